@@ -7,12 +7,19 @@ require (
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/gobuffalo/packd v0.3.0
 	github.com/gobuffalo/packr v1.30.1
+	github.com/golang/protobuf v1.3.3
 	github.com/google/uuid v1.1.1
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c
+	github.com/pion/ice v0.7.12
 	github.com/pion/logging v0.2.2
 	github.com/pion/rtcp v1.2.1
+	github.com/pion/rtp v1.4.0
+	github.com/pion/turn/v2 v2.0.3
 	github.com/pion/webrtc/v2 v2.2.5
+	github.com/prometheus/client_golang v1.5.1
 	github.com/stretchr/testify v1.5.1
+	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 	gopkg.in/yaml.v2 v2.2.8
 	nhooyr.io/websocket v1.8.4
 )
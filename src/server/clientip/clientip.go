@@ -0,0 +1,77 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver extracts the IP address of the client that sent a request. The
+// X-Forwarded-For and X-Real-IP headers are only used when the request was
+// received from a trusted proxy, because clients can set them to anything.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// Creates a resolver trusting proxies with addresses in the networks. The
+// CIDRs must have been validated, invalid ones are ignored. Headers are never
+// used when there are no trusted proxies.
+func NewResolver(trustedCIDRs []string) *Resolver {
+	trusted := make([]*net.IPNet, 0, len(trustedCIDRs))
+	for _, cidr := range trustedCIDRs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			trusted = append(trusted, ipNet)
+		}
+	}
+	return &Resolver{
+		trusted: trusted,
+	}
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, ipNet := range r.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client. The hops in X-Forwarded-For
+// are walked from the nearest one, and the first address that does not belong
+// to a trusted proxy is returned. X-Real-IP is only used when there is no
+// X-Forwarded-For header. The address of the immediate peer is returned when
+// it is not trusted.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	remoteAddr := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+
+	ip := net.ParseIP(remoteAddr)
+	if ip == nil || !r.isTrusted(ip) {
+		return remoteAddr
+	}
+
+	if forwardedFor := req.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				// the proxies in front of the last valid hop are unknown
+				break
+			}
+			ip = hop
+			if !r.isTrusted(hop) {
+				break
+			}
+		}
+		return ip.String()
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,80 @@
+package clientip_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/clientip"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver_ClientIP(t *testing.T) {
+	resolver := clientip.NewResolver([]string{"10.0.0.0/8", "fd00::/8"})
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		clientIP   string
+	}{
+		{"no proxy", "1.2.3.4:5678", nil, "1.2.3.4"},
+		{"untrusted peer spoofing headers", "1.2.3.4:5678", map[string]string{
+			"X-Forwarded-For": "5.6.7.8",
+			"X-Real-IP":       "5.6.7.8",
+		}, "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:5678", map[string]string{
+			"X-Forwarded-For": "1.2.3.4",
+		}, "1.2.3.4"},
+		{"trusted proxy chain", "10.0.0.1:5678", map[string]string{
+			"X-Forwarded-For": "1.2.3.4, 10.0.0.2, 10.0.0.3",
+		}, "1.2.3.4"},
+		{"spoofed hops before untrusted hop", "10.0.0.1:5678", map[string]string{
+			"X-Forwarded-For": "5.6.7.8, 10.0.0.9, 1.2.3.4",
+		}, "1.2.3.4"},
+		{"only trusted hops", "10.0.0.1:5678", map[string]string{
+			"X-Forwarded-For": "10.0.0.3, 10.0.0.2",
+		}, "10.0.0.3"},
+		{"invalid hop", "10.0.0.1:5678", map[string]string{
+			"X-Forwarded-For": "1.2.3.4, invalid, 10.0.0.2",
+		}, "10.0.0.2"},
+		{"forwarded for takes precedence", "10.0.0.1:5678", map[string]string{
+			"X-Forwarded-For": "1.2.3.4",
+			"X-Real-IP":       "5.6.7.8",
+		}, "1.2.3.4"},
+		{"real ip", "10.0.0.1:5678", map[string]string{
+			"X-Real-IP": "1.2.3.4",
+		}, "1.2.3.4"},
+		{"invalid real ip", "10.0.0.1:5678", map[string]string{
+			"X-Real-IP": "invalid",
+		}, "10.0.0.1"},
+		{"ipv6", "[fd00::1]:5678", map[string]string{
+			"X-Forwarded-For": "2001:db8::1",
+		}, "2001:db8::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+			assert.Equal(t, tc.clientIP, resolver.ClientIP(r))
+		})
+	}
+}
+
+func TestResolver_ClientIP_noTrustedProxies(t *testing.T) {
+	resolver := clientip.NewResolver(nil)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "10.0.0.1", resolver.ClientIP(r))
+}
+
+func TestResolver_ClientIP_multipleHeaders(t *testing.T) {
+	resolver := clientip.NewResolver([]string{"10.0.0.0/8"})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+	r.Header.Add("X-Forwarded-For", "5.6.7.8")
+	r.Header.Add("X-Forwarded-For", "1.2.3.4, 10.0.0.2")
+	assert.Equal(t, "1.2.3.4", resolver.ClientIP(r))
+}
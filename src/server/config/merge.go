@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Merge deep-merges src into dst using the following rules:
+//
+//   - structs are merged field by field,
+//   - slices from src are appended to slices in dst,
+//   - maps are merged key by key, with values from src taking precedence,
+//   - all other values from src override values in dst, unless they are zero
+//     values, which are considered unset.
+//
+// Since zero values are considered unset, Merge cannot reset a value to its
+// zero value (for example, set bind_port back to 0). ReadFiles does not have
+// this limitation because it knows which keys were set in each file.
+func Merge(dst *Config, src Config) {
+	merge(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src), nil)
+}
+
+// mergeDocument is like Merge, but uses the YAML document src was decoded
+// from to decide which fields were set. Fields present in the document
+// override values in dst even when they are zero values, and fields missing
+// from it are left untouched.
+func mergeDocument(dst *Config, src Config, doc map[interface{}]interface{}) {
+	if doc == nil {
+		doc = map[interface{}]interface{}{}
+	}
+	merge(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src), doc)
+}
+
+func merge(dst reflect.Value, src reflect.Value, doc map[interface{}]interface{}) {
+	switch src.Kind() {
+	case reflect.Struct:
+		for i := 0; i < src.NumField(); i++ {
+			if doc == nil {
+				merge(dst.Field(i), src.Field(i), nil)
+				continue
+			}
+			value, ok := doc[yamlKey(src.Type().Field(i))]
+			if !ok {
+				continue
+			}
+			switch src.Field(i).Kind() {
+			case reflect.Struct:
+				if sub, ok := value.(map[interface{}]interface{}); ok {
+					merge(dst.Field(i), src.Field(i), sub)
+					continue
+				}
+			case reflect.Slice, reflect.Map:
+				merge(dst.Field(i), src.Field(i), nil)
+				continue
+			}
+			dst.Field(i).Set(src.Field(i))
+		}
+	case reflect.Slice:
+		if src.Len() > 0 {
+			dst.Set(reflect.AppendSlice(dst, src))
+		}
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(src.Type()))
+		}
+		iter := src.MapRange()
+		for iter.Next() {
+			dst.SetMapIndex(iter.Key(), iter.Value())
+		}
+	default:
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// yamlKey returns the key yaml.v2 uses for field.
+func yamlKey(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_scalar(t *testing.T) {
+	dst := Config{BaseURL: "/a", BindHost: "127.0.0.1"}
+	Merge(&dst, Config{BaseURL: "/b"})
+	assert.Equal(t, "/b", dst.BaseURL)
+	assert.Equal(t, "127.0.0.1", dst.BindHost)
+}
+
+func TestMerge_slice(t *testing.T) {
+	dst := Config{ICEServers: []ICEServer{{URLs: []string{"stun:a"}}}}
+	Merge(&dst, Config{ICEServers: []ICEServer{{URLs: []string{"stun:b"}}}})
+	assert.Equal(t, []ICEServer{
+		{URLs: []string{"stun:a"}},
+		{URLs: []string{"stun:b"}},
+	}, dst.ICEServers)
+
+	Merge(&dst, Config{})
+	assert.Equal(t, 2, len(dst.ICEServers))
+}
+
+func TestMerge_map(t *testing.T) {
+	dst := map[string]string{"a": "1", "b": "2"}
+	src := map[string]string{"b": "3", "c": "4"}
+	merge(reflect.ValueOf(&dst).Elem(), reflect.ValueOf(src), nil)
+	assert.Equal(t, map[string]string{"a": "1", "b": "3", "c": "4"}, dst)
+
+	var nilDst map[string]string
+	merge(reflect.ValueOf(&nilDst).Elem(), reflect.ValueOf(src), nil)
+	assert.Equal(t, src, nilDst)
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+var namespaceRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Returns the store config of a namespace. Redis keys and channels of the
+// namespace are prefixed with the Redis prefix followed by the namespace so
+// they do not collide with the ones of other namespaces.
+func NamespaceStoreConfig(c StoreConfig, namespace string) StoreConfig {
+	c.Redis.Prefix = c.Redis.Prefix + ":" + namespace
+	return c
+}
+
+// Returns the room ICE servers of a namespace keyed by room name. Rooms of a
+// namespace are listed in room_ice_servers as <namespace>/<room>, and rooms
+// served under /ws, when namespace is empty, by their name only.
+func NamespaceRoomICEServers(rooms map[string][]ICEServer, namespace string) map[string][]ICEServer {
+	result := map[string][]ICEServer{}
+	for key, iceServers := range rooms {
+		keyNamespace, room := splitNamespace(key)
+		if keyNamespace == namespace {
+			result[room] = iceServers
+		}
+	}
+	return result
+}
+
+// Splits a room_ice_servers key into namespace and room name.
+func splitNamespace(key string) (namespace string, room string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
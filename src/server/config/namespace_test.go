@@ -0,0 +1,37 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceStoreConfig(t *testing.T) {
+	var c config.StoreConfig
+	c.Type = config.StoreTypeRedis
+	c.Redis.Prefix = "peercalls"
+
+	nc := config.NamespaceStoreConfig(c, "app1")
+	assert.Equal(t, "peercalls:app1", nc.Redis.Prefix)
+	assert.Equal(t, config.StoreTypeRedis, nc.Type)
+	assert.Equal(t, "peercalls", c.Redis.Prefix)
+}
+
+func TestNamespaceRoomICEServers(t *testing.T) {
+	stun := []config.ICEServer{{URLs: []string{"stun:stun.example.com"}}}
+	turn := []config.ICEServer{{URLs: []string{"turn:turn.example.com"}}}
+	rooms := map[string][]config.ICEServer{
+		"room1":      stun,
+		"app1/room1": turn,
+		"app2/room2": stun,
+	}
+
+	assert.Equal(t, map[string][]config.ICEServer{
+		"room1": stun,
+	}, config.NamespaceRoomICEServers(rooms, ""))
+	assert.Equal(t, map[string][]config.ICEServer{
+		"room1": turn,
+	}, config.NamespaceRoomICEServers(rooms, "app1"))
+	assert.Equal(t, map[string][]config.ICEServer{}, config.NamespaceRoomICEServers(rooms, "app3"))
+}
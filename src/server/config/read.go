@@ -1,50 +1,198 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
-func ReadFile(filename string, c *Config) (err error) {
-	f, err := os.Open(filename)
+type ReadParams struct {
+	// Number of times a config file is read again after failing with a
+	// transient error, e.g. on network filesystems. Missing files are never
+	// retried. Disabled when zero.
+	Retries int
+	// Delay between attempts to read a config file.
+	RetryDelay time.Duration
+}
+
+// Opens config files, replaced in tests to simulate transient errors.
+var openFile = func(filename string) (io.ReadCloser, error) {
+	return os.Open(filename)
+}
+
+func ReadFile(filename string, c *Config) error {
+	return ReadFileWithParams(filename, c, ReadParams{})
+}
+
+func ReadFileWithParams(filename string, c *Config, params ReadParams) error {
+	data, err := readFile(filename, params)
 	if err != nil {
-		return fmt.Errorf("Error opening YAML file: %w", err)
+		return err
 	}
-	err = ReadYAML(f, c)
-	f.Close()
-	return err
+	return ReadYAML(bytes.NewReader(data), c)
+}
+
+// Reads the file again when opening or reading it fails with a transient
+// error. The whole file is read again since a partial read cannot be resumed
+// safely after the file was reopened.
+func readFile(filename string, params ReadParams) ([]byte, error) {
+	data, err := readFileOnce(filename)
+	for attempt := 0; err != nil && isTransientError(err) && attempt < params.Retries; attempt++ {
+		time.Sleep(params.RetryDelay)
+		data, err = readFileOnce(filename)
+	}
+	return data, err
+}
+
+func readFileOnce(filename string) ([]byte, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening YAML file: %w", err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading YAML file: %w", err)
+	}
+	return data, nil
 }
 
-func ReadFiles(filenames []string, c *Config) (err error) {
+// Returns true for errors that might not happen when a file is read again.
+func isTransientError(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EAGAIN,
+		syscall.EBUSY,
+		syscall.EINTR,
+		syscall.EIO,
+		syscall.ESTALE,
+		syscall.ETIMEDOUT,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads all files in order and deep-merges them into c. See Merge for the
+// merge rules, except that a key set in a later file always overrides the
+// value from earlier files, even when it is false or zero.
+func ReadFiles(filenames []string, c *Config) error {
+	return ReadFilesWithParams(filenames, c, ReadParams{})
+}
+
+func ReadFilesWithParams(filenames []string, c *Config, params ReadParams) (err error) {
 	for _, filename := range filenames {
-		err = ReadFile(filename, c)
+		var data []byte
+		data, err = readFile(filename, params)
+		if err != nil {
+			break
+		}
+		var fileConfig Config
+		err = ReadYAML(bytes.NewReader(data), &fileConfig)
 		if err != nil {
 			break
 		}
+		var doc map[interface{}]interface{}
+		// Cannot fail since the same data was decoded into a Config above.
+		_ = yaml.Unmarshal(data, &doc)
+		mergeDocument(c, fileConfig, doc)
 	}
 	return err
 }
 
+// Sets default values for all fields that have not been set.
 func Init(c *Config) {
-	c.BindPort = 3000
-	c.Network.Type = NetworkTypeMesh
-	c.Store.Type = StoreTypeMemory
-	c.ICEServers = []ICEServer{{
-		URLs: []string{"stun:stun.l.google.com:19302"},
-	}, {
-		URLs: []string{"stun:global.stun.twilio.com:3478?transport=udp"},
-	}}
+	if c.BindHost == "" {
+		c.BindHost = BindHostDualStack
+	}
+	if c.BindPort == 0 {
+		c.BindPort = 3000
+	}
+	if c.TURNPolicy == "" {
+		c.TURNPolicy = TURNPolicyWarn
+	}
+	if c.Admin.BindHost == "" {
+		c.Admin.BindHost = "127.0.0.1"
+	}
+	if c.Network.Type == "" {
+		c.Network.Type = NetworkTypeMesh
+	}
+	if len(c.API.AllowedMessageTypes) == 0 {
+		c.API.AllowedMessageTypes = []string{"ws_notice", "ws_chat"}
+	}
+	if c.WS.RateLimitBurst == 0 {
+		c.WS.RateLimitBurst = c.WS.RateLimit
+	}
+	if c.Store.Redis.Serializer == "" {
+		c.Store.Redis.Serializer = SerializerTypeJSON
+	}
+	if c.Network.Serializer == "" {
+		c.Network.Serializer = SerializerTypeJSON
+	}
+	if c.WS.MaxFrameRate == 0 {
+		c.WS.MaxFrameRate = 1000
+	}
+	if c.WS.ClientIDMode == "" {
+		c.WS.ClientIDMode = ClientIDModeClient
+	}
+	if c.Network.SFU.IPFamilies == "" {
+		c.Network.SFU.IPFamilies = IPFamilyBoth
+	}
+	if c.Store.Type == "" {
+		c.Store.Type = StoreTypeMemory
+	}
+	if c.Store.RoomPasswordTTL == 0 {
+		c.Store.RoomPasswordTTL = 24 * time.Hour
+	}
+	if len(c.ICEServers) == 0 {
+		c.ICEServers = []ICEServer{{
+			URLs: []string{"stun:stun.l.google.com:19302"},
+		}, {
+			URLs: []string{"stun:global.stun.twilio.com:3478?transport=udp"},
+		}}
+	}
 }
 
+// Returns the files listed in the comma-separated environment variable name,
+// skipping empty entries.
+func envFilenames(name string) (filenames []string) {
+	for _, filename := range strings.Split(os.Getenv(name), ",") {
+		if filename = strings.TrimSpace(filename); filename != "" {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames
+}
+
+// Reads the files listed in PEERCALLS_CONFIG followed by filenames, so that
+// values in filenames take precedence over the ones in PEERCALLS_CONFIG.
+// Environment variables take precedence over all files.
 func Read(filenames []string) (c Config, err error) {
+	return ReadWithParams(filenames, ReadParams{})
+}
+
+// Same as Read, but retries files that fail with transient errors.
+func ReadWithParams(filenames []string, params ReadParams) (c Config, err error) {
+	filenames = append(envFilenames("PEERCALLS_CONFIG"), filenames...)
+	// Set before reading the files because zero disables retries, so it
+	// cannot be defaulted in Init.
+	c.Webhooks.MaxRetries = DefaultWebhooksMaxRetries
+	err = ReadFilesWithParams(filenames, &c, params)
 	Init(&c)
-	err = ReadFiles(filenames, &c)
 	ReadEnv("PEERCALLS_", &c)
+	if err == nil {
+		err = Validate(c)
+	}
 	return c, err
 }
 
@@ -60,16 +208,104 @@ func ReadEnv(prefix string, c *Config) {
 	setEnvString(&c.BaseURL, prefix+"BASE_URL")
 	setEnvString(&c.BindHost, prefix+"BIND_HOST")
 	setEnvInt(&c.BindPort, prefix+"BIND_PORT")
+	setEnvString(&c.BindSocket, prefix+"BIND_SOCKET")
 	setEnvString(&c.TLS.Cert, prefix+"TLS_CERT")
 	setEnvString(&c.TLS.Key, prefix+"TLS_KEY")
+	setEnvString(&c.TLS.MinVersion, prefix+"TLS_MIN_VERSION")
+	setEnvStringArray(&c.TLS.CipherSuites, prefix+"TLS_CIPHER_SUITES")
 
 	setEnvStoreType(&c.Store.Type, prefix+"STORE_TYPE")
 	setEnvString(&c.Store.Redis.Host, prefix+"STORE_REDIS_HOST")
 	setEnvInt(&c.Store.Redis.Port, prefix+"STORE_REDIS_PORT")
 	setEnvString(&c.Store.Redis.Prefix, prefix+"STORE_REDIS_PREFIX")
+	setEnvString((*string)(&c.Store.Redis.Serializer), prefix+"STORE_REDIS_SERIALIZER")
+	setEnvBool(&c.Store.Redis.PersistRoomMetadata, prefix+"STORE_REDIS_PERSIST_ROOM_METADATA")
+	setEnvDuration(&c.Store.Redis.PublishTimeout, prefix+"STORE_REDIS_PUBLISH_TIMEOUT")
+	setEnvDuration(&c.Store.Redis.PingInterval, prefix+"STORE_REDIS_PING_INTERVAL")
+	setEnvInt(&c.Store.ChatHistorySize, prefix+"STORE_CHAT_HISTORY_SIZE")
+	setEnvDuration(&c.Store.PresenceDebounce, prefix+"STORE_PRESENCE_DEBOUNCE")
+	setEnvDuration(&c.Store.RoomPasswordTTL, prefix+"STORE_ROOM_PASSWORD_TTL")
 
 	setEnvNetworkType(&c.Network.Type, prefix+"NETWORK_TYPE")
+	setEnvInt(&c.Network.AutoSFUThreshold, prefix+"NETWORK_AUTO_SFU_THRESHOLD")
+	setEnvBool(&c.Network.AudioOnly, prefix+"NETWORK_AUDIO_ONLY")
+	setEnvString((*string)(&c.Network.Serializer), prefix+"NETWORK_SERIALIZER")
 	setEnvStringArray(&c.Network.SFU.Interfaces, prefix+"NETWORK_SFU_INTERFACES")
+	setEnvString((*string)(&c.Network.SFU.IPFamilies), prefix+"NETWORK_SFU_IP_FAMILIES")
+	setEnvDuration(&c.Network.SFU.Keepalive, prefix+"NETWORK_SFU_KEEPALIVE")
+	setEnvInt(&c.Network.SFU.MaxSDPSize, prefix+"NETWORK_SFU_MAX_SDP_SIZE")
+	setEnvStringArray(&c.Network.SFU.CandidateAllowCIDRs, prefix+"NETWORK_SFU_CANDIDATE_ALLOW_CIDRS")
+	setEnvStringArray(&c.Network.SFU.CandidateDenyCIDRs, prefix+"NETWORK_SFU_CANDIDATE_DENY_CIDRS")
+	setEnvDuration(&c.Network.SFU.DisconnectGracePeriod, prefix+"NETWORK_SFU_DISCONNECT_GRACE_PERIOD")
+	setEnvInt(&c.Network.SFU.MaxNegotiations, prefix+"NETWORK_SFU_MAX_NEGOTIATIONS")
+	setEnvDuration(&c.Network.SFU.MaxNegotiationsWindow, prefix+"NETWORK_SFU_MAX_NEGOTIATIONS_WINDOW")
+	setEnvDuration(&c.Network.SFU.NegotiationTimeout, prefix+"NETWORK_SFU_NEGOTIATION_TIMEOUT")
+	setEnvInt(&c.Network.SFU.MaxConcurrentNegotiations, prefix+"NETWORK_SFU_MAX_CONCURRENT_NEGOTIATIONS")
+	setEnvString(&c.Network.SFU.DTLSCert, prefix+"NETWORK_SFU_DTLS_CERT")
+	setEnvString(&c.Network.SFU.DTLSKey, prefix+"NETWORK_SFU_DTLS_KEY")
+	setEnvBool(&c.Network.SFU.Trickle, prefix+"NETWORK_SFU_TRICKLE")
+	setEnvDuration(&c.Network.SFU.KeyframeInterval, prefix+"NETWORK_SFU_KEYFRAME_INTERVAL")
+	setEnvSlice(&c.Network.SFU.Interceptors, prefix+"NETWORK_SFU_INTERCEPTORS")
+	setEnvInt(&c.Network.SFU.MaxSignalDepth, prefix+"NETWORK_SFU_MAX_SIGNAL_DEPTH")
+	setEnvInt(&c.Network.SFU.MaxSignalElements, prefix+"NETWORK_SFU_MAX_SIGNAL_ELEMENTS")
+	setEnvString((*string)(&c.Network.SFU.InitialTransceiverDirection), prefix+"NETWORK_SFU_INITIAL_TRANSCEIVER_DIRECTION")
+	setEnvString((*string)(&c.Network.SFU.RequestedTransceiverDirection), prefix+"NETWORK_SFU_REQUESTED_TRANSCEIVER_DIRECTION")
+	setEnvDuration(&c.Network.SFU.QualityInterval, prefix+"NETWORK_SFU_QUALITY_INTERVAL")
+	setEnvBool(&c.Network.SFU.LogSDP, prefix+"NETWORK_SFU_LOG_SDP")
+	setEnvBool(&c.Network.SFU.LogCandidatePair, prefix+"NETWORK_SFU_LOG_CANDIDATE_PAIR")
+	setEnvInt(&c.Network.SFU.LastN, prefix+"NETWORK_SFU_LAST_N")
+	setEnvInt(&c.Network.SFU.MaxCandidates, prefix+"NETWORK_SFU_MAX_CANDIDATES")
+	setEnvInt(&c.Network.SFU.ReorderDepth, prefix+"NETWORK_SFU_REORDER_DEPTH")
+	setEnvDuration(&c.Network.SFU.ReorderTimeout, prefix+"NETWORK_SFU_REORDER_TIMEOUT")
+	setEnvInt(&c.Network.SFU.MaxQueuedTransceivers, prefix+"NETWORK_SFU_MAX_QUEUED_TRANSCEIVERS")
+	setEnvString((*string)(&c.Network.SFU.QueuedTransceiversOverflowPolicy), prefix+"NETWORK_SFU_QUEUED_TRANSCEIVERS_OVERFLOW_POLICY")
+	setEnvInt(&c.Network.SFU.DSCP, prefix+"NETWORK_SFU_DSCP")
+
+	setEnvString(&c.WS.WelcomeMessage, prefix+"WS_WELCOME_MESSAGE")
+	setEnvInt(&c.WS.RateLimit, prefix+"WS_RATE_LIMIT")
+	setEnvInt(&c.WS.RateLimitBurst, prefix+"WS_RATE_LIMIT_BURST")
+	setEnvInt(&c.WS.RateLimitMaxDropped, prefix+"WS_RATE_LIMIT_MAX_DROPPED")
+	setEnvString((*string)(&c.WS.Compression), prefix+"WS_COMPRESSION")
+	setEnvInt(&c.WS.CompressionThreshold, prefix+"WS_COMPRESSION_THRESHOLD")
+	setEnvString((*string)(&c.WS.ClientIDMode), prefix+"WS_CLIENT_ID_MODE")
+	setEnvInt(&c.WS.SendQueueSize, prefix+"WS_SEND_QUEUE_SIZE")
+	setEnvString((*string)(&c.WS.SendQueueOverflowPolicy), prefix+"WS_SEND_QUEUE_OVERFLOW_POLICY")
+	setEnvString(&c.WS.RoomNamePattern, prefix+"WS_ROOM_NAME_PATTERN")
+	setEnvDuration(&c.WS.ReconnectWindow, prefix+"WS_RECONNECT_WINDOW")
+	setEnvStringArray(&c.WS.TrustedProxies, prefix+"WS_TRUSTED_PROXIES")
+	setEnvBool(&c.WS.LogPayloads, prefix+"WS_LOG_PAYLOADS")
+	setEnvStringArray(&c.WS.LogPayloadsRedact, prefix+"WS_LOG_PAYLOADS_REDACT")
+	setEnvInt(&c.WS.MaxFrameRate, prefix+"WS_MAX_FRAME_RATE")
+	setEnvInt(&c.WS.ReadLimit, prefix+"WS_READ_LIMIT")
+	setEnvString((*string)(&c.WS.UnknownMessagePolicy), prefix+"WS_UNKNOWN_MESSAGE_POLICY")
+	setEnvBool(&c.WS.RoomPasswords, prefix+"WS_ROOM_PASSWORDS")
+	setEnvBool(&c.WS.CorrelationIDs, prefix+"WS_CORRELATION_IDS")
+	setEnvInt(&c.WS.MetadataMaxLength, prefix+"WS_METADATA_MAX_LENGTH")
+	setEnvString(&c.WS.MetadataPattern, prefix+"WS_METADATA_PATTERN")
+	setEnvBool(&c.WS.RequireMetadata, prefix+"WS_REQUIRE_METADATA")
+
+	setEnvInt(&c.Rooms.Max, prefix+"ROOMS_MAX")
+	setEnvInt(&c.Rooms.MaxVideoPublishers, prefix+"ROOMS_MAX_VIDEO_PUBLISHERS")
+	setEnvInt(&c.Rooms.MaxTransceivers, prefix+"ROOMS_MAX_TRANSCEIVERS")
+	setEnvDuration(&c.Rooms.MaxDuration, prefix+"ROOMS_MAX_DURATION")
+	setEnvInt(&c.Rooms.ClientQuota, prefix+"ROOMS_CLIENT_QUOTA")
+	setEnvDuration(&c.Rooms.ClientQuotaWindow, prefix+"ROOMS_CLIENT_QUOTA_WINDOW")
+
+	setEnvString(&c.API.Token, prefix+"API_TOKEN")
+	setEnvStringArray(&c.API.AllowedMessageTypes, prefix+"API_ALLOWED_MESSAGE_TYPES")
+
+	setEnvStringArray(&c.Webhooks.URLs, prefix+"WEBHOOKS_URLS")
+	setEnvString(&c.Webhooks.Secret, prefix+"WEBHOOKS_SECRET")
+	setEnvInt(&c.Webhooks.MaxRetries, prefix+"WEBHOOKS_MAX_RETRIES")
+	setEnvDuration(&c.Webhooks.RetryDelay, prefix+"WEBHOOKS_RETRY_DELAY")
+	setEnvDuration(&c.Webhooks.Timeout, prefix+"WEBHOOKS_TIMEOUT")
+
+	setEnvString((*string)(&c.TURNPolicy), prefix+"TURN_POLICY")
+	setEnvBool(&c.TURNSelfTest, prefix+"TURN_SELF_TEST")
+	setEnvStringArray(&c.Namespaces, prefix+"NAMESPACES")
+
+	setEnvString(&c.Admin.BindHost, prefix+"ADMIN_BIND_HOST")
+	setEnvInt(&c.Admin.BindPort, prefix+"ADMIN_BIND_PORT")
 
 	var ice ICEServer
 	setEnvSlice(&ice.URLs, prefix+"ICE_SERVER_URLS")
@@ -90,6 +326,8 @@ func setEnvSlice(dest *[]string, name string) {
 	}
 }
 
+// Also used for string types with a fixed set of values, such as
+// Compression. Unknown values are kept so that Validate can report them.
 func setEnvString(dest *string, name string) {
 	value := os.Getenv(name)
 	if value != "" {
@@ -104,6 +342,20 @@ func setEnvInt(dest *int, name string) {
 	}
 }
 
+func setEnvBool(dest *bool, name string) {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err == nil {
+		*dest = value
+	}
+}
+
+func setEnvDuration(dest *time.Duration, name string) {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err == nil {
+		*dest = value
+	}
+}
+
 func setEnvAuthType(authType *AuthType, name string) {
 	value := os.Getenv(name)
 	switch AuthType(value) {
@@ -1,9 +1,12 @@
 package config_test
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/stretchr/testify/assert"
@@ -16,8 +19,45 @@ func TestRead(t *testing.T) {
 	assert.Equal(t, 2, len(c.ICEServers))
 	assert.Equal(t, []string{"stun:stun.l.google.com:19302"}, c.ICEServers[0].URLs)
 	assert.Equal(t, []string{"stun:global.stun.twilio.com:3478?transport=udp"}, c.ICEServers[1].URLs)
+	assert.Equal(t, config.BindHostDualStack, c.BindHost)
+	assert.Equal(t, 3000, c.BindPort)
+	assert.Equal(t, "127.0.0.1", c.Admin.BindHost)
+	assert.Equal(t, config.TURNPolicyWarn, c.TURNPolicy)
+	assert.Equal(t, 0, c.Admin.BindPort)
 	assert.Equal(t, config.NetworkTypeMesh, c.Network.Type)
 	assert.Equal(t, config.StoreTypeMemory, c.Store.Type)
+	assert.Equal(t, config.IPFamilyBoth, c.Network.SFU.IPFamilies)
+	assert.Equal(t, config.ClientIDModeClient, c.WS.ClientIDMode)
+	assert.Equal(t, config.SerializerTypeJSON, c.Store.Redis.Serializer)
+	assert.Equal(t, config.SerializerTypeJSON, c.Network.Serializer)
+	assert.Equal(t, 1000, c.WS.MaxFrameRate)
+	assert.Equal(t, []string{"ws_notice", "ws_chat"}, c.API.AllowedMessageTypes)
+	assert.Equal(t, config.DefaultWebhooksMaxRetries, c.Webhooks.MaxRetries)
+	assert.Equal(t, 24*time.Hour, c.Store.RoomPasswordTTL)
+}
+
+func TestRead_webhooksNoRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-calls-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`
+webhooks:
+  max_retries: 0
+`), 0600))
+
+	c, err := config.Read([]string{filename})
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Webhooks.MaxRetries)
+}
+
+func TestRead_invalidIPFamilies(t *testing.T) {
+	os.Setenv("PEERCALLS_NETWORK_SFU_IP_FAMILIES", "ipv5")
+	defer os.Unsetenv("PEERCALLS_NETWORK_SFU_IP_FAMILIES")
+	_, err := config.Read([]string{})
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.ip_families", err.Error())
 }
 
 func TestReadFiles(t *testing.T) {
@@ -37,7 +77,103 @@ func TestReadFiles(t *testing.T) {
 	assert.Equal(t, config.AuthTypeSecret, ice.AuthType)
 	assert.Equal(t, "test_user", ice.AuthSecret.Username)
 	assert.Equal(t, "test_secret", ice.AuthSecret.Secret)
+	assert.Equal(t, 1, len(c.RoomICEServers["private"]))
+	assert.Equal(t, []string{"turn:private.example.com"}, c.RoomICEServers["private"][0].URLs)
+	assert.Equal(t, "private_user", c.RoomICEServers["private"][0].AuthSecret.Username)
 	assert.Equal(t, []string(nil), c.Network.SFU.Interfaces)
+	assert.Equal(t, 15*time.Second, c.Network.SFU.Keepalive)
+	maxRetransmits := uint16(0)
+	assert.Equal(t, []config.DataChannelConfig{
+		{Label: "data"},
+		{Label: "telemetry", Unordered: true, MaxRetransmits: &maxRetransmits},
+	}, c.Network.SFU.DataChannels)
+}
+
+func TestReadFiles_merge(t *testing.T) {
+	var c config.Config
+	err := config.ReadFiles([]string{"config_example.yml", "config_overlay_example.yml"}, &c)
+	assert.Nil(t, err, "Error should be nil")
+	assert.Equal(t, "/test", c.BaseURL)
+	assert.Equal(t, 3005, c.BindPort)
+	assert.Equal(t, config.StoreTypeRedis, c.Store.Type)
+	assert.Equal(t, "localhost", c.Store.Redis.Host)
+	assert.Equal(t, 6380, c.Store.Redis.Port)
+	assert.Equal(t, "peercalls", c.Store.Redis.Prefix)
+	assert.Equal(t, 2, len(c.ICEServers))
+	assert.Equal(t, []string{"stun:stun.l.google.com:19302"}, c.ICEServers[0].URLs)
+	assert.Equal(t, config.AuthTypeSecret, c.ICEServers[0].AuthType)
+	assert.Equal(t, []string{"turn:turn.example.com"}, c.ICEServers[1].URLs)
+	assert.Equal(t, config.AuthTypeSecret, c.ICEServers[1].AuthType)
+}
+
+func TestReadFiles_mergeZeroValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-calls-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.yml")
+	overlay := filepath.Join(dir, "overlay.yml")
+	require.NoError(t, ioutil.WriteFile(base, []byte(`
+bind_port: 3005
+turn_self_test: true
+ws:
+  require_metadata: true
+`), 0600))
+	require.NoError(t, ioutil.WriteFile(overlay, []byte(`
+bind_port: 0
+ws:
+  require_metadata: false
+`), 0600))
+
+	var c config.Config
+	err = config.ReadFiles([]string{base, overlay}, &c)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.BindPort)
+	assert.False(t, c.WS.RequireMetadata)
+	assert.True(t, c.TURNSelfTest, "keys missing from overlay should be kept")
+}
+
+func TestRead_filesReplaceDefaultICEServers(t *testing.T) {
+	c, err := config.Read([]string{"config_overlay_example.yml"})
+	assert.Nil(t, err, "error reading config")
+	assert.Equal(t, 1, len(c.ICEServers))
+	assert.Equal(t, []string{"turn:turn.example.com"}, c.ICEServers[0].URLs)
+	assert.Equal(t, 3005, c.BindPort)
+	assert.Equal(t, config.NetworkTypeMesh, c.Network.Type)
+}
+
+func TestRead_envFiles(t *testing.T) {
+	os.Setenv("PEERCALLS_CONFIG", " config_example.yml,,config_overlay_example.yml ")
+	defer os.Unsetenv("PEERCALLS_CONFIG")
+	c, err := config.Read([]string{})
+	assert.Nil(t, err, "error reading config")
+	assert.Equal(t, "/test", c.BaseURL)
+	assert.Equal(t, 3005, c.BindPort)
+	assert.Equal(t, 6380, c.Store.Redis.Port)
+}
+
+func TestRead_envFilesAndArgs(t *testing.T) {
+	// files passed as arguments take precedence
+	os.Setenv("PEERCALLS_CONFIG", "config_overlay_example.yml")
+	defer os.Unsetenv("PEERCALLS_CONFIG")
+	c, err := config.Read([]string{"config_example.yml"})
+	assert.Nil(t, err, "error reading config")
+	assert.Equal(t, "/test", c.BaseURL)
+	assert.Equal(t, 3005, c.BindPort)
+	assert.Equal(t, 6379, c.Store.Redis.Port)
+
+	os.Setenv("PEERCALLS_CONFIG", "config_example.yml")
+	c, err = config.Read([]string{"config_overlay_example.yml"})
+	assert.Nil(t, err, "error reading config")
+	assert.Equal(t, 6380, c.Store.Redis.Port)
+}
+
+func TestRead_envFilesError(t *testing.T) {
+	os.Setenv("PEERCALLS_CONFIG", "config_missing.yml")
+	defer os.Unsetenv("PEERCALLS_CONFIG")
+	_, err := config.Read([]string{"config_example.yml"})
+	require.NotNil(t, err, "error should be defined")
+	assert.Regexp(t, "no such file", err.Error())
 }
 
 func TestReadFiles_error(t *testing.T) {
@@ -60,27 +196,119 @@ func TestReadFromEnv(t *testing.T) {
 	prefix := "PEERCALLSTEST_"
 	defer os.Unsetenv(prefix)
 	os.Setenv(prefix+"BASE_URL", "/test")
+	os.Setenv(prefix+"BIND_SOCKET", "/run/peer-calls.sock")
 	os.Setenv(prefix+"TLS_CERT", "test.pem")
 	os.Setenv(prefix+"TLS_KEY", "test.key")
+	os.Setenv(prefix+"TLS_MIN_VERSION", "1.3")
+	os.Setenv(prefix+"TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
 	os.Setenv(prefix+"STORE_TYPE", "redis")
 	os.Setenv(prefix+"STORE_REDIS_HOST", "localhost")
+	os.Setenv(prefix+"STORE_REDIS_SERIALIZER", "protobuf")
+	os.Setenv(prefix+"STORE_REDIS_PERSIST_ROOM_METADATA", "true")
+	os.Setenv(prefix+"STORE_REDIS_PUBLISH_TIMEOUT", "500ms")
+	os.Setenv(prefix+"STORE_REDIS_PING_INTERVAL", "30s")
 	os.Setenv(prefix+"STORE_REDIS_PORT", "6379")
 	os.Setenv(prefix+"STORE_REDIS_PREFIX", "peercalls")
+	os.Setenv(prefix+"STORE_CHAT_HISTORY_SIZE", "20")
+	os.Setenv(prefix+"STORE_PRESENCE_DEBOUNCE", "250ms")
+	os.Setenv(prefix+"STORE_ROOM_PASSWORD_TTL", "1h")
 	os.Setenv(prefix+"ICE_SERVER_URLS", "stun:stun.l.google.com:19302,stuns:stun.l.google.com:19302")
 	os.Setenv(prefix+"ICE_SERVER_AUTH_TYPE", "secret")
 	os.Setenv(prefix+"ICE_SERVER_USERNAME", "test_user")
 	os.Setenv(prefix+"ICE_SERVER_SECRET", "test_secret")
 	os.Setenv(prefix+"NETWORK_TYPE", "sfu")
 	os.Setenv(prefix+"NETWORK_SFU_INTERFACES", "a,b")
+	os.Setenv(prefix+"NETWORK_SFU_IP_FAMILIES", "ipv4")
+	os.Setenv(prefix+"NETWORK_SFU_KEEPALIVE", "5s")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_SDP_SIZE", "4096")
+	os.Setenv(prefix+"NETWORK_SFU_CANDIDATE_ALLOW_CIDRS", "10.0.0.0/8,192.168.0.0/16")
+	os.Setenv(prefix+"NETWORK_SFU_CANDIDATE_DENY_CIDRS", "10.8.0.0/16")
+	os.Setenv(prefix+"NETWORK_SFU_DISCONNECT_GRACE_PERIOD", "3s")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_NEGOTIATIONS", "30")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_NEGOTIATIONS_WINDOW", "2m")
+	os.Setenv(prefix+"NETWORK_SFU_NEGOTIATION_TIMEOUT", "30s")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_CONCURRENT_NEGOTIATIONS", "8")
+	os.Setenv(prefix+"NETWORK_SFU_DTLS_CERT", "dtls.pem")
+	os.Setenv(prefix+"NETWORK_SFU_DTLS_KEY", "dtls.key")
+	os.Setenv(prefix+"NETWORK_SFU_TRICKLE", "true")
+	os.Setenv(prefix+"NETWORK_SFU_KEYFRAME_INTERVAL", "1s")
+	os.Setenv(prefix+"NETWORK_SFU_INTERCEPTORS", "sei,headers")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_SIGNAL_DEPTH", "8")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_SIGNAL_ELEMENTS", "256")
+	os.Setenv(prefix+"NETWORK_SFU_INITIAL_TRANSCEIVER_DIRECTION", "sendrecv")
+	os.Setenv(prefix+"NETWORK_SFU_REQUESTED_TRANSCEIVER_DIRECTION", "recvonly")
+	os.Setenv(prefix+"NETWORK_SFU_QUALITY_INTERVAL", "10s")
+	os.Setenv(prefix+"NETWORK_SFU_LOG_SDP", "true")
+	os.Setenv(prefix+"NETWORK_SFU_LOG_CANDIDATE_PAIR", "true")
+	os.Setenv(prefix+"NETWORK_SFU_LAST_N", "3")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_CANDIDATES", "20")
+	os.Setenv(prefix+"NETWORK_SFU_REORDER_DEPTH", "8")
+	os.Setenv(prefix+"NETWORK_SFU_REORDER_TIMEOUT", "30ms")
+	os.Setenv(prefix+"NETWORK_SFU_MAX_QUEUED_TRANSCEIVERS", "4")
+	os.Setenv(prefix+"NETWORK_SFU_QUEUED_TRANSCEIVERS_OVERFLOW_POLICY", "close-connection")
+	os.Setenv(prefix+"NETWORK_SFU_DSCP", "46")
+	os.Setenv(prefix+"NETWORK_AUTO_SFU_THRESHOLD", "4")
+	os.Setenv(prefix+"NETWORK_AUDIO_ONLY", "true")
+	os.Setenv(prefix+"NETWORK_SERIALIZER", "protobuf")
+	os.Setenv(prefix+"WS_WELCOME_MESSAGE", "welcome")
+	os.Setenv(prefix+"WS_RATE_LIMIT", "5")
+	os.Setenv(prefix+"WS_RATE_LIMIT_BURST", "10")
+	os.Setenv(prefix+"WS_RATE_LIMIT_MAX_DROPPED", "50")
+	os.Setenv(prefix+"WS_COMPRESSION", "context_takeover")
+	os.Setenv(prefix+"WS_COMPRESSION_THRESHOLD", "256")
+	os.Setenv(prefix+"WS_CLIENT_ID_MODE", "server")
+	os.Setenv(prefix+"WS_SEND_QUEUE_SIZE", "64")
+	os.Setenv(prefix+"WS_SEND_QUEUE_OVERFLOW_POLICY", "drop-oldest")
+	os.Setenv(prefix+"WS_ROOM_NAME_PATTERN", "[a-z]+")
+	os.Setenv(prefix+"WS_RECONNECT_WINDOW", "30s")
+	os.Setenv(prefix+"WS_TRUSTED_PROXIES", "10.0.0.0/8,fd00::/8")
+	os.Setenv(prefix+"WS_LOG_PAYLOADS", "true")
+	os.Setenv(prefix+"WS_LOG_PAYLOADS_REDACT", "sdp,nickname")
+	os.Setenv(prefix+"WS_MAX_FRAME_RATE", "200")
+	os.Setenv(prefix+"WS_READ_LIMIT", "65536")
+	os.Setenv(prefix+"WS_UNKNOWN_MESSAGE_POLICY", "reject")
+	os.Setenv(prefix+"WS_ROOM_PASSWORDS", "true")
+	os.Setenv(prefix+"WS_CORRELATION_IDS", "true")
+	os.Setenv(prefix+"WS_METADATA_MAX_LENGTH", "32")
+	os.Setenv(prefix+"WS_METADATA_PATTERN", "[a-z ]*")
+	os.Setenv(prefix+"WS_REQUIRE_METADATA", "true")
+	os.Setenv(prefix+"ROOMS_MAX", "10")
+	os.Setenv(prefix+"ROOMS_MAX_VIDEO_PUBLISHERS", "3")
+	os.Setenv(prefix+"ROOMS_MAX_TRANSCEIVERS", "20")
+	os.Setenv(prefix+"ROOMS_MAX_DURATION", "1h")
+	os.Setenv(prefix+"ROOMS_CLIENT_QUOTA", "1000000")
+	os.Setenv(prefix+"ROOMS_CLIENT_QUOTA_WINDOW", "1m")
+	os.Setenv(prefix+"API_TOKEN", "secret")
+	os.Setenv(prefix+"API_ALLOWED_MESSAGE_TYPES", "ws_notice")
+	os.Setenv(prefix+"WEBHOOKS_URLS", "https://a.example.com/hook,https://b.example.com/hook")
+	os.Setenv(prefix+"WEBHOOKS_SECRET", "hook-secret")
+	os.Setenv(prefix+"WEBHOOKS_MAX_RETRIES", "5")
+	os.Setenv(prefix+"WEBHOOKS_RETRY_DELAY", "2s")
+	os.Setenv(prefix+"WEBHOOKS_TIMEOUT", "10s")
+	os.Setenv(prefix+"TURN_POLICY", "ignore")
+	os.Setenv(prefix+"TURN_SELF_TEST", "true")
+	os.Setenv(prefix+"NAMESPACES", "app1,app2")
+	os.Setenv(prefix+"ADMIN_BIND_HOST", "::1")
+	os.Setenv(prefix+"ADMIN_BIND_PORT", "9090")
 	var c config.Config
 	config.ReadEnv(prefix, &c)
 	assert.Equal(t, "/test", c.BaseURL)
+	assert.Equal(t, "/run/peer-calls.sock", c.BindSocket)
 	assert.Equal(t, "test.pem", c.TLS.Cert)
 	assert.Equal(t, "test.key", c.TLS.Key)
+	assert.Equal(t, "1.3", c.TLS.MinVersion)
+	assert.Equal(t, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, c.TLS.CipherSuites)
 	assert.Equal(t, config.StoreTypeRedis, c.Store.Type)
 	assert.Equal(t, "localhost", c.Store.Redis.Host)
 	assert.Equal(t, 6379, c.Store.Redis.Port)
 	assert.Equal(t, "peercalls", c.Store.Redis.Prefix)
+	assert.Equal(t, config.SerializerTypeProtobuf, c.Store.Redis.Serializer)
+	assert.True(t, c.Store.Redis.PersistRoomMetadata)
+	assert.Equal(t, 500*time.Millisecond, c.Store.Redis.PublishTimeout)
+	assert.Equal(t, 30*time.Second, c.Store.Redis.PingInterval)
+	assert.Equal(t, 20, c.Store.ChatHistorySize)
+	assert.Equal(t, 250*time.Millisecond, c.Store.PresenceDebounce)
+	assert.Equal(t, time.Hour, c.Store.RoomPasswordTTL)
 	assert.Equal(t, 1, len(c.ICEServers))
 	ice := c.ICEServers[0]
 	assert.Equal(t, []string{
@@ -92,4 +320,76 @@ func TestReadFromEnv(t *testing.T) {
 	assert.Equal(t, "test_secret", ice.AuthSecret.Secret)
 	assert.Equal(t, config.NetworkType("sfu"), c.Network.Type)
 	assert.Equal(t, []string{"a", "b"}, c.Network.SFU.Interfaces)
+	assert.Equal(t, config.IPFamilyIPv4, c.Network.SFU.IPFamilies)
+	assert.Equal(t, 5*time.Second, c.Network.SFU.Keepalive)
+	assert.Equal(t, 4096, c.Network.SFU.MaxSDPSize)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, c.Network.SFU.CandidateAllowCIDRs)
+	assert.Equal(t, []string{"10.8.0.0/16"}, c.Network.SFU.CandidateDenyCIDRs)
+	assert.Equal(t, 3*time.Second, c.Network.SFU.DisconnectGracePeriod)
+	assert.Equal(t, 30, c.Network.SFU.MaxNegotiations)
+	assert.Equal(t, 2*time.Minute, c.Network.SFU.MaxNegotiationsWindow)
+	assert.Equal(t, 30*time.Second, c.Network.SFU.NegotiationTimeout)
+	assert.Equal(t, 8, c.Network.SFU.MaxConcurrentNegotiations)
+	assert.Equal(t, "dtls.pem", c.Network.SFU.DTLSCert)
+	assert.Equal(t, "dtls.key", c.Network.SFU.DTLSKey)
+	assert.True(t, c.Network.SFU.Trickle)
+	assert.Equal(t, time.Second, c.Network.SFU.KeyframeInterval)
+	assert.Equal(t, []string{"sei", "headers"}, c.Network.SFU.Interceptors)
+	assert.Equal(t, 8, c.Network.SFU.MaxSignalDepth)
+	assert.Equal(t, 256, c.Network.SFU.MaxSignalElements)
+	assert.Equal(t, config.TransceiverDirectionSendrecv, c.Network.SFU.InitialTransceiverDirection)
+	assert.Equal(t, config.TransceiverDirectionRecvonly, c.Network.SFU.RequestedTransceiverDirection)
+	assert.Equal(t, 10*time.Second, c.Network.SFU.QualityInterval)
+	assert.True(t, c.Network.SFU.LogSDP)
+	assert.True(t, c.Network.SFU.LogCandidatePair)
+	assert.Equal(t, 3, c.Network.SFU.LastN)
+	assert.Equal(t, 20, c.Network.SFU.MaxCandidates)
+	assert.Equal(t, 8, c.Network.SFU.ReorderDepth)
+	assert.Equal(t, 30*time.Millisecond, c.Network.SFU.ReorderTimeout)
+	assert.Equal(t, 4, c.Network.SFU.MaxQueuedTransceivers)
+	assert.Equal(t, config.OverflowPolicyCloseConnection, c.Network.SFU.QueuedTransceiversOverflowPolicy)
+	assert.Equal(t, 46, c.Network.SFU.DSCP)
+	assert.True(t, c.Network.AudioOnly)
+	assert.Equal(t, config.SerializerTypeProtobuf, c.Network.Serializer)
+	assert.Equal(t, 4, c.Network.AutoSFUThreshold)
+	assert.Equal(t, "welcome", c.WS.WelcomeMessage)
+	assert.Equal(t, 5, c.WS.RateLimit)
+	assert.Equal(t, 10, c.WS.RateLimitBurst)
+	assert.Equal(t, 50, c.WS.RateLimitMaxDropped)
+	assert.Equal(t, config.CompressionContextTakeover, c.WS.Compression)
+	assert.Equal(t, 256, c.WS.CompressionThreshold)
+	assert.Equal(t, config.ClientIDModeServer, c.WS.ClientIDMode)
+	assert.Equal(t, 64, c.WS.SendQueueSize)
+	assert.Equal(t, config.OverflowPolicyDropOldest, c.WS.SendQueueOverflowPolicy)
+	assert.Equal(t, "[a-z]+", c.WS.RoomNamePattern)
+	assert.Equal(t, 30*time.Second, c.WS.ReconnectWindow)
+	assert.Equal(t, []string{"10.0.0.0/8", "fd00::/8"}, c.WS.TrustedProxies)
+	assert.True(t, c.WS.LogPayloads)
+	assert.Equal(t, []string{"sdp", "nickname"}, c.WS.LogPayloadsRedact)
+	assert.Equal(t, 200, c.WS.MaxFrameRate)
+	assert.Equal(t, 65536, c.WS.ReadLimit)
+	assert.Equal(t, config.UnknownMessagePolicyReject, c.WS.UnknownMessagePolicy)
+	assert.True(t, c.WS.RoomPasswords)
+	assert.True(t, c.WS.CorrelationIDs)
+	assert.True(t, c.WS.RequireMetadata)
+	assert.Equal(t, 32, c.WS.MetadataMaxLength)
+	assert.Equal(t, "[a-z ]*", c.WS.MetadataPattern)
+	assert.Equal(t, 10, c.Rooms.Max)
+	assert.Equal(t, 3, c.Rooms.MaxVideoPublishers)
+	assert.Equal(t, 20, c.Rooms.MaxTransceivers)
+	assert.Equal(t, time.Hour, c.Rooms.MaxDuration)
+	assert.Equal(t, 1000000, c.Rooms.ClientQuota)
+	assert.Equal(t, time.Minute, c.Rooms.ClientQuotaWindow)
+	assert.Equal(t, "secret", c.API.Token)
+	assert.Equal(t, []string{"ws_notice"}, c.API.AllowedMessageTypes)
+	assert.Equal(t, []string{"https://a.example.com/hook", "https://b.example.com/hook"}, c.Webhooks.URLs)
+	assert.Equal(t, "hook-secret", c.Webhooks.Secret)
+	assert.Equal(t, 5, c.Webhooks.MaxRetries)
+	assert.Equal(t, 2*time.Second, c.Webhooks.RetryDelay)
+	assert.Equal(t, 10*time.Second, c.Webhooks.Timeout)
+	assert.Equal(t, config.TURNPolicyIgnore, c.TURNPolicy)
+	assert.True(t, c.TURNSelfTest)
+	assert.Equal(t, []string{"app1", "app2"}, c.Namespaces)
+	assert.Equal(t, "::1", c.Admin.BindHost)
+	assert.Equal(t, 9090, c.Admin.BindPort)
 }
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Replaces openFile with a function that fails with err for the first
+// failures attempts and then opens the file. Returns the number of attempts.
+func mockOpenFile(t *testing.T, failures int, open func(filename string) (io.ReadCloser, error)) *int {
+	attempts := 0
+	orig := openFile
+	t.Cleanup(func() { openFile = orig })
+	openFile = func(filename string) (io.ReadCloser, error) {
+		attempts++
+		if attempts <= failures {
+			return open(filename)
+		}
+		return orig(filename)
+	}
+	return &attempts
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+func (r failingReader) Close() error             { return nil }
+
+func writeConfigFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "peer-calls-config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, "config.yml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("bind_port: 3001\n"), 0600))
+	return filename
+}
+
+func TestReadFileWithParams_openRetry(t *testing.T) {
+	filename := writeConfigFile(t)
+	attempts := mockOpenFile(t, 2, func(filename string) (io.ReadCloser, error) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: syscall.ESTALE}
+	})
+
+	var c Config
+	err := ReadFileWithParams(filename, &c, ReadParams{Retries: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 3001, c.BindPort)
+	assert.Equal(t, 3, *attempts)
+}
+
+func TestReadFileWithParams_readRetry(t *testing.T) {
+	filename := writeConfigFile(t)
+	attempts := mockOpenFile(t, 2, func(filename string) (io.ReadCloser, error) {
+		return failingReader{&os.PathError{Op: "read", Path: filename, Err: syscall.EIO}}, nil
+	})
+
+	var c Config
+	err := ReadFileWithParams(filename, &c, ReadParams{Retries: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 3001, c.BindPort)
+	assert.Equal(t, 3, *attempts)
+}
+
+func TestReadFileWithParams_retriesExceeded(t *testing.T) {
+	filename := writeConfigFile(t)
+	attempts := mockOpenFile(t, 3, func(filename string) (io.ReadCloser, error) {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: syscall.EIO}
+	})
+
+	var c Config
+	err := ReadFileWithParams(filename, &c, ReadParams{Retries: 2})
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "Error opening YAML file"), err.Error())
+	assert.Equal(t, 3, *attempts)
+}
+
+func TestReadFileWithParams_missingNotRetried(t *testing.T) {
+	attempts := mockOpenFile(t, 0, nil)
+
+	var c Config
+	err := ReadFileWithParams("/non-existing/config.yml", &c, ReadParams{Retries: 3})
+	require.Error(t, err)
+	assert.True(t, os.IsNotExist(errors.Unwrap(err)), err.Error())
+	assert.Equal(t, 1, *attempts)
+}
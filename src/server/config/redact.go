@@ -0,0 +1,39 @@
+package config
+
+const redacted = "[redacted]"
+
+// Returns a copy of the config with secrets, such as the API token and the
+// TURN secrets, replaced so that it can be logged. Empty secrets are kept
+// empty to show that they are not set.
+func (c Config) Redacted() Config {
+	c.API.Token = redact(c.API.Token)
+	c.Webhooks.Secret = redact(c.Webhooks.Secret)
+	c.ICEServers = redactICEServers(c.ICEServers)
+	if c.RoomICEServers != nil {
+		rooms := make(map[string][]ICEServer, len(c.RoomICEServers))
+		for room, servers := range c.RoomICEServers {
+			rooms[room] = redactICEServers(servers)
+		}
+		c.RoomICEServers = rooms
+	}
+	return c
+}
+
+func redactICEServers(servers []ICEServer) []ICEServer {
+	if servers == nil {
+		return nil
+	}
+	copied := make([]ICEServer, len(servers))
+	for i, server := range servers {
+		server.AuthSecret.Secret = redact(server.AuthSecret.Secret)
+		copied[i] = server
+	}
+	return copied
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
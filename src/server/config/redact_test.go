@@ -0,0 +1,35 @@
+package config_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Redacted(t *testing.T) {
+	var c config.Config
+	c.API.Token = "api-token"
+	c.Webhooks.Secret = "webhooks-secret"
+	var iceServer config.ICEServer
+	iceServer.AuthType = config.AuthTypeSecret
+	iceServer.AuthSecret.Username = "user"
+	iceServer.AuthSecret.Secret = "turn-secret"
+	c.ICEServers = []config.ICEServer{iceServer}
+	c.RoomICEServers = map[string][]config.ICEServer{
+		"room1": {iceServer},
+	}
+
+	logged := fmt.Sprintf("%+v", c.Redacted())
+
+	for _, secret := range []string{"api-token", "webhooks-secret", "turn-secret"} {
+		assert.NotContains(t, logged, secret)
+	}
+	assert.Contains(t, logged, "user")
+	assert.Equal(t, "api-token", c.API.Token, "the config should not be modified")
+	assert.Equal(t, "turn-secret", c.ICEServers[0].AuthSecret.Secret, "the config should not be modified")
+	assert.Equal(t, "turn-secret", c.RoomICEServers["room1"][0].AuthSecret.Secret, "the config should not be modified")
+
+	assert.Equal(t, "", config.Config{}.Redacted().API.Token)
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Returns the TLS version for a name like "1.2". Defaults to TLS 1.2 when
+// name is empty.
+func TLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown TLS version: %q, expected one of: 1.0, 1.1, 1.2, 1.3", name)
+	}
+	return version, nil
+}
+
+// Returns the IDs of cipher suites named like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+// Only the suites returned by tls.CipherSuites are allowed because the others
+// have known security issues. Returns nil when names is empty so that the Go
+// defaults are used.
+func TLSCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suitesByName := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suitesByName[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suitesByName[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown or insecure TLS cipher suite: %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Loads the certificate used for DTLS from PEM encoded files and sets its
+// Leaf. Only RSA and ECDSA keys are supported by pion. Returns nil when both
+// files are empty.
+func DTLSCertificate(certFile string, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("Both the certificate and the key file are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading certificate: %w", err)
+	}
+
+	switch cert.PrivateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("Unsupported private key type: %T, expected RSA or ECDSA", cert.PrivateKey)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing certificate: %w", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("Certificate expired at %s", leaf.NotAfter)
+	}
+	cert.Leaf = leaf
+
+	return &cert, nil
+}
@@ -0,0 +1,127 @@
+package config_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSVersion(t *testing.T) {
+	for name, version := range map[string]uint16{
+		"":    tls.VersionTLS12,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	} {
+		v, err := config.TLSVersion(name)
+		assert.Nil(t, err, "version: %q", name)
+		assert.Equal(t, version, v, "version: %q", name)
+	}
+
+	for _, name := range []string{"1.4", "TLS1.2", "ssl3"} {
+		_, err := config.TLSVersion(name)
+		require.NotNil(t, err, "version: %q", name)
+		assert.Regexp(t, "Unknown TLS version", err.Error())
+	}
+}
+
+func TestTLSCipherSuites(t *testing.T) {
+	ids, err := config.TLSCipherSuites(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, ids)
+
+	ids, err = config.TLSCipherSuites([]string{
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}, ids)
+
+	for _, name := range []string{"TLS_UNKNOWN", "TLS_RSA_WITH_RC4_128_SHA"} {
+		_, err = config.TLSCipherSuites([]string{name})
+		require.NotNil(t, err, "cipher suite: %s", name)
+		assert.Regexp(t, "Unknown or insecure TLS cipher suite", err.Error())
+	}
+}
+
+// Writes a self-signed certificate and its key to PEM files in dir.
+func writeCertificate(t *testing.T, dir string, name string, key crypto.Signer, notAfter time.Time) (certFile string, keyFile string) {
+	t.Helper()
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestDTLSCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-calls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	certFile, keyFile := writeCertificate(t, dir, "valid", ecdsaKey, time.Now().Add(time.Hour))
+	expiredCertFile, expiredKeyFile := writeCertificate(t, dir, "expired", ecdsaKey, time.Now().Add(-time.Hour))
+	ed25519CertFile, ed25519KeyFile := writeCertificate(t, dir, "ed25519", ed25519Key, time.Now().Add(time.Hour))
+
+	cert, err := config.DTLSCertificate("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, cert)
+
+	cert, err = config.DTLSCertificate(certFile, keyFile)
+	require.Nil(t, err)
+	require.NotNil(t, cert)
+	require.NotNil(t, cert.Leaf)
+	assert.Equal(t, cert.Certificate[0], cert.Leaf.Raw)
+	assert.Equal(t, ecdsaKey, cert.PrivateKey)
+
+	for _, tc := range []struct {
+		certFile string
+		keyFile  string
+		err      string
+	}{
+		{certFile, "", "Both the certificate and the key file are required"},
+		{"", keyFile, "Both the certificate and the key file are required"},
+		{certFile, filepath.Join(dir, "missing.key"), "Error loading certificate"},
+		{certFile, ed25519KeyFile, "Error loading certificate"},
+		{expiredCertFile, expiredKeyFile, "Certificate expired"},
+		{ed25519CertFile, ed25519KeyFile, "Unsupported private key type"},
+	} {
+		_, err := config.DTLSCertificate(tc.certFile, tc.keyFile)
+		require.NotNil(t, err, "cert: %s, key: %s", tc.certFile, tc.keyFile)
+		assert.Contains(t, err.Error(), tc.err)
+	}
+}
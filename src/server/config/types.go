@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type AuthType string
 
 const (
@@ -16,9 +18,34 @@ type ICEServer struct {
 	} `yaml:"auth_secret"`
 }
 
+// What happens at startup when no TURN server is configured. Without TURN,
+// clients behind symmetric NATs cannot connect.
+type TURNPolicy string
+
+const (
+	TURNPolicyIgnore TURNPolicy = "ignore"
+	TURNPolicyWarn   TURNPolicy = "warn"
+	TURNPolicyStrict TURNPolicy = "strict"
+)
+
+// Direction of transceivers the SFU adds to a peer connection. The SFU
+// cannot add sendonly transceivers, those are only used for kinds that a
+// client is not allowed to publish.
+type TransceiverDirection string
+
+const (
+	TransceiverDirectionSendrecv TransceiverDirection = "sendrecv"
+	TransceiverDirectionRecvonly TransceiverDirection = "recvonly"
+)
+
 type TLSConfig struct {
 	Cert string `yaml:"cert"`
 	Key  string `yaml:"key"`
+	// Minimum TLS version: 1.0, 1.1, 1.2 or 1.3. Defaults to 1.2 when empty.
+	MinVersion string `yaml:"min_version"`
+	// Names of cipher suites used with TLS 1.2 and older, for example
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Uses the Go defaults when empty.
+	CipherSuites []string `yaml:"cipher_suites"`
 }
 
 type StoreType string
@@ -28,15 +55,46 @@ const (
 	StoreTypeRedis  StoreType = "redis"
 )
 
+type SerializerType string
+
+const (
+	SerializerTypeJSON     SerializerType = "json"
+	SerializerTypeProtobuf SerializerType = "protobuf"
+	// JSON compressed with DEFLATE. Only supported by network.serializer.
+	SerializerTypeBinary SerializerType = "binary"
+)
+
 type RedisConfig struct {
 	Host   string `yaml:"host"`
 	Port   int    `yaml:"port"`
 	Prefix string `yaml:"prefix"`
+	// Encoding of messages published to and stored in Redis. All instances
+	// sharing a Redis server must use the same serializer.
+	Serializer SerializerType `yaml:"serializer"`
+	// Store room metadata in Redis so it is shared between instances and
+	// survives restarts. Otherwise each instance keeps it in-process.
+	PersistRoomMetadata bool `yaml:"persist_room_metadata"`
+	// Maximum time to wait for a message to be published to Redis before
+	// giving up with an error. Unlimited when zero.
+	PublishTimeout time.Duration `yaml:"publish_timeout"`
+	// Interval between pings on the subscriber connection, which keeps it
+	// from being closed by proxies when idle. Disabled when zero.
+	PingInterval time.Duration `yaml:"ping_interval"`
 }
 
 type StoreConfig struct {
 	Type  StoreType   `yaml:"type"`
 	Redis RedisConfig `yaml:"redis"`
+	// Number of recent chat messages kept per room. Disabled when zero.
+	ChatHistorySize int `yaml:"chat_history_size"`
+	// Join and leave messages within this window are sent as a single
+	// ws_room_state_delta message to clients that subscribed to it, which
+	// reduces the number of messages in large rooms. Other clients still
+	// receive the join and leave messages. Disabled when zero.
+	PresenceDebounce time.Duration `yaml:"presence_debounce"`
+	// Room passwords are removed when they are not used to join the room,
+	// or set again, for this long. Defaults to 24 hours when zero.
+	RoomPasswordTTL time.Duration `yaml:"room_password_ttl"`
 }
 
 type NetworkType string
@@ -49,18 +107,360 @@ const (
 type NetworkConfig struct {
 	Type NetworkType      `yaml:"type"`
 	SFU  NetworkConfigSFU `yaml:"sfu"`
+	// When using mesh, new connections to a room with more than this many
+	// connections use the SFU instead. Disabled when zero.
+	AutoSFUThreshold int `yaml:"auto_sfu_threshold"`
+	// Only negotiates audio with the SFU. Mesh connections negotiate media
+	// directly between clients and are not affected.
+	AudioOnly bool `yaml:"audio_only"`
+	// Encoding of websocket messages exchanged with clients. Clients must
+	// request the matching websocket subprotocol, otherwise the connection is
+	// rejected.
+	Serializer SerializerType `yaml:"serializer"`
+}
+
+// Binds all interfaces on both IPv4 and IPv6.
+const BindHostDualStack = "*"
+
+type IPFamily string
+
+const (
+	IPFamilyIPv4 IPFamily = "ipv4"
+	IPFamilyIPv6 IPFamily = "ipv6"
+	IPFamilyBoth IPFamily = "both"
+)
+
+// Options of a data channel created by the SFU. Messages are delivered
+// reliably and in order by default.
+type DataChannelConfig struct {
+	Label string `yaml:"label"`
+	// Allows messages to be delivered out of order.
+	Unordered bool `yaml:"unordered"`
+	// Maximum number of retransmissions of a message that was not
+	// acknowledged. Cannot be combined with MaxPacketLifeTime.
+	MaxRetransmits *uint16 `yaml:"max_retransmits"`
+	// Time in milliseconds during which a message that was not acknowledged
+	// is retransmitted. Cannot be combined with MaxRetransmits.
+	MaxPacketLifeTime *uint16 `yaml:"max_packet_life_time"`
 }
 
 type NetworkConfigSFU struct {
 	Interfaces []string `yaml:"interfaces"`
+	IPFamilies IPFamily `yaml:"ip_families"`
+	// Interval between STUN keepalives sent on ICE candidate pairs. Must be
+	// shorter than the ICE connection timeout (30s), otherwise connections
+	// will be considered disconnected between keepalives. Uses the pion
+	// default (10s) when zero.
+	Keepalive time.Duration `yaml:"keepalive"`
+	// Maximum size of SDPs received from clients in bytes. Peer connections
+	// sending larger SDPs are closed. Defaults to 32 KiB when zero. SDPs are
+	// also bounded by WSConfig.ReadLimit.
+	MaxSDPSize int `yaml:"max_sdp_size"`
+	// ICE candidates are only used when their address is in one of these
+	// networks. All addresses are allowed when empty.
+	CandidateAllowCIDRs []string `yaml:"candidate_allow_cidrs"`
+	// ICE candidates with addresses in these networks are never used, even
+	// when they are also allowed.
+	CandidateDenyCIDRs []string `yaml:"candidate_deny_cidrs"`
+	// Time to wait for ICE to recover from a disconnected state before the
+	// peer connection is closed. Closes immediately when zero.
+	DisconnectGracePeriod time.Duration `yaml:"disconnect_grace_period"`
+	// Maximum number of negotiations with a peer within
+	// MaxNegotiationsWindow. Further negotiations are postponed. Unlimited
+	// when zero.
+	MaxNegotiations int `yaml:"max_negotiations"`
+	// Rolling window for MaxNegotiations. Defaults to one minute when zero.
+	MaxNegotiationsWindow time.Duration `yaml:"max_negotiations_window"`
+	// Maximum time a negotiation may wait for an offer or answer before the
+	// peer connection is closed. Disabled when zero.
+	NegotiationTimeout time.Duration `yaml:"negotiation_timeout"`
+	// Maximum number of offers and answers created at the same time by all
+	// peer connections. Further negotiations wait in a queue. Unlimited when
+	// zero.
+	MaxConcurrentNegotiations int `yaml:"max_concurrent_negotiations"`
+	// PEM encoded certificate and key files used for DTLS, so that the DTLS
+	// fingerprint of the server is the same after restarts. A new certificate
+	// is generated for every peer connection when empty.
+	DTLSCert string `yaml:"dtls_cert"`
+	DTLSKey  string `yaml:"dtls_key"`
+	// Signals ICE candidates to clients as they are gathered. When false, all
+	// candidates are gathered before the SDP is sent and included in it.
+	Trickle bool `yaml:"trickle"`
+	// Interval between keyframes requested from publishers of video tracks,
+	// which bounds the time subscribers wait for a picture after packet loss.
+	// Defaults to 3 seconds when zero.
+	KeyframeInterval time.Duration `yaml:"keyframe_interval"`
+	// Names of interceptors registered with tracks.RegisterInterceptor that
+	// process RTP packets before they are forwarded, in order.
+	Interceptors []string `yaml:"interceptors"`
+	// Maximum nesting depth of signal payloads received from clients.
+	// Defaults to 16 when zero.
+	MaxSignalDepth int `yaml:"max_signal_depth"`
+	// Maximum number of map entries and list elements in a signal payload
+	// received from a client. Defaults to 1024 when zero.
+	MaxSignalElements int `yaml:"max_signal_elements"`
+	// Data channels created by the SFU when it initiates the connection. The
+	// data channel used for messages, labeled "data", is reliable and
+	// ordered unless it is listed.
+	DataChannels []DataChannelConfig `yaml:"data_channels"`
+	// Direction of the audio and video transceivers added when a client
+	// connects. Use sendrecv when most clients publish media and recvonly
+	// when most only view. Defaults to recvonly when empty.
+	InitialTransceiverDirection TransceiverDirection `yaml:"initial_transceiver_direction"`
+	// Direction of transceivers added when a client requests them to publish
+	// a track. Defaults to sendrecv when empty.
+	RequestedTransceiverDirection TransceiverDirection `yaml:"requested_transceiver_direction"`
+	// Interval between estimates of the connection quality of each client,
+	// which are broadcast to the room when they change. Defaults to 5 seconds
+	// when zero.
+	QualityInterval time.Duration `yaml:"quality_interval"`
+	// Logs SDPs and ICE candidates, which contain the network addresses of
+	// clients, to the sdp logger. They are never written when false, even
+	// when the sdp logger is enabled.
+	LogSDP bool `yaml:"log_sdp"`
+	// Logs the types of the local and remote candidates of the ICE candidate
+	// pair selected for each peer connection, which tells whether media is
+	// sent directly or relayed through TURN.
+	LogCandidatePair bool `yaml:"log_candidate_pair"`
+	// Forwards video of only the N most recent active speakers in each room,
+	// and of clients whose video was requested with ws_video_request. Clients
+	// are told when their video is paused with ws_video_paused. Requires
+	// clients to send Opus audio with DTX. Video of all publishers is
+	// forwarded when zero.
+	LastN int `yaml:"last_n"`
+	// Maximum number of ICE candidates accepted from a client per peer
+	// connection. Further candidates are dropped, and the peer connection is
+	// closed when a client sends more than twice as many. Defaults to 100
+	// when zero.
+	MaxCandidates int `yaml:"max_candidates"`
+	// Maximum number of RTP packets of each track held to forward them in
+	// order of their sequence numbers. Packets are forwarded as they arrive
+	// when zero.
+	ReorderDepth int `yaml:"reorder_depth"`
+	// Maximum time a packet is held while waiting for earlier ones, after
+	// which the missing packets are skipped. Defaults to 50ms when zero.
+	ReorderTimeout time.Duration `yaml:"reorder_timeout"`
+	// Maximum number of transceiver requests of a client queued until the
+	// next negotiation, e.g. while the client does not complete the current
+	// one. Unlimited when zero.
+	MaxQueuedTransceivers int `yaml:"max_queued_transceivers"`
+	// Decides what happens to transceiver requests when the queue is full.
+	// Dropped requests are reported to the client, and close-connection
+	// closes the peer connection. Defaults to drop-newest when empty.
+	QueuedTransceiversOverflowPolicy OverflowPolicy `yaml:"queued_transceivers_overflow_policy"`
+	// DSCP value (0-63) set on packets sent to clients, e.g. 46 (EF) so that
+	// managed networks prioritize media. Audio and video share a socket, so
+	// they cannot be marked differently. Relayed packets and platforms that
+	// do not support setting the DSCP are not marked. Disabled when zero.
+	DSCP int `yaml:"dscp"`
+}
+
+type RoomsConfig struct {
+	// Maximum number of simultaneously active rooms. When using Redis this
+	// limit is per instance. Unlimited when zero.
+	Max int `yaml:"max"`
+	// Maximum number of clients that can publish video in a room when using
+	// the SFU. Other clients can still receive. Unlimited when zero.
+	MaxVideoPublishers int `yaml:"max_video_publishers"`
+	// Maximum number of transceivers negotiated by all clients in a room when
+	// using the SFU. Unlimited when zero.
+	MaxTransceivers int `yaml:"max_transceivers"`
+	// Maximum time a room can exist. All clients are disconnected once it has
+	// passed. Unlimited when zero.
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// Maximum number of bytes sent to and received from each client within
+	// the quota window, over websockets and the SFU. Clients over the quota
+	// are disconnected. Unlimited when zero.
+	ClientQuota int `yaml:"client_quota"`
+	// Window after which the usage of clients is reset. The quota applies to
+	// the whole connection when zero.
+	ClientQuotaWindow time.Duration `yaml:"client_quota_window"`
+}
+
+type Compression string
+
+const (
+	CompressionDisabled          Compression = "disabled"
+	CompressionContextTakeover   Compression = "context_takeover"
+	CompressionNoContextTakeover Compression = "no_context_takeover"
+)
+
+type ClientIDMode string
+
+const (
+	// Client IDs are taken from the websocket URL.
+	ClientIDModeClient ClientIDMode = "client"
+	// Client IDs are generated by the server and client-supplied IDs are
+	// ignored.
+	ClientIDModeServer ClientIDMode = "server"
+)
+
+type OverflowPolicy string
+
+const (
+	OverflowPolicyDropNewest      OverflowPolicy = "drop-newest"
+	OverflowPolicyDropOldest      OverflowPolicy = "drop-oldest"
+	OverflowPolicyCloseConnection OverflowPolicy = "close-connection"
+)
+
+// What happens to messages of types that the server does not handle.
+type UnknownMessagePolicy string
+
+const (
+	UnknownMessagePolicyIgnore UnknownMessagePolicy = "ignore"
+	UnknownMessagePolicyLog    UnknownMessagePolicy = "log"
+	UnknownMessagePolicyReject UnknownMessagePolicy = "reject"
+)
+
+// Bounds of WSConfig.ReadLimit.
+const (
+	MinReadLimit = 1 << 10
+	MaxReadLimit = 16 << 20
+)
+
+type WSConfig struct {
+	// Notice sent only to a client after it joins a room. Disabled when empty.
+	WelcomeMessage string `yaml:"welcome_message"`
+	// Maximum number of messages per second a client can send for each
+	// message type. Messages over the limit are dropped. Message types that
+	// are not handled by the server share one limit. Disabled when zero.
+	RateLimit int `yaml:"rate_limit"`
+	// Number of messages of each type a client can send in a burst.
+	RateLimitBurst int `yaml:"rate_limit_burst"`
+	// Number of dropped messages, replenished at RateLimit per second, after
+	// which the connection is closed. Connections are never closed when zero.
+	RateLimitMaxDropped int `yaml:"rate_limit_max_dropped"`
+	// Per-message-deflate compression mode. Disabled when empty.
+	Compression Compression `yaml:"compression"`
+	// Minimum message size in bytes before compression is applied. Uses the
+	// websocket library default for the selected mode when zero.
+	CompressionThreshold int `yaml:"compression_threshold"`
+	// Whether client IDs are supplied by clients or assigned by the server.
+	ClientIDMode ClientIDMode `yaml:"client_id_mode"`
+	// Number of messages queued for sending to each client. Defaults to 16
+	// when zero.
+	SendQueueSize int `yaml:"send_queue_size"`
+	// What happens to messages sent to a client whose send queue is full.
+	// Defaults to drop-newest when empty.
+	SendQueueOverflowPolicy OverflowPolicy `yaml:"send_queue_overflow_policy"`
+	// Regular expression that the whole room name must match, for example
+	// `[a-z0-9-]{4,32}`. Connections to other rooms are rejected. All room
+	// names are allowed when empty.
+	RoomNamePattern string `yaml:"room_name_pattern"`
+	// Time other clients should wait for a client whose peer connection with
+	// the server failed ICE to reconnect. Sent as a hint in the hangUp message
+	// of the client. Disabled when zero.
+	ReconnectWindow time.Duration `yaml:"reconnect_window"`
+	// Networks of reverse proxies trusted to set the X-Forwarded-For and
+	// X-Real-IP headers to the address of the client.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Logs the type, room, sender and payload of every message received from
+	// clients, for debugging.
+	LogPayloads bool `yaml:"log_payloads"`
+	// Names of payload fields whose values are replaced before payloads are
+	// logged, at any depth.
+	LogPayloadsRedact []string `yaml:"log_payloads_redact"`
+	// Maximum number of websocket frames per second a client can send, of
+	// all message types combined. The connection is closed when a client
+	// sends more. Defaults to 1000 when zero.
+	MaxFrameRate int `yaml:"max_frame_rate"`
+	// Maximum size in bytes of a message read from a client, between 1 KiB
+	// and 16 MiB. Connections sending larger messages are closed. Uses the
+	// websocket library default (32 KiB) when zero.
+	ReadLimit int `yaml:"read_limit"`
+	// What happens to messages of types the server does not handle, which
+	// usually means the client and server versions differ. Reject closes
+	// the connection. Defaults to ignore when empty.
+	UnknownMessagePolicy UnknownMessagePolicy `yaml:"unknown_message_policy"`
+	// Allows the first client to join a room to protect it with a password,
+	// which other clients must supply to join. Passwords can always be set
+	// through the HTTP API.
+	RoomPasswords bool `yaml:"room_passwords"`
+	// Maximum number of characters in client metadata, such as display
+	// names. Unlimited when zero.
+	MetadataMaxLength int `yaml:"metadata_max_length"`
+	// Regular expression that the whole client metadata must match, for
+	// example `[\pL\pN _.-]*`. Metadata with control characters or invalid
+	// UTF-8 is always rejected.
+	MetadataPattern string `yaml:"metadata_pattern"`
+	// Rejects connections of clients that do not supply metadata, such as a
+	// display name, in the metadata URL query parameter, unless it is set by
+	// the authenticator. Ready messages with an empty nickname are rejected
+	// too. The bundled web client does not send metadata when connecting.
+	RequireMetadata bool `yaml:"require_metadata"`
+	// Tags the log lines of each websocket connection, including the ones of
+	// its SFU signaller, with a random ID shared by all lines of the
+	// connection.
+	CorrelationIDs bool `yaml:"correlation_ids"`
+}
+
+type APIConfig struct {
+	// Bearer token required by the HTTP API. The API is disabled when empty.
+	Token string `yaml:"token"`
+	// Message types that can be sent to rooms via the HTTP API.
+	AllowedMessageTypes []string `yaml:"allowed_message_types"`
+}
+
+const DefaultWebhooksMaxRetries = 3
+
+type WebhooksConfig struct {
+	// HTTP or HTTPS URLs notified about room events. Disabled when empty.
+	URLs []string `yaml:"urls"`
+	// Key used to sign payloads with HMAC-SHA256. Payloads are not signed
+	// when empty.
+	Secret string `yaml:"secret"`
+	// Number of times a failed request is retried. Not retried when zero.
+	// Defaults to DefaultWebhooksMaxRetries when not set.
+	MaxRetries int `yaml:"max_retries"`
+	// Delay before the first retry, doubled after each retry. Defaults to 1
+	// second when zero.
+	RetryDelay time.Duration `yaml:"retry_delay"`
+	// Timeout of each request. Defaults to 5 seconds when zero.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Listener for the metrics and API endpoints, so that they can be kept off
+// the public listener.
+type AdminConfig struct {
+	// IP to listen to, or `*` for all IPv4 and IPv6 interfaces. Defaults to
+	// 127.0.0.1 when empty.
+	BindHost string `yaml:"bind_host"`
+	// Port to listen to. The endpoints are served by the main listener when
+	// zero.
+	BindPort int `yaml:"bind_port"`
 }
 
 type Config struct {
 	BaseURL    string        `yaml:"base_url"`
 	BindHost   string        `yaml:"bind_host"`
 	BindPort   int           `yaml:"bind_port"`
+	BindSocket string        `yaml:"bind_socket"`
 	ICEServers []ICEServer   `yaml:"ice_servers"`
 	TLS        TLSConfig     `yaml:"tls"`
 	Store      StoreConfig   `yaml:"store"`
 	Network    NetworkConfig `yaml:"network"`
+	WS         WSConfig      `yaml:"ws"`
+	Rooms      RoomsConfig   `yaml:"rooms"`
+	API        APIConfig     `yaml:"api"`
+	// ICE servers used instead of ICEServers for clients of specific rooms,
+	// keyed by room name. Rooms of namespaces are keyed by
+	// <namespace>/<room>.
+	RoomICEServers map[string][]ICEServer `yaml:"room_ice_servers"`
+	// Notified when rooms are created or destroyed and when clients join or
+	// leave.
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+	Admin    AdminConfig    `yaml:"admin"`
+	// Whether the ICE servers, and the ICE servers of each room in
+	// RoomICEServers, must include a TURN server. Strict refuses to start
+	// without one. Defaults to warn when empty.
+	TURNPolicy TURNPolicy `yaml:"turn_policy"`
+	// Allocate a relay on each TURN server at startup to check that it is
+	// reachable and accepts the credentials. TURN servers reachable only over
+	// TCP or TLS are skipped. Startup fails when a check fails and the TURN
+	// policy is strict, otherwise failures are logged.
+	TURNSelfTest bool `yaml:"turn_self_test"`
+	// Names of additional signaling namespaces served under /ns/<name>/ws.
+	// Each has its own rooms, so rooms with the same name in different
+	// namespaces are isolated from each other.
+	Namespaces []string `yaml:"namespaces"`
 }
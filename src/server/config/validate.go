@@ -0,0 +1,460 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Validates values that cannot be checked while parsing.
+func Validate(c Config) error {
+	if _, err := TLSVersion(c.TLS.MinVersion); err != nil {
+		return fmt.Errorf("Invalid tls.min_version: %w", err)
+	}
+
+	if _, err := TLSCipherSuites(c.TLS.CipherSuites); err != nil {
+		return fmt.Errorf("Invalid tls.cipher_suites: %w", err)
+	}
+
+	for _, iceServer := range c.ICEServers {
+		if err := validateICEServer(iceServer); err != nil {
+			return err
+		}
+	}
+
+	for room, iceServers := range c.RoomICEServers {
+		for _, iceServer := range iceServers {
+			if err := validateICEServer(iceServer); err != nil {
+				return fmt.Errorf("Invalid room_ice_servers.%s: %w", room, err)
+			}
+		}
+	}
+
+	switch c.TURNPolicy {
+	case "", TURNPolicyIgnore, TURNPolicyWarn:
+	case TURNPolicyStrict:
+		if missing := missingTURNServers(c); len(missing) > 0 {
+			return fmt.Errorf("Invalid %s: no TURN server configured, required by turn_policy: %s",
+				missing[0], TURNPolicyStrict)
+		}
+	default:
+		return fmt.Errorf("Invalid turn_policy: %q, expected one of: %s, %s, %s",
+			c.TURNPolicy, TURNPolicyIgnore, TURNPolicyWarn, TURNPolicyStrict)
+	}
+
+	namespaces := make(map[string]struct{}, len(c.Namespaces))
+	for _, namespace := range c.Namespaces {
+		if !namespaceRegexp.MatchString(namespace) {
+			return fmt.Errorf("Invalid namespaces: %q, must contain only letters, digits, - and _", namespace)
+		}
+		if _, ok := namespaces[namespace]; ok {
+			return fmt.Errorf("Invalid namespaces: duplicate namespace: %q", namespace)
+		}
+		namespaces[namespace] = struct{}{}
+	}
+
+	for key := range c.RoomICEServers {
+		if namespace, _ := splitNamespace(key); namespace != "" {
+			if _, ok := namespaces[namespace]; !ok {
+				return fmt.Errorf("Invalid room_ice_servers.%s: unknown namespace: %q", key, namespace)
+			}
+		}
+	}
+
+	switch c.Network.SFU.IPFamilies {
+	case "", IPFamilyIPv4, IPFamilyIPv6, IPFamilyBoth:
+	default:
+		return fmt.Errorf("Invalid network.sfu.ip_families: %q, expected one of: %s, %s, %s",
+			c.Network.SFU.IPFamilies, IPFamilyIPv4, IPFamilyIPv6, IPFamilyBoth)
+	}
+
+	if c.Network.SFU.Keepalive < 0 {
+		return fmt.Errorf("Invalid network.sfu.keepalive: %s, must be a positive duration",
+			c.Network.SFU.Keepalive)
+	}
+
+	if c.Network.SFU.DisconnectGracePeriod < 0 {
+		return fmt.Errorf("Invalid network.sfu.disconnect_grace_period: %s, must not be negative",
+			c.Network.SFU.DisconnectGracePeriod)
+	}
+
+	if c.Network.SFU.MaxNegotiations < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_negotiations: %d, must not be negative",
+			c.Network.SFU.MaxNegotiations)
+	}
+
+	if c.Network.SFU.MaxNegotiationsWindow < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_negotiations_window: %s, must not be negative",
+			c.Network.SFU.MaxNegotiationsWindow)
+	}
+
+	if c.Network.SFU.MaxSignalDepth < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_signal_depth: %d, must not be negative",
+			c.Network.SFU.MaxSignalDepth)
+	}
+
+	if c.Network.SFU.MaxSignalElements < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_signal_elements: %d, must not be negative",
+			c.Network.SFU.MaxSignalElements)
+	}
+
+	if err := validateTransceiverDirection(
+		"initial_transceiver_direction", c.Network.SFU.InitialTransceiverDirection,
+	); err != nil {
+		return err
+	}
+
+	if err := validateTransceiverDirection(
+		"requested_transceiver_direction", c.Network.SFU.RequestedTransceiverDirection,
+	); err != nil {
+		return err
+	}
+
+	dataChannelLabels := map[string]struct{}{}
+	for _, dataChannel := range c.Network.SFU.DataChannels {
+		if dataChannel.Label == "" {
+			return fmt.Errorf("Invalid network.sfu.data_channels: label is required")
+		}
+		if _, ok := dataChannelLabels[dataChannel.Label]; ok {
+			return fmt.Errorf("Invalid network.sfu.data_channels: %q, duplicate label", dataChannel.Label)
+		}
+		dataChannelLabels[dataChannel.Label] = struct{}{}
+		if dataChannel.MaxRetransmits != nil && dataChannel.MaxPacketLifeTime != nil {
+			return fmt.Errorf("Invalid network.sfu.data_channels: %q, max_retransmits and max_packet_life_time cannot be combined",
+				dataChannel.Label)
+		}
+	}
+
+	if c.Network.SFU.KeyframeInterval < 0 {
+		return fmt.Errorf("Invalid network.sfu.keyframe_interval: %s, must not be negative",
+			c.Network.SFU.KeyframeInterval)
+	}
+
+	if c.Network.SFU.QualityInterval < 0 {
+		return fmt.Errorf("Invalid network.sfu.quality_interval: %s, must not be negative",
+			c.Network.SFU.QualityInterval)
+	}
+
+	// Speakers are detected from the size of Opus packets because the audio
+	// level header extension cannot be negotiated, so last_n requires clients
+	// to send audio with DTX (usedtx=1). Without it silence is encoded in
+	// packets as large as speech and every client is considered speaking.
+	if c.Network.SFU.LastN < 0 {
+		return fmt.Errorf("Invalid network.sfu.last_n: %d, must not be negative",
+			c.Network.SFU.LastN)
+	}
+
+	if c.Network.SFU.ReorderDepth < 0 {
+		return fmt.Errorf("Invalid network.sfu.reorder_depth: %d, must not be negative",
+			c.Network.SFU.ReorderDepth)
+	}
+
+	if c.Network.SFU.ReorderTimeout < 0 {
+		return fmt.Errorf("Invalid network.sfu.reorder_timeout: %s, must not be negative",
+			c.Network.SFU.ReorderTimeout)
+	}
+
+	if c.Network.SFU.MaxQueuedTransceivers < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_queued_transceivers: %d, must not be negative",
+			c.Network.SFU.MaxQueuedTransceivers)
+	}
+
+	switch c.Network.SFU.QueuedTransceiversOverflowPolicy {
+	case "", OverflowPolicyDropNewest, OverflowPolicyDropOldest, OverflowPolicyCloseConnection:
+	default:
+		return fmt.Errorf("Invalid network.sfu.queued_transceivers_overflow_policy: %q, expected one of: %s, %s, %s",
+			c.Network.SFU.QueuedTransceiversOverflowPolicy, OverflowPolicyDropNewest, OverflowPolicyDropOldest, OverflowPolicyCloseConnection)
+	}
+
+	if c.Network.SFU.NegotiationTimeout < 0 {
+		return fmt.Errorf("Invalid network.sfu.negotiation_timeout: %s, must not be negative",
+			c.Network.SFU.NegotiationTimeout)
+	}
+
+	if c.Network.SFU.MaxConcurrentNegotiations < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_concurrent_negotiations: %d, must not be negative",
+			c.Network.SFU.MaxConcurrentNegotiations)
+	}
+
+	if _, err := DTLSCertificate(c.Network.SFU.DTLSCert, c.Network.SFU.DTLSKey); err != nil {
+		return fmt.Errorf("Invalid network.sfu.dtls_cert or network.sfu.dtls_key: %w", err)
+	}
+
+	if c.Network.SFU.MaxSDPSize < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_sdp_size: %d, must not be negative",
+			c.Network.SFU.MaxSDPSize)
+	}
+
+	if c.Network.SFU.MaxCandidates < 0 {
+		return fmt.Errorf("Invalid network.sfu.max_candidates: %d, must not be negative",
+			c.Network.SFU.MaxCandidates)
+	}
+
+	if c.Network.SFU.DSCP < 0 || c.Network.SFU.DSCP > 63 {
+		return fmt.Errorf("Invalid network.sfu.dscp: %d, must be between 0 and 63",
+			c.Network.SFU.DSCP)
+	}
+
+	for _, cidr := range c.Network.SFU.CandidateAllowCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid network.sfu.candidate_allow_cidrs: %w", err)
+		}
+	}
+
+	for _, cidr := range c.Network.SFU.CandidateDenyCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid network.sfu.candidate_deny_cidrs: %w", err)
+		}
+	}
+
+	if c.Network.AutoSFUThreshold < 0 {
+		return fmt.Errorf("Invalid network.auto_sfu_threshold: %d, must not be negative",
+			c.Network.AutoSFUThreshold)
+	}
+
+	if c.Rooms.MaxDuration < 0 {
+		return fmt.Errorf("Invalid rooms.max_duration: %s, must not be negative",
+			c.Rooms.MaxDuration)
+	}
+
+	if c.Rooms.ClientQuota < 0 {
+		return fmt.Errorf("Invalid rooms.client_quota: %d, must not be negative",
+			c.Rooms.ClientQuota)
+	}
+
+	if c.Rooms.ClientQuotaWindow < 0 {
+		return fmt.Errorf("Invalid rooms.client_quota_window: %s, must not be negative",
+			c.Rooms.ClientQuotaWindow)
+	}
+
+	if c.Store.PresenceDebounce < 0 {
+		return fmt.Errorf("Invalid store.presence_debounce: %s, must not be negative",
+			c.Store.PresenceDebounce)
+	}
+
+	if c.Store.RoomPasswordTTL < 0 {
+		return fmt.Errorf("Invalid store.room_password_ttl: %s, must not be negative",
+			c.Store.RoomPasswordTTL)
+	}
+
+	if c.Store.Redis.PublishTimeout < 0 {
+		return fmt.Errorf("Invalid store.redis.publish_timeout: %s, must not be negative",
+			c.Store.Redis.PublishTimeout)
+	}
+
+	if c.Store.Redis.PingInterval < 0 {
+		return fmt.Errorf("Invalid store.redis.ping_interval: %s, must not be negative",
+			c.Store.Redis.PingInterval)
+	}
+
+	switch c.Store.Redis.Serializer {
+	case "", SerializerTypeJSON, SerializerTypeProtobuf:
+	default:
+		return fmt.Errorf("Invalid store.redis.serializer: %q, expected one of: %s, %s",
+			c.Store.Redis.Serializer, SerializerTypeJSON, SerializerTypeProtobuf)
+	}
+
+	switch c.Network.Serializer {
+	case "", SerializerTypeJSON, SerializerTypeProtobuf, SerializerTypeBinary:
+	default:
+		return fmt.Errorf("Invalid network.serializer: %q, expected one of: %s, %s, %s",
+			c.Network.Serializer, SerializerTypeJSON, SerializerTypeProtobuf, SerializerTypeBinary)
+	}
+
+	switch c.WS.Compression {
+	case "", CompressionDisabled, CompressionContextTakeover, CompressionNoContextTakeover:
+	default:
+		return fmt.Errorf("Invalid ws.compression: %q, expected one of: %s, %s, %s",
+			c.WS.Compression, CompressionDisabled, CompressionContextTakeover, CompressionNoContextTakeover)
+	}
+
+	switch c.WS.ClientIDMode {
+	case "", ClientIDModeClient, ClientIDModeServer:
+	default:
+		return fmt.Errorf("Invalid ws.client_id_mode: %q, expected one of: %s, %s",
+			c.WS.ClientIDMode, ClientIDModeClient, ClientIDModeServer)
+	}
+
+	if c.WS.SendQueueSize < 0 {
+		return fmt.Errorf("Invalid ws.send_queue_size: %d, must not be negative",
+			c.WS.SendQueueSize)
+	}
+
+	switch c.WS.SendQueueOverflowPolicy {
+	case "", OverflowPolicyDropNewest, OverflowPolicyDropOldest, OverflowPolicyCloseConnection:
+	default:
+		return fmt.Errorf("Invalid ws.send_queue_overflow_policy: %q, expected one of: %s, %s, %s",
+			c.WS.SendQueueOverflowPolicy, OverflowPolicyDropNewest, OverflowPolicyDropOldest, OverflowPolicyCloseConnection)
+	}
+
+	if _, err := regexp.Compile(c.WS.RoomNamePattern); err != nil {
+		return fmt.Errorf("Invalid ws.room_name_pattern: %w", err)
+	}
+
+	if _, err := regexp.Compile(c.WS.MetadataPattern); err != nil {
+		return fmt.Errorf("Invalid ws.metadata_pattern: %w", err)
+	}
+
+	if c.WS.MetadataMaxLength < 0 {
+		return fmt.Errorf("Invalid ws.metadata_max_length: %d, must not be negative",
+			c.WS.MetadataMaxLength)
+	}
+
+	if c.WS.MaxFrameRate < 0 {
+		return fmt.Errorf("Invalid ws.max_frame_rate: %d, must not be negative",
+			c.WS.MaxFrameRate)
+	}
+
+	if limit := c.WS.ReadLimit; limit != 0 && (limit < MinReadLimit || limit > MaxReadLimit) {
+		return fmt.Errorf("Invalid ws.read_limit: %d, must be between %d and %d",
+			limit, MinReadLimit, MaxReadLimit)
+	}
+
+	switch c.WS.UnknownMessagePolicy {
+	case "", UnknownMessagePolicyIgnore, UnknownMessagePolicyLog, UnknownMessagePolicyReject:
+	default:
+		return fmt.Errorf("Invalid ws.unknown_message_policy: %q, expected one of: %s, %s, %s",
+			c.WS.UnknownMessagePolicy, UnknownMessagePolicyIgnore, UnknownMessagePolicyLog, UnknownMessagePolicyReject)
+	}
+
+	if c.WS.ReconnectWindow < 0 {
+		return fmt.Errorf("Invalid ws.reconnect_window: %s, must not be negative",
+			c.WS.ReconnectWindow)
+	}
+
+	for _, cidr := range c.WS.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid ws.trusted_proxies: %w", err)
+		}
+	}
+
+	for _, webhookURL := range c.Webhooks.URLs {
+		u, err := url.Parse(webhookURL)
+		if err != nil {
+			return fmt.Errorf("Invalid webhooks.urls: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid webhooks.urls: %q, expected an http or https URL", webhookURL)
+		}
+	}
+
+	if c.Webhooks.MaxRetries < 0 {
+		return fmt.Errorf("Invalid webhooks.max_retries: %d, must not be negative",
+			c.Webhooks.MaxRetries)
+	}
+
+	if c.Webhooks.RetryDelay < 0 {
+		return fmt.Errorf("Invalid webhooks.retry_delay: %s, must not be negative",
+			c.Webhooks.RetryDelay)
+	}
+
+	if c.Webhooks.Timeout < 0 {
+		return fmt.Errorf("Invalid webhooks.timeout: %s, must not be negative",
+			c.Webhooks.Timeout)
+	}
+
+	if port := c.Admin.BindPort; port < 0 || port > 65535 {
+		return fmt.Errorf("Invalid admin.bind_port: %d, must be between 0 and 65535", port)
+	}
+
+	if c.Admin.BindPort != 0 && c.Admin.BindPort == c.BindPort && c.BindSocket == "" {
+		return fmt.Errorf("Invalid admin.bind_port: %d, must differ from bind_port", c.Admin.BindPort)
+	}
+
+	return nil
+}
+
+// Returns true when any of the ICE servers has a turn or turns URL.
+func HasTURNServer(iceServers []ICEServer) bool {
+	for _, iceServer := range iceServers {
+		for _, url := range iceServer.URLs {
+			switch strings.SplitN(url, ":", 2)[0] {
+			case "turn", "turns":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the keys of ice_servers and of each room in room_ice_servers that
+// have no TURN server, sorted by room.
+func missingTURNServers(c Config) (keys []string) {
+	if !HasTURNServer(c.ICEServers) {
+		keys = append(keys, "ice_servers")
+	}
+
+	rooms := make([]string, 0, len(c.RoomICEServers))
+	for room := range c.RoomICEServers {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	for _, room := range rooms {
+		if !HasTURNServer(c.RoomICEServers[room]) {
+			keys = append(keys, "room_ice_servers."+room)
+		}
+	}
+	return keys
+}
+
+// Returns a warning to log at startup when ice_servers or any of the
+// room_ice_servers have no TURN server and the TURN policy is warn, or an
+// empty string.
+func TURNWarning(c Config) string {
+	if c.TURNPolicy != TURNPolicyWarn {
+		return ""
+	}
+	missing := missingTURNServers(c)
+	if len(missing) == 0 {
+		return ""
+	}
+	return "No TURN server configured in " + strings.Join(missing, ", ") +
+		", clients behind symmetric NATs will not be able to connect. " +
+		"Add a TURN server, or set turn_policy to ignore to hide this warning"
+}
+
+// Checks the URL schemes of an ICE server and that TURN servers have
+// credentials configured. Credentials are optional for STUN servers.
+func validateICEServer(iceServer ICEServer) error {
+	switch iceServer.AuthType {
+	case AuthTypeNone:
+	case AuthTypeSecret:
+		if iceServer.AuthSecret.Secret == "" {
+			return fmt.Errorf("Invalid ice_servers: %v, auth_secret.secret is required for auth_type: %s",
+				iceServer.URLs, AuthTypeSecret)
+		}
+	default:
+		return fmt.Errorf("Invalid ice_servers: %v, auth_type: %q, expected one of: %q, %s",
+			iceServer.URLs, iceServer.AuthType, AuthTypeNone, AuthTypeSecret)
+	}
+
+	for _, url := range iceServer.URLs {
+		scheme := strings.SplitN(url, ":", 2)[0]
+		switch scheme {
+		case "stun", "stuns":
+		case "turn", "turns":
+			if iceServer.AuthType == AuthTypeNone {
+				return fmt.Errorf("Invalid ice_servers: %s, auth_type: %s is required for %s servers",
+					url, AuthTypeSecret, scheme)
+			}
+		default:
+			return fmt.Errorf("Invalid ice_servers: %s, expected one of the schemes: stun, stuns, turn, turns", url)
+		}
+	}
+
+	return nil
+}
+
+func validateTransceiverDirection(name string, direction TransceiverDirection) error {
+	switch direction {
+	case "", TransceiverDirectionSendrecv, TransceiverDirectionRecvonly:
+		return nil
+	default:
+		return fmt.Errorf("Invalid network.sfu.%s: %q, expected one of: %s, %s",
+			name, direction, TransceiverDirectionSendrecv, TransceiverDirectionRecvonly)
+	}
+}
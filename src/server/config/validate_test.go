@@ -0,0 +1,805 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate_ipFamilies(t *testing.T) {
+	for _, ipFamily := range []config.IPFamily{
+		"",
+		config.IPFamilyIPv4,
+		config.IPFamilyIPv6,
+		config.IPFamilyBoth,
+	} {
+		var c config.Config
+		c.Network.SFU.IPFamilies = ipFamily
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", ipFamily)
+	}
+
+	var c config.Config
+	c.Network.SFU.IPFamilies = "ipv5"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.ip_families", err.Error())
+}
+
+func TestValidate_keepalive(t *testing.T) {
+	for _, keepalive := range []time.Duration{0, time.Second} {
+		var c config.Config
+		c.Network.SFU.Keepalive = keepalive
+		assert.Nil(t, config.Validate(c), "expected %s to be valid", keepalive)
+	}
+
+	var c config.Config
+	c.Network.SFU.Keepalive = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.keepalive", err.Error())
+}
+
+func TestValidate_maxSDPSize(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.MaxSDPSize = 1024
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.MaxSDPSize = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_sdp_size", err.Error())
+}
+
+func TestValidate_maxCandidates(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.MaxCandidates = 20
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.MaxCandidates = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_candidates", err.Error())
+}
+
+func TestValidate_dscp(t *testing.T) {
+	for _, dscp := range []int{0, 46, 63} {
+		var c config.Config
+		c.Network.SFU.DSCP = dscp
+		assert.Nil(t, config.Validate(c), "expected %d to be valid", dscp)
+	}
+
+	for _, dscp := range []int{-1, 64} {
+		var c config.Config
+		c.Network.SFU.DSCP = dscp
+		err := config.Validate(c)
+		require.NotNil(t, err)
+		assert.Regexp(t, "Invalid network.sfu.dscp", err.Error())
+	}
+}
+
+func TestValidate_autoSFUThreshold(t *testing.T) {
+	var c config.Config
+	c.Network.AutoSFUThreshold = 4
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.AutoSFUThreshold = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.auto_sfu_threshold", err.Error())
+}
+
+func TestValidate_serializer(t *testing.T) {
+	for _, serializer := range []config.SerializerType{
+		"",
+		config.SerializerTypeJSON,
+		config.SerializerTypeProtobuf,
+	} {
+		var c config.Config
+		c.Store.Redis.Serializer = serializer
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", serializer)
+	}
+
+	for _, serializer := range []config.SerializerType{"xml", config.SerializerTypeBinary} {
+		var c config.Config
+		c.Store.Redis.Serializer = serializer
+		err := config.Validate(c)
+		require.NotNil(t, err, "expected %q to be invalid", serializer)
+		assert.Regexp(t, "Invalid store.redis.serializer", err.Error())
+	}
+}
+
+func TestValidate_networkSerializer(t *testing.T) {
+	for _, serializer := range []config.SerializerType{
+		"",
+		config.SerializerTypeJSON,
+		config.SerializerTypeProtobuf,
+		config.SerializerTypeBinary,
+	} {
+		var c config.Config
+		c.Network.Serializer = serializer
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", serializer)
+	}
+
+	var c config.Config
+	c.Network.Serializer = "xml"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.serializer", err.Error())
+}
+
+func TestValidate_presenceDebounce(t *testing.T) {
+	var c config.Config
+	c.Store.PresenceDebounce = 250 * time.Millisecond
+	assert.Nil(t, config.Validate(c))
+
+	c.Store.PresenceDebounce = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid store.presence_debounce", err.Error())
+}
+
+func TestValidate_roomPasswordTTL(t *testing.T) {
+	var c config.Config
+	c.Store.RoomPasswordTTL = time.Hour
+	assert.Nil(t, config.Validate(c))
+
+	c.Store.RoomPasswordTTL = -time.Hour
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid store.room_password_ttl", err.Error())
+}
+
+func TestValidate_publishTimeout(t *testing.T) {
+	var c config.Config
+	c.Store.Redis.PublishTimeout = time.Second
+	assert.Nil(t, config.Validate(c))
+
+	c.Store.Redis.PublishTimeout = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid store.redis.publish_timeout", err.Error())
+}
+
+func TestValidate_pingInterval(t *testing.T) {
+	var c config.Config
+	c.Store.Redis.PingInterval = time.Minute
+	assert.Nil(t, config.Validate(c))
+
+	c.Store.Redis.PingInterval = -time.Minute
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid store.redis.ping_interval", err.Error())
+}
+
+func TestValidate_compression(t *testing.T) {
+	for _, compression := range []config.Compression{
+		"",
+		config.CompressionDisabled,
+		config.CompressionContextTakeover,
+		config.CompressionNoContextTakeover,
+	} {
+		var c config.Config
+		c.WS.Compression = compression
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", compression)
+	}
+
+	var c config.Config
+	c.WS.Compression = "gzip"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.compression", err.Error())
+}
+
+func TestValidate_clientIDMode(t *testing.T) {
+	for _, clientIDMode := range []config.ClientIDMode{
+		"",
+		config.ClientIDModeClient,
+		config.ClientIDModeServer,
+	} {
+		var c config.Config
+		c.WS.ClientIDMode = clientIDMode
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", clientIDMode)
+	}
+
+	var c config.Config
+	c.WS.ClientIDMode = "random"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.client_id_mode", err.Error())
+}
+
+func TestValidate_sendQueue(t *testing.T) {
+	for _, overflowPolicy := range []config.OverflowPolicy{
+		"",
+		config.OverflowPolicyDropNewest,
+		config.OverflowPolicyDropOldest,
+		config.OverflowPolicyCloseConnection,
+	} {
+		var c config.Config
+		c.WS.SendQueueOverflowPolicy = overflowPolicy
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", overflowPolicy)
+	}
+
+	var c config.Config
+	c.WS.SendQueueOverflowPolicy = "block"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.send_queue_overflow_policy", err.Error())
+
+	c = config.Config{}
+	c.WS.SendQueueSize = -1
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.send_queue_size", err.Error())
+}
+
+func TestValidate_tls(t *testing.T) {
+	var c config.Config
+	c.TLS.MinVersion = "1.3"
+	c.TLS.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	assert.Nil(t, config.Validate(c))
+
+	c.TLS.MinVersion = "1.4"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid tls.min_version", err.Error())
+
+	c.TLS.MinVersion = ""
+	c.TLS.CipherSuites = []string{"TLS_UNKNOWN"}
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid tls.cipher_suites", err.Error())
+}
+
+func TestValidate_roomNamePattern(t *testing.T) {
+	var c config.Config
+	c.WS.RoomNamePattern = "[a-z0-9-]{4,32}"
+	assert.Nil(t, config.Validate(c))
+
+	c.WS.RoomNamePattern = "[a-z"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.room_name_pattern", err.Error())
+}
+
+func TestValidate_metadata(t *testing.T) {
+	var c config.Config
+	c.WS.MetadataPattern = `[\pL\pN _.-]*`
+	c.WS.MetadataMaxLength = 32
+	assert.Nil(t, config.Validate(c))
+
+	c.WS.MetadataPattern = "[a-z"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.metadata_pattern", err.Error())
+
+	c.WS.MetadataPattern = ""
+	c.WS.MetadataMaxLength = -1
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.metadata_max_length", err.Error())
+}
+
+func TestValidate_reconnectWindow(t *testing.T) {
+	var c config.Config
+	c.WS.ReconnectWindow = 30 * time.Second
+	assert.Nil(t, config.Validate(c))
+
+	c.WS.ReconnectWindow = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.reconnect_window", err.Error())
+}
+
+func TestValidate_maxFrameRate(t *testing.T) {
+	var c config.Config
+	c.WS.MaxFrameRate = 100
+	assert.Nil(t, config.Validate(c))
+
+	c.WS.MaxFrameRate = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.max_frame_rate", err.Error())
+}
+
+func TestValidate_readLimit(t *testing.T) {
+	for _, limit := range []int{0, config.MinReadLimit, 65536, config.MaxReadLimit} {
+		var c config.Config
+		c.WS.ReadLimit = limit
+		assert.Nil(t, config.Validate(c), "expected %d to be valid", limit)
+	}
+
+	for _, limit := range []int{-1, config.MinReadLimit - 1, config.MaxReadLimit + 1} {
+		var c config.Config
+		c.WS.ReadLimit = limit
+		err := config.Validate(c)
+		require.NotNil(t, err, "expected %d to be invalid", limit)
+		assert.Regexp(t, "Invalid ws.read_limit", err.Error())
+	}
+}
+
+func TestValidate_maxSignalSize(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.MaxSignalDepth = 8
+	c.Network.SFU.MaxSignalElements = 256
+	assert.Nil(t, config.Validate(c))
+
+	c = config.Config{}
+	c.Network.SFU.MaxSignalDepth = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_signal_depth", err.Error())
+
+	c = config.Config{}
+	c.Network.SFU.MaxSignalElements = -1
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_signal_elements", err.Error())
+}
+
+func TestValidate_transceiverDirection(t *testing.T) {
+	for _, direction := range []config.TransceiverDirection{
+		"",
+		config.TransceiverDirectionSendrecv,
+		config.TransceiverDirectionRecvonly,
+	} {
+		var c config.Config
+		c.Network.SFU.InitialTransceiverDirection = direction
+		c.Network.SFU.RequestedTransceiverDirection = direction
+		assert.Nil(t, config.Validate(c))
+	}
+
+	var c config.Config
+	c.Network.SFU.InitialTransceiverDirection = "sendonly"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.initial_transceiver_direction", err.Error())
+
+	c = config.Config{}
+	c.Network.SFU.RequestedTransceiverDirection = "inactive"
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.requested_transceiver_direction", err.Error())
+}
+
+func TestValidate_unknownMessagePolicy(t *testing.T) {
+	for _, policy := range []config.UnknownMessagePolicy{
+		"",
+		config.UnknownMessagePolicyIgnore,
+		config.UnknownMessagePolicyLog,
+		config.UnknownMessagePolicyReject,
+	} {
+		var c config.Config
+		c.WS.UnknownMessagePolicy = policy
+		assert.Nil(t, config.Validate(c), "expected %q to be valid", policy)
+	}
+
+	var c config.Config
+	c.WS.UnknownMessagePolicy = "close"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.unknown_message_policy", err.Error())
+}
+
+func TestValidate_candidateCIDRs(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.CandidateAllowCIDRs = []string{"10.0.0.0/8", "fd00::/8"}
+	c.Network.SFU.CandidateDenyCIDRs = []string{"10.8.0.0/16"}
+	assert.Nil(t, config.Validate(c))
+
+	c = config.Config{}
+	c.Network.SFU.CandidateAllowCIDRs = []string{"10.0.0.1"}
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.candidate_allow_cidrs", err.Error())
+
+	c = config.Config{}
+	c.Network.SFU.CandidateDenyCIDRs = []string{"10.0.0.0/33"}
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.candidate_deny_cidrs", err.Error())
+}
+
+func TestValidate_disconnectGracePeriod(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.DisconnectGracePeriod = 5 * time.Second
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.DisconnectGracePeriod = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.disconnect_grace_period", err.Error())
+}
+
+func TestValidate_maxNegotiations(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.MaxNegotiations = 30
+	c.Network.SFU.MaxNegotiationsWindow = time.Minute
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.MaxNegotiations = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_negotiations:", err.Error())
+
+	c.Network.SFU.MaxNegotiations = 30
+	c.Network.SFU.MaxNegotiationsWindow = -time.Second
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_negotiations_window", err.Error())
+}
+
+func TestValidate_negotiationTimeout(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.NegotiationTimeout = 30 * time.Second
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.NegotiationTimeout = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.negotiation_timeout", err.Error())
+}
+
+func TestValidate_keyframeInterval(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.KeyframeInterval = time.Second
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.KeyframeInterval = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.keyframe_interval", err.Error())
+}
+
+func TestValidate_qualityInterval(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.QualityInterval = time.Second
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.QualityInterval = -time.Second
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.quality_interval", err.Error())
+}
+
+func TestValidate_lastN(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.LastN = 3
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.LastN = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.last_n", err.Error())
+}
+
+func TestValidate_reorder(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.ReorderDepth = 8
+	c.Network.SFU.ReorderTimeout = 30 * time.Millisecond
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.ReorderDepth = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.reorder_depth", err.Error())
+
+	c.Network.SFU.ReorderDepth = 8
+	c.Network.SFU.ReorderTimeout = -time.Millisecond
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.reorder_timeout", err.Error())
+}
+
+func TestValidate_maxQueuedTransceivers(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.MaxQueuedTransceivers = 4
+	c.Network.SFU.QueuedTransceiversOverflowPolicy = config.OverflowPolicyCloseConnection
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.MaxQueuedTransceivers = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_queued_transceivers", err.Error())
+
+	c.Network.SFU.MaxQueuedTransceivers = 4
+	c.Network.SFU.QueuedTransceiversOverflowPolicy = "block"
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.queued_transceivers_overflow_policy", err.Error())
+}
+
+func TestValidate_maxConcurrentNegotiations(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.MaxConcurrentNegotiations = 8
+	assert.Nil(t, config.Validate(c))
+
+	c.Network.SFU.MaxConcurrentNegotiations = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.max_concurrent_negotiations", err.Error())
+}
+
+func TestValidate_dtlsCertificate(t *testing.T) {
+	var c config.Config
+	c.Network.SFU.DTLSCert = "missing.pem"
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid network.sfu.dtls_cert or network.sfu.dtls_key", err.Error())
+}
+
+func TestValidate_iceServers(t *testing.T) {
+	secret := config.ICEServer{AuthType: config.AuthTypeSecret}
+	secret.AuthSecret.Username = "user"
+	secret.AuthSecret.Secret = "secret"
+
+	for _, tc := range []struct {
+		url    string
+		server config.ICEServer
+		err    string
+	}{
+		{"stun:stun.example.com", config.ICEServer{}, ""},
+		{"stun:stun.example.com", secret, ""},
+		{"stuns:stun.example.com", config.ICEServer{}, ""},
+		{"stuns:stun.example.com", secret, ""},
+		{"turn:turn.example.com", secret, ""},
+		{"turn:turn.example.com", config.ICEServer{}, "auth_type: secret is required for turn servers"},
+		{"turns:turn.example.com:5349", secret, ""},
+		{"turns:turn.example.com:5349", config.ICEServer{}, "auth_type: secret is required for turns servers"},
+		{"turn:turn.example.com", config.ICEServer{AuthType: config.AuthTypeSecret}, "auth_secret.secret is required"},
+		{"stun:stun.example.com", config.ICEServer{AuthType: "static"}, "auth_type: \"static\""},
+		{"http://stun.example.com", config.ICEServer{}, "expected one of the schemes"},
+	} {
+		var c config.Config
+		tc.server.URLs = []string{tc.url}
+		c.ICEServers = []config.ICEServer{tc.server}
+		err := config.Validate(c)
+		if tc.err == "" {
+			assert.Nil(t, err, "expected %s with auth_type: %q to be valid", tc.url, tc.server.AuthType)
+			continue
+		}
+		require.NotNil(t, err, "expected %s with auth_type: %q to be invalid", tc.url, tc.server.AuthType)
+		assert.Regexp(t, "Invalid ice_servers", err.Error())
+		assert.Contains(t, err.Error(), tc.err)
+	}
+}
+
+func TestValidate_roomICEServers(t *testing.T) {
+	var c config.Config
+	c.RoomICEServers = map[string][]config.ICEServer{
+		"room1": {{URLs: []string{"stun:stun.example.com"}}},
+	}
+	assert.Nil(t, config.Validate(c))
+
+	c.RoomICEServers["room2"] = []config.ICEServer{{URLs: []string{"turn:turn.example.com"}}}
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid room_ice_servers.room2", err.Error())
+	assert.Contains(t, err.Error(), "auth_type: secret is required for turn servers")
+}
+
+func TestValidate_roomsMaxDuration(t *testing.T) {
+	var c config.Config
+	c.Rooms.MaxDuration = time.Hour
+	assert.Nil(t, config.Validate(c))
+
+	c.Rooms.MaxDuration = -time.Hour
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid rooms.max_duration", err.Error())
+}
+
+func TestValidate_roomsClientQuota(t *testing.T) {
+	var c config.Config
+	c.Rooms.ClientQuota = 1000
+	c.Rooms.ClientQuotaWindow = time.Minute
+	assert.Nil(t, config.Validate(c))
+
+	c.Rooms.ClientQuota = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid rooms.client_quota", err.Error())
+
+	c.Rooms.ClientQuota = 1000
+	c.Rooms.ClientQuotaWindow = -time.Minute
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid rooms.client_quota_window", err.Error())
+}
+
+func TestValidate_trustedProxies(t *testing.T) {
+	var c config.Config
+	c.WS.TrustedProxies = []string{"10.0.0.0/8", "fd00::/8"}
+	assert.Nil(t, config.Validate(c))
+
+	c.WS.TrustedProxies = []string{"10.0.0.1"}
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid ws.trusted_proxies", err.Error())
+}
+
+func TestValidate_webhooks(t *testing.T) {
+	var c config.Config
+	c.Webhooks.URLs = []string{"https://example.com/hook", "http://localhost:8080"}
+	c.Webhooks.MaxRetries = 3
+	c.Webhooks.RetryDelay = time.Second
+	c.Webhooks.Timeout = time.Second
+	assert.Nil(t, config.Validate(c))
+
+	for _, invalidURL := range []string{"example.com/hook", "ftp://example.com", "https://"} {
+		c.Webhooks.URLs = []string{invalidURL}
+		err := config.Validate(c)
+		require.NotNil(t, err, "expected an error for %s", invalidURL)
+		assert.Regexp(t, "Invalid webhooks.urls", err.Error())
+	}
+	c.Webhooks.URLs = nil
+
+	c.Webhooks.MaxRetries = -1
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid webhooks.max_retries", err.Error())
+	c.Webhooks.MaxRetries = 0
+
+	c.Webhooks.RetryDelay = -time.Second
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid webhooks.retry_delay", err.Error())
+	c.Webhooks.RetryDelay = 0
+
+	c.Webhooks.Timeout = -time.Second
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid webhooks.timeout", err.Error())
+}
+
+func TestValidate_admin(t *testing.T) {
+	var c config.Config
+	c.BindPort = 3000
+	c.Admin.BindPort = 9090
+	assert.Nil(t, config.Validate(c))
+
+	c.BindSocket = "/tmp/peer-calls.sock"
+	c.Admin.BindPort = 3000
+	assert.Nil(t, config.Validate(c), "the main listener does not use the port")
+
+	c.BindSocket = ""
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid admin.bind_port: 3000, must differ", err.Error())
+
+	for _, port := range []int{-1, 65536} {
+		c = config.Config{}
+		c.Admin.BindPort = port
+		err = config.Validate(c)
+		require.NotNil(t, err, "expected %d to be invalid", port)
+		assert.Regexp(t, "Invalid admin.bind_port", err.Error())
+	}
+}
+
+func TestValidate_turnPolicy(t *testing.T) {
+	stun := config.ICEServer{URLs: []string{"stun:stun.example.com"}}
+	turn := config.ICEServer{URLs: []string{"turns:turn.example.com"}, AuthType: config.AuthTypeSecret}
+	turn.AuthSecret.Secret = "secret"
+
+	for _, policy := range []config.TURNPolicy{"", config.TURNPolicyIgnore, config.TURNPolicyWarn} {
+		var c config.Config
+		c.TURNPolicy = policy
+		c.ICEServers = []config.ICEServer{stun}
+		assert.Nil(t, config.Validate(c), "expected %q to be valid without TURN", policy)
+	}
+
+	var c config.Config
+	c.TURNPolicy = config.TURNPolicyStrict
+	c.ICEServers = []config.ICEServer{stun}
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "no TURN server configured", err.Error())
+
+	c.ICEServers = []config.ICEServer{stun, turn}
+	assert.Nil(t, config.Validate(c))
+
+	c.RoomICEServers = map[string][]config.ICEServer{
+		"room1": {turn},
+		"room2": {stun},
+	}
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid room_ice_servers.room2: no TURN server configured", err.Error())
+
+	c.RoomICEServers["room2"] = []config.ICEServer{turn}
+	assert.Nil(t, config.Validate(c))
+
+	c.TURNPolicy = "error"
+	err = config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid turn_policy", err.Error())
+}
+
+func TestTURNWarning(t *testing.T) {
+	var c config.Config
+	c.TURNPolicy = config.TURNPolicyWarn
+	c.ICEServers = []config.ICEServer{{URLs: []string{"stun:stun.example.com"}}}
+	assert.Regexp(t, "No TURN server configured", config.TURNWarning(c))
+
+	c.TURNPolicy = config.TURNPolicyIgnore
+	assert.Equal(t, "", config.TURNWarning(c))
+
+	c.TURNPolicy = config.TURNPolicyWarn
+	c.ICEServers = append(c.ICEServers, config.ICEServer{URLs: []string{"turn:turn.example.com"}})
+	assert.Equal(t, "", config.TURNWarning(c))
+
+	c.RoomICEServers = map[string][]config.ICEServer{
+		"room1": {{URLs: []string{"stun:stun.example.com"}}},
+	}
+	assert.Regexp(t, "No TURN server configured in room_ice_servers.room1,", config.TURNWarning(c))
+}
+
+func TestValidate_dataChannels(t *testing.T) {
+	maxRetransmits := uint16(0)
+	maxPacketLifeTime := uint16(500)
+
+	var c config.Config
+	c.Network.SFU.DataChannels = []config.DataChannelConfig{
+		{Label: "data"},
+		{Label: "telemetry", Unordered: true, MaxRetransmits: &maxRetransmits},
+		{Label: "cursor", MaxPacketLifeTime: &maxPacketLifeTime},
+	}
+	assert.Nil(t, config.Validate(c))
+
+	for _, tc := range []struct {
+		dataChannels []config.DataChannelConfig
+		err          string
+	}{
+		{[]config.DataChannelConfig{{}}, "label is required"},
+		{[]config.DataChannelConfig{{Label: "data"}, {Label: "data"}}, "duplicate label"},
+		{[]config.DataChannelConfig{{
+			Label:             "data",
+			MaxRetransmits:    &maxRetransmits,
+			MaxPacketLifeTime: &maxPacketLifeTime,
+		}}, "cannot be combined"},
+	} {
+		c = config.Config{}
+		c.Network.SFU.DataChannels = tc.dataChannels
+		err := config.Validate(c)
+		require.NotNil(t, err)
+		assert.Regexp(t, "Invalid network.sfu.data_channels: .*"+tc.err, err.Error())
+	}
+}
+
+func TestValidate_namespaces(t *testing.T) {
+	var c config.Config
+	c.Namespaces = []string{"app1", "other_App-2"}
+	assert.Nil(t, config.Validate(c))
+
+	for _, tc := range []struct {
+		namespaces []string
+		err        string
+	}{
+		{[]string{""}, "must contain only"},
+		{[]string{"a/b"}, "must contain only"},
+		{[]string{"app1", "app1"}, "duplicate namespace"},
+	} {
+		c = config.Config{}
+		c.Namespaces = tc.namespaces
+		err := config.Validate(c)
+		require.NotNil(t, err)
+		assert.Regexp(t, "Invalid namespaces: .*"+tc.err, err.Error())
+	}
+}
+
+func TestValidate_namespaceRoomICEServers(t *testing.T) {
+	var c config.Config
+	c.Namespaces = []string{"app1"}
+	c.RoomICEServers = map[string][]config.ICEServer{
+		"app1/room1": {{URLs: []string{"stun:stun.example.com"}}},
+	}
+	assert.Nil(t, config.Validate(c))
+
+	c.Namespaces = nil
+	err := config.Validate(c)
+	require.NotNil(t, err)
+	assert.Regexp(t, `Invalid room_ice_servers.app1/room1: unknown namespace: "app1"`, err.Error())
+}
@@ -7,8 +7,10 @@ import (
 	"github.com/go-redis/redis/v7"
 	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/room"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/jeremija/peer-calls/src/server/ws/wsredis"
 )
 
@@ -17,37 +19,72 @@ type AdapterFactory struct {
 	subClient *redis.Client
 
 	NewAdapter func(room string) wsadapter.Adapter
+	// Store of the metadata of all rooms, shared by the adapters.
+	RoomMetadataStore wsadapter.RoomMetadataStore
+	// Finds the listed rooms of all instances when using Redis with
+	// persisted room metadata. Nil otherwise, see DirectoryDisabled.
+	RoomDirectoryStore room.DirectoryStore
+	// Set when using Redis without persisted room metadata. Whether a room is
+	// listed is then only known by the instance that set it, so the rooms of
+	// other instances cannot be listed.
+	DirectoryDisabled bool
 }
 
 var log = logger.GetLogger("adapterfactory")
 
 func NewAdapterFactory(c config.StoreConfig) *AdapterFactory {
 	f := AdapterFactory{}
+	params := wsadapter.Params{
+		ChatHistorySize:   c.ChatHistorySize,
+		RoomMetadataStore: wsadapter.NewMemoryRoomMetadataStore(),
+		RoomPasswordStore: wsadapter.NewMemoryRoomPasswordStore(c.RoomPasswordTTL),
+		PresenceDebounce:  c.PresenceDebounce,
+	}
 
 	switch c.Type {
 	case config.StoreTypeRedis:
 		addr := net.JoinHostPort(c.Redis.Host, strconv.Itoa(c.Redis.Port))
 		prefix := c.Redis.Prefix
-		log.Printf("Using RedisAdapter: %s with prefix %s", addr, prefix)
+		log.Printf("Using RedisAdapter: %s with prefix %s, serializer: %s", addr, prefix, c.Redis.Serializer)
+		params.Serializer = newSerializer(c.Redis.Serializer)
+		params.PublishTimeout = c.Redis.PublishTimeout
+		params.PingInterval = c.Redis.PingInterval
 		f.pubClient = redis.NewClient(&redis.Options{
 			Addr: addr,
 		})
 		f.subClient = redis.NewClient(&redis.Options{
 			Addr: addr,
 		})
+		params.RoomPasswordStore = wsredis.NewRedisRoomPasswordStore(f.pubClient, prefix, c.RoomPasswordTTL)
+		if c.Redis.PersistRoomMetadata {
+			params.RoomMetadataStore = wsredis.NewRedisRoomMetadataStore(f.pubClient, prefix)
+			f.RoomDirectoryStore = wsredis.NewRedisRoomDirectoryStore(f.pubClient, prefix)
+		} else {
+			f.DirectoryDisabled = true
+		}
 		f.NewAdapter = func(room string) wsadapter.Adapter {
-			return wsredis.NewRedisAdapter(f.pubClient, f.subClient, prefix, room)
+			return wsredis.NewRedisAdapterWithParams(f.pubClient, f.subClient, prefix, room, params)
 		}
 	default:
 		log.Printf("Using MemoryAdapter")
 		f.NewAdapter = func(room string) wsadapter.Adapter {
-			return wsmemory.NewMemoryAdapter(room)
+			return wsmemory.NewMemoryAdapterWithParams(room, params)
 		}
 	}
 
+	f.RoomMetadataStore = params.RoomMetadataStore
 	return &f
 }
 
+func newSerializer(serializerType config.SerializerType) wsmessage.SerializerDeserializer {
+	switch serializerType {
+	case config.SerializerTypeProtobuf:
+		return wsmessage.ProtoSerializer{}
+	default:
+		return wsmessage.ByteSerializer{}
+	}
+}
+
 func (a *AdapterFactory) Close() (err error) {
 	if a.pubClient != nil {
 		err = a.pubClient.Close()
@@ -14,14 +14,17 @@ func TestNewAdapterFactory_redis(t *testing.T) {
 	f := adapter.NewAdapterFactory(config.StoreConfig{
 		Type: "redis",
 		Redis: config.RedisConfig{
-			Prefix: "peercalls",
-			Host:   "localhost",
-			Port:   6379,
+			Prefix:              "peercalls",
+			Host:                "localhost",
+			Port:                6379,
+			PersistRoomMetadata: true,
 		},
 	})
 
 	redisAdapter, ok := f.NewAdapter("test-room").(*wsredis.RedisAdapter)
 	assert.True(t, ok)
+	assert.IsType(t, &wsredis.RedisRoomDirectoryStore{}, f.RoomDirectoryStore)
+	assert.False(t, f.DirectoryDisabled)
 
 	err := redisAdapter.Close()
 	assert.Nil(t, err)
@@ -34,4 +37,22 @@ func TestNewAdapterFactory_memory(t *testing.T) {
 
 	_, ok := f.NewAdapter("test-room").(*wsmemory.MemoryAdapter)
 	assert.True(t, ok)
+	assert.NotNil(t, f.RoomMetadataStore)
+	assert.Nil(t, f.RoomDirectoryStore)
+	assert.False(t, f.DirectoryDisabled)
+}
+
+func TestNewAdapterFactory_redis_directoryDisabled(t *testing.T) {
+	f := adapter.NewAdapterFactory(config.StoreConfig{
+		Type: "redis",
+		Redis: config.RedisConfig{
+			Prefix: "peercalls",
+			Host:   "localhost",
+			Port:   6379,
+		},
+	})
+	defer f.Close()
+
+	assert.Nil(t, f.RoomDirectoryStore)
+	assert.True(t, f.DirectoryDisabled)
 }
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type correlationIDKey struct{}
+
+// Returns a random ID that is short enough to be added to every log line of
+// a connection.
+func NewCorrelationID() string {
+	b := make([]byte, 4)
+	// crypto/rand.Read only fails when the system source is unavailable, in
+	// which case the zero ID is still usable for logging
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Returns a context carrying the correlation ID of a connection, so that
+// components handling the connection can add it to their log lines.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// Returns the correlation ID set with WithCorrelationID, or an empty string.
+func CorrelationID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// PrefixLogger prefixes each line with a fixed string, e.g. a correlation ID.
+type PrefixLogger struct {
+	logger *Logger
+	prefix string
+}
+
+func NewPrefixLogger(logger *Logger, prefix string) *PrefixLogger {
+	return &PrefixLogger{logger: logger, prefix: prefix}
+}
+
+func (p *PrefixLogger) Printf(message string, values ...interface{}) {
+	p.logger.Printf(p.prefix+message, values...)
+}
+
+func (p *PrefixLogger) Println(values ...interface{}) {
+	if p.logger.Enabled {
+		p.logger.printf("%s", p.prefix+strings.TrimSuffix(fmt.Sprintln(values...), "\n"))
+	}
+}
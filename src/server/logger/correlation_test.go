@@ -0,0 +1,30 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationID(t *testing.T) {
+	id := logger.NewCorrelationID()
+	assert.Regexp(t, "^[0-9a-f]{8}$", id)
+	assert.NotEqual(t, id, logger.NewCorrelationID())
+
+	ctx := logger.WithCorrelationID(context.Background(), id)
+	assert.Equal(t, id, logger.CorrelationID(ctx))
+	assert.Equal(t, "", logger.CorrelationID(context.Background()))
+}
+
+func TestPrefixLogger(t *testing.T) {
+	var out bytes.Buffer
+	log := logger.NewPrefixLogger(logger.NewLogger("test", &out, true), "[abc] ")
+
+	log.Printf("a %s", "b")
+	log.Println("c")
+
+	assert.Regexp(t, `\[abc\] a b\n.*\[abc\] c\n$`, out.String())
+}
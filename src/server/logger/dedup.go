@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DedupLogger prints the first occurrence of a message and collapses
+// identical messages printed within the same window. The number of collapsed
+// messages is printed when the window ends.
+type DedupLogger struct {
+	logger *Logger
+	window time.Duration
+
+	mu      sync.Mutex
+	texts   []string
+	repeats map[string]int
+	timer   *time.Timer
+}
+
+func NewDedupLogger(logger *Logger, window time.Duration) *DedupLogger {
+	return &DedupLogger{
+		logger:  logger,
+		window:  window,
+		repeats: map[string]int{},
+	}
+}
+
+func (d *DedupLogger) Printf(message string, values ...interface{}) {
+	if !d.logger.Enabled {
+		return
+	}
+
+	text := fmt.Sprintf(message, values...)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if count, ok := d.repeats[text]; ok {
+		d.repeats[text] = count + 1
+		return
+	}
+
+	d.logger.printf("%s", text)
+	d.texts = append(d.texts, text)
+	d.repeats[text] = 0
+
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.window, d.Flush)
+	}
+}
+
+// Prints the number of collapsed messages and starts a new window.
+func (d *DedupLogger) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, text := range d.texts {
+		if count := d.repeats[text]; count > 0 {
+			d.logger.printf("%s (repeated %d times)", text, count)
+		}
+	}
+
+	d.texts = nil
+	d.repeats = map[string]int{}
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
@@ -0,0 +1,84 @@
+package logger_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuilder struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (b *syncBuilder) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *syncBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
+
+func TestDedupLogger_Printf(t *testing.T) {
+	var out syncBuilder
+	d := logger.NewDedupLogger(logger.NewLogger("dedup", &out, true), time.Minute)
+
+	for i := 0; i < 3; i++ {
+		d.Printf("candidate: %s", "a")
+		d.Printf("candidate: %s", "b")
+	}
+	d.Printf("candidate: %s", "c")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Regexp(t, "candidate: a$", lines[0])
+	assert.Regexp(t, "candidate: b$", lines[1])
+	assert.Regexp(t, "candidate: c$", lines[2])
+
+	d.Flush()
+
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 5, len(lines))
+	assert.Regexp(t, "candidate: a \\(repeated 2 times\\)$", lines[3])
+	assert.Regexp(t, "candidate: b \\(repeated 2 times\\)$", lines[4])
+
+	d.Printf("candidate: %s", "a")
+	d.Flush()
+
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 6, len(lines), "expected a new window after flush")
+	assert.Regexp(t, "candidate: a$", lines[5])
+}
+
+func TestDedupLogger_window(t *testing.T) {
+	var out syncBuilder
+	d := logger.NewDedupLogger(logger.NewLogger("dedup", &out, true), 20*time.Millisecond)
+
+	d.Printf("candidate")
+	d.Printf("candidate")
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(out.String(), "repeated") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Regexp(t, "candidate \\(repeated 1 times\\)\n$", out.String())
+}
+
+func TestDedupLogger_disabled(t *testing.T) {
+	var out syncBuilder
+	d := logger.NewDedupLogger(logger.NewLogger("dedup", &out, false), time.Minute)
+
+	d.Printf("candidate")
+	d.Printf("candidate")
+	d.Flush()
+
+	assert.Equal(t, "", out.String())
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/factory/adapter"
 	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/quota"
 	"github.com/jeremija/peer-calls/src/server/room"
 	"github.com/jeremija/peer-calls/src/server/routes"
 	"github.com/jeremija/peer-calls/src/server/server"
+	"github.com/jeremija/peer-calls/src/server/turncheck"
+	"github.com/jeremija/peer-calls/src/server/webhook"
 	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
 )
 
@@ -26,6 +34,32 @@ func panicOnError(err error, message string) {
 
 var log = logger.GetLogger("main")
 
+// Time clients have to be notified and disconnected after the server
+// receives a signal to stop.
+const shutdownTimeout = 10 * time.Second
+
+// Logs the result of allocating a relay on each TURN server. Returns an
+// error when any of the checks failed.
+func selfTestTURN(iceServers []config.ICEServer) error {
+	failed := 0
+	for _, result := range turncheck.Check(iceServers, turncheck.Params{}) {
+		if result.Skipped {
+			log.Printf("TURN self-test skipped, only UDP is checked: %s", result.URL)
+			continue
+		}
+		if result.Err != nil {
+			log.Printf("TURN self-test failed: %s: %s", result.URL, result.Err)
+			failed++
+			continue
+		}
+		log.Printf("TURN self-test passed: %s", result.URL)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d TURN server checks failed", failed)
+	}
+	return nil
+}
+
 func init() {
 	logger.SetDefaultEnabled([]string{
 		"-sdp",
@@ -37,32 +71,178 @@ func init() {
 	})
 }
 
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Returns the room directory of the rooms entered through rooms, or nil when
+// the directory is disabled.
+func newDirectory(newAdapter *adapter.AdapterFactory, rooms *room.RoomManager) routes.RoomDirectory {
+	switch {
+	case newAdapter.DirectoryDisabled:
+		log.Printf("Room directory disabled, it requires store.redis.persist_room_metadata with Redis")
+		return nil
+	case newAdapter.RoomDirectoryStore != nil:
+		return room.NewDirectory(newAdapter.RoomDirectoryStore, newAdapter.RoomMetadataStore)
+	default:
+		return room.NewDirectory(rooms, newAdapter.RoomMetadataStore)
+	}
+}
+
 func main() {
 	flags := flag.NewFlagSet("peer-calls", flag.ExitOnError)
-	var configFilename string
-	flags.StringVar(&configFilename, "c", "", "Config file to use")
+	var configFiles stringsFlag
+	flags.Var(&configFiles, "c", "Config file to use, can be repeated to merge multiple files. Takes precedence over files in PEERCALLS_CONFIG")
+	var readParams config.ReadParams
+	flags.IntVar(&readParams.Retries, "config-retries", 0, "Number of times a config file is read again after a transient error, e.g. on network filesystems")
+	flags.DurationVar(&readParams.RetryDelay, "config-retry-delay", time.Second, "Delay between attempts to read a config file")
 	flags.Parse(os.Args[1:])
 
-	configFiles := []string{}
-	if configFilename != "" {
-		configFiles = append(configFiles, configFilename)
-	}
-	c, err := config.Read(configFiles)
+	c, err := config.ReadWithParams(configFiles, readParams)
 	panicOnError(err, "Error reading config")
 
-	log.Printf("Using config: %+v", c)
+	log.Printf("Using config: %+v", c.Redacted())
+	if warning := config.TURNWarning(c); warning != "" {
+		log.Printf("Warning: %s", warning)
+	}
+	if c.TURNSelfTest {
+		if err := selfTestTURN(c.ICEServers); err != nil && c.TURNPolicy == config.TURNPolicyStrict {
+			panicOnError(err, "Error checking TURN servers")
+		}
+	}
+	webhooks := webhook.NewDispatcher(webhook.Params{
+		URLs:       c.Webhooks.URLs,
+		Secret:     c.Webhooks.Secret,
+		MaxRetries: c.Webhooks.MaxRetries,
+		RetryDelay: c.Webhooks.RetryDelay,
+		Timeout:    c.Webhooks.Timeout,
+	})
+	newRoomManager := func(newAdapter *adapter.AdapterFactory, webhooks *webhook.Dispatcher) *room.RoomManager {
+		return room.NewRoomManagerWithParams(newAdapter.NewAdapter, room.Params{
+			MaxRooms:         c.Rooms.Max,
+			AutoSFUThreshold: c.Network.AutoSFUThreshold,
+			MaxRoomDuration:  c.Rooms.MaxDuration,
+			Webhooks:         webhooks,
+		})
+	}
 	newAdapter := adapter.NewAdapterFactory(c.Store)
-	rooms := room.NewRoomManager(newAdapter.NewAdapter)
-	tracks := tracks.NewTracksManager()
-	mux := routes.NewMux(c.BaseURL, gitDescribe, c.Network, c.ICEServers, rooms, tracks)
-	l, err := net.Listen("tcp", net.JoinHostPort(c.BindHost, strconv.Itoa(c.BindPort)))
+	rooms := newRoomManager(newAdapter, webhooks)
+	newQuotaTracker := func() *quota.Tracker {
+		return quota.NewTracker(quota.Params{
+			Bytes:  int64(c.Rooms.ClientQuota),
+			Window: c.Rooms.ClientQuotaWindow,
+		})
+	}
+	usage := newQuotaTracker()
+	interceptor, err := tracks.NewInterceptorChain(c.Network.SFU.Interceptors)
+	panicOnError(err, "Error creating RTP interceptors")
+	newTracksManager := func(usage *quota.Tracker) *tracks.TracksManager {
+		return tracks.NewTracksManagerWithParams(tracks.Params{
+			MaxVideoPublishers: c.Rooms.MaxVideoPublishers,
+			MaxTransceivers:    c.Rooms.MaxTransceivers,
+			Quota:              usage,
+			KeyframeInterval:   c.Network.SFU.KeyframeInterval,
+			Interceptor:        interceptor,
+			LastN:              c.Network.SFU.LastN,
+			ReorderDepth:       c.Network.SFU.ReorderDepth,
+			ReorderTimeout:     c.Network.SFU.ReorderTimeout,
+		})
+	}
+	tracks := newTracksManager(usage)
+	// each namespace has its own rooms, usage and room directory, and the
+	// webhooks of its rooms contain the namespace
+	namespaces := make([]routes.Namespace, len(c.Namespaces))
+	for i, name := range c.Namespaces {
+		namespaceAdapter := adapter.NewAdapterFactory(config.NamespaceStoreConfig(c.Store, name))
+		namespaceWebhooks := webhooks.WithNamespace(name)
+		namespaceRooms := newRoomManager(namespaceAdapter, namespaceWebhooks)
+		namespaceUsage := newQuotaTracker()
+		namespaces[i] = routes.Namespace{
+			Name:     name,
+			Rooms:    namespaceRooms,
+			Tracks:   newTracksManager(namespaceUsage),
+			Quota:    namespaceUsage,
+			Webhooks: namespaceWebhooks,
+			ICEServers: routes.RoomICEServers{
+				Default: c.ICEServers,
+				Rooms:   config.NamespaceRoomICEServers(c.RoomICEServers, name),
+			},
+			Directory: newDirectory(namespaceAdapter, namespaceRooms),
+		}
+	}
+	mux := routes.NewMux(c.BaseURL, gitDescribe, c.Network, routes.RoomICEServers{
+		Default: c.ICEServers,
+		Rooms:   config.NamespaceRoomICEServers(c.RoomICEServers, ""),
+	}, c.WS, c.API, rooms, tracks, usage, webhooks, newDirectory(newAdapter, rooms), c.Admin, namespaces)
+	var adminServer *server.StartStopper
+	if admin := mux.AdminHandler(); admin != nil {
+		adminListener, err := server.Listen(server.ListenParams{
+			BindHost:  c.Admin.BindHost,
+			BindPort:  c.Admin.BindPort,
+			DualStack: c.Admin.BindHost == config.BindHostDualStack,
+		})
+		panicOnError(err, "Error starting admin listener")
+		log.Printf("Admin listening on: %s", adminListener.Addr().String())
+		adminServer = server.NewStartStopper(server.ServerParams{}, admin)
+		go func() {
+			err := adminServer.Start(adminListener)
+			if errors.Is(err, http.ErrServerClosed) {
+				return
+			}
+			panicOnError(err, "Error starting admin server")
+		}()
+	}
+	l, err := server.Listen(server.ListenParams{
+		BindHost:   c.BindHost,
+		BindPort:   c.BindPort,
+		DualStack:  c.BindHost == config.BindHostDualStack,
+		BindSocket: c.BindSocket,
+	})
 	panicOnError(err, "Error starting server listener")
-	addr := l.Addr().(*net.TCPAddr)
-	log.Printf("Listening on: %s", addr.String())
+	log.Printf("Listening on: %s", l.Addr().String())
+	// the TLS config has been validated by config.Read
+	tlsMinVersion, _ := config.TLSVersion(c.TLS.MinVersion)
+	tlsCipherSuites, _ := config.TLSCipherSuites(c.TLS.CipherSuites)
 	server := server.NewStartStopper(server.ServerParams{
-		TLSCertFile: c.TLS.Cert,
-		TLSKeyFile:  c.TLS.Key,
+		TLSCertFile:     c.TLS.Cert,
+		TLSKeyFile:      c.TLS.Key,
+		TLSMinVersion:   tlsMinVersion,
+		TLSCipherSuites: tlsCipherSuites,
 	}, mux)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-interrupt
+		log.Printf("Received signal: %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := mux.Shutdown(ctx); err != nil {
+			log.Printf("Error closing websocket connections: %s", err)
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down server: %s", err)
+		}
+		if adminServer != nil {
+			if err := adminServer.Shutdown(ctx); err != nil {
+				log.Printf("Error shutting down admin server: %s", err)
+			}
+		}
+	}()
+
 	err = server.Start(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		return
+	}
 	panicOnError(err, "Error starting server")
 }
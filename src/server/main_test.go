@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,3 +25,15 @@ func TestPanicOnError_noerror(t *testing.T) {
 	}()
 	panicOnError(nil, "an error")
 }
+
+func TestSelfTestTURN(t *testing.T) {
+	require.Nil(t, selfTestTURN([]config.ICEServer{{URLs: []string{"stun:stun.example.com"}}}))
+
+	skipped := config.ICEServer{URLs: []string{"turns:turn.example.com", "turn:turn.example.com?transport=tcp"}}
+	require.Nil(t, selfTestTURN([]config.ICEServer{skipped}), "TURN servers that cannot be checked should be skipped")
+
+	invalid := config.ICEServer{URLs: []string{"turn:127.0.0.1:99999"}}
+	err := selfTestTURN([]config.ICEServer{skipped, invalid})
+	require.NotNil(t, err)
+	require.Regexp(t, "1 TURN server checks failed", err.Error())
+}
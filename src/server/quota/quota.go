@@ -0,0 +1,214 @@
+// Package quota accounts the bytes sent to and received from each client,
+// over websockets and the SFU, and detects clients that exceed a quota.
+package quota
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var exceededCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "peercalls_quota_exceeded_total",
+	Help: "Number of clients that exceeded the bandwidth quota",
+})
+
+// Usage of a client within the current window.
+type Usage struct {
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+	// Set once the client has exceeded the quota, even when the window has
+	// been reset since.
+	Exceeded bool `json:"exceeded"`
+}
+
+type Params struct {
+	// Maximum number of bytes sent to and received from a client within the
+	// window. Unlimited when zero.
+	Bytes int64
+	// Usage is reset after each window. Never reset when zero.
+	Window time.Duration
+}
+
+// Tracker keeps the usage of clients that are connected to this instance. A
+// nil Tracker does not account anything.
+type Tracker struct {
+	params Params
+	now    func() time.Time
+
+	mu sync.Mutex
+	// keys are room and clientID
+	countersByRoom map[string]map[string]*Counter
+}
+
+func NewTracker(params Params) *Tracker {
+	return &Tracker{
+		params:         params,
+		now:            time.Now,
+		countersByRoom: map[string]map[string]*Counter{},
+	}
+}
+
+// Returns the counter of the client in the room, creating it when the client
+// does not have one yet. The websocket and SFU connections of a client share
+// the counter. Every call must be followed by a call to Counter.Close.
+// Returns nil when t is nil.
+func (t *Tracker) Open(room string, clientID string) *Counter {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counters, ok := t.countersByRoom[room]
+	if !ok {
+		counters = map[string]*Counter{}
+		t.countersByRoom[room] = counters
+	}
+
+	counter, ok := counters[clientID]
+	if !ok {
+		counter = &Counter{
+			tracker:     t,
+			room:        room,
+			clientID:    clientID,
+			windowStart: t.now(),
+			exceeded:    make(chan struct{}),
+		}
+		counters[clientID] = counter
+	}
+	counter.refs++
+
+	return counter
+}
+
+func (t *Tracker) release(counter *Counter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counter.refs--
+	if counter.refs > 0 {
+		return
+	}
+
+	counters := t.countersByRoom[counter.room]
+	delete(counters, counter.clientID)
+	if len(counters) == 0 {
+		delete(t.countersByRoom, counter.room)
+	}
+}
+
+// Returns the usage of each client in the room, keyed by clientID.
+func (t *Tracker) Usage(room string) map[string]Usage {
+	usageByClientID := map[string]Usage{}
+	if t == nil {
+		return usageByClientID
+	}
+
+	t.mu.Lock()
+	counters := make([]*Counter, 0, len(t.countersByRoom[room]))
+	for _, counter := range t.countersByRoom[room] {
+		counters = append(counters, counter)
+	}
+	t.mu.Unlock()
+
+	for _, counter := range counters {
+		usageByClientID[counter.clientID] = counter.Usage()
+	}
+	return usageByClientID
+}
+
+// Counter accounts the usage of a single client. All methods can be called
+// on a nil Counter.
+type Counter struct {
+	tracker  *Tracker
+	room     string
+	clientID string
+	// guarded by tracker.mu
+	refs int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	usage       Usage
+	exceeded    chan struct{}
+}
+
+// Adds n bytes sent to the client.
+func (c *Counter) AddSent(n int) {
+	c.add(int64(n), 0)
+}
+
+// Adds n bytes received from the client.
+func (c *Counter) AddReceived(n int) {
+	c.add(0, int64(n))
+}
+
+func (c *Counter) add(sent int64, received int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resetWindow()
+	c.usage.BytesSent += sent
+	c.usage.BytesReceived += received
+
+	limit := c.tracker.params.Bytes
+	if limit > 0 && !c.usage.Exceeded && c.usage.BytesSent+c.usage.BytesReceived > limit {
+		c.usage.Exceeded = true
+		close(c.exceeded)
+		exceededCounter.Inc()
+	}
+}
+
+// Must be called with mu locked.
+func (c *Counter) resetWindow() {
+	window := c.tracker.params.Window
+	if window <= 0 {
+		return
+	}
+
+	now := c.tracker.now()
+	if now.Sub(c.windowStart) < window {
+		return
+	}
+
+	c.windowStart = now
+	c.usage.BytesSent = 0
+	c.usage.BytesReceived = 0
+}
+
+// Returns the usage within the current window.
+func (c *Counter) Usage() Usage {
+	if c == nil {
+		return Usage{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resetWindow()
+	return c.usage
+}
+
+// Returns a channel that is closed once the client exceeds the quota. The
+// channel of a nil Counter is never closed.
+func (c *Counter) Exceeded() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.exceeded
+}
+
+// Releases the counter acquired with Tracker.Open.
+func (c *Counter) Close() {
+	if c == nil {
+		return
+	}
+	c.tracker.release(c)
+}
@@ -0,0 +1,106 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestTracker_exceeded(t *testing.T) {
+	tracker := NewTracker(Params{Bytes: 100})
+
+	counter := tracker.Open("room1", "a")
+	defer counter.Close()
+
+	counter.AddSent(60)
+	counter.AddReceived(40)
+	assert.False(t, isClosed(counter.Exceeded()), "usage equal to the quota is allowed")
+	assert.Equal(t, Usage{BytesSent: 60, BytesReceived: 40}, counter.Usage())
+
+	counter.AddReceived(1)
+	assert.True(t, isClosed(counter.Exceeded()))
+	assert.Equal(t, Usage{BytesSent: 60, BytesReceived: 41, Exceeded: true}, counter.Usage())
+
+	// must not panic by closing the channel again
+	counter.AddSent(1)
+}
+
+func TestTracker_unlimited(t *testing.T) {
+	tracker := NewTracker(Params{})
+
+	counter := tracker.Open("room1", "a")
+	defer counter.Close()
+
+	counter.AddSent(1 << 30)
+	assert.False(t, isClosed(counter.Exceeded()))
+	assert.Equal(t, Usage{BytesSent: 1 << 30}, counter.Usage())
+}
+
+func TestTracker_window(t *testing.T) {
+	now := time.Now()
+	tracker := NewTracker(Params{Bytes: 100, Window: time.Minute})
+	tracker.now = func() time.Time { return now }
+
+	counter := tracker.Open("room1", "a")
+	defer counter.Close()
+
+	counter.AddSent(80)
+	now = now.Add(30 * time.Second)
+	counter.AddSent(20)
+	assert.Equal(t, Usage{BytesSent: 100}, counter.Usage())
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, Usage{}, counter.Usage(), "usage should be reset after the window")
+
+	counter.AddSent(80)
+	assert.False(t, isClosed(counter.Exceeded()))
+	counter.AddSent(21)
+	assert.True(t, isClosed(counter.Exceeded()))
+}
+
+func TestTracker_sharedCounter(t *testing.T) {
+	tracker := NewTracker(Params{Bytes: 100})
+
+	ws := tracker.Open("room1", "a")
+	sfu := tracker.Open("room1", "a")
+	other := tracker.Open("room1", "b")
+	defer other.Close()
+
+	ws.AddReceived(10)
+	sfu.AddSent(20)
+	other.AddSent(5)
+	assert.Equal(t, map[string]Usage{
+		"a": {BytesSent: 20, BytesReceived: 10},
+		"b": {BytesSent: 5},
+	}, tracker.Usage("room1"))
+	assert.Equal(t, map[string]Usage{}, tracker.Usage("room2"))
+
+	ws.Close()
+	assert.Equal(t, Usage{BytesSent: 20, BytesReceived: 10}, tracker.Usage("room1")["a"],
+		"usage should be kept while the client has other connections")
+
+	sfu.Close()
+	assert.Equal(t, map[string]Usage{"b": {BytesSent: 5}}, tracker.Usage("room1"))
+}
+
+func TestTracker_nil(t *testing.T) {
+	var tracker *Tracker
+
+	counter := tracker.Open("room1", "a")
+	counter.AddSent(10)
+	counter.AddReceived(10)
+	assert.Equal(t, Usage{}, counter.Usage())
+	assert.Nil(t, counter.Exceeded())
+	counter.Close()
+	assert.Equal(t, map[string]Usage{}, tracker.Usage("room1"))
+}
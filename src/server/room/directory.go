@@ -0,0 +1,110 @@
+package room
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+)
+
+const (
+	// Number of rooms listed per page when the query has no limit.
+	DefaultDirectoryLimit = 20
+	// Maximum number of rooms listed per page.
+	MaxDirectoryLimit = 100
+)
+
+// DirectoryStore returns the rooms that have at least one client. When using
+// Redis the rooms of all instances are returned.
+type DirectoryStore interface {
+	// Returns the number of clients in each room, keyed by room. Rooms that
+	// are not listed may be omitted.
+	ActiveRooms() (map[string]int, error)
+}
+
+// DirectoryEntry describes a room listed in the directory.
+type DirectoryEntry struct {
+	Room         string `json:"room"`
+	Participants int    `json:"participants"`
+	Topic        string `json:"topic"`
+	Locked       bool   `json:"locked"`
+}
+
+type DirectoryQuery struct {
+	// Only rooms with names starting with the prefix are listed.
+	Prefix string
+	// Rooms are listed in order of their names, starting after this room.
+	// Set it to DirectoryPage.Next to list the next page.
+	After string
+	// Maximum number of rooms to list. Defaults to DefaultDirectoryLimit when
+	// zero and cannot exceed MaxDirectoryLimit.
+	Limit int
+}
+
+type DirectoryPage struct {
+	Rooms []DirectoryEntry `json:"rooms"`
+	// Name of the last listed room when there are more rooms, used as
+	// DirectoryQuery.After to list the next page. Empty on the last page.
+	Next string `json:"next"`
+}
+
+// Directory lists the active rooms that opted in to being listed with
+// wsadapter.RoomMetadata.Listed.
+type Directory struct {
+	store    DirectoryStore
+	metadata wsadapter.RoomMetadataStore
+}
+
+func NewDirectory(store DirectoryStore, metadata wsadapter.RoomMetadataStore) *Directory {
+	return &Directory{
+		store:    store,
+		metadata: metadata,
+	}
+}
+
+func (d *Directory) List(query DirectoryQuery) (page DirectoryPage, err error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultDirectoryLimit
+	}
+	if limit > MaxDirectoryLimit {
+		limit = MaxDirectoryLimit
+	}
+
+	participantsByRoom, err := d.store.ActiveRooms()
+	if err != nil {
+		return page, fmt.Errorf("Directory.List - error retrieving active rooms: %w", err)
+	}
+
+	rooms := make([]string, 0, len(participantsByRoom))
+	for room := range participantsByRoom {
+		if strings.HasPrefix(room, query.Prefix) && room > query.After {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Strings(rooms)
+
+	page.Rooms = []DirectoryEntry{}
+	for _, room := range rooms {
+		metadata, err := d.metadata.RoomMetadata(room)
+		if err != nil {
+			return page, fmt.Errorf("Directory.List - error retrieving metadata of room: %s: %w", room, err)
+		}
+		if !metadata.Listed {
+			continue
+		}
+		if len(page.Rooms) == limit {
+			page.Next = page.Rooms[limit-1].Room
+			break
+		}
+		page.Rooms = append(page.Rooms, DirectoryEntry{
+			Room:         room,
+			Participants: participantsByRoom[room],
+			Topic:        metadata.Topic,
+			Locked:       metadata.Locked,
+		})
+	}
+
+	return page, nil
+}
@@ -0,0 +1,181 @@
+package room_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDirectoryStore struct {
+	rooms map[string]int
+	err   error
+}
+
+func (s fakeDirectoryStore) ActiveRooms() (map[string]int, error) {
+	return s.rooms, s.err
+}
+
+func newTestDirectory(t *testing.T, rooms map[string]int, listed ...string) *room.Directory {
+	metadata := wsadapter.NewMemoryRoomMetadataStore()
+	for _, name := range listed {
+		require.Nil(t, metadata.SetRoomMetadata(name, wsadapter.RoomMetadata{
+			Listed: true,
+			Topic:  "topic " + name,
+		}))
+	}
+	return room.NewDirectory(fakeDirectoryStore{rooms: rooms}, metadata)
+}
+
+func roomNames(page room.DirectoryPage) []string {
+	names := []string{}
+	for _, entry := range page.Rooms {
+		names = append(names, entry.Room)
+	}
+	return names
+}
+
+func TestDirectory_List(t *testing.T) {
+	metadata := wsadapter.NewMemoryRoomMetadataStore()
+	require.Nil(t, metadata.SetRoomMetadata("a", wsadapter.RoomMetadata{Listed: true, Topic: "topic", Locked: true}))
+	require.Nil(t, metadata.SetRoomMetadata("b", wsadapter.RoomMetadata{Topic: "unlisted"}))
+	require.Nil(t, metadata.SetRoomMetadata("c", wsadapter.RoomMetadata{Listed: true}))
+	directory := room.NewDirectory(fakeDirectoryStore{rooms: map[string]int{"a": 2, "b": 1, "c": 3}}, metadata)
+
+	page, err := directory.List(room.DirectoryQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, room.DirectoryPage{
+		Rooms: []room.DirectoryEntry{
+			{Room: "a", Participants: 2, Topic: "topic", Locked: true},
+			{Room: "c", Participants: 3},
+		},
+	}, page)
+}
+
+func TestDirectory_List_empty(t *testing.T) {
+	directory := newTestDirectory(t, map[string]int{"a": 1})
+
+	page, err := directory.List(room.DirectoryQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, room.DirectoryPage{Rooms: []room.DirectoryEntry{}}, page)
+}
+
+func TestDirectory_List_pagination(t *testing.T) {
+	directory := newTestDirectory(t,
+		map[string]int{"a": 1, "b": 1, "c": 1, "d": 1, "e": 1},
+		"a", "b", "d", "e",
+	)
+
+	page, err := directory.List(room.DirectoryQuery{Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, roomNames(page))
+	assert.Equal(t, "b", page.Next)
+
+	// the unlisted room c is skipped
+	page, err = directory.List(room.DirectoryQuery{Limit: 2, After: page.Next})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "e"}, roomNames(page))
+	assert.Equal(t, "", page.Next, "the last page should not have a next page even when it is full")
+
+	page, err = directory.List(room.DirectoryQuery{Limit: 2, After: "e"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, roomNames(page))
+	assert.Equal(t, "", page.Next)
+
+	page, err = directory.List(room.DirectoryQuery{Limit: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "d", "e"}, roomNames(page))
+	assert.Equal(t, "", page.Next)
+
+	page, err = directory.List(room.DirectoryQuery{Limit: 3, After: "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d", "e"}, roomNames(page))
+	assert.Equal(t, "", page.Next)
+}
+
+func TestDirectory_List_limits(t *testing.T) {
+	rooms := map[string]int{}
+	var listed []string
+	for i := 0; i < room.MaxDirectoryLimit+10; i++ {
+		name := string(rune('a'+i/26)) + string(rune('a'+i%26))
+		rooms[name] = 1
+		listed = append(listed, name)
+	}
+	directory := newTestDirectory(t, rooms, listed...)
+
+	page, err := directory.List(room.DirectoryQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, room.DefaultDirectoryLimit, len(page.Rooms))
+	assert.Equal(t, page.Rooms[room.DefaultDirectoryLimit-1].Room, page.Next)
+
+	page, err = directory.List(room.DirectoryQuery{Limit: room.MaxDirectoryLimit + 1})
+	assert.Nil(t, err)
+	assert.Equal(t, room.MaxDirectoryLimit, len(page.Rooms))
+	assert.NotEqual(t, "", page.Next)
+}
+
+func TestDirectory_List_prefix(t *testing.T) {
+	directory := newTestDirectory(t,
+		map[string]int{"team": 1, "team-a": 1, "team-b": 1, "teams": 1, "other": 1},
+		"team", "team-a", "team-b", "teams", "other",
+	)
+
+	page, err := directory.List(room.DirectoryQuery{Prefix: "team-"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, roomNames(page))
+
+	page, err = directory.List(room.DirectoryQuery{Prefix: "team"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"team", "team-a", "team-b", "teams"}, roomNames(page))
+
+	page, err = directory.List(room.DirectoryQuery{Prefix: "team", After: "team-a", Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"team-b"}, roomNames(page))
+	assert.Equal(t, "team-b", page.Next)
+
+	page, err = directory.List(room.DirectoryQuery{Prefix: "missing"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, roomNames(page))
+}
+
+func TestDirectory_List_error(t *testing.T) {
+	storeErr := errors.New("test")
+	directory := room.NewDirectory(
+		fakeDirectoryStore{err: storeErr},
+		wsadapter.NewMemoryRoomMetadataStore(),
+	)
+
+	_, err := directory.List(room.DirectoryQuery{})
+	assert.True(t, errors.Is(err, storeErr))
+	assert.EqualError(t, err, "Directory.List - error retrieving active rooms: test")
+}
+
+type testClient struct {
+	id string
+}
+
+func newTestClient(id string) *testClient {
+	return &testClient{id: id}
+}
+
+func (c *testClient) ID() string                       { return c.id }
+func (c *testClient) Send(msg wsmessage.Message) error { return nil }
+func (c *testClient) Subscribed(typ string) bool       { return true }
+func (c *testClient) Metadata() string                 { return "" }
+func (c *testClient) SetMetadata(metadata string)      {}
+
+func TestRoomManager_ActiveRooms(t *testing.T) {
+	rooms := room.NewRoomManager(newAdapter)
+	adapter := mustEnter(t, rooms, "room1")
+	mustEnter(t, rooms, "room2")
+	require.Nil(t, adapter.Add(newTestClient("a")))
+	require.Nil(t, adapter.Add(newTestClient("b")))
+
+	active, err := rooms.ActiveRooms()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"room1": 2}, active)
+}
@@ -1,56 +1,324 @@
 package room
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/webhook"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 )
 
+var log = logger.GetLogger("room")
+
 type AdapterFactory func(room string) wsadapter.Adapter
 
+var ErrTooManyRooms = errors.New("Maximum number of rooms reached")
+
+var ErrRoomClosed = errors.New("Room is closed")
+
+var ErrRoomNotFound = errors.New("Room not found")
+
+type Params struct {
+	// Maximum number of rooms active at the same time. When using Redis this
+	// limit is per instance. Unlimited when zero.
+	MaxRooms int
+	// Rooms switch from mesh to SFU for new connections once the number of
+	// connections exceeds this threshold. The room stays in SFU mode until it
+	// becomes empty. Disabled when zero.
+	AutoSFUThreshold int
+	// Maximum time a room can exist, measured from the time its first client
+	// entered. Rooms are closed once it has passed. Unlimited when zero.
+	MaxRoomDuration time.Duration
+	// Notified when a room is created by the first connection entering it,
+	// and when it is destroyed after the last connection exited. When using
+	// Redis the room must also be empty on all other instances. Optional.
+	Webhooks *webhook.Dispatcher
+}
+
 type adapterCounter struct {
-	count   uint64
-	adapter wsadapter.Adapter
+	count uint64
+	// Number of entries made with EnterUncounted, included in count. The
+	// room has not been created while all entries are uncounted.
+	uncounted   uint64
+	adapter     wsadapter.Adapter
+	networkType config.NetworkType
+	// Closed once the room exceeds the maximum duration. Nil when the
+	// duration is unlimited.
+	closed chan struct{}
+	timer  *time.Timer
 }
 
 type RoomManager struct {
 	rooms      map[string]*adapterCounter
 	roomsMu    sync.RWMutex
 	newAdapter AdapterFactory
+	params     Params
 }
 
 func NewRoomManager(newAdapter AdapterFactory) *RoomManager {
+	return NewRoomManagerWithParams(newAdapter, Params{})
+}
+
+func NewRoomManagerWithParams(newAdapter AdapterFactory, params Params) *RoomManager {
 	return &RoomManager{
 		rooms:      map[string]*adapterCounter{},
 		newAdapter: newAdapter,
+		params:     params,
 	}
 }
 
-func (r *RoomManager) Enter(room string) wsadapter.Adapter {
+func (r *RoomManager) Enter(room string) (wsadapter.Adapter, error) {
+	adapter, _, err := r.EnterWithNetworkType(room)
+	return adapter, err
+}
+
+// EnterWithNetworkType enters the room like Enter and returns the network
+// type the entering connection should use. The network type is decided while
+// the room is locked, so concurrent connections cannot both be counted below
+// the auto SFU threshold.
+func (r *RoomManager) EnterWithNetworkType(room string) (wsadapter.Adapter, config.NetworkType, error) {
 	r.roomsMu.Lock()
+	defer r.roomsMu.Unlock()
+	adapter, err := r.enter(room, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if r.exceedsAutoSFUThreshold(adapter.count) {
+		adapter.networkType = config.NetworkTypeSFU
+	}
+	return adapter.adapter, adapter.networkType, nil
+}
+
+// EnterExisting enters the room like Enter, but only when the room exists.
+// When using Redis a room exists when it has clients on any instance.
+// Returns ErrRoomNotFound otherwise, without creating the room.
+func (r *RoomManager) EnterExisting(room string) (wsadapter.Adapter, error) {
+	r.roomsMu.Lock()
+	defer r.roomsMu.Unlock()
+	adapter, err := r.enter(room, false)
+	if err != nil {
+		return nil, err
+	}
+	return adapter.adapter, nil
+}
+
+// EnterUncounted enters the room without creating it, for example to set
+// the password of a room before its first client connects. The entry does
+// not count towards MaxRooms or the maximum room duration, and no webhooks
+// are dispatched for it. The room must be exited with ExitUncounted.
+func (r *RoomManager) EnterUncounted(room string) (wsadapter.Adapter, error) {
+	r.roomsMu.Lock()
+	defer r.roomsMu.Unlock()
+
+	adapter, ok := r.rooms[room]
+	if !ok {
+		adapter = &adapterCounter{
+			adapter:     r.newAdapter(room),
+			networkType: config.NetworkTypeMesh,
+		}
+		r.rooms[room] = adapter
+	} else if isClosed(adapter.closed) {
+		return nil, ErrRoomClosed
+	}
+	adapter.count++
+	adapter.uncounted++
+	return adapter.adapter, nil
+}
+
+// Must be called with roomsMu locked. Rooms that do not exist on any
+// instance are only created when create is set.
+func (r *RoomManager) enter(room string, create bool) (*adapterCounter, error) {
 	adapter, ok := r.rooms[room]
-	if ok {
+	if ok && adapter.count > adapter.uncounted {
+		if isClosed(adapter.closed) {
+			return nil, ErrRoomClosed
+		}
 		adapter.count++
-	} else {
+		return adapter, nil
+	}
+
+	// the room is created, unless it was only entered with EnterUncounted
+	if r.params.MaxRooms > 0 && r.countedRooms() >= r.params.MaxRooms {
+		return nil, ErrTooManyRooms
+	}
+	if !ok {
 		adapter = &adapterCounter{
-			count:   1,
 			adapter: r.newAdapter(room),
 		}
-		r.rooms[room] = adapter
 	}
-	r.roomsMu.Unlock()
-	return adapter.adapter
+	// closes the adapter when the room is not entered
+	release := func() {
+		if !ok {
+			adapter.adapter.Close()
+		}
+	}
+	if !create {
+		if size, err := adapter.adapter.Size(); err != nil || size == 0 {
+			release()
+			if err != nil {
+				return nil, fmt.Errorf("RoomManager.enter - error retrieving size of room: %s: %w", room, err)
+			}
+			return nil, ErrRoomNotFound
+		}
+	}
+	if r.params.MaxRoomDuration > 0 {
+		if err := r.startCloseTimer(adapter); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	adapter.count++
+	adapter.networkType = config.NetworkTypeMesh
+	r.rooms[room] = adapter
+	if create {
+		r.dispatchIfEmpty(webhook.EventTypeRoomCreated, room, adapter.adapter)
+	}
+	return adapter, nil
+}
+
+// Must be called with roomsMu locked. Returns the number of rooms entered
+// with other methods than EnterUncounted.
+func (r *RoomManager) countedRooms() int {
+	count := 0
+	for _, adapter := range r.rooms {
+		if adapter.count > adapter.uncounted {
+			count++
+		}
+	}
+	return count
+}
+
+// Must be called with roomsMu locked. Rooms that still have clients on other
+// instances have not been created or destroyed.
+func (r *RoomManager) dispatchIfEmpty(eventType webhook.EventType, room string, adapter wsadapter.Adapter) {
+	if r.params.Webhooks == nil {
+		return
+	}
+	size, err := adapter.Size()
+	if err != nil {
+		log.Printf("Error retrieving size of room: %s: %s", room, err)
+		return
+	}
+	if size == 0 {
+		r.params.Webhooks.Dispatch(webhook.Event{
+			Type: eventType,
+			Room: room,
+		})
+	}
+}
+
+// Closes the room once the maximum duration has passed since its creation.
+// When using Redis the room may have been created by another instance.
+func (r *RoomManager) startCloseTimer(adapter *adapterCounter) error {
+	createdAt, err := adapter.adapter.CreatedAt()
+	if err != nil {
+		// measured from now when the creation time is unavailable
+		createdAt = time.Now()
+	}
+
+	remaining := time.Until(createdAt.Add(r.params.MaxRoomDuration))
+	if remaining <= 0 {
+		return ErrRoomClosed
+	}
+
+	closed := make(chan struct{})
+	adapter.closed = closed
+	adapter.timer = time.AfterFunc(remaining, func() {
+		close(closed)
+	})
+
+	return nil
+}
+
+func isClosed(closed <-chan struct{}) bool {
+	if closed == nil {
+		return false
+	}
+	select {
+	case <-closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Closed returns a channel that is closed once the room has exceeded the
+// maximum duration. It returns nil when the duration is unlimited or the room
+// does not exist.
+func (r *RoomManager) Closed(room string) <-chan struct{} {
+	r.roomsMu.RLock()
+	defer r.roomsMu.RUnlock()
+
+	adapter, ok := r.rooms[room]
+	if !ok {
+		return nil
+	}
+	return adapter.closed
+}
+
+func (r *RoomManager) exceedsAutoSFUThreshold(count uint64) bool {
+	threshold := r.params.AutoSFUThreshold
+	return threshold > 0 && count > uint64(threshold)
 }
 
 func (r *RoomManager) Exit(room string) {
 	r.roomsMu.Lock()
 	adapter, ok := r.rooms[room]
-	if ok {
+	if ok && adapter.count > adapter.uncounted {
 		adapter.count--
-		if adapter.count == 0 {
-			delete(r.rooms, room)
-			adapter.adapter.Close() // FIXME log error
+		if adapter.count == adapter.uncounted {
+			if adapter.timer != nil {
+				adapter.timer.Stop()
+				adapter.timer = nil
+				adapter.closed = nil
+			}
+			r.dispatchIfEmpty(webhook.EventTypeRoomDestroyed, room, adapter.adapter)
 		}
+		r.removeIfUnused(room, adapter)
+	}
+	r.roomsMu.Unlock()
+}
+
+// Exits a room entered with EnterUncounted.
+func (r *RoomManager) ExitUncounted(room string) {
+	r.roomsMu.Lock()
+	adapter, ok := r.rooms[room]
+	if ok && adapter.uncounted > 0 {
+		adapter.count--
+		adapter.uncounted--
+		r.removeIfUnused(room, adapter)
 	}
 	r.roomsMu.Unlock()
 }
+
+// Must be called with roomsMu locked.
+func (r *RoomManager) removeIfUnused(room string, adapter *adapterCounter) {
+	if adapter.count == 0 {
+		delete(r.rooms, room)
+		adapter.adapter.Close() // FIXME log error
+	}
+}
+
+// Returns the number of clients connected to this instance in each room.
+// Rooms without clients, such as rooms only entered through the API, are
+// omitted.
+func (r *RoomManager) ActiveRooms() (map[string]int, error) {
+	r.roomsMu.RLock()
+	defer r.roomsMu.RUnlock()
+
+	participantsByRoom := map[string]int{}
+	for room, adapter := range r.rooms {
+		size, err := adapter.adapter.Size()
+		if err != nil {
+			return nil, fmt.Errorf("RoomManager.ActiveRooms - error retrieving size of room: %s: %w", room, err)
+		}
+		if size > 0 {
+			participantsByRoom[room] = size
+		}
+	}
+	return participantsByRoom, nil
+}
@@ -1,9 +1,16 @@
 package room_test
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/webhook"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
 	"github.com/stretchr/testify/assert"
@@ -14,22 +21,349 @@ var newAdapter room.AdapterFactory = func(room string) wsadapter.Adapter {
 	return wsmemory.NewMemoryAdapter(room)
 }
 
+func mustEnter(t *testing.T, rooms *room.RoomManager, name string) wsadapter.Adapter {
+	t.Helper()
+	adapter, err := rooms.Enter(name)
+	require.Nil(t, err)
+	return adapter
+}
+
 func TestRoomManager(t *testing.T) {
 	rooms := room.NewRoomManager(newAdapter)
 
-	adapter1, ok := rooms.Enter("test").(*wsmemory.MemoryAdapter)
+	adapter1, ok := mustEnter(t, rooms, "test").(*wsmemory.MemoryAdapter)
 	require.True(t, ok)
 
-	adapter2 := rooms.Enter("test")
+	adapter2 := mustEnter(t, rooms, "test")
 	assert.True(t, adapter1 == adapter2, "adapters should be the same")
 
 	rooms.Exit("test")
-	adapter3 := rooms.Enter("test")
+	adapter3 := mustEnter(t, rooms, "test")
 	assert.True(t, adapter1 == adapter3, "adapters should be the same")
 
 	rooms.Exit("test")
 	rooms.Exit("test")
 
-	adapter4 := rooms.Enter("test")
+	adapter4 := mustEnter(t, rooms, "test")
 	assert.True(t, adapter1 != adapter4, "adapters should NOT be the same")
 }
+
+func TestRoomManager_maxRooms(t *testing.T) {
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		MaxRooms: 1,
+	})
+
+	mustEnter(t, rooms, "test1")
+	mustEnter(t, rooms, "test1")
+
+	_, err := rooms.Enter("test2")
+	assert.Equal(t, room.ErrTooManyRooms, err)
+
+	rooms.Exit("test1")
+	rooms.Exit("test1")
+
+	mustEnter(t, rooms, "test2")
+}
+
+func TestRoomManager_EnterExisting(t *testing.T) {
+	rooms := room.NewRoomManager(newAdapter)
+
+	_, err := rooms.EnterExisting("test")
+	assert.Equal(t, room.ErrRoomNotFound, err)
+	active, err := rooms.ActiveRooms()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{}, active)
+
+	adapter := mustEnter(t, rooms, "test")
+	existing, err := rooms.EnterExisting("test")
+	require.Nil(t, err)
+	assert.Equal(t, adapter, existing)
+
+	// the room is kept until both have exited
+	rooms.Exit("test")
+	existing, err = rooms.EnterExisting("test")
+	require.Nil(t, err)
+	assert.Equal(t, adapter, existing)
+	rooms.Exit("test")
+	rooms.Exit("test")
+
+	_, err = rooms.EnterExisting("test")
+	assert.Equal(t, room.ErrRoomNotFound, err)
+}
+
+func mustEnterWithNetworkType(t *testing.T, rooms *room.RoomManager, name string) config.NetworkType {
+	t.Helper()
+	_, networkType, err := rooms.EnterWithNetworkType(name)
+	require.Nil(t, err)
+	return networkType
+}
+
+func TestRoomManager_autoSFUThreshold(t *testing.T) {
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		AutoSFUThreshold: 2,
+	})
+
+	assert.Equal(t, config.NetworkTypeMesh, mustEnterWithNetworkType(t, rooms, "test"))
+	assert.Equal(t, config.NetworkTypeMesh, mustEnterWithNetworkType(t, rooms, "test"))
+	assert.Equal(t, config.NetworkTypeSFU, mustEnterWithNetworkType(t, rooms, "test"))
+	assert.Equal(t, config.NetworkTypeMesh, mustEnterWithNetworkType(t, rooms, "other"))
+
+	// the room stays in sfu mode once the threshold has been exceeded
+	rooms.Exit("test")
+	rooms.Exit("test")
+	assert.Equal(t, config.NetworkTypeSFU, mustEnterWithNetworkType(t, rooms, "test"))
+
+	rooms.Exit("test")
+	rooms.Exit("test")
+	assert.Equal(t, config.NetworkTypeMesh, mustEnterWithNetworkType(t, rooms, "test"))
+}
+
+func TestRoomManager_autoSFUThreshold_concurrent(t *testing.T) {
+	const threshold = 2
+	const joins = 20
+
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		AutoSFUThreshold: threshold,
+	})
+
+	networkTypes := make(chan config.NetworkType, joins)
+	var wg sync.WaitGroup
+	wg.Add(joins)
+	for i := 0; i < joins; i++ {
+		go func() {
+			defer wg.Done()
+			_, networkType, err := rooms.EnterWithNetworkType("test")
+			assert.Nil(t, err)
+			networkTypes <- networkType
+		}()
+	}
+	wg.Wait()
+	close(networkTypes)
+
+	counts := map[config.NetworkType]int{}
+	for networkType := range networkTypes {
+		counts[networkType]++
+	}
+	assert.Equal(t, map[config.NetworkType]int{
+		config.NetworkTypeMesh: threshold,
+		config.NetworkTypeSFU:  joins - threshold,
+	}, counts)
+}
+
+func TestRoomManager_autoSFUThreshold_disabled(t *testing.T) {
+	rooms := room.NewRoomManager(newAdapter)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, config.NetworkTypeMesh, mustEnterWithNetworkType(t, rooms, "test"))
+	}
+}
+
+func TestRoomManager_maxRoomDuration(t *testing.T) {
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		MaxRoomDuration: 50 * time.Millisecond,
+	})
+	adapter1 := mustEnter(t, rooms, "test")
+	mustEnter(t, rooms, "test")
+	closed := rooms.Closed("test")
+	require.NotNil(t, closed)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for room to be closed")
+	}
+
+	_, err := rooms.Enter("test")
+	assert.Equal(t, room.ErrRoomClosed, err)
+
+	rooms.Exit("test")
+	rooms.Exit("test")
+	adapter2 := mustEnter(t, rooms, "test")
+	assert.True(t, adapter1 != adapter2, "expected a new room after all clients left")
+	select {
+	case <-rooms.Closed("test"):
+		assert.Fail(t, "new room should not be closed")
+	default:
+	}
+}
+
+func TestRoomManager_maxRoomDuration_notExceeded(t *testing.T) {
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		MaxRoomDuration: time.Hour,
+	})
+	mustEnter(t, rooms, "test")
+	closed := rooms.Closed("test")
+	require.NotNil(t, closed)
+
+	select {
+	case <-closed:
+		assert.Fail(t, "room should not be closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mustEnter(t, rooms, "test")
+	rooms.Exit("test")
+	rooms.Exit("test")
+}
+
+func TestRoomManager_maxRoomDuration_disabled(t *testing.T) {
+	rooms := room.NewRoomManager(newAdapter)
+	mustEnter(t, rooms, "test")
+	assert.Nil(t, rooms.Closed("test"))
+	assert.Nil(t, rooms.Closed("other"))
+}
+
+type createdAtAdapter struct {
+	*wsmemory.MemoryAdapter
+	createdAt time.Time
+}
+
+func (a createdAtAdapter) CreatedAt() (time.Time, error) {
+	return a.createdAt, nil
+}
+
+func TestRoomManager_maxRoomDuration_createdElsewhere(t *testing.T) {
+	// another instance created the room before the duration elapsed
+	rooms := room.NewRoomManagerWithParams(func(name string) wsadapter.Adapter {
+		return createdAtAdapter{
+			MemoryAdapter: wsmemory.NewMemoryAdapter(name),
+			createdAt:     time.Now().Add(-time.Hour),
+		}
+	}, room.Params{
+		MaxRoomDuration: time.Hour,
+	})
+
+	_, err := rooms.Enter("test")
+	assert.Equal(t, room.ErrRoomClosed, err)
+	assert.Nil(t, rooms.Closed("test"))
+}
+
+// Records the types of received webhook events.
+type webhookReceiver struct {
+	mu     sync.Mutex
+	events []webhook.EventType
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var event webhook.Event
+	if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event.Type)
+}
+
+func (r *webhookReceiver) Events() []webhook.EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhook.EventType(nil), r.events...)
+}
+
+func TestRoomManager_webhooks(t *testing.T) {
+	recv := &webhookReceiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+	webhooks := webhook.NewDispatcher(webhook.Params{
+		URLs: []string{server.URL},
+	})
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		Webhooks: webhooks,
+	})
+
+	mustEnter(t, rooms, "test")
+	webhooks.Wait()
+	assert.Equal(t, []webhook.EventType{webhook.EventTypeRoomCreated}, recv.Events())
+
+	mustEnter(t, rooms, "test")
+	rooms.Exit("test")
+	webhooks.Wait()
+	assert.Equal(t, []webhook.EventType{webhook.EventTypeRoomCreated}, recv.Events())
+
+	rooms.Exit("test")
+	webhooks.Wait()
+	assert.Equal(t, []webhook.EventType{
+		webhook.EventTypeRoomCreated,
+		webhook.EventTypeRoomDestroyed,
+	}, recv.Events())
+}
+
+func TestRoomManager_EnterUncounted(t *testing.T) {
+	recv := &webhookReceiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+	webhooks := webhook.NewDispatcher(webhook.Params{
+		URLs: []string{server.URL},
+	})
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		MaxRooms: 1,
+		Webhooks: webhooks,
+	})
+
+	uncounted, err := rooms.EnterUncounted("test1")
+	require.Nil(t, err)
+	_, err = rooms.EnterExisting("test1")
+	assert.Equal(t, room.ErrRoomNotFound, err)
+
+	// the uncounted room does not count towards MaxRooms
+	mustEnter(t, rooms, "test2")
+	webhooks.Wait()
+	_, err = rooms.Enter("test1")
+	assert.Equal(t, room.ErrTooManyRooms, err)
+	rooms.Exit("test2")
+	webhooks.Wait()
+
+	adapter := mustEnter(t, rooms, "test1")
+	webhooks.Wait()
+	assert.True(t, uncounted == adapter, "adapters should be the same")
+	rooms.ExitUncounted("test1")
+	rooms.Exit("test1")
+
+	// rooms that were only entered uncounted are neither created nor destroyed
+	_, err = rooms.EnterUncounted("test3")
+	require.Nil(t, err)
+	rooms.ExitUncounted("test3")
+
+	webhooks.Wait()
+	assert.Equal(t, []webhook.EventType{
+		webhook.EventTypeRoomCreated,
+		webhook.EventTypeRoomDestroyed,
+		webhook.EventTypeRoomCreated,
+		webhook.EventTypeRoomDestroyed,
+	}, recv.Events())
+	active, err := rooms.ActiveRooms()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{}, active)
+}
+
+type sizeAdapter struct {
+	*wsmemory.MemoryAdapter
+	size int
+}
+
+func (a sizeAdapter) Size() (int, error) {
+	return a.size, nil
+}
+
+func TestRoomManager_webhooks_clientsElsewhere(t *testing.T) {
+	// another instance still has clients in the room
+	recv := &webhookReceiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+	webhooks := webhook.NewDispatcher(webhook.Params{
+		URLs: []string{server.URL},
+	})
+	rooms := room.NewRoomManagerWithParams(func(name string) wsadapter.Adapter {
+		return sizeAdapter{
+			MemoryAdapter: wsmemory.NewMemoryAdapter(name),
+			size:          1,
+		}
+	}, room.Params{
+		Webhooks: webhooks,
+	})
+
+	mustEnter(t, rooms, "test")
+	rooms.Exit("test")
+	webhooks.Wait()
+	assert.Empty(t, recv.Events())
+}
@@ -0,0 +1,333 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/quota"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+type apiHandler struct {
+	token        string
+	allowedTypes map[string]struct{}
+	rooms        RoomManager
+	tracks       TracksManager
+	usage        *quota.Tracker
+}
+
+type APIMessage struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+type APIRoomPassword struct {
+	// Generated by the server when empty.
+	Password string `json:"password"`
+}
+
+type APIRedirect struct {
+	// Absolute URL of the instance clients should reconnect to.
+	URL string `json:"url"`
+	// Passed on to the clients, e.g. to authenticate with the other instance.
+	Token string `json:"token"`
+}
+
+func newAPIHandler(c config.APIConfig, rooms RoomManager, tracks TracksManager, usage *quota.Tracker) http.Handler {
+	allowedTypes := map[string]struct{}{}
+	for _, typ := range c.AllowedMessageTypes {
+		allowedTypes[typ] = struct{}{}
+	}
+
+	h := &apiHandler{
+		token:        c.Token,
+		allowedTypes: allowedTypes,
+		rooms:        rooms,
+		tracks:       tracks,
+		usage:        usage,
+	}
+
+	router := chi.NewRouter()
+	router.Use(h.authenticate)
+	router.Post("/rooms/{room}/messages", h.routeMessage)
+	router.Get("/rooms/{room}/metadata", h.routeGetMetadata)
+	router.Put("/rooms/{room}/metadata", h.routePutMetadata)
+	router.Put("/rooms/{room}/password", h.routePutPassword)
+	router.Delete("/rooms/{room}/password", h.routeDeletePassword)
+	router.Get("/rooms/{room}/subscriptions", h.routeGetSubscriptions)
+	router.Get("/rooms/{room}/stats", h.routeGetTrackStats)
+	router.Get("/rooms/{room}/stats/usage", h.routeGetUsage)
+	router.Post("/rooms/{room}/end", h.routeEnd)
+	router.Post("/rooms/{room}/redirect", h.routeRedirect)
+	return router
+}
+
+// Enters an existing room. Responds with 404 when the room does not exist, so
+// that API requests do not create rooms. The caller must exit the room when
+// true is returned.
+func (h *apiHandler) enterExisting(w http.ResponseWriter, name string) (wsadapter.Adapter, bool) {
+	adapter, err := h.rooms.EnterExisting(name)
+	if errors.Is(err, room.ErrRoomNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return adapter, true
+}
+
+func (h *apiHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Broadcasts a message to all clients in a room. In Redis mode the message
+// will also be delivered to clients connected to other instances. Responds
+// with 404 when the room has no clients.
+func (h *apiHandler) routeMessage(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	var apiMessage APIMessage
+	if err := json.NewDecoder(r.Body).Decode(&apiMessage); err != nil {
+		http.Error(w, "Invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.allowedTypes[apiMessage.Type]; !ok {
+		http.Error(w, "Message type not allowed: "+apiMessage.Type, http.StatusForbidden)
+		return
+	}
+
+	adapter, ok := h.enterExisting(w, room)
+	if !ok {
+		return
+	}
+	defer h.rooms.Exit(room)
+
+	err := adapter.Broadcast(wsmessage.NewMessage(apiMessage.Type, room, apiMessage.Payload))
+	if err != nil {
+		log.Printf("Error broadcasting API message to room: %s: %s", room, err)
+		http.Error(w, "Error broadcasting message", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Ends the room by closing the connections of all its clients. In Redis mode
+// clients connected to other instances are disconnected too. Responds with
+// 404 when the room has no clients.
+func (h *apiHandler) routeEnd(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	adapter, ok := h.enterExisting(w, room)
+	if !ok {
+		return
+	}
+	defer h.rooms.Exit(room)
+
+	if err := adapter.Broadcast(wsmessage.NewMessageRoomEnd(room)); err != nil {
+		log.Printf("Error ending room: %s: %s", room, err)
+		http.Error(w, "Error ending room", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Asks all clients in the room to reconnect to another instance and closes
+// their connections, so that the room is drained from this cluster. In Redis
+// mode clients connected to other instances are redirected too. Responds with
+// 404 when the room has no clients.
+func (h *apiHandler) routeRedirect(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	var redirect APIRedirect
+	if err := json.NewDecoder(r.Body).Decode(&redirect); err != nil {
+		http.Error(w, "Invalid redirect: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if u, err := url.Parse(redirect.URL); err != nil || !u.IsAbs() || u.Host == "" {
+		http.Error(w, "Invalid redirect: url must be absolute", http.StatusBadRequest)
+		return
+	}
+
+	adapter, ok := h.enterExisting(w, room)
+	if !ok {
+		return
+	}
+	defer h.rooms.Exit(room)
+
+	err := adapter.Broadcast(wsmessage.NewMessageRoomRedirect(room, redirect.URL, redirect.Token))
+	if err != nil {
+		log.Printf("Error redirecting room: %s: %s", room, err)
+		http.Error(w, "Error redirecting room", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *apiHandler) routeGetMetadata(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	adapter, ok := h.enterExisting(w, room)
+	if !ok {
+		return
+	}
+	defer h.rooms.Exit(room)
+
+	metadata, err := adapter.RoomMetadata()
+	if err != nil {
+		log.Printf("Error retrieving metadata of room: %s: %s", room, err)
+		http.Error(w, "Error retrieving metadata", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		log.Printf("Error encoding metadata of room: %s: %s", room, err)
+	}
+}
+
+// Replaces room metadata and sends it to all clients in the room.
+func (h *apiHandler) routePutMetadata(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	var metadata wsadapter.RoomMetadata
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		http.Error(w, "Invalid metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adapter, ok := h.enterExisting(w, room)
+	if !ok {
+		return
+	}
+	defer h.rooms.Exit(room)
+
+	if err := adapter.SetRoomMetadata(metadata); err != nil {
+		log.Printf("Error setting metadata of room: %s: %s", room, err)
+		http.Error(w, "Error setting metadata", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Sets the password clients need to join the room and returns it. The
+// password is generated when the request has none. Clients already in the
+// room stay connected.
+func (h *apiHandler) routePutPassword(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	var password APIRoomPassword
+	if err := json.NewDecoder(r.Body).Decode(&password); err != nil {
+		http.Error(w, "Invalid password: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if password.Password == "" {
+		password.Password = wsadapter.NewRoomPassword()
+	}
+
+	hash, err := wsadapter.HashRoomPassword(password.Password)
+	if err != nil {
+		log.Printf("Error hashing password of room: %s: %s", room, err)
+		http.Error(w, "Error setting password", http.StatusInternalServerError)
+		return
+	}
+
+	adapter, err := h.rooms.EnterUncounted(room)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.rooms.ExitUncounted(room)
+
+	if err := adapter.SetRoomPassword(hash); err != nil {
+		log.Printf("Error setting password of room: %s: %s", room, err)
+		http.Error(w, "Error setting password", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(password); err != nil {
+		log.Printf("Error encoding password of room: %s: %s", room, err)
+	}
+}
+
+// Removes the room password so that clients can join without it.
+func (h *apiHandler) routeDeletePassword(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	adapter, err := h.rooms.EnterUncounted(room)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.rooms.ExitUncounted(room)
+
+	if err := adapter.SetRoomPassword(""); err != nil {
+		log.Printf("Error removing password of room: %s: %s", room, err)
+		http.Error(w, "Error removing password", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the SFU tracks forwarded to each client in the room, keyed by
+// clientID. Only peers connected to this instance are included.
+func (h *apiHandler) routeGetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.tracks.Subscriptions(room)); err != nil {
+		log.Printf("Error encoding subscriptions of room: %s: %s", room, err)
+	}
+}
+
+// Returns the stats of SFU tracks published by each client in the room,
+// keyed by clientID. Only peers connected to this instance are included.
+func (h *apiHandler) routeGetTrackStats(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.tracks.TrackStats(room)); err != nil {
+		log.Printf("Error encoding track stats of room: %s: %s", room, err)
+	}
+}
+
+// Returns the bytes sent to and received from each client in the room within
+// the current quota window, keyed by clientID. Only clients connected to this
+// instance are included.
+func (h *apiHandler) routeGetUsage(w http.ResponseWriter, r *http.Request) {
+	room := chi.URLParam(r, "room")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.usage.Usage(room)); err != nil {
+		log.Printf("Error encoding usage of room: %s: %s", room, err)
+	}
+}
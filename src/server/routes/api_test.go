@@ -0,0 +1,410 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/factory/adapter"
+	"github.com/jeremija/peer-calls/src/server/quota"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/routes"
+	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func newAPIMux(mrm *MockRoomManager) *routes.Mux {
+	return newAPIMuxWithTracks(mrm, newMockTracksManager())
+}
+
+func newAPIMuxWithTracks(mrm *MockRoomManager, trk *mockTracksManager) *routes.Mux {
+	return newAPIMuxWithQuota(mrm, trk, nil)
+}
+
+func newAPIMuxWithQuota(mrm *MockRoomManager, trk *mockTracksManager, usage *quota.Tracker) *routes.Mux {
+	api := config.APIConfig{
+		Token:               "secret",
+		AllowedMessageTypes: []string{wsmessage.MessageTypeNotice},
+	}
+	return routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, api, mrm, trk, usage, nil, nil, config.AdminConfig{}, nil)
+}
+
+func newAPIRequest(token string, body string) *http.Request {
+	r := httptest.NewRequest("POST", "/test/api/rooms/room1/messages", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestAPI_routeMessage(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+	w := httptest.NewRecorder()
+	r := newAPIRequest("secret", `{"type":"ws_notice","payload":"hello"}`)
+
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "room1", <-mrm.enter)
+	assert.Equal(t, wsmessage.NewMessage(wsmessage.MessageTypeNotice, "room1", "hello"), <-mrm.broadcast)
+	assert.Equal(t, "room1", <-mrm.exit)
+}
+
+func TestAPI_routeMessage_notFound(t *testing.T) {
+	mrm := NewMockRoomManager()
+	mrm.missingRooms = map[string]struct{}{"room1": {}}
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+	w := httptest.NewRecorder()
+	r := newAPIRequest("secret", `{"type":"ws_notice","payload":"hello"}`)
+
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, len(mrm.enter), "the room should not be entered")
+	assert.Equal(t, 0, len(mrm.broadcast))
+}
+
+func TestAPI_routeMessage_unauthorized(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	for _, token := range []string{"", "invalid"} {
+		w := httptest.NewRecorder()
+		r := newAPIRequest(token, `{"type":"ws_notice","payload":"hello"}`)
+
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	for _, authorization := range []string{"secret", "Basic secret", "bearer secret"} {
+		w := httptest.NewRecorder()
+		r := newAPIRequest("", `{"type":"ws_notice","payload":"hello"}`)
+		r.Header.Set("Authorization", authorization)
+
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "authorization: %s", authorization)
+	}
+	assert.Equal(t, 0, len(mrm.broadcast))
+}
+
+func TestAPI_routeMessage_typeNotAllowed(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+	w := httptest.NewRecorder()
+	r := newAPIRequest("secret", `{"type":"signal","payload":{"signal":{"type":"offer"}}}`)
+
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 0, len(mrm.enter))
+	assert.Equal(t, 0, len(mrm.broadcast))
+}
+
+func TestAPI_disabled(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, newMockTracksManager(), nil, nil, nil, config.AdminConfig{}, nil)
+	w := httptest.NewRecorder()
+	r := newAPIRequest("", `{"type":"ws_notice","payload":"hello"}`)
+
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPI_roomMetadata(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("PUT", "/test/api/rooms/room1/metadata", strings.NewReader(`{"locked":true,"topic":"topic"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	metadata := wsadapter.RoomMetadata{Locked: true, Topic: "topic"}
+	assert.Equal(t, wsmessage.NewMessageRoomMetadata("room1", metadata), <-mrm.broadcast)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/test/api/rooms/room1/metadata", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"locked":true,"topic":"topic","welcomeMessage":"","listed":false}`, w.Body.String())
+}
+
+func TestAPI_roomMetadata_notFound(t *testing.T) {
+	mrm := NewMockRoomManager()
+	mrm.missingRooms = map[string]struct{}{"room1": {}}
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/test/api/rooms/room1/metadata", nil),
+		httptest.NewRequest("PUT", "/test/api/rooms/room1/metadata", strings.NewReader(`{"locked":true}`)),
+	} {
+		w := httptest.NewRecorder()
+		r.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s %s", r.Method, r.URL)
+	}
+	assert.Equal(t, 0, len(mrm.enter), "the room should not be entered")
+	assert.Equal(t, 0, len(mrm.broadcast))
+}
+
+func TestAPI_roomPassword(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	setPassword := func(body string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/test/api/rooms/room1/password", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var password routes.APIRoomPassword
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &password))
+		assert.True(t, wsadapter.CheckRoomPassword(<-mrm.password, password.Password), "expected the hash of the password to be stored")
+		return password.Password
+	}
+
+	assert.Equal(t, "secret-password", setPassword(`{"password":"secret-password"}`))
+	assert.NotEqual(t, "", setPassword(`{}`), "expected a password to be generated")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/test/api/rooms/room1/password", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "", <-mrm.password)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "/test/api/rooms/room1/password", strings.NewReader(`invalid`))
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 0, len(mrm.password))
+}
+
+func TestAPI_roomPassword_memory(t *testing.T) {
+	adapters := adapter.NewAdapterFactory(config.StoreConfig{
+		Type:            config.StoreTypeMemory,
+		RoomPasswordTTL: time.Hour,
+	})
+	defer adapters.Close()
+	rooms := room.NewRoomManager(adapters.NewAdapter)
+	api := config.APIConfig{Token: "secret"}
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, api, rooms, newMockTracksManager(), nil, nil, nil, config.AdminConfig{}, nil)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/test/ws/room1/" + clientID
+
+	// the room is empty, so it is closed after the password is set
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("PUT", "/test/api/rooms/room1/password", strings.NewReader(`{"password":"secret-password"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, res, err := websocket.Dial(ctx, url+"?password=invalid", nil)
+	require.NotNil(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	conn := mustDialWS(t, ctx, url)
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeJoin, "room1", map[string]string{"password": "invalid"}))
+	assert.Equal(t, wsmessage.MessageTypeError, mustReadWS(t, ctx, conn).Type)
+	conn.Close(websocket.StatusNormalClosure, "")
+
+	conn = mustDialWS(t, ctx, url+"?password=secret-password")
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn).Type)
+	conn.Close(websocket.StatusNormalClosure, "")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("DELETE", "/test/api/rooms/room1/password", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	conn = mustDialWS(t, ctx, url)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn).Type)
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func TestAPI_endRoom(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/test/api/rooms/room1/end", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "room1", <-mrm.enter)
+		assert.Equal(t, wsmessage.NewMessageRoomEnd("room1"), <-mrm.broadcast)
+		assert.Equal(t, "room1", <-mrm.exit)
+	}
+}
+
+func TestAPI_endRoom_notFound(t *testing.T) {
+	mrm := NewMockRoomManager()
+	mrm.missingRooms = map[string]struct{}{"room1": {}}
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/test/api/rooms/room1/end", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, len(mrm.enter), "the room should not be entered")
+	assert.Equal(t, 0, len(mrm.broadcast))
+}
+
+func TestAPI_redirectRoom(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/test/api/rooms/room1/redirect", strings.NewReader(`{"url":"https://sfu2.example.com/call/room1","token":"abc"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "room1", <-mrm.enter)
+	assert.Equal(t, wsmessage.NewMessageRoomRedirect("room1", "https://sfu2.example.com/call/room1", "abc"), <-mrm.broadcast)
+	assert.Equal(t, "room1", <-mrm.exit)
+}
+
+func TestAPI_redirectRoom_notFound(t *testing.T) {
+	mrm := NewMockRoomManager()
+	mrm.missingRooms = map[string]struct{}{"room1": {}}
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/test/api/rooms/room1/redirect", strings.NewReader(`{"url":"https://sfu2.example.com/call/room1"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, len(mrm.enter), "the room should not be entered")
+	assert.Equal(t, 0, len(mrm.broadcast))
+}
+
+func TestAPI_redirectRoom_invalidURL(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newAPIMux(mrm)
+
+	for _, body := range []string{`{}`, `{"url":"/call/room1"}`, `{"url":"%"}`, `invalid`} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/test/api/rooms/room1/redirect", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, "body: %s", body)
+	}
+}
+
+func TestAPI_subscriptions(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	trk := newMockTracksManager()
+	trk.subscriptions = map[string][]tracks.Subscription{
+		"b": {{TrackID: "sfu_a1", SourceClientID: "a", Kind: "video"}},
+	}
+	mux := newAPIMuxWithTracks(mrm, trk)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/api/rooms/room1/subscriptions", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"b":[{"trackId":"sfu_a1","sourceClientId":"a","kind":"video"}]}`, w.Body.String())
+}
+
+func TestAPI_trackStats(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	trk := newMockTracksManager()
+	trk.trackStats = map[string][]tracks.TrackStats{
+		"a": {{TrackID: "sfu_a1", Kind: "video", SSRC: 1234, PacketsReceived: 10, PacketsForwarded: 9}},
+	}
+	mux := newAPIMuxWithTracks(mrm, trk)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/api/rooms/room1/stats", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"a":[{
+		"trackId":"sfu_a1",
+		"kind":"video",
+		"ssrc":1234,
+		"packetsReceived":10,
+		"bytesReceived":0,
+		"packetsForwarded":9,
+		"bytesForwarded":0,
+		"packetsLost":0,
+		"jitter":0
+	}]}`, w.Body.String())
+}
+
+func TestAPI_usage(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	usage := quota.NewTracker(quota.Params{Bytes: 100})
+	mux := newAPIMuxWithQuota(mrm, newMockTracksManager(), usage)
+
+	counter := usage.Open("room1", "a")
+	defer counter.Close()
+	counter.AddSent(80)
+	counter.AddReceived(30)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/api/rooms/room1/stats/usage", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"a":{
+		"bytesSent":80,
+		"bytesReceived":30,
+		"exceeded":true
+	}}`, w.Body.String())
+}
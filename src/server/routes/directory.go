@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jeremija/peer-calls/src/server/room"
+)
+
+// RoomDirectory lists the active rooms that opted in to being listed.
+type RoomDirectory interface {
+	List(query room.DirectoryQuery) (room.DirectoryPage, error)
+}
+
+// Lists a page of rooms for a lobby. The prefix, after and limit query
+// parameters are passed on in the room.DirectoryQuery.
+func newDirectoryHandler(directory RoomDirectory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		query := room.DirectoryQuery{
+			Prefix: values.Get("prefix"),
+			After:  values.Get("after"),
+		}
+
+		if limit := values.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit: "+limit, http.StatusBadRequest)
+				return
+			}
+			query.Limit = n
+		}
+
+		page, err := directory.List(query)
+		if err != nil {
+			log.Printf("Error listing rooms: %s", err)
+			http.Error(w, "Error listing rooms", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			log.Printf("Error encoding rooms: %s", err)
+		}
+	}
+}
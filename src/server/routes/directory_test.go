@@ -0,0 +1,91 @@
+package routes_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/routes"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRoomDirectory struct {
+	queries []room.DirectoryQuery
+	page    room.DirectoryPage
+	err     error
+}
+
+func (d *mockRoomDirectory) List(query room.DirectoryQuery) (room.DirectoryPage, error) {
+	d.queries = append(d.queries, query)
+	return d.page, d.err
+}
+
+func newDirectoryMux(mrm *MockRoomManager, directory routes.RoomDirectory) *routes.Mux {
+	return routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, newMockTracksManager(), nil, nil, directory, config.AdminConfig{}, nil)
+}
+
+func TestDirectory(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	directory := &mockRoomDirectory{
+		page: room.DirectoryPage{
+			Rooms: []room.DirectoryEntry{{Room: "team-a", Participants: 2, Topic: "topic"}},
+			Next:  "team-a",
+		},
+	}
+	mux := newDirectoryMux(mrm, directory)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/rooms?prefix=team-&after=team&limit=1", nil)
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []room.DirectoryQuery{{Prefix: "team-", After: "team", Limit: 1}}, directory.queries)
+	assert.JSONEq(t, `{
+		"rooms": [{"room":"team-a","participants":2,"topic":"topic","locked":false}],
+		"next": "team-a"
+	}`, w.Body.String())
+}
+
+func TestDirectory_invalidLimit(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	directory := &mockRoomDirectory{}
+	mux := newDirectoryMux(mrm, directory)
+
+	for _, limit := range []string{"-1", "ten"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/test/rooms?limit="+limit, nil)
+		mux.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	assert.Empty(t, directory.queries)
+}
+
+func TestDirectory_error(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newDirectoryMux(mrm, &mockRoomDirectory{err: errors.New("test")})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/rooms", nil)
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestDirectory_disabled(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := newDirectoryMux(mrm, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/rooms", nil)
+	mux.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"unsafe"
+
+	"github.com/pion/ice"
+	"github.com/pion/webrtc/v2"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Sets the DSCP field of packets sent from the sockets of the local ICE
+// candidates of peerConnection. Must be called after the candidates were
+// gathered, e.g. once ICE has connected. pion does not expose the sockets,
+// so they are obtained through unexported fields, like the media engine in
+// NewPeerToServerRoomHandler. Relayed candidates are skipped because their
+// socket is owned by the TURN client.
+func setDSCP(peerConnection *webrtc.PeerConnection, dscp int) error {
+	gatherer := unexportedField(reflect.ValueOf(peerConnection).Elem(), "iceGatherer")
+	if gatherer.IsNil() {
+		return fmt.Errorf("setDSCP: no ICE gatherer")
+	}
+	agent, ok := unexportedField(gatherer.Elem(), "agent").Interface().(*ice.Agent)
+	if !ok || agent == nil {
+		return fmt.Errorf("setDSCP: no ICE agent")
+	}
+
+	candidates, err := agent.GetLocalCandidates()
+	if err != nil {
+		return fmt.Errorf("setDSCP: error retrieving local candidates: %w", err)
+	}
+
+	for _, candidate := range candidates {
+		conn, ok := candidateConn(candidate).(*net.UDPConn)
+		if !ok {
+			continue
+		}
+		if err := setConnDSCP(conn, candidate.NetworkType().IsIPv6(), dscp); err != nil {
+			return fmt.Errorf("setDSCP: error marking candidate %s: %w", candidate, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the socket of a local candidate, or nil when it is not set.
+func candidateConn(candidate ice.Candidate) net.PacketConn {
+	conn, _ := unexportedField(reflect.ValueOf(candidate).Elem(), "conn").Interface().(net.PacketConn)
+	return conn
+}
+
+func setConnDSCP(conn *net.UDPConn, ipv6Conn bool, dscp int) error {
+	// the DSCP is the upper six bits of the traffic class
+	tos := dscp << 2
+	if ipv6Conn {
+		return ipv6.NewConn(conn).SetTrafficClass(tos)
+	}
+	return ipv4.NewConn(conn).SetTOS(tos)
+}
+
+func unexportedField(v reflect.Value, name string) reflect.Value {
+	field := v.FieldByName(name)
+	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+}
@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/pion/ice"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+)
+
+func TestSetDSCP(t *testing.T) {
+	findIPv4Interface(t)
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(newSettingEngine(config.NetworkConfigSFU{
+		IPFamilies: config.IPFamilyIPv4,
+	})))
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer peerConnection.Close()
+
+	_, err = peerConnection.CreateDataChannel("data", nil)
+	require.Nil(t, err)
+	offer, err := peerConnection.CreateOffer(nil)
+	require.Nil(t, err)
+	// candidates are gathered synchronously without trickle
+	require.Nil(t, peerConnection.SetLocalDescription(offer))
+
+	require.Nil(t, setDSCP(peerConnection, 46))
+
+	agent, ok := unexportedField(unexportedField(reflect.ValueOf(peerConnection).Elem(), "iceGatherer").Elem(), "agent").Interface().(*ice.Agent)
+	require.True(t, ok)
+	candidates, err := agent.GetLocalCandidates()
+	require.Nil(t, err)
+	require.NotEmpty(t, candidates)
+	for _, candidate := range candidates {
+		conn, ok := candidateConn(candidate).(*net.UDPConn)
+		require.True(t, ok, "unexpected conn of candidate %s", candidate)
+		tos, err := ipv4.NewConn(conn).TOS()
+		require.Nil(t, err)
+		assert.Equal(t, 46<<2, tos, "TOS of candidate %s", candidate)
+	}
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// Returns the hangUp message broadcast after the peer connection of a client
+// closed. When ICE failed, the client is expected to reconnect and other
+// clients are told how long to wait for it.
+func newHangUpMessage(room string, clientID string, reason signals.CloseReason, reconnectWindow time.Duration) wsmessage.Message {
+	if reason != signals.CloseReasonICEFailed || reconnectWindow <= 0 {
+		return wsmessage.NewMessage("hangUp", room, map[string]string{
+			"userId": clientID,
+		})
+	}
+	return wsmessage.NewMessage("hangUp", room, map[string]interface{}{
+		"userId":            clientID,
+		"reconnecting":      true,
+		"reconnectWindowMs": reconnectWindow.Milliseconds(),
+	})
+}
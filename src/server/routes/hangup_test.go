@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHangUpMessage(t *testing.T) {
+	for _, reason := range []signals.CloseReason{
+		signals.CloseReasonHangUp,
+		signals.CloseReasonNegotiationFailed,
+		signals.CloseReasonKicked,
+		signals.CloseReasonShutdown,
+	} {
+		assert.Equal(t, wsmessage.NewMessage("hangUp", "test-room", map[string]string{
+			"userId": "client1",
+		}), newHangUpMessage("test-room", "client1", reason, 30*time.Second), "reason: %s", reason)
+	}
+
+	assert.Equal(t, wsmessage.NewMessage("hangUp", "test-room", map[string]interface{}{
+		"userId":            "client1",
+		"reconnecting":      true,
+		"reconnectWindowMs": int64(30000),
+	}), newHangUpMessage("test-room", "client1", signals.CloseReasonICEFailed, 30*time.Second))
+
+	assert.Equal(t, wsmessage.NewMessage("hangUp", "test-room", map[string]string{
+		"userId": "client1",
+	}), newHangUpMessage("test-room", "client1", signals.CloseReasonICEFailed, 0))
+}
@@ -0,0 +1,20 @@
+package routes
+
+import "github.com/jeremija/peer-calls/src/server/config"
+
+// RoomICEServers resolves the ICE servers sent to the clients of a room.
+type RoomICEServers struct {
+	// ICE servers for rooms without an override.
+	Default []config.ICEServer
+	// ICE servers overriding Default, keyed by room name.
+	Rooms map[string][]config.ICEServer
+}
+
+// Get returns the ICE servers for room, falling back to the default ICE
+// servers when the room has no override.
+func (r RoomICEServers) Get(room string) []config.ICEServer {
+	if iceServers, ok := r.Rooms[room]; ok {
+		return iceServers
+	}
+	return r.Default
+}
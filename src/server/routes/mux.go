@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
 	"html/template"
 	"net/http"
@@ -12,27 +13,89 @@ import (
 	"github.com/jeremija/peer-calls/src/server/basen"
 	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/iceauth"
+	"github.com/jeremija/peer-calls/src/server/quota"
 	"github.com/jeremija/peer-calls/src/server/render"
+	"github.com/jeremija/peer-calls/src/server/webhook"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/jeremija/peer-calls/src/server/wshandler"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Types of messages sent by clients that are handled by the mesh and SFU
+// room handlers.
+var clientMessageTypes = []string{
+	"ready",
+	"signal",
+	"hangUp",
+	wsmessage.MessageTypeChat,
+	wsmessage.MessageTypeRaiseHand,
+	wsmessage.MessageTypeReaction,
+	wsmessage.MessageTypeVideoRequest,
+}
+
+// Namespace is an additional signaling handler with its own rooms, served
+// under /ns/<Name>/ws. The API and room directory of the namespace are served
+// under /ns/<Name>/api and /ns/<Name>/rooms.
+type Namespace struct {
+	Name   string
+	Rooms  RoomManager
+	Tracks TracksManager
+	// Accounts the usage of the clients of the namespace. Optional.
+	Quota *quota.Tracker
+	// Notified about the rooms of the namespace. Optional.
+	Webhooks   *webhook.Dispatcher
+	ICEServers RoomICEServers
+	// The room directory is not served when nil.
+	Directory RoomDirectory
+}
+
 type Mux struct {
 	BaseURL    string
 	handler    *chi.Mux
-	iceServers []config.ICEServer
+	admin      *chi.Mux
+	iceServers RoomICEServers
+	wss        []*wshandler.WSS
 }
 
 func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mux.handler.ServeHTTP(w, r)
 }
 
+// Returns the handler of the metrics and API endpoints when they are served
+// by a separate admin listener, or nil when they are served by the Mux.
+func (mux *Mux) AdminHandler() http.Handler {
+	if mux.admin == nil {
+		return nil
+	}
+	return mux.admin
+}
+
+// Closes the websocket connections of all rooms and waits until they are
+// closed or ctx is done.
+func (mux *Mux) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, wss := range mux.wss {
+		if err := wss.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func NewMux(
 	baseURL string,
 	version string,
 	network config.NetworkConfig,
-	iceServers []config.ICEServer,
+	iceServers RoomICEServers,
+	ws config.WSConfig,
+	api config.APIConfig,
 	rooms RoomManager,
 	tracks TracksManager,
+	usage *quota.Tracker,
+	webhooks *webhook.Dispatcher,
+	directory RoomDirectory,
+	admin config.AdminConfig,
+	namespaces []Namespace,
 ) *Mux {
 	box := packr.NewBox("../templates")
 	templates := render.ParseTemplates(box)
@@ -52,12 +115,41 @@ func NewMux(
 		root = baseURL
 	}
 
-	wsHandler := newWebSocketHandler(
-		network,
-		wshandler.NewWSS(rooms),
-		iceServers,
-		tracks,
-	)
+	newWSHandler := func(
+		rooms RoomManager,
+		tracks TracksManager,
+		usage *quota.Tracker,
+		webhooks *webhook.Dispatcher,
+		iceServers RoomICEServers,
+	) http.Handler {
+		wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+			Rooms:        rooms,
+			Config:       ws,
+			Quota:        usage,
+			Webhooks:     webhooks,
+			Serializer:   network.Serializer,
+			MessageTypes: clientMessageTypes,
+		})
+		mux.wss = append(mux.wss, wss)
+		return newWebSocketHandler(
+			network,
+			wss,
+			iceServers,
+			rooms,
+			tracks,
+		)
+	}
+
+	adminRoutes := func(router chi.Router) {
+		router.Handle("/metrics", promhttp.Handler())
+
+		if api.Token != "" {
+			router.Mount("/api", newAPIHandler(api, rooms, tracks, usage))
+			for _, namespace := range namespaces {
+				router.Mount("/ns/"+namespace.Name+"/api", newAPIHandler(api, namespace.Rooms, namespace.Tracks, namespace.Quota))
+			}
+		}
+	}
 
 	handler.Route(root, func(router chi.Router) {
 		router.Get("/", renderer.Render(mux.routeIndex))
@@ -65,29 +157,90 @@ func NewMux(
 		router.Handle("/res/*", static(baseURL+"/res", packr.NewBox("../../../res")))
 		router.Post("/call", mux.routeNewCall)
 		router.Get("/call/{callID}", renderer.Render(mux.routeCall))
+		if directory != nil {
+			router.Get("/rooms", newDirectoryHandler(directory))
+		}
+
+		router.Mount("/ws", newWSHandler(rooms, tracks, usage, webhooks, iceServers))
 
-		router.Mount("/ws", wsHandler)
+		for _, namespace := range namespaces {
+			log.Printf("Serving namespace %s", namespace.Name)
+			prefix := "/ns/" + namespace.Name
+			router.Mount(prefix+"/ws", newWSHandler(
+				namespace.Rooms,
+				namespace.Tracks,
+				namespace.Quota,
+				namespace.Webhooks,
+				namespace.ICEServers,
+			))
+			if namespace.Directory != nil {
+				router.Get(prefix+"/rooms", newDirectoryHandler(namespace.Directory))
+			}
+		}
+
+		if admin.BindPort == 0 {
+			adminRoutes(router)
+		}
 	})
 
+	if admin.BindPort != 0 {
+		mux.admin = chi.NewRouter()
+		mux.admin.Route(root, adminRoutes)
+	}
+
 	return mux
 }
 
 func newWebSocketHandler(
 	network config.NetworkConfig,
 	wss *wshandler.WSS,
-	iceServers []config.ICEServer,
+	iceServers RoomICEServers,
+	rooms RoomManager,
 	tracks TracksManager,
 ) http.Handler {
 	switch network.Type {
 	case config.NetworkTypeSFU:
 		log.Println("Using network type sfu")
-		return NewPeerToServerRoomHandler(wss, iceServers, network.SFU, tracks)
+		return NewPeerToServerRoomHandler(wss, iceServers, network, tracks)
 	default:
+		if network.AutoSFUThreshold > 0 {
+			log.Printf("Using network type mesh, sfu above %d connections", network.AutoSFUThreshold)
+			return newAutoSFUHandler(
+				wss,
+				rooms,
+				NewPeerToPeerRoomHandler(wss),
+				NewPeerToServerRoomHandler(wss, iceServers, network, tracks),
+			)
+		}
 		log.Println("Using network type mesh")
 		return NewPeerToPeerRoomHandler(wss)
 	}
 }
 
+// Selects the handler for each websocket connection based on the network
+// type the room manager decides when the connection enters the room. The room
+// is entered here, so that the decision and the entry cannot race with other
+// connections, and is exited once the selected handler returns.
+func newAutoSFUHandler(wss *wshandler.WSS, rooms RoomManager, mesh http.Handler, sfu http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		room := path.Base(path.Dir(r.URL.Path))
+		adapter, networkType, err := rooms.EnterWithNetworkType(room)
+		if err != nil {
+			log.Printf("Error entering room: %s: %s", room, err)
+			wss.RejectEnter(w, r, room, err)
+			return
+		}
+		defer rooms.Exit(room)
+
+		r = r.WithContext(wshandler.WithEnteredRoom(r.Context(), room, adapter))
+		if networkType == config.NetworkTypeSFU {
+			sfu.ServeHTTP(w, r)
+			return
+		}
+		mesh.ServeHTTP(w, r)
+	})
+}
+
 func static(prefix string, box packr.Box) http.Handler {
 	fileServer := http.FileServer(http.FileSystem(box))
 	return http.StripPrefix(prefix, fileServer)
@@ -107,10 +260,11 @@ func (mux *Mux) routeIndex(w http.ResponseWriter, r *http.Request) (string, inte
 }
 
 func (mux *Mux) routeCall(w http.ResponseWriter, r *http.Request) (string, interface{}, error) {
-	callID := url.PathEscape(path.Base(r.URL.Path))
+	room := path.Base(r.URL.Path)
+	callID := url.PathEscape(room)
 	userID := basen.NewUUIDBase62()
 
-	iceServers := iceauth.GetICEServers(mux.iceServers)
+	iceServers := iceauth.GetICEServers(mux.iceServers.Get(room))
 	iceServersJSON, _ := json.Marshal(iceServers)
 
 	data := map[string]interface{}{
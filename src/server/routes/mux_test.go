@@ -1,18 +1,27 @@
 package routes_test
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/factory/adapter"
+	"github.com/jeremija/peer-calls/src/server/quota"
+	"github.com/jeremija/peer-calls/src/server/room"
 	"github.com/jeremija/peer-calls/src/server/routes"
+	"github.com/jeremija/peer-calls/src/server/webhook"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
 )
 
-var iceServers = []config.ICEServer{}
+var iceServers = routes.RoomICEServers{}
 
 func mesh() (network config.NetworkConfig) {
 	network.Type = config.NetworkTypeMesh
@@ -23,7 +32,7 @@ func Test_routeIndex(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/test", nil)
 
@@ -37,7 +46,7 @@ func Test_routeIndex_noBaseURL(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := routes.NewMux("", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := routes.NewMux("", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/", nil)
 
@@ -51,7 +60,7 @@ func Test_routeNewCall_name(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
 	w := httptest.NewRecorder()
 	reader := strings.NewReader("call=my room")
 	r := httptest.NewRequest("POST", "/test/call", reader)
@@ -67,7 +76,7 @@ func Test_routeNewCall_random(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("POST", "/test/call", nil)
 	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -83,10 +92,12 @@ func Test_routeCall(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	iceServers := []config.ICEServer{{
-		URLs: []string{"stun:"},
-	}}
-	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	iceServers := routes.RoomICEServers{
+		Default: []config.ICEServer{{
+			URLs: []string{"stun:"},
+		}},
+	}
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/test/call/abc", nil)
 	mux.ServeHTTP(w, r)
@@ -96,3 +107,272 @@ func Test_routeCall(t *testing.T) {
 	assert.Regexp(t, "id=\"iceServers\" value='.*stun:", w.Body.String())
 	assert.Regexp(t, "id=\"userId\" value=\"[^\"]", w.Body.String())
 }
+
+func Test_routeCall_roomICEServers(t *testing.T) {
+	mrm := NewMockRoomManager()
+	trk := newMockTracksManager()
+	defer mrm.close()
+	iceServers := routes.RoomICEServers{
+		Default: []config.ICEServer{{
+			URLs: []string{"stun:default.example.com"},
+		}},
+		Rooms: map[string][]config.ICEServer{
+			"private": {{
+				URLs: []string{"stun:private.example.com"},
+			}},
+		},
+	}
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/call/private", nil)
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, "id=\"iceServers\" value='.*stun:private.example.com", w.Body.String())
+	assert.NotContains(t, w.Body.String(), "stun:default.example.com")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/test/call/public", nil)
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, "id=\"iceServers\" value='.*stun:default.example.com", w.Body.String())
+	assert.NotContains(t, w.Body.String(), "stun:private.example.com")
+}
+
+func Test_routeMetrics(t *testing.T) {
+	mrm := NewMockRoomManager()
+	trk := newMockTracksManager()
+	defer mrm.close()
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/metrics", nil)
+	mux.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, "go_goroutines", w.Body.String())
+}
+
+func Test_adminListener(t *testing.T) {
+	mrm := NewMockRoomManager()
+	trk := newMockTracksManager()
+	defer mrm.close()
+	api := config.APIConfig{Token: "secret"}
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, api, mrm, trk, nil, nil, nil, config.AdminConfig{
+		BindPort: 9090,
+	}, nil)
+	admin := mux.AdminHandler()
+	require.NotNil(t, admin)
+
+	for _, path := range []string{"/test/metrics", "/test/api/rooms/room1/subscriptions"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s should not be served by the public listener", path)
+
+		w = httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, "%s should be served by the admin listener", path)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test", nil)
+	admin.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code, "the admin listener should not serve public routes")
+}
+
+func Test_adminListener_disabled(t *testing.T) {
+	mrm := NewMockRoomManager()
+	trk := newMockTracksManager()
+	defer mrm.close()
+	mux := routes.NewMux("/test", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, mrm, trk, nil, nil, nil, config.AdminConfig{}, nil)
+	assert.Nil(t, mux.AdminHandler())
+}
+
+func Test_autoSFUThreshold(t *testing.T) {
+	network := mesh()
+	network.AutoSFUThreshold = 2
+
+	for _, networkType := range []config.NetworkType{config.NetworkTypeMesh, config.NetworkTypeSFU} {
+		t.Run(string(networkType), func(t *testing.T) {
+			rooms := NewMockRoomManager()
+			rooms.networkType = networkType
+			defer rooms.close()
+			mux := routes.NewMux("", "v0.0.0", network, iceServers, config.WSConfig{}, config.APIConfig{}, rooms, newMockTracksManager(), nil, nil, nil, config.AdminConfig{}, nil)
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/" + clientID
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			ws := mustDialWS(t, ctx, url)
+			defer ws.Close(websocket.StatusNormalClosure, "")
+			mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+				"nickname": "abc",
+			}))
+
+			msg := <-rooms.broadcast
+			assert.Equal(t, "users", msg.Type)
+			payload, ok := msg.Payload.(map[string]interface{})
+			require.True(t, ok)
+			if networkType == config.NetworkTypeSFU {
+				assert.Equal(t, []string{"__SERVER__"}, payload["peerIds"])
+			} else {
+				assert.Equal(t, []string{"client1"}, payload["peerIds"])
+			}
+		})
+	}
+}
+
+func Test_namespaces(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	rooms1 := NewMockRoomManager()
+	defer rooms1.close()
+	rooms2 := NewMockRoomManager()
+	defer rooms2.close()
+	mux := routes.NewMux("", "v0.0.0", mesh(), iceServers, config.WSConfig{}, config.APIConfig{}, rooms, newMockTracksManager(), nil, nil, nil, config.AdminConfig{}, []routes.Namespace{
+		{Name: "app1", Rooms: rooms1, Tracks: newMockTracksManager()},
+		{Name: "app2", Rooms: rooms2, Tracks: newMockTracksManager()},
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, baseURL+"/ns/app1/ws/"+roomName+"/"+clientID)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage(wsmessage.MessageTypeChat, roomName, "hello"))
+
+	assert.Equal(t, roomName, <-rooms1.enter)
+	assert.Equal(t, wsmessage.NewMessageChat(roomName, clientID, "hello"), <-rooms1.broadcast)
+	assert.Empty(t, rooms.enter)
+	assert.Empty(t, rooms2.enter)
+	assert.Empty(t, rooms.broadcast)
+	assert.Empty(t, rooms2.broadcast)
+
+	_, _, err := websocket.Dial(ctx, baseURL+"/ns/app3/ws/"+roomName+"/"+clientID, nil)
+	assert.Error(t, err, "unknown namespaces should not be served")
+}
+
+type webhookReceiver struct {
+	events chan webhook.Event
+}
+
+func (r webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var event webhook.Event
+	if err := json.NewDecoder(req.Body).Decode(&event); err == nil {
+		r.events <- event
+	}
+}
+
+func Test_namespaces_isolated(t *testing.T) {
+	recv := webhookReceiver{events: make(chan webhook.Event, 10)}
+	webhookServer := httptest.NewServer(recv)
+	defer webhookServer.Close()
+	webhooks := webhook.NewDispatcher(webhook.Params{URLs: []string{webhookServer.URL}})
+	defer webhooks.Wait()
+
+	newNamespace := func(name string) routes.Namespace {
+		adapters := adapter.NewAdapterFactory(config.StoreConfig{Type: config.StoreTypeMemory})
+		namespaceWebhooks := webhooks.WithNamespace(name)
+		rooms := room.NewRoomManagerWithParams(adapters.NewAdapter, room.Params{
+			Webhooks: namespaceWebhooks,
+		})
+		return routes.Namespace{
+			Name:      name,
+			Rooms:     rooms,
+			Tracks:    newMockTracksManager(),
+			Quota:     quota.NewTracker(quota.Params{}),
+			Webhooks:  namespaceWebhooks,
+			Directory: room.NewDirectory(rooms, adapters.RoomMetadataStore),
+		}
+	}
+	app1 := newNamespace("app1")
+	app2 := newNamespace("app2")
+	defaultNamespace := newNamespace("")
+
+	api := config.APIConfig{Token: "secret"}
+	mux := routes.NewMux("", "v0.0.0", mesh(), iceServers, config.WSConfig{}, api, defaultNamespace.Rooms, defaultNamespace.Tracks, defaultNamespace.Quota, webhooks, defaultNamespace.Directory, config.AdminConfig{}, []routes.Namespace{app1, app2})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	baseURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// the same client ID joins the same room name in both namespaces
+	ws1 := mustDialWS(t, ctx, baseURL+"/ns/app1/ws/"+roomName+"/"+clientID)
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, ws1).Type)
+	assert.Equal(t, wsmessage.MessageTypeRoomState, mustReadWS(t, ctx, ws1).Type)
+	ws2 := mustDialWS(t, ctx, baseURL+"/ns/app2/ws/"+roomName+"/"+clientID)
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, ws2).Type)
+	assert.Equal(t, wsmessage.MessageTypeRoomState, mustReadWS(t, ctx, ws2).Type)
+
+	assert.Len(t, app1.Quota.Usage(roomName), 1)
+	assert.Len(t, app2.Quota.Usage(roomName), 1)
+
+	namespaces := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		event := <-recv.events
+		assert.Equal(t, roomName, event.Room)
+		namespaces[event.Namespace+":"+string(event.Type)] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"app1:room_created": true,
+		"app1:room_join":    true,
+		"app2:room_created": true,
+		"app2:room_join":    true,
+	}, namespaces)
+
+	mustWriteWS(t, ctx, ws1, wsmessage.NewMessage(wsmessage.MessageTypeChat, roomName, "hello"))
+	assert.Equal(t, wsmessage.NewMessageChat(roomName, clientID, "hello"), mustReadWS(t, ctx, ws1))
+
+	// the connection is closed when the read times out
+	readCtx, readCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer readCancel()
+	_, _, err := ws2.Read(readCtx)
+	assert.Error(t, err, "the chat message should not be received in the other namespace")
+
+	serve := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("PUT", "/ns/app1/api/rooms/"+roomName+"/metadata", `{"listed":true}`)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	for url, rooms := range map[string]int{
+		"/ns/app1/rooms": 1,
+		"/ns/app2/rooms": 0,
+		"/rooms":         0,
+	} {
+		w = serve("GET", url, "")
+		require.Equal(t, http.StatusOK, w.Code, url)
+		var page room.DirectoryPage
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, rooms, len(page.Rooms), url)
+	}
+
+	for url, clients := range map[string]int{
+		"/ns/app1/api/rooms/" + roomName + "/stats/usage": 1,
+		"/api/rooms/" + roomName + "/stats/usage":         0,
+	} {
+		w = serve("GET", url, "")
+		require.Equal(t, http.StatusOK, w.Code, url)
+		var usage map[string]quota.Usage
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &usage))
+		assert.Equal(t, clients, len(usage), url)
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(app2.Quota.Usage(roomName)) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Len(t, app1.Quota.Usage(roomName), 1, "usage should be tracked per namespace")
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"net/http"
 
+	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/logger"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
@@ -14,8 +15,19 @@ type AdapterFactory func(room string) wsadapter.Adapter
 var log = logger.GetLogger("ws")
 
 type RoomManager interface {
-	Enter(room string) wsadapter.Adapter
+	Enter(room string) (wsadapter.Adapter, error)
 	Exit(room string)
+	Closed(room string) <-chan struct{}
+	// Enters the room and returns the network type the entering connection
+	// should use.
+	EnterWithNetworkType(room string) (wsadapter.Adapter, config.NetworkType, error)
+	// Enters the room only when it exists, otherwise returns
+	// room.ErrRoomNotFound.
+	EnterExisting(room string) (wsadapter.Adapter, error)
+	// Enters the room without creating it, for example to set its password
+	// before the first client connects. Must be exited with ExitUncounted.
+	EnterUncounted(room string) (wsadapter.Adapter, error)
+	ExitUncounted(room string)
 }
 
 type ReadyMessage struct {
@@ -36,9 +48,17 @@ func NewPeerToPeerRoomHandler(wss *wshandler.WSS) http.Handler {
 
 			switch msg.Type {
 			case "ready":
-				// FIXME check for errors
 				payload, _ := msg.Payload.(map[string]interface{})
-				adapter.SetMetadata(clientID, payload["nickname"].(string))
+				nickname, _ := payload["nickname"].(string)
+
+				if metadataErr := wss.CheckMetadata(nickname); metadataErr != nil {
+					log.Printf("[%s] Rejected metadata: %s", clientID, metadataErr)
+					responseEventName = wsmessage.MessageTypeError
+					err = adapter.Emit(clientID, wsmessage.NewMessageError(room, wshandler.MetadataErrorCode(metadataErr), metadataErr.Error()))
+					break
+				}
+
+				adapter.SetMetadata(clientID, nickname)
 
 				clients, err := getReadyClients(adapter)
 				if err != nil {
@@ -53,6 +73,12 @@ func NewPeerToPeerRoomHandler(wss *wshandler.WSS) http.Handler {
 						"nicknames": clients,
 					}),
 				)
+			case wsmessage.MessageTypeChat:
+				responseEventName = wsmessage.MessageTypeChat
+				err = adapter.Broadcast(wsmessage.NewMessageChat(room, clientID, msg.Payload))
+			case wsmessage.MessageTypeRaiseHand, wsmessage.MessageTypeReaction:
+				responseEventName = msg.Type
+				err = handleParticipantMessage(adapter, room, clientID, msg)
 			case "signal":
 				payload, _ := msg.Payload.(map[string]interface{})
 				signal, _ := payload["signal"]
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/room"
 	"github.com/jeremija/peer-calls/src/server/routes"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
@@ -17,10 +19,15 @@ import (
 )
 
 type MockRoomManager struct {
-	enter     chan string
-	exit      chan string
-	emit      chan Emit
-	broadcast chan wsmessage.Message
+	enter       chan string
+	exit        chan string
+	emit        chan Emit
+	broadcast   chan wsmessage.Message
+	password    chan string
+	networkType config.NetworkType
+	metadata    *wsadapter.MemoryRoomMetadataStore
+	// EnterExisting returns room.ErrRoomNotFound for these rooms.
+	missingRooms map[string]struct{}
 }
 
 type Emit struct {
@@ -34,29 +41,61 @@ func NewMockRoomManager() *MockRoomManager {
 		exit:      make(chan string, 10),
 		emit:      make(chan Emit, 10),
 		broadcast: make(chan wsmessage.Message, 10),
+		password:  make(chan string, 10),
+		metadata:  wsadapter.NewMemoryRoomMetadataStore(),
 	}
 }
 
-func (r *MockRoomManager) Enter(room string) wsadapter.Adapter {
+func (r *MockRoomManager) Enter(room string) (wsadapter.Adapter, error) {
 	r.enter <- room
-	return &MockAdapter{room: room, emit: r.emit, broadcast: r.broadcast}
+	return &MockAdapter{room: room, emit: r.emit, broadcast: r.broadcast, password: r.password, metadata: r.metadata}, nil
 }
 
 func (r *MockRoomManager) Exit(room string) {
 	r.exit <- room
 }
 
+func (r *MockRoomManager) Closed(room string) <-chan struct{} {
+	return nil
+}
+
+func (r *MockRoomManager) EnterExisting(name string) (wsadapter.Adapter, error) {
+	if _, ok := r.missingRooms[name]; ok {
+		return nil, room.ErrRoomNotFound
+	}
+	return r.Enter(name)
+}
+
+func (r *MockRoomManager) EnterUncounted(room string) (wsadapter.Adapter, error) {
+	return r.Enter(room)
+}
+
+func (r *MockRoomManager) ExitUncounted(room string) {
+	r.Exit(room)
+}
+
+func (r *MockRoomManager) EnterWithNetworkType(room string) (wsadapter.Adapter, config.NetworkType, error) {
+	adapter, err := r.Enter(room)
+	if r.networkType == "" {
+		return adapter, config.NetworkTypeMesh, err
+	}
+	return adapter, r.networkType, err
+}
+
 func (r *MockRoomManager) close() {
 	close(r.enter)
 	close(r.exit)
 	close(r.emit)
 	close(r.broadcast)
+	close(r.password)
 }
 
 type MockAdapter struct {
 	room      string
 	emit      chan Emit
 	broadcast chan wsmessage.Message
+	password  chan string
+	metadata  *wsadapter.MemoryRoomMetadataStore
 }
 
 func (m *MockAdapter) Add(client wsadapter.Client) error {
@@ -67,11 +106,28 @@ func (m *MockAdapter) Remove(clientID string) error {
 	return nil
 }
 
+func (m *MockAdapter) RemoveWithReason(clientID string, reason string) error {
+	return nil
+}
+
+func (m *MockAdapter) RemoveWithMessage(clientID string, leave wsmessage.Message) error {
+	return nil
+}
+
 func (m *MockAdapter) Broadcast(message wsmessage.Message) error {
 	m.broadcast <- message
 	return nil
 }
 
+func (m *MockAdapter) BroadcastTo(clientIDs []string, message wsmessage.Message) error {
+	for _, clientID := range clientIDs {
+		if err := m.Emit(clientID, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *MockAdapter) SetMetadata(clientID string, metadata string) bool {
 	return true
 }
@@ -80,6 +136,17 @@ func (m *MockAdapter) Clients() (map[string]string, error) {
 	return map[string]string{"client1": "abc"}, nil
 }
 
+func (m *MockAdapter) RoomMetadata() (wsadapter.RoomMetadata, error) {
+	return m.metadata.RoomMetadata(m.room)
+}
+
+func (m *MockAdapter) SetRoomMetadata(metadata wsadapter.RoomMetadata) error {
+	if err := m.metadata.SetRoomMetadata(m.room, metadata); err != nil {
+		return err
+	}
+	return m.Broadcast(wsmessage.NewMessageRoomMetadata(m.room, metadata))
+}
+
 func (m *MockAdapter) Close() error {
 	return nil
 }
@@ -88,6 +155,23 @@ func (m *MockAdapter) Size() (int, error) {
 	return 0, nil
 }
 
+func (m *MockAdapter) CreatedAt() (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (m *MockAdapter) RoomPassword() (string, error) {
+	return "", nil
+}
+
+func (m *MockAdapter) Owner() (string, error) {
+	return "", nil
+}
+
+func (m *MockAdapter) SetRoomPassword(hash string) error {
+	m.password <- hash
+	return nil
+}
+
 func (m *MockAdapter) Metadata(clientID string) (string, bool) {
 	return "", true
 }
@@ -130,7 +214,7 @@ func mustReadWS(t *testing.T, ctx context.Context, ws *websocket.Conn) wsmessage
 }
 
 func setupServer(rooms routes.RoomManager) (server *httptest.Server, url string) {
-	handler := routes.NewPeerToPeerRoomHandler(wshandler.NewWSS(rooms))
+	handler := routes.NewPeerToPeerRoomHandler(wshandler.NewWSS(rooms, config.WSConfig{}))
 	server = httptest.NewServer(handler)
 	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/" + clientID
 	return
@@ -195,6 +279,9 @@ func TestWS_event_signal(t *testing.T) {
 	}))
 	emit, ok := <-rooms.emit
 	require.True(t, ok, "rooms.emit channel is closed")
+	assert.Equal(t, wsmessage.MessageTypeRoomState, emit.message.Type)
+	emit, ok = <-rooms.emit
+	require.True(t, ok, "rooms.emit channel is closed")
 	assert.Equal(t, emit.clientID, otherClientID)
 	assert.Equal(t, "signal", emit.message.Type)
 	payload, ok := emit.message.Payload.(map[string]interface{})
@@ -202,3 +289,112 @@ func TestWS_event_signal(t *testing.T) {
 	assert.Equal(t, signal, payload["signal"])
 	assert.Equal(t, clientID, payload["userId"])
 }
+
+func TestWS_event_chat(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	server, url := setupServer(rooms)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws := mustDialWS(t, ctx, url)
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage(wsmessage.MessageTypeChat, "test-room", "hello"))
+	msg := <-rooms.broadcast
+	assert.Equal(t, wsmessage.NewMessageChat("test-room", clientID, "hello"), msg)
+}
+
+func TestWS_event_raiseHand(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	server, url := setupServer(rooms)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws := mustDialWS(t, ctx, url)
+	start := wsmessage.NewTimestamp(time.Now())
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage(wsmessage.MessageTypeRaiseHand, "test-room", map[string]interface{}{
+		"raised": true,
+	}))
+	msg := <-rooms.broadcast
+	assert.Equal(t, wsmessage.MessageTypeRaiseHand, msg.Type)
+	payload, ok := msg.Payload.(map[string]interface{})
+	require.True(t, ok, "unexpected payload type: %s", msg.Payload)
+	assert.Equal(t, clientID, payload["clientID"])
+	assert.Equal(t, true, payload["raised"])
+	assert.GreaterOrEqual(t, payload["timestamp"], start)
+}
+
+func TestWS_event_reaction(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	server, url := setupServer(rooms)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws := mustDialWS(t, ctx, url)
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage(wsmessage.MessageTypeReaction, "test-room", map[string]interface{}{
+		"reaction": strings.Repeat("a", 33),
+	}))
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage(wsmessage.MessageTypeReaction, "test-room", map[string]interface{}{
+		"reaction": "+1",
+	}))
+	msg := <-rooms.broadcast
+	assert.Equal(t, wsmessage.MessageTypeReaction, msg.Type)
+	payload, ok := msg.Payload.(map[string]interface{})
+	require.True(t, ok, "unexpected payload type: %s", msg.Payload)
+	assert.Equal(t, clientID, payload["clientID"])
+	assert.Equal(t, "+1", payload["reaction"])
+	assert.IsType(t, int64(0), payload["timestamp"])
+}
+
+func TestWS_event_ready_invalidMetadata(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	handler := routes.NewPeerToPeerRoomHandler(wshandler.NewWSS(rooms, config.WSConfig{
+		MetadataMaxLength: 3,
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/" + clientID
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws := mustDialWS(t, ctx, url)
+	emit := <-rooms.emit
+	assert.Equal(t, wsmessage.MessageTypeRoomState, emit.message.Type)
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", "test-room", map[string]interface{}{
+		"nickname": "abcd",
+	}))
+	emit = <-rooms.emit
+	assert.Equal(t, clientID, emit.clientID)
+	assert.Equal(t, wsmessage.MessageTypeError, emit.message.Type)
+	payload, ok := emit.message.Payload.(map[string]string)
+	require.True(t, ok, "unexpected payload type: %s", emit.message.Payload)
+	assert.Equal(t, wsmessage.ErrorCodeInvalidMetadata, payload["code"])
+	assert.Empty(t, rooms.broadcast)
+}
+
+func TestWS_event_ready_metadataRequired(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	handler := routes.NewPeerToPeerRoomHandler(wshandler.NewWSS(rooms, config.WSConfig{
+		RequireMetadata: true,
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/" + clientID + "?metadata=abc"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ws := mustDialWS(t, ctx, url)
+	emit := <-rooms.emit
+	assert.Equal(t, wsmessage.MessageTypeRoomState, emit.message.Type)
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", "test-room", map[string]interface{}{
+		"nickname": "",
+	}))
+	emit = <-rooms.emit
+	assert.Equal(t, clientID, emit.clientID)
+	assert.Equal(t, wsmessage.MessageTypeError, emit.message.Type)
+	payload, ok := emit.message.Payload.(map[string]string)
+	require.True(t, ok, "unexpected payload type: %s", emit.message.Payload)
+	assert.Equal(t, wsmessage.ErrorCodeMetadataRequired, payload["code"])
+	assert.Empty(t, rooms.broadcast)
+}
@@ -1,27 +1,44 @@
 package routes
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"path"
 	"reflect"
-	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/iceauth"
 	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/wrtc/negotiator"
 	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
 	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/jeremija/peer-calls/src/server/wshandler"
 	"github.com/pion/logging"
 	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const localPeerID = "__SERVER__"
 
+var peerConnectionsCreatedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "peercalls_sfu_peer_connections_created_total",
+	Help: "Total number of peer connections created for clients of the SFU",
+})
+
 type TracksManager interface {
 	Add(room string, clientID string, peerConnection tracks.PeerConnection, dataChannel *webrtc.DataChannel, signaller tracks.Signaller) (closeChannel <-chan struct{})
+	CanPublish(room string, clientID string, kind webrtc.RTPCodecType) bool
+	AddTransceivers(room string, n int) bool
+	RemoveTransceivers(room string, n int)
+	Subscriptions(room string) map[string][]tracks.Subscription
+	TrackStats(room string) map[string][]tracks.TrackStats
+	ForwardedVideo(room string) []string
+	RequestVideo(room string, clientID string, clientIDs []string)
 }
 
 type pionLogger struct {
@@ -79,15 +96,34 @@ const serverIsInitiator = true
 
 func NewPeerToServerRoomHandler(
 	wss *wshandler.WSS,
-	iceServers []config.ICEServer,
-	sfuConfig config.NetworkConfigSFU,
+	iceServers RoomICEServers,
+	network config.NetworkConfig,
 	tracksManager TracksManager,
 ) http.Handler {
+	sfuConfig := network.SFU
+	negotiationLimiter := negotiator.NewLimiter(sfuConfig.MaxConcurrentNegotiations)
+	dataChannels := getDataChannelOptions(sfuConfig.DataChannels)
+
+	qualityInterval := sfuConfig.QualityInterval
+	if qualityInterval == 0 {
+		qualityInterval = tracks.DefaultQualityInterval
+	}
+
+	// the certificate has been validated by config.Read
+	var certificates []webrtc.Certificate
+	if cert, err := config.DTLSCertificate(sfuConfig.DTLSCert, sfuConfig.DTLSKey); err != nil {
+		log.Printf("Error loading DTLS certificate, generating certificates instead: %s", err)
+	} else if cert != nil {
+		certificates = []webrtc.Certificate{webrtc.CertificateFromX509(cert.PrivateKey, cert.Leaf)}
+	}
+
+	sessions := newPeerSessions()
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
 
+		room := path.Base(path.Dir(r.URL.Path))
 		webrtcICEServers := []webrtc.ICEServer{}
-		for _, iceServer := range iceauth.GetICEServers(iceServers) {
+		for _, iceServer := range iceauth.GetICEServers(iceServers.Get(room)) {
 			var c webrtc.ICECredentialType
 			if iceServer.Username != "" && iceServer.Credential != "" {
 				c = webrtc.ICECredentialTypePassword
@@ -101,24 +137,13 @@ func NewPeerToServerRoomHandler(
 		}
 
 		webrtcConfig := webrtc.Configuration{
-			ICEServers: webrtcICEServers,
+			ICEServers:   webrtcICEServers,
+			Certificates: certificates,
 		}
 
-		allowedInterfaces := map[string]struct{}{}
-		for _, iface := range sfuConfig.Interfaces {
-			allowedInterfaces[iface] = struct{}{}
-		}
+		allowCandidate := newCandidateFilter(sfuConfig.CandidateAllowCIDRs, sfuConfig.CandidateDenyCIDRs)
 
-		settingEngine := webrtc.SettingEngine{
-			LoggerFactory: pionLoggerFactory{},
-		}
-		if len(allowedInterfaces) > 0 {
-			settingEngine.SetInterfaceFilter(func(iface string) bool {
-				_, ok := allowedInterfaces[iface]
-				return ok
-			})
-		}
-		// settingEngine.SetTrickle(true)
+		settingEngine := newSettingEngine(sfuConfig)
 		api := webrtc.NewAPI(
 			webrtc.WithMediaEngine(webrtc.MediaEngine{}),
 			webrtc.WithSettingEngine(settingEngine),
@@ -136,16 +161,30 @@ func NewPeerToServerRoomHandler(
 			return
 		}
 
-		var signaller *signals.Signaller
-		var signallerMu sync.Mutex
-
 		cleanup := func(event wshandler.CleanupEvent) {
-			signallerMu.Lock()
-			defer signallerMu.Unlock()
+			session, ok := sessions.lookup(event.Room, event.ClientID)
+			if ok {
+				session.mu.Lock()
+				defer session.mu.Unlock()
+			}
+
+			if event.Reason == wsmessage.LeaveReasonReplaced {
+				// the client is still in the room on another connection, which
+				// keeps the peer connection
+				if ok && session.signaller != nil {
+					log.Printf("[%s] cleanup: rebinding signaller to the new connection", event.ClientID)
+					session.signaller.Rebind(newSignalEmitter(event.Adapter, event.Room, event.ClientID))
+				}
+				return
+			}
 
-			if signaller != nil {
-				if err := signaller.Close(); err != nil {
-					log.Printf("[%s] cleanup: error in signaller.Close: %s", event.ClientID, err)
+			if ok {
+				sessions.remove(event.Room, event.ClientID, session)
+				if session.signaller != nil {
+					session.peerClose.setClient(event.Client)
+					if err := session.signaller.CloseWithReason(getCloseReason(event.Reason)); err != nil {
+						log.Printf("[%s] cleanup: error in signaller.Close: %s", event.ClientID, err)
+					}
 				}
 			}
 
@@ -161,8 +200,9 @@ func NewPeerToServerRoomHandler(
 
 		handleMessage := func(event wshandler.RoomEvent) {
 			log.Printf("[%s] got message, %s", event.ClientID, event.Message.Type)
-			signallerMu.Lock()
-			defer signallerMu.Unlock()
+			session := sessions.get(event.Room, event.ClientID)
+			session.mu.Lock()
+			defer session.mu.Unlock()
 
 			msg := event.Message
 			adapter := event.Adapter
@@ -179,8 +219,8 @@ func NewPeerToServerRoomHandler(
 			switch msg.Type {
 			case "hangUp":
 				log.Printf("[%s] hangUp event", clientID)
-				if signaller != nil {
-					err := signaller.Close()
+				if session.signaller != nil {
+					err := session.signaller.Close()
 					if err != nil {
 						err = fmt.Errorf("[%s] hangUp: Error closing peer connection: %s", clientID, err)
 					}
@@ -188,18 +228,31 @@ func NewPeerToServerRoomHandler(
 			case "ready":
 				log.Printf("[%s] Initiator: %s", clientID, initiator)
 
-				peerConnection, err := api.NewPeerConnection(webrtcConfig)
-				if err != nil {
-					err = fmt.Errorf("[%s] Error creating peer connection: %s", clientID, err)
+				payload, _ := msg.Payload.(map[string]interface{})
+				nickname, _ := payload["nickname"].(string)
+
+				if metadataErr := wss.CheckMetadata(nickname); metadataErr != nil {
+					log.Printf("[%s] Rejected metadata: %s", clientID, metadataErr)
+					err = adapter.Emit(clientID, wsmessage.NewMessageError(room, wshandler.MetadataErrorCode(metadataErr), metadataErr.Error()))
 					break
 				}
-				peerConnection.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
-					log.Printf("ICE gathering state changed: %s", state)
-				})
 
-				// FIXME check for errors
-				payload, _ := msg.Payload.(map[string]interface{})
-				adapter.SetMetadata(clientID, payload["nickname"].(string))
+				// a session kept for a reconnecting client still has its peer
+				// connection
+				var peerConnection *webrtc.PeerConnection
+				if session.signaller == nil {
+					peerConnection, err = api.NewPeerConnection(webrtcConfig)
+					if err != nil {
+						err = fmt.Errorf("[%s] Error creating peer connection: %s", clientID, err)
+						break
+					}
+					peerConnectionsCreatedCounter.Inc()
+					peerConnection.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
+						log.Printf("ICE gathering state changed: %s", state)
+					})
+				}
+
+				adapter.SetMetadata(clientID, nickname)
 
 				clients, clientsError := getReadyClients(adapter)
 				if clientsError != nil {
@@ -214,11 +267,19 @@ func NewPeerToServerRoomHandler(
 					}),
 				)
 
+				if session.signaller != nil {
+					// the reconnected client expects to negotiate the kept peer
+					// connection again
+					log.Printf("[%s] Negotiating kept peer connection", clientID)
+					session.signaller.Negotiate()
+					break
+				}
+
 				var dataChannel *webrtc.DataChannel
 				if initiator == localPeerID {
 					// need to do this to connect with simple peer
 					// only when we are the initiator
-					dataChannel, err = peerConnection.CreateDataChannel("data", nil)
+					dataChannel, err = tracks.CreateDataChannels(peerConnection, dataChannels)
 					if err != nil {
 						log.Printf("[%s] Error creating data channel: %s", clientID, err)
 						// TODO abort connection
@@ -227,57 +288,143 @@ func NewPeerToServerRoomHandler(
 
 				// TODO use this to get all client IDs and request all tracks of all users
 				// adapter.Clients()
-				if signaller == nil {
-					signaller, err = signals.NewSignaller(
-						initiator == localPeerID,
-						peerConnection,
-						mediaEngine,
-						localPeerID,
-						clientID,
-						func(signal interface{}) {
-							err := adapter.Emit(clientID, wsmessage.NewMessage("signal", room, signal))
+				var onConnected func()
+				if sfuConfig.DSCP > 0 {
+					onConnected = func() {
+						if err := setDSCP(peerConnection, sfuConfig.DSCP); err != nil {
+							log.Printf("[%s] Error setting DSCP: %s", clientID, err)
+						}
+					}
+				}
+
+				// OnClose is called once, before the close channel is closed
+				closeReasons := make(chan signals.CloseReason, 1)
+				var signaller *signals.Signaller
+				signaller, err = signals.NewSignallerWithParams(
+					initiator == localPeerID,
+					peerConnection,
+					mediaEngine,
+					localPeerID,
+					clientID,
+					newSignalEmitter(adapter, room, clientID),
+					signals.Params{
+						PayloadLimits: signals.PayloadLimits{
+							MaxDepth:    sfuConfig.MaxSignalDepth,
+							MaxElements: sfuConfig.MaxSignalElements,
+						},
+						CanPublish: func(kind webrtc.RTPCodecType) bool {
+							return tracksManager.CanPublish(room, clientID, kind)
+						},
+						AddTransceivers: func(n int) bool {
+							return tracksManager.AddTransceivers(room, n)
+						},
+						RemoveTransceivers: func(n int) {
+							tracksManager.RemoveTransceivers(room, n)
+						},
+						MaxSDPSize:            sfuConfig.MaxSDPSize,
+						MaxCandidates:         sfuConfig.MaxCandidates,
+						AllowCandidate:        allowCandidate,
+						DisconnectGracePeriod: sfuConfig.DisconnectGracePeriod,
+						MaxNegotiations:       sfuConfig.MaxNegotiations,
+						MaxNegotiationsWindow: sfuConfig.MaxNegotiationsWindow,
+						MaxQueuedTransceivers: sfuConfig.MaxQueuedTransceivers,
+						NegotiationTimeout:    sfuConfig.NegotiationTimeout,
+						NegotiationLimiter:    negotiationLimiter,
+
+						Trickle:          sfuConfig.Trickle,
+						LogSDP:           sfuConfig.LogSDP,
+						LogCandidatePair: sfuConfig.LogCandidatePair,
+						OnConnected:      onConnected,
+						Context:          event.Context,
+						AudioOnly:        network.AudioOnly,
+
+						TransceiverOverflowPolicy: transceiverOverflowPolicy(sfuConfig.QueuedTransceiversOverflowPolicy),
+
+						InitialDirection:   webrtc.NewRTPTransceiverDirection(string(sfuConfig.InitialTransceiverDirection)),
+						RequestedDirection: webrtc.NewRTPTransceiverDirection(string(sfuConfig.RequestedTransceiverDirection)),
+						OnClose: func(reason signals.CloseReason) {
+							closeReasons <- reason
+							err := session.peerClose.send(adapter, clientID, wsmessage.NewMessagePeerClose(room, string(reason)))
 							if err != nil {
-								log.Printf("[%s] Error sending local signal: %s", clientID, err)
-								// TODO abort connection
+								log.Printf("[%s] Error sending peer close message: %s", clientID, err)
 							}
 						},
-					)
-					if err != nil {
-						err = fmt.Errorf("[%s] Error initializing signaller: %s", clientID, err)
-						break
-					}
-					closeChannel := tracksManager.Add(room, clientID, peerConnection, dataChannel, signaller)
+						OnError: func(err error) {
+							if code, message, ok := getErrorMessage("signal", err); ok {
+								if err := adapter.Emit(clientID, wsmessage.NewMessageError(room, code, message)); err != nil {
+									log.Printf("[%s] Error sending error message: %s", clientID, err)
+								}
+							}
+						},
+					},
+				)
+				if err != nil {
+					err = fmt.Errorf("[%s] Error initializing signaller: %w", clientID, err)
+					break
+				}
+				session.signaller = signaller
+				closeChannel := tracksManager.Add(room, clientID, peerConnection, dataChannel, signaller)
+				go func() {
+					ticker := time.NewTicker(qualityInterval)
+					defer ticker.Stop()
+					broadcastQuality(adapter, tracksManager, room, clientID, ticker.C, closeChannel)
+				}()
+				if sfuConfig.LastN > 0 {
 					go func() {
-						// TODO figure out what happens if WS socket connectino terminates
-						// before peer connection
-						<-closeChannel
-						signallerMu.Lock()
-						defer signallerMu.Unlock()
-						signaller = nil
-						log.Printf("[%s] Peer connection closed, emitting hangUp event", clientID)
-						adapter.SetMetadata(clientID, "")
-
-						err := event.Adapter.Broadcast(
-							wsmessage.NewMessage("hangUp", event.Room, map[string]string{
-								"userId": event.ClientID,
-							}),
-						)
-						if err != nil {
-							log.Printf("[%s] Error brodacastin hangUp: %s", event.ClientID, err)
-						}
+						ticker := time.NewTicker(videoPausedInterval)
+						defer ticker.Stop()
+						emitVideoPaused(adapter, tracksManager, room, clientID, ticker.C, closeChannel)
 					}()
 				}
+				go func() {
+					// TODO figure out what happens if WS socket connectino terminates
+					// before peer connection
+					<-closeChannel
+					var reason signals.CloseReason
+					select {
+					case reason = <-closeReasons:
+					default:
+					}
+					session.mu.Lock()
+					defer session.mu.Unlock()
+					if session.signaller == signaller {
+						session.signaller = nil
+					}
+					log.Printf("[%s] Peer connection closed, emitting hangUp event", clientID)
+					adapter.SetMetadata(clientID, "")
+
+					err := event.Adapter.Broadcast(newHangUpMessage(event.Room, event.ClientID, reason, wss.ReconnectWindow()))
+					if err != nil {
+						log.Printf("[%s] Error brodacastin hangUp: %s", event.ClientID, err)
+					}
+				}()
+			case wsmessage.MessageTypeChat:
+				err = adapter.Broadcast(wsmessage.NewMessageChat(room, clientID, msg.Payload))
+			case wsmessage.MessageTypeRaiseHand, wsmessage.MessageTypeReaction:
+				err = handleParticipantMessage(adapter, room, clientID, msg)
+			case wsmessage.MessageTypeVideoRequest:
+				clientIDs, ok := wsmessage.VideoRequestClientIDs(msg)
+				if !ok {
+					err = fmt.Errorf("[%s] Invalid video request payload: %v", clientID, msg.Payload)
+					break
+				}
+				tracksManager.RequestVideo(room, clientID, clientIDs)
 			case "signal":
 				payload, _ := msg.Payload.(map[string]interface{})
-				if signaller == nil {
+				if session.signaller == nil {
 					err = fmt.Errorf("[%s] Ignoring signal because signaller is not initialized", clientID)
 				} else {
-					err = signaller.Signal(payload)
+					err = session.signaller.Signal(payload)
 				}
 			}
 
 			if err != nil {
 				log.Printf("[%s] Error handling event (event: %s, room: %s): %s", clientID, msg.Type, room, err)
+				if code, message, ok := getErrorMessage(msg.Type, err); ok {
+					if err := adapter.Emit(clientID, wsmessage.NewMessageError(room, code, message)); err != nil {
+						log.Printf("[%s] Error sending error message: %s", clientID, err)
+					}
+				}
 			}
 		}
 
@@ -285,3 +432,59 @@ func NewPeerToServerRoomHandler(
 	}
 	return http.HandlerFunc(fn)
 }
+
+// Returns the error message sent to a client after handling a message of
+// type typ failed. Returns false when the client is not notified.
+func getErrorMessage(typ string, err error) (code string, message string, ok bool) {
+	switch {
+	case errors.Is(err, signals.ErrTooManyTransceivers):
+		return wsmessage.ErrorCodeRoomFull, "Room is full", true
+	case errors.Is(err, signals.ErrAddTransceiver):
+		return wsmessage.ErrorCodeTransceiverFailed, "Track could not be added", true
+	case errors.Is(err, signals.ErrVideoDisabled):
+		return wsmessage.ErrorCodeTransceiverFailed, "Video is disabled", true
+	case errors.As(err, new(*signals.UnexpectedSignalError)):
+		return wsmessage.ErrorCodeUnexpectedSignal, "Signal type is not supported", true
+	case typ == "ready", typ == "signal":
+		return wsmessage.ErrorCodeNegotiationFailed, "Connection with the server could not be negotiated", true
+	default:
+		return "", "", false
+	}
+}
+
+func getDataChannelOptions(dataChannels []config.DataChannelConfig) []tracks.DataChannelOptions {
+	options := make([]tracks.DataChannelOptions, len(dataChannels))
+	for i, dc := range dataChannels {
+		options[i] = tracks.DataChannelOptions{
+			Label:             dc.Label,
+			Unordered:         dc.Unordered,
+			MaxRetransmits:    dc.MaxRetransmits,
+			MaxPacketLifeTime: dc.MaxPacketLifeTime,
+		}
+	}
+	return options
+}
+
+func transceiverOverflowPolicy(policy config.OverflowPolicy) negotiator.OverflowPolicy {
+	switch policy {
+	case config.OverflowPolicyDropOldest:
+		return negotiator.OverflowPolicyDropOldest
+	case config.OverflowPolicyCloseConnection:
+		return negotiator.OverflowPolicyClose
+	default:
+		return negotiator.OverflowPolicyDropNewest
+	}
+}
+
+// Returns the reason the peer connection is closed with after a client left
+// the room with leaveReason.
+func getCloseReason(leaveReason string) signals.CloseReason {
+	switch leaveReason {
+	case wsmessage.LeaveReasonKicked:
+		return signals.CloseReasonKicked
+	case wsmessage.LeaveReasonShutdown:
+		return signals.CloseReasonShutdown
+	default:
+		return signals.CloseReasonHangUp
+	}
+}
@@ -1,8 +1,37 @@
 package routes_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/routes"
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
 	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/jeremija/peer-calls/src/server/wshandler"
 	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
 )
 
 type addedPeer struct {
@@ -13,7 +42,10 @@ type addedPeer struct {
 }
 
 type mockTracksManager struct {
-	added chan addedPeer
+	added              chan addedPeer
+	rejectTransceivers bool
+	subscriptions      map[string][]tracks.Subscription
+	trackStats         map[string][]tracks.TrackStats
 }
 
 func newMockTracksManager() *mockTracksManager {
@@ -32,3 +64,442 @@ func (m *mockTracksManager) Add(room string, clientID string, peerConnection tra
 	}
 	return closeChannel
 }
+
+func (m *mockTracksManager) CanPublish(room string, clientID string, kind webrtc.RTPCodecType) bool {
+	return true
+}
+
+func (m *mockTracksManager) AddTransceivers(room string, n int) bool {
+	return !m.rejectTransceivers
+}
+
+func (m *mockTracksManager) RemoveTransceivers(room string, n int) {}
+
+func (m *mockTracksManager) Subscriptions(room string) map[string][]tracks.Subscription {
+	return m.subscriptions
+}
+
+func (m *mockTracksManager) TrackStats(room string) map[string][]tracks.TrackStats {
+	return m.trackStats
+}
+
+func (m *mockTracksManager) ForwardedVideo(room string) []string {
+	return nil
+}
+
+func (m *mockTracksManager) RequestVideo(room string, clientID string, clientIDs []string) {}
+
+func setupSFUServer(rooms routes.RoomManager, tracksManager routes.TracksManager) (server *httptest.Server, url string) {
+	return setupSFUServerWithConfig(rooms, tracksManager, config.NetworkConfig{})
+}
+
+func setupSFUServerWithConfig(rooms routes.RoomManager, tracksManager routes.TracksManager, network config.NetworkConfig) (server *httptest.Server, url string) {
+	wss := wshandler.NewWSS(rooms, config.WSConfig{})
+	handler := routes.NewPeerToServerRoomHandler(wss, iceServers, network, tracksManager)
+	server = httptest.NewServer(handler)
+	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/" + clientID
+	return
+}
+
+// Skips messages emitted to the client until an error message is found.
+func waitForEmittedMessage(t *testing.T, rooms *MockRoomManager, typ string) wsmessage.Message {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case emit := <-rooms.emit:
+			if emit.message.Type == typ {
+				assert.Equal(t, clientID, emit.clientID)
+				return emit.message
+			}
+		case <-timeout:
+			require.Fail(t, "timed out waiting for message", "type: %s", typ)
+		}
+	}
+}
+
+func waitForErrorMessage(t *testing.T, rooms *MockRoomManager) wsmessage.Message {
+	t.Helper()
+	return waitForEmittedMessage(t, rooms, wsmessage.MessageTypeError)
+}
+
+func TestSFU_error_roomFull(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	tracksManager := newMockTracksManager()
+	tracksManager.rejectTransceivers = true
+	server, url := setupSFUServer(rooms, tracksManager)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, url)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+
+	msg := waitForErrorMessage(t, rooms)
+	assert.Equal(t, wsmessage.ErrorCodeRoomFull, msg.Payload.(map[string]string)["code"])
+}
+
+func TestSFU_error_negotiationFailed(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	server, url := setupSFUServer(rooms, newMockTracksManager())
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, url)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("signal", roomName, map[string]interface{}{
+		"userId": clientID,
+		"signal": map[string]interface{}{
+			"type": "offer",
+			"sdp":  "invalid",
+		},
+	}))
+
+	msg := waitForErrorMessage(t, rooms)
+	assert.Equal(t, wsmessage.ErrorCodeNegotiationFailed, msg.Payload.(map[string]string)["code"])
+}
+
+func TestSFU_error_videoDisabled(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	tracksManager := newMockTracksManager()
+	server, url := setupSFUServerWithConfig(rooms, tracksManager, config.NetworkConfig{
+		AudioOnly: true,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, url)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+	select {
+	case <-tracksManager.added:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "timed out waiting for peer to be added")
+	}
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("signal", roomName, map[string]interface{}{
+		"userId": clientID,
+		"signal": map[string]interface{}{
+			"transceiverRequest": map[string]interface{}{
+				"kind": "video",
+			},
+		},
+	}))
+
+	msg := waitForErrorMessage(t, rooms)
+	assert.Equal(t, wsmessage.ErrorCodeTransceiverFailed, msg.Payload.(map[string]string)["code"])
+}
+
+func TestSFU_peerClose_hangUp(t *testing.T) {
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	tracksManager := newMockTracksManager()
+	server, url := setupSFUServer(rooms, tracksManager)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, url)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+	select {
+	case <-tracksManager.added:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "timed out waiting for peer to be added")
+	}
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("hangUp", roomName, nil))
+
+	msg := waitForEmittedMessage(t, rooms, wsmessage.MessageTypePeerClose)
+	assert.Equal(t, map[string]string{
+		"reason": "hang_up",
+	}, msg.Payload)
+}
+
+// Reads messages sent to the client until the peer close message is found.
+func readPeerClose(t *testing.T, ctx context.Context, ws *websocket.Conn) wsmessage.Message {
+	t.Helper()
+	for {
+		msg := mustReadWS(t, ctx, ws)
+		if msg.Type == wsmessage.MessageTypePeerClose {
+			return msg
+		}
+	}
+}
+
+func TestSFU_peerClose_memoryAdapter(t *testing.T) {
+	for _, reason := range []signals.CloseReason{
+		signals.CloseReasonHangUp,
+		signals.CloseReasonShutdown,
+	} {
+		t.Run(string(reason), func(t *testing.T) {
+			rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+				return wsmemory.NewMemoryAdapter(room)
+			})
+			tracksManager := newMockTracksManager()
+			wss := wshandler.NewWSS(rooms, config.WSConfig{})
+			server := httptest.NewServer(routes.NewPeerToServerRoomHandler(wss, iceServers, config.NetworkConfig{}, tracksManager))
+			defer server.Close()
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/" + clientID
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			ws := mustDialWS(t, ctx, url)
+			defer ws.Close(websocket.StatusNormalClosure, "")
+			mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+				"nickname": "abc",
+			}))
+			select {
+			case <-tracksManager.added:
+			case <-time.After(10 * time.Second):
+				require.Fail(t, "timed out waiting for peer to be added")
+			}
+
+			if reason == signals.CloseReasonShutdown {
+				go wss.Shutdown(ctx)
+			} else {
+				mustWriteWS(t, ctx, ws, wsmessage.NewMessage("hangUp", roomName, nil))
+			}
+
+			msg := readPeerClose(t, ctx, ws)
+			assert.Equal(t, map[string]interface{}{
+				"reason": string(reason),
+			}, msg.Payload)
+		})
+	}
+}
+
+// Reads messages sent to the client until a signal with an offer is found.
+func readOffer(t *testing.T, ctx context.Context, ws *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	for {
+		msg := mustReadWS(t, ctx, ws)
+		if msg.Type != "signal" {
+			continue
+		}
+		payload, _ := msg.Payload.(map[string]interface{})
+		signal, _ := payload["signal"].(map[string]interface{})
+		if signal["type"] == "offer" {
+			return signal
+		}
+	}
+}
+
+func TestSFU_reconnect_rebind(t *testing.T) {
+	rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+		return wsmemory.NewMemoryAdapter(room)
+	})
+	tracksManager := newMockTracksManager()
+	server, url := setupSFUServer(rooms, tracksManager)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url)
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws1, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+	offer := readOffer(t, ctx, ws1)
+
+	// the offer is not answered before the client reconnects
+	ws2 := mustDialWS(t, ctx, url)
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, offer, readOffer(t, ctx, ws2))
+
+	select {
+	case <-tracksManager.added:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "timed out waiting for peer to be added")
+	}
+	select {
+	case <-tracksManager.added:
+		assert.Fail(t, "a new peer connection was added after reconnecting")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Returns the number of peer connections created by SFU handlers.
+func peerConnectionsCreated(t *testing.T) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() == "peercalls_sfu_peer_connections_created_total" {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestSFU_reconnect_ready(t *testing.T) {
+	rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+		return wsmemory.NewMemoryAdapter(room)
+	})
+	tracksManager := newMockTracksManager()
+	server, url := setupSFUServer(rooms, tracksManager)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	created := peerConnectionsCreated(t)
+
+	ws1 := mustDialWS(t, ctx, url)
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws1, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+	readOffer(t, ctx, ws1)
+
+	ws2 := mustDialWS(t, ctx, url)
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	offer := readOffer(t, ctx, ws2)
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer pc.Close()
+	sdp, _ := offer["sdp"].(string)
+	require.Nil(t, pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}))
+	answer, err := pc.CreateAnswer(nil)
+	require.Nil(t, err)
+	require.Nil(t, pc.SetLocalDescription(answer))
+	mustWriteWS(t, ctx, ws2, wsmessage.NewMessage("signal", roomName, map[string]interface{}{
+		"userId": clientID,
+		"signal": map[string]interface{}{
+			"type": "answer",
+			"sdp":  answer.SDP,
+		},
+	}))
+
+	// the kept peer connection is negotiated again
+	mustWriteWS(t, ctx, ws2, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+	readOffer(t, ctx, ws2)
+
+	assert.Equal(t, created+1, peerConnectionsCreated(t))
+	select {
+	case <-tracksManager.added:
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "timed out waiting for peer to be added")
+	}
+	select {
+	case <-tracksManager.added:
+		assert.Fail(t, "a new peer connection was added after reconnecting")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Writes a self-signed ECDSA certificate and key to PEM files in dir and
+// returns the DER encoded certificate.
+func writeDTLSCertificate(t *testing.T, dir string) (certFile string, keyFile string, der []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err = x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+
+	certFile = filepath.Join(dir, "dtls.pem")
+	keyFile = filepath.Join(dir, "dtls.key")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile, der
+}
+
+func TestSFU_dtlsCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-calls")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile, der := writeDTLSCertificate(t, dir)
+
+	rooms := NewMockRoomManager()
+	defer rooms.close()
+	server, url := setupSFUServerWithConfig(rooms, newMockTracksManager(), config.NetworkConfig{
+		SFU: config.NetworkConfigSFU{
+			DTLSCert: certFile,
+			DTLSKey:  keyFile,
+		},
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, url)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "abc",
+	}))
+
+	msg := waitForEmittedMessage(t, rooms, "signal")
+	payload, ok := msg.Payload.(signals.Payload)
+	require.True(t, ok, "unexpected payload: %#v", msg.Payload)
+	offer, ok := payload.Signal.(webrtc.SessionDescription)
+	require.True(t, ok, "unexpected signal: %#v", payload.Signal)
+
+	sum := sha256.Sum256(der)
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+	assert.Contains(t, offer.SDP, "a=fingerprint:sha-256 "+strings.Join(fingerprint, ":"))
+}
+
+func TestSFU_trickle(t *testing.T) {
+	for _, trickle := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trickle=%t", trickle), func(t *testing.T) {
+			rooms := NewMockRoomManager()
+			defer rooms.close()
+			server, url := setupSFUServerWithConfig(rooms, newMockTracksManager(), config.NetworkConfig{
+				SFU: config.NetworkConfigSFU{
+					Trickle: trickle,
+				},
+			})
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			ws := mustDialWS(t, ctx, url)
+			defer ws.Close(websocket.StatusNormalClosure, "")
+			mustWriteWS(t, ctx, ws, wsmessage.NewMessage("ready", roomName, map[string]interface{}{
+				"nickname": "abc",
+			}))
+
+			msg := waitForEmittedMessage(t, rooms, "signal")
+			payload, ok := msg.Payload.(signals.Payload)
+			require.True(t, ok, "unexpected payload: %#v", msg.Payload)
+			offer, ok := payload.Signal.(webrtc.SessionDescription)
+			require.True(t, ok, "unexpected signal: %#v", payload.Signal)
+
+			if !trickle {
+				assert.Contains(t, offer.SDP, "a=candidate:")
+				return
+			}
+
+			assert.NotContains(t, offer.SDP, "a=candidate:")
+			msg = waitForEmittedMessage(t, rooms, "signal")
+			payload, ok = msg.Payload.(signals.Payload)
+			require.True(t, ok, "unexpected payload: %#v", msg.Payload)
+			_, ok = payload.Signal.(signals.Candidate)
+			assert.True(t, ok, "expected a candidate after the offer: %#v", payload.Signal)
+		})
+	}
+}
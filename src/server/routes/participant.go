@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+const maxReactionLength = 32
+
+// Broadcasts hand raises and reactions with the sender's metadata and a
+// server timestamp.
+func handleParticipantMessage(adapter wsadapter.Adapter, room string, clientID string, msg wsmessage.Message) error {
+	payload, _ := msg.Payload.(map[string]interface{})
+	metadata, _ := adapter.Metadata(clientID)
+	timestamp := wsmessage.NewTimestamp(time.Now())
+
+	switch msg.Type {
+	case wsmessage.MessageTypeRaiseHand:
+		raised, _ := payload["raised"].(bool)
+		return adapter.Broadcast(wsmessage.NewMessageRaiseHand(room, clientID, metadata, raised, timestamp))
+	case wsmessage.MessageTypeReaction:
+		reaction, _ := payload["reaction"].(string)
+		if reaction == "" || len(reaction) > maxReactionLength {
+			return fmt.Errorf("[%s] Invalid reaction: %q", clientID, reaction)
+		}
+		return adapter.Broadcast(wsmessage.NewMessageReaction(room, clientID, metadata, reaction, timestamp))
+	default:
+		return fmt.Errorf("[%s] Not a participant message: %s", clientID, msg.Type)
+	}
+}
@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// Estimates the connection quality of the client on every tick from the
+// stats of the tracks it publishes, and broadcasts changes to the room until
+// done is closed.
+func broadcastQuality(
+	adapter wsadapter.Adapter,
+	tracksManager TracksManager,
+	room string,
+	clientID string,
+	ticks <-chan time.Time,
+	done <-chan struct{},
+) {
+	estimator := tracks.NewQualityEstimator()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticks:
+			quality, changed := estimator.Update(tracksManager.TrackStats(room)[clientID])
+			if !changed {
+				continue
+			}
+			log.Printf("[%s] Connection quality: %s", clientID, quality)
+			err := adapter.Broadcast(wsmessage.NewMessageQuality(room, clientID, string(quality)))
+			if err != nil {
+				log.Printf("[%s] Error broadcasting quality: %s", clientID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+)
+
+type broadcastRecorder struct {
+	wsadapter.Adapter
+	messages chan wsmessage.Message
+}
+
+func (b *broadcastRecorder) Broadcast(msg wsmessage.Message) error {
+	b.messages <- msg
+	return nil
+}
+
+type statsTracksManager struct {
+	TracksManager
+	stats chan []tracks.TrackStats
+}
+
+func (s *statsTracksManager) TrackStats(room string) map[string][]tracks.TrackStats {
+	return map[string][]tracks.TrackStats{"client1": <-s.stats}
+}
+
+func TestBroadcastQuality(t *testing.T) {
+	adapter := &broadcastRecorder{messages: make(chan wsmessage.Message, 10)}
+	tracksManager := &statsTracksManager{stats: make(chan []tracks.TrackStats, 10)}
+	ticks := make(chan time.Time)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		broadcastQuality(adapter, tracksManager, "room1", "client1", ticks, done)
+	}()
+
+	var stats tracks.TrackStats
+	tick := func(lost uint64) {
+		stats.TrackID = "sfu_v"
+		stats.PacketsReceived += 100 - lost
+		stats.PacketsLost += lost
+		tracksManager.stats <- []tracks.TrackStats{stats}
+		ticks <- time.Now()
+	}
+
+	tick(0)
+	tick(50)
+	tick(50)
+	tick(50)
+	close(done)
+	<-stopped
+	close(adapter.messages)
+
+	var messages []wsmessage.Message
+	for msg := range adapter.messages {
+		messages = append(messages, msg)
+	}
+	assert.Equal(t, []wsmessage.Message{
+		wsmessage.NewMessageQuality("room1", "client1", "good"),
+		wsmessage.NewMessageQuality("room1", "client1", "poor"),
+	}, messages)
+}
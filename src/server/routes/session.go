@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/jeremija/peer-calls/src/server/ws"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// Peer connection of a client with the server. It is shared by the
+// websocket connections of the client, so a client that reconnects with the
+// same ID and replaces its previous connection keeps its peer connection.
+type peerSession struct {
+	mu        sync.Mutex
+	signaller *signals.Signaller
+	peerClose peerCloseSender
+}
+
+type peerSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*peerSession
+}
+
+func newPeerSessions() *peerSessions {
+	return &peerSessions{
+		sessions: map[string]*peerSession{},
+	}
+}
+
+func peerSessionKey(room string, clientID string) string {
+	return room + "/" + clientID
+}
+
+// Returns the session of a client, creating it when it does not exist.
+func (p *peerSessions) get(room string, clientID string) *peerSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := peerSessionKey(room, clientID)
+	session, ok := p.sessions[key]
+	if !ok {
+		session = &peerSession{}
+		p.sessions[key] = session
+	}
+	return session
+}
+
+func (p *peerSessions) lookup(room string, clientID string) (*peerSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session, ok := p.sessions[peerSessionKey(room, clientID)]
+	return session, ok
+}
+
+// Removes the session of a client unless it has already been replaced by
+// another one.
+func (p *peerSessions) remove(room string, clientID string, session *peerSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := peerSessionKey(room, clientID)
+	if p.sessions[key] == session {
+		delete(p.sessions, key)
+	}
+}
+
+// Returns a callback sending local signals to a client.
+func newSignalEmitter(adapter wsadapter.Adapter, room string, clientID string) func(signal interface{}) {
+	return func(signal interface{}) {
+		err := adapter.Emit(clientID, wsmessage.NewMessage("signal", room, signal))
+		if err != nil {
+			log.Printf("[%s] Error sending local signal: %s", clientID, err)
+			// TODO abort connection
+		}
+	}
+}
+
+// Sends the peer close message to a client. During cleanup the client is no
+// longer subscribed to messages emitted by the adapter, so the message is
+// written to its connection directly.
+type peerCloseSender struct {
+	mu     sync.Mutex
+	client *ws.Client
+}
+
+func (p *peerCloseSender) setClient(client *ws.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client = client
+}
+
+func (p *peerCloseSender) send(adapter wsadapter.Adapter, clientID string, msg wsmessage.Message) error {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+
+	if client == nil {
+		return adapter.Emit(clientID, msg)
+	}
+	return client.WriteTimeout(context.Background(), time.Second, msg)
+}
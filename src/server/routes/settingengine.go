@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"net"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/pion/webrtc/v2"
+)
+
+// Same as the pion ICE default. It needs to be set together with the
+// keepalive interval.
+const iceConnectionTimeout = 30 * time.Second
+
+func newSettingEngine(sfuConfig config.NetworkConfigSFU) webrtc.SettingEngine {
+	settingEngine := webrtc.SettingEngine{
+		LoggerFactory: pionLoggerFactory{},
+	}
+
+	if filter := newInterfaceFilter(sfuConfig.Interfaces); filter != nil {
+		settingEngine.SetInterfaceFilter(filter)
+	}
+
+	if networkTypes := getNetworkTypes(sfuConfig.IPFamilies); len(networkTypes) > 0 {
+		settingEngine.SetNetworkTypes(networkTypes)
+	}
+
+	if sfuConfig.Keepalive > 0 {
+		settingEngine.SetConnectionTimeout(iceConnectionTimeout, sfuConfig.Keepalive)
+	}
+
+	settingEngine.SetTrickle(sfuConfig.Trickle)
+
+	return settingEngine
+}
+
+// Returns nil when all interfaces are allowed.
+func newInterfaceFilter(interfaces []string) func(iface string) bool {
+	if len(interfaces) == 0 {
+		return nil
+	}
+
+	allowedInterfaces := map[string]struct{}{}
+	for _, iface := range interfaces {
+		allowedInterfaces[iface] = struct{}{}
+	}
+
+	return func(iface string) bool {
+		_, ok := allowedInterfaces[iface]
+		return ok
+	}
+}
+
+// Returns a filter for ICE candidate addresses. Denied networks take
+// precedence over allowed networks, and hostnames are only allowed when no
+// allowed networks are configured. Returns nil when all candidates are
+// allowed. The CIDRs must have been validated.
+func newCandidateFilter(allowCIDRs []string, denyCIDRs []string) func(address string) bool {
+	if len(allowCIDRs) == 0 && len(denyCIDRs) == 0 {
+		return nil
+	}
+
+	allowNets := parseCIDRs(allowCIDRs)
+	denyNets := parseCIDRs(denyCIDRs)
+
+	return func(address string) bool {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return len(allowNets) == 0
+		}
+		if containsIP(denyNets, ip) {
+			return false
+		}
+		return len(allowNets) == 0 || containsIP(allowNets, ip)
+	}
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the ICE candidate network types for ipFamily. Returns nil when both
+// families should be used so that pion defaults are kept.
+func getNetworkTypes(ipFamily config.IPFamily) []webrtc.NetworkType {
+	switch ipFamily {
+	case config.IPFamilyIPv4:
+		return []webrtc.NetworkType{webrtc.NetworkTypeUDP4}
+	case config.IPFamilyIPv6:
+		return []webrtc.NetworkType{webrtc.NetworkTypeUDP6}
+	default:
+		return nil
+	}
+}
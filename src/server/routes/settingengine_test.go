@@ -0,0 +1,137 @@
+package routes
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNetworkTypes(t *testing.T) {
+	assert.Equal(t, []webrtc.NetworkType{webrtc.NetworkTypeUDP4}, getNetworkTypes(config.IPFamilyIPv4))
+	assert.Equal(t, []webrtc.NetworkType{webrtc.NetworkTypeUDP6}, getNetworkTypes(config.IPFamilyIPv6))
+	assert.Nil(t, getNetworkTypes(config.IPFamilyBoth))
+	assert.Nil(t, getNetworkTypes(""))
+}
+
+func TestNewInterfaceFilter(t *testing.T) {
+	assert.Nil(t, newInterfaceFilter(nil))
+
+	filter := newInterfaceFilter([]string{"eth0", "eth1"})
+	assert.True(t, filter("eth0"))
+	assert.True(t, filter("eth1"))
+	assert.False(t, filter("wlan0"))
+}
+
+// Returns the name of a non-loopback interface with an IPv4 address.
+func findIPv4Interface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	require.Nil(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		require.Nil(t, err)
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return iface.Name
+			}
+		}
+	}
+	t.Skip("no interface with an IPv4 address found")
+	return ""
+}
+
+// Gathers host candidates using the setting engine built from sfuConfig.
+// Trickle is disabled, so candidates are gathered synchronously.
+func gatherHostCandidates(t *testing.T, sfuConfig config.NetworkConfigSFU) []net.IP {
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(newSettingEngine(sfuConfig)))
+	gatherer, err := api.NewICEGatherer(webrtc.ICEGatherOptions{})
+	require.Nil(t, err)
+	defer gatherer.Close()
+
+	candidates, err := gatherer.GetLocalCandidates()
+	require.Nil(t, err)
+
+	var ips []net.IP
+	for _, candidate := range candidates {
+		if candidate.Typ == webrtc.ICECandidateTypeHost {
+			ips = append(ips, net.ParseIP(candidate.Address))
+		}
+	}
+	return ips
+}
+
+// The pion/ice version in use only gathers IPv4-compatible IPv6 addresses,
+// so IPv6 host candidates can only be checked for being absent.
+func TestNewSettingEngine_ipFamiliesAndInterfaces(t *testing.T) {
+	iface := findIPv4Interface(t)
+
+	type testCase struct {
+		ipFamilies config.IPFamily
+		interfaces []string
+		wantIPv4   bool
+		allowIPv6  bool
+	}
+
+	testCases := []testCase{
+		{config.IPFamilyBoth, nil, true, true},
+		{config.IPFamilyBoth, []string{iface}, true, true},
+		{config.IPFamilyIPv4, []string{iface}, true, false},
+		{config.IPFamilyIPv6, []string{iface}, false, true},
+		{config.IPFamilyBoth, []string{"peercalls-missing0"}, false, false},
+		{config.IPFamilyIPv4, []string{"peercalls-missing0"}, false, false},
+		{config.IPFamilyIPv6, []string{"peercalls-missing0"}, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%v", tc.ipFamilies, tc.interfaces), func(t *testing.T) {
+			ips := gatherHostCandidates(t, config.NetworkConfigSFU{
+				IPFamilies: tc.ipFamilies,
+				Interfaces: tc.interfaces,
+			})
+
+			var gotIPv4, gotIPv6 bool
+			for _, ip := range ips {
+				require.NotNil(t, ip, "host candidate should have an IP address")
+				gotIPv4 = gotIPv4 || ip.To4() != nil
+				gotIPv6 = gotIPv6 || ip.To4() == nil
+			}
+			assert.Equal(t, tc.wantIPv4, gotIPv4, "IPv4 candidates in %v", ips)
+			if !tc.allowIPv6 {
+				assert.False(t, gotIPv6, "IPv6 candidates in %v", ips)
+			}
+		})
+	}
+}
+
+func TestNewCandidateFilter(t *testing.T) {
+	assert.Nil(t, newCandidateFilter(nil, nil))
+
+	t.Run("allow", func(t *testing.T) {
+		filter := newCandidateFilter([]string{"10.0.0.0/8", "fd00::/8"}, nil)
+		assert.True(t, filter("10.1.2.3"))
+		assert.True(t, filter("fd00::1"))
+		assert.False(t, filter("192.168.1.1"))
+		assert.False(t, filter("abc.local"))
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		filter := newCandidateFilter(nil, []string{"169.254.0.0/16"})
+		assert.False(t, filter("169.254.1.1"))
+		assert.True(t, filter("192.168.1.1"))
+		assert.True(t, filter("abc.local"))
+	})
+
+	t.Run("deny takes precedence", func(t *testing.T) {
+		filter := newCandidateFilter([]string{"10.0.0.0/8"}, []string{"10.8.0.0/16"})
+		assert.True(t, filter("10.1.2.3"))
+		assert.False(t, filter("10.8.0.1"))
+		assert.False(t, filter("192.168.1.1"))
+	})
+}
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// Interval between checks whether the video of a client is forwarded when
+// only the video of the last N speakers is forwarded.
+const videoPausedInterval = 500 * time.Millisecond
+
+// Checks on every tick whether the video of the client is forwarded to the
+// room, and tells the client when it is paused or resumed until done is
+// closed.
+func emitVideoPaused(
+	adapter wsadapter.Adapter,
+	tracksManager TracksManager,
+	room string,
+	clientID string,
+	ticks <-chan time.Time,
+	done <-chan struct{},
+) {
+	paused := false
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticks:
+			forwarded := tracksManager.ForwardedVideo(room)
+			if forwarded == nil {
+				// all video is forwarded
+				continue
+			}
+			if isPaused := !containsString(forwarded, clientID); isPaused != paused {
+				paused = isPaused
+				log.Printf("[%s] Video paused: %t", clientID, paused)
+				err := adapter.Emit(clientID, wsmessage.NewMessageVideoPaused(room, paused))
+				if err != nil {
+					log.Printf("[%s] Error sending video paused: %s", clientID, err)
+				}
+			}
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+)
+
+type emitRecorder struct {
+	wsadapter.Adapter
+	messages chan wsmessage.Message
+}
+
+func (e *emitRecorder) Emit(clientID string, msg wsmessage.Message) error {
+	e.messages <- msg
+	return nil
+}
+
+type forwardedTracksManager struct {
+	TracksManager
+	forwarded chan []string
+}
+
+func (f *forwardedTracksManager) ForwardedVideo(room string) []string {
+	return <-f.forwarded
+}
+
+func TestEmitVideoPaused(t *testing.T) {
+	adapter := &emitRecorder{messages: make(chan wsmessage.Message, 10)}
+	tracksManager := &forwardedTracksManager{forwarded: make(chan []string, 10)}
+	ticks := make(chan time.Time)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		emitVideoPaused(adapter, tracksManager, "room1", "client1", ticks, done)
+	}()
+
+	tick := func(forwarded []string) {
+		tracksManager.forwarded <- forwarded
+		ticks <- time.Now()
+	}
+
+	tick([]string{"client1"})
+	tick([]string{"client2"})
+	tick([]string{"client2", "client3"})
+	tick(nil)
+	tick([]string{"client2", "client1"})
+	close(done)
+	<-stopped
+	close(adapter.messages)
+
+	var messages []wsmessage.Message
+	for msg := range adapter.messages {
+		messages = append(messages, msg)
+	}
+	assert.Equal(t, []wsmessage.Message{
+		wsmessage.NewMessageVideoPaused("room1", true),
+		wsmessage.NewMessageVideoPaused("room1", false),
+	}, messages)
+}
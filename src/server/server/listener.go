@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrListenerClosed = errors.New("Listener closed")
+
+type ListenParams struct {
+	// IPv4 or IPv6 address, optionally enclosed in brackets, or a hostname.
+	// Listens on all interfaces when empty.
+	BindHost string
+	BindPort int
+	// Listens on all IPv4 and IPv6 interfaces using separate sockets.
+	// BindHost is ignored when set.
+	DualStack bool
+	// Path of a Unix domain socket. Takes precedence over BindHost and
+	// BindPort when set. The socket file is removed when the listener is
+	// closed.
+	BindSocket string
+}
+
+// Listens on a Unix domain socket when BindSocket is set, or on TCP
+// otherwise.
+func Listen(params ListenParams) (net.Listener, error) {
+	if params.BindSocket == "" {
+		if params.DualStack {
+			return listenDualStack(params.BindPort)
+		}
+		return net.Listen("tcp", ListenAddress(params.BindHost, params.BindPort))
+	}
+
+	if err := removeStaleSocket(params.BindSocket); err != nil {
+		return nil, err
+	}
+
+	return net.Listen("unix", params.BindSocket)
+}
+
+// Returns the TCP address to listen on. IPv6 literals are enclosed in
+// brackets, and brackets already enclosing them are not doubled.
+func ListenAddress(host string, port int) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Removes a socket file left behind by a server that did not shut down
+// cleanly. Sockets that still accept connections and other files are kept.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error checking socket: %w", err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("Not a socket: %s", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("Socket is in use: %s", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("Error removing stale socket: %w", err)
+	}
+
+	return nil
+}
+
+// Listens on the IPv6 and IPv4 wildcard addresses. The IPv6 socket is bound
+// with IPV6_V6ONLY so that both can share the port. On platforms which always
+// map IPv4 over IPv6 the IPv4 address is reported as in use, and the IPv6
+// socket alone accepts connections from both families. Hosts without IPv6
+// or IPv4 support only listen on the available family.
+func listenDualStack(port int) (net.Listener, error) {
+	l6, err6 := net.Listen("tcp6", net.JoinHostPort("::", strconv.Itoa(port)))
+	if err6 == nil && port == 0 {
+		// the IPv4 socket must use the same randomly assigned port
+		port = l6.Addr().(*net.TCPAddr).Port
+	}
+
+	l4, err4 := net.Listen("tcp4", net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+
+	switch {
+	case err6 == nil && err4 == nil:
+		return newMultiListener(l6, l4), nil
+	case err6 == nil && errors.Is(err4, syscall.EADDRINUSE):
+		return l6, nil
+	case err6 == nil:
+		l6.Close()
+		return nil, err4
+	case err4 == nil:
+		return l4, nil
+	default:
+		return nil, err6
+	}
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener accepts connections from multiple listeners. The address of
+// the first listener is reported.
+type multiListener struct {
+	listeners []net.Listener
+	results   chan acceptResult
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ net.Listener = &multiListener{}
+
+func newMultiListener(listeners ...net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		results:   make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go m.accept(l)
+	}
+	return m
+}
+
+// Errors are passed on to the caller of Accept, which decides whether to
+// continue accepting connections.
+func (m *multiListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case m.results <- acceptResult{conn, err}:
+		case <-m.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-m.results:
+		return result.conn, result.err
+	case <-m.closed:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (m *multiListener) Close() (err error) {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, l := range m.listeners {
+			if closeErr := l.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return
+}
+
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
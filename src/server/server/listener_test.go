@@ -0,0 +1,182 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSocketPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "peer-calls")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "peer-calls.sock")
+}
+
+func getSocket(t *testing.T, path string) []byte {
+	c := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	res, err := c.Get("http://unix/")
+	require.Nil(t, err, "error executing request")
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err, "error reading body")
+	return body
+}
+
+func TestListen_socket(t *testing.T) {
+	path := newSocketPath(t)
+	l, err := server.Listen(server.ListenParams{
+		BindHost:   "127.0.0.1",
+		BindPort:   0,
+		BindSocket: path,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "unix", l.Addr().Network())
+
+	s := server.NewStartStopper(server.ServerParams{}, handler)
+	go s.Start(l)
+
+	assert.Equal(t, []byte("hello"), getSocket(t, path))
+
+	_, err = server.Listen(server.ListenParams{BindSocket: path})
+	require.NotNil(t, err)
+	assert.Regexp(t, "Socket is in use", err.Error())
+
+	require.Nil(t, s.Stop())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "expected socket to be removed on close")
+}
+
+func TestListen_staleSocket(t *testing.T) {
+	path := newSocketPath(t)
+	l, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.Nil(t, l.Close())
+
+	l, err = server.Listen(server.ListenParams{BindSocket: path})
+	require.Nil(t, err, "expected stale socket to be removed")
+	defer l.Close()
+	assert.Equal(t, path, l.Addr().String())
+}
+
+func TestListen_notSocket(t *testing.T) {
+	path := newSocketPath(t)
+	require.Nil(t, ioutil.WriteFile(path, []byte("test"), 0600))
+
+	_, err := server.Listen(server.ListenParams{BindSocket: path})
+	require.NotNil(t, err)
+	assert.Regexp(t, "Not a socket", err.Error())
+}
+
+func TestListen_tcp(t *testing.T) {
+	l, err := server.Listen(server.ListenParams{
+		BindHost: "127.0.0.1",
+		BindPort: 0,
+	})
+	require.Nil(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+
+	s := server.NewStartStopper(server.ServerParams{}, handler)
+	go s.Start(l)
+	defer s.Stop()
+
+	res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d", port))
+	require.Nil(t, err, "error executing request")
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err, "error reading body")
+	assert.Equal(t, []byte("hello"), body)
+}
+
+func TestListenAddress(t *testing.T) {
+	for _, tc := range []struct {
+		host string
+		want string
+	}{
+		{"127.0.0.1", "127.0.0.1:3000"},
+		{"::1", "[::1]:3000"},
+		{"[::1]", "[::1]:3000"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:3000"},
+		{"localhost", "localhost:3000"},
+		{"", ":3000"},
+	} {
+		addr := server.ListenAddress(tc.host, 3000)
+		assert.Equal(t, tc.want, addr, "host: %q", tc.host)
+		host, port, err := net.SplitHostPort(addr)
+		assert.Nil(t, err, "host: %q", tc.host)
+		assert.Equal(t, strings.Trim(tc.host, "[]"), host)
+		assert.Equal(t, "3000", port)
+	}
+}
+
+func TestListen_ipv6(t *testing.T) {
+	for _, host := range []string{"::1", "[::1]"} {
+		l, err := server.Listen(server.ListenParams{
+			BindHost: host,
+			BindPort: 0,
+		})
+		if err != nil {
+			t.Skipf("IPv6 loopback unavailable: %s", err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+
+		s := server.NewStartStopper(server.ServerParams{}, handler)
+		go s.Start(l)
+		assert.Equal(t, []byte("hello"), getTCP(t, "::1", port))
+		require.Nil(t, s.Stop())
+	}
+}
+
+func getTCP(t *testing.T, host string, port int) []byte {
+	res, err := http.Get(fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(port))))
+	require.Nil(t, err, "error executing request")
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err, "error reading body")
+	return body
+}
+
+func TestListen_dualStack(t *testing.T) {
+	l, err := server.Listen(server.ListenParams{
+		BindHost:  "127.0.0.1",
+		BindPort:  0,
+		DualStack: true,
+	})
+	require.Nil(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+
+	s := server.NewStartStopper(server.ServerParams{}, handler)
+	go s.Start(l)
+
+	assert.Equal(t, []byte("hello"), getTCP(t, "127.0.0.1", port))
+
+	if conn, err := net.Dial("tcp6", net.JoinHostPort("::1", strconv.Itoa(port))); err == nil {
+		conn.Close()
+		assert.Equal(t, []byte("hello"), getTCP(t, "::1", port))
+	} else {
+		t.Logf("IPv6 loopback unavailable: %s", err)
+	}
+
+	require.Nil(t, s.Stop())
+	_, err = net.Dial("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.NotNil(t, err, "expected listeners to be closed")
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 )
@@ -8,6 +10,12 @@ import (
 type ServerParams struct {
 	TLSCertFile string
 	TLSKeyFile  string
+	// Minimum TLS version, one of the tls.VersionTLS constants. Uses the Go
+	// default when zero.
+	TLSMinVersion uint16
+	// Cipher suites used with TLS 1.2 and older. Uses the Go defaults when
+	// empty.
+	TLSCipherSuites []uint16
 }
 
 type StartStopper struct {
@@ -18,6 +26,10 @@ type StartStopper struct {
 func NewStartStopper(params ServerParams, handler http.Handler) *StartStopper {
 	server := &http.Server{
 		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:   params.TLSMinVersion,
+			CipherSuites: params.TLSCipherSuites,
+		},
 	}
 	return &StartStopper{
 		server: server,
@@ -37,3 +49,9 @@ func (s StartStopper) Start(l net.Listener) (err error) {
 func (s StartStopper) Stop() error {
 	return s.server.Close()
 }
+
+// Stops accepting connections and waits for active requests to finish or
+// ctx to be done. Websocket connections are not waited for.
+func (s StartStopper) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -60,3 +60,37 @@ func TestServerStarter_HTTPS(t *testing.T) {
 	require.Nil(t, err, "error reading body")
 	require.Equal(t, []byte("hello"), body)
 }
+
+func TestServerStarter_HTTPS_minVersion(t *testing.T) {
+	addr := net.JoinHostPort("127.0.0.1", "0")
+	l, err := net.Listen("tcp", addr)
+	require.Nil(t, err, "error listening to: %s", addr)
+	port := l.Addr().(*net.TCPAddr).Port
+	params := server.ServerParams{
+		TLSCertFile:   "../../../config/cert.example.pem",
+		TLSKeyFile:    "../../../config/cert.example.key",
+		TLSMinVersion: tls.VersionTLS13,
+	}
+	s := server.NewStartStopper(params, handler)
+	go s.Start(l)
+	defer s.Stop()
+	url := fmt.Sprintf("https://127.0.0.1:%d", port)
+
+	var c http.Client
+	c.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+		},
+	}
+	_, err = c.Get(url)
+	require.NotNil(t, err, "expected TLS 1.2 to be rejected")
+
+	c.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	res, err := c.Get(url)
+	require.Nil(t, err, "error executing request")
+	require.Equal(t, uint16(tls.VersionTLS13), res.TLS.Version)
+	res.Body.Close()
+}
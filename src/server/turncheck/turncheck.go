@@ -0,0 +1,183 @@
+package turncheck
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/iceauth"
+	"github.com/pion/turn/v2"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultPort    = "3478"
+)
+
+var ErrTimeout = errors.New("Timed out")
+
+// Returned by udpAddress for TURN URLs that cannot be checked, e.g. those
+// reachable only over TCP or TLS.
+var errUnsupported = errors.New("Unsupported TURN URL")
+
+// Result of allocating a relay on a TURN server.
+type Result struct {
+	URL string
+	// Set for URLs that cannot be checked, i.e. turns: URLs and URLs with
+	// transport=tcp. Err is nil for skipped URLs.
+	Skipped bool
+	// Nil when a relay was allocated.
+	Err error
+}
+
+type Params struct {
+	// Maximum time to wait for each allocation. Defaults to 5 seconds when
+	// zero.
+	Timeout time.Duration
+}
+
+// Allocates a relay over UDP on each turn URL of the ICE servers, using the
+// same credentials as clients, and releases it right away. STUN URLs are
+// ignored, and TURN URLs that are not reachable over UDP are reported as
+// skipped. Results are in the order of the URLs.
+func Check(iceServers []config.ICEServer, params Params) []Result {
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	var results []Result
+	for _, iceServer := range iceauth.GetICEServers(iceServers) {
+		for _, url := range iceServer.URLs {
+			scheme := strings.SplitN(url, ":", 2)[0]
+			switch scheme {
+			case "turn", "turns":
+			default:
+				continue
+			}
+			addr, err := udpAddress(url)
+			if err != nil {
+				results = append(results, Result{URL: url, Skipped: true})
+				continue
+			}
+			results = append(results, Result{
+				URL: url,
+				Err: checkAddress(addr, iceServer.Username, iceServer.Credential, timeout),
+			})
+		}
+	}
+	return results
+}
+
+// Returns the address of a turn URL, e.g. host:3478 for
+// turn:host?transport=udp.
+func udpAddress(url string) (string, error) {
+	parts := strings.SplitN(url, ":", 2)
+	if len(parts) != 2 || parts[0] != "turn" {
+		return "", fmt.Errorf("%w: %s", errUnsupported, url)
+	}
+
+	hostPort := parts[1]
+	if i := strings.Index(hostPort, "?"); i >= 0 {
+		query := hostPort[i+1:]
+		hostPort = hostPort[:i]
+		if query != "" && query != "transport=udp" {
+			return "", fmt.Errorf("%w: %s", errUnsupported, url)
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		hostPort = net.JoinHostPort(strings.Trim(hostPort, "[]"), defaultPort)
+	}
+	return hostPort, nil
+}
+
+// The TURN client only resolves IPv4 addresses, so IPv6 servers are reached
+// through a connection that maps this address to the server.
+var ipv6Placeholder = net.IPv4(192, 0, 2, 1)
+
+func checkAddress(addr string, username string, password string, timeout time.Duration) error {
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("Error resolving address: %w", err)
+	}
+
+	var conn net.PacketConn
+	if serverAddr.IP.To4() != nil {
+		conn, err = net.ListenPacket("udp4", "0.0.0.0:0")
+	} else {
+		conn, err = net.ListenPacket("udp6", "[::]:0")
+	}
+	if err != nil {
+		return fmt.Errorf("Error listening: %w", err)
+	}
+	defer conn.Close()
+
+	if serverAddr.IP.To4() == nil {
+		placeholder := &net.UDPAddr{IP: ipv6Placeholder, Port: serverAddr.Port}
+		conn = &mappedConn{PacketConn: conn, placeholder: placeholder, server: serverAddr}
+		addr = placeholder.String()
+	}
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Conn:           conn,
+		Username:       username,
+		Password:       password,
+	})
+	if err != nil {
+		return fmt.Errorf("Error creating TURN client: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.Listen(); err != nil {
+		return fmt.Errorf("Error listening: %w", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		relay, err := client.Allocate()
+		if err != nil {
+			errCh <- fmt.Errorf("Error allocating relay: %w", err)
+			return
+		}
+		errCh <- relay.Close()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+	}
+}
+
+// mappedConn sends packets addressed to placeholder to server, and reports
+// packets received from server as coming from placeholder.
+type mappedConn struct {
+	net.PacketConn
+	placeholder *net.UDPAddr
+	server      *net.UDPAddr
+}
+
+func (c *mappedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr.String() == c.placeholder.String() {
+		addr = c.server
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *mappedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if addr != nil && addr.String() == c.server.String() {
+		addr = c.placeholder
+	}
+	return n, addr, err
+}
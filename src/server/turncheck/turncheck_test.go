@@ -0,0 +1,129 @@
+package turncheck_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/turncheck"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const realm = "peercalls.test"
+
+// Starts a TURN server accepting the time limited credentials generated from
+// secret.
+func startTURNServer(t *testing.T, secret string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.Nil(t, err)
+	return startTURNServerOn(t, conn, secret)
+}
+
+func startTURNServerOn(t *testing.T, conn net.PacketConn, secret string) string {
+	t.Helper()
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: realm,
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			h := hmac.New(sha1.New, []byte(secret))
+			h.Write([]byte(username))
+			password := base64.StdEncoding.EncodeToString(h.Sum(nil))
+			return turn.GenerateAuthKey(username, realm, password), true
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn: conn,
+			RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP("127.0.0.1"),
+				Address:      "127.0.0.1",
+			},
+		}},
+	})
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return conn.LocalAddr().String()
+}
+
+func iceServer(url string, secret string) config.ICEServer {
+	var s config.ICEServer
+	s.URLs = []string{url}
+	s.AuthType = config.AuthTypeSecret
+	s.AuthSecret.Username = "peercalls"
+	s.AuthSecret.Secret = secret
+	return s
+}
+
+func TestCheck(t *testing.T) {
+	addr := startTURNServer(t, "secret")
+
+	results := turncheck.Check([]config.ICEServer{
+		{URLs: []string{"stun:" + addr}},
+		iceServer("turn:"+addr+"?transport=udp", "secret"),
+	}, turncheck.Params{})
+
+	require.Equal(t, 1, len(results))
+	assert.Equal(t, "turn:"+addr+"?transport=udp", results[0].URL)
+	assert.Nil(t, results[0].Err)
+}
+
+func TestCheck_invalidCredentials(t *testing.T) {
+	addr := startTURNServer(t, "secret")
+
+	results := turncheck.Check([]config.ICEServer{
+		iceServer("turn:"+addr, "wrong"),
+	}, turncheck.Params{})
+
+	require.Equal(t, 1, len(results))
+	assert.NotNil(t, results[0].Err)
+}
+
+func TestCheck_unreachable(t *testing.T) {
+	// receives requests without ever responding
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	results := turncheck.Check([]config.ICEServer{
+		iceServer("turn:"+conn.LocalAddr().String(), "secret"),
+	}, turncheck.Params{Timeout: 100 * time.Millisecond})
+
+	require.Equal(t, 1, len(results))
+	assert.True(t, errors.Is(results[0].Err, turncheck.ErrTimeout), "expected ErrTimeout, but got: %s", results[0].Err)
+}
+
+func TestCheck_skipped(t *testing.T) {
+	results := turncheck.Check([]config.ICEServer{
+		iceServer("turns:turn.example.com:5349", "secret"),
+		iceServer("turn:turn.example.com?transport=tcp", "secret"),
+	}, turncheck.Params{})
+
+	require.Equal(t, 2, len(results))
+	for _, result := range results {
+		assert.True(t, result.Skipped, "expected %s to be skipped", result.URL)
+		assert.Nil(t, result.Err)
+	}
+}
+
+func TestCheck_ipv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %s", err)
+	}
+	addr := startTURNServerOn(t, conn, "secret")
+
+	results := turncheck.Check([]config.ICEServer{
+		iceServer("turn:"+addr, "secret"),
+	}, turncheck.Params{})
+
+	require.Equal(t, 1, len(results))
+	assert.False(t, results[0].Skipped)
+	assert.Nil(t, results[0].Err)
+}
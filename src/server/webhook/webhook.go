@@ -0,0 +1,220 @@
+// Package webhook notifies external services about room events by sending
+// signed JSON payloads to configured URLs.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var log = logger.GetLogger("webhook")
+
+var (
+	deliveredCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "peercalls_webhooks_delivered_total",
+		Help: "Number of webhook requests accepted by the receiver",
+	})
+	failedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "peercalls_webhooks_failed_total",
+		Help: "Number of webhooks that could not be delivered after all retries",
+	})
+)
+
+// Name of the header containing the hex encoded HMAC-SHA256 of the request
+// body, prefixed with "sha256=".
+const SignatureHeader = "X-PeerCalls-Signature"
+
+type EventType string
+
+const (
+	EventTypeRoomCreated   EventType = "room_created"
+	EventTypeRoomDestroyed EventType = "room_destroyed"
+	EventTypeRoomJoin      EventType = "room_join"
+	EventTypeRoomLeave     EventType = "room_leave"
+)
+
+type Event struct {
+	Type EventType `json:"type"`
+	// Namespace of the room, empty for rooms served under /ws.
+	Namespace string `json:"namespace,omitempty"`
+	Room      string `json:"room"`
+	// Empty for room_created and room_destroyed events.
+	ClientID string `json:"clientId,omitempty"`
+	// Metadata of the client set by the authenticator.
+	Metadata string `json:"metadata,omitempty"`
+	// Why the client left, one of the wsmessage.LeaveReason constants.
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+const (
+	defaultRetryDelay = time.Second
+	defaultTimeout    = 5 * time.Second
+)
+
+type Params struct {
+	// URLs that every event is sent to.
+	URLs []string
+	// Key used to sign the payloads. Payloads are not signed when empty.
+	Secret string
+	// Number of times a failed request is retried. Not retried when zero.
+	MaxRetries int
+	// Delay before the first retry, doubled after each retry. Defaults to 1
+	// second.
+	RetryDelay time.Duration
+	// Timeout of each request. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// Dispatcher sends events in the background. A nil Dispatcher drops all
+// events.
+type Dispatcher struct {
+	urls       []string
+	secret     []byte
+	maxRetries int
+	retryDelay time.Duration
+	client     *http.Client
+	namespace  string
+	wg         *sync.WaitGroup
+}
+
+// Returns nil when there are no URLs.
+func NewDispatcher(params Params) *Dispatcher {
+	if len(params.URLs) == 0 {
+		return nil
+	}
+
+	d := &Dispatcher{
+		urls:       params.URLs,
+		secret:     []byte(params.Secret),
+		maxRetries: params.MaxRetries,
+		retryDelay: params.RetryDelay,
+		client:     &http.Client{Timeout: params.Timeout},
+		wg:         &sync.WaitGroup{},
+	}
+
+	if d.retryDelay == 0 {
+		d.retryDelay = defaultRetryDelay
+	}
+	if d.client.Timeout == 0 {
+		d.client.Timeout = defaultTimeout
+	}
+
+	return d
+}
+
+// Returns a dispatcher that sets the namespace of the events it sends. Wait
+// on either dispatcher waits for the events of both. Returns nil when d is
+// nil.
+func (d *Dispatcher) WithNamespace(namespace string) *Dispatcher {
+	if d == nil {
+		return nil
+	}
+	namespaced := *d
+	namespaced.namespace = namespace
+	return &namespaced
+}
+
+// Sends the event to all URLs without blocking. The timestamp is set when it
+// is zero.
+func (d *Dispatcher) Dispatch(event Event) {
+	if d == nil {
+		return
+	}
+
+	if event.Namespace == "" {
+		event.Namespace = d.namespace
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error serializing %s event of room: %s: %s", event.Type, event.Room, err)
+		return
+	}
+
+	for _, url := range d.urls {
+		d.wg.Add(1)
+		go func(url string) {
+			defer d.wg.Done()
+			d.deliver(url, event, body)
+		}(url)
+	}
+}
+
+// Waits until all dispatched events have been delivered or have failed.
+func (d *Dispatcher) Wait() {
+	if d == nil {
+		return
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(url string, event Event, body []byte) {
+	delay := d.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := d.post(url, body)
+		if err == nil {
+			deliveredCounter.Inc()
+			return
+		}
+
+		if attempt >= d.maxRetries {
+			failedCounter.Inc()
+			log.Printf("Error sending %s event of room: %s to %s, giving up after %d retries: %s",
+				event.Type, event.Room, url, d.maxRetries, err)
+			return
+		}
+
+		log.Printf("Error sending %s event of room: %s to %s, retrying in %s: %s",
+			event.Type, event.Room, url, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (d *Dispatcher) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(d.secret, body))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// Returns the value of SignatureHeader for the body.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,186 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type request struct {
+	signature string
+	body      []byte
+}
+
+type receiver struct {
+	mu       sync.Mutex
+	requests []request
+	// Status codes returned to the first requests, 200 afterwards.
+	statuses []int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, request{
+		signature: req.Header.Get(webhook.SignatureHeader),
+		body:      body,
+	})
+
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+func (r *receiver) Requests() []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]request{}, r.requests...)
+}
+
+func TestDispatcher_payload(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(webhook.Params{
+		URLs:   []string{server.URL},
+		Secret: "secret",
+	})
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	d.Dispatch(webhook.Event{
+		Type:      webhook.EventTypeRoomJoin,
+		Room:      "room1",
+		ClientID:  "client1",
+		Metadata:  "abc",
+		Timestamp: timestamp,
+	})
+	d.Wait()
+
+	requests := recv.Requests()
+	require.Equal(t, 1, len(requests))
+	assert.JSONEq(t, `{
+		"type": "room_join",
+		"room": "room1",
+		"clientId": "client1",
+		"metadata": "abc",
+		"timestamp": "2020-01-02T03:04:05Z"
+	}`, string(requests[0].body))
+	assert.Equal(t, webhook.Sign([]byte("secret"), requests[0].body), requests[0].signature)
+	assert.Regexp(t, "^sha256=[0-9a-f]{64}$", requests[0].signature)
+}
+
+func TestDispatcher_timestamp(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(webhook.Params{
+		URLs: []string{server.URL},
+	})
+	d.Dispatch(webhook.Event{Type: webhook.EventTypeRoomCreated, Room: "room1"})
+	d.Wait()
+
+	requests := recv.Requests()
+	require.Equal(t, 1, len(requests))
+	assert.Equal(t, "", requests[0].signature, "payloads should not be signed without a secret")
+
+	var event webhook.Event
+	require.Nil(t, json.Unmarshal(requests[0].body, &event))
+	assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+}
+
+func TestDispatcher_WithNamespace(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(webhook.Params{
+		URLs: []string{server.URL},
+	})
+	d.WithNamespace("app1").Dispatch(webhook.Event{Type: webhook.EventTypeRoomCreated, Room: "room1"})
+	d.Wait()
+	d.Dispatch(webhook.Event{Type: webhook.EventTypeRoomCreated, Room: "room1"})
+	d.Wait()
+
+	requests := recv.Requests()
+	require.Equal(t, 2, len(requests))
+	var event webhook.Event
+	require.Nil(t, json.Unmarshal(requests[0].body, &event))
+	assert.Equal(t, "app1", event.Namespace)
+	assert.NotContains(t, string(requests[1].body), "namespace")
+}
+
+func TestDispatcher_retry(t *testing.T) {
+	recv := &receiver{
+		statuses: []int{http.StatusInternalServerError, http.StatusBadGateway},
+	}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(webhook.Params{
+		URLs:       []string{server.URL},
+		MaxRetries: 3,
+		RetryDelay: time.Millisecond,
+	})
+	d.Dispatch(webhook.Event{Type: webhook.EventTypeRoomLeave, Room: "room1", ClientID: "client1"})
+	d.Wait()
+
+	requests := recv.Requests()
+	require.Equal(t, 3, len(requests))
+	assert.Equal(t, requests[0].body, requests[2].body)
+}
+
+func TestDispatcher_maxRetries(t *testing.T) {
+	recv := &receiver{
+		statuses: []int{500, 500, 500, 500},
+	}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(webhook.Params{
+		URLs:       []string{server.URL},
+		MaxRetries: 2,
+		RetryDelay: time.Millisecond,
+	})
+	d.Dispatch(webhook.Event{Type: webhook.EventTypeRoomDestroyed, Room: "room1"})
+	d.Wait()
+
+	assert.Equal(t, 3, len(recv.Requests()))
+}
+
+func TestDispatcher_noRetries(t *testing.T) {
+	recv := &receiver{
+		statuses: []int{500, 500},
+	}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(webhook.Params{
+		URLs:       []string{server.URL},
+		RetryDelay: time.Millisecond,
+	})
+	d.Dispatch(webhook.Event{Type: webhook.EventTypeRoomDestroyed, Room: "room1"})
+	d.Wait()
+
+	assert.Equal(t, 1, len(recv.Requests()))
+}
+
+func TestDispatcher_disabled(t *testing.T) {
+	d := webhook.NewDispatcher(webhook.Params{})
+	assert.Nil(t, d)
+	d.Dispatch(webhook.Event{Type: webhook.EventTypeRoomCreated, Room: "room1"})
+	d.Wait()
+	assert.Nil(t, d.WithNamespace("app1"))
+}
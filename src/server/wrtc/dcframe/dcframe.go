@@ -0,0 +1,167 @@
+// Package dcframe implements a framing for multiplexing chat, file and
+// control messages over a single data channel.
+//
+// Each frame starts with a 5 byte header: a type byte followed by the length
+// of the payload as a big-endian uint32. The highest bit of the type byte is
+// set when more fragments of the same message follow. Messages larger than
+// the maximum frame size are split into fragments which are reassembled by
+// the receiver. Fragments of messages with different types may be
+// interleaved, but fragments of the same type must be sent in order.
+package dcframe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type FrameType byte
+
+const (
+	FrameTypeChat    FrameType = 1
+	FrameTypeFile    FrameType = 2
+	FrameTypeControl FrameType = 3
+)
+
+// HeaderSize is the size of the frame header in bytes.
+const HeaderSize = 5
+
+const flagMore = 0x80
+
+var (
+	ErrTruncated       = errors.New("truncated frame")
+	ErrInvalidType     = errors.New("invalid frame type")
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+type Frame struct {
+	Type FrameType
+	// More is set when more fragments of the same message follow.
+	More    bool
+	Payload []byte
+}
+
+type Message struct {
+	Type    FrameType
+	Payload []byte
+}
+
+func (t FrameType) valid() bool {
+	return t > 0 && t&flagMore == 0
+}
+
+// Encode encodes a single frame.
+func Encode(frame Frame) []byte {
+	data := make([]byte, HeaderSize+len(frame.Payload))
+
+	data[0] = byte(frame.Type)
+	if frame.More {
+		data[0] |= flagMore
+	}
+
+	binary.BigEndian.PutUint32(data[1:HeaderSize], uint32(len(frame.Payload)))
+	copy(data[HeaderSize:], frame.Payload)
+
+	return data
+}
+
+// Decode decodes the frame at the start of data and returns the number of
+// bytes read. The payload references data. Returns ErrTruncated when data
+// is shorter than the frame.
+func Decode(data []byte) (frame Frame, n int, err error) {
+	if len(data) < HeaderSize {
+		return frame, 0, fmt.Errorf("Decode: %w: %d bytes, need at least %d for the header",
+			ErrTruncated, len(data), HeaderSize)
+	}
+
+	frame.Type = FrameType(data[0] &^ flagMore)
+	frame.More = data[0]&flagMore != 0
+
+	if !frame.Type.valid() {
+		return frame, 0, fmt.Errorf("Decode: %w: %d", ErrInvalidType, frame.Type)
+	}
+
+	size := binary.BigEndian.Uint32(data[1:HeaderSize])
+	if uint64(len(data)-HeaderSize) < uint64(size) {
+		return frame, 0, fmt.Errorf("Decode: %w: %d payload bytes, expected %d",
+			ErrTruncated, len(data)-HeaderSize, size)
+	}
+
+	n = HeaderSize + int(size)
+	frame.Payload = data[HeaderSize:n]
+
+	return frame, n, nil
+}
+
+// EncodeMessage encodes a message into one or more frames with payloads of
+// at most maxPayloadSize bytes. The message is never split when
+// maxPayloadSize is zero.
+func EncodeMessage(msg Message, maxPayloadSize int) [][]byte {
+	payload := msg.Payload
+
+	if maxPayloadSize <= 0 || len(payload) <= maxPayloadSize {
+		return [][]byte{Encode(Frame{Type: msg.Type, Payload: payload})}
+	}
+
+	frames := make([][]byte, 0, (len(payload)+maxPayloadSize-1)/maxPayloadSize)
+
+	for len(payload) > maxPayloadSize {
+		frames = append(frames, Encode(Frame{
+			Type:    msg.Type,
+			More:    true,
+			Payload: payload[:maxPayloadSize],
+		}))
+		payload = payload[maxPayloadSize:]
+	}
+
+	return append(frames, Encode(Frame{Type: msg.Type, Payload: payload}))
+}
+
+// Reassembler reassembles messages from fragmented frames.
+type Reassembler struct {
+	maxMessageSize int
+	pending        map[FrameType][]byte
+}
+
+// NewReassembler creates a reassembler that rejects messages larger than
+// maxMessageSize bytes. There is no limit when maxMessageSize is zero.
+func NewReassembler(maxMessageSize int) *Reassembler {
+	return &Reassembler{
+		maxMessageSize: maxMessageSize,
+		pending:        map[FrameType][]byte{},
+	}
+}
+
+// Add decodes a frame and returns the message when it was the last fragment.
+// The pending fragments of a message are discarded when it is too large.
+func (r *Reassembler) Add(data []byte) (msg Message, ok bool, err error) {
+	frame, n, err := Decode(data)
+	if err != nil {
+		return msg, false, err
+	}
+
+	if n != len(data) {
+		return msg, false, fmt.Errorf("Reassembler.Add: %d unexpected bytes after frame", len(data)-n)
+	}
+
+	pending := r.pending[frame.Type]
+
+	if r.maxMessageSize > 0 && len(pending)+len(frame.Payload) > r.maxMessageSize {
+		delete(r.pending, frame.Type)
+		return msg, false, fmt.Errorf("Reassembler.Add: %w: more than %d bytes", ErrMessageTooLarge, r.maxMessageSize)
+	}
+
+	pending = append(pending, frame.Payload...)
+
+	if frame.More {
+		r.pending[frame.Type] = pending
+		return msg, false, nil
+	}
+
+	delete(r.pending, frame.Type)
+
+	return Message{
+		Type:    frame.Type,
+		Payload: pending,
+	}, true, nil
+}
@@ -0,0 +1,153 @@
+package dcframe_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/dcframe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := dcframe.Encode(dcframe.Frame{
+		Type:    dcframe.FrameTypeChat,
+		Payload: []byte("hello"),
+	})
+	assert.Equal(t, []byte{1, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}, data)
+
+	frame, n, err := dcframe.Decode(data)
+	require.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, dcframe.Frame{
+		Type:    dcframe.FrameTypeChat,
+		Payload: []byte("hello"),
+	}, frame)
+}
+
+func TestDecode_multipleFrames(t *testing.T) {
+	data := append(
+		dcframe.Encode(dcframe.Frame{Type: dcframe.FrameTypeControl, Payload: []byte("a")}),
+		dcframe.Encode(dcframe.Frame{Type: dcframe.FrameTypeFile, More: true, Payload: []byte("bc")})...,
+	)
+
+	frame, n, err := dcframe.Decode(data)
+	require.Nil(t, err)
+	assert.Equal(t, dcframe.Frame{Type: dcframe.FrameTypeControl, Payload: []byte("a")}, frame)
+
+	frame, _, err = dcframe.Decode(data[n:])
+	require.Nil(t, err)
+	assert.Equal(t, dcframe.Frame{Type: dcframe.FrameTypeFile, More: true, Payload: []byte("bc")}, frame)
+}
+
+func TestDecode_truncated(t *testing.T) {
+	data := dcframe.Encode(dcframe.Frame{Type: dcframe.FrameTypeChat, Payload: []byte("hello")})
+
+	for _, size := range []int{0, 1, dcframe.HeaderSize - 1, dcframe.HeaderSize, len(data) - 1} {
+		_, _, err := dcframe.Decode(data[:size])
+		require.NotNil(t, err, "expected an error for %d bytes", size)
+		assert.True(t, errors.Is(err, dcframe.ErrTruncated), "unexpected error: %s", err)
+	}
+}
+
+func TestDecode_invalidType(t *testing.T) {
+	_, _, err := dcframe.Decode([]byte{0, 0, 0, 0, 0})
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, dcframe.ErrInvalidType), "unexpected error: %s", err)
+}
+
+func TestReassembler_singleFrame(t *testing.T) {
+	r := dcframe.NewReassembler(0)
+
+	frames := dcframe.EncodeMessage(dcframe.Message{
+		Type:    dcframe.FrameTypeChat,
+		Payload: []byte("hello"),
+	}, 16)
+	require.Equal(t, 1, len(frames))
+
+	msg, ok, err := r.Add(frames[0])
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, dcframe.Message{Type: dcframe.FrameTypeChat, Payload: []byte("hello")}, msg)
+}
+
+func TestReassembler_multiFrame(t *testing.T) {
+	r := dcframe.NewReassembler(0)
+
+	payload := bytes.Repeat([]byte("0123456789"), 10)
+	frames := dcframe.EncodeMessage(dcframe.Message{
+		Type:    dcframe.FrameTypeFile,
+		Payload: payload,
+	}, 32)
+	require.Equal(t, 4, len(frames))
+
+	chat := dcframe.EncodeMessage(dcframe.Message{
+		Type:    dcframe.FrameTypeChat,
+		Payload: []byte("hi"),
+	}, 32)
+
+	for i, frame := range frames {
+		if i == 1 {
+			// Messages of other types can be interleaved with the fragments.
+			msg, ok, err := r.Add(chat[0])
+			require.Nil(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, dcframe.Message{Type: dcframe.FrameTypeChat, Payload: []byte("hi")}, msg)
+		}
+
+		msg, ok, err := r.Add(frame)
+		require.Nil(t, err)
+		if i < len(frames)-1 {
+			assert.False(t, ok, "expected message to be incomplete after frame %d", i)
+			continue
+		}
+		assert.True(t, ok)
+		assert.Equal(t, dcframe.Message{Type: dcframe.FrameTypeFile, Payload: payload}, msg)
+	}
+}
+
+func TestReassembler_truncated(t *testing.T) {
+	r := dcframe.NewReassembler(0)
+
+	data := dcframe.Encode(dcframe.Frame{Type: dcframe.FrameTypeChat, Payload: []byte("hello")})
+	_, ok, err := r.Add(data[:len(data)-2])
+	require.NotNil(t, err)
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, dcframe.ErrTruncated), "unexpected error: %s", err)
+
+	_, ok, err = r.Add(append(data, 0))
+	require.NotNil(t, err)
+	assert.False(t, ok)
+	assert.Regexp(t, "unexpected bytes", err.Error())
+}
+
+func TestReassembler_messageTooLarge(t *testing.T) {
+	r := dcframe.NewReassembler(8)
+
+	frames := dcframe.EncodeMessage(dcframe.Message{
+		Type:    dcframe.FrameTypeFile,
+		Payload: []byte("0123456789"),
+	}, 4)
+	require.Equal(t, 3, len(frames))
+
+	for _, frame := range frames[:2] {
+		_, ok, err := r.Add(frame)
+		require.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	_, ok, err := r.Add(frames[2])
+	require.NotNil(t, err)
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, dcframe.ErrMessageTooLarge), "unexpected error: %s", err)
+
+	// The pending fragments were discarded.
+	msg, ok, err := r.Add(dcframe.EncodeMessage(dcframe.Message{
+		Type:    dcframe.FrameTypeFile,
+		Payload: []byte("abc"),
+	}, 4)[0])
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("abc"), msg.Payload)
+}
@@ -0,0 +1,62 @@
+package negotiator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	negotiationsActiveGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "peercalls_negotiations_active",
+		Help: "Number of offers and answers being created",
+	})
+	negotiationsQueuedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "peercalls_negotiations_queued",
+		Help: "Number of negotiations waiting for a slot",
+	})
+)
+
+// Limiter limits the number of offers and answers created concurrently
+// across all peer connections. Negotiations wait in a queue for a free slot.
+// A nil Limiter does not limit anything.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// Creates a limiter with max slots. Negotiations are not limited when max is
+// zero.
+func NewLimiter(max int) *Limiter {
+	l := &Limiter{}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// Acquire blocks until a slot is free. Every call must be followed by a call
+// to Release.
+func (l *Limiter) Acquire() {
+	if l == nil || l.slots == nil {
+		return
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		negotiationsQueuedGauge.Inc()
+		l.slots <- struct{}{}
+		negotiationsQueuedGauge.Dec()
+	}
+
+	negotiationsActiveGauge.Inc()
+}
+
+// Release frees a slot acquired with Acquire.
+func (l *Limiter) Release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+
+	negotiationsActiveGauge.Dec()
+	<-l.slots
+}
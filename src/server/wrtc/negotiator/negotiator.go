@@ -1,14 +1,40 @@
 package negotiator
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/logger"
 	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var log = logger.GetLogger("negotiator")
 
+var transceiverRequestsDroppedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "peercalls_transceiver_requests_dropped_total",
+	Help: "Total number of transceiver requests dropped because the queue of a peer was full",
+})
+
+var ErrTransceiverQueueFull = errors.New("Transceiver request queue full")
+
+// OverflowPolicy decides what happens to a transceiver request queued when
+// the queue is full.
+type OverflowPolicy int
+
+const (
+	// Drops the request being queued.
+	OverflowPolicyDropNewest OverflowPolicy = iota
+	// Drops the oldest queued request to make room for the request being
+	// queued.
+	OverflowPolicyDropOldest
+	// Drops the request being queued and calls Params.OnQueueOverflow so that
+	// the peer connection can be closed.
+	OverflowPolicyClose
+)
+
 type PeerConnection interface {
 	CreateOffer(*webrtc.OfferOptions) (webrtc.SessionDescription, error)
 	OnSignalingStateChange(func(webrtc.SignalingState))
@@ -26,12 +52,58 @@ type Negotiator struct {
 	peerConnection       PeerConnection
 	onOffer              func(webrtc.SessionDescription, error)
 	onRequestNegotiation func()
+	onStateChange        func(webrtc.SignalingState)
+	onTransceiverError   func(TransceiverRequest, error)
+	limiter              *Limiter
 
 	isNegotiating     bool
 	mu                sync.Mutex
 	queuedNegotiation bool
 
 	queuedTransceiverRequests []TransceiverRequest
+
+	maxQueuedTransceivers int
+	overflowPolicy        OverflowPolicy
+	onQueueOverflow       func()
+
+	maxNegotiations int
+	window          time.Duration
+	// Start times of negotiations within the window, oldest first.
+	negotiatedAt  []time.Time
+	throttleTimer *time.Timer
+	closed        bool
+}
+
+const defaultMaxNegotiationsWindow = time.Minute
+
+type Params struct {
+	// Maximum number of negotiations started within MaxNegotiationsWindow.
+	// Further negotiations are postponed until the oldest one leaves the
+	// window. Unlimited when zero.
+	MaxNegotiations int
+	// Duration of the rolling window. Defaults to one minute.
+	MaxNegotiationsWindow time.Duration
+	// Called on every signaling state change. Only one handler can be
+	// registered on the peer connection, and it is used by the negotiator.
+	OnSignalingStateChange func(webrtc.SignalingState)
+	// Called when a queued transceiver could not be added to the peer
+	// connection. The negotiation continues without it.
+	OnTransceiverError func(TransceiverRequest, error)
+	// Shared by all negotiators to limit the number of offers created
+	// concurrently. A slot is held while the offer is created and handled.
+	// Not limited when nil.
+	Limiter *Limiter
+	// Maximum number of transceiver requests queued until the next
+	// negotiation starts, e.g. while a peer does not complete the current
+	// one. Unlimited when zero.
+	MaxQueuedTransceivers int
+	// Decides what happens to requests queued when the queue is full.
+	// Dropped requests are passed to OnTransceiverError with
+	// ErrTransceiverQueueFull. Defaults to OverflowPolicyDropNewest.
+	OverflowPolicy OverflowPolicy
+	// Called instead of OnTransceiverError when the queue is full and the
+	// policy is OverflowPolicyClose.
+	OnQueueOverflow func()
 }
 
 func NewNegotiator(
@@ -40,6 +112,24 @@ func NewNegotiator(
 	remotePeerID string,
 	onOffer func(webrtc.SessionDescription, error),
 	onRequestNegotiation func(),
+) *Negotiator {
+	return NewNegotiatorWithParams(
+		initiator,
+		peerConnection,
+		remotePeerID,
+		onOffer,
+		onRequestNegotiation,
+		Params{},
+	)
+}
+
+func NewNegotiatorWithParams(
+	initiator bool,
+	peerConnection PeerConnection,
+	remotePeerID string,
+	onOffer func(webrtc.SessionDescription, error),
+	onRequestNegotiation func(),
+	params Params,
 ) *Negotiator {
 	n := &Negotiator{
 		initiator:            initiator,
@@ -47,6 +137,19 @@ func NewNegotiator(
 		remotePeerID:         remotePeerID,
 		onOffer:              onOffer,
 		onRequestNegotiation: onRequestNegotiation,
+		onStateChange:        params.OnSignalingStateChange,
+		onTransceiverError:   params.OnTransceiverError,
+		limiter:              params.Limiter,
+		maxNegotiations:      params.MaxNegotiations,
+		window:               params.MaxNegotiationsWindow,
+
+		maxQueuedTransceivers: params.MaxQueuedTransceivers,
+		overflowPolicy:        params.OverflowPolicy,
+		onQueueOverflow:       params.OnQueueOverflow,
+	}
+
+	if n.window == 0 {
+		n.window = defaultMaxNegotiationsWindow
 	}
 
 	peerConnection.OnSignalingStateChange(n.handleSignalingStateChange)
@@ -55,28 +158,78 @@ func NewNegotiator(
 
 func (n *Negotiator) AddTransceiverFromKind(t TransceiverRequest) {
 	n.mu.Lock()
-	log.Printf("[%s] Queued %s transceiver, direction: %s", n.remotePeerID, t.CodecType, t.Init.Direction)
-	n.queuedTransceiverRequests = append(n.queuedTransceiverRequests, t)
+	dropped, overflow := n.queueTransceiverRequest(t)
 	n.mu.Unlock()
+
+	if overflow {
+		// a negotiation is already queued for the requests in the queue
+		n.handleQueueOverflow(dropped)
+		return
+	}
+
 	log.Printf("[%s] Calling Negotiate because a %s transceiver was queued", n.remotePeerID, t.CodecType)
 	n.Negotiate()
 }
 
+// Queues the request, or returns the request dropped and true when the queue
+// is full.
+func (n *Negotiator) queueTransceiverRequest(t TransceiverRequest) (TransceiverRequest, bool) {
+	queue := n.queuedTransceiverRequests
+	if n.maxQueuedTransceivers <= 0 || len(queue) < n.maxQueuedTransceivers {
+		log.Printf("[%s] Queued %s transceiver, direction: %s", n.remotePeerID, t.CodecType, t.Init.Direction)
+		n.queuedTransceiverRequests = append(queue, t)
+		return TransceiverRequest{}, false
+	}
+
+	transceiverRequestsDroppedCounter.Inc()
+
+	if n.overflowPolicy == OverflowPolicyDropOldest {
+		dropped := queue[0]
+		n.queuedTransceiverRequests = append(queue[1:], t)
+		return dropped, true
+	}
+
+	return t, true
+}
+
+func (n *Negotiator) handleQueueOverflow(dropped TransceiverRequest) {
+	log.Printf("[%s] Dropping %s transceiver request, more than %d queued", n.remotePeerID, dropped.CodecType, n.maxQueuedTransceivers)
+
+	if n.overflowPolicy == OverflowPolicyClose {
+		if n.onQueueOverflow != nil {
+			n.onQueueOverflow()
+		}
+		return
+	}
+
+	if n.onTransceiverError != nil {
+		n.onTransceiverError(dropped, ErrTransceiverQueueFull)
+	}
+}
+
 func (n *Negotiator) handleSignalingStateChange(state webrtc.SignalingState) {
 	// TODO check if we need to have a check for first stable state
 	// like simple-peer has.
 	log.Printf("[%s] Signaling state change for: %s", n.remotePeerID, state)
+	if n.onStateChange != nil {
+		n.onStateChange(state)
+	}
 
 	if state == webrtc.SignalingStateStable {
 		n.mu.Lock()
-		defer n.mu.Unlock()
 		n.isNegotiating = false
 
+		createOffer := false
 		if n.queuedNegotiation {
 			n.isNegotiating = true
 			log.Printf("[%s] Executing queued negotiation", n.remotePeerID)
 			n.queuedNegotiation = false
-			n.negotiate()
+			createOffer = n.negotiate()
+		}
+		n.mu.Unlock()
+
+		if createOffer {
+			n.createOffer()
 		}
 	}
 }
@@ -85,17 +238,22 @@ func (n *Negotiator) Negotiate() {
 	log.Printf("[%s] Negotiate", n.remotePeerID)
 
 	n.mu.Lock()
-	defer n.mu.Unlock()
 	if n.isNegotiating {
 		log.Printf("[%s] Negotiate: already negotiating, queueing for later", n.remotePeerID)
 		n.queuedNegotiation = true
+		n.mu.Unlock()
 		return
 	}
 
 	log.Printf("[%s] Negotiate: start", n.remotePeerID)
 	n.isNegotiating = true
 
-	n.negotiate()
+	createOffer := n.negotiate()
+	n.mu.Unlock()
+
+	if createOffer {
+		n.createOffer()
+	}
 }
 
 func (n *Negotiator) addQueuedTransceivers() {
@@ -103,26 +261,101 @@ func (n *Negotiator) addQueuedTransceivers() {
 		log.Printf("[%s] Adding queued %s transceiver, direction: %s", n.remotePeerID, t.CodecType, t.Init.Direction)
 		_, err := n.peerConnection.AddTransceiverFromKind(t.CodecType, t.Init)
 		if err != nil {
-			log.Printf("[%s] Error adding %s transceiver: %s", n.remotePeerID, err)
+			log.Printf("[%s] Error adding %s transceiver: %s", n.remotePeerID, t.CodecType, err)
+			if n.onTransceiverError != nil {
+				n.onTransceiverError(t, err)
+			}
 		}
 	}
 	n.queuedTransceiverRequests = []TransceiverRequest{}
 }
 
-func (n *Negotiator) negotiate() {
+// Returns the time to wait before the next negotiation can start, or zero
+// when it can start now. Records the negotiation when it can start.
+func (n *Negotiator) reserveNegotiation() time.Duration {
+	if n.maxNegotiations <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	expired := 0
+	for _, t := range n.negotiatedAt {
+		if now.Sub(t) < n.window {
+			break
+		}
+		expired++
+	}
+	n.negotiatedAt = n.negotiatedAt[expired:]
+
+	if len(n.negotiatedAt) >= n.maxNegotiations {
+		return n.negotiatedAt[0].Add(n.window).Sub(now)
+	}
+
+	n.negotiatedAt = append(n.negotiatedAt, now)
+	return 0
+}
+
+// Starts a negotiation with n.mu held. Returns true when an offer should be
+// created, which is left to the caller so that the lock is not held while
+// waiting for the limiter. isNegotiating stays set meanwhile, so other
+// negotiations are queued.
+func (n *Negotiator) negotiate() bool {
+	if delay := n.reserveNegotiation(); delay > 0 {
+		n.isNegotiating = false
+		if n.throttleTimer == nil {
+			log.Printf("[%s] negotiate: more than %d negotiations in %s, postponing by %s",
+				n.remotePeerID, n.maxNegotiations, n.window, delay)
+			n.throttleTimer = time.AfterFunc(delay, n.handleThrottleTimeout)
+		}
+		return false
+	}
+
 	n.addQueuedTransceivers()
 
 	if !n.initiator {
 		log.Printf("[%s] negotiate: requesting from initiator", n.remotePeerID)
 		n.requestNegotiation()
-		return
+		return false
 	}
 
+	return true
+}
+
+func (n *Negotiator) createOffer() {
+	n.limiter.Acquire()
+	defer n.limiter.Release()
+
 	log.Printf("[%s] negotiate: creating offer", n.remotePeerID)
 	offer, err := n.peerConnection.CreateOffer(nil)
 	n.onOffer(offer, err)
 }
 
+// Cancels a postponed negotiation.
+func (n *Negotiator) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
+	if n.throttleTimer != nil {
+		n.throttleTimer.Stop()
+		n.throttleTimer = nil
+	}
+}
+
+func (n *Negotiator) handleThrottleTimeout() {
+	n.mu.Lock()
+	closed := n.closed
+	n.throttleTimer = nil
+	n.mu.Unlock()
+
+	if closed {
+		return
+	}
+
+	log.Printf("[%s] Executing postponed negotiation", n.remotePeerID)
+	n.Negotiate()
+}
+
 func (n *Negotiator) requestNegotiation() {
 	n.onRequestNegotiation()
 }
@@ -0,0 +1,279 @@
+package negotiator_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/negotiator"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPeerConnection struct {
+	onSignalingStateChange func(webrtc.SignalingState)
+	onCreateOffer          func()
+	// Directions of the transceivers added.
+	added []webrtc.RTPTransceiverDirection
+}
+
+func (p *mockPeerConnection) CreateOffer(*webrtc.OfferOptions) (webrtc.SessionDescription, error) {
+	if p.onCreateOffer != nil {
+		p.onCreateOffer()
+	}
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer}, nil
+}
+
+func (p *mockPeerConnection) OnSignalingStateChange(fn func(webrtc.SignalingState)) {
+	p.onSignalingStateChange = fn
+}
+
+func (p *mockPeerConnection) AddTransceiverFromKind(codecType webrtc.RTPCodecType, init ...webrtc.RtpTransceiverInit) (*webrtc.RTPTransceiver, error) {
+	p.added = append(p.added, init[0].Direction)
+	return nil, nil
+}
+
+type offerCounter struct {
+	mu     sync.Mutex
+	offers int
+}
+
+func (c *offerCounter) handleOffer(webrtc.SessionDescription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offers++
+}
+
+func (c *offerCounter) Offers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offers
+}
+
+func newThrottledNegotiator(pc *mockPeerConnection, counter *offerCounter, window time.Duration) *negotiator.Negotiator {
+	return negotiator.NewNegotiatorWithParams(
+		true,
+		pc,
+		"client1",
+		counter.handleOffer,
+		func() {},
+		negotiator.Params{
+			MaxNegotiations:       2,
+			MaxNegotiationsWindow: window,
+		},
+	)
+}
+
+func TestNegotiator_maxNegotiations(t *testing.T) {
+	pc := &mockPeerConnection{}
+	counter := &offerCounter{}
+	n := newThrottledNegotiator(pc, counter, 100*time.Millisecond)
+	defer n.Close()
+
+	for i := 0; i < 5; i++ {
+		n.Negotiate()
+		pc.onSignalingStateChange(webrtc.SignalingStateStable)
+	}
+	assert.Equal(t, 2, counter.Offers(), "expected negotiations to be throttled")
+
+	deadline := time.Now().Add(time.Second)
+	for counter.Offers() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 3, counter.Offers(), "expected postponed negotiation")
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, counter.Offers(), "expected throttled negotiations to be merged")
+}
+
+func TestNegotiator_maxNegotiations_close(t *testing.T) {
+	pc := &mockPeerConnection{}
+	counter := &offerCounter{}
+	n := newThrottledNegotiator(pc, counter, 20*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		n.Negotiate()
+		pc.onSignalingStateChange(webrtc.SignalingStateStable)
+	}
+	n.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, counter.Offers(), "expected postponed negotiation to be cancelled")
+}
+
+func TestNegotiator_unlimited(t *testing.T) {
+	pc := &mockPeerConnection{}
+	counter := &offerCounter{}
+	n := negotiator.NewNegotiator(true, pc, "client1", counter.handleOffer, func() {})
+
+	for i := 0; i < 5; i++ {
+		n.Negotiate()
+		pc.onSignalingStateChange(webrtc.SignalingStateStable)
+	}
+	assert.Equal(t, 5, counter.Offers())
+}
+
+// Tracks the number of offers created concurrently.
+type concurrencyCounter struct {
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func (c *concurrencyCounter) createOffer() {
+	c.mu.Lock()
+	c.active++
+	if c.active > c.max {
+		c.max = c.active
+	}
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+}
+
+func TestNegotiator_limiter(t *testing.T) {
+	limiter := negotiator.NewLimiter(2)
+	concurrency := &concurrencyCounter{}
+	counter := &offerCounter{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		pc := &mockPeerConnection{onCreateOffer: concurrency.createOffer}
+		n := negotiator.NewNegotiatorWithParams(
+			true,
+			pc,
+			"client1",
+			counter.handleOffer,
+			func() {},
+			negotiator.Params{
+				Limiter: limiter,
+			},
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.Negotiate()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 6, counter.Offers())
+	assert.Equal(t, 2, concurrency.max, "expected at most 2 concurrent negotiations")
+}
+
+func TestNegotiator_limiter_unlocked(t *testing.T) {
+	limiter := negotiator.NewLimiter(1)
+	limiter.Acquire()
+
+	pc := &mockPeerConnection{}
+	counter := &offerCounter{}
+	n := negotiator.NewNegotiatorWithParams(
+		true,
+		pc,
+		"client1",
+		counter.handleOffer,
+		func() {},
+		negotiator.Params{
+			Limiter: limiter,
+		},
+	)
+
+	negotiated := make(chan struct{})
+	go func() {
+		n.Negotiate()
+		close(negotiated)
+	}()
+
+	queued := make(chan struct{})
+	go func() {
+		// waits for the limiter slot while the first negotiation is pending
+		time.Sleep(20 * time.Millisecond)
+		n.AddTransceiverFromKind(newTransceiverRequest(webrtc.RTPTransceiverDirectionRecvonly))
+		close(queued)
+	}()
+
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		require.Fail(t, "expected the negotiator not to be locked while waiting for the limiter")
+	}
+	assert.Equal(t, 0, counter.Offers())
+
+	limiter.Release()
+	<-negotiated
+	assert.Equal(t, 1, counter.Offers())
+
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+	assert.Equal(t, 2, counter.Offers(), "expected the queued negotiation to be executed")
+	assert.Equal(t, []webrtc.RTPTransceiverDirection{webrtc.RTPTransceiverDirectionRecvonly}, pc.added)
+}
+
+func newTransceiverRequest(direction webrtc.RTPTransceiverDirection) negotiator.TransceiverRequest {
+	return negotiator.TransceiverRequest{
+		CodecType: webrtc.RTPCodecTypeAudio,
+		Init:      webrtc.RtpTransceiverInit{Direction: direction},
+	}
+}
+
+func TestNegotiator_maxQueuedTransceivers(t *testing.T) {
+	sendrecv := webrtc.RTPTransceiverDirectionSendrecv
+	sendonly := webrtc.RTPTransceiverDirectionSendonly
+	recvonly := webrtc.RTPTransceiverDirectionRecvonly
+	inactive := webrtc.RTPTransceiverDirectionInactive
+
+	for _, tc := range []struct {
+		name     string
+		policy   negotiator.OverflowPolicy
+		added    []webrtc.RTPTransceiverDirection
+		dropped  []webrtc.RTPTransceiverDirection
+		overflow int
+	}{
+		{"drop newest", negotiator.OverflowPolicyDropNewest, []webrtc.RTPTransceiverDirection{sendonly, recvonly}, []webrtc.RTPTransceiverDirection{inactive}, 0},
+		{"drop oldest", negotiator.OverflowPolicyDropOldest, []webrtc.RTPTransceiverDirection{recvonly, inactive}, []webrtc.RTPTransceiverDirection{sendonly}, 0},
+		{"close", negotiator.OverflowPolicyClose, []webrtc.RTPTransceiverDirection{sendonly, recvonly}, nil, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pc := &mockPeerConnection{}
+			counter := &offerCounter{}
+			var dropped []webrtc.RTPTransceiverDirection
+			overflow := 0
+			n := negotiator.NewNegotiatorWithParams(
+				true,
+				pc,
+				"client1",
+				counter.handleOffer,
+				func() {},
+				negotiator.Params{
+					MaxQueuedTransceivers: 2,
+					OverflowPolicy:        tc.policy,
+					OnTransceiverError: func(req negotiator.TransceiverRequest, err error) {
+						assert.Equal(t, negotiator.ErrTransceiverQueueFull, err)
+						dropped = append(dropped, req.Init.Direction)
+					},
+					OnQueueOverflow: func() {
+						overflow++
+					},
+				},
+			)
+			defer n.Close()
+
+			// the first request is added by the negotiation it starts, which
+			// does not complete until the signaling state becomes stable
+			n.AddTransceiverFromKind(newTransceiverRequest(sendrecv))
+			n.AddTransceiverFromKind(newTransceiverRequest(sendonly))
+			n.AddTransceiverFromKind(newTransceiverRequest(recvonly))
+			n.AddTransceiverFromKind(newTransceiverRequest(inactive))
+			assert.Equal(t, tc.dropped, dropped)
+			assert.Equal(t, tc.overflow, overflow)
+
+			pc.onSignalingStateChange(webrtc.SignalingStateStable)
+			assert.Equal(t, append([]webrtc.RTPTransceiverDirection{sendrecv}, tc.added...), pc.added)
+			assert.Equal(t, 2, counter.Offers())
+		})
+	}
+}
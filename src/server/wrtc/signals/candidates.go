@@ -0,0 +1,79 @@
+package signals
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// Returns the connection address of an ICE candidate attribute, with or
+// without the "a=" prefix.
+func candidateAddress(candidate string) (string, bool) {
+	candidate = strings.TrimPrefix(candidate, "a=")
+	if !strings.HasPrefix(candidate, "candidate:") {
+		return "", false
+	}
+	fields := strings.Fields(candidate)
+	if len(fields) < 5 {
+		return "", false
+	}
+	return fields[4], true
+}
+
+// Returns the type and transport protocol of an ICE candidate attribute, for
+// example "host udp", or "unknown" when the attribute cannot be parsed.
+func candidateSummary(candidate string) string {
+	candidate = strings.TrimPrefix(candidate, "a=")
+	fields := strings.Fields(candidate)
+	if !strings.HasPrefix(candidate, "candidate:") || len(fields) < 8 || fields[6] != "typ" {
+		return "unknown"
+	}
+	return fields[7] + " " + strings.ToLower(fields[2])
+}
+
+// Removes candidate lines whose address is not allowed from sdp.
+func filterSDPCandidates(sdp string, allowCandidate func(address string) bool) string {
+	lines := strings.SplitAfter(sdp, "\n")
+	filtered := lines[:0]
+	for _, line := range lines {
+		address, ok := candidateAddress(strings.TrimRight(line, "\r\n"))
+		if ok && !allowCandidate(address) {
+			continue
+		}
+		filtered = append(filtered, line)
+	}
+	return strings.Join(filtered, "")
+}
+
+// Returns the local and remote candidates of the nominated candidate pair in
+// report, or of a succeeded pair when none is nominated.
+func selectedCandidatePair(report webrtc.StatsReport) (local webrtc.ICECandidateStats, remote webrtc.ICECandidateStats, ok bool) {
+	var selected webrtc.ICECandidatePairStats
+	found := false
+	for _, stats := range report {
+		pair, isPair := stats.(webrtc.ICECandidatePairStats)
+		if !isPair || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+		if !found || pair.Nominated {
+			selected = pair
+			found = true
+		}
+		if pair.Nominated {
+			break
+		}
+	}
+	if !found {
+		return local, remote, false
+	}
+
+	local, localOK := report[selected.LocalCandidateID].(webrtc.ICECandidateStats)
+	remote, remoteOK := report[selected.RemoteCandidateID].(webrtc.ICECandidateStats)
+	return local, remote, localOK && remoteOK
+}
+
+// Returns the type and transport protocol of candidate stats, for example
+// "relay udp", in the same format as candidateSummary.
+func candidateStatsSummary(stats webrtc.ICECandidateStats) string {
+	return stats.CandidateType.String() + " " + strings.ToLower(stats.Protocol)
+}
@@ -0,0 +1,171 @@
+package signals
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCandidateSummary(t *testing.T) {
+	for candidate, summary := range map[string]string{
+		"candidate:1 1 UDP 2122252543 192.168.1.2 51234 typ host":                                  "host udp",
+		"a=candidate:2 1 tcp 1518280447 10.0.0.1 9 typ host tcptype active":                        "host tcp",
+		"candidate:3 1 udp 1686052607 1.2.3.4 51234 typ srflx raddr 192.168.1.2 rport 51234":       "srflx udp",
+		"candidate:4 1 udp 41885439 5.6.7.8 3478 typ relay raddr 1.2.3.4 rport 51234 generation 0": "relay udp",
+		"candidate:5 1 udp": "unknown",
+		"invalid":           "unknown",
+		"":                  "unknown",
+	} {
+		assert.Equal(t, summary, candidateSummary(candidate), "summary of %q", candidate)
+	}
+}
+
+func TestSignaller_logCandidate(t *testing.T) {
+	var out bytes.Buffer
+	defer func(l *logger.DedupLogger) { candidateLog = l }(candidateLog)
+	candidateLog = logger.NewDedupLogger(logger.NewLogger("signals", &out, true), time.Minute)
+
+	s := &Signaller{remotePeerID: "peer1", logID: "peer1"}
+	s.logCandidate("Local", "candidate:1 1 udp 2122252543 192.168.1.2 51234 typ host")
+
+	assert.Contains(t, out.String(), "[peer1] Local signal.candidate: host udp\n")
+	assert.NotContains(t, out.String(), "192.168.1.2", "addresses should only be logged to the sdp logger")
+}
+
+func TestSignaller_logSDP(t *testing.T) {
+	var out bytes.Buffer
+	defer func(l *logger.Logger) { sdpLog = l }(sdpLog)
+	sdpLog = logger.NewLogger("sdp", &out, true)
+
+	s := &Signaller{remotePeerID: "peer1", logID: "peer1"}
+	s.logCandidate("Local", "candidate:1 1 udp 2122252543 192.168.1.2 51234 typ host")
+	s.sdpLogf("[%s] Local signal.type: %s, signal.sdp: %s", s.remotePeerID, "offer", "v=0")
+	assert.Equal(t, "", out.String(), "nothing should be logged without LogSDP")
+
+	s.logSDP = true
+	s.logCandidate("Local", "candidate:1 1 udp 2122252543 192.168.1.2 51234 typ host")
+	s.sdpLogf("[%s] Local signal.type: %s, signal.sdp: %s", s.remotePeerID, "offer", "v=0")
+	assert.Contains(t, out.String(), "[peer1] Local signal.candidate: candidate:1 1 udp 2122252543 192.168.1.2 51234 typ host\n")
+	assert.Contains(t, out.String(), "[peer1] Local signal.type: offer, signal.sdp: v=0\n")
+}
+
+func TestSignaller_logCorrelationID(t *testing.T) {
+	var out bytes.Buffer
+	defer func(l *logger.DedupLogger) { candidateLog = l }(candidateLog)
+	candidateLog = logger.NewDedupLogger(logger.NewLogger("signals", &out, true), time.Minute)
+
+	assert.Equal(t, "peer1", newLogID("peer1", nil))
+	assert.Equal(t, "peer1", newLogID("peer1", context.Background()))
+
+	ctx := logger.WithCorrelationID(context.Background(), "abcd1234")
+	s := &Signaller{remotePeerID: "peer1", logID: newLogID("peer1", ctx)}
+	s.logCandidate("Local", "candidate:1 1 udp 2122252543 192.168.1.2 51234 typ host")
+	s.logCandidate("Remote", "candidate:2 1 udp 2122252543 192.168.1.3 51234 typ host")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, 2, len(lines))
+	for _, line := range lines {
+		assert.Contains(t, line, "[peer1 abcd1234] ")
+	}
+}
+
+func newCandidatePairReport(nominated bool) webrtc.StatsReport {
+	return webrtc.StatsReport{
+		"pair": webrtc.ICECandidatePairStats{
+			Type:              webrtc.StatsTypeCandidatePair,
+			LocalCandidateID:  "local",
+			RemoteCandidateID: "remote",
+			State:             webrtc.StatsICECandidatePairStateSucceeded,
+			Nominated:         nominated,
+		},
+		"failed": webrtc.ICECandidatePairStats{
+			Type:              webrtc.StatsTypeCandidatePair,
+			LocalCandidateID:  "local",
+			RemoteCandidateID: "other",
+			State:             webrtc.StatsICECandidatePairStateFailed,
+		},
+		"local": webrtc.ICECandidateStats{
+			Type:          webrtc.StatsTypeLocalCandidate,
+			CandidateType: webrtc.ICECandidateTypeHost,
+			Protocol:      "udp",
+			IP:            "10.0.0.1",
+			Port:          5000,
+		},
+		"remote": webrtc.ICECandidateStats{
+			Type:          webrtc.StatsTypeRemoteCandidate,
+			CandidateType: webrtc.ICECandidateTypeRelay,
+			Protocol:      "udp",
+			IP:            "1.2.3.4",
+			Port:          3478,
+		},
+	}
+}
+
+func TestSelectedCandidatePair(t *testing.T) {
+	for _, nominated := range []bool{true, false} {
+		local, remote, ok := selectedCandidatePair(newCandidatePairReport(nominated))
+		require.True(t, ok)
+		assert.Equal(t, "host udp", candidateStatsSummary(local))
+		assert.Equal(t, "relay udp", candidateStatsSummary(remote))
+	}
+
+	_, _, ok := selectedCandidatePair(webrtc.StatsReport{})
+	assert.False(t, ok)
+}
+
+type statsPeerConnection struct {
+	PeerConnection
+	report webrtc.StatsReport
+}
+
+func (p statsPeerConnection) GetStats() webrtc.StatsReport {
+	return p.report
+}
+
+// Buffer written by the goroutine logging the candidate pair.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSignaller_logCandidatePair(t *testing.T) {
+	var out syncBuffer
+	defer func(l *logger.Logger) { log = l }(log)
+	log = logger.NewLogger("signals", &out, true)
+
+	for _, enabled := range []bool{false, true} {
+		s := &Signaller{
+			logID:            "peer1",
+			peerConnection:   statsPeerConnection{report: newCandidatePairReport(true)},
+			logCandidatePair: enabled,
+		}
+		s.handleICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	}
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "Selected ICE candidate pair")
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, strings.Count(out.String(), "Selected ICE candidate pair"))
+	assert.Contains(t, out.String(), "[peer1] Selected ICE candidate pair, local: host udp, remote: relay udp")
+	assert.NotContains(t, out.String(), "1.2.3.4", "expected addresses to be omitted")
+}
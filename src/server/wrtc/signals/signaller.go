@@ -1,12 +1,16 @@
 package signals
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/logger"
 	"github.com/jeremija/peer-calls/src/server/wrtc/negotiator"
 	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type PeerConnection interface {
@@ -19,6 +23,7 @@ type PeerConnection interface {
 	CreateOffer(*webrtc.OfferOptions) (webrtc.SessionDescription, error)
 	CreateAnswer(*webrtc.AnswerOptions) (webrtc.SessionDescription, error)
 	OnICEConnectionStateChange(func(webrtc.ICEConnectionState))
+	GetStats() webrtc.StatsReport
 	Close() error
 }
 
@@ -28,15 +33,256 @@ type Signaller struct {
 	initiator      bool
 	localPeerID    string
 	remotePeerID   string
-	onSignal       func(signal interface{})
 	negotiator     *negotiator.Negotiator
+	canPublish     func(kind webrtc.RTPCodecType) bool
+	audioOnly      bool
 	closeChannel   chan struct{}
 	closeOnce      sync.Once
+	onClose        func(reason CloseReason)
+	onError        func(err error)
+	limiter        *negotiator.Limiter
+
+	// Prefix of log lines, the remote peer ID followed by the correlation ID
+	// of the connection when there is one.
+	logID string
+
+	maxSDPSize     int
+	allowCandidate func(address string) bool
+	payloadLimits  PayloadLimits
+
+	maxCandidates    int
+	candidatesMu     sync.Mutex
+	remoteCandidates int
+
+	initialDirection   webrtc.RTPTransceiverDirection
+	requestedDirection webrtc.RTPTransceiverDirection
+
+	logSDP           bool
+	logCandidatePair bool
+	onConnected      func()
+
+	// Last states reported by the peer connection.
+	stateMu         sync.RWMutex
+	connectionState webrtc.ICEConnectionState
+	signalingState  webrtc.SignalingState
+
+	// Guards the codecs of mediaEngine populated from remote offers and read
+	// by Codecs.
+	mediaEngineMu sync.Mutex
+
+	signalMu sync.Mutex
+	onSignal func(signal interface{})
+	// Last local offer or renegotiation request, nil when the remote peer has
+	// responded. It is sent again when the transport is replaced.
+	pendingSignal interface{}
+	// Local candidates gathered before the first local SDP was signaled, sent
+	// right after it so that the remote peer can apply them.
+	pendingCandidates []Payload
+	candidatesReady   bool
+
+	// Time the last local offer was sent, zero when no answer is pending.
+	offerSentAtMu sync.Mutex
+	offerSentAt   time.Time
+
+	disconnectGracePeriod time.Duration
+	disconnectTimerMu     sync.Mutex
+	disconnectTimer       *time.Timer
+
+	negotiationTimeout      time.Duration
+	negotiationTimerMu      sync.Mutex
+	negotiationTimer        *time.Timer
+	negotiationTimerStopped bool
+
+	handleSignal func(payload Payload) error
+
+	maxRetries int
+	retryDelay time.Duration
+	retriesMu  sync.Mutex
+	retries    int
+
+	addTransceivers    func(n int) bool
+	removeTransceivers func(n int)
+	transceiversMu     sync.Mutex
+	transceivers       int
+}
+
+var ErrTooManyTransceivers = fmt.Errorf("Too many transceivers in room")
+
+var ErrSDPTooLarge = fmt.Errorf("SDP too large")
+
+var ErrTooManyCandidates = fmt.Errorf("Too many ICE candidates")
+
+var ErrVideoDisabled = fmt.Errorf("Video is disabled")
+
+var ErrAddTransceiver = fmt.Errorf("Error adding transceiver")
+
+var ErrUnexpectedSignal = fmt.Errorf("Unexpected signal")
+
+// Returned by Signal for signals that are decoded but not handled, because
+// they are of a registered payload type and Params.HandleSignal is not set.
+// Wraps ErrUnexpectedSignal.
+type UnexpectedSignalError struct {
+	PeerID string
+	// Go type of the decoded signal, for example "signals.Renegotiate".
+	Type   string
+	Signal interface{}
+}
+
+func (e *UnexpectedSignalError) Error() string {
+	return fmt.Sprintf("[%s] %s: %#v", e.PeerID, ErrUnexpectedSignal, e.Signal)
+}
+
+func (e *UnexpectedSignalError) Unwrap() error {
+	return ErrUnexpectedSignal
+}
+
+// Describes why a peer connection was closed.
+type CloseReason string
+
+const (
+	// The peer or the server hung up normally.
+	CloseReasonHangUp CloseReason = "hang_up"
+	// ICE failed or did not recover from a disconnect.
+	CloseReasonICEFailed CloseReason = "ice_failed"
+	// The remote peer sent an SDP that could not be accepted.
+	CloseReasonNegotiationFailed CloseReason = "negotiation_failed"
+	// The peer was removed from the room by the server.
+	CloseReasonKicked CloseReason = "kicked"
+	// The server is shutting down.
+	CloseReasonShutdown CloseReason = "shutdown"
+)
+
+const (
+	defaultNegotiationRetries    = 3
+	defaultNegotiationRetryDelay = time.Second
+	defaultMaxSDPSize            = 32 * 1024
+	defaultMaxCandidates         = 100
+)
+
+type Params struct {
+	// Decides whether the remote peer can publish tracks of a kind. Requested
+	// transceivers for kinds that cannot be published are added as sendonly
+	// so the remote peer can still receive. Everything is allowed when nil.
+	CanPublish func(kind webrtc.RTPCodecType) bool
+	// Number of times renegotiation is requested after failing to apply a
+	// remote offer. Defaults to 3.
+	NegotiationRetries int
+	// Delay before renegotiation is requested. Defaults to 1 second.
+	NegotiationRetryDelay time.Duration
+	// Reserves transceivers in the room of the remote peer. Returns false when
+	// the room limit has been reached. No limit is enforced when nil.
+	AddTransceivers func(n int) bool
+	// Releases transceivers reserved with AddTransceivers when the signaller
+	// is closed.
+	RemoveTransceivers func(n int)
+	// Maximum size of a remote SDP in bytes. Larger SDPs are rejected and the
+	// peer connection is closed. Defaults to 32 KiB, the default websocket
+	// read limit, which larger SDPs could not pass anyway.
+	MaxSDPSize int
+	// Maximum number of ICE candidates accepted from the remote peer over the
+	// lifetime of the peer connection. Further candidates are dropped, and
+	// the peer connection is closed once the remote peer sent more than twice
+	// as many. Defaults to 100.
+	MaxCandidates int
+	// Direction of the audio and video transceivers added when the signaller
+	// is created. Defaults to recvonly, so that the remote peer can publish
+	// without renegotiating.
+	InitialDirection webrtc.RTPTransceiverDirection
+	// Direction of transceivers added when the remote peer requests them.
+	// Transceivers for kinds that cannot be published are sendonly regardless.
+	// Defaults to sendrecv.
+	RequestedDirection webrtc.RTPTransceiverDirection
+	// Bounds of signal payloads received from the remote peer. Larger
+	// payloads are rejected before they are decoded.
+	PayloadLimits PayloadLimits
+	// Decides whether an ICE candidate with an IP address or hostname is
+	// used. Denied local candidates are removed from SDPs before they are
+	// signaled and denied remote candidates are ignored. Everything is allowed
+	// when nil.
+	AllowCandidate func(address string) bool
+	// Time to wait for ICE to recover after the connection becomes
+	// disconnected before the peer connection is closed. Closes immediately
+	// when zero.
+	DisconnectGracePeriod time.Duration
+	// Maximum number of negotiations started within MaxNegotiationsWindow.
+	// Further negotiations are postponed instead of closing the peer
+	// connection. Unlimited when zero.
+	MaxNegotiations int
+	// Rolling window for MaxNegotiations. Defaults to one minute.
+	MaxNegotiationsWindow time.Duration
+	// Maximum number of transceiver requests queued until the next
+	// negotiation. Unlimited when zero.
+	MaxQueuedTransceivers int
+	// Decides what happens to transceiver requests when the queue is full.
+	// With negotiator.OverflowPolicyClose the peer connection is closed.
+	TransceiverOverflowPolicy negotiator.OverflowPolicy
+	// Maximum time spent in the have-local-offer or have-remote-offer
+	// signaling states before the negotiation is considered stuck and the
+	// peer connection is closed. Disabled when zero.
+	NegotiationTimeout time.Duration
+	// Handles signals of types registered with RegisterPayloadType. Signals
+	// that are not built in are rejected when nil.
+	HandleSignal func(payload Payload) error
+	// Skips the video transceiver added during initialization and refuses
+	// requested video transceivers with ErrVideoDisabled, so that only audio
+	// is negotiated.
+	AudioOnly bool
+	// Called once with the reason before the peer connection is closed, so
+	// that the remote peer can be notified.
+	OnClose func(reason CloseReason)
+	// Called with errors that happen outside of Signal so that the remote peer
+	// can be notified, e.g. when a requested transceiver wraps
+	// ErrAddTransceiver because it could not be added.
+	OnError func(err error)
+	// Limits the number of offers and answers created concurrently across
+	// all signallers. Not limited when nil.
+	NegotiationLimiter *negotiator.Limiter
+	// Signals local candidates as they are gathered. The peer connection must
+	// be created with trickle enabled in its setting engine. When false, the
+	// candidates are only included in the SDP.
+	Trickle bool
+	// Logs SDPs and whole candidates to the sdp logger. They are never
+	// written when false, even when the sdp logger is enabled, because they
+	// contain the network addresses of the peers.
+	LogSDP bool
+	// Logs the types of the local and remote candidates of the selected ICE
+	// candidate pair once connected, e.g. to tell whether the connection is
+	// relayed through TURN.
+	LogCandidatePair bool
+	// Called every time ICE connects, after the local candidates have been
+	// gathered, e.g. to configure their sockets. Optional.
+	OnConnected func()
+	// Context of the websocket connection of the remote peer. Its correlation
+	// ID, set with logger.WithCorrelationID, is added to log lines. Optional.
+	Context context.Context
+}
+
+// Returns the prefix of log lines of a signaller, the remote peer ID followed
+// by the correlation ID of the connection when ctx has one.
+func newLogID(remotePeerID string, ctx context.Context) string {
+	if correlationID := logger.CorrelationID(ctx); correlationID != "" {
+		return remotePeerID + " " + correlationID
+	}
+	return remotePeerID
 }
 
 var log = logger.GetLogger("signals")
 var sdpLog = logger.GetLogger("sdp")
 
+// Candidates are signaled in bursts during every negotiation.
+var candidateLog = logger.NewDedupLogger(log, 10*time.Second)
+
+var (
+	localOfferDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "peercalls_sdp_local_offer_duration_seconds",
+		Help: "Time from sending a local offer to receiving the remote answer",
+	})
+	remoteOfferDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "peercalls_sdp_remote_offer_duration_seconds",
+		Help: "Time from receiving a remote offer to sending the answer",
+	})
+)
+
 func NewSignaller(
 	initiator bool,
 	peerConnection PeerConnection,
@@ -44,6 +290,26 @@ func NewSignaller(
 	localPeerID string,
 	remotePeerID string,
 	onSignal func(signal interface{}),
+) (*Signaller, error) {
+	return NewSignallerWithParams(
+		initiator,
+		peerConnection,
+		mediaEngine,
+		localPeerID,
+		remotePeerID,
+		onSignal,
+		Params{},
+	)
+}
+
+func NewSignallerWithParams(
+	initiator bool,
+	peerConnection PeerConnection,
+	mediaEngine *webrtc.MediaEngine,
+	localPeerID string,
+	remotePeerID string,
+	onSignal func(signal interface{}),
+	params Params,
 ) (*Signaller, error) {
 	s := &Signaller{
 		initiator:      initiator,
@@ -51,24 +317,92 @@ func NewSignaller(
 		mediaEngine:    mediaEngine,
 		localPeerID:    localPeerID,
 		remotePeerID:   remotePeerID,
+		logID:          newLogID(remotePeerID, params.Context),
 		onSignal:       onSignal,
+		canPublish:     params.CanPublish,
+		audioOnly:      params.AudioOnly,
 		closeChannel:   make(chan struct{}),
+		onClose:        params.OnClose,
+		onError:        params.OnError,
+		limiter:        params.NegotiationLimiter,
+		maxRetries:     params.NegotiationRetries,
+		retryDelay:     params.NegotiationRetryDelay,
+		maxSDPSize:     params.MaxSDPSize,
+		allowCandidate: params.AllowCandidate,
+		payloadLimits:  params.PayloadLimits,
+		handleSignal:   params.HandleSignal,
+
+		maxCandidates: params.MaxCandidates,
+
+		connectionState: webrtc.ICEConnectionStateNew,
+		signalingState:  webrtc.SignalingStateStable,
+
+		disconnectGracePeriod: params.DisconnectGracePeriod,
+		negotiationTimeout:    params.NegotiationTimeout,
+
+		initialDirection:   params.InitialDirection,
+		requestedDirection: params.RequestedDirection,
+
+		logSDP:           params.LogSDP,
+		logCandidatePair: params.LogCandidatePair,
+		onConnected:      params.OnConnected,
+
+		addTransceivers:    params.AddTransceivers,
+		removeTransceivers: params.RemoveTransceivers,
+	}
+
+	if s.maxRetries == 0 {
+		s.maxRetries = defaultNegotiationRetries
+	}
+	if s.retryDelay == 0 {
+		s.retryDelay = defaultNegotiationRetryDelay
+	}
+	if s.maxSDPSize == 0 {
+		s.maxSDPSize = defaultMaxSDPSize
+	}
+	if s.maxCandidates == 0 {
+		s.maxCandidates = defaultMaxCandidates
+	}
+	if s.initialDirection == webrtc.RTPTransceiverDirection(webrtc.Unknown) {
+		s.initialDirection = webrtc.RTPTransceiverDirectionRecvonly
+	}
+	if s.requestedDirection == webrtc.RTPTransceiverDirection(webrtc.Unknown) {
+		s.requestedDirection = webrtc.RTPTransceiverDirectionSendrecv
 	}
 
-	negotiator := negotiator.NewNegotiator(
+	negotiator := negotiator.NewNegotiatorWithParams(
 		initiator,
 		peerConnection,
-		s.remotePeerID,
+		s.logID,
 		s.handleLocalOffer,
 		s.handleLocalRequestNegotiation,
+		negotiator.Params{
+			MaxNegotiations:       params.MaxNegotiations,
+			MaxNegotiationsWindow: params.MaxNegotiationsWindow,
+
+			OnSignalingStateChange: s.handleSignalingStateChange,
+			OnTransceiverError:     s.handleTransceiverError,
+			Limiter:                params.NegotiationLimiter,
+
+			MaxQueuedTransceivers: params.MaxQueuedTransceivers,
+			OverflowPolicy:        params.TransceiverOverflowPolicy,
+			OnQueueOverflow:       s.handleTransceiverQueueOverflow,
+		},
 	)
 
 	s.negotiator = negotiator
 
 	peerConnection.OnICEConnectionStateChange(s.handleICEConnectionStateChange)
-	// peerConnection.OnICECandidate(s.handleICECandidate)
+	if params.Trickle {
+		peerConnection.OnICECandidate(s.handleICECandidate)
+	}
+
+	if err := s.initialize(); err != nil {
+		s.releaseTransceivers()
+		return s, err
+	}
 
-	return s, s.initialize()
+	return s, nil
 }
 
 // This does not close any channel, but returns a channel that can be used
@@ -77,37 +411,85 @@ func (s *Signaller) CloseChannel() <-chan struct{} {
 	return s.closeChannel
 }
 
+// Reserves transceivers for this peer. Always succeeds when no limit is
+// configured.
+func (s *Signaller) reserveTransceivers(n int) bool {
+	s.transceiversMu.Lock()
+	defer s.transceiversMu.Unlock()
+
+	if s.addTransceivers != nil && !s.addTransceivers(n) {
+		return false
+	}
+	s.transceivers += n
+	return true
+}
+
+func (s *Signaller) releaseTransceivers() {
+	s.transceiversMu.Lock()
+	defer s.transceiversMu.Unlock()
+
+	if s.removeTransceivers != nil && s.transceivers > 0 {
+		s.removeTransceivers(s.transceivers)
+	}
+	s.transceivers = 0
+}
+
+// Releases transceivers reserved for transceivers that were not added.
+func (s *Signaller) unreserveTransceivers(n int) {
+	s.transceiversMu.Lock()
+	defer s.transceiversMu.Unlock()
+
+	if n > s.transceivers {
+		n = s.transceivers
+	}
+	if s.removeTransceivers != nil && n > 0 {
+		s.removeTransceivers(n)
+	}
+	s.transceivers -= n
+}
+
 func (s *Signaller) initialize() error {
+	// one video and one audio transceiver are always added, unless video is
+	// disabled
+	transceivers := 2
+	if s.audioOnly {
+		transceivers = 1
+	}
+	if !s.reserveTransceivers(transceivers) {
+		return fmt.Errorf("[%s] NewSignaller: %w", s.logID, ErrTooManyTransceivers)
+	}
+
 	if s.initiator {
-		log.Printf("[%s] NewSignaller: Initiator registering default codecs", s.remotePeerID)
+		log.Printf("[%s] NewSignaller: Initiator registering default codecs", s.logID)
 		s.mediaEngine.RegisterDefaultCodecs()
 	}
 
-	log.Printf("[%s] NewSignaller: Non-Initiator pre-add video transceiver", s.remotePeerID)
-	_, err := s.peerConnection.AddTransceiverFromKind(
-		webrtc.RTPCodecTypeVideo,
-		webrtc.RtpTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionRecvonly,
-		},
-	)
-	if err != nil {
-		log.Printf("ERROR: %s", err)
-		return fmt.Errorf("[%s] NewSignaller: Error pre-adding video transceiver: %s", s.remotePeerID, err)
+	if !s.audioOnly {
+		log.Printf("[%s] NewSignaller: pre-add %s video transceiver", s.logID, s.initialDirection)
+		_, err := s.peerConnection.AddTransceiverFromKind(
+			webrtc.RTPCodecTypeVideo,
+			webrtc.RtpTransceiverInit{
+				Direction: s.initialDirection,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("[%s] NewSignaller: Error pre-adding video transceiver: %s", s.logID, err)
+		}
 	}
 
-	log.Printf("[%s] NewSignaller: Non-Initiator pre-add audio transceiver", s.remotePeerID)
-	_, err = s.peerConnection.AddTransceiverFromKind(
+	log.Printf("[%s] NewSignaller: pre-add %s audio transceiver", s.logID, s.initialDirection)
+	_, err := s.peerConnection.AddTransceiverFromKind(
 		webrtc.RTPCodecTypeAudio,
 		webrtc.RtpTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			Direction: s.initialDirection,
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("[%s] NewSignaller: Error pre-adding audio transceiver: %s", s.remotePeerID, err)
+		return fmt.Errorf("[%s] NewSignaller: Error pre-adding audio transceiver: %s", s.logID, err)
 	}
 
 	if s.initiator {
-		log.Printf("[%s] NewSignaller: Initiator calling Negotiate()", s.remotePeerID)
+		log.Printf("[%s] NewSignaller: Initiator calling Negotiate()", s.logID)
 		s.negotiator.Negotiate()
 	}
 
@@ -118,27 +500,215 @@ func (s *Signaller) Initiator() bool {
 	return s.initiator
 }
 
+// Returns the codecs of kind negotiated with the remote peer so far.
+func (s *Signaller) Codecs(kind webrtc.RTPCodecType) []*webrtc.RTPCodec {
+	s.mediaEngineMu.Lock()
+	defer s.mediaEngineMu.Unlock()
+	return s.mediaEngine.GetCodecsByKind(kind)
+}
+
+// Returns the last ICE connection state of the peer connection. The state is
+// closed once the signaller is closed.
+func (s *Signaller) ConnectionState() webrtc.ICEConnectionState {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.connectionState
+}
+
+// Returns the last signaling state of the peer connection.
+func (s *Signaller) SignalingState() webrtc.SignalingState {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	return s.signalingState
+}
+
 func (s *Signaller) handleICEConnectionStateChange(connectionState webrtc.ICEConnectionState) {
-	log.Printf("[%s] Peer connection state changed: %s", s.remotePeerID, connectionState.String())
-	if connectionState == webrtc.ICEConnectionStateClosed ||
-		connectionState == webrtc.ICEConnectionStateDisconnected ||
-		connectionState == webrtc.ICEConnectionStateFailed {
-		s.Close()
+	log.Printf("[%s] Peer connection state changed: %s", s.logID, connectionState.String())
+	s.stateMu.Lock()
+	s.connectionState = connectionState
+	s.stateMu.Unlock()
+	switch connectionState {
+	case webrtc.ICEConnectionStateClosed, webrtc.ICEConnectionStateFailed:
+		s.CloseWithReason(CloseReasonICEFailed)
+	case webrtc.ICEConnectionStateDisconnected:
+		if s.disconnectGracePeriod <= 0 {
+			s.CloseWithReason(CloseReasonICEFailed)
+			return
+		}
+		s.startDisconnectTimer()
+	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		s.stopDisconnectTimer()
+		if connectionState == webrtc.ICEConnectionStateConnected && s.logCandidatePair {
+			// stats are collected from the ICE agent, which is reporting the
+			// state change
+			go s.logSelectedCandidatePair()
+		}
+		if connectionState == webrtc.ICEConnectionStateConnected && s.onConnected != nil {
+			// the ICE agent cannot be used while it reports the state change
+			go s.onConnected()
+		}
 	}
+}
 
+func (s *Signaller) handleSignalingStateChange(state webrtc.SignalingState) {
+	s.stateMu.Lock()
+	s.signalingState = state
+	s.stateMu.Unlock()
+	switch state {
+	case webrtc.SignalingStateHaveLocalOffer, webrtc.SignalingStateHaveRemoteOffer:
+		s.startNegotiationTimer(state)
+	default:
+		s.stopNegotiationTimer()
+	}
 }
 
-func (s *Signaller) Close() (err error) {
+// Closes the peer connection unless the signaling state changes before the
+// negotiation timeout.
+func (s *Signaller) startNegotiationTimer(state webrtc.SignalingState) {
+	if s.negotiationTimeout <= 0 {
+		return
+	}
+
+	s.negotiationTimerMu.Lock()
+	defer s.negotiationTimerMu.Unlock()
+
+	if s.negotiationTimerStopped {
+		return
+	}
+	if s.negotiationTimer != nil {
+		s.negotiationTimer.Stop()
+	}
+	s.negotiationTimer = time.AfterFunc(s.negotiationTimeout, func() {
+		log.Printf("[%s] Negotiation stuck in signaling state: %s for %s, closing",
+			s.logID, state, s.negotiationTimeout)
+		s.CloseWithReason(CloseReasonNegotiationFailed)
+	})
+}
+
+func (s *Signaller) stopNegotiationTimer() {
+	s.negotiationTimerMu.Lock()
+	defer s.negotiationTimerMu.Unlock()
+
+	if s.negotiationTimer != nil {
+		s.negotiationTimer.Stop()
+		s.negotiationTimer = nil
+	}
+}
+
+// Closes the peer connection unless ICE recovers within the grace period.
+func (s *Signaller) startDisconnectTimer() {
+	s.disconnectTimerMu.Lock()
+	defer s.disconnectTimerMu.Unlock()
+
+	if s.disconnectTimer != nil {
+		return
+	}
+	log.Printf("[%s] Waiting %s for the connection to recover", s.logID, s.disconnectGracePeriod)
+	s.disconnectTimer = time.AfterFunc(s.disconnectGracePeriod, func() {
+		log.Printf("[%s] Connection did not recover, closing", s.logID)
+		s.CloseWithReason(CloseReasonICEFailed)
+	})
+}
+
+func (s *Signaller) stopDisconnectTimer() {
+	s.disconnectTimerMu.Lock()
+	defer s.disconnectTimerMu.Unlock()
+
+	if s.disconnectTimer != nil {
+		s.disconnectTimer.Stop()
+		s.disconnectTimer = nil
+	}
+}
+
+// Closes the peer connection with CloseReasonHangUp.
+func (s *Signaller) Close() error {
+	return s.CloseWithReason(CloseReasonHangUp)
+}
+
+// Closes the peer connection. Only the reason of the first call is passed to
+// Params.OnClose, subsequent calls do nothing.
+func (s *Signaller) CloseWithReason(reason CloseReason) (err error) {
 	s.closeOnce.Do(func() {
+		log.Printf("[%s] Closing peer connection, reason: %s", s.logID, reason)
+		if s.onClose != nil {
+			s.onClose(reason)
+		}
+		s.stopDisconnectTimer()
+		s.negotiationTimerMu.Lock()
+		s.negotiationTimerStopped = true
+		s.negotiationTimerMu.Unlock()
+		s.stopNegotiationTimer()
+		s.negotiator.Close()
 		// TODO see if this is a race condition
 		err = s.peerConnection.Close()
+		s.releaseTransceivers()
+		s.stateMu.Lock()
+		s.connectionState = webrtc.ICEConnectionStateClosed
+		s.signalingState = webrtc.SignalingStateClosed
+		s.stateMu.Unlock()
 		close(s.closeChannel)
 	})
 	return
 }
 
+func (s *Signaller) signal(payload interface{}) {
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+	s.onSignal(payload)
+}
+
+// Sends a signal that the remote peer needs to respond to.
+func (s *Signaller) signalPending(payload interface{}) {
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+	s.pendingSignal = payload
+	s.onSignal(payload)
+}
+
+func (s *Signaller) clearPendingSignal() {
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+	s.pendingSignal = nil
+}
+
+// Sends a local SDP followed by the candidates gathered before it.
+func (s *Signaller) signalSDP(payload Payload, pending bool) {
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+
+	if pending {
+		s.pendingSignal = payload
+	}
+	s.onSignal(payload)
+
+	if !s.candidatesReady {
+		s.candidatesReady = true
+		for _, candidate := range s.pendingCandidates {
+			s.onSignal(candidate)
+		}
+		s.pendingCandidates = nil
+	}
+}
+
+// Replaces the callback used to send signals to the remote peer, for example
+// after the peer has reconnected using a new websocket. A local offer or a
+// renegotiation request that the remote peer has not responded to is sent
+// again because it might have been lost with the previous transport. Remote
+// offers that were answered before the transport was replaced need to be
+// sent again by the remote peer if the answer did not arrive.
+func (s *Signaller) Rebind(onSignal func(signal interface{})) {
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+
+	s.onSignal = onSignal
+	if s.pendingSignal != nil {
+		log.Printf("[%s] Rebind: sending pending signal again", s.logID)
+		s.onSignal(s.pendingSignal)
+	}
+}
+
 func (s *Signaller) handleICECandidate(c *webrtc.ICECandidate) {
-	if c == nil {
+	if c == nil || !s.isCandidateAllowed(c.ToJSON().Candidate) {
 		return
 	}
 
@@ -149,111 +719,304 @@ func (s *Signaller) handleICECandidate(c *webrtc.ICECandidate) {
 		},
 	}
 
-	log.Printf("[%s] Got ice candidate from server peer: %s", payload, s.remotePeerID)
+	s.logCandidate("Local", c.ToJSON().Candidate)
+
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+
+	// gathering starts when the local description is set, before the SDP
+	// is signaled
+	if !s.candidatesReady {
+		s.pendingCandidates = append(s.pendingCandidates, payload)
+		return
+	}
 	s.onSignal(payload)
 }
 
+// Logs a summary of a local or remote candidate, prefixed by the remote peer
+// ID. The whole candidate is only logged to the sdp logger when LogSDP is
+// set.
+func (s *Signaller) logCandidate(source string, candidate string) {
+	candidateLog.Printf("[%s] %s signal.candidate: %s", s.logID, source, candidateSummary(candidate))
+	s.sdpLogf("[%s] %s signal.candidate: %s", s.logID, source, candidate)
+}
+
+// Logs the types of the selected candidate pair. Their addresses are only
+// logged to the sdp logger when LogSDP is set.
+func (s *Signaller) logSelectedCandidatePair() {
+	local, remote, ok := selectedCandidatePair(s.peerConnection.GetStats())
+	if !ok {
+		log.Printf("[%s] No ICE candidate pair selected", s.logID)
+		return
+	}
+	log.Printf("[%s] Selected ICE candidate pair, local: %s, remote: %s",
+		s.logID, candidateStatsSummary(local), candidateStatsSummary(remote))
+	s.sdpLogf("[%s] Selected ICE candidate pair, local: %s:%d, remote: %s:%d",
+		s.logID, local.IP, local.Port, remote.IP, remote.Port)
+}
+
+func (s *Signaller) sdpLogf(message string, values ...interface{}) {
+	if s.logSDP {
+		sdpLog.Printf(message, values...)
+	}
+}
+
 func (s *Signaller) Signal(payload map[string]interface{}) error {
-	signalPayload, err := NewPayloadFromMap(payload)
+	signalPayload, err := NewPayloadFromMapWithLimits(payload, s.payloadLimits)
 
 	if err != nil {
-		return fmt.Errorf("Error constructing signal from payload: %s", err)
+		return fmt.Errorf("[%s] Error constructing signal from payload: %w", s.logID, err)
 	}
 
 	switch signal := signalPayload.Signal.(type) {
 	case Candidate:
-		log.Printf("[%s] Remote signal.canidate: %s ", signal.Candidate, s.remotePeerID)
+		if ok, err := s.acceptRemoteCandidate(); !ok {
+			return err
+		}
+		s.logCandidate("Remote", signal.Candidate.Candidate)
+		if !s.isCandidateAllowed(signal.Candidate.Candidate) {
+			log.Printf("[%s] Ignoring remote candidate: %s", s.logID, signal.Candidate.Candidate)
+			return nil
+		}
 		return s.peerConnection.AddICECandidate(signal.Candidate)
 	case Renegotiate:
-		log.Printf("[%s] Remote signal.renegotiate ", s.remotePeerID)
-		log.Printf("[%s] Calling signaller.Negotiate() because remote peer wanted to negotiate", s.remotePeerID)
+		log.Printf("[%s] Remote signal.renegotiate ", s.logID)
+		log.Printf("[%s] Calling signaller.Negotiate() because remote peer wanted to negotiate", s.logID)
 		s.Negotiate()
 		return nil
 	case TransceiverRequest:
-		log.Printf("[%s] Remote signal.transceiverRequest: %s", s.remotePeerID, signal.TransceiverRequest.Kind)
+		log.Printf("[%s] Remote signal.transceiverRequest: %s", s.logID, signal.TransceiverRequest.Kind)
 		s.handleTransceiverRequest(signal)
 		return nil
 	case webrtc.SessionDescription:
-		sdpLog.Printf("[%s] Remote signal.type: %s, signal.sdp: %s", s.remotePeerID, signal.Type, signal.SDP)
+		s.sdpLogf("[%s] Remote signal.type: %s, signal.sdp: %s", s.logID, signal.Type, signal.SDP)
 		return s.handleRemoteSDP(signal)
 	default:
-		return fmt.Errorf("[%s] Unexpected signal: %#v ", s.remotePeerID, signal)
+		if s.handleSignal != nil {
+			return s.handleSignal(signalPayload)
+		}
+		return &UnexpectedSignalError{
+			PeerID: s.remotePeerID,
+			Type:   fmt.Sprintf("%T", signal),
+			Signal: signal,
+		}
 	}
 }
 
+// Counts a remote candidate and returns false when it is over the limit and
+// should be dropped. Returns ErrTooManyCandidates after closing the peer
+// connection when the remote peer sent more than twice the limit.
+func (s *Signaller) acceptRemoteCandidate() (bool, error) {
+	s.candidatesMu.Lock()
+	s.remoteCandidates++
+	count := s.remoteCandidates
+	s.candidatesMu.Unlock()
+
+	if count <= s.maxCandidates {
+		return true, nil
+	}
+	if count > 2*s.maxCandidates {
+		if closeErr := s.CloseWithReason(CloseReasonNegotiationFailed); closeErr != nil {
+			log.Printf("[%s] Error closing peer connection after receiving too many candidates: %s", s.logID, closeErr)
+		}
+		return false, fmt.Errorf("[%s] Received %d candidates, limit is %d: %w", s.logID, count, s.maxCandidates, ErrTooManyCandidates)
+	}
+	candidateLog.Printf("[%s] Dropping remote candidate over the limit of %d", s.logID, s.maxCandidates)
+	return false, nil
+}
+
 func (s *Signaller) handleTransceiverRequest(transceiverRequest TransceiverRequest) {
-	log.Printf("[%s] handleTransceiverRequest: %v", s.remotePeerID, transceiverRequest)
+	log.Printf("[%s] handleTransceiverRequest: %v", s.logID, transceiverRequest)
 
 	codecType := transceiverRequest.TransceiverRequest.Kind
 
+	if s.audioOnly && codecType == webrtc.RTPCodecTypeVideo {
+		err := fmt.Errorf("[%s] ignoring %s transceiver request: %w", s.logID, codecType, ErrVideoDisabled)
+		log.Printf("handleTransceiverRequest: %s", err)
+		if s.onError != nil {
+			s.onError(err)
+		}
+		return
+	}
+
+	if !s.reserveTransceivers(1) {
+		err := fmt.Errorf("[%s] ignoring %s transceiver request: %w", s.logID, codecType, ErrTooManyTransceivers)
+		log.Printf("handleTransceiverRequest: %s", err)
+		if s.onError != nil {
+			s.onError(err)
+		}
+		return
+	}
+
+	direction := s.requestedDirection
+	if s.canPublish != nil && !s.canPublish(codecType) {
+		log.Printf("[%s] handleTransceiverRequest: not allowed to publish %s, adding sendonly transceiver", s.logID, codecType)
+		direction = webrtc.RTPTransceiverDirectionSendonly
+	}
+
 	s.negotiator.AddTransceiverFromKind(negotiator.TransceiverRequest{
 		CodecType: codecType,
 		Init: webrtc.RtpTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionSendrecv,
+			Direction: direction,
 		},
 	})
 }
 
+// Handles a transceiver requested by the remote peer that could not be added
+// when the negotiation started.
+func (s *Signaller) handleTransceiverError(t negotiator.TransceiverRequest, err error) {
+	s.unreserveTransceivers(1)
+
+	err = fmt.Errorf("[%s] %w: %s: %s", s.logID, ErrAddTransceiver, t.CodecType, err)
+	log.Printf("%s", err)
+
+	if s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// Closes the peer connection of a remote peer that requested more
+// transceivers than can be queued.
+func (s *Signaller) handleTransceiverQueueOverflow() {
+	log.Printf("[%s] Closing peer connection: %s", s.logID, negotiator.ErrTransceiverQueueFull)
+	if err := s.CloseWithReason(CloseReasonNegotiationFailed); err != nil {
+		log.Printf("[%s] Error closing peer connection after transceiver queue overflow: %s", s.logID, err)
+	}
+}
+
 func (s *Signaller) handleRemoteSDP(sessionDescription webrtc.SessionDescription) (err error) {
+	if size := len(sessionDescription.SDP); size > s.maxSDPSize {
+		if closeErr := s.CloseWithReason(CloseReasonNegotiationFailed); closeErr != nil {
+			log.Printf("[%s] Error closing peer connection after receiving a large SDP: %s", s.logID, closeErr)
+		}
+		return fmt.Errorf("[%s] Rejected %s of %d bytes, limit is %d: %w", s.logID, sessionDescription.Type, size, s.maxSDPSize, ErrSDPTooLarge)
+	}
+
 	switch sessionDescription.Type {
 	case webrtc.SDPTypeOffer:
 		return s.handleRemoteOffer(sessionDescription)
 	case webrtc.SDPTypeAnswer:
 		return s.handleRemoteAnswer(sessionDescription)
 	default:
-		return fmt.Errorf("[%s] Unexpected sdp type: %s", s.remotePeerID, sessionDescription.Type)
+		return fmt.Errorf("[%s] Unexpected sdp type: %s", s.logID, sessionDescription.Type)
 	}
 }
 
 func (s *Signaller) handleRemoteOffer(sessionDescription webrtc.SessionDescription) (err error) {
-	if err = s.mediaEngine.PopulateFromSDP(sessionDescription); err != nil {
-		return fmt.Errorf("[%s] Error populating codec info from SDP: %s", s.remotePeerID, err)
+	start := time.Now()
+
+	s.mediaEngineMu.Lock()
+	err = s.mediaEngine.PopulateFromSDP(sessionDescription)
+	s.mediaEngineMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("[%s] Error populating codec info from SDP: %s", s.logID, err)
 	}
 
+	s.limiter.Acquire()
+	defer s.limiter.Release()
+
 	if err = s.peerConnection.SetRemoteDescription(sessionDescription); err != nil {
-		return fmt.Errorf("[%s] Error setting remote description: %w", s.remotePeerID, err)
+		s.retryNegotiation()
+		return fmt.Errorf("[%s] Error setting remote description: %w", s.logID, err)
 	}
+	// a remote offer is the response to a renegotiation request
+	s.clearPendingSignal()
 	answer, err := s.peerConnection.CreateAnswer(nil)
 	if err != nil {
-		return fmt.Errorf("[%s] Error creating answer: %w", s.remotePeerID, err)
+		s.retryNegotiation()
+		return fmt.Errorf("[%s] Error creating answer: %w", s.logID, err)
 	}
+	s.resetNegotiationRetries()
 	if err := s.peerConnection.SetLocalDescription(answer); err != nil {
-		return fmt.Errorf("[%s] Error setting local description: %w", s.remotePeerID, err)
+		return fmt.Errorf("[%s] Error setting local description: %w", s.logID, err)
 	}
 
-	sdpLog.Printf("[%s] Local signal.type: %s, signal.sdp: %s", s.remotePeerID, answer.Type, answer.SDP)
-	s.onSignal(NewPayloadSDP(s.localPeerID, answer))
+	s.sdpLogf("[%s] Local signal.type: %s, signal.sdp: %s", s.logID, answer.Type, answer.SDP)
+	s.signalSDP(NewPayloadSDP(s.localPeerID, s.filterCandidates(answer)), false)
+	remoteOfferDuration.Observe(time.Since(start).Seconds())
 	return nil
 }
 
+// Requests a new offer from the remote peer after a delay, so that a call
+// does not remain half-negotiated after a remote offer could not be applied.
+func (s *Signaller) retryNegotiation() {
+	s.retriesMu.Lock()
+	if s.retries >= s.maxRetries {
+		s.retriesMu.Unlock()
+		log.Printf("[%s] Not requesting renegotiation, gave up after %d retries", s.logID, s.maxRetries)
+		return
+	}
+	s.retries++
+	retry := s.retries
+	s.retriesMu.Unlock()
+
+	log.Printf("[%s] Requesting renegotiation in %s (retry %d/%d)", s.logID, s.retryDelay, retry, s.maxRetries)
+	time.AfterFunc(s.retryDelay, func() {
+		select {
+		case <-s.closeChannel:
+			return
+		default:
+			s.handleLocalRequestNegotiation()
+		}
+	})
+}
+
+func (s *Signaller) resetNegotiationRetries() {
+	s.retriesMu.Lock()
+	s.retries = 0
+	s.retriesMu.Unlock()
+}
+
 func (s *Signaller) handleLocalRequestNegotiation() {
-	log.Printf("[%s] Sending renegotiation request to initiator", s.remotePeerID)
-	s.onSignal(NewPayloadRenegotiate(s.localPeerID))
+	log.Printf("[%s] Sending renegotiation request to initiator", s.logID)
+	s.signalPending(NewPayloadRenegotiate(s.localPeerID))
 }
 
 func (s *Signaller) handleLocalOffer(offer webrtc.SessionDescription, err error) {
-	sdpLog.Printf("[%s] Local signal.type: %s, signal.sdp: %s", s.remotePeerID, offer.Type, offer.SDP)
+	s.sdpLogf("[%s] Local signal.type: %s, signal.sdp: %s", s.logID, offer.Type, offer.SDP)
 	if err != nil {
-		log.Printf("[%s] Error creating local offer: %s", s.remotePeerID, err)
+		log.Printf("[%s] Error creating local offer: %s", s.logID, err)
 		// TODO abort connection
 		return
 	}
 
 	err = s.peerConnection.SetLocalDescription(offer)
 	if err != nil {
-		log.Printf("[%s] Error setting local description from local offer: %s", s.remotePeerID, err)
+		log.Printf("[%s] Error setting local description from local offer: %s", s.logID, err)
 		// TODO abort connection
 		return
 	}
 
-	s.onSignal(NewPayloadSDP(s.localPeerID, offer))
+	s.offerSentAtMu.Lock()
+	s.offerSentAt = time.Now()
+	s.offerSentAtMu.Unlock()
+
+	s.signalSDP(NewPayloadSDP(s.localPeerID, s.filterCandidates(offer)), true)
+}
+
+func (s *Signaller) isCandidateAllowed(candidate string) bool {
+	if s.allowCandidate == nil {
+		return true
+	}
+	address, ok := candidateAddress(candidate)
+	return !ok || s.allowCandidate(address)
+}
+
+// Returns a copy of a local session description without the candidates that
+// are not allowed.
+func (s *Signaller) filterCandidates(sessionDescription webrtc.SessionDescription) webrtc.SessionDescription {
+	if s.allowCandidate == nil {
+		return sessionDescription
+	}
+	sessionDescription.SDP = filterSDPCandidates(sessionDescription.SDP, s.allowCandidate)
+	return sessionDescription
 }
 
 // Sends a request for a new transceiver, only if the peer is not the initiator.
 func (s *Signaller) SendTransceiverRequest(kind webrtc.RTPCodecType, direction webrtc.RTPTransceiverDirection) {
 	if !s.initiator {
-		log.Printf("[%s] Sending transceiver request to initiator", s.remotePeerID)
-		s.onSignal(NewTransceiverRequest(s.localPeerID, kind, direction))
+		log.Printf("[%s] Sending transceiver request to initiator", s.logID)
+		s.signal(NewTransceiverRequest(s.localPeerID, kind, direction))
 	}
 }
 
@@ -266,7 +1029,16 @@ func (s *Signaller) Negotiate() {
 
 func (s *Signaller) handleRemoteAnswer(sessionDescription webrtc.SessionDescription) (err error) {
 	if err = s.peerConnection.SetRemoteDescription(sessionDescription); err != nil {
-		return fmt.Errorf("[%s] Error setting remote description: %w", s.remotePeerID, err)
+		return fmt.Errorf("[%s] Error setting remote description: %w", s.logID, err)
 	}
+	s.clearPendingSignal()
+
+	s.offerSentAtMu.Lock()
+	if !s.offerSentAt.IsZero() {
+		localOfferDuration.Observe(time.Since(s.offerSentAt).Seconds())
+		s.offerSentAt = time.Time{}
+	}
+	s.offerSentAtMu.Unlock()
+
 	return nil
 }
@@ -0,0 +1,1096 @@
+package signals_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type transceiver struct {
+	kind      webrtc.RTPCodecType
+	direction webrtc.RTPTransceiverDirection
+}
+
+type mockPeerConnection struct {
+	transceivers               []transceiver
+	addTransceiverErr          error
+	onSignalingStateChange     func(webrtc.SignalingState)
+	onICEConnectionStateChange func(webrtc.ICEConnectionState)
+	setRemoteDescriptionErrs   []error
+	remoteDescriptions         int
+	closed                     bool
+	localSDP                   string
+	candidates                 []webrtc.ICECandidateInit
+	onICECandidate             func(*webrtc.ICECandidate)
+	// Passed to the OnICECandidate handler when the local description is
+	// set, like gathering does.
+	gatheredCandidates []*webrtc.ICECandidate
+}
+
+func (p *mockPeerConnection) OnICECandidate(fn func(*webrtc.ICECandidate)) {
+	p.onICECandidate = fn
+}
+
+func (p *mockPeerConnection) OnSignalingStateChange(fn func(webrtc.SignalingState)) {
+	p.onSignalingStateChange = fn
+}
+
+func (p *mockPeerConnection) AddICECandidate(candidate webrtc.ICECandidateInit) error {
+	p.candidates = append(p.candidates, candidate)
+	return nil
+}
+
+func (p *mockPeerConnection) AddTransceiverFromKind(kind webrtc.RTPCodecType, init ...webrtc.RtpTransceiverInit) (*webrtc.RTPTransceiver, error) {
+	if p.addTransceiverErr != nil {
+		return nil, p.addTransceiverErr
+	}
+	p.transceivers = append(p.transceivers, transceiver{kind, init[0].Direction})
+	return nil, nil
+}
+
+func (p *mockPeerConnection) SetRemoteDescription(webrtc.SessionDescription) error {
+	p.remoteDescriptions++
+	if len(p.setRemoteDescriptionErrs) == 0 {
+		return nil
+	}
+	err := p.setRemoteDescriptionErrs[0]
+	p.setRemoteDescriptionErrs = p.setRemoteDescriptionErrs[1:]
+	return err
+}
+
+func (p *mockPeerConnection) SetLocalDescription(webrtc.SessionDescription) error {
+	if p.onICECandidate != nil {
+		for _, candidate := range p.gatheredCandidates {
+			p.onICECandidate(candidate)
+		}
+		p.gatheredCandidates = nil
+	}
+	return nil
+}
+
+func (p *mockPeerConnection) CreateOffer(*webrtc.OfferOptions) (webrtc.SessionDescription, error) {
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: p.localSDP}, nil
+}
+
+func (p *mockPeerConnection) CreateAnswer(*webrtc.AnswerOptions) (webrtc.SessionDescription, error) {
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: p.localSDP}, nil
+}
+
+func (p *mockPeerConnection) OnICEConnectionStateChange(fn func(webrtc.ICEConnectionState)) {
+	p.onICEConnectionStateChange = fn
+}
+
+func (p *mockPeerConnection) GetStats() webrtc.StatsReport {
+	return webrtc.StatsReport{}
+}
+
+func (p *mockPeerConnection) Close() error {
+	p.closed = true
+	return nil
+}
+
+// newTestSignaller creates a Signaller for client1 and returns it together
+// with a channel receiving the signals it sends.
+func newTestSignaller(t *testing.T, pc *mockPeerConnection, initiator bool, params signals.Params) (*signals.Signaller, chan interface{}) {
+	signalsChan := make(chan interface{}, 10)
+	s, err := signals.NewSignallerWithParams(
+		initiator,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {
+			signalsChan <- signal
+		},
+		params,
+	)
+	require.Nil(t, err)
+	return s, signalsChan
+}
+
+func newTransceiverRequest(kind string) map[string]interface{} {
+	return map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"transceiverRequest": map[string]interface{}{
+				"kind": kind,
+			},
+		},
+	}
+}
+
+func TestSignaller_transceiverRequest_canPublish(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, err := signals.NewSignallerWithParams(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			CanPublish: func(kind webrtc.RTPCodecType) bool {
+				return kind != webrtc.RTPCodecTypeVideo
+			},
+		},
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Signal(newTransceiverRequest("video")))
+	require.Nil(t, s.Signal(newTransceiverRequest("audio")))
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	assert.Equal(t, []transceiver{
+		{webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverDirectionRecvonly},
+		{webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverDirectionRecvonly},
+		{webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverDirectionSendonly},
+		{webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverDirectionSendrecv},
+	}, pc.transceivers)
+}
+
+func TestSignaller_transceiverDirections(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, err := signals.NewSignallerWithParams(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			InitialDirection:   webrtc.RTPTransceiverDirectionSendrecv,
+			RequestedDirection: webrtc.RTPTransceiverDirectionRecvonly,
+		},
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Signal(newTransceiverRequest("video")))
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	assert.Equal(t, []transceiver{
+		{webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverDirectionSendrecv},
+		{webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverDirectionSendrecv},
+		{webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverDirectionRecvonly},
+	}, pc.transceivers)
+}
+
+func TestSignaller_transceiverRequest(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, err := signals.NewSignaller(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Signal(newTransceiverRequest("video")))
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	assert.Equal(t, transceiver{webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverDirectionSendrecv}, pc.transceivers[2])
+}
+
+func TestSignaller_audioOnly(t *testing.T) {
+	pc := &mockPeerConnection{}
+	var reserved int
+	var errs []error
+	s, err := signals.NewSignallerWithParams(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			AudioOnly: true,
+			AddTransceivers: func(n int) bool {
+				reserved += n
+				return true
+			},
+			OnError: func(err error) {
+				errs = append(errs, err)
+			},
+		},
+	)
+	require.Nil(t, err)
+	assert.Equal(t, []transceiver{
+		{webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverDirectionRecvonly},
+	}, pc.transceivers, "expected no video transceiver to be pre-added")
+
+	require.Nil(t, s.Signal(newTransceiverRequest("video")))
+	require.Nil(t, s.Signal(newTransceiverRequest("audio")))
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	assert.Equal(t, []transceiver{
+		{webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverDirectionRecvonly},
+		{webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverDirectionSendrecv},
+	}, pc.transceivers, "expected the video transceiver request to be refused")
+	assert.Equal(t, 2, reserved)
+	require.Equal(t, 1, len(errs), "expected the refused request to be signaled")
+	assert.True(t, errors.Is(errs[0], signals.ErrVideoDisabled), "unexpected error: %s", errs[0])
+}
+
+func TestSignaller_transceiverRequest_error(t *testing.T) {
+	pc := &mockPeerConnection{}
+	var reserved int
+	var errs []error
+	s, err := signals.NewSignallerWithParams(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			AddTransceivers: func(n int) bool {
+				reserved += n
+				return true
+			},
+			RemoveTransceivers: func(n int) {
+				reserved -= n
+			},
+			OnError: func(err error) {
+				errs = append(errs, err)
+			},
+		},
+	)
+	require.Nil(t, err)
+	assert.Equal(t, 2, reserved)
+
+	pc.addTransceiverErr = errors.New("test error")
+	require.Nil(t, s.Signal(newTransceiverRequest("audio")))
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	require.Equal(t, 1, len(errs), "expected the error to be signaled")
+	assert.True(t, errors.Is(errs[0], signals.ErrAddTransceiver), "unexpected error: %s", errs[0])
+	assert.Contains(t, errs[0].Error(), "test error")
+	assert.Equal(t, 2, reserved, "expected the reserved transceiver to be released")
+	assert.Equal(t, 2, len(pc.transceivers))
+}
+
+func TestSignaller_transceiverRequest_roomFull(t *testing.T) {
+	pc := &mockPeerConnection{}
+	var errs []error
+	s, err := signals.NewSignallerWithParams(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			// only the initial transceivers fit
+			AddTransceivers: func(n int) bool {
+				return n == 2
+			},
+			RemoveTransceivers: func(n int) {},
+			OnError: func(err error) {
+				errs = append(errs, err)
+			},
+		},
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Signal(newTransceiverRequest("video")))
+	require.Equal(t, 1, len(errs), "expected the error to be signaled")
+	assert.True(t, errors.Is(errs[0], signals.ErrTooManyTransceivers), "unexpected error: %s", errs[0])
+	assert.Equal(t, 2, len(pc.transceivers))
+}
+
+func TestSignaller_transceiverRequest_maxTransceivers(t *testing.T) {
+	tracksManager := tracks.NewTracksManagerWithParams(tracks.Params{
+		MaxTransceivers: 5,
+	})
+
+	params := signals.Params{
+		AddTransceivers: func(n int) bool {
+			return tracksManager.AddTransceivers("room1", n)
+		},
+		RemoveTransceivers: func(n int) {
+			tracksManager.RemoveTransceivers("room1", n)
+		},
+	}
+
+	pc1 := &mockPeerConnection{}
+	s1, _ := newTestSignaller(t, pc1, true, params)
+	pc2 := &mockPeerConnection{}
+	s2, _ := newTestSignaller(t, pc2, true, params)
+	defer s2.Close()
+	assert.Equal(t, 4, tracksManager.Transceivers("room1"))
+
+	_, err := signals.NewSignallerWithParams(
+		true,
+		&mockPeerConnection{},
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client3",
+		func(signal interface{}) {},
+		params,
+	)
+	assert.True(t, errors.Is(err, signals.ErrTooManyTransceivers))
+	assert.Equal(t, 4, tracksManager.Transceivers("room1"))
+
+	require.Nil(t, s1.Signal(newTransceiverRequest("video")))
+	pc1.onSignalingStateChange(webrtc.SignalingStateStable)
+	assert.Equal(t, 3, len(pc1.transceivers))
+	assert.Equal(t, 5, tracksManager.Transceivers("room1"))
+
+	require.Nil(t, s2.Signal(newTransceiverRequest("video")))
+	pc2.onSignalingStateChange(webrtc.SignalingStateStable)
+	assert.Equal(t, 2, len(pc2.transceivers))
+	assert.Equal(t, 5, tracksManager.Transceivers("room1"))
+
+	require.Nil(t, s1.Close())
+	assert.Equal(t, 2, tracksManager.Transceivers("room1"))
+
+	require.Nil(t, s2.Signal(newTransceiverRequest("video")))
+	pc2.onSignalingStateChange(webrtc.SignalingStateStable)
+	assert.Equal(t, 3, len(pc2.transceivers))
+	assert.Equal(t, 3, tracksManager.Transceivers("room1"))
+}
+
+const testSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"
+
+func newOffer() map[string]interface{} {
+	return map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"type": "offer",
+			"sdp":  testSDP,
+		},
+	}
+}
+
+func TestSignaller_remoteOffer_retry(t *testing.T) {
+	pc := &mockPeerConnection{
+		setRemoteDescriptionErrs: []error{errors.New("test error")},
+	}
+	s, signalsChan := newTestSignaller(t, pc, false, signals.Params{
+		NegotiationRetries:    3,
+		NegotiationRetryDelay: time.Millisecond,
+	})
+
+	err := s.Signal(newOffer())
+	require.NotNil(t, err)
+	assert.Regexp(t, "Error setting remote description", err.Error())
+	assert.Equal(t, signals.NewPayloadRenegotiate("__SERVER__"), <-signalsChan)
+
+	require.Nil(t, s.Signal(newOffer()))
+	answer := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer}
+	assert.Equal(t, signals.NewPayloadSDP("__SERVER__", answer), <-signalsChan)
+}
+
+func TestSignaller_remoteOffer_maxRetries(t *testing.T) {
+	testErr := errors.New("test error")
+	pc := &mockPeerConnection{
+		setRemoteDescriptionErrs: []error{testErr, testErr, testErr},
+	}
+	s, signalsChan := newTestSignaller(t, pc, false, signals.Params{
+		NegotiationRetries:    2,
+		NegotiationRetryDelay: time.Millisecond,
+	})
+
+	for i := 0; i < 3; i++ {
+		require.NotNil(t, s.Signal(newOffer()))
+	}
+	assert.Equal(t, signals.NewPayloadRenegotiate("__SERVER__"), <-signalsChan)
+	assert.Equal(t, signals.NewPayloadRenegotiate("__SERVER__"), <-signalsChan)
+	select {
+	case signal := <-signalsChan:
+		assert.Fail(t, "unexpected signal", "%v", signal)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSignaller_remoteOffer_retryAfterClose(t *testing.T) {
+	pc := &mockPeerConnection{
+		setRemoteDescriptionErrs: []error{errors.New("test error")},
+	}
+	s, signalsChan := newTestSignaller(t, pc, false, signals.Params{
+		NegotiationRetries:    3,
+		NegotiationRetryDelay: time.Millisecond,
+	})
+
+	require.NotNil(t, s.Signal(newOffer()))
+	require.Nil(t, s.Close())
+	select {
+	case signal := <-signalsChan:
+		assert.Fail(t, "unexpected signal", "%v", signal)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSignaller_remoteOffer_maxSDPSize(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, err := signals.NewSignallerWithParams(
+		false,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			MaxSDPSize: len(testSDP),
+		},
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Signal(newOffer()))
+	assert.Equal(t, 1, pc.remoteDescriptions)
+	assert.False(t, pc.closed)
+
+	offer := newOffer()
+	offer["signal"].(map[string]interface{})["sdp"] = testSDP + "a=x\r\n"
+	err = s.Signal(offer)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, signals.ErrSDPTooLarge))
+	assert.Equal(t, 1, pc.remoteDescriptions)
+	assert.True(t, pc.closed)
+
+	select {
+	case <-s.CloseChannel():
+	default:
+		t.Fatal("expected signaller to be closed")
+	}
+}
+
+func histogramSampleCount(t *testing.T, name string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestSignaller_negotiationDuration(t *testing.T) {
+	localOffers := histogramSampleCount(t, "peercalls_sdp_local_offer_duration_seconds")
+	remoteOffers := histogramSampleCount(t, "peercalls_sdp_remote_offer_duration_seconds")
+
+	signalsChan := make(chan interface{}, 10)
+	s, err := signals.NewSignaller(
+		true,
+		&mockPeerConnection{},
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {
+			signalsChan <- signal
+		},
+	)
+	require.Nil(t, err)
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer}
+	assert.Equal(t, signals.NewPayloadSDP("__SERVER__", offer), <-signalsChan)
+
+	require.Nil(t, s.Signal(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"type": "answer",
+			"sdp":  testSDP,
+		},
+	}))
+	assert.Equal(t, localOffers+1, histogramSampleCount(t, "peercalls_sdp_local_offer_duration_seconds"))
+
+	require.Nil(t, s.Signal(newOffer()))
+	answer := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer}
+	assert.Equal(t, signals.NewPayloadSDP("__SERVER__", answer), <-signalsChan)
+	assert.Equal(t, remoteOffers+1, histogramSampleCount(t, "peercalls_sdp_remote_offer_duration_seconds"))
+}
+
+func TestSignaller_allowCandidate(t *testing.T) {
+	pc := &mockPeerConnection{
+		localSDP: testSDP +
+			"a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host\r\n" +
+			"a=candidate:2 1 udp 2130706431 192.168.0.1 5000 typ host\r\n",
+	}
+	signalsChan := make(chan interface{}, 10)
+	s, err := signals.NewSignallerWithParams(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {
+			signalsChan <- signal
+		},
+		signals.Params{
+			AllowCandidate: func(address string) bool {
+				return address != "10.0.0.1"
+			},
+		},
+	)
+	require.Nil(t, err)
+
+	offer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  testSDP + "a=candidate:2 1 udp 2130706431 192.168.0.1 5000 typ host\r\n",
+	}
+	assert.Equal(t, signals.NewPayloadSDP("__SERVER__", offer), <-signalsChan)
+
+	for _, address := range []string{"10.0.0.1", "192.168.0.1"} {
+		require.Nil(t, s.Signal(map[string]interface{}{
+			"userId": "client1",
+			"signal": map[string]interface{}{
+				"candidate": map[string]interface{}{
+					"candidate":     "candidate:1 1 udp 2130706431 " + address + " 5000 typ host",
+					"sdpMLineIndex": float64(0),
+					"sdpMid":        "0",
+				},
+			},
+		}))
+	}
+	require.Equal(t, 1, len(pc.candidates))
+	assert.Regexp(t, "192.168.0.1", pc.candidates[0].Candidate)
+}
+
+func TestSignaller_maxCandidates(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, err := signals.NewSignallerWithParams(
+		false,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			MaxCandidates: 2,
+		},
+	)
+	require.Nil(t, err)
+
+	candidate := func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"userId": "client1",
+			"signal": map[string]interface{}{
+				"candidate": map[string]interface{}{
+					"candidate":     fmt.Sprintf("candidate:%d 1 udp 2130706431 192.168.0.1 %d typ host", i, 5000+i),
+					"sdpMLineIndex": float64(0),
+					"sdpMid":        "0",
+				},
+			},
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		require.Nil(t, s.Signal(candidate(i)), "candidates over the limit should be dropped")
+	}
+	assert.Equal(t, 2, len(pc.candidates))
+	assert.False(t, pc.closed)
+
+	err = s.Signal(candidate(4))
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, signals.ErrTooManyCandidates))
+	assert.Equal(t, 2, len(pc.candidates))
+	assert.True(t, pc.closed)
+
+	select {
+	case <-s.CloseChannel():
+	default:
+		t.Fatal("expected signaller to be closed")
+	}
+}
+
+func TestSignaller_states(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, _ := newTestSignaller(t, pc, false, signals.Params{
+		DisconnectGracePeriod: time.Minute,
+	})
+
+	assert.Equal(t, webrtc.ICEConnectionStateNew, s.ConnectionState())
+	assert.Equal(t, webrtc.SignalingStateStable, s.SignalingState())
+
+	pc.onSignalingStateChange(webrtc.SignalingStateHaveRemoteOffer)
+	assert.Equal(t, webrtc.SignalingStateHaveRemoteOffer, s.SignalingState())
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+	assert.Equal(t, webrtc.SignalingStateStable, s.SignalingState())
+
+	for _, state := range []webrtc.ICEConnectionState{
+		webrtc.ICEConnectionStateChecking,
+		webrtc.ICEConnectionStateConnected,
+		webrtc.ICEConnectionStateDisconnected,
+		webrtc.ICEConnectionStateConnected,
+	} {
+		pc.onICEConnectionStateChange(state)
+		assert.Equal(t, state, s.ConnectionState())
+	}
+
+	require.Nil(t, s.Close())
+	assert.Equal(t, webrtc.ICEConnectionStateClosed, s.ConnectionState())
+	assert.Equal(t, webrtc.SignalingStateClosed, s.SignalingState())
+}
+
+func TestSignaller_onConnected(t *testing.T) {
+	pc := &mockPeerConnection{}
+	connected := make(chan struct{}, 2)
+	s, _ := newTestSignaller(t, pc, false, signals.Params{
+		OnConnected: func() {
+			connected <- struct{}{}
+		},
+	})
+	defer s.Close()
+
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateChecking)
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for OnConnected")
+	}
+
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateCompleted)
+	select {
+	case <-connected:
+		assert.Fail(t, "OnConnected should only be called when connected")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSignaller_disconnectGracePeriod_recover(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, _ := newTestSignaller(t, pc, false, signals.Params{
+		DisconnectGracePeriod: 20 * time.Millisecond,
+	})
+
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+
+	select {
+	case <-s.CloseChannel():
+		assert.Fail(t, "expected peer connection to remain open")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSignaller_disconnectGracePeriod_timeout(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, _ := newTestSignaller(t, pc, false, signals.Params{
+		DisconnectGracePeriod: 20 * time.Millisecond,
+	})
+
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+
+	select {
+	case <-s.CloseChannel():
+		assert.Fail(t, "expected peer connection to be closed after the grace period")
+	default:
+	}
+	select {
+	case <-s.CloseChannel():
+	case <-time.After(time.Second):
+		assert.Fail(t, "expected peer connection to be closed")
+	}
+}
+
+func TestSignaller_disconnectGracePeriod_failed(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, _ := newTestSignaller(t, pc, false, signals.Params{
+		DisconnectGracePeriod: time.Minute,
+	})
+
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateFailed)
+
+	select {
+	case <-s.CloseChannel():
+	default:
+		assert.Fail(t, "expected peer connection to be closed immediately")
+	}
+}
+
+func newCloseReasonSignaller(t *testing.T, pc *mockPeerConnection, params signals.Params) (*signals.Signaller, <-chan signals.CloseReason) {
+	reasons := make(chan signals.CloseReason, 2)
+	params.OnClose = func(reason signals.CloseReason) {
+		assert.False(t, pc.closed, "expected OnClose to be called before closing")
+		reasons <- reason
+	}
+	s, _ := newTestSignaller(t, pc, false, params)
+	return s, reasons
+}
+
+func TestSignaller_closeWithReason(t *testing.T) {
+	for _, reason := range []signals.CloseReason{
+		signals.CloseReasonKicked,
+		signals.CloseReasonShutdown,
+	} {
+		pc := &mockPeerConnection{}
+		s, reasons := newCloseReasonSignaller(t, pc, signals.Params{})
+
+		require.Nil(t, s.CloseWithReason(reason))
+		require.Nil(t, s.Close())
+		assert.True(t, pc.closed)
+		assert.Equal(t, reason, <-reasons)
+		assert.Equal(t, 0, len(reasons), "expected OnClose to be called once")
+	}
+}
+
+func TestSignaller_close_hangUp(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, reasons := newCloseReasonSignaller(t, pc, signals.Params{})
+
+	require.Nil(t, s.Close())
+	assert.Equal(t, signals.CloseReasonHangUp, <-reasons)
+}
+
+func TestSignaller_closeReason_iceFailed(t *testing.T) {
+	pc := &mockPeerConnection{}
+	_, reasons := newCloseReasonSignaller(t, pc, signals.Params{})
+
+	pc.onICEConnectionStateChange(webrtc.ICEConnectionStateFailed)
+	assert.Equal(t, signals.CloseReasonICEFailed, <-reasons)
+}
+
+func TestSignaller_closeReason_sdpTooLarge(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, reasons := newCloseReasonSignaller(t, pc, signals.Params{
+		MaxSDPSize: len(testSDP) - 1,
+	})
+
+	require.NotNil(t, s.Signal(newOffer()))
+	assert.Equal(t, signals.CloseReasonNegotiationFailed, <-reasons)
+}
+
+func TestSignaller_negotiationTimeout_stuck(t *testing.T) {
+	for _, state := range []webrtc.SignalingState{
+		webrtc.SignalingStateHaveLocalOffer,
+		webrtc.SignalingStateHaveRemoteOffer,
+	} {
+		pc := &mockPeerConnection{}
+		_, reasons := newCloseReasonSignaller(t, pc, signals.Params{
+			NegotiationTimeout: 20 * time.Millisecond,
+		})
+
+		pc.onSignalingStateChange(state)
+
+		select {
+		case reason := <-reasons:
+			assert.Equal(t, signals.CloseReasonNegotiationFailed, reason)
+		case <-time.After(time.Second):
+			assert.Fail(t, "expected peer connection to be closed", "state: %s", state)
+		}
+	}
+}
+
+func TestSignaller_negotiationTimeout_stable(t *testing.T) {
+	pc := &mockPeerConnection{}
+	_, reasons := newCloseReasonSignaller(t, pc, signals.Params{
+		NegotiationTimeout: 20 * time.Millisecond,
+	})
+
+	pc.onSignalingStateChange(webrtc.SignalingStateHaveLocalOffer)
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	select {
+	case reason := <-reasons:
+		assert.Fail(t, "expected peer connection to remain open", "reason: %s", reason)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newAnswer() map[string]interface{} {
+	return map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"type": "answer",
+			"sdp":  testSDP,
+		},
+	}
+}
+
+func assertNoSignal(t *testing.T, signalsChan <-chan interface{}) {
+	t.Helper()
+	select {
+	case signal := <-signalsChan:
+		assert.Fail(t, "unexpected signal", "signal: %v", signal)
+	default:
+	}
+}
+
+func TestSignaller_rebind(t *testing.T) {
+	oldSignals := make(chan interface{}, 10)
+	pc := &mockPeerConnection{}
+	s, err := signals.NewSignaller(
+		true,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {
+			oldSignals <- signal
+		},
+	)
+	require.Nil(t, err)
+
+	offer := signals.NewPayloadSDP("__SERVER__", webrtc.SessionDescription{Type: webrtc.SDPTypeOffer})
+	assert.Equal(t, offer, <-oldSignals)
+	require.Nil(t, s.Signal(newAnswer()))
+	pc.onSignalingStateChange(webrtc.SignalingStateStable)
+
+	newSignals := make(chan interface{}, 10)
+	s.Rebind(func(signal interface{}) {
+		newSignals <- signal
+	})
+	assertNoSignal(t, newSignals)
+
+	s.Negotiate()
+	assert.Equal(t, offer, <-newSignals)
+	assertNoSignal(t, oldSignals)
+}
+
+func TestSignaller_rebind_pendingOffer(t *testing.T) {
+	oldSignals := make(chan interface{}, 10)
+	s, err := signals.NewSignaller(
+		true,
+		&mockPeerConnection{},
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {
+			oldSignals <- signal
+		},
+	)
+	require.Nil(t, err)
+
+	// the offer was sent, but the transport was replaced before the answer
+	offer := signals.NewPayloadSDP("__SERVER__", webrtc.SessionDescription{Type: webrtc.SDPTypeOffer})
+	assert.Equal(t, offer, <-oldSignals)
+
+	newSignals := make(chan interface{}, 10)
+	s.Rebind(func(signal interface{}) {
+		newSignals <- signal
+	})
+	assert.Equal(t, offer, <-newSignals)
+
+	require.Nil(t, s.Signal(newAnswer()))
+	s.Rebind(func(signal interface{}) {
+		newSignals <- signal
+	})
+	assertNoSignal(t, newSignals)
+}
+
+func TestSignaller_rebind_pendingRenegotiation(t *testing.T) {
+	pc := &mockPeerConnection{}
+	s, oldSignals := newTestSignaller(t, pc, false, signals.Params{})
+
+	s.Negotiate()
+	renegotiate := signals.NewPayloadRenegotiate("__SERVER__")
+	assert.Equal(t, renegotiate, <-oldSignals)
+
+	newSignals := make(chan interface{}, 10)
+	s.Rebind(func(signal interface{}) {
+		newSignals <- signal
+	})
+	assert.Equal(t, renegotiate, <-newSignals)
+
+	require.Nil(t, s.Signal(newOffer()))
+	answer := signals.NewPayloadSDP("__SERVER__", webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer})
+	assert.Equal(t, answer, <-newSignals)
+
+	s.Rebind(func(signal interface{}) {
+		newSignals <- signal
+	})
+	assertNoSignal(t, newSignals)
+	assertNoSignal(t, oldSignals)
+}
+
+func TestSignaller_handleSignal(t *testing.T) {
+	require.Nil(t, signals.RegisterPayloadType("fileAccept", func(value interface{}, _ map[string]interface{}) (interface{}, error) {
+		return value, nil
+	}))
+
+	var handled []signals.Payload
+	s, err := signals.NewSignallerWithParams(
+		false,
+		&mockPeerConnection{},
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			HandleSignal: func(payload signals.Payload) error {
+				handled = append(handled, payload)
+				return nil
+			},
+		},
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, s.Signal(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"fileAccept": "notes.txt",
+		},
+	}))
+	assert.Equal(t, []signals.Payload{{UserID: "client1", Signal: "notes.txt"}}, handled)
+}
+
+func newHostCandidate(address string) *webrtc.ICECandidate {
+	return &webrtc.ICECandidate{
+		Foundation: "1",
+		Priority:   2130706431,
+		Address:    address,
+		Protocol:   webrtc.ICEProtocolUDP,
+		Port:       5000,
+		Typ:        webrtc.ICECandidateTypeHost,
+		Component:  1,
+	}
+}
+
+func TestSignaller_trickle(t *testing.T) {
+	pc := &mockPeerConnection{
+		localSDP: testSDP,
+		gatheredCandidates: []*webrtc.ICECandidate{
+			newHostCandidate("192.168.0.1"),
+			nil,
+		},
+	}
+	s, signalsChan := newTestSignaller(t, pc, true, signals.Params{
+		Trickle: true,
+	})
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: testSDP}
+	assert.Equal(t, signals.NewPayloadSDP("__SERVER__", offer), <-signalsChan,
+		"candidates gathered before the offer was signaled should follow it")
+	assert.Equal(t, signals.Payload{
+		UserID: "__SERVER__",
+		Signal: signals.Candidate{Candidate: newHostCandidate("192.168.0.1").ToJSON()},
+	}, <-signalsChan)
+	assertNoSignal(t, signalsChan)
+
+	// candidates gathered later are signaled as they arrive
+	pc.onICECandidate(newHostCandidate("192.168.0.2"))
+	assert.Equal(t, signals.Payload{
+		UserID: "__SERVER__",
+		Signal: signals.Candidate{Candidate: newHostCandidate("192.168.0.2").ToJSON()},
+	}, <-signalsChan)
+
+	require.Nil(t, s.Signal(newAnswer()))
+	assertNoSignal(t, signalsChan)
+}
+
+func TestSignaller_nonTrickle(t *testing.T) {
+	sdp := testSDP + "a=candidate:1 1 udp 2130706431 192.168.0.1 5000 typ host\r\n"
+	pc := &mockPeerConnection{
+		localSDP: sdp,
+		gatheredCandidates: []*webrtc.ICECandidate{
+			newHostCandidate("192.168.0.1"),
+		},
+	}
+	s, signalsChan := newTestSignaller(t, pc, true, signals.Params{
+		Trickle: false,
+	})
+
+	assert.Nil(t, pc.onICECandidate, "candidates should not be signaled separately")
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+	assert.Equal(t, signals.NewPayloadSDP("__SERVER__", offer), <-signalsChan)
+
+	require.Nil(t, s.Signal(newAnswer()))
+	assertNoSignal(t, signalsChan)
+}
+
+func TestSignaller_Codecs(t *testing.T) {
+	pc := &mockPeerConnection{localSDP: testSDP}
+	s, err := signals.NewSignaller(
+		false,
+		pc,
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+	)
+	require.Nil(t, err)
+
+	assert.Empty(t, s.Codecs(webrtc.RTPCodecTypeVideo))
+
+	require.Nil(t, s.Signal(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"type": "offer",
+			"sdp": testSDP +
+				"m=video 9 UDP/TLS/RTP/SAVPF 98 100\r\n" +
+				"a=rtpmap:98 VP8/90000\r\n" +
+				"a=rtpmap:100 VP9/90000\r\n",
+		},
+	}))
+
+	codecs := s.Codecs(webrtc.RTPCodecTypeVideo)
+	require.Equal(t, 2, len(codecs))
+	assert.Equal(t, webrtc.VP8, codecs[0].Name)
+	assert.Equal(t, uint8(98), codecs[0].PayloadType)
+	assert.Equal(t, webrtc.VP9, codecs[1].Name)
+	assert.Equal(t, uint8(100), codecs[1].PayloadType)
+	assert.Empty(t, s.Codecs(webrtc.RTPCodecTypeAudio))
+}
+
+type fileReject struct {
+	Name string
+}
+
+func TestSignaller_unexpectedSignal(t *testing.T) {
+	require.Nil(t, signals.RegisterPayloadType("fileReject", func(value interface{}, _ map[string]interface{}) (interface{}, error) {
+		name, _ := value.(string)
+		return fileReject{name}, nil
+	}))
+
+	s, err := signals.NewSignallerWithParams(
+		false,
+		&mockPeerConnection{},
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{},
+	)
+	require.Nil(t, err)
+
+	err = s.Signal(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"fileReject": "notes.txt",
+		},
+	})
+	require.NotNil(t, err)
+
+	var unexpected *signals.UnexpectedSignalError
+	require.True(t, errors.As(err, &unexpected))
+	assert.Equal(t, "client1", unexpected.PeerID)
+	assert.Equal(t, "signals_test.fileReject", unexpected.Type)
+	assert.Equal(t, fileReject{"notes.txt"}, unexpected.Signal)
+	assert.True(t, errors.Is(err, signals.ErrUnexpectedSignal))
+	assert.Equal(t, `[client1] Unexpected signal: signals_test.fileReject{Name:"notes.txt"}`, err.Error())
+}
+
+func TestSignaller_payloadLimits(t *testing.T) {
+	s, err := signals.NewSignallerWithParams(
+		false,
+		&mockPeerConnection{},
+		&webrtc.MediaEngine{},
+		"__SERVER__",
+		"client1",
+		func(signal interface{}) {},
+		signals.Params{
+			PayloadLimits: signals.PayloadLimits{MaxElements: 3},
+		},
+	)
+	require.Nil(t, err)
+
+	err = s.Signal(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"renegotiate": []interface{}{1, 2},
+		},
+	})
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, signals.ErrPayloadTooLarge))
+}
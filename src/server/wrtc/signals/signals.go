@@ -2,6 +2,7 @@ package signals
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/pion/webrtc/v2"
 )
@@ -198,7 +199,139 @@ func newSDP(sdpType interface{}, signal map[string]interface{}) (s webrtc.Sessio
 	return
 }
 
+// Decodes the value of the property identifying a signal type. The whole
+// signal map is passed for signals that span multiple properties.
+type PayloadDecoder func(value interface{}, signal map[string]interface{}) (interface{}, error)
+
+type payloadType struct {
+	key    string
+	decode PayloadDecoder
+}
+
+var (
+	payloadTypesMu sync.RWMutex
+	// Checked in order, the first type whose key is set in the signal is used.
+	payloadTypes = []payloadType{
+		{"candidate", func(value interface{}, _ map[string]interface{}) (interface{}, error) {
+			return newCandidate(value)
+		}},
+		{"renegotiate", func(interface{}, map[string]interface{}) (interface{}, error) {
+			return newRenegotiate(), nil
+		}},
+		{"transceiverRequest", func(value interface{}, _ map[string]interface{}) (interface{}, error) {
+			return newTransceiverRequest(value)
+		}},
+		{"type", func(value interface{}, signal map[string]interface{}) (interface{}, error) {
+			return newSDP(value, signal)
+		}},
+	}
+)
+
+// Registers a signal type identified by the key property of the signal, so
+// that NewPayloadFromMap can decode it. Registered types are checked after
+// the built-in ones. Returns an error when the key is already registered.
+func RegisterPayloadType(key string, decode PayloadDecoder) error {
+	payloadTypesMu.Lock()
+	defer payloadTypesMu.Unlock()
+
+	for _, t := range payloadTypes {
+		if t.key == key {
+			return fmt.Errorf("Payload type already registered: %s", key)
+		}
+	}
+
+	payloadTypes = append(payloadTypes, payloadType{key, decode})
+	return nil
+}
+
+// Walks maps and slices in value at depth and adds their elements to
+// elements. Stops as soon as a limit is exceeded.
+func checkPayloadSize(value interface{}, depth int, limits PayloadLimits, elements *int) error {
+	check := func(n int) error {
+		if depth > limits.MaxDepth {
+			return fmt.Errorf("%w: nested deeper than %d", ErrPayloadTooLarge, limits.MaxDepth)
+		}
+		*elements += n
+		if *elements > limits.MaxElements {
+			return fmt.Errorf("%w: more than %d elements", ErrPayloadTooLarge, limits.MaxElements)
+		}
+		return nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if err := check(len(v)); err != nil {
+			return err
+		}
+		for _, child := range v {
+			if err := checkPayloadSize(child, depth+1, limits, elements); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if err := check(len(v)); err != nil {
+			return err
+		}
+		for _, child := range v {
+			if err := checkPayloadSize(child, depth+1, limits, elements); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeSignal(signal map[string]interface{}) (value interface{}, ok bool, err error) {
+	payloadTypesMu.RLock()
+	defer payloadTypesMu.RUnlock()
+
+	for _, t := range payloadTypes {
+		if v, ok := signal[t.key]; ok {
+			value, err = t.decode(v, signal)
+			return value, true, err
+		}
+	}
+
+	return nil, false, nil
+}
+
+var ErrPayloadTooLarge = fmt.Errorf("Payload too large")
+
+// Defaults of PayloadLimits.
+const (
+	DefaultMaxPayloadDepth    = 16
+	DefaultMaxPayloadElements = 1024
+)
+
+// Bounds of payloads decoded by NewPayloadFromMapWithLimits.
+type PayloadLimits struct {
+	// Maximum nesting of maps and slices, the payload itself is at depth 1.
+	// Defaults to DefaultMaxPayloadDepth when zero.
+	MaxDepth int
+	// Maximum number of map entries and slice elements at all depths.
+	// Defaults to DefaultMaxPayloadElements when zero.
+	MaxElements int
+}
+
 func NewPayloadFromMap(payload map[string]interface{}) (p Payload, err error) {
+	return NewPayloadFromMapWithLimits(payload, PayloadLimits{})
+}
+
+// Decodes a signal payload received from a client. Payloads nested deeper or
+// with more elements than limits allow are rejected with ErrPayloadTooLarge
+// before the signal is decoded.
+func NewPayloadFromMapWithLimits(payload map[string]interface{}, limits PayloadLimits) (p Payload, err error) {
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultMaxPayloadDepth
+	}
+	if limits.MaxElements <= 0 {
+		limits.MaxElements = DefaultMaxPayloadElements
+	}
+	elements := 0
+	if err = checkPayloadSize(payload, 1, limits, &elements); err != nil {
+		return
+	}
+
 	userID, ok := payload["userId"].(string)
 	if !ok {
 		err = fmt.Errorf("No userId property in payload: %#v", payload)
@@ -210,17 +343,8 @@ func NewPayloadFromMap(payload map[string]interface{}) (p Payload, err error) {
 		return
 	}
 
-	var value interface{}
-
-	if candidate, ok := signal["candidate"]; ok {
-		value, err = newCandidate(candidate)
-	} else if _, ok := signal["renegotiate"]; ok {
-		value = newRenegotiate()
-	} else if transceiverRequest, ok := signal["transceiverRequest"]; ok {
-		value, err = newTransceiverRequest(transceiverRequest)
-	} else if sdpType, ok := signal["type"]; ok {
-		value, err = newSDP(sdpType, signal)
-	} else {
+	value, ok, err := decodeSignal(signal)
+	if !ok {
 		err = fmt.Errorf("Unexpected signal message: %#v", payload)
 		return
 	}
@@ -0,0 +1,153 @@
+package signals_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fileOffer struct {
+	FileOffer struct {
+		Name string `json:"name"`
+		Size int    `json:"size"`
+	} `json:"fileOffer"`
+}
+
+func decodeFileOffer(value interface{}, _ map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(map[string]interface{}{"fileOffer": value})
+	if err != nil {
+		return nil, err
+	}
+	var offer fileOffer
+	if err := json.Unmarshal(data, &offer); err != nil {
+		return nil, fmt.Errorf("Invalid file offer: %w", err)
+	}
+	return offer, nil
+}
+
+func roundTrip(t *testing.T, payload signals.Payload) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	require.Nil(t, err)
+	var payloadMap map[string]interface{}
+	require.Nil(t, json.Unmarshal(data, &payloadMap))
+	return payloadMap
+}
+
+func TestRegisterPayloadType(t *testing.T) {
+	require.Nil(t, signals.RegisterPayloadType("fileOffer", decodeFileOffer))
+
+	var offer fileOffer
+	offer.FileOffer.Name = "notes.txt"
+	offer.FileOffer.Size = 1024
+
+	payload, err := signals.NewPayloadFromMap(roundTrip(t, signals.Payload{
+		UserID: "client1",
+		Signal: offer,
+	}))
+	require.Nil(t, err)
+	assert.Equal(t, signals.Payload{UserID: "client1", Signal: offer}, payload)
+
+	_, err = signals.NewPayloadFromMap(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"fileOffer": "notes.txt",
+		},
+	})
+	require.NotNil(t, err)
+	assert.Regexp(t, "Invalid file offer", err.Error())
+
+	err = signals.RegisterPayloadType("fileOffer", decodeFileOffer)
+	require.NotNil(t, err)
+	assert.Regexp(t, "already registered", err.Error())
+}
+
+func TestRegisterPayloadType_builtIn(t *testing.T) {
+	err := signals.RegisterPayloadType("candidate", decodeFileOffer)
+	require.NotNil(t, err)
+	assert.Regexp(t, "already registered", err.Error())
+
+	payload, err := signals.NewPayloadFromMap(roundTrip(t, signals.NewPayloadRenegotiate("client1")))
+	require.Nil(t, err)
+	assert.Equal(t, signals.NewPayloadRenegotiate("client1"), payload)
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: testSDP}
+	payload, err = signals.NewPayloadFromMap(roundTrip(t, signals.NewPayloadSDP("client1", offer)))
+	require.Nil(t, err)
+	assert.Equal(t, signals.NewPayloadSDP("client1", offer), payload)
+}
+
+func nestedMap(depth int) map[string]interface{} {
+	m := map[string]interface{}{}
+	for i := 1; i < depth; i++ {
+		m = map[string]interface{}{"nested": m}
+	}
+	return m
+}
+
+func TestNewPayloadFromMap_tooDeep(t *testing.T) {
+	payload := map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"renegotiate": nestedMap(10000),
+		},
+	}
+	_, err := signals.NewPayloadFromMap(payload)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, signals.ErrPayloadTooLarge))
+	assert.Regexp(t, "nested deeper than 16", err.Error())
+
+	// the payload and signal maps are at depths 1 and 2
+	_, err = signals.NewPayloadFromMapWithLimits(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"renegotiate": nestedMap(2),
+		},
+	}, signals.PayloadLimits{MaxDepth: 4})
+	assert.Nil(t, err)
+
+	_, err = signals.NewPayloadFromMapWithLimits(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"renegotiate": nestedMap(3),
+		},
+	}, signals.PayloadLimits{MaxDepth: 4})
+	assert.True(t, errors.Is(err, signals.ErrPayloadTooLarge))
+}
+
+func TestNewPayloadFromMap_tooManyElements(t *testing.T) {
+	elements := make([]interface{}, 2000)
+	for i := range elements {
+		elements[i] = []interface{}{}
+	}
+	payload := map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"renegotiate": elements,
+		},
+	}
+	_, err := signals.NewPayloadFromMap(payload)
+	require.NotNil(t, err)
+	assert.True(t, errors.Is(err, signals.ErrPayloadTooLarge))
+	assert.Regexp(t, "more than 1024 elements", err.Error())
+
+	_, err = signals.NewPayloadFromMapWithLimits(payload, signals.PayloadLimits{MaxElements: 2003})
+	assert.Nil(t, err)
+}
+
+func TestNewPayloadFromMap_unknown(t *testing.T) {
+	_, err := signals.NewPayloadFromMap(map[string]interface{}{
+		"userId": "client1",
+		"signal": map[string]interface{}{
+			"unknown": true,
+		},
+	})
+	require.NotNil(t, err)
+	assert.Regexp(t, "Unexpected signal message", err.Error())
+}
@@ -0,0 +1,62 @@
+package tracks
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// Reliability and ordering of a data channel created by the server.
+// Messages are delivered reliably and in order by default.
+type DataChannelOptions struct {
+	Label     string
+	Unordered bool
+	// Maximum number of retransmissions of a message. Cannot be combined with
+	// MaxPacketLifeTime.
+	MaxRetransmits *uint16
+	// Time in milliseconds during which a message is retransmitted. Cannot be
+	// combined with MaxRetransmits.
+	MaxPacketLifeTime *uint16
+}
+
+func (o DataChannelOptions) init() *webrtc.DataChannelInit {
+	ordered := !o.Unordered
+	return &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxRetransmits:    o.MaxRetransmits,
+		MaxPacketLifeTime: o.MaxPacketLifeTime,
+	}
+}
+
+type DataChannelCreator interface {
+	CreateDataChannel(label string, options *webrtc.DataChannelInit) (*webrtc.DataChannel, error)
+}
+
+// Creates the data channels in order and returns the one used for messages,
+// labeled DataChannelName. It is created reliable and ordered first when it
+// is not in options.
+func CreateDataChannels(pc DataChannelCreator, options []DataChannelOptions) (*webrtc.DataChannel, error) {
+	hasDataChannel := false
+	for _, o := range options {
+		if o.Label == DataChannelName {
+			hasDataChannel = true
+			break
+		}
+	}
+	if !hasDataChannel {
+		options = append([]DataChannelOptions{{Label: DataChannelName}}, options...)
+	}
+
+	var dataChannel *webrtc.DataChannel
+	for _, o := range options {
+		dc, err := pc.CreateDataChannel(o.Label, o.init())
+		if err != nil {
+			return nil, fmt.Errorf("Error creating data channel: %s: %w", o.Label, err)
+		}
+		if o.Label == DataChannelName {
+			dataChannel = dc
+		}
+	}
+
+	return dataChannel, nil
+}
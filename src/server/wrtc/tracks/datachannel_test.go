@@ -0,0 +1,115 @@
+package tracks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Connects the peer connections and returns the data channels announced to
+// answerer, keyed by label.
+func connectDataChannels(t *testing.T, offerer *webrtc.PeerConnection, answerer *webrtc.PeerConnection, n int) map[string]*webrtc.DataChannel {
+	t.Helper()
+
+	dataChannels := make(chan *webrtc.DataChannel, n)
+	answerer.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dataChannels <- dc
+	})
+	offerer.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			_ = answerer.AddICECandidate(c.ToJSON())
+		}
+	})
+	answerer.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			_ = offerer.AddICECandidate(c.ToJSON())
+		}
+	})
+
+	offer, err := offerer.CreateOffer(nil)
+	require.Nil(t, err)
+	require.Nil(t, offerer.SetLocalDescription(offer))
+	require.Nil(t, answerer.SetRemoteDescription(offer))
+	answer, err := answerer.CreateAnswer(nil)
+	require.Nil(t, err)
+	require.Nil(t, answerer.SetLocalDescription(answer))
+	require.Nil(t, offerer.SetRemoteDescription(answer))
+
+	received := map[string]*webrtc.DataChannel{}
+	timeout := time.After(20 * time.Second)
+	for len(received) < n {
+		select {
+		case dc := <-dataChannels:
+			received[dc.Label()] = dc
+		case <-timeout:
+			t.Fatalf("Timed out waiting for data channels, received: %d", len(received))
+		}
+	}
+	return received
+}
+
+func TestCreateDataChannels(t *testing.T) {
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer server.Close()
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer client.Close()
+
+	zero := uint16(0)
+	lifeTime := uint16(500)
+	dataChannel, err := tracks.CreateDataChannels(server, []tracks.DataChannelOptions{{
+		Label:          "telemetry",
+		Unordered:      true,
+		MaxRetransmits: &zero,
+	}, {
+		Label:             "cursor",
+		MaxPacketLifeTime: &lifeTime,
+	}})
+	require.Nil(t, err)
+	require.NotNil(t, dataChannel)
+	assert.Equal(t, tracks.DataChannelName, dataChannel.Label())
+
+	received := connectDataChannels(t, server, client, 3)
+
+	data := received[tracks.DataChannelName]
+	require.NotNil(t, data)
+	assert.True(t, data.Ordered(), "the data channel should be ordered")
+	assert.Nil(t, data.MaxRetransmits(), "the data channel should be reliable")
+	assert.Nil(t, data.MaxPacketLifeTime(), "the data channel should be reliable")
+
+	telemetry := received["telemetry"]
+	require.NotNil(t, telemetry)
+	assert.False(t, telemetry.Ordered())
+	require.NotNil(t, telemetry.MaxRetransmits())
+	assert.Equal(t, uint16(0), *telemetry.MaxRetransmits())
+	assert.Nil(t, telemetry.MaxPacketLifeTime())
+
+	cursor := received["cursor"]
+	require.NotNil(t, cursor)
+	assert.True(t, cursor.Ordered())
+	assert.Nil(t, cursor.MaxRetransmits())
+	require.NotNil(t, cursor.MaxPacketLifeTime())
+	assert.Equal(t, uint16(500), *cursor.MaxPacketLifeTime())
+}
+
+func TestCreateDataChannels_listed(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer pc.Close()
+
+	lifeTime := uint16(1000)
+	dataChannel, err := tracks.CreateDataChannels(pc, []tracks.DataChannelOptions{{
+		Label:             tracks.DataChannelName,
+		Unordered:         true,
+		MaxPacketLifeTime: &lifeTime,
+	}})
+	require.Nil(t, err)
+	require.NotNil(t, dataChannel)
+	assert.False(t, dataChannel.Ordered())
+	assert.Equal(t, &lifeTime, dataChannel.MaxPacketLifeTime())
+}
@@ -0,0 +1,78 @@
+package tracks
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Interceptor processes RTP packets published by clients before they are
+// forwarded to subscribers. It is called from the goroutine reading the
+// track, so it should not block.
+type Interceptor interface {
+	// Returns the packet to forward. The packet can be modified in place and
+	// is reused for the next packet of the track after this call, so it must
+	// not be retained. Returning nil drops the packet.
+	InterceptRTP(clientID string, trackID string, packet []byte) []byte
+}
+
+// InterceptorFunc adapts a function to the Interceptor interface.
+type InterceptorFunc func(clientID string, trackID string, packet []byte) []byte
+
+func (f InterceptorFunc) InterceptRTP(clientID string, trackID string, packet []byte) []byte {
+	return f(clientID, trackID, packet)
+}
+
+// Calls each interceptor in order with the packet returned by the previous
+// one and stops when a packet is dropped.
+type interceptorChain []Interceptor
+
+func (c interceptorChain) InterceptRTP(clientID string, trackID string, packet []byte) []byte {
+	for _, interceptor := range c {
+		if packet = interceptor.InterceptRTP(clientID, trackID, packet); packet == nil {
+			return nil
+		}
+	}
+	return packet
+}
+
+var (
+	interceptorsMu sync.RWMutex
+	interceptors   = map[string]Interceptor{}
+)
+
+// Registers an interceptor so that it can be enabled by name with
+// NewInterceptorChain. Returns an error when the name is already registered.
+func RegisterInterceptor(name string, interceptor Interceptor) error {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+
+	if _, ok := interceptors[name]; ok {
+		return fmt.Errorf("Interceptor already registered: %s", name)
+	}
+
+	interceptors[name] = interceptor
+	return nil
+}
+
+// Returns an interceptor calling the registered interceptors in the order of
+// names, or nil when names is empty. Returns an error when an interceptor is
+// not registered.
+func NewInterceptorChain(names []string) (Interceptor, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	interceptorsMu.RLock()
+	defer interceptorsMu.RUnlock()
+
+	chain := make(interceptorChain, 0, len(names))
+	for _, name := range names {
+		interceptor, ok := interceptors[name]
+		if !ok {
+			return nil, fmt.Errorf("Interceptor not registered: %s", name)
+		}
+		chain = append(chain, interceptor)
+	}
+
+	return chain, nil
+}
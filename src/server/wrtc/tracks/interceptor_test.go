@@ -0,0 +1,106 @@
+package tracks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingWriter struct {
+	packets [][]byte
+}
+
+func (w *recordingWriter) Write(packet []byte) (int, error) {
+	w.packets = append(w.packets, append([]byte{}, packet...))
+	return len(packet), nil
+}
+
+func dropEveryOther() Interceptor {
+	var count int
+	return InterceptorFunc(func(clientID string, trackID string, packet []byte) []byte {
+		count++
+		if count%2 == 0 {
+			return nil
+		}
+		return packet
+	})
+}
+
+func TestPeer_writePacket_interceptor(t *testing.T) {
+	var intercepted []string
+	interceptor := interceptorChain{
+		InterceptorFunc(func(clientID string, trackID string, packet []byte) []byte {
+			intercepted = append(intercepted, clientID+"/"+trackID)
+			return packet
+		}),
+		dropEveryOther(),
+	}
+	p := newPeer("a", &mockPeerConnection{}, nil, nil, 0, interceptor, nil, 0, 0)
+	stats := newTrackStats("sfu_v", "video", 1234, 90000)
+	w := &recordingWriter{}
+
+	buf := make([]byte, 1400)
+	for i := byte(0); i < 6; i++ {
+		require.Nil(t, p.writePacket(w, "sfu_v", []byte{i, i}, buf, stats))
+	}
+
+	assert.Equal(t, [][]byte{{0, 0}, {2, 2}, {4, 4}}, w.packets)
+	assert.Equal(t, 6, len(intercepted))
+	assert.Equal(t, "a/sfu_v", intercepted[0])
+	assert.Equal(t, uint64(3), stats.Stats().PacketsForwarded)
+	assert.Equal(t, uint64(6), stats.Stats().BytesForwarded)
+}
+
+func TestPeer_writePacket_modify(t *testing.T) {
+	interceptor := InterceptorFunc(func(clientID string, trackID string, packet []byte) []byte {
+		return append(packet, 0xff)
+	})
+	p := newPeer("a", &mockPeerConnection{}, nil, nil, 0, interceptor, nil, 0, 0)
+	w := &recordingWriter{}
+
+	require.Nil(t, p.writePacket(w, "sfu_v", []byte{1, 2}, nil, newTrackStats("sfu_v", "video", 1234, 90000)))
+
+	assert.Equal(t, [][]byte{{1, 2, 0xff}}, w.packets)
+}
+
+func TestPeer_writePacket_passThrough(t *testing.T) {
+	p := newPeer("a", &mockPeerConnection{}, nil, nil, 0, nil, nil, 0, 0)
+	w := &recordingWriter{}
+
+	for i := byte(0); i < 3; i++ {
+		require.Nil(t, p.writePacket(w, "sfu_v", []byte{i}, nil, newTrackStats("sfu_v", "video", 1234, 90000)))
+	}
+
+	assert.Equal(t, [][]byte{{0}, {1}, {2}}, w.packets)
+}
+
+func TestNewInterceptorChain(t *testing.T) {
+	appendByte := func(b byte) Interceptor {
+		return InterceptorFunc(func(clientID string, trackID string, packet []byte) []byte {
+			return append(packet, b)
+		})
+	}
+	require.Nil(t, RegisterInterceptor("test_append_1", appendByte(1)))
+	require.Nil(t, RegisterInterceptor("test_append_2", appendByte(2)))
+	defer func() {
+		interceptorsMu.Lock()
+		delete(interceptors, "test_append_1")
+		delete(interceptors, "test_append_2")
+		interceptorsMu.Unlock()
+	}()
+
+	err := RegisterInterceptor("test_append_1", appendByte(3))
+	assert.EqualError(t, err, "Interceptor already registered: test_append_1")
+
+	chain, err := NewInterceptorChain([]string{"test_append_2", "test_append_1"})
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0, 2, 1}, chain.InterceptRTP("a", "sfu_v", []byte{0}))
+
+	chain, err = NewInterceptorChain(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, chain)
+
+	_, err = NewInterceptorChain([]string{"test_append_1", "test_missing"})
+	assert.EqualError(t, err, "Interceptor not registered: test_missing")
+}
@@ -3,22 +3,104 @@ package tracks
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/quota"
 	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const DataChannelName = "data"
 
 var log = logger.GetLogger("tracks")
 
+var (
+	transceiversGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "peercalls_transceivers",
+		Help: "Number of transceivers negotiated in all rooms",
+	})
+	transceiversRejectedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "peercalls_transceivers_rejected_total",
+		Help: "Number of transceivers rejected because of the room limit",
+	})
+)
+
 type TracksManager struct {
 	mu sync.RWMutex
 	// key is clientID
 	peers map[string]peerInRoom
 	// key is room, value is clientID
 	peerIDsByRoom map[string]map[string]struct{}
+
+	maxVideoPublishers int
+	publishersMu       sync.Mutex
+	// key is room, value is clientID
+	videoPublishersByRoom map[string]map[string]struct{}
+
+	maxTransceivers    int
+	transceiversMu     sync.Mutex
+	transceiversByRoom map[string]int
+
+	// Tracks forwarded to each client, guarded by mu. Keys are room, clientID
+	// of the subscriber and track ID.
+	subscriptionsByRoom map[string]map[string]map[string]Subscription
+
+	quota *quota.Tracker
+
+	keyframeInterval time.Duration
+	interceptor      Interceptor
+
+	lastN int
+	// Clients of each room ordered by how recently they spoke, most recent
+	// first, guarded by mu. Only the video of the first lastN is forwarded.
+	speakersByRoom map[string][]string
+	// IDs of the clients whose video each client of a room asked for, keyed
+	// by room and the ID of the asking client, guarded by mu. Their video is
+	// forwarded even when they are not among the last N speakers.
+	videoRequestsByRoom map[string]map[string][]string
+
+	reorderDepth   int
+	reorderTimeout time.Duration
+}
+
+// Subscription describes a track of another client forwarded to a client.
+type Subscription struct {
+	TrackID        string `json:"trackId"`
+	SourceClientID string `json:"sourceClientId"`
+	Kind           string `json:"kind"`
+}
+
+type Params struct {
+	// Maximum number of clients that can publish video in a room. Clients over
+	// the limit can still receive tracks. Unlimited when zero.
+	MaxVideoPublishers int
+	// Maximum number of transceivers negotiated by all peers in a room.
+	// Unlimited when zero.
+	MaxTransceivers int
+	// Accounts the bytes received from and forwarded to each client.
+	// Optional.
+	Quota *quota.Tracker
+	// Interval between keyframes requested from publishers of video tracks.
+	// Defaults to 3 seconds when zero.
+	KeyframeInterval time.Duration
+	// Processes RTP packets before they are forwarded to subscribers.
+	// Packets are forwarded unchanged when nil.
+	Interceptor Interceptor
+	// Maximum number of clients in a room whose video is forwarded, the ones
+	// that spoke most recently. Video of other clients is paused until they
+	// speak. All video is forwarded when zero.
+	LastN int
+	// Maximum number of RTP packets of a track held to forward them in order
+	// of their sequence numbers. Packets are forwarded as they arrive when
+	// zero.
+	ReorderDepth int
+	// Maximum time a packet is held while waiting for earlier ones. Defaults
+	// to 50 milliseconds when zero.
+	ReorderTimeout time.Duration
 }
 
 type Signaller interface {
@@ -26,12 +108,125 @@ type Signaller interface {
 	SendTransceiverRequest(kind webrtc.RTPCodecType, direction webrtc.RTPTransceiverDirection)
 	Negotiate()
 	CloseChannel() <-chan struct{}
+	// Returns the codecs of kind negotiated with the peer.
+	Codecs(kind webrtc.RTPCodecType) []*webrtc.RTPCodec
 }
 
 func NewTracksManager() *TracksManager {
+	return NewTracksManagerWithParams(Params{})
+}
+
+func NewTracksManagerWithParams(params Params) *TracksManager {
 	return &TracksManager{
-		peers:         map[string]peerInRoom{},
-		peerIDsByRoom: map[string]map[string]struct{}{},
+		peers:                 map[string]peerInRoom{},
+		peerIDsByRoom:         map[string]map[string]struct{}{},
+		maxVideoPublishers:    params.MaxVideoPublishers,
+		videoPublishersByRoom: map[string]map[string]struct{}{},
+		maxTransceivers:       params.MaxTransceivers,
+		transceiversByRoom:    map[string]int{},
+		subscriptionsByRoom:   map[string]map[string]map[string]Subscription{},
+		quota:                 params.Quota,
+		keyframeInterval:      params.KeyframeInterval,
+		interceptor:           params.Interceptor,
+		lastN:                 params.LastN,
+		speakersByRoom:        map[string][]string{},
+		videoRequestsByRoom:   map[string]map[string][]string{},
+		reorderDepth:          params.ReorderDepth,
+		reorderTimeout:        params.ReorderTimeout,
+	}
+}
+
+// Reserves n transceivers in the room. Returns false without reserving any
+// when the room limit would be exceeded.
+func (t *TracksManager) AddTransceivers(room string, n int) bool {
+	t.transceiversMu.Lock()
+	defer t.transceiversMu.Unlock()
+
+	count := t.transceiversByRoom[room]
+	if t.maxTransceivers > 0 && count+n > t.maxTransceivers {
+		transceiversRejectedCounter.Add(float64(n))
+		return false
+	}
+
+	t.transceiversByRoom[room] = count + n
+	transceiversGauge.Add(float64(n))
+	return true
+}
+
+// Releases n transceivers reserved with AddTransceivers.
+func (t *TracksManager) RemoveTransceivers(room string, n int) {
+	t.transceiversMu.Lock()
+	defer t.transceiversMu.Unlock()
+
+	count := t.transceiversByRoom[room] - n
+	if count > 0 {
+		t.transceiversByRoom[room] = count
+	} else {
+		delete(t.transceiversByRoom, room)
+	}
+	transceiversGauge.Sub(float64(n))
+}
+
+// Returns the number of transceivers reserved in the room.
+func (t *TracksManager) Transceivers(room string) int {
+	t.transceiversMu.Lock()
+	defer t.transceiversMu.Unlock()
+	return t.transceiversByRoom[room]
+}
+
+// Returns true when the client is allowed to publish a track of this kind
+// in the room.
+func (t *TracksManager) CanPublish(room string, clientID string, kind webrtc.RTPCodecType) bool {
+	t.publishersMu.Lock()
+	defer t.publishersMu.Unlock()
+	return t.canPublish(room, clientID, kind)
+}
+
+func (t *TracksManager) canPublish(room string, clientID string, kind webrtc.RTPCodecType) bool {
+	if kind != webrtc.RTPCodecTypeVideo || t.maxVideoPublishers <= 0 {
+		return true
+	}
+	publishers := t.videoPublishersByRoom[room]
+	if _, ok := publishers[clientID]; ok {
+		return true
+	}
+	return len(publishers) < t.maxVideoPublishers
+}
+
+// Registers the client as a video publisher when the track is a video
+// track. Returns false when the room has reached the publisher limit. A
+// client remains a publisher until it leaves the room.
+func (t *TracksManager) addPublisher(room string, clientID string, kind webrtc.RTPCodecType) bool {
+	t.publishersMu.Lock()
+	defer t.publishersMu.Unlock()
+
+	if !t.canPublish(room, clientID, kind) {
+		return false
+	}
+	if kind != webrtc.RTPCodecTypeVideo || t.maxVideoPublishers <= 0 {
+		return true
+	}
+
+	publishers, ok := t.videoPublishersByRoom[room]
+	if !ok {
+		publishers = map[string]struct{}{}
+		t.videoPublishersByRoom[room] = publishers
+	}
+	publishers[clientID] = struct{}{}
+	return true
+}
+
+func (t *TracksManager) removePublisher(room string, clientID string) {
+	t.publishersMu.Lock()
+	defer t.publishersMu.Unlock()
+
+	publishers, ok := t.videoPublishersByRoom[room]
+	if !ok {
+		return
+	}
+	delete(publishers, clientID)
+	if len(publishers) == 0 {
+		delete(t.videoPublishersByRoom, room)
 	}
 }
 
@@ -47,7 +242,7 @@ func (t *TracksManager) addTrack(room string, clientID string, track *webrtc.Tra
 
 	for otherClientID, otherPeerInRoom := range t.peers {
 		if otherClientID != clientID {
-			if err := addTrackToPeer(otherPeerInRoom, track); err != nil {
+			if err := t.addTrackToPeer(otherPeerInRoom, clientID, track); err != nil {
 				log.Printf("[%s] TracksManager.addTrack Error adding track: %s", otherClientID, err)
 				continue
 			}
@@ -85,11 +280,43 @@ func (t *TracksManager) broadcast(clientID string, msg webrtc.DataChannelMessage
 	t.mu.Unlock()
 }
 
-func addTrackToPeer(peerInRoom peerInRoom, track *webrtc.Track) error {
+// Must be called with mu locked.
+func (t *TracksManager) addTrackToPeer(peerInRoom peerInRoom, sourceClientID string, track *webrtc.Track) error {
 	peer := peerInRoom.peer
-	if err := peer.AddTrack(track); err != nil {
+
+	source, sourceOK := t.peers[sourceClientID]
+
+	var remappedTrack *webrtc.Track
+	if sourceOK {
+		var err error
+		remappedTrack, err = newRemappedTrack(track, peerInRoom.signaller.Codecs(track.Kind()))
+		if err != nil {
+			return fmt.Errorf("[%s] addTrackToPeer Error remapping payload type of track: %s: %s", peer.ClientID(), track.ID(), err)
+		}
+	}
+
+	sentTrack := track
+	if remappedTrack != nil {
+		log.Printf("[%s] addTrackToPeer Remapping payload type of track: %s from %d to %d",
+			peer.ClientID(), track.ID(), track.PayloadType(), remappedTrack.PayloadType())
+		sentTrack = remappedTrack
+	}
+
+	if err := peer.addRemappedTrack(track, sentTrack); err != nil {
 		return fmt.Errorf("[%s] addTrackToPeer Error adding track: %s: %s", peer.ClientID(), track.ID(), err)
 	}
+	t.addSubscription(peerInRoom.room, peer.ClientID(), Subscription{
+		TrackID:        track.ID(),
+		SourceClientID: sourceClientID,
+		Kind:           track.Kind().String(),
+	})
+
+	if sourceOK {
+		source.peer.addSubscriber(track.ID(), peer.ClientID(), peer.usage, remappedTrack)
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			source.peer.RequestKeyframe(track.SSRC())
+		}
+	}
 
 	kind := track.Kind()
 	signaller := peerInRoom.signaller
@@ -112,9 +339,25 @@ func (t *TracksManager) Add(
 ) (closeChannel <-chan struct{}) {
 	log.Printf("[%s] TrackManager.Add peer to room: %s", clientID, room)
 
+	var onSpeaking func()
+	if t.lastN > 0 {
+		onSpeaking = func() {
+			t.SetActiveSpeaker(room, clientID)
+		}
+	}
+
 	peer := newPeer(
 		clientID,
 		peerConnection,
+		func(kind webrtc.RTPCodecType) bool {
+			return t.addPublisher(room, clientID, kind)
+		},
+		t.quota.Open(room, clientID),
+		t.keyframeInterval,
+		t.interceptor,
+		onSpeaking,
+		t.reorderDepth,
+		t.reorderTimeout,
 	)
 
 	t.mu.Lock()
@@ -135,7 +378,7 @@ func (t *TracksManager) Add(
 		}
 		for _, track := range existingPeerInRoom.peer.Tracks() {
 			// TODO what if tracks list changes in the meantime?
-			err := addTrackToPeer(peerJoiningRoom, track)
+			err := t.addTrackToPeer(peerJoiningRoom, existingPeerClientID, track)
 			if err != nil {
 				log.Printf(
 					"Error adding peer clientID: %s track to clientID: %s - reason: %s",
@@ -149,6 +392,7 @@ func (t *TracksManager) Add(
 
 	t.peers[clientID] = peerJoiningRoom
 	peersSet[clientID] = struct{}{}
+	t.addSpeaker(room, clientID)
 
 	messagesChannel := dataTransceiver.MessagesChannel()
 	go func() {
@@ -192,8 +436,17 @@ func (t *TracksManager) removePeer(clientID string) {
 	peerLeavingRoom.peer.Close()
 	peerLeavingRoom.dataTransceiver.Close()
 	t.removePeerTracks(peerLeavingRoom)
+	t.removePublisher(peerLeavingRoom.room, clientID)
+	t.removeSubscriptions(peerLeavingRoom.room, clientID)
+	for otherClientID := range t.peerIDsByRoom[peerLeavingRoom.room] {
+		if otherPeerInRoom, ok := t.peers[otherClientID]; ok {
+			otherPeerInRoom.peer.removeSubscriberTracks(clientID)
+		}
+	}
+	peerLeavingRoom.peer.usage.Close()
 
 	delete(t.peers, clientID)
+	t.removeSpeaker(peerLeavingRoom.room, clientID)
 	peerIDs, ok := t.peerIDsByRoom[peerLeavingRoom.room]
 	if !ok {
 		log.Printf("Cannot remove peer ID from room: %s (not found)", clientID)
@@ -222,6 +475,7 @@ func (t *TracksManager) removePeerTracks(peerLeavingRoom peerInRoom) {
 					clientID,
 					leavingClientID,
 				)
+				t.removeSubscription(otherPeerInRoom.room, clientID, track.ID())
 				err := otherPeerInRoom.peer.RemoveTrack(track)
 				if err != nil {
 					log.Printf(
@@ -257,6 +511,8 @@ func (t *TracksManager) removeTrack(clientID string, track *webrtc.Track) {
 	for otherClientID := range clientIDs {
 		if otherClientID != clientID {
 			otherPeerInRoom := t.peers[otherClientID]
+			t.removeSubscription(otherPeerInRoom.room, otherClientID, track.ID())
+			peer.peer.removeSubscriber(track.ID(), otherClientID)
 			err := otherPeerInRoom.peer.RemoveTrack(track)
 			if err != nil {
 				log.Printf("[%s] removeTrack error removing track: %s", clientID, err)
@@ -265,3 +521,226 @@ func (t *TracksManager) removeTrack(clientID string, track *webrtc.Track) {
 		}
 	}
 }
+
+// Marks the client as the most recent speaker of the room. When the video
+// of only the last N speakers is forwarded, the video of the client is
+// resumed and the video of the speaker that falls out of the last N is
+// paused.
+func (t *TracksManager) SetActiveSpeaker(room string, clientID string) {
+	if t.lastN <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	speakers := t.speakersByRoom[room]
+	index := -1
+	for i, speaker := range speakers {
+		if speaker == clientID {
+			index = i
+			break
+		}
+	}
+	if index <= 0 {
+		// already the most recent speaker, or no longer in the room
+		return
+	}
+
+	copy(speakers[1:index+1], speakers[:index])
+	speakers[0] = clientID
+	t.updateVideoForwarding(room)
+}
+
+// Resumes the video of clientIDs on demand of the client, e.g. when it
+// shows them pinned, even when they are not among the last N speakers.
+// Replaces the previous request of the client, an empty list cancels it.
+func (t *TracksManager) RequestVideo(room string, clientID string, clientIDs []string) {
+	if t.lastN <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if peerInRoom, ok := t.peers[clientID]; !ok || peerInRoom.room != room {
+		return
+	}
+
+	requests := t.videoRequestsByRoom[room]
+	if len(clientIDs) == 0 {
+		delete(requests, clientID)
+		if len(requests) == 0 {
+			delete(t.videoRequestsByRoom, room)
+		}
+	} else {
+		if requests == nil {
+			requests = map[string][]string{}
+			t.videoRequestsByRoom[room] = requests
+		}
+		requests[clientID] = append([]string{}, clientIDs...)
+	}
+	t.updateVideoForwarding(room)
+}
+
+// Returns the clients of the room whose video is forwarded: the last N
+// speakers, most recent speaker first, followed by the other clients whose
+// video was requested. Returns nil when all video is forwarded.
+func (t *TracksManager) ForwardedVideo(room string) []string {
+	if t.lastN <= 0 {
+		return nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	forwarded := []string{}
+	for i, clientID := range t.speakersByRoom[room] {
+		if i < t.lastN || t.isVideoRequested(room, clientID) {
+			forwarded = append(forwarded, clientID)
+		}
+	}
+	return forwarded
+}
+
+// Must be called with mu locked.
+func (t *TracksManager) isVideoRequested(room string, clientID string) bool {
+	for requester, clientIDs := range t.videoRequestsByRoom[room] {
+		if requester == clientID {
+			continue
+		}
+		for _, requested := range clientIDs {
+			if requested == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Adds the client as the least recent speaker of the room. Must be called
+// with mu locked.
+func (t *TracksManager) addSpeaker(room string, clientID string) {
+	if t.lastN <= 0 {
+		return
+	}
+	t.speakersByRoom[room] = append(t.speakersByRoom[room], clientID)
+	t.updateVideoForwarding(room)
+}
+
+// Must be called with mu locked.
+func (t *TracksManager) removeSpeaker(room string, clientID string) {
+	if t.lastN <= 0 {
+		return
+	}
+
+	if requests := t.videoRequestsByRoom[room]; requests != nil {
+		delete(requests, clientID)
+		if len(requests) == 0 {
+			delete(t.videoRequestsByRoom, room)
+		}
+	}
+
+	speakers := t.speakersByRoom[room]
+	for i, speaker := range speakers {
+		if speaker == clientID {
+			speakers = append(speakers[:i], speakers[i+1:]...)
+			break
+		}
+	}
+	if len(speakers) == 0 {
+		delete(t.speakersByRoom, room)
+		return
+	}
+	t.speakersByRoom[room] = speakers
+	t.updateVideoForwarding(room)
+}
+
+// Pauses the video of clients that are not among the last N speakers of the
+// room and whose video was not requested, and resumes the video of the
+// others. Must be called with mu locked.
+func (t *TracksManager) updateVideoForwarding(room string) {
+	for i, clientID := range t.speakersByRoom[room] {
+		if peerInRoom, ok := t.peers[clientID]; ok {
+			peerInRoom.peer.setVideoPaused(i >= t.lastN && !t.isVideoRequested(room, clientID))
+		}
+	}
+}
+
+// Returns the tracks forwarded to each client in the room, sorted by track
+// ID. Clients without any tracks are omitted.
+func (t *TracksManager) Subscriptions(room string) map[string][]Subscription {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	subscriptionsByClientID := map[string][]Subscription{}
+	for clientID, subscriptionsByTrackID := range t.subscriptionsByRoom[room] {
+		subscriptions := make([]Subscription, 0, len(subscriptionsByTrackID))
+		for _, subscription := range subscriptionsByTrackID {
+			subscriptions = append(subscriptions, subscription)
+		}
+		sort.Slice(subscriptions, func(i, j int) bool {
+			return subscriptions[i].TrackID < subscriptions[j].TrackID
+		})
+		subscriptionsByClientID[clientID] = subscriptions
+	}
+	return subscriptionsByClientID
+}
+
+// Returns the stats of tracks published by each client in the room, sorted by
+// track ID. Clients without any tracks are omitted.
+func (t *TracksManager) TrackStats(room string) map[string][]TrackStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	statsByClientID := map[string][]TrackStats{}
+	for clientID := range t.peerIDsByRoom[room] {
+		peerInRoom, ok := t.peers[clientID]
+		if !ok {
+			continue
+		}
+		if stats := peerInRoom.peer.TrackStats(); len(stats) > 0 {
+			statsByClientID[clientID] = stats
+		}
+	}
+	return statsByClientID
+}
+
+// Must be called with mu locked.
+func (t *TracksManager) addSubscription(room string, clientID string, subscription Subscription) {
+	clients, ok := t.subscriptionsByRoom[room]
+	if !ok {
+		clients = map[string]map[string]Subscription{}
+		t.subscriptionsByRoom[room] = clients
+	}
+	subscriptions, ok := clients[clientID]
+	if !ok {
+		subscriptions = map[string]Subscription{}
+		clients[clientID] = subscriptions
+	}
+	subscriptions[subscription.TrackID] = subscription
+}
+
+// Must be called with mu locked.
+func (t *TracksManager) removeSubscription(room string, clientID string, trackID string) {
+	subscriptions, ok := t.subscriptionsByRoom[room][clientID]
+	if !ok {
+		return
+	}
+	delete(subscriptions, trackID)
+	if len(subscriptions) == 0 {
+		t.removeSubscriptions(room, clientID)
+	}
+}
+
+// Must be called with mu locked.
+func (t *TracksManager) removeSubscriptions(room string, clientID string) {
+	clients, ok := t.subscriptionsByRoom[room]
+	if !ok {
+		return
+	}
+	delete(clients, clientID)
+	if len(clients) == 0 {
+		delete(t.subscriptionsByRoom, room)
+	}
+}
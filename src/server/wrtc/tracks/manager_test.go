@@ -0,0 +1,99 @@
+package tracks
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracksManager_maxVideoPublishers(t *testing.T) {
+	video := webrtc.RTPCodecTypeVideo
+	audio := webrtc.RTPCodecTypeAudio
+	m := NewTracksManagerWithParams(Params{MaxVideoPublishers: 2})
+
+	assert.True(t, m.addPublisher("room1", "a", video))
+	assert.True(t, m.addPublisher("room1", "b", video))
+	assert.False(t, m.CanPublish("room1", "c", video))
+	assert.False(t, m.addPublisher("room1", "c", video))
+
+	// existing publishers can add more tracks and audio is not limited
+	assert.True(t, m.addPublisher("room1", "a", video))
+	assert.True(t, m.CanPublish("room1", "c", audio))
+	assert.True(t, m.addPublisher("room1", "c", audio))
+
+	// the limit is per room
+	assert.True(t, m.addPublisher("room2", "c", video))
+
+	m.removePublisher("room1", "a")
+	assert.True(t, m.CanPublish("room1", "c", video))
+	assert.True(t, m.addPublisher("room1", "c", video))
+	assert.False(t, m.addPublisher("room1", "a", video))
+}
+
+func TestTracksManager_maxVideoPublishers_unlimited(t *testing.T) {
+	m := NewTracksManager()
+
+	for _, clientID := range []string{"a", "b", "c"} {
+		assert.True(t, m.addPublisher("room1", clientID, webrtc.RTPCodecTypeVideo))
+	}
+	assert.Equal(t, 0, len(m.videoPublishersByRoom))
+}
+
+func TestTracksManager_maxTransceivers(t *testing.T) {
+	m := NewTracksManagerWithParams(Params{MaxTransceivers: 4})
+
+	assert.True(t, m.AddTransceivers("room1", 2))
+	assert.True(t, m.AddTransceivers("room1", 2))
+	assert.False(t, m.AddTransceivers("room1", 1))
+	assert.Equal(t, 4, m.Transceivers("room1"))
+
+	// the limit is per room
+	assert.True(t, m.AddTransceivers("room2", 4))
+
+	m.RemoveTransceivers("room1", 2)
+	assert.Equal(t, 2, m.Transceivers("room1"))
+	assert.True(t, m.AddTransceivers("room1", 1))
+
+	m.RemoveTransceivers("room1", 3)
+	m.RemoveTransceivers("room2", 4)
+	assert.Equal(t, 0, len(m.transceiversByRoom))
+}
+
+func TestTracksManager_subscriptions(t *testing.T) {
+	m := NewTracksManager()
+	audio := Subscription{TrackID: "sfu_a1", SourceClientID: "a", Kind: "audio"}
+	video := Subscription{TrackID: "sfu_a2", SourceClientID: "a", Kind: "video"}
+
+	m.mu.Lock()
+	m.addSubscription("room1", "b", video)
+	m.addSubscription("room1", "b", audio)
+	m.addSubscription("room1", "c", audio)
+	m.addSubscription("room2", "d", audio)
+	m.mu.Unlock()
+
+	assert.Equal(t, map[string][]Subscription{
+		"b": {audio, video},
+		"c": {audio},
+	}, m.Subscriptions("room1"))
+	assert.Equal(t, map[string][]Subscription{
+		"d": {audio},
+	}, m.Subscriptions("room2"))
+
+	m.mu.Lock()
+	m.removeSubscription("room1", "b", video.TrackID)
+	m.removeSubscription("room1", "c", audio.TrackID)
+	m.mu.Unlock()
+
+	assert.Equal(t, map[string][]Subscription{
+		"b": {audio},
+	}, m.Subscriptions("room1"))
+
+	m.mu.Lock()
+	m.removeSubscriptions("room1", "b")
+	m.removeSubscriptions("room2", "d")
+	m.mu.Unlock()
+
+	assert.Equal(t, map[string][]Subscription{}, m.Subscriptions("room1"))
+	assert.Equal(t, 0, len(m.subscriptionsByRoom))
+}
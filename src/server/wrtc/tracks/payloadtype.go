@@ -0,0 +1,68 @@
+package tracks
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// Clients choose their own dynamic payload types, so a codec published by
+// one client can have a different payload type, or its payload type can
+// belong to a different codec, in the SDP of a subscriber. Tracks forwarded
+// to such subscribers have their payload type rewritten to the one the
+// subscriber negotiated for the same codec.
+
+// Returns the payload type assigned to a codec equal to codec by codecs,
+// ignoring payload types. Codecs with the same fmtp line are preferred.
+// Later codecs take precedence because PopulateFromSDP appends the codecs of
+// every negotiation.
+func findPayloadType(codec *webrtc.RTPCodec, codecs []*webrtc.RTPCodec) (payloadType uint8, ok bool) {
+	for i := len(codecs) - 1; i >= 0; i-- {
+		c := codecs[i]
+		if !sameCodec(codec, c) {
+			continue
+		}
+		if c.SDPFmtpLine == codec.SDPFmtpLine {
+			return c.PayloadType, true
+		}
+		if !ok {
+			payloadType, ok = c.PayloadType, true
+		}
+	}
+	return payloadType, ok
+}
+
+func sameCodec(a *webrtc.RTPCodec, b *webrtc.RTPCodec) bool {
+	return a.Type == b.Type &&
+		strings.EqualFold(a.Name, b.Name) &&
+		a.ClockRate == b.ClockRate &&
+		a.Channels == b.Channels
+}
+
+// Returns a track with the same ID, label and SSRC as track that uses the
+// payload type the subscriber codecs assign to the codec of track. Returns
+// nil when the payload types are the same or when the subscriber has not
+// negotiated the codec yet.
+func newRemappedTrack(track *webrtc.Track, codecs []*webrtc.RTPCodec) (*webrtc.Track, error) {
+	codec := track.Codec()
+	if codec == nil {
+		return nil, nil
+	}
+
+	payloadType, ok := findPayloadType(codec, codecs)
+	if !ok || payloadType == track.PayloadType() {
+		return nil, nil
+	}
+
+	remappedCodec := *codec
+	remappedCodec.PayloadType = payloadType
+	return webrtc.NewTrack(payloadType, track.SSRC(), track.ID(), track.Label(), &remappedCodec)
+}
+
+// Sets the payload type in the header of an RTP packet, keeping the marker
+// bit.
+func setPayloadType(packet []byte, payloadType uint8) {
+	if len(packet) > 1 {
+		packet[1] = packet[1]&0x80 | payloadType&0x7f
+	}
+}
@@ -0,0 +1,155 @@
+package tracks
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codecsSignaller struct {
+	mockSignaller
+	codecs []*webrtc.RTPCodec
+}
+
+func (s codecsSignaller) Codecs(kind webrtc.RTPCodecType) []*webrtc.RTPCodec {
+	var codecs []*webrtc.RTPCodec
+	for _, codec := range s.codecs {
+		if codec.Type == kind {
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
+
+func TestFindPayloadType(t *testing.T) {
+	h264 := func(payloadType uint8, fmtp string) *webrtc.RTPCodec {
+		return webrtc.NewRTPH264CodecExt(payloadType, 90000, nil, fmtp)
+	}
+
+	codecs := []*webrtc.RTPCodec{
+		webrtc.NewRTPVP8Codec(100, 90000),
+		h264(102, "profile-level-id=42001f"),
+		h264(104, "profile-level-id=42e01f"),
+		webrtc.NewRTPVP8Codec(96, 90000),
+	}
+
+	pt, ok := findPayloadType(webrtc.NewRTPVP8Codec(98, 90000), codecs)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(96), pt, "codecs of later negotiations should take precedence")
+
+	pt, ok = findPayloadType(h264(96, "profile-level-id=42001f"), codecs)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(102), pt, "codecs with the same fmtp should be preferred")
+
+	pt, ok = findPayloadType(h264(96, "profile-level-id=640032"), codecs)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(104), pt)
+
+	_, ok = findPayloadType(webrtc.NewRTPVP9Codec(98, 90000), codecs)
+	assert.False(t, ok)
+
+	_, ok = findPayloadType(webrtc.NewRTPVP8Codec(96, 48000), codecs)
+	assert.False(t, ok, "clock rates should match")
+}
+
+func TestSetPayloadType(t *testing.T) {
+	packet := []byte{0x80, 0x80 | 96, 0x00, 0x01}
+	setPayloadType(packet, 98)
+	assert.Equal(t, []byte{0x80, 0x80 | 98, 0x00, 0x01}, packet, "marker bit should be kept")
+
+	packet = []byte{0x80, 96}
+	setPayloadType(packet, 100)
+	assert.Equal(t, []byte{0x80, 100}, packet)
+}
+
+func TestTracksManager_remapPayloadTypes(t *testing.T) {
+	m := NewTracksManager()
+
+	// a and b advertise the same codecs with swapped payload types, while c
+	// uses the same payload types as a
+	codecsA := []*webrtc.RTPCodec{
+		webrtc.NewRTPVP8Codec(96, 90000),
+		webrtc.NewRTPVP9Codec(98, 90000),
+		webrtc.NewRTPOpusCodec(111, 48000),
+	}
+	codecsB := []*webrtc.RTPCodec{
+		webrtc.NewRTPVP9Codec(96, 90000),
+		webrtc.NewRTPVP8Codec(98, 90000),
+		webrtc.NewRTPOpusCodec(109, 48000),
+	}
+
+	pcs := map[string]*mockPeerConnection{}
+	peers := map[string]peerInRoom{}
+	for clientID, codecs := range map[string][]*webrtc.RTPCodec{
+		"a": codecsA,
+		"b": codecsB,
+		"c": codecsA,
+	} {
+		pc := &mockPeerConnection{}
+		p := newPeer(clientID, pc, nil, nil, 0, nil, nil, 0, 0)
+		pcs[clientID] = pc
+		peers[clientID] = peerInRoom{
+			peer:            p,
+			dataTransceiver: newDataTransceiver(clientID, nil, pc),
+			room:            "room1",
+			signaller:       codecsSignaller{codecs: codecs},
+		}
+		m.peers[clientID] = peers[clientID]
+	}
+	m.peerIDsByRoom["room1"] = map[string]struct{}{"a": {}, "b": {}, "c": {}}
+
+	videoA, err := webrtc.NewTrack(96, 1111, "sfu_va", "sfu_a_v", webrtc.NewRTPVP8Codec(96, 90000))
+	require.Nil(t, err)
+	audioA, err := webrtc.NewTrack(111, 2222, "sfu_aa", "sfu_a_a", webrtc.NewRTPOpusCodec(111, 48000))
+	require.Nil(t, err)
+	videoB, err := webrtc.NewTrack(98, 3333, "sfu_vb", "sfu_b_v", webrtc.NewRTPVP8Codec(98, 90000))
+	require.Nil(t, err)
+
+	m.mu.Lock()
+	for _, clientID := range []string{"b", "c"} {
+		require.Nil(t, m.addTrackToPeer(peers[clientID], "a", videoA))
+		require.Nil(t, m.addTrackToPeer(peers[clientID], "a", audioA))
+	}
+	for _, clientID := range []string{"a", "c"} {
+		require.Nil(t, m.addTrackToPeer(peers[clientID], "b", videoB))
+	}
+	m.mu.Unlock()
+
+	type trackInfo struct {
+		ID          string
+		SSRC        uint32
+		PayloadType uint8
+		Codec       string
+	}
+	infos := func(clientID string) (infos []trackInfo) {
+		for _, track := range pcs[clientID].Tracks() {
+			infos = append(infos, trackInfo{track.ID(), track.SSRC(), track.PayloadType(), track.Codec().Name})
+		}
+		return infos
+	}
+
+	assert.Equal(t, []trackInfo{
+		{"sfu_vb", 3333, 96, webrtc.VP8},
+	}, infos("a"))
+	assert.Equal(t, []trackInfo{
+		{"sfu_va", 1111, 98, webrtc.VP8},
+		{"sfu_aa", 2222, 109, webrtc.Opus},
+	}, infos("b"))
+	assert.Equal(t, []trackInfo{
+		{"sfu_va", 1111, 96, webrtc.VP8},
+		{"sfu_aa", 2222, 111, webrtc.Opus},
+		{"sfu_vb", 3333, 96, webrtc.VP8},
+	}, infos("c"))
+
+	assert.Same(t, videoA, pcs["c"].Tracks()[0], "the local track should be shared when payload types match")
+
+	a := peers["a"].peer
+	assert.Nil(t, a.subscribersByTrackID["sfu_va"]["c"].remappedTrack)
+	assert.Equal(t, pcs["b"].Tracks()[0], a.subscribersByTrackID["sfu_va"]["b"].remappedTrack)
+
+	require.Nil(t, peers["b"].peer.RemoveTrack(videoA), "remapped tracks should be removed by the local track")
+	m.removePeer("b")
+	assert.Equal(t, 0, len(a.subscribersByTrackID["sfu_va"]))
+}
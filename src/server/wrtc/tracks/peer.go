@@ -3,16 +3,23 @@ package tracks
 import (
 	"fmt"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/jeremija/peer-calls/src/server/basen"
+	"github.com/jeremija/peer-calls/src/server/quota"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v2"
 )
 
 const (
+	// Default interval between keyframes requested from publishers of video
+	// tracks.
 	rtcpPLIInterval = time.Second * 3
+	// Delay before a keyframe is requested for a new subscriber, so that a
+	// single PLI is sent when multiple clients subscribe at the same time.
+	rtcpPLIDebounce = time.Millisecond * 200
 )
 
 type TrackEventType uint32
@@ -127,6 +134,7 @@ func (d *DataTransceiver) Send(message []byte) (err error) {
 type peer struct {
 	clientID         string
 	peerConnection   PeerConnection
+	allowTrack       func(kind webrtc.RTPCodecType) bool
 	localTracks      []*webrtc.Track
 	localTracksMu    sync.RWMutex
 	rtpSenderByTrack map[*webrtc.Track]*webrtc.RTPSender
@@ -135,17 +143,76 @@ type peer struct {
 	tracksChannelClosed bool
 	tracksChannelOnce   sync.Once
 	tracksChannelMu     sync.RWMutex
+
+	keyframeInterval time.Duration
+	interceptor      Interceptor
+	// Called when the peer is speaking. Speech is not detected when nil.
+	onSpeaking       func()
+	pliDebounce      time.Duration
+	pliMu            sync.Mutex
+	pendingPLIBySSRC map[uint32]struct{}
+
+	// Packets of each track are reordered by sequence number before they are
+	// forwarded when reorderDepth is greater than zero.
+	reorderDepth   int
+	reorderTimeout time.Duration
+
+	// Video is not forwarded while the peer is not among the last N speakers
+	// of the room.
+	videoPausedMu sync.RWMutex
+	videoPaused   bool
+
+	// Stats of tracks that are currently being forwarded, keyed by the ID of
+	// the local track.
+	statsMu        sync.RWMutex
+	statsByTrackID map[string]*trackStats
+
+	// Accounts the bytes received from this peer.
+	usage *quota.Counter
+	// Subscribers of tracks published by this peer, keyed by the ID of the
+	// local track and clientID of the subscriber.
+	subscribersMu        sync.RWMutex
+	subscribersByTrackID map[string]map[string]subscriber
+}
+
+type subscriber struct {
+	// Accounts the bytes forwarded to the subscriber.
+	usage *quota.Counter
+	// Forwards the track with the payload type negotiated by the subscriber.
+	// Nil when the subscriber receives the local track.
+	remappedTrack *webrtc.Track
 }
 
 func newPeer(
 	clientID string,
 	peerConnection PeerConnection,
+	allowTrack func(kind webrtc.RTPCodecType) bool,
+	usage *quota.Counter,
+	keyframeInterval time.Duration,
+	interceptor Interceptor,
+	onSpeaking func(),
+	reorderDepth int,
+	reorderTimeout time.Duration,
 ) *peer {
+	if keyframeInterval <= 0 {
+		keyframeInterval = rtcpPLIInterval
+	}
 	p := &peer{
-		clientID:         clientID,
-		peerConnection:   peerConnection,
-		rtpSenderByTrack: map[*webrtc.Track]*webrtc.RTPSender{},
-		tracksChannel:    make(chan TrackEvent),
+		clientID:             clientID,
+		peerConnection:       peerConnection,
+		allowTrack:           allowTrack,
+		rtpSenderByTrack:     map[*webrtc.Track]*webrtc.RTPSender{},
+		tracksChannel:        make(chan TrackEvent),
+		keyframeInterval:     keyframeInterval,
+		interceptor:          interceptor,
+		onSpeaking:           onSpeaking,
+		reorderDepth:         reorderDepth,
+		reorderTimeout:       reorderTimeout,
+		pliDebounce:          rtcpPLIDebounce,
+		pendingPLIBySSRC:     map[uint32]struct{}{},
+		statsByTrackID:       map[string]*trackStats{},
+		usage:                usage,
+		subscribersByTrackID: map[string]map[string]subscriber{},
 	}
 
 	log.Printf("[%s] Setting PeerConnection.OnTrack listener", clientID)
@@ -174,11 +241,18 @@ func (p *peer) ClientID() string {
 }
 
 func (p *peer) AddTrack(track *webrtc.Track) error {
+	return p.addRemappedTrack(track, track)
+}
+
+// Adds remappedTrack to the peer connection in place of track, so that
+// RemoveTrack can be called with track.
+func (p *peer) addRemappedTrack(track *webrtc.Track, remappedTrack *webrtc.Track) error {
 	p.localTracksMu.Lock()
 	defer p.localTracksMu.Unlock()
 
-	log.Printf("[%s] peer.AddTrack: add sendonly transceiver for track: %s", p.clientID, track.ID())
-	rtpSender, err := p.peerConnection.AddTrack(track)
+	log.Printf("[%s] peer.AddTrack: add sendonly transceiver for track: %s, payload type: %d",
+		p.clientID, track.ID(), remappedTrack.PayloadType())
+	rtpSender, err := p.peerConnection.AddTrack(remappedTrack)
 	// t, err := p.peerConnection.AddTransceiverFromTrack(
 	// 	track,
 	// 	webrtc.RtpTransceiverInit{
@@ -210,6 +284,11 @@ func (p *peer) RemoveTrack(track *webrtc.Track) error {
 func (p *peer) handleTrack(remoteTrack *webrtc.Track, receiver *webrtc.RTPReceiver) {
 	log.Printf("[%s] peer.handleTrack (id: %s, label: %s, type: %s, ssrc: %d)",
 		p.clientID, remoteTrack.ID(), remoteTrack.Label(), remoteTrack.Kind(), remoteTrack.SSRC())
+	if p.allowTrack != nil && !p.allowTrack(remoteTrack.Kind()) {
+		log.Printf("[%s] peer.handleTrack: not allowed to publish %s, ignoring track: %s",
+			p.clientID, remoteTrack.Kind(), remoteTrack.ID())
+		return
+	}
 	localTrack, err := p.startCopyingTrack(remoteTrack)
 	if err != nil {
 		log.Printf("Error copying remote track: %s", err)
@@ -227,6 +306,220 @@ func (p *peer) Tracks() []*webrtc.Track {
 	return p.localTracks
 }
 
+// Pauses or resumes forwarding the video tracks of the peer. A keyframe is
+// requested when video is resumed, so that subscribers do not have to wait
+// for the next periodic one.
+func (p *peer) setVideoPaused(paused bool) {
+	p.videoPausedMu.Lock()
+	resumed := p.videoPaused && !paused
+	p.videoPaused = paused
+	p.videoPausedMu.Unlock()
+
+	if !resumed {
+		return
+	}
+
+	log.Printf("[%s] peer.setVideoPaused: resuming video", p.clientID)
+	p.localTracksMu.RLock()
+	defer p.localTracksMu.RUnlock()
+	for _, track := range p.localTracks {
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			p.RequestKeyframe(track.SSRC())
+		}
+	}
+}
+
+func (p *peer) isVideoPaused() bool {
+	p.videoPausedMu.RLock()
+	defer p.videoPausedMu.RUnlock()
+	return p.videoPaused
+}
+
+// Returns the stats of tracks published by this peer, sorted by track ID.
+func (p *peer) TrackStats() []TrackStats {
+	p.statsMu.RLock()
+	defer p.statsMu.RUnlock()
+
+	stats := make([]TrackStats, 0, len(p.statsByTrackID))
+	for _, s := range p.statsByTrackID {
+		stats = append(stats, s.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].TrackID < stats[j].TrackID
+	})
+	return stats
+}
+
+func (p *peer) addTrackStats(trackID string, stats *trackStats) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.statsByTrackID[trackID] = stats
+}
+
+func (p *peer) removeTrackStats(trackID string) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	delete(p.statsByTrackID, trackID)
+}
+
+// Accounts the bytes of the track forwarded to the subscriber, and forwards
+// the track to remappedTrack when it is not nil.
+func (p *peer) addSubscriber(trackID string, clientID string, usage *quota.Counter, remappedTrack *webrtc.Track) {
+	if usage == nil && remappedTrack == nil {
+		return
+	}
+
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+
+	subscribers, ok := p.subscribersByTrackID[trackID]
+	if !ok {
+		subscribers = map[string]subscriber{}
+		p.subscribersByTrackID[trackID] = subscribers
+	}
+	subscribers[clientID] = subscriber{usage, remappedTrack}
+}
+
+func (p *peer) removeSubscriber(trackID string, clientID string) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+
+	subscribers := p.subscribersByTrackID[trackID]
+	delete(subscribers, clientID)
+	if len(subscribers) == 0 {
+		delete(p.subscribersByTrackID, trackID)
+	}
+}
+
+// Removes the subscriber from all tracks.
+func (p *peer) removeSubscriberTracks(clientID string) {
+	p.subscribersMu.RLock()
+	trackIDs := make([]string, 0, len(p.subscribersByTrackID))
+	for trackID := range p.subscribersByTrackID {
+		trackIDs = append(trackIDs, trackID)
+	}
+	p.subscribersMu.RUnlock()
+
+	for _, trackID := range trackIDs {
+		p.removeSubscriber(trackID, clientID)
+	}
+}
+
+func (p *peer) removeSubscribers(trackID string) {
+	p.subscribersMu.Lock()
+	defer p.subscribersMu.Unlock()
+	delete(p.subscribersByTrackID, trackID)
+}
+
+// Writes the packet to the remapped tracks of subscribers and accounts the
+// bytes forwarded to each subscriber. Subscribers of the local track are only
+// accounted when sent is true. Uses buf to rewrite the payload type. Returns
+// true when the packet was forwarded to at least one subscriber.
+func (p *peer) forward(trackID string, packet []byte, buf []byte, sent bool) bool {
+	p.subscribersMu.RLock()
+	defer p.subscribersMu.RUnlock()
+
+	if len(packet) > len(buf) {
+		// interceptors can return packets larger than the read buffer
+		buf = make([]byte, len(packet))
+	}
+
+	forwarded := sent
+	for clientID, s := range p.subscribersByTrackID[trackID] {
+		if s.remappedTrack == nil {
+			if sent {
+				s.usage.AddSent(len(packet))
+			}
+			continue
+		}
+
+		n := copy(buf, packet)
+		setPayloadType(buf[:n], s.remappedTrack.PayloadType())
+		// ErrClosedPipe means the track has not been negotiated yet
+		if _, err := s.remappedTrack.Write(buf[:n]); err != nil {
+			if err != io.ErrClosedPipe {
+				log.Printf("[%s] Error writing to remapped track: %s of subscriber: %s: %s", p.clientID, trackID, clientID, err)
+			}
+			continue
+		}
+		s.usage.AddSent(n)
+		forwarded = true
+	}
+	return forwarded
+}
+
+// Passes the packet read from the remote track through the interceptor and
+// writes it to the local track and the remapped tracks of subscribers.
+// Dropped packets are not forwarded.
+func (p *peer) writePacket(localTrack io.Writer, trackID string, packet []byte, buf []byte, stats *trackStats) error {
+	if p.interceptor != nil {
+		if packet = p.interceptor.InterceptRTP(p.clientID, trackID, packet); packet == nil {
+			return nil
+		}
+	}
+
+	// ErrClosedPipe means we don't have any subscribers, this is ok if no peers have connected yet
+	_, err := localTrack.Write(packet)
+	if err != nil && err != io.ErrClosedPipe {
+		return err
+	}
+	if p.forward(trackID, packet, buf, err == nil) {
+		stats.forward(len(packet))
+	}
+	return nil
+}
+
+func (p *peer) writePLI(ssrc uint32) {
+	err := p.peerConnection.WriteRTCP(
+		[]rtcp.Packet{
+			&rtcp.PictureLossIndication{
+				MediaSSRC: ssrc,
+			},
+		},
+	)
+	if err != nil {
+		log.Printf("[%s] Error sending rtcp PLI for ssrc: %d: %s", p.clientID, ssrc, err)
+	}
+}
+
+// Sends a PLI right away and then every keyframeInterval until done is
+// closed.
+func (p *peer) requestKeyframes(ssrc uint32, done <-chan struct{}) {
+	ticker := time.NewTicker(p.keyframeInterval)
+	defer ticker.Stop()
+
+	p.writePLI(ssrc)
+	for {
+		select {
+		case <-ticker.C:
+			p.writePLI(ssrc)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Asks the publisher for a keyframe so that a new subscriber does not have to
+// wait for the next periodic PLI. Requests made within the debounce delay
+// result in a single PLI.
+func (p *peer) RequestKeyframe(ssrc uint32) {
+	p.pliMu.Lock()
+	defer p.pliMu.Unlock()
+
+	if _, ok := p.pendingPLIBySSRC[ssrc]; ok {
+		return
+	}
+	p.pendingPLIBySSRC[ssrc] = struct{}{}
+
+	time.AfterFunc(p.pliDebounce, func() {
+		p.pliMu.Lock()
+		delete(p.pendingPLIBySSRC, ssrc)
+		p.pliMu.Unlock()
+
+		p.writePLI(ssrc)
+	})
+}
+
 func (p *peer) startCopyingTrack(remoteTrack *webrtc.Track) (*webrtc.Track, error) {
 	remoteTrackID := remoteTrack.ID()
 	if remoteTrackID == "" {
@@ -253,36 +546,35 @@ func (p *peer) startCopyingTrack(remoteTrack *webrtc.Track) (*webrtc.Track, erro
 		return nil, err
 	}
 
-	// Send a PLI on an interval so that the publisher is pushing a keyframe every rtcpPLIInterval
+	// Send a PLI on an interval so that the publisher is pushing a keyframe every keyframeInterval
 	// This can be less wasteful by processing incoming RTCP events, then we would emit a NACK/PLI when a viewer requests it
+	trackDone := make(chan struct{})
+	if remoteTrack.Kind() == webrtc.RTPCodecTypeVideo {
+		go p.requestKeyframes(ssrc, trackDone)
+	}
 
-	ticker := time.NewTicker(rtcpPLIInterval)
-	go func() {
-		writeRTCP := func() {
-			err := p.peerConnection.WriteRTCP(
-				[]rtcp.Packet{
-					&rtcp.PictureLossIndication{
-						MediaSSRC: ssrc,
-					},
-				},
-			)
-			if err != nil {
-				log.Printf("[%s] Error sending rtcp PLI for local track: %s: %s",
-					p.clientID,
-					localTrackID,
-					err,
-				)
-			}
-		}
+	var clockRate uint32
+	if codec := remoteTrack.Codec(); codec != nil {
+		clockRate = codec.ClockRate
+	}
+	stats := newTrackStats(localTrackID, remoteTrack.Kind().String(), ssrc, clockRate)
+	p.addTrackStats(localTrackID, stats)
 
-		writeRTCP()
-		for range ticker.C {
-			writeRTCP()
-		}
-	}()
+	isVideo := remoteTrack.Kind() == webrtc.RTPCodecTypeVideo
+	var detector *speakerDetector
+	if remoteTrack.Kind() == webrtc.RTPCodecTypeAudio && p.onSpeaking != nil {
+		detector = &speakerDetector{}
+	}
+
+	var reorder *reorderBuffer
+	if p.reorderDepth > 0 {
+		reorder = newReorderBuffer(p.reorderDepth, p.reorderTimeout)
+	}
 
 	go func() {
-		defer ticker.Stop()
+		defer close(trackDone)
+		defer p.removeTrackStats(localTrackID)
+		defer p.removeSubscribers(localTrackID)
 		defer func() {
 			p.tracksChannelMu.RLock()
 			if !p.tracksChannelClosed {
@@ -291,6 +583,7 @@ func (p *peer) startCopyingTrack(remoteTrack *webrtc.Track) (*webrtc.Track, erro
 			p.tracksChannelMu.RUnlock()
 		}()
 		rtpBuf := make([]byte, 1400)
+		remapBuf := make([]byte, len(rtpBuf))
 		for {
 			i, err := remoteTrack.Read(rtpBuf)
 			if err != nil {
@@ -302,16 +595,32 @@ func (p *peer) startCopyingTrack(remoteTrack *webrtc.Track) (*webrtc.Track, erro
 				)
 				return
 			}
+			now := time.Now()
+			stats.receive(rtpBuf[:i], now)
+			p.usage.AddReceived(i)
 
-			// ErrClosedPipe means we don't have any subscribers, this is ok if no peers have connected yet
-			if _, err = localTrack.Write(rtpBuf[:i]); err != nil && err != io.ErrClosedPipe {
-				log.Printf(
-					"[%s] Error writing to local track: %s: %s",
-					p.clientID,
-					localTrackID,
-					err,
-				)
-				return
+			if detector != nil && detector.receive(rtpBuf[:i], now) {
+				p.onSpeaking()
+			}
+			if isVideo && p.isVideoPaused() {
+				continue
+			}
+
+			packets := [][]byte{rtpBuf[:i]}
+			if reorder != nil {
+				packets = reorder.push(rtpBuf[:i], now)
+			}
+
+			for _, packet := range packets {
+				if err := p.writePacket(localTrack, localTrackID, packet, remapBuf, stats); err != nil {
+					log.Printf(
+						"[%s] Error writing to local track: %s: %s",
+						p.clientID,
+						localTrackID,
+						err,
+					)
+					return
+				}
 			}
 		}
 	}()
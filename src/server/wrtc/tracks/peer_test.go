@@ -0,0 +1,201 @@
+package tracks
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/quota"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPeerConnection struct {
+	mu      sync.Mutex
+	packets []rtcp.Packet
+	tracks  []*webrtc.Track
+}
+
+func (m *mockPeerConnection) AddTrack(track *webrtc.Track) (*webrtc.RTPSender, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tracks = append(m.tracks, track)
+	return nil, nil
+}
+
+func (m *mockPeerConnection) AddTransceiverFromTrack(track *webrtc.Track, init ...webrtc.RtpTransceiverInit) (*webrtc.RTPTransceiver, error) {
+	return nil, nil
+}
+
+func (m *mockPeerConnection) RemoveTrack(*webrtc.RTPSender) error {
+	return nil
+}
+
+func (m *mockPeerConnection) OnTrack(func(*webrtc.Track, *webrtc.RTPReceiver)) {}
+
+func (m *mockPeerConnection) WriteRTCP(packets []rtcp.Packet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packets = append(m.packets, packets...)
+	return nil
+}
+
+func (m *mockPeerConnection) NewTrack(uint8, uint32, string, string) (*webrtc.Track, error) {
+	return nil, nil
+}
+
+func (m *mockPeerConnection) OnDataChannel(func(*webrtc.DataChannel)) {}
+
+func (m *mockPeerConnection) Tracks() []*webrtc.Track {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*webrtc.Track{}, m.tracks...)
+}
+
+func (m *mockPeerConnection) Packets() []rtcp.Packet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]rtcp.Packet{}, m.packets...)
+}
+
+type mockSignaller struct{}
+
+func (mockSignaller) Initiator() bool { return true }
+
+func (mockSignaller) SendTransceiverRequest(webrtc.RTPCodecType, webrtc.RTPTransceiverDirection) {}
+
+func (mockSignaller) Negotiate() {}
+
+func (mockSignaller) CloseChannel() <-chan struct{} { return nil }
+
+func (mockSignaller) Codecs(webrtc.RTPCodecType) []*webrtc.RTPCodec { return nil }
+
+func newTestPeerInRoom(room string, clientID string, pc PeerConnection) peerInRoom {
+	p := newPeer(clientID, pc, nil, nil, 0, nil, nil, 0, 0)
+	p.pliDebounce = 10 * time.Millisecond
+	return peerInRoom{peer: p, room: room, signaller: mockSignaller{}}
+}
+
+func TestTracksManager_addTrackToPeer_keyframe(t *testing.T) {
+	m := NewTracksManager()
+	sourcePC := &mockPeerConnection{}
+	source := newTestPeerInRoom("room1", "a", sourcePC)
+	m.peers["a"] = source
+
+	video, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1234, "sfu_v", "sfu_a_v", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.Nil(t, err)
+	audio, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 5678, "sfu_a", "sfu_a_a", webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.Nil(t, err)
+
+	m.mu.Lock()
+	for _, clientID := range []string{"b", "c"} {
+		subscriber := newTestPeerInRoom("room1", clientID, &mockPeerConnection{})
+		require.Nil(t, m.addTrackToPeer(subscriber, "a", video))
+		require.Nil(t, m.addTrackToPeer(subscriber, "a", audio))
+	}
+	m.mu.Unlock()
+
+	assert.Equal(t, 0, len(sourcePC.Packets()), "PLI should be debounced")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: 1234},
+	}, sourcePC.Packets())
+}
+
+func TestTracksManager_quota(t *testing.T) {
+	tracker := quota.NewTracker(quota.Params{Bytes: 1000})
+	m := NewTracksManagerWithParams(Params{Quota: tracker})
+
+	newPeerInRoom := func(clientID string) peerInRoom {
+		pc := &mockPeerConnection{}
+		p := newPeer(clientID, pc, nil, tracker.Open("room1", clientID), 0, nil, nil, 0, 0)
+		peerInRoom := peerInRoom{
+			peer:            p,
+			dataTransceiver: newDataTransceiver(clientID, nil, pc),
+			room:            "room1",
+			signaller:       mockSignaller{},
+		}
+		m.peers[clientID] = peerInRoom
+		m.peerIDsByRoom["room1"] = map[string]struct{}{"a": {}, "b": {}, "c": {}}
+		return peerInRoom
+	}
+
+	source := newPeerInRoom("a")
+	b := newPeerInRoom("b")
+	c := newPeerInRoom("c")
+
+	audio, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 5678, "sfu_a", "sfu_a_a", webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.Nil(t, err)
+
+	m.mu.Lock()
+	require.Nil(t, m.addTrackToPeer(b, "a", audio))
+	require.Nil(t, m.addTrackToPeer(c, "a", audio))
+	m.mu.Unlock()
+
+	source.peer.usage.AddReceived(600)
+	source.peer.forward(audio.ID(), make([]byte, 600), make([]byte, 1400), true)
+	assert.Equal(t, map[string]quota.Usage{
+		"a": {BytesReceived: 600},
+		"b": {BytesSent: 600},
+		"c": {BytesSent: 600},
+	}, tracker.Usage("room1"))
+
+	m.removePeer("c")
+	source.peer.forward(audio.ID(), make([]byte, 600), make([]byte, 1400), true)
+
+	select {
+	case <-b.peer.usage.Exceeded():
+	default:
+		t.Fatal("expected subscriber to exceed the quota")
+	}
+	assert.Equal(t, map[string]quota.Usage{
+		"a": {BytesReceived: 600},
+		"b": {BytesSent: 1200, Exceeded: true},
+	}, tracker.Usage("room1"), "bytes should no longer be accounted to removed subscribers")
+}
+
+func countPLIs(pc *mockPeerConnection, ssrc uint32) (count int) {
+	for _, packet := range pc.Packets() {
+		if pli, ok := packet.(*rtcp.PictureLossIndication); ok && pli.MediaSSRC == ssrc {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPeer_requestKeyframes(t *testing.T) {
+	pc := &mockPeerConnection{}
+	p := newPeer("a", pc, nil, nil, 20*time.Millisecond, nil, nil, 0, 0)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		p.requestKeyframes(1234, done)
+		close(stopped)
+	}()
+
+	time.Sleep(110 * time.Millisecond)
+	close(done)
+	<-stopped
+
+	// one right away and one every 20ms
+	count := countPLIs(pc, 1234)
+	assert.GreaterOrEqual(t, count, 4)
+	assert.LessOrEqual(t, count, 7)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, count, countPLIs(pc, 1234), "PLIs should stop when the track ends")
+}
+
+func TestTracksManager_keyframeInterval(t *testing.T) {
+	assert.Equal(t, rtcpPLIInterval, newPeer("a", &mockPeerConnection{}, nil, nil, 0, nil, nil, 0, 0).keyframeInterval)
+
+	m := NewTracksManagerWithParams(Params{KeyframeInterval: time.Second})
+	m.Add("room1", "a", &mockPeerConnection{}, nil, mockSignaller{})
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	assert.Equal(t, time.Second, m.peers["a"].peer.keyframeInterval)
+}
@@ -0,0 +1,118 @@
+package tracks
+
+import (
+	"time"
+)
+
+// DefaultQualityInterval is the default interval between connection quality
+// estimates.
+const DefaultQualityInterval = 5 * time.Second
+
+// Quality is a coarse estimate of the connection quality of a client,
+// derived from the loss and jitter of the tracks it publishes.
+type Quality string
+
+const (
+	QualityGood Quality = "good"
+	QualityFair Quality = "fair"
+	QualityPoor Quality = "poor"
+)
+
+const (
+	qualityFairLoss   = 0.02
+	qualityPoorLoss   = 0.1
+	qualityFairJitter = 0.03
+	qualityPoorJitter = 0.1
+	// Number of consecutive estimates that must agree before a change is
+	// reported.
+	qualityStableEstimates = 2
+)
+
+// QualityEstimator estimates the connection quality of a single client from
+// periodic snapshots of the stats of its tracks. Changes are debounced so
+// that a short burst of loss does not cause an update.
+type QualityEstimator struct {
+	lastStatsByTrackID map[string]TrackStats
+
+	reported  Quality
+	candidate Quality
+	count     int
+}
+
+func NewQualityEstimator() *QualityEstimator {
+	return &QualityEstimator{
+		lastStatsByTrackID: map[string]TrackStats{},
+	}
+}
+
+// Updates the estimate with the current stats of the tracks published by the
+// client. Returns the new quality and true when it changed significantly
+// since the last reported quality. The first estimate is always reported.
+func (e *QualityEstimator) Update(stats []TrackStats) (Quality, bool) {
+	var received, lost uint64
+	var jitter float64
+
+	statsByTrackID := make(map[string]TrackStats, len(stats))
+	for _, s := range stats {
+		last := e.lastStatsByTrackID[s.TrackID]
+		received += counterDelta(s.PacketsReceived, last.PacketsReceived)
+		lost += counterDelta(s.PacketsLost, last.PacketsLost)
+		if s.Jitter > jitter {
+			jitter = s.Jitter
+		}
+		statsByTrackID[s.TrackID] = s
+	}
+	e.lastStatsByTrackID = statsByTrackID
+
+	if received == 0 {
+		// nothing was published since the last update, so there is nothing
+		// to base an estimate on.
+		return e.reported, false
+	}
+
+	quality := estimateQuality(float64(lost)/float64(received+lost), jitter)
+
+	if e.reported == "" {
+		e.reported = quality
+		return quality, true
+	}
+
+	if quality == e.reported {
+		e.count = 0
+		return quality, false
+	}
+
+	if quality != e.candidate {
+		e.candidate = quality
+		e.count = 0
+	}
+	e.count++
+
+	if e.count < qualityStableEstimates {
+		return e.reported, false
+	}
+
+	e.reported = quality
+	e.count = 0
+	return quality, true
+}
+
+func estimateQuality(loss float64, jitter float64) Quality {
+	switch {
+	case loss >= qualityPoorLoss, jitter >= qualityPoorJitter:
+		return QualityPoor
+	case loss >= qualityFairLoss, jitter >= qualityFairJitter:
+		return QualityFair
+	default:
+		return QualityGood
+	}
+}
+
+// Returns the increase of a counter, or zero when it decreased. The number of
+// lost packets decreases when packets arrive out of order.
+func counterDelta(value uint64, last uint64) uint64 {
+	if value < last {
+		return 0
+	}
+	return value - last
+}
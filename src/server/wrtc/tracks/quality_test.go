@@ -0,0 +1,73 @@
+package tracks_test
+
+import (
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/wrtc/tracks"
+	"github.com/stretchr/testify/assert"
+)
+
+// Accumulates the stats of a track that receives 100 packets per interval.
+type syntheticTrack struct {
+	stats tracks.TrackStats
+}
+
+func (s *syntheticTrack) next(lost uint64, jitter float64) []tracks.TrackStats {
+	s.stats.TrackID = "sfu_v"
+	s.stats.PacketsReceived += 100 - lost
+	s.stats.PacketsLost += lost
+	s.stats.Jitter = jitter
+	return []tracks.TrackStats{s.stats}
+}
+
+type qualityUpdate struct {
+	quality tracks.Quality
+	changed bool
+}
+
+func update(e *tracks.QualityEstimator, stats []tracks.TrackStats) qualityUpdate {
+	quality, changed := e.Update(stats)
+	return qualityUpdate{quality, changed}
+}
+
+func TestQualityEstimator_loss(t *testing.T) {
+	e := tracks.NewQualityEstimator()
+	track := &syntheticTrack{}
+
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, true}, update(e, track.next(0, 0)))
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, false}, update(e, track.next(1, 0)))
+
+	// a single interval of loss is not reported
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, false}, update(e, track.next(20, 0)))
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, false}, update(e, track.next(0, 0)))
+
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, false}, update(e, track.next(20, 0)))
+	assert.Equal(t, qualityUpdate{tracks.QualityPoor, true}, update(e, track.next(20, 0)))
+	assert.Equal(t, qualityUpdate{tracks.QualityPoor, false}, update(e, track.next(20, 0)))
+
+	assert.Equal(t, qualityUpdate{tracks.QualityPoor, false}, update(e, track.next(5, 0)))
+	assert.Equal(t, qualityUpdate{tracks.QualityFair, true}, update(e, track.next(5, 0)))
+
+	assert.Equal(t, qualityUpdate{tracks.QualityFair, false}, update(e, track.next(0, 0)))
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, true}, update(e, track.next(0, 0)))
+}
+
+func TestQualityEstimator_jitter(t *testing.T) {
+	e := tracks.NewQualityEstimator()
+	track := &syntheticTrack{}
+
+	assert.Equal(t, qualityUpdate{tracks.QualityFair, true}, update(e, track.next(0, 0.05)))
+	assert.Equal(t, qualityUpdate{tracks.QualityFair, false}, update(e, track.next(0, 0.2)))
+	assert.Equal(t, qualityUpdate{tracks.QualityPoor, true}, update(e, track.next(0, 0.2)))
+}
+
+func TestQualityEstimator_noPackets(t *testing.T) {
+	e := tracks.NewQualityEstimator()
+	track := &syntheticTrack{}
+
+	assert.Equal(t, qualityUpdate{"", false}, update(e, nil))
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, true}, update(e, track.next(0, 0)))
+	// the stats are unchanged when the client stops publishing
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, false}, update(e, []tracks.TrackStats{track.stats}))
+	assert.Equal(t, qualityUpdate{tracks.QualityGood, false}, update(e, []tracks.TrackStats{track.stats}))
+}
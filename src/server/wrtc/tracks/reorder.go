@@ -0,0 +1,161 @@
+package tracks
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Maximum time a packet is held while waiting for earlier packets when no
+	// timeout is configured.
+	defaultReorderTimeout = 50 * time.Millisecond
+	// Packets this far behind the next expected sequence number are assumed
+	// to belong to a restarted stream instead of being late.
+	reorderResetDistance = 1000
+)
+
+var reorderDroppedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "peercalls_rtp_reorder_dropped_total",
+	Help: "Number of RTP packets dropped by the SFU because they arrived too late to be forwarded in order",
+})
+
+// Holds RTP packets that arrive out of order so they can be forwarded in
+// order of their sequence numbers. When a packet is missing, later packets
+// are held until depth packets are waiting or the oldest one has waited for
+// longer than timeout, and are then forwarded without the missing ones.
+// Packets that arrive after later ones have been forwarded are dropped.
+// Timeouts are only checked when packets arrive.
+type reorderBuffer struct {
+	depth   int
+	timeout time.Duration
+
+	started bool
+	// Sequence number of the next packet to forward.
+	next    uint16
+	packets map[uint16]reorderedPacket
+}
+
+type reorderedPacket struct {
+	data    []byte
+	arrival time.Time
+}
+
+func newReorderBuffer(depth int, timeout time.Duration) *reorderBuffer {
+	if timeout <= 0 {
+		timeout = defaultReorderTimeout
+	}
+	return &reorderBuffer{
+		depth:   depth,
+		timeout: timeout,
+		packets: map[uint16]reorderedPacket{},
+	}
+}
+
+// Adds a packet read from the publisher. Returns the packets that can be
+// forwarded, in order. Packets with invalid RTP headers are returned right
+// away. The packet is copied when it has to be held, so the caller can reuse
+// it after the returned packets have been forwarded.
+func (b *reorderBuffer) push(packet []byte, arrival time.Time) [][]byte {
+	var header rtp.Header
+	if err := header.Unmarshal(packet); err != nil {
+		return [][]byte{packet}
+	}
+	seq := header.SequenceNumber
+
+	if !b.started {
+		b.started = true
+		b.next = seq
+	}
+
+	var out [][]byte
+
+	if distance := int16(seq - b.next); distance < 0 {
+		if -int(distance) <= reorderResetDistance {
+			reorderDroppedCounter.Inc()
+			return nil
+		}
+		out = b.flush(out)
+		b.next = seq
+	}
+
+	if seq == b.next && len(b.packets) == 0 {
+		b.next++
+		return append(out, packet)
+	}
+
+	if _, ok := b.packets[seq]; ok {
+		// duplicate
+		return out
+	}
+
+	data := make([]byte, len(packet))
+	copy(data, packet)
+	b.packets[seq] = reorderedPacket{data, arrival}
+
+	return b.pop(out, arrival)
+}
+
+// Appends the packets that are next in order to out. Skips missing packets
+// when the buffer is full or the oldest packet has waited for too long.
+func (b *reorderBuffer) pop(out [][]byte, now time.Time) [][]byte {
+	for {
+		out = b.popConsecutive(out)
+
+		if len(b.packets) == 0 {
+			return out
+		}
+
+		if len(b.packets) <= b.depth && now.Sub(b.oldestArrival()) < b.timeout {
+			return out
+		}
+
+		b.next = b.lowest()
+	}
+}
+
+// Appends all held packets to out in order and empties the buffer.
+func (b *reorderBuffer) flush(out [][]byte) [][]byte {
+	for len(b.packets) > 0 {
+		b.next = b.lowest()
+		out = b.popConsecutive(out)
+	}
+	return out
+}
+
+// Appends the held packets starting with next and without gaps to out.
+func (b *reorderBuffer) popConsecutive(out [][]byte) [][]byte {
+	for {
+		packet, ok := b.packets[b.next]
+		if !ok {
+			return out
+		}
+		out = append(out, packet.data)
+		delete(b.packets, b.next)
+		b.next++
+	}
+}
+
+// Returns the first held sequence number after next.
+func (b *reorderBuffer) lowest() uint16 {
+	lowest := b.next
+	min := -1
+	for seq := range b.packets {
+		if distance := int(seq - b.next); min < 0 || distance < min {
+			min = distance
+			lowest = seq
+		}
+	}
+	return lowest
+}
+
+func (b *reorderBuffer) oldestArrival() (oldest time.Time) {
+	for _, packet := range b.packets {
+		if oldest.IsZero() || packet.arrival.Before(oldest) {
+			oldest = packet.arrival
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,110 @@
+package tracks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRTPPacket(t *testing.T, seq uint16) []byte {
+	t.Helper()
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			SequenceNumber: seq,
+			SSRC:           1234,
+		},
+		Payload: []byte{byte(seq)},
+	}
+	data, err := packet.Marshal()
+	require.Nil(t, err)
+	return data
+}
+
+func sequenceNumbers(t *testing.T, packets [][]byte) []uint16 {
+	t.Helper()
+	seqs := []uint16{}
+	for _, packet := range packets {
+		var header rtp.Header
+		require.Nil(t, header.Unmarshal(packet))
+		seqs = append(seqs, header.SequenceNumber)
+	}
+	return seqs
+}
+
+func TestReorderBuffer_shuffled(t *testing.T) {
+	b := newReorderBuffer(5, time.Second)
+	now := time.Now()
+
+	var out [][]byte
+	for _, seq := range []uint16{10, 12, 11, 15, 13, 14, 17, 16, 18} {
+		out = append(out, b.push(newRTPPacket(t, seq), now)...)
+	}
+
+	assert.Equal(t, []uint16{10, 11, 12, 13, 14, 15, 16, 17, 18}, sequenceNumbers(t, out))
+}
+
+func TestReorderBuffer_wrapAround(t *testing.T) {
+	b := newReorderBuffer(5, time.Second)
+	now := time.Now()
+
+	var out [][]byte
+	for _, seq := range []uint16{65534, 0, 65535, 1} {
+		out = append(out, b.push(newRTPPacket(t, seq), now)...)
+	}
+
+	assert.Equal(t, []uint16{65534, 65535, 0, 1}, sequenceNumbers(t, out))
+}
+
+func TestReorderBuffer_depth(t *testing.T) {
+	b := newReorderBuffer(2, time.Second)
+	now := time.Now()
+
+	assert.Equal(t, []uint16{1}, sequenceNumbers(t, b.push(newRTPPacket(t, 1), now)))
+	assert.Empty(t, b.push(newRTPPacket(t, 3), now))
+	assert.Empty(t, b.push(newRTPPacket(t, 4), now))
+	assert.Equal(t, []uint16{3, 4, 5}, sequenceNumbers(t, b.push(newRTPPacket(t, 5), now)), "skips 2 when full")
+	assert.Empty(t, b.push(newRTPPacket(t, 2), now), "drops packets too late to be forwarded in order")
+	assert.Equal(t, []uint16{6}, sequenceNumbers(t, b.push(newRTPPacket(t, 6), now)))
+}
+
+func TestReorderBuffer_timeout(t *testing.T) {
+	b := newReorderBuffer(10, 50*time.Millisecond)
+	now := time.Now()
+
+	assert.Equal(t, []uint16{1}, sequenceNumbers(t, b.push(newRTPPacket(t, 1), now)))
+	assert.Empty(t, b.push(newRTPPacket(t, 3), now))
+	assert.Empty(t, b.push(newRTPPacket(t, 4), now.Add(40*time.Millisecond)))
+	assert.Equal(t, []uint16{3, 4}, sequenceNumbers(t, b.push(newRTPPacket(t, 6), now.Add(50*time.Millisecond))), "skips 2 after 3 waited too long")
+	assert.Equal(t, []uint16{6, 7}, sequenceNumbers(t, b.push(newRTPPacket(t, 7), now.Add(100*time.Millisecond))), "skips 5 after 6 waited too long")
+}
+
+func TestReorderBuffer_duplicates(t *testing.T) {
+	b := newReorderBuffer(5, time.Second)
+	now := time.Now()
+
+	assert.Equal(t, []uint16{1}, sequenceNumbers(t, b.push(newRTPPacket(t, 1), now)))
+	assert.Empty(t, b.push(newRTPPacket(t, 1), now))
+	assert.Empty(t, b.push(newRTPPacket(t, 3), now))
+	assert.Empty(t, b.push(newRTPPacket(t, 3), now))
+	assert.Equal(t, []uint16{2, 3}, sequenceNumbers(t, b.push(newRTPPacket(t, 2), now)))
+}
+
+func TestReorderBuffer_restart(t *testing.T) {
+	b := newReorderBuffer(5, time.Second)
+	now := time.Now()
+
+	assert.Equal(t, []uint16{5000}, sequenceNumbers(t, b.push(newRTPPacket(t, 5000), now)))
+	assert.Empty(t, b.push(newRTPPacket(t, 5002), now))
+	assert.Equal(t, []uint16{5002, 10}, sequenceNumbers(t, b.push(newRTPPacket(t, 10), now)), "flushes held packets when the stream restarts")
+	assert.Equal(t, []uint16{11}, sequenceNumbers(t, b.push(newRTPPacket(t, 11), now)))
+}
+
+func TestReorderBuffer_invalid(t *testing.T) {
+	b := newReorderBuffer(5, time.Second)
+
+	assert.Equal(t, [][]byte{{1, 2}}, b.push([]byte{1, 2}, time.Now()))
+}
@@ -0,0 +1,51 @@
+package tracks
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// Opus encodes silence and background noise in a few bytes, so larger
+	// audio payloads are assumed to carry speech.
+	voicedPayloadSize = 40
+	// Audio packets are counted within windows of this duration.
+	speakerWindow = 500 * time.Millisecond
+	// Minimum fraction of voiced audio packets in a window for the publisher
+	// to be considered speaking.
+	speakerVoicedRatio = 0.5
+)
+
+// Estimates whether the publisher of an audio track is speaking from the size
+// of the payloads, since the audio level header extension is not negotiated.
+type speakerDetector struct {
+	windowStart time.Time
+	packets     int
+	voiced      int
+}
+
+// Records an audio packet read from the publisher. Returns true once per
+// window in which the publisher was speaking. Packets with invalid RTP
+// headers are ignored.
+func (d *speakerDetector) receive(packet []byte, arrival time.Time) bool {
+	var header rtp.Header
+	if err := header.Unmarshal(packet); err != nil {
+		return false
+	}
+
+	speaking := false
+	if arrival.Sub(d.windowStart) >= speakerWindow {
+		speaking = d.packets > 0 && float64(d.voiced) >= float64(d.packets)*speakerVoicedRatio
+		d.windowStart = arrival
+		d.packets = 0
+		d.voiced = 0
+	}
+
+	d.packets++
+	if len(packet)-header.PayloadOffset >= voicedPayloadSize {
+		d.voiced++
+	}
+
+	return speaking
+}
@@ -0,0 +1,166 @@
+package tracks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAudioPacket(t *testing.T, seq uint16, payloadSize int) []byte {
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    webrtc.DefaultPayloadTypeOpus,
+			SequenceNumber: seq,
+			SSRC:           5678,
+		},
+		Payload: make([]byte, payloadSize),
+	}
+	data, err := packet.Marshal()
+	require.Nil(t, err)
+	return data
+}
+
+func TestSpeakerDetector(t *testing.T) {
+	var d speakerDetector
+	start := time.Now()
+	seq := uint16(0)
+
+	// sends a window of 25 packets, of which voiced have speech
+	window := func(voiced int) (speaking []bool) {
+		for i := 0; i < 25; i++ {
+			size := 5
+			if i < voiced {
+				size = 80
+			}
+			arrival := start.Add(time.Duration(seq) * 20 * time.Millisecond)
+			speaking = append(speaking, d.receive(newTestAudioPacket(t, seq, size), arrival))
+			seq++
+		}
+		return speaking
+	}
+
+	countTrue := func(values []bool) (count int) {
+		for _, value := range values {
+			if value {
+				count++
+			}
+		}
+		return count
+	}
+
+	assert.Equal(t, 0, countTrue(window(0)))
+	assert.Equal(t, 0, countTrue(window(20)), "speech should be reported after the window ends")
+	assert.Equal(t, 1, countTrue(window(5)))
+	assert.Equal(t, 0, countTrue(window(0)))
+	assert.Equal(t, 0, countTrue(window(0)))
+
+	assert.False(t, d.receive([]byte{1, 2}, start.Add(time.Hour)), "invalid packets should be ignored")
+}
+
+func TestTracksManager_lastN(t *testing.T) {
+	m := NewTracksManagerWithParams(Params{LastN: 2})
+	video, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1234, "sfu_v", "sfu_d_v", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.Nil(t, err)
+
+	pcs := map[string]*mockPeerConnection{}
+	for _, clientID := range []string{"a", "b", "c", "d"} {
+		pcs[clientID] = &mockPeerConnection{}
+		m.Add("room1", clientID, pcs[clientID], nil, mockSignaller{})
+	}
+	m.Add("room2", "e", &mockPeerConnection{}, nil, mockSignaller{})
+
+	d := m.peers["d"].peer
+	d.pliDebounce = time.Millisecond
+	d.localTracks = append(d.localTracks, video)
+
+	paused := func() (clientIDs []string) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		for _, clientID := range []string{"a", "b", "c", "d", "e"} {
+			if p, ok := m.peers[clientID]; ok && p.peer.isVideoPaused() {
+				clientIDs = append(clientIDs, clientID)
+			}
+		}
+		return clientIDs
+	}
+
+	assert.Equal(t, []string{"a", "b"}, m.ForwardedVideo("room1"))
+	assert.Equal(t, []string{"c", "d"}, paused())
+	assert.Equal(t, []string{"e"}, m.ForwardedVideo("room2"))
+
+	m.SetActiveSpeaker("room1", "d")
+	assert.Equal(t, []string{"d", "a"}, m.ForwardedVideo("room1"))
+	assert.Equal(t, []string{"b", "c"}, paused())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: 1234},
+	}, pcs["d"].Packets(), "a keyframe should be requested when video is resumed")
+
+	m.SetActiveSpeaker("room1", "b")
+	assert.Equal(t, []string{"b", "d"}, m.ForwardedVideo("room1"))
+	assert.Equal(t, []string{"a", "c"}, paused())
+
+	// the most recent speaker speaking again changes nothing
+	m.SetActiveSpeaker("room1", "b")
+	assert.Equal(t, []string{"b", "d"}, m.ForwardedVideo("room1"))
+
+	m.SetActiveSpeaker("room1", "missing")
+	assert.Equal(t, []string{"b", "d"}, m.ForwardedVideo("room1"))
+
+	m.removePeer("d")
+	assert.Equal(t, []string{"b", "a"}, m.ForwardedVideo("room1"))
+	assert.Equal(t, []string{"c"}, paused())
+
+	m.removePeer("a")
+	m.removePeer("b")
+	m.removePeer("c")
+	assert.Equal(t, []string{}, m.ForwardedVideo("room1"))
+	assert.Equal(t, 1, len(m.speakersByRoom), "empty rooms should be removed")
+}
+
+func TestTracksManager_RequestVideo(t *testing.T) {
+	m := NewTracksManagerWithParams(Params{LastN: 1})
+	for _, clientID := range []string{"a", "b", "c"} {
+		m.Add("room1", clientID, &mockPeerConnection{}, nil, mockSignaller{})
+	}
+	assert.Equal(t, []string{"a"}, m.ForwardedVideo("room1"))
+
+	m.RequestVideo("room1", "a", []string{"c"})
+	assert.Equal(t, []string{"a", "c"}, m.ForwardedVideo("room1"))
+	assert.False(t, m.peers["c"].peer.isVideoPaused(), "requested video should be resumed")
+	assert.True(t, m.peers["b"].peer.isVideoPaused())
+
+	m.RequestVideo("room1", "b", []string{"b"})
+	assert.Equal(t, []string{"a", "c"}, m.ForwardedVideo("room1"), "clients should not request their own video")
+
+	m.RequestVideo("room1", "a", nil)
+	m.RequestVideo("room1", "b", nil)
+	assert.Equal(t, []string{"a"}, m.ForwardedVideo("room1"))
+	assert.True(t, m.peers["c"].peer.isVideoPaused(), "video should be paused when the request is cancelled")
+
+	m.RequestVideo("room1", "b", []string{"c"})
+	assert.Equal(t, []string{"a", "c"}, m.ForwardedVideo("room1"))
+	m.removePeer("b")
+	assert.Equal(t, []string{"a"}, m.ForwardedVideo("room1"), "requests should be removed with the client")
+	assert.Empty(t, m.videoRequestsByRoom)
+
+	m.RequestVideo("room1", "missing", []string{"c"})
+	assert.Equal(t, []string{"a"}, m.ForwardedVideo("room1"), "requests of clients not in the room should be ignored")
+}
+
+func TestTracksManager_lastN_disabled(t *testing.T) {
+	m := NewTracksManager()
+	m.Add("room1", "a", &mockPeerConnection{}, nil, mockSignaller{})
+
+	m.SetActiveSpeaker("room1", "a")
+	assert.Nil(t, m.ForwardedVideo("room1"))
+	assert.Nil(t, m.peers["a"].peer.onSpeaking)
+	assert.False(t, m.peers["a"].peer.isVideoPaused())
+}
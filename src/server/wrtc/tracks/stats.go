@@ -0,0 +1,113 @@
+package tracks
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// TrackStats describes a track received from a publisher and forwarded to
+// the subscribers.
+type TrackStats struct {
+	TrackID string `json:"trackId"`
+	Kind    string `json:"kind"`
+	SSRC    uint32 `json:"ssrc"`
+
+	PacketsReceived  uint64 `json:"packetsReceived"`
+	BytesReceived    uint64 `json:"bytesReceived"`
+	PacketsForwarded uint64 `json:"packetsForwarded"`
+	BytesForwarded   uint64 `json:"bytesForwarded"`
+	// Estimated from gaps in RTP sequence numbers.
+	PacketsLost uint64 `json:"packetsLost"`
+	// Interarrival jitter in seconds, as defined in RFC 3550.
+	Jitter float64 `json:"jitter"`
+}
+
+// Collects the stats of a single track from RTP packets read from the
+// publisher.
+type trackStats struct {
+	mu        sync.Mutex
+	stats     TrackStats
+	clockRate uint32
+
+	started    bool
+	baseSeq    uint32
+	highestSeq uint32
+
+	hasLast       bool
+	lastArrival   time.Time
+	lastTimestamp uint32
+	// Jitter in RTP timestamp units.
+	jitter float64
+}
+
+func newTrackStats(trackID string, kind string, ssrc uint32, clockRate uint32) *trackStats {
+	return &trackStats{
+		stats: TrackStats{
+			TrackID: trackID,
+			Kind:    kind,
+			SSRC:    ssrc,
+		},
+		clockRate: clockRate,
+	}
+}
+
+// Records a packet read from the publisher. Packets with invalid RTP headers
+// are ignored.
+func (s *trackStats) receive(packet []byte, arrival time.Time) {
+	var header rtp.Header
+	if err := header.Unmarshal(packet); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.PacketsReceived++
+	s.stats.BytesReceived += uint64(len(packet))
+
+	if !s.started {
+		s.started = true
+		s.baseSeq = uint32(header.SequenceNumber)
+		s.highestSeq = s.baseSeq
+	} else if diff := int16(header.SequenceNumber - uint16(s.highestSeq)); diff > 0 {
+		// the extended sequence number also counts wraparounds
+		s.highestSeq += uint32(diff)
+	}
+
+	expected := uint64(s.highestSeq-s.baseSeq) + 1
+	if expected > s.stats.PacketsReceived {
+		s.stats.PacketsLost = expected - s.stats.PacketsReceived
+	} else {
+		s.stats.PacketsLost = 0
+	}
+
+	if s.clockRate > 0 {
+		if s.hasLast {
+			elapsed := arrival.Sub(s.lastArrival).Seconds() * float64(s.clockRate)
+			d := math.Abs(elapsed - float64(int32(header.Timestamp-s.lastTimestamp)))
+			s.jitter += (d - s.jitter) / 16
+			s.stats.Jitter = s.jitter / float64(s.clockRate)
+		}
+		s.hasLast = true
+		s.lastArrival = arrival
+		s.lastTimestamp = header.Timestamp
+	}
+}
+
+// Records a packet written to the subscribers.
+func (s *trackStats) forward(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.PacketsForwarded++
+	s.stats.BytesForwarded += uint64(size)
+}
+
+func (s *trackStats) Stats() TrackStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
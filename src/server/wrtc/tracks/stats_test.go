@@ -0,0 +1,86 @@
+package tracks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRTPPacket(t *testing.T, seq uint16, timestamp uint32) []byte {
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    webrtc.DefaultPayloadTypeOpus,
+			SequenceNumber: seq,
+			Timestamp:      timestamp,
+			SSRC:           1234,
+		},
+		Payload: []byte{1, 2, 3, 4},
+	}
+	data, err := packet.Marshal()
+	require.Nil(t, err)
+	return data
+}
+
+func TestTrackStats_receive(t *testing.T) {
+	s := newTrackStats("sfu_a", "audio", 1234, 48000)
+	start := time.Now()
+
+	// 20ms of audio per packet, sequence number 3 is lost and the sequence
+	// number wraps around.
+	for i, seq := range []uint16{65534, 65535, 0, 2, 3} {
+		packet := newTestRTPPacket(t, seq, uint32(i*960))
+		s.receive(packet, start.Add(time.Duration(i)*20*time.Millisecond))
+		s.forward(len(packet))
+	}
+	s.receive([]byte{1, 2}, start)
+
+	stats := s.Stats()
+	packetSize := uint64(len(newTestRTPPacket(t, 0, 0)))
+	assert.Equal(t, TrackStats{
+		TrackID:          "sfu_a",
+		Kind:             "audio",
+		SSRC:             1234,
+		PacketsReceived:  5,
+		BytesReceived:    5 * packetSize,
+		PacketsForwarded: 5,
+		BytesForwarded:   5 * packetSize,
+		PacketsLost:      1,
+		Jitter:           stats.Jitter,
+	}, stats)
+	assert.InDelta(t, 0, stats.Jitter, 0.001, "expected no jitter for evenly spaced packets")
+}
+
+func TestTrackStats_jitter(t *testing.T) {
+	s := newTrackStats("sfu_a", "audio", 1234, 48000)
+	start := time.Now()
+
+	s.receive(newTestRTPPacket(t, 1, 0), start)
+	s.receive(newTestRTPPacket(t, 2, 960), start.Add(40*time.Millisecond))
+
+	// the second packet arrived 20ms late: 960 timestamp units / 16
+	assert.InDelta(t, 0.02/16, s.Stats().Jitter, 0.0001)
+}
+
+func TestTracksManager_TrackStats(t *testing.T) {
+	m := NewTracksManager()
+	p := newTestPeerInRoom("room1", "a", &mockPeerConnection{})
+	m.peers["a"] = p
+	m.peerIDsByRoom["room1"] = map[string]struct{}{"a": {}, "b": {}}
+	m.peers["b"] = newTestPeerInRoom("room1", "b", &mockPeerConnection{})
+
+	stats := newTrackStats("sfu_a", "audio", 1234, 48000)
+	stats.receive(newTestRTPPacket(t, 1, 0), time.Now())
+	p.peer.addTrackStats("sfu_a", stats)
+
+	assert.Equal(t, map[string][]TrackStats{
+		"a": {stats.Stats()},
+	}, m.TrackStats("room1"))
+
+	p.peer.removeTrackStats("sfu_a")
+	assert.Equal(t, map[string][]TrackStats{}, m.TrackStats("room1"))
+}
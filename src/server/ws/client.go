@@ -2,7 +2,9 @@ package ws
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/jeremija/peer-calls/src/server/basen"
@@ -10,6 +12,27 @@ import (
 	"nhooyr.io/websocket"
 )
 
+const (
+	ProtocolVersion1 = "peercalls.v1"
+	ProtocolVersion2 = "peercalls.v2"
+	// Same as ProtocolVersion2, but messages are binary and encoded with
+	// wsmessage.ProtoSerializer.
+	ProtocolVersion2Protobuf = "peercalls.v2.protobuf"
+	// Same as ProtocolVersion2, but messages are binary and compressed with
+	// wsmessage.CompressingSerializer.
+	ProtocolVersion2Binary = "peercalls.v2.binary"
+)
+
+// Supported websocket subprotocols of clients using JSON, ordered by
+// preference.
+var Subprotocols = []string{ProtocolVersion2, ProtocolVersion1}
+
+// Supported websocket subprotocols of clients using protobuf.
+var ProtobufSubprotocols = []string{ProtocolVersion2Protobuf}
+
+// Supported websocket subprotocols of clients using compressed JSON.
+var BinarySubprotocols = []string{ProtocolVersion2Binary}
+
 type WSWriter interface {
 	Write(ctx context.Context, typ websocket.MessageType, msg []byte) error
 }
@@ -23,14 +46,84 @@ type WSReadWriter interface {
 	WSWriter
 }
 
+// OverflowPolicy decides what happens to a message sent to a client whose
+// send queue is full.
+type OverflowPolicy int
+
+const (
+	// Drops the message being sent.
+	OverflowPolicyDropNewest OverflowPolicy = iota
+	// Drops the oldest queued message to make room for the message being
+	// sent.
+	OverflowPolicyDropOldest
+	// Drops the message being sent and ends the subscription so that the
+	// connection is closed.
+	OverflowPolicyCloseConnection
+)
+
+const defaultSendQueueSize = 16
+
+var ErrSendQueueFull = errors.New("Send queue full")
+
+var ErrClientClosed = errors.New("Client closed")
+
+var ErrFrameRateExceeded = errors.New("Frame rate exceeded")
+
+// ByteCounter counts the bytes of messages written to and read from a
+// websocket.
+type ByteCounter interface {
+	AddSent(n int)
+	AddReceived(n int)
+}
+
 // An abstraction for sending out to websocket using channels.
 type Client struct {
-	id           string
-	conn         WSReadWriter
-	metadata     string
-	writeChannel chan wsmessage.Message
-	readChannel  chan wsmessage.Message
-	serializer   wsmessage.ByteSerializer
+	id             string
+	conn           WSReadWriter
+	metadata       string
+	protocol       string
+	writeChannel   chan wsmessage.Message
+	readChannel    chan wsmessage.Message
+	serializer     wsmessage.SerializerDeserializer
+	messageType    websocket.MessageType
+	overflowPolicy OverflowPolicy
+	byteCounter    ByteCounter
+	frameLimiter   *RateLimiter
+
+	sendMu       sync.Mutex
+	closed       bool
+	overflow     chan struct{}
+	overflowOnce sync.Once
+
+	messageTypesMu sync.RWMutex
+	messageTypes   map[string]struct{}
+
+	compressionMu sync.RWMutex
+	// Messages written are compressed by this serializer when the client
+	// asked for compression, nil otherwise.
+	compressor wsmessage.Serializer
+}
+
+type ClientParams struct {
+	// Generated when empty.
+	ID string
+	// Number of messages queued for writing to the websocket. Defaults to 16.
+	SendQueueSize int
+	// Decides what happens when the send queue is full. Defaults to
+	// OverflowPolicyDropNewest.
+	OverflowPolicy OverflowPolicy
+	// Counts the bytes of messages written and read. Optional.
+	ByteCounter ByteCounter
+	// Encodes messages written and decodes messages read. Defaults to
+	// wsmessage.ByteSerializer.
+	Serializer wsmessage.SerializerDeserializer
+	// Type of messages written. Messages of other types are ignored when
+	// read. Defaults to websocket.MessageText.
+	MessageType websocket.MessageType
+	// Maximum number of frames per second read from the websocket, of any
+	// type. The subscription ends with ErrFrameRateExceeded when a client
+	// sends more. Unlimited when zero.
+	MaxFrameRate int
 }
 
 // Creates a new websocket client.
@@ -39,14 +132,42 @@ func NewClient(conn WSReadWriter) *Client {
 }
 
 func NewClientWithID(conn WSReadWriter, id string) *Client {
+	return NewClientWithParams(conn, ClientParams{ID: id})
+}
+
+func NewClientWithParams(conn WSReadWriter, params ClientParams) *Client {
+	id := params.ID
 	if id == "" {
 		id = basen.NewUUIDBase62()
 	}
+	sendQueueSize := params.SendQueueSize
+	if sendQueueSize <= 0 {
+		sendQueueSize = defaultSendQueueSize
+	}
+	serializer := params.Serializer
+	if serializer == nil {
+		serializer = wsmessage.ByteSerializer{}
+	}
+	messageType := params.MessageType
+	if messageType == 0 {
+		messageType = websocket.MessageText
+	}
+	var frameLimiter *RateLimiter
+	if params.MaxFrameRate > 0 {
+		frameLimiter = NewRateLimiter(float64(params.MaxFrameRate), params.MaxFrameRate)
+	}
 	return &Client{
-		id:           id,
-		conn:         conn,
-		writeChannel: make(chan wsmessage.Message, 16),
-		readChannel:  make(chan wsmessage.Message, 16),
+		id:             id,
+		conn:           conn,
+		protocol:       ProtocolVersion1,
+		writeChannel:   make(chan wsmessage.Message, sendQueueSize),
+		readChannel:    make(chan wsmessage.Message, 16),
+		overflowPolicy: params.OverflowPolicy,
+		byteCounter:    params.ByteCounter,
+		serializer:     serializer,
+		messageType:    messageType,
+		frameLimiter:   frameLimiter,
+		overflow:       make(chan struct{}),
 	}
 }
 
@@ -58,25 +179,165 @@ func (c *Client) Metadata() string {
 	return c.metadata
 }
 
+// Sets the negotiated protocol version. Defaults to ProtocolVersion1.
+func (c *Client) SetProtocol(protocol string) {
+	c.protocol = protocol
+}
+
+func (c *Client) Protocol() string {
+	return c.protocol
+}
+
+// Enables or disables compression of the messages written to this client
+// with wsmessage.CompressingSerializer. Compressed messages are written as
+// binary websocket messages. Messages read are never compressed.
+func (c *Client) SetCompression(enabled bool) {
+	c.compressionMu.Lock()
+	defer c.compressionMu.Unlock()
+
+	if !enabled {
+		c.compressor = nil
+		return
+	}
+	c.compressor = wsmessage.CompressingSerializer{Serializer: c.serializer}
+}
+
+// Returns true when messages written to this client are compressed.
+func (c *Client) Compression() bool {
+	c.compressionMu.RLock()
+	defer c.compressionMu.RUnlock()
+	return c.compressor != nil
+}
+
 // Writes a message to websocket with timeout.
 func (c *Client) WriteTimeout(ctx context.Context, timeout time.Duration, msg wsmessage.Message) error {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	data, err := c.serializer.Serialize(msg)
+
+	var serializer wsmessage.Serializer = c.serializer
+	messageType := c.messageType
+	c.compressionMu.RLock()
+	if c.compressor != nil {
+		serializer = c.compressor
+		messageType = websocket.MessageBinary
+	}
+	c.compressionMu.RUnlock()
+
+	data, err := serializer.Serialize(msg)
 	if err != nil {
 		return fmt.Errorf("client.WriteTimeout - error serializing message: %w", err)
 	}
-	return c.conn.Write(ctx, websocket.MessageText, data)
+	if err := c.conn.Write(ctx, messageType, data); err != nil {
+		return err
+	}
+	if c.byteCounter != nil {
+		c.byteCounter.AddSent(len(data))
+	}
+	return nil
+}
+
+// Reads a single message from websocket with timeout, e.g. before the client
+// is subscribed. Messages of other websocket message types are skipped.
+func (c *Client) ReadTimeout(ctx context.Context, timeout time.Duration) (wsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		typ, data, err := c.conn.Read(ctx)
+		if err != nil {
+			return wsmessage.Message{}, fmt.Errorf("client.ReadTimeout - error reading data: %w", err)
+		}
+		if c.byteCounter != nil {
+			c.byteCounter.AddReceived(len(data))
+		}
+		if typ != c.messageType {
+			continue
+		}
+		message, err := c.serializer.Deserialize(data)
+		if err != nil {
+			return wsmessage.Message{}, fmt.Errorf("client.ReadTimeout - error deserializing data: %w", err)
+		}
+		return message, nil
+	}
 }
 
 func (c *Client) ID() string {
 	return c.id
 }
 
-// Gets the channel to write out to. Messages sent here will be written
-// to the websocket and received by the other side.
-func (c *Client) WriteChannel() chan<- wsmessage.Message {
-	return c.writeChannel
+// Restricts broadcast messages delivered to this client to messageTypes.
+// Messages of all types are delivered when messageTypes is empty.
+func (c *Client) SetMessageTypes(messageTypes []string) {
+	c.messageTypesMu.Lock()
+	defer c.messageTypesMu.Unlock()
+
+	if len(messageTypes) == 0 {
+		c.messageTypes = nil
+		return
+	}
+
+	c.messageTypes = make(map[string]struct{}, len(messageTypes))
+	for _, typ := range messageTypes {
+		c.messageTypes[typ] = struct{}{}
+	}
+}
+
+// Returns true when broadcast messages of type typ should be delivered to
+// this client.
+func (c *Client) Subscribed(typ string) bool {
+	c.messageTypesMu.RLock()
+	defer c.messageTypesMu.RUnlock()
+
+	if c.messageTypes == nil {
+		return true
+	}
+	_, ok := c.messageTypes[typ]
+	return ok
+}
+
+// Returns true when messageTypes were restricted and include typ, i.e. the
+// client asked for messages of type typ by name.
+func (c *Client) SubscribedExplicitly(typ string) bool {
+	c.messageTypesMu.RLock()
+	defer c.messageTypesMu.RUnlock()
+
+	_, ok := c.messageTypes[typ]
+	return ok
+}
+
+// Queues a message to be written to the websocket without blocking. When the
+// queue is full the message is handled according to the overflow policy.
+func (c *Client) Send(msg wsmessage.Message) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	select {
+	case c.writeChannel <- msg:
+		return nil
+	default:
+	}
+
+	switch c.overflowPolicy {
+	case OverflowPolicyDropOldest:
+		// senders are serialized by sendMu and the subscription only removes
+		// messages, so there is room after removing one
+		select {
+		case <-c.writeChannel:
+		default:
+		}
+		c.writeChannel <- msg
+		return nil
+	case OverflowPolicyCloseConnection:
+		c.overflowOnce.Do(func() {
+			close(c.overflow)
+		})
+		return ErrSendQueueFull
+	default:
+		return ErrSendQueueFull
+	}
 }
 
 // Subscribes
@@ -86,17 +347,28 @@ func (c *Client) subscribeRead(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("client.subscribeRead - error reading data: %w", err)
 		}
+		if c.frameLimiter != nil && !c.frameLimiter.Allow("") {
+			return fmt.Errorf("client.subscribeRead - closing flooding client: %w", ErrFrameRateExceeded)
+		}
+		if c.byteCounter != nil {
+			c.byteCounter.AddReceived(len(data))
+		}
+		if typ != c.messageType {
+			continue
+		}
 		message, err := c.serializer.Deserialize(data)
 		if err != nil {
 			return fmt.Errorf("client.subscribeRead - error deserializing data: %w", err)
 		}
-		if typ == websocket.MessageText {
-			c.readChannel <- message
-		}
+		c.readChannel <- message
 	}
 }
 
 func (c *Client) Close() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.closed = true
 	close(c.readChannel)
 	close(c.writeChannel)
 }
@@ -121,6 +393,8 @@ func (c *Client) Subscribe(ctx context.Context, handle func(wsmessage.Message))
 			}
 		case msg := <-c.readChannel:
 			handle(msg)
+		case <-c.overflow:
+			return fmt.Errorf("client.Subscribe - closing slow client: %w", ErrSendQueueFull)
 		case err := <-readErr:
 			return err
 		case <-ctx.Done():
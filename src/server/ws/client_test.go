@@ -0,0 +1,290 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type blockingConn struct{}
+
+func (blockingConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	<-ctx.Done()
+	return 0, nil, ctx.Err()
+}
+
+func (blockingConn) Write(ctx context.Context, typ websocket.MessageType, msg []byte) error {
+	return nil
+}
+
+func newQueueClient(policy OverflowPolicy) *Client {
+	return NewClientWithParams(blockingConn{}, ClientParams{
+		SendQueueSize:  2,
+		OverflowPolicy: policy,
+	})
+}
+
+func newQueueMessage(typ string) wsmessage.Message {
+	return wsmessage.NewMessage(typ, "room1", nil)
+}
+
+func queuedTypes(c *Client) []string {
+	var types []string
+	for len(c.writeChannel) > 0 {
+		types = append(types, (<-c.writeChannel).Type)
+	}
+	return types
+}
+
+func TestClient_Send_dropNewest(t *testing.T) {
+	c := newQueueClient(OverflowPolicyDropNewest)
+	defer c.Close()
+
+	require.Nil(t, c.Send(newQueueMessage("a")))
+	require.Nil(t, c.Send(newQueueMessage("b")))
+	err := c.Send(newQueueMessage("c"))
+	assert.True(t, errors.Is(err, ErrSendQueueFull))
+
+	assert.Equal(t, []string{"a", "b"}, queuedTypes(c))
+}
+
+func TestClient_Send_dropOldest(t *testing.T) {
+	c := newQueueClient(OverflowPolicyDropOldest)
+	defer c.Close()
+
+	require.Nil(t, c.Send(newQueueMessage("a")))
+	require.Nil(t, c.Send(newQueueMessage("b")))
+	require.Nil(t, c.Send(newQueueMessage("c")))
+
+	assert.Equal(t, []string{"b", "c"}, queuedTypes(c))
+}
+
+func TestClient_Send_closeConnection(t *testing.T) {
+	c := newQueueClient(OverflowPolicyCloseConnection)
+	defer c.Close()
+
+	require.Nil(t, c.Send(newQueueMessage("a")))
+	require.Nil(t, c.Send(newQueueMessage("b")))
+	err := c.Send(newQueueMessage("c"))
+	assert.True(t, errors.Is(err, ErrSendQueueFull))
+
+	err = c.Subscribe(context.Background(), func(wsmessage.Message) {})
+	assert.True(t, errors.Is(err, ErrSendQueueFull), "expected ErrSendQueueFull, but got: %s", err)
+}
+
+func TestClient_Send_closed(t *testing.T) {
+	c := newQueueClient(OverflowPolicyDropNewest)
+	c.Close()
+
+	assert.Equal(t, ErrClientClosed, c.Send(newQueueMessage("a")))
+}
+
+func TestClient_Subscribed(t *testing.T) {
+	c := NewClient(blockingConn{})
+	assert.True(t, c.Subscribed("a"), "all types are subscribed by default")
+
+	c.SetMessageTypes([]string{"a", "b"})
+	assert.True(t, c.Subscribed("a"))
+	assert.True(t, c.Subscribed("b"))
+	assert.False(t, c.Subscribed("c"))
+
+	c.SetMessageTypes(nil)
+	assert.True(t, c.Subscribed("c"))
+	assert.False(t, c.SubscribedExplicitly("c"), "subscribing to all types should not be explicit")
+
+	c.SetMessageTypes([]string{"c"})
+	assert.True(t, c.SubscribedExplicitly("c"))
+	assert.False(t, c.SubscribedExplicitly("d"))
+}
+
+type byteCounter struct {
+	sent     int
+	received int
+}
+
+func (b *byteCounter) AddSent(n int) {
+	b.sent += n
+}
+
+func (b *byteCounter) AddReceived(n int) {
+	b.received += n
+}
+
+type readOnceConn struct {
+	blockingConn
+	data []byte
+}
+
+func (r *readOnceConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	if data := r.data; data != nil {
+		r.data = nil
+		return websocket.MessageText, data, nil
+	}
+	return r.blockingConn.Read(ctx)
+}
+
+func TestClient_byteCounter(t *testing.T) {
+	var serializer wsmessage.ByteSerializer
+	received, err := serializer.Serialize(newQueueMessage("a"))
+	require.Nil(t, err)
+
+	counter := &byteCounter{}
+	c := NewClientWithParams(&readOnceConn{data: received}, ClientParams{
+		ByteCounter: counter,
+	})
+	defer c.Close()
+
+	msg := newQueueMessage("sent")
+	sent, err := serializer.Serialize(msg)
+	require.Nil(t, err)
+	require.Nil(t, c.WriteTimeout(context.Background(), time.Second, msg))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = c.Subscribe(ctx, func(wsmessage.Message) {
+		cancel()
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	assert.Equal(t, len(sent), counter.sent)
+	assert.Equal(t, len(received), counter.received)
+}
+
+type recordingConn struct {
+	readType websocket.MessageType
+	readData []byte
+
+	writeType websocket.MessageType
+	writeData []byte
+}
+
+func (r *recordingConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	if data := r.readData; data != nil {
+		r.readData = nil
+		return r.readType, data, nil
+	}
+	<-ctx.Done()
+	return 0, nil, ctx.Err()
+}
+
+func (r *recordingConn) Write(ctx context.Context, typ websocket.MessageType, msg []byte) error {
+	r.writeType = typ
+	r.writeData = msg
+	return nil
+}
+
+func TestClient_serializer(t *testing.T) {
+	var serializer wsmessage.ProtoSerializer
+	received, err := serializer.Serialize(newQueueMessage("received"))
+	require.Nil(t, err)
+
+	conn := &recordingConn{readType: websocket.MessageBinary, readData: received}
+	c := NewClientWithParams(conn, ClientParams{
+		Serializer:  serializer,
+		MessageType: websocket.MessageBinary,
+	})
+	defer c.Close()
+
+	require.Nil(t, c.WriteTimeout(context.Background(), time.Second, newQueueMessage("sent")))
+	assert.Equal(t, websocket.MessageBinary, conn.writeType)
+	sent, err := serializer.Deserialize(conn.writeData)
+	require.Nil(t, err)
+	assert.Equal(t, newQueueMessage("sent"), sent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var messages []wsmessage.Message
+	err = c.Subscribe(ctx, func(msg wsmessage.Message) {
+		messages = append(messages, msg)
+		cancel()
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, []wsmessage.Message{newQueueMessage("received")}, messages)
+}
+
+func TestClient_serializer_default(t *testing.T) {
+	conn := &recordingConn{}
+	c := NewClient(conn)
+	defer c.Close()
+
+	require.Nil(t, c.WriteTimeout(context.Background(), time.Second, newQueueMessage("sent")))
+	assert.Equal(t, websocket.MessageText, conn.writeType)
+	sent, err := wsmessage.ByteSerializer{}.Deserialize(conn.writeData)
+	require.Nil(t, err)
+	assert.Equal(t, newQueueMessage("sent"), sent)
+}
+
+func TestClient_ReadTimeout(t *testing.T) {
+	var serializer wsmessage.ProtoSerializer
+	received, err := serializer.Serialize(newQueueMessage("received"))
+	require.Nil(t, err)
+
+	counter := &byteCounter{}
+	conn := &recordingConn{readType: websocket.MessageBinary, readData: received}
+	c := NewClientWithParams(conn, ClientParams{
+		Serializer:  serializer,
+		MessageType: websocket.MessageBinary,
+		ByteCounter: counter,
+	})
+	defer c.Close()
+
+	msg, err := c.ReadTimeout(context.Background(), time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, newQueueMessage("received"), msg)
+	assert.Equal(t, len(received), counter.received)
+
+	_, err = c.ReadTimeout(context.Background(), 10*time.Millisecond)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %s", err)
+}
+
+type floodingConn struct{}
+
+func (floodingConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	return websocket.MessageBinary, []byte{0}, nil
+}
+
+func (floodingConn) Write(ctx context.Context, typ websocket.MessageType, msg []byte) error {
+	return nil
+}
+
+func TestClient_MaxFrameRate(t *testing.T) {
+	c := NewClientWithParams(floodingConn{}, ClientParams{
+		MaxFrameRate: 10,
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := c.Subscribe(ctx, func(wsmessage.Message) {})
+	assert.True(t, errors.Is(err, ErrFrameRateExceeded), "unexpected error: %s", err)
+}
+
+func TestClient_SetCompression(t *testing.T) {
+	conn := &recordingConn{}
+	c := NewClientWithParams(conn, ClientParams{})
+	defer c.Close()
+	msg := newQueueMessage("sent")
+
+	assert.False(t, c.Compression())
+	c.SetCompression(true)
+	assert.True(t, c.Compression())
+
+	require.Nil(t, c.WriteTimeout(context.Background(), time.Second, msg))
+	assert.Equal(t, websocket.MessageBinary, conn.writeType)
+	sent, err := wsmessage.CompressingSerializer{Serializer: wsmessage.ByteSerializer{}}.Deserialize(conn.writeData)
+	require.Nil(t, err)
+	assert.Equal(t, msg, sent)
+
+	c.SetCompression(false)
+	assert.False(t, c.Compression())
+
+	require.Nil(t, c.WriteTimeout(context.Background(), time.Second, msg))
+	assert.Equal(t, websocket.MessageText, conn.writeType)
+	sent, err = wsmessage.ByteSerializer{}.Deserialize(conn.writeData)
+	require.Nil(t, err)
+	assert.Equal(t, msg, sent)
+}
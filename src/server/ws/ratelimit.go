@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket rate limiter which keeps a separate bucket
+// for every key.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// Creates a new rate limiter which allows rate events per second for each
+// key, with bursts of up to burst events.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// Reports whether an event for key may happen now and consumes a token if
+// it may.
+func (r *RateLimiter) Allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * r.rate
+	if bucket.tokens > r.burst {
+		bucket.tokens = r.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
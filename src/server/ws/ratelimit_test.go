@@ -0,0 +1,31 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	r := NewRateLimiter(2, 3)
+	r.now = func() time.Time { return now }
+
+	assert.True(t, r.Allow("a"))
+	assert.True(t, r.Allow("a"))
+	assert.True(t, r.Allow("a"))
+	assert.False(t, r.Allow("a"))
+
+	assert.True(t, r.Allow("b"), "keys should have separate buckets")
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, r.Allow("a"))
+	assert.False(t, r.Allow("a"))
+
+	now = now.Add(time.Minute)
+	assert.True(t, r.Allow("a"))
+	assert.True(t, r.Allow("a"))
+	assert.True(t, r.Allow("a"))
+	assert.False(t, r.Allow("a"), "tokens should not exceed burst")
+}
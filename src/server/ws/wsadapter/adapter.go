@@ -1,22 +1,100 @@
 package wsadapter
 
-import "github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+import (
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
 
 type Client interface {
 	ID() string
-	WriteChannel() chan<- wsmessage.Message
+	Send(msg wsmessage.Message) error
+	// Returns false when broadcast messages of type typ should be skipped.
+	Subscribed(typ string) bool
 	Metadata() string
 	SetMetadata(metadata string)
 }
 
+// Replaceable is implemented by clients that are notified when another
+// client with the same ID is added to the room. Adapters no longer deliver
+// messages to a replaced client, which should close its connection without
+// removing its ID from the room.
+type Replaceable interface {
+	Replaced()
+}
+
+// Notifies the client that it was replaced, if it implements Replaceable.
+func NotifyReplaced(client Client) {
+	if r, ok := client.(Replaceable); ok {
+		r.Replaced()
+	}
+}
+
+type Params struct {
+	// Number of most recent chat messages stored per room and sent to clients
+	// after they join. Disabled when zero.
+	ChatHistorySize int
+	// Serializer for messages published or stored outside of the process.
+	// Defaults to wsmessage.ByteSerializer.
+	Serializer wsmessage.SerializerDeserializer
+	// Store for room metadata. Defaults to a store used only by the adapter.
+	RoomMetadataStore RoomMetadataStore
+	// Store for room password hashes. Defaults to a store used only by the
+	// adapter, whose passwords never expire.
+	RoomPasswordStore RoomPasswordStore
+	// Maximum time to wait for a message to be published outside of the
+	// process. Publishing fails with an error after the timeout. Unlimited
+	// when zero.
+	PublishTimeout time.Duration
+	// Interval between pings on the connection subscribed to messages
+	// published outside of the process. Keeps idle connections from being
+	// closed by proxies. The adapter resubscribes when a ping fails.
+	// Disabled when zero.
+	PingInterval time.Duration
+	// Join and leave messages within this window are batched into a single
+	// room state delta. Disabled when zero.
+	PresenceDebounce time.Duration
+}
+
 type Adapter interface {
+	// Adds a client to the room and broadcasts the join message. A client
+	// added with the ID of another client in the room replaces it: the
+	// replaced client is notified and the join message is not broadcast
+	// again. When using Redis, clients connected to other instances are
+	// replaced too.
 	Add(client Client) error
 	Remove(clientID string) error
+	RemoveWithReason(clientID string, reason string) error
+	// Removes a client and broadcasts the leave message instead of the
+	// default one.
+	RemoveWithMessage(clientID string, leave wsmessage.Message) error
 	Broadcast(msg wsmessage.Message) error
+	// Sends a message to the listed clients in the room. Message types
+	// subscribed by clients are not checked, same as with Emit.
+	BroadcastTo(clientIDs []string, msg wsmessage.Message) error
 	Metadata(clientID string) (string, bool)
 	SetMetadata(clientID string, metadata string) bool
 	Emit(clientID string, msg wsmessage.Message) error
 	Clients() (map[string]string, error)
+	RoomMetadata() (RoomMetadata, error)
+	SetRoomMetadata(metadata RoomMetadata) error
+	// Returns the number of clients in the room. Callers that only need the
+	// count should use it instead of Clients.
 	Size() (int, error)
+	// Returns the time the room was created. When using Redis the time is
+	// shared by all instances until the room becomes empty.
+	CreatedAt() (time.Time, error)
+	// Returns the hash of the room password, or an empty string when the
+	// room has no password. The password is kept in the RoomPasswordStore,
+	// so it outlives the adapter.
+	RoomPassword() (string, error)
+	// Stores the hash of the room password. The password is removed when
+	// hash is empty.
+	SetRoomPassword(hash string) error
+	// Returns the ID of the client added first to the empty room, or an
+	// empty string once that client is removed. The owner is decided by Add,
+	// so clients joining at the same time, also on other instances, agree
+	// on it.
+	Owner() (string, error)
 	Close() error
 }
@@ -0,0 +1,69 @@
+package wsadapter
+
+import (
+	"sync"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// RaisedHands keeps the raise hand messages of clients whose hands are
+// currently raised, so they can be sent to clients that join later.
+type RaisedHands struct {
+	mu        sync.Mutex
+	clientIDs []string
+	messages  map[string]wsmessage.Message
+}
+
+func NewRaisedHands() *RaisedHands {
+	return &RaisedHands{
+		messages: map[string]wsmessage.Message{},
+	}
+}
+
+// Updates the state from a raise hand message. Other messages are ignored.
+func (h *RaisedHands) Update(msg wsmessage.Message) {
+	clientID, raised, ok := wsmessage.RaiseHandState(msg)
+	if !ok {
+		return
+	}
+	if !raised {
+		h.Remove(clientID)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.messages[clientID]; !ok {
+		h.clientIDs = append(h.clientIDs, clientID)
+	}
+	h.messages[clientID] = msg
+}
+
+func (h *RaisedHands) Remove(clientID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.messages[clientID]; !ok {
+		return
+	}
+	delete(h.messages, clientID)
+	for i, id := range h.clientIDs {
+		if id == clientID {
+			h.clientIDs = append(h.clientIDs[:i], h.clientIDs[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns raise hand messages in the order hands were raised.
+func (h *RaisedHands) Messages() []wsmessage.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	messages := make([]wsmessage.Message, len(h.clientIDs))
+	for i, clientID := range h.clientIDs {
+		messages[i] = h.messages[clientID]
+	}
+	return messages
+}
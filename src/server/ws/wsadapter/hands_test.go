@@ -0,0 +1,38 @@
+package wsadapter_test
+
+import (
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRaiseHand(clientID string, raised bool) wsmessage.Message {
+	return wsmessage.NewMessageRaiseHand("test-room", clientID, "", raised, 1)
+}
+
+func TestRaisedHands(t *testing.T) {
+	h := wsadapter.NewRaisedHands()
+	assert.Equal(t, []wsmessage.Message{}, h.Messages())
+
+	h.Update(newRaiseHand("a", true))
+	h.Update(newRaiseHand("b", true))
+	h.Update(newRaiseHand("c", true))
+	h.Update(newChatMessage("ignored"))
+	assert.Equal(t, []wsmessage.Message{
+		newRaiseHand("a", true),
+		newRaiseHand("b", true),
+		newRaiseHand("c", true),
+	}, h.Messages())
+
+	h.Update(newRaiseHand("a", false))
+	h.Remove("c")
+	assert.Equal(t, []wsmessage.Message{newRaiseHand("b", true)}, h.Messages())
+
+	h.Update(newRaiseHand("a", true))
+	assert.Equal(t, []wsmessage.Message{
+		newRaiseHand("b", true),
+		newRaiseHand("a", true),
+	}, h.Messages())
+}
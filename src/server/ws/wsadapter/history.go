@@ -0,0 +1,51 @@
+package wsadapter
+
+import (
+	"sync"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// History is a bounded ring buffer of messages. When full, the oldest message
+// is evicted.
+type History struct {
+	mu       sync.Mutex
+	messages []wsmessage.Message
+	start    int
+	size     int
+}
+
+func NewHistory(capacity int) *History {
+	return &History{
+		messages: make([]wsmessage.Message, capacity),
+	}
+}
+
+func (h *History) Add(msg wsmessage.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	capacity := len(h.messages)
+	if capacity == 0 {
+		return
+	}
+
+	h.messages[(h.start+h.size)%capacity] = msg
+	if h.size < capacity {
+		h.size++
+	} else {
+		h.start = (h.start + 1) % capacity
+	}
+}
+
+// Returns buffered messages, oldest first.
+func (h *History) Messages() []wsmessage.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	messages := make([]wsmessage.Message, h.size)
+	for i := 0; i < h.size; i++ {
+		messages[i] = h.messages[(h.start+i)%len(h.messages)]
+	}
+	return messages
+}
@@ -0,0 +1,34 @@
+package wsadapter_test
+
+import (
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+)
+
+func newChatMessage(text string) wsmessage.Message {
+	return wsmessage.NewMessageChat("test-room", "client1", text)
+}
+
+func TestHistory_eviction(t *testing.T) {
+	h := wsadapter.NewHistory(2)
+	assert.Equal(t, []wsmessage.Message{}, h.Messages())
+
+	h.Add(newChatMessage("a"))
+	assert.Equal(t, []wsmessage.Message{newChatMessage("a")}, h.Messages())
+
+	h.Add(newChatMessage("b"))
+	h.Add(newChatMessage("c"))
+	assert.Equal(t, []wsmessage.Message{newChatMessage("b"), newChatMessage("c")}, h.Messages())
+
+	h.Add(newChatMessage("d"))
+	assert.Equal(t, []wsmessage.Message{newChatMessage("c"), newChatMessage("d")}, h.Messages())
+}
+
+func TestHistory_disabled(t *testing.T) {
+	h := wsadapter.NewHistory(0)
+	h.Add(newChatMessage("a"))
+	assert.Equal(t, []wsmessage.Message{}, h.Messages())
+}
@@ -0,0 +1,72 @@
+package wsadapter
+
+import "sync"
+
+// RoomMetadata contains room-level settings.
+type RoomMetadata struct {
+	// New clients cannot join locked rooms.
+	Locked bool   `json:"locked"`
+	Topic  string `json:"topic"`
+	// Notice sent to clients after joining the room. Overrides the global
+	// welcome message when set.
+	WelcomeMessage string `json:"welcomeMessage"`
+	// Lists the room in the room directory while it has clients.
+	Listed bool `json:"listed"`
+}
+
+// RoomMetadataStore stores metadata of rooms, keyed by room.
+type RoomMetadataStore interface {
+	RoomMetadata(room string) (RoomMetadata, error)
+	SetRoomMetadata(room string, metadata RoomMetadata) error
+}
+
+// RoomMetadataRemover is implemented by stores that only keep metadata while
+// the room exists. Adapters remove the metadata of their room when they are
+// closed.
+type RoomMetadataRemover interface {
+	RemoveRoomMetadata(room string)
+}
+
+// Removes the metadata of room when store only keeps it while the room
+// exists.
+func RemoveRoomMetadata(store RoomMetadataStore, room string) {
+	if remover, ok := store.(RoomMetadataRemover); ok {
+		remover.RemoveRoomMetadata(room)
+	}
+}
+
+// MemoryRoomMetadataStore keeps room metadata in-process, until the adapter
+// of the room is closed.
+type MemoryRoomMetadataStore struct {
+	mu       sync.RWMutex
+	metadata map[string]RoomMetadata
+}
+
+func NewMemoryRoomMetadataStore() *MemoryRoomMetadataStore {
+	return &MemoryRoomMetadataStore{
+		metadata: map[string]RoomMetadata{},
+	}
+}
+
+func (s *MemoryRoomMetadataStore) RoomMetadata(room string) (RoomMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.metadata[room], nil
+}
+
+func (s *MemoryRoomMetadataStore) SetRoomMetadata(room string, metadata RoomMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if metadata == (RoomMetadata{}) {
+		delete(s.metadata, room)
+	} else {
+		s.metadata[room] = metadata
+	}
+	return nil
+}
+
+func (s *MemoryRoomMetadataStore) RemoveRoomMetadata(room string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.metadata, room)
+}
@@ -0,0 +1,38 @@
+package wsadapter_test
+
+import (
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRoomMetadataStore(t *testing.T) {
+	s := wsadapter.NewMemoryRoomMetadataStore()
+
+	metadata, err := s.RoomMetadata("room1")
+	require.Nil(t, err)
+	assert.Equal(t, wsadapter.RoomMetadata{}, metadata)
+
+	expected := wsadapter.RoomMetadata{Locked: true, Topic: "topic"}
+	require.Nil(t, s.SetRoomMetadata("room1", expected))
+	metadata, err = s.RoomMetadata("room1")
+	require.Nil(t, err)
+	assert.Equal(t, expected, metadata)
+
+	metadata, err = s.RoomMetadata("room2")
+	require.Nil(t, err)
+	assert.Equal(t, wsadapter.RoomMetadata{}, metadata)
+
+	require.Nil(t, s.SetRoomMetadata("room1", wsadapter.RoomMetadata{}))
+	metadata, err = s.RoomMetadata("room1")
+	require.Nil(t, err)
+	assert.Equal(t, wsadapter.RoomMetadata{}, metadata)
+
+	require.Nil(t, s.SetRoomMetadata("room1", expected))
+	wsadapter.RemoveRoomMetadata(s, "room1")
+	metadata, err = s.RoomMetadata("room1")
+	require.Nil(t, err)
+	assert.Equal(t, wsadapter.RoomMetadata{}, metadata)
+}
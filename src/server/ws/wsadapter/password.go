@@ -0,0 +1,106 @@
+package wsadapter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/basen"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Generates a random room password for rooms whose password is chosen by
+// the server.
+func NewRoomPassword() string {
+	return basen.NewUUIDBase62()
+}
+
+// Hashes a room password with bcrypt so that it can be stored outside of the
+// process.
+func HashRoomPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("HashRoomPassword - error hashing password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Returns true when password matches a hash returned by HashRoomPassword.
+func CheckRoomPassword(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// RoomPasswordStore stores password hashes of rooms, keyed by room. Unlike
+// adapters, stores are not closed when rooms become empty, so passwords set
+// through the HTTP API before any client joins are kept. Passwords expire
+// when they are not read or set for the TTL of the store.
+type RoomPasswordStore interface {
+	RoomPassword(room string) (string, error)
+	// Removes the password when hash is empty.
+	SetRoomPassword(room string, hash string) error
+}
+
+type roomPassword struct {
+	hash      string
+	expiresAt time.Time
+}
+
+// MemoryRoomPasswordStore keeps room password hashes in-process.
+type MemoryRoomPasswordStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	now       func() time.Time
+	passwords map[string]roomPassword
+}
+
+// Creates a store whose passwords expire after ttl. Passwords never expire
+// when ttl is zero.
+func NewMemoryRoomPasswordStore(ttl time.Duration) *MemoryRoomPasswordStore {
+	return &MemoryRoomPasswordStore{
+		ttl:       ttl,
+		now:       time.Now,
+		passwords: map[string]roomPassword{},
+	}
+}
+
+// Returns the password hash of room and resets its expiry.
+func (s *MemoryRoomPasswordStore) RoomPassword(room string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	password, ok := s.passwords[room]
+	if !ok {
+		return "", nil
+	}
+	s.set(room, password.hash)
+	return password.hash, nil
+}
+
+func (s *MemoryRoomPasswordStore) SetRoomPassword(room string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	if hash == "" {
+		delete(s.passwords, room)
+	} else {
+		s.set(room, hash)
+	}
+	return nil
+}
+
+func (s *MemoryRoomPasswordStore) set(room string, hash string) {
+	password := roomPassword{hash: hash}
+	if s.ttl > 0 {
+		password.expiresAt = s.now().Add(s.ttl)
+	}
+	s.passwords[room] = password
+}
+
+func (s *MemoryRoomPasswordStore) removeExpired() {
+	now := s.now()
+	for room, password := range s.passwords {
+		if !password.expiresAt.IsZero() && !now.Before(password.expiresAt) {
+			delete(s.passwords, room)
+		}
+	}
+}
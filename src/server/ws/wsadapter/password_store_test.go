@@ -0,0 +1,69 @@
+package wsadapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRoomPasswordStore(t *testing.T) {
+	store := NewMemoryRoomPasswordStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	hash, err := store.RoomPassword("room1")
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+
+	require.Nil(t, store.SetRoomPassword("room1", "hash1"))
+	hash, err = store.RoomPassword("room1")
+	require.Nil(t, err)
+	assert.Equal(t, "hash1", hash)
+
+	hash, err = store.RoomPassword("room2")
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+
+	require.Nil(t, store.SetRoomPassword("room1", ""))
+	hash, err = store.RoomPassword("room1")
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+}
+
+func TestMemoryRoomPasswordStore_ttl(t *testing.T) {
+	store := NewMemoryRoomPasswordStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.Nil(t, store.SetRoomPassword("room1", "hash1"))
+	require.Nil(t, store.SetRoomPassword("room2", "hash2"))
+
+	now = now.Add(50 * time.Second)
+	hash, err := store.RoomPassword("room1")
+	require.Nil(t, err)
+	assert.Equal(t, "hash1", hash, "reading should reset the expiry")
+
+	now = now.Add(50 * time.Second)
+	hash, err = store.RoomPassword("room1")
+	require.Nil(t, err)
+	assert.Equal(t, "hash1", hash)
+
+	hash, err = store.RoomPassword("room2")
+	require.Nil(t, err)
+	assert.Equal(t, "", hash, "should have expired")
+	assert.Len(t, store.passwords, 1)
+}
+
+func TestMemoryRoomPasswordStore_noTTL(t *testing.T) {
+	store := NewMemoryRoomPasswordStore(0)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.Nil(t, store.SetRoomPassword("room1", "hash1"))
+	now = now.Add(24 * 365 * time.Hour)
+	hash, err := store.RoomPassword("room1")
+	require.Nil(t, err)
+	assert.Equal(t, "hash1", hash)
+}
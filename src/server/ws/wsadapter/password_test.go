@@ -0,0 +1,36 @@
+package wsadapter_test
+
+import (
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomPassword(t *testing.T) {
+	hash, err := wsadapter.HashRoomPassword("secret")
+	require.Nil(t, err)
+	assert.NotContains(t, hash, "secret")
+
+	assert.True(t, wsadapter.CheckRoomPassword(hash, "secret"))
+	assert.False(t, wsadapter.CheckRoomPassword(hash, "Secret"))
+	assert.False(t, wsadapter.CheckRoomPassword(hash, ""))
+
+	other, err := wsadapter.HashRoomPassword("secret")
+	require.Nil(t, err)
+	assert.NotEqual(t, hash, other, "hashes should be salted")
+	assert.Regexp(t, `^\$2a\$`, hash, "should be a bcrypt hash")
+}
+
+func TestCheckRoomPassword_invalidHash(t *testing.T) {
+	for _, hash := range []string{"", "invalid", "zz:00", "00:zz", "$2a$10$"} {
+		assert.False(t, wsadapter.CheckRoomPassword(hash, ""), "hash: %q", hash)
+	}
+}
+
+func TestNewRoomPassword(t *testing.T) {
+	password := wsadapter.NewRoomPassword()
+	assert.NotEqual(t, "", password)
+	assert.NotEqual(t, password, wsadapter.NewRoomPassword())
+}
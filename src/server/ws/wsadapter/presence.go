@@ -0,0 +1,148 @@
+package wsadapter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// Presence batches join and leave messages of a room received within a
+// window into a single room state delta, so that clients of large rooms are
+// not flooded with messages when many clients join or leave at once. Deltas
+// are only delivered to clients that subscribed to them, the others still
+// receive the join and leave messages.
+type Presence struct {
+	room   string
+	window time.Duration
+	flush  func(msg wsmessage.Message)
+
+	mu     sync.Mutex
+	joined map[string]string
+	left   map[string]string
+	timer  *time.Timer
+	closed bool
+}
+
+// Creates a batch which calls flush with the delta once the window has
+// passed since the first batched message. Returns nil when window is zero.
+func NewPresence(room string, window time.Duration, flush func(msg wsmessage.Message)) *Presence {
+	if window <= 0 {
+		return nil
+	}
+	return &Presence{
+		room:   room,
+		window: window,
+		flush:  flush,
+		joined: map[string]string{},
+		left:   map[string]string{},
+	}
+}
+
+// explicitSubscriber is implemented by clients that can tell whether they
+// subscribed to a message type by name, e.g. ws.Client.
+type explicitSubscriber interface {
+	SubscribedExplicitly(typ string) bool
+}
+
+// Returns true when client subscribed to room state deltas. Clients that
+// subscribed to all message types do not, because older clients do not
+// understand them.
+func ReceivesRoomStateDeltas(client Client) bool {
+	subscriber, ok := client.(explicitSubscriber)
+	return ok && subscriber.SubscribedExplicitly(wsmessage.MessageTypeRoomStateDelta)
+}
+
+// Returns false when a broadcast message of type typ should be skipped for
+// client. When p is not nil, clients receiving room state deltas do not
+// receive the join and leave messages batched into them.
+func (p *Presence) Subscribed(client Client, typ string) bool {
+	if !client.Subscribed(typ) {
+		return false
+	}
+	if p == nil {
+		return true
+	}
+
+	switch typ {
+	case wsmessage.MessageTypeRoomJoin, wsmessage.MessageTypeRoomLeave:
+		return !ReceivesRoomStateDeltas(client)
+	case wsmessage.MessageTypeRoomStateDelta:
+		return ReceivesRoomStateDeltas(client)
+	default:
+		return true
+	}
+}
+
+// Adds a join or leave message to the batch. Returns false when it is
+// neither, or when p is nil. Join and leave messages should still be
+// broadcast to the clients that do not receive deltas.
+func (p *Presence) Add(msg wsmessage.Message) bool {
+	if p == nil {
+		return false
+	}
+
+	if clientID, metadata, ok := wsmessage.RoomJoinState(msg); ok {
+		p.add(func() {
+			delete(p.left, clientID)
+			p.joined[clientID] = metadata
+		})
+		return true
+	}
+
+	if clientID, reason, ok := wsmessage.RoomLeaveState(msg); ok {
+		p.add(func() {
+			delete(p.joined, clientID)
+			p.left[clientID] = reason
+		})
+		return true
+	}
+
+	return false
+}
+
+func (p *Presence) add(update func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	update()
+	if p.timer == nil {
+		p.timer = time.AfterFunc(p.window, p.flushDelta)
+	}
+}
+
+func (p *Presence) flushDelta() {
+	p.mu.Lock()
+	joined, left := p.joined, p.left
+	p.joined = map[string]string{}
+	p.left = map[string]string{}
+	p.timer = nil
+	closed := p.closed
+	p.mu.Unlock()
+
+	// the adapter lock is acquired by flush, so it must not be called with
+	// mu locked
+	if !closed && (len(joined) > 0 || len(left) > 0) {
+		p.flush(wsmessage.NewMessageRoomStateDelta(p.room, joined, left))
+	}
+}
+
+// Discards the batched messages.
+func (p *Presence) Close() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
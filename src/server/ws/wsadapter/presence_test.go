@@ -0,0 +1,79 @@
+package wsadapter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const presenceRoom = "test-room"
+
+func TestPresence_disabled(t *testing.T) {
+	p := wsadapter.NewPresence(presenceRoom, 0, func(wsmessage.Message) {})
+	assert.Nil(t, p)
+	assert.False(t, p.Add(wsmessage.NewMessageRoomJoin(presenceRoom, "a", "")))
+	p.Close()
+}
+
+func TestPresence_batch(t *testing.T) {
+	deltas := make(chan wsmessage.Message, 10)
+	p := wsadapter.NewPresence(presenceRoom, 20*time.Millisecond, func(msg wsmessage.Message) {
+		deltas <- msg
+	})
+	defer p.Close()
+
+	assert.False(t, p.Add(wsmessage.NewMessage("test", presenceRoom, nil)))
+
+	assert.True(t, p.Add(wsmessage.NewMessageRoomJoin(presenceRoom, "a", "meta-a")))
+	assert.True(t, p.Add(wsmessage.NewMessageRoomJoin(presenceRoom, "b", "meta-b")))
+	assert.True(t, p.Add(wsmessage.NewMessageRoomLeaveWithReason(presenceRoom, "b", wsmessage.LeaveReasonTimeout)))
+	assert.True(t, p.Add(wsmessage.NewMessageRoomLeave(presenceRoom, "c")))
+	assert.True(t, p.Add(wsmessage.NewMessageRoomJoin(presenceRoom, "c", "meta-c2")))
+	assert.True(t, p.Add(wsmessage.NewMessage(wsmessage.MessageTypeRoomLeave, presenceRoom, map[string]interface{}{
+		"clientID": "d",
+		"reason":   wsmessage.LeaveReasonDisconnected,
+	})))
+	// deserialized leave message of an older server
+	assert.True(t, p.Add(wsmessage.NewMessage(wsmessage.MessageTypeRoomLeave, presenceRoom, "e")))
+
+	select {
+	case delta := <-deltas:
+		assert.Equal(t, wsmessage.NewMessageRoomStateDelta(presenceRoom, map[string]string{
+			"a": "meta-a",
+			"c": "meta-c2",
+		}, map[string]string{
+			"b": wsmessage.LeaveReasonTimeout,
+			"d": wsmessage.LeaveReasonDisconnected,
+			"e": "",
+		}), delta)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for delta")
+	}
+
+	select {
+	case delta := <-deltas:
+		assert.Fail(t, "unexpected delta", "%v", delta)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPresence_Close(t *testing.T) {
+	deltas := make(chan wsmessage.Message, 10)
+	p := wsadapter.NewPresence(presenceRoom, 10*time.Millisecond, func(msg wsmessage.Message) {
+		deltas <- msg
+	})
+
+	p.Add(wsmessage.NewMessageRoomJoin(presenceRoom, "a", ""))
+	p.Close()
+	p.Add(wsmessage.NewMessageRoomJoin(presenceRoom, "b", ""))
+
+	select {
+	case delta := <-deltas:
+		assert.Fail(t, "unexpected delta", "%v", delta)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
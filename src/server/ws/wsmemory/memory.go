@@ -3,45 +3,110 @@ package wsmemory
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 )
 
 type MemoryAdapter struct {
-	clientsMu *sync.RWMutex
-	clients   map[string]wsadapter.Client
-	room      string
+	clientsMu         *sync.RWMutex
+	clients           map[string]wsadapter.Client
+	room              string
+	chatHistory       *wsadapter.History
+	raisedHands       *wsadapter.RaisedHands
+	roomMetadataStore wsadapter.RoomMetadataStore
+	createdAt         time.Time
+	roomPasswordStore wsadapter.RoomPasswordStore
+	presence          *wsadapter.Presence
+	owner             string
 }
 
 func NewMemoryAdapter(room string) *MemoryAdapter {
+	return NewMemoryAdapterWithParams(room, wsadapter.Params{})
+}
+
+func NewMemoryAdapterWithParams(room string, params wsadapter.Params) *MemoryAdapter {
 	var clientsMu sync.RWMutex
-	return &MemoryAdapter{
-		clientsMu: &clientsMu,
-		clients:   map[string]wsadapter.Client{},
-		room:      room,
+	roomMetadataStore := params.RoomMetadataStore
+	if roomMetadataStore == nil {
+		roomMetadataStore = wsadapter.NewMemoryRoomMetadataStore()
+	}
+	roomPasswordStore := params.RoomPasswordStore
+	if roomPasswordStore == nil {
+		roomPasswordStore = wsadapter.NewMemoryRoomPasswordStore(0)
 	}
+	m := &MemoryAdapter{
+		clientsMu:         &clientsMu,
+		clients:           map[string]wsadapter.Client{},
+		room:              room,
+		chatHistory:       wsadapter.NewHistory(params.ChatHistorySize),
+		raisedHands:       wsadapter.NewRaisedHands(),
+		roomMetadataStore: roomMetadataStore,
+		roomPasswordStore: roomPasswordStore,
+		createdAt:         time.Now(),
+	}
+	m.presence = wsadapter.NewPresence(room, params.PresenceDebounce, func(delta wsmessage.Message) {
+		m.clientsMu.RLock()
+		defer m.clientsMu.RUnlock()
+		m.broadcast(delta)
+	})
+	return m
 }
 
 // Add a client to the room
 func (m *MemoryAdapter) Add(client wsadapter.Client) (err error) {
 	m.clientsMu.Lock()
 	clientID := client.ID()
+	old, replaced := m.clients[clientID]
+	if len(m.clients) == 0 {
+		m.owner = clientID
+	}
 	m.clients[clientID] = client
-	err = m.broadcast(wsmessage.NewMessageRoomJoin(m.room, clientID, client.Metadata()))
+	if replaced {
+		wsadapter.NotifyReplaced(old)
+	} else {
+		err = m.broadcastPresence(wsmessage.NewMessageRoomJoin(m.room, clientID, client.Metadata()))
+	}
+	if history := m.chatHistory.Messages(); len(history) > 0 {
+		if emitErr := m.emit(clientID, wsmessage.NewMessageChatHistory(m.room, history)); emitErr != nil && err == nil {
+			err = emitErr
+		}
+	}
+	if hands := m.raisedHands.Messages(); len(hands) > 0 {
+		if emitErr := m.emit(clientID, wsmessage.NewMessageRaisedHands(m.room, hands)); emitErr != nil && err == nil {
+			err = emitErr
+		}
+	}
 	m.clientsMu.Unlock()
 	return
 }
 
 func (m *MemoryAdapter) Close() error {
+	m.presence.Close()
+	wsadapter.RemoveRoomMetadata(m.roomMetadataStore, m.room)
 	return nil
 }
 
 // Remove a client from the room
-func (m *MemoryAdapter) Remove(clientID string) (err error) {
+func (m *MemoryAdapter) Remove(clientID string) error {
+	return m.RemoveWithReason(clientID, wsmessage.LeaveReasonLeft)
+}
+
+// Remove a client from the room, notifying others why the client left
+func (m *MemoryAdapter) RemoveWithReason(clientID string, reason string) (err error) {
+	return m.RemoveWithMessage(clientID, wsmessage.NewMessageRoomLeaveWithReason(m.room, clientID, reason))
+}
+
+// Remove a client from the room and broadcast the leave message
+func (m *MemoryAdapter) RemoveWithMessage(clientID string, leave wsmessage.Message) (err error) {
 	m.clientsMu.Lock()
-	err = m.broadcast(wsmessage.NewMessageRoomLeave(m.room, clientID))
+	err = m.broadcastPresence(leave)
 	delete(m.clients, clientID)
+	if clientID == m.owner {
+		m.owner = ""
+	}
+	m.raisedHands.Remove(clientID)
 	m.clientsMu.Unlock()
 	return
 }
@@ -73,6 +138,39 @@ func (m *MemoryAdapter) Clients() (clientIDs map[string]string, err error) {
 	return
 }
 
+func (m *MemoryAdapter) RoomMetadata() (wsadapter.RoomMetadata, error) {
+	return m.roomMetadataStore.RoomMetadata(m.room)
+}
+
+// Stores room metadata and sends it to all clients in the room
+func (m *MemoryAdapter) SetRoomMetadata(metadata wsadapter.RoomMetadata) error {
+	if err := m.roomMetadataStore.SetRoomMetadata(m.room, metadata); err != nil {
+		return fmt.Errorf("MemoryAdapter.SetRoomMetadata - error storing metadata: %w", err)
+	}
+	return m.Broadcast(wsmessage.NewMessageRoomMetadata(m.room, metadata))
+}
+
+// Returns the time the adapter was created. Adapters live as long as the room
+// has clients.
+func (m *MemoryAdapter) CreatedAt() (time.Time, error) {
+	return m.createdAt, nil
+}
+
+// Returns the hash of the room password from the RoomPasswordStore.
+func (m *MemoryAdapter) RoomPassword() (string, error) {
+	return m.roomPasswordStore.RoomPassword(m.room)
+}
+
+func (m *MemoryAdapter) SetRoomPassword(hash string) error {
+	return m.roomPasswordStore.SetRoomPassword(m.room, hash)
+}
+
+func (m *MemoryAdapter) Owner() (string, error) {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+	return m.owner, nil
+}
+
 func (m *MemoryAdapter) Size() (value int, err error) {
 	m.clientsMu.RLock()
 	value = len(m.clients)
@@ -82,6 +180,12 @@ func (m *MemoryAdapter) Size() (value int, err error) {
 
 // Send a message to all sockets
 func (m *MemoryAdapter) Broadcast(msg wsmessage.Message) error {
+	switch msg.Type {
+	case wsmessage.MessageTypeChat:
+		m.chatHistory.Add(msg)
+	case wsmessage.MessageTypeRaiseHand:
+		m.raisedHands.Update(msg)
+	}
 	m.clientsMu.RLock()
 	err := m.broadcast(msg)
 	m.clientsMu.RUnlock()
@@ -89,7 +193,28 @@ func (m *MemoryAdapter) Broadcast(msg wsmessage.Message) error {
 }
 
 func (m *MemoryAdapter) broadcast(msg wsmessage.Message) (err error) {
-	for clientID := range m.clients {
+	for clientID, client := range m.clients {
+		if !m.presence.Subscribed(client, msg.Type) {
+			continue
+		}
+		if emitErr := m.emit(clientID, msg); emitErr != nil && err == nil {
+			err = emitErr
+		}
+	}
+	return
+}
+
+// Batches join and leave messages when presence debouncing is enabled.
+func (m *MemoryAdapter) broadcastPresence(msg wsmessage.Message) error {
+	m.presence.Add(msg)
+	return m.broadcast(msg)
+}
+
+func (m *MemoryAdapter) BroadcastTo(clientIDs []string, msg wsmessage.Message) (err error) {
+	m.clientsMu.RLock()
+	defer m.clientsMu.RUnlock()
+
+	for _, clientID := range clientIDs {
 		if emitErr := m.emit(clientID, msg); emitErr != nil && err == nil {
 			err = emitErr
 		}
@@ -110,11 +235,8 @@ func (m *MemoryAdapter) emit(clientID string, msg wsmessage.Message) error {
 	if !ok {
 		return fmt.Errorf("wsadapter.Client not found, clientID: %s", clientID)
 	}
-	select {
-	case client.WriteChannel() <- msg:
-		return nil
-	default:
-		return fmt.Errorf("wsadapter.Client buffer full, clientID: %s", clientID)
-		// if the client buffer is full, it will not be sent
+	if err := client.Send(msg); err != nil {
+		return fmt.Errorf("wsadapter.Client send failed, clientID: %s: %w", clientID, err)
 	}
+	return nil
 }
@@ -3,10 +3,13 @@ package wsmemory_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/ws"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/stretchr/testify/assert"
@@ -69,6 +72,52 @@ func TestMemoryAdapter_add_remove_clients(t *testing.T) {
 	assert.Equal(t, 0, size)
 }
 
+func TestMemoryAdapter_owner(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	defer adapter.Close()
+
+	clients := make([]*ws.Client, 5)
+	var wg sync.WaitGroup
+	for i := range clients {
+		clients[i] = ws.NewClient(NewMockWriter())
+		defer clients[i].Close()
+		wg.Add(1)
+		go func(client *ws.Client) {
+			defer wg.Done()
+			assert.Nil(t, adapter.Add(client))
+		}(clients[i])
+	}
+	wg.Wait()
+
+	owner, err := adapter.Owner()
+	require.Nil(t, err)
+	var others []string
+	for _, client := range clients {
+		if client.ID() != owner {
+			others = append(others, client.ID())
+		}
+	}
+	require.Equal(t, len(clients)-1, len(others), "expected one of the clients to be the owner")
+
+	require.Nil(t, adapter.Remove(others[0]))
+	owner2, err := adapter.Owner()
+	require.Nil(t, err)
+	assert.Equal(t, owner, owner2, "expected the owner to be kept")
+
+	require.Nil(t, adapter.Remove(owner))
+	owner2, err = adapter.Owner()
+	require.Nil(t, err)
+	assert.Equal(t, "", owner2, "expected no owner after the owner left")
+
+	for _, clientID := range others[1:] {
+		require.Nil(t, adapter.Remove(clientID))
+	}
+	require.Nil(t, adapter.Add(clients[0]))
+	owner2, err = adapter.Owner()
+	require.Nil(t, err)
+	assert.Equal(t, clients[0].ID(), owner2, "expected a new owner once the room was empty")
+}
+
 func TestMemoryAdapter_emitFound(t *testing.T) {
 	adapter := wsmemory.NewMemoryAdapter(room)
 	mockWriter := NewMockWriter()
@@ -137,3 +186,442 @@ func TestMemoryAdapter_Brodacast(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestMemoryAdapter_Broadcast_messageTypes(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	mockWriter1 := NewMockWriter()
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	mockWriter2 := NewMockWriter()
+	client2 := ws.NewClient(mockWriter2)
+	client2.SetMessageTypes([]string{"other-type"})
+	defer client2.Close()
+	defer close(mockWriter1.out)
+	defer close(mockWriter2.out)
+	assert.Nil(t, adapter.Add(client1))
+	assert.Nil(t, adapter.Add(client2))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		client1.Subscribe(ctx, func(msg wsmessage.Message) {})
+		wg.Done()
+	}()
+	go func() {
+		client2.Subscribe(ctx, func(msg wsmessage.Message) {})
+		wg.Done()
+	}()
+	msg := wsmessage.NewMessage("test-type", room, []byte("test"))
+	otherMsg := wsmessage.NewMessage("other-type", room, []byte("test"))
+	assert.Nil(t, adapter.Broadcast(msg))
+	assert.Nil(t, adapter.Broadcast(otherMsg))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client1.ID(), "")), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, msg), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, otherMsg), <-mockWriter1.out)
+	// room join messages are not subscribed either
+	assert.Equal(t, serialize(t, otherMsg), <-mockWriter2.out)
+	cancel()
+	wg.Wait()
+}
+
+func TestMemoryAdapter_BroadcastTo(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	var clients []*ws.Client
+	var writers []*MockWSWriter
+	for i := 0; i < 3; i++ {
+		mockWriter := NewMockWriter()
+		defer close(mockWriter.out)
+		client := ws.NewClientWithID(mockWriter, fmt.Sprintf("client%d", i))
+		defer client.Close()
+		clients = append(clients, client)
+		writers = append(writers, mockWriter)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(len(clients))
+	for _, client := range clients {
+		go func(client *ws.Client) {
+			client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			wg.Done()
+		}(client)
+	}
+	for _, client := range clients {
+		assert.Nil(t, adapter.Add(client))
+	}
+
+	msg := wsmessage.NewMessage("test-type", room, "test")
+	assert.Nil(t, adapter.BroadcastTo([]string{"client0", "client2"}, msg))
+	other := wsmessage.NewMessage("other-type", room, "test")
+	assert.Nil(t, adapter.Broadcast(other))
+
+	readNext := func(w *MockWSWriter) []byte {
+		for {
+			data := <-w.out
+			m, err := serializer.Deserialize(data)
+			require.Nil(t, err)
+			if m.Type != wsmessage.MessageTypeRoomJoin {
+				return data
+			}
+		}
+	}
+	assert.Equal(t, serialize(t, msg), readNext(writers[0]))
+	assert.Equal(t, serialize(t, other), readNext(writers[0]))
+	assert.Equal(t, serialize(t, other), readNext(writers[1]), "client1 was not targeted")
+	assert.Equal(t, serialize(t, msg), readNext(writers[2]))
+	assert.Equal(t, serialize(t, other), readNext(writers[2]))
+
+	err := adapter.BroadcastTo([]string{"missing"}, msg)
+	assert.NotNil(t, err)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestMemoryAdapter_chatHistory(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapterWithParams(room, wsadapter.Params{
+		ChatHistorySize: 2,
+	})
+	mockWriter1 := NewMockWriter()
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	mockWriter2 := NewMockWriter()
+	client2 := ws.NewClient(mockWriter2)
+	defer client2.Close()
+	defer close(mockWriter1.out)
+	defer close(mockWriter2.out)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, client := range []*ws.Client{client1, client2} {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, but got: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	assert.Nil(t, adapter.Add(client1))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client1.ID(), "")), <-mockWriter1.out)
+	chat := []wsmessage.Message{
+		wsmessage.NewMessageChat(room, client1.ID(), "a"),
+		wsmessage.NewMessageChat(room, client1.ID(), "b"),
+		wsmessage.NewMessageChat(room, client1.ID(), "c"),
+	}
+	for _, msg := range chat {
+		assert.Nil(t, adapter.Broadcast(msg))
+		assert.Equal(t, serialize(t, msg), <-mockWriter1.out)
+	}
+
+	assert.Nil(t, adapter.Add(client2))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), <-mockWriter2.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageChatHistory(room, chat[1:])), <-mockWriter2.out)
+	cancel()
+	wg.Wait()
+}
+
+func TestMemoryAdapter_roomMetadata(t *testing.T) {
+	store := wsadapter.NewMemoryRoomMetadataStore()
+	params := wsadapter.Params{RoomMetadataStore: store}
+	adapter := wsmemory.NewMemoryAdapterWithParams(room, params)
+	mockWriter := NewMockWriter()
+	client := ws.NewClient(mockWriter)
+	defer client.Close()
+	defer close(mockWriter.out)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+		assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, but got: %s", err)
+		wg.Done()
+	}()
+
+	assert.Nil(t, adapter.Add(client))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client.ID(), "")), <-mockWriter.out)
+
+	metadata := wsadapter.RoomMetadata{Locked: true, Topic: "topic", WelcomeMessage: "welcome"}
+	assert.Nil(t, adapter.SetRoomMetadata(metadata))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomMetadata(room, metadata)), <-mockWriter.out)
+	cancel()
+	wg.Wait()
+
+	// adapters of the same room share metadata through the store
+	restored, err := wsmemory.NewMemoryAdapterWithParams(room, params).RoomMetadata()
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, restored)
+
+	other, err := wsmemory.NewMemoryAdapterWithParams("other-room", params).RoomMetadata()
+	assert.Nil(t, err)
+	assert.Equal(t, wsadapter.RoomMetadata{}, other)
+
+	// the metadata is removed together with the room
+	assert.Nil(t, adapter.Close())
+	removed, err := store.RoomMetadata(room)
+	assert.Nil(t, err)
+	assert.Equal(t, wsadapter.RoomMetadata{}, removed)
+}
+
+func TestMemoryAdapter_roomPassword(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	defer adapter.Close()
+
+	hash, err := adapter.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+
+	require.Nil(t, adapter.SetRoomPassword("hash"))
+	hash, err = adapter.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "hash", hash)
+
+	require.Nil(t, adapter.SetRoomPassword(""))
+	hash, err = adapter.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+}
+
+func TestMemoryAdapter_roomPassword_store(t *testing.T) {
+	params := wsadapter.Params{
+		RoomPasswordStore: wsadapter.NewMemoryRoomPasswordStore(time.Hour),
+	}
+	adapter := wsmemory.NewMemoryAdapterWithParams(room, params)
+	require.Nil(t, adapter.SetRoomPassword("hash"))
+	assert.Nil(t, adapter.Close())
+
+	adapter = wsmemory.NewMemoryAdapterWithParams(room, params)
+	defer adapter.Close()
+	hash, err := adapter.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "hash", hash, "expected the password to outlive the adapter")
+}
+
+func TestMemoryAdapter_raisedHands(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	mockWriter1 := NewMockWriter()
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	mockWriter2 := NewMockWriter()
+	client2 := ws.NewClient(mockWriter2)
+	defer client2.Close()
+	mockWriter3 := NewMockWriter()
+	client3 := ws.NewClient(mockWriter3)
+	defer client3.Close()
+	defer close(mockWriter1.out)
+	defer close(mockWriter2.out)
+	defer close(mockWriter3.out)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for _, client := range []*ws.Client{client1, client2, client3} {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, but got: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	assert.Nil(t, adapter.Add(client1))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client1.ID(), "")), <-mockWriter1.out)
+	assert.Nil(t, adapter.Add(client2))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), <-mockWriter2.out)
+
+	raiseHand := wsmessage.NewMessageRaiseHand(room, client1.ID(), "", true, 1)
+	assert.Nil(t, adapter.Broadcast(raiseHand))
+	assert.Equal(t, serialize(t, raiseHand), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, raiseHand), <-mockWriter2.out)
+
+	assert.Nil(t, adapter.Add(client3))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client3.ID(), "")), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client3.ID(), "")), <-mockWriter2.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client3.ID(), "")), <-mockWriter3.out)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRaisedHands(room, []wsmessage.Message{raiseHand})), <-mockWriter3.out)
+
+	assert.Nil(t, adapter.Remove(client1.ID()))
+	leave := serialize(t, wsmessage.NewMessageRoomLeave(room, client1.ID()))
+	assert.Equal(t, leave, <-mockWriter1.out)
+	assert.Equal(t, leave, <-mockWriter2.out)
+	assert.Equal(t, leave, <-mockWriter3.out)
+	cancel()
+	wg.Wait()
+}
+
+type recordingClient struct {
+	id     string
+	deltas bool
+
+	mu       sync.Mutex
+	messages []wsmessage.Message
+}
+
+func (c *recordingClient) ID() string                  { return c.id }
+func (c *recordingClient) Subscribed(typ string) bool  { return true }
+func (c *recordingClient) Metadata() string            { return "meta-" + c.id }
+func (c *recordingClient) SetMetadata(metadata string) {}
+
+func (c *recordingClient) SubscribedExplicitly(typ string) bool {
+	return c.deltas && typ == wsmessage.MessageTypeRoomStateDelta
+}
+
+func (c *recordingClient) Send(msg wsmessage.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *recordingClient) Messages() []wsmessage.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]wsmessage.Message{}, c.messages...)
+}
+
+func TestMemoryAdapter_presenceDebounce(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapterWithParams(room, wsadapter.Params{
+		PresenceDebounce: 50 * time.Millisecond,
+	})
+	defer adapter.Close()
+
+	a := &recordingClient{id: "a", deltas: true}
+	require.Nil(t, adapter.Add(a))
+	legacy := &recordingClient{id: "legacy"}
+	require.Nil(t, adapter.Add(legacy))
+	require.Nil(t, adapter.Add(&recordingClient{id: "b"}))
+	require.Nil(t, adapter.Add(&recordingClient{id: "c"}))
+	require.Nil(t, adapter.RemoveWithReason("b", wsmessage.LeaveReasonKicked))
+	require.Nil(t, adapter.Broadcast(wsmessage.NewMessage("test", room, nil)))
+
+	assert.Equal(t, []wsmessage.Message{
+		wsmessage.NewMessage("test", room, nil),
+	}, a.Messages(), "join and leave messages should be batched")
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []wsmessage.Message{
+		wsmessage.NewMessage("test", room, nil),
+		wsmessage.NewMessageRoomStateDelta(room, map[string]string{
+			"a":      "meta-a",
+			"legacy": "meta-legacy",
+			"c":      "meta-c",
+		}, map[string]string{
+			"b": wsmessage.LeaveReasonKicked,
+		}),
+	}, a.Messages())
+	assert.Equal(t, []wsmessage.Message{
+		wsmessage.NewMessageRoomJoin(room, "legacy", "meta-legacy"),
+		wsmessage.NewMessageRoomJoin(room, "b", "meta-b"),
+		wsmessage.NewMessageRoomJoin(room, "c", "meta-c"),
+		wsmessage.NewMessageRoomLeaveWithReason(room, "b", wsmessage.LeaveReasonKicked),
+		wsmessage.NewMessage("test", room, nil),
+	}, legacy.Messages(), "clients not subscribed to deltas should receive join and leave messages")
+
+	require.Nil(t, adapter.Remove("c"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, wsmessage.NewMessageRoomStateDelta(room, map[string]string{}, map[string]string{
+		"c": wsmessage.LeaveReasonLeft,
+	}), a.Messages()[2], "changes after a flush should be batched again")
+}
+
+func TestMemoryAdapter_Broadcast_compression(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	mockWriter1 := NewMockWriter()
+	client1 := ws.NewClient(mockWriter1)
+	client1.SetCompression(true)
+	defer client1.Close()
+	mockWriter2 := NewMockWriter()
+	client2 := ws.NewClient(mockWriter2)
+	defer client2.Close()
+	defer close(mockWriter1.out)
+	defer close(mockWriter2.out)
+	assert.Nil(t, adapter.Add(client1))
+	assert.Nil(t, adapter.Add(client2))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		err := client1.Subscribe(ctx, func(msg wsmessage.Message) {})
+		assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, but got: %s", err)
+		wg.Done()
+	}()
+	go func() {
+		err := client2.Subscribe(ctx, func(msg wsmessage.Message) {})
+		assert.True(t, errors.Is(err, context.Canceled), "expected context.Canceled, but got: %s", err)
+		wg.Done()
+	}()
+	decompress := func(data []byte) []byte {
+		msg, err := wsmessage.CompressingSerializer{Serializer: serializer}.Deserialize(data)
+		require.Nil(t, err)
+		return serialize(t, msg)
+	}
+	msg := wsmessage.NewMessage("test-type", room, "test")
+	adapter.Broadcast(msg)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client1.ID(), "")), decompress(<-mockWriter1.out))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), decompress(<-mockWriter1.out))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "")), <-mockWriter2.out)
+	serializedMsg := serialize(t, msg)
+	assert.Equal(t, serializedMsg, decompress(<-mockWriter1.out))
+	assert.Equal(t, serializedMsg, <-mockWriter2.out)
+	cancel()
+	wg.Wait()
+}
+
+type replaceableClient struct {
+	id       string
+	mu       sync.Mutex
+	messages []wsmessage.Message
+	replaced bool
+}
+
+func (c *replaceableClient) ID() string                 { return c.id }
+func (c *replaceableClient) Subscribed(typ string) bool { return true }
+func (c *replaceableClient) Metadata() string           { return "" }
+func (c *replaceableClient) SetMetadata(string)         {}
+
+func (c *replaceableClient) Send(msg wsmessage.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *replaceableClient) Replaced() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replaced = true
+}
+
+func (c *replaceableClient) messageTypes() (types []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, msg := range c.messages {
+		types = append(types, msg.Type)
+	}
+	return types
+}
+
+func TestMemoryAdapter_Add_duplicate(t *testing.T) {
+	adapter := wsmemory.NewMemoryAdapter(room)
+	defer adapter.Close()
+	other := &replaceableClient{id: "b"}
+	client1 := &replaceableClient{id: "a"}
+	client2 := &replaceableClient{id: "a"}
+
+	require.Nil(t, adapter.Add(other))
+	require.Nil(t, adapter.Add(client1))
+	require.Nil(t, adapter.Add(client2))
+
+	assert.True(t, client1.replaced)
+	assert.False(t, client2.replaced)
+
+	clients, err := adapter.Clients()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "", "b": ""}, clients)
+
+	require.Nil(t, adapter.Broadcast(wsmessage.NewMessage("test-type", room, nil)))
+
+	join := wsmessage.MessageTypeRoomJoin
+	assert.Equal(t, []string{join, join, "test-type"}, other.messageTypes())
+	assert.Equal(t, []string{join}, client1.messageTypes())
+	assert.Equal(t, []string{"test-type"}, client2.messageTypes())
+}
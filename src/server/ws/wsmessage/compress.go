@@ -0,0 +1,46 @@
+package wsmessage
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+)
+
+// CompressingSerializer compresses messages encoded by Serializer with
+// DEFLATE (RFC 1951). Compressed messages are binary, so they must be sent
+// as binary websocket messages.
+type CompressingSerializer struct {
+	Serializer SerializerDeserializer
+}
+
+func (s CompressingSerializer) Serialize(m Message) ([]byte, error) {
+	data, err := s.Serializer.Serialize(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, fmt.Errorf("CompressingSerializer.Serialize - error creating writer: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("CompressingSerializer.Serialize - error compressing: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("CompressingSerializer.Serialize - error compressing: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (s CompressingSerializer) Deserialize(data []byte) (Message, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Message{}, fmt.Errorf("CompressingSerializer.Deserialize - error decompressing: %w", err)
+	}
+	return s.Serializer.Deserialize(data)
+}
@@ -0,0 +1,33 @@
+package wsmessage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressingSerializer(t *testing.T) {
+	serializer := wsmessage.CompressingSerializer{
+		Serializer: wsmessage.ByteSerializer{},
+	}
+	msg := wsmessage.NewMessageChat("room1", "client1", strings.Repeat("hello ", 100))
+
+	data, err := serializer.Serialize(msg)
+	require.Nil(t, err)
+
+	uncompressed, err := wsmessage.ByteSerializer{}.Serialize(msg)
+	require.Nil(t, err)
+	assert.Less(t, len(data), len(uncompressed))
+
+	decoded, err := serializer.Deserialize(data)
+	require.Nil(t, err)
+	expected, err := wsmessage.ByteSerializer{}.Deserialize(uncompressed)
+	require.Nil(t, err)
+	assert.Equal(t, expected, decoded)
+
+	_, err = serializer.Deserialize([]byte("not compressed"))
+	assert.NotNil(t, err)
+}
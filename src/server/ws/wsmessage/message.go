@@ -2,11 +2,102 @@ package wsmessage
 
 import (
 	"encoding/json"
+	"time"
 )
 
 const (
 	MessageTypeRoomJoin  string = "ws_room_join"
 	MessageTypeRoomLeave string = "ws_room_leave"
+	MessageTypeNotice    string = "ws_notice"
+	MessageTypeClientID  string = "ws_client_id"
+	MessageTypeError     string = "ws_error"
+	MessageTypePeerClose string = "ws_peer_close"
+	MessageTypeSubscribe string = "ws_subscribe"
+
+	MessageTypeRoomMetadata string = "ws_room_metadata"
+	MessageTypeRoomState    string = "ws_room_state"
+	MessageTypeRoomEnd      string = "ws_room_end"
+	MessageTypeRoomRedirect string = "ws_room_redirect"
+
+	MessageTypeRoomStateDelta string = "ws_room_state_delta"
+
+	MessageTypeChat        string = "ws_chat"
+	MessageTypeChatHistory string = "ws_chat_history"
+
+	MessageTypeRaiseHand   string = "ws_raise_hand"
+	MessageTypeRaisedHands string = "ws_raised_hands"
+	MessageTypeReaction    string = "ws_reaction"
+
+	MessageTypeQuality string = "ws_quality"
+
+	MessageTypeVideoPaused  string = "ws_video_paused"
+	MessageTypeVideoRequest string = "ws_video_request"
+
+	MessageTypeJoin            string = "ws_join"
+	MessageTypeSetRoomPassword string = "ws_set_room_password"
+	MessageTypeRoomPassword    string = "ws_room_password"
+
+	MessageTypeCompression string = "ws_compression"
+)
+
+// Reasons for a client leaving a room, sent in room leave messages.
+const (
+	LeaveReasonLeft         string = "left"
+	LeaveReasonDisconnected string = "disconnected"
+	LeaveReasonKicked       string = "kicked"
+	LeaveReasonTimeout      string = "timeout"
+	LeaveReasonRoomClosed   string = "room_closed"
+	LeaveReasonRedirected   string = "redirected"
+	LeaveReasonReplaced     string = "replaced"
+	LeaveReasonShutdown     string = "shutdown"
+)
+
+// Machine-readable codes sent in error messages.
+const (
+	// The server cannot accept more rooms, or the SFU cannot accept more
+	// transceivers in the room.
+	ErrorCodeRoomFull string = "room_full"
+	// The client sent too many messages and is being disconnected.
+	ErrorCodeRateLimited string = "rate_limited"
+	// A peer connection with the server could not be established or
+	// renegotiated.
+	ErrorCodeNegotiationFailed string = "negotiation_failed"
+	// A requested transceiver could not be added. The client can request it
+	// again or continue without it.
+	ErrorCodeTransceiverFailed string = "transceiver_failed"
+	// The client exceeded the bandwidth quota and is being disconnected.
+	ErrorCodeQuotaExceeded string = "quota_exceeded"
+	// The client sent a message of a type the server does not handle and is
+	// being disconnected.
+	ErrorCodeUnknownMessageType string = "unknown_message_type"
+	// The server does not handle the type of signal sent by the client. The
+	// connection stays open.
+	ErrorCodeUnexpectedSignal string = "unexpected_signal"
+	// The room has a password and the client supplied a different one. The
+	// connection is closed.
+	ErrorCodeInvalidPassword string = "invalid_password"
+	// The client is not allowed to change the room. The connection stays
+	// open.
+	ErrorCodeForbidden string = "forbidden"
+	// The metadata sent by the client, e.g. its display name, was rejected.
+	// The connection stays open, unless the metadata was supplied in the URL
+	// when connecting.
+	ErrorCodeInvalidMetadata string = "invalid_metadata"
+	// The server requires clients to supply metadata, e.g. a display name,
+	// when connecting. The connection is closed.
+	ErrorCodeMetadataRequired string = "metadata_required"
+	// Another connection was added to the room with the same client ID. The
+	// connection is closed.
+	ErrorCodeReplaced string = "replaced"
+)
+
+// Versions of the message envelope. Messages without a version predate
+// versioning and are migrated to the current version when deserialized.
+const (
+	MessageVersion0 int = 0
+	MessageVersion1 int = 1
+
+	MessageVersionCurrent = MessageVersion1
 )
 
 type Serializer interface {
@@ -17,6 +108,11 @@ type Deserializer interface {
 	Deserialize([]byte) (Message, error)
 }
 
+type SerializerDeserializer interface {
+	Serializer
+	Deserializer
+}
+
 // Simple message is a container for web-socket messages.
 type Message struct {
 	// Types 0-10 are reserved for base functionality, others can be used for
@@ -26,10 +122,12 @@ type Message struct {
 	Room string `json:"room"`
 	// Payload content
 	Payload interface{} `json:"payload"`
+	// Version of the message envelope and payload schema
+	Version int `json:"version,omitempty"`
 }
 
 func NewMessage(typ string, room string, payload interface{}) Message {
-	return Message{Type: typ, Room: room, Payload: payload}
+	return Message{Type: typ, Room: room, Payload: payload, Version: MessageVersionCurrent}
 }
 
 func NewMessageRoomJoin(room string, clientID string, metadata string) Message {
@@ -40,7 +138,273 @@ func NewMessageRoomJoin(room string, clientID string, metadata string) Message {
 }
 
 func NewMessageRoomLeave(room string, clientID string) Message {
-	return NewMessage(MessageTypeRoomLeave, room, clientID)
+	return NewMessageRoomLeaveWithReason(room, clientID, LeaveReasonLeft)
+}
+
+func NewMessageRoomLeaveWithReason(room string, clientID string, reason string) Message {
+	return NewMessage(MessageTypeRoomLeave, room, map[string]string{
+		"clientID": clientID,
+		"reason":   reason,
+	})
+}
+
+func NewMessageClientID(room string, clientID string) Message {
+	return NewMessage(MessageTypeClientID, room, clientID)
+}
+
+func NewMessageRoomMetadata(room string, metadata interface{}) Message {
+	return NewMessage(MessageTypeRoomMetadata, room, metadata)
+}
+
+// Creates a snapshot of the room sent to a client after it joins. Clients
+// maps IDs of all clients in the room, including the joining client, to their
+// metadata.
+func NewMessageRoomState(room string, clients map[string]string, locked bool, topic string) Message {
+	return NewMessage(MessageTypeRoomState, room, map[string]interface{}{
+		"clients": clients,
+		"locked":  locked,
+		"topic":   topic,
+	})
+}
+
+// Creates an update of the clients in the room that batches join and leave
+// messages. Joined maps IDs of clients that joined to their metadata, and
+// left maps IDs of clients that left to the reason they left. Clients apply
+// it to the last room state. Only sent to clients that subscribed to it.
+func NewMessageRoomStateDelta(room string, joined map[string]string, left map[string]string) Message {
+	return NewMessage(MessageTypeRoomStateDelta, room, map[string]interface{}{
+		"joined": joined,
+		"left":   left,
+	})
+}
+
+// Ends the room for all clients. Servers close the connections of clients
+// after sending them this message.
+func NewMessageRoomEnd(room string) Message {
+	return NewMessage(MessageTypeRoomEnd, room, nil)
+}
+
+// Asks clients to reconnect to the room at url, e.g. to move the room to
+// another instance. The token is passed on to the other instance and can be
+// empty. Servers close the connections of clients after sending them this
+// message.
+func NewMessageRoomRedirect(room string, url string, token string) Message {
+	return NewMessage(MessageTypeRoomRedirect, room, map[string]string{
+		"url":   url,
+		"token": token,
+	})
+}
+
+func NewMessageNotice(room string, notice string) Message {
+	return NewMessage(MessageTypeNotice, room, notice)
+}
+
+// Creates an error message with a code from the ErrorCode constants and a
+// message that can be shown to users.
+func NewMessageError(room string, code string, message string) Message {
+	return NewMessage(MessageTypeError, room, map[string]string{
+		"code":    code,
+		"message": message,
+	})
+}
+
+// Notifies a client that its peer connection with the server is being closed.
+// The reason is one of the signals.CloseReason values.
+func NewMessagePeerClose(room string, reason string) Message {
+	return NewMessage(MessageTypePeerClose, room, map[string]string{
+		"reason": reason,
+	})
+}
+
+func NewMessageChat(room string, clientID string, message interface{}) Message {
+	return NewMessage(MessageTypeChat, room, map[string]interface{}{
+		"clientID": clientID,
+		"message":  message,
+	})
+}
+
+func NewMessageChatHistory(room string, messages []Message) Message {
+	return NewMessage(MessageTypeChatHistory, room, messages)
+}
+
+// Returns milliseconds since the Unix epoch, used for server timestamps in
+// message payloads.
+func NewTimestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func NewMessageRaiseHand(room string, clientID string, metadata string, raised bool, timestamp int64) Message {
+	return NewMessage(MessageTypeRaiseHand, room, map[string]interface{}{
+		"clientID":  clientID,
+		"metadata":  metadata,
+		"raised":    raised,
+		"timestamp": timestamp,
+	})
+}
+
+func NewMessageRaisedHands(room string, messages []Message) Message {
+	return NewMessage(MessageTypeRaisedHands, room, messages)
+}
+
+func NewMessageReaction(room string, clientID string, metadata string, reaction string, timestamp int64) Message {
+	return NewMessage(MessageTypeReaction, room, map[string]interface{}{
+		"clientID":  clientID,
+		"metadata":  metadata,
+		"reaction":  reaction,
+		"timestamp": timestamp,
+	})
+}
+
+// Notifies the room that the estimated connection quality of a client
+// changed. Quality is one of the tracks.Quality values.
+func NewMessageQuality(room string, clientID string, quality string) Message {
+	return NewMessage(MessageTypeQuality, room, map[string]string{
+		"clientID": clientID,
+		"quality":  quality,
+	})
+}
+
+// Tells a client in an SFU room whether its video is forwarded to the other
+// clients. Clients can stop sending video while it is paused.
+func NewMessageVideoPaused(room string, paused bool) Message {
+	return NewMessage(MessageTypeVideoPaused, room, map[string]bool{
+		"paused": paused,
+	})
+}
+
+// Returns the IDs of the clients whose video a client asked to be forwarded
+// in a video request message, in addition to the video of the active
+// speakers. An empty list cancels the previous request.
+func VideoRequestClientIDs(msg Message) (clientIDs []string, ok bool) {
+	if msg.Type != MessageTypeVideoRequest {
+		return nil, false
+	}
+	return stringSlice(msg.Payload)
+}
+
+// Returns the client ID and hand state from a raise hand message.
+func RaiseHandState(msg Message) (clientID string, raised bool, ok bool) {
+	if msg.Type != MessageTypeRaiseHand {
+		return "", false, false
+	}
+	payload, ok := msg.Payload.(map[string]interface{})
+	if !ok {
+		return "", false, false
+	}
+	clientID, ok = payload["clientID"].(string)
+	if !ok {
+		return "", false, false
+	}
+	raised, _ = payload["raised"].(bool)
+	return clientID, raised, true
+}
+
+// Returns the client ID and metadata from a join message.
+func RoomJoinState(msg Message) (clientID string, metadata string, ok bool) {
+	if msg.Type != MessageTypeRoomJoin {
+		return "", "", false
+	}
+	return stringFields(msg.Payload, "clientID", "metadata")
+}
+
+// Returns the client ID and reason from a leave message. Older servers send
+// the client ID as the whole payload and no reason.
+func RoomLeaveState(msg Message) (clientID string, reason string, ok bool) {
+	if msg.Type != MessageTypeRoomLeave {
+		return "", "", false
+	}
+	if clientID, ok := msg.Payload.(string); ok {
+		return clientID, "", true
+	}
+	return stringFields(msg.Payload, "clientID", "reason")
+}
+
+// Returns the required string field key and the optional string field
+// other from a payload that was either created locally or deserialized.
+func stringFields(payload interface{}, key string, other string) (value string, otherValue string, ok bool) {
+	switch p := payload.(type) {
+	case map[string]string:
+		value, ok = p[key]
+		otherValue = p[other]
+	case map[string]interface{}:
+		value, ok = p[key].(string)
+		otherValue, _ = p[other].(string)
+	}
+	return
+}
+
+// Sends the room password to the client that set it, after the server
+// generated it or to confirm it was set.
+func NewMessageRoomPassword(room string, password string) Message {
+	return NewMessage(MessageTypeRoomPassword, room, map[string]string{
+		"password": password,
+	})
+}
+
+// Returns the password from a join or set room password message sent by a
+// client. A set room password message without a password asks the server to
+// generate one.
+func RoomPassword(msg Message) (password string, ok bool) {
+	switch msg.Type {
+	case MessageTypeJoin, MessageTypeSetRoomPassword:
+	default:
+		return "", false
+	}
+	if msg.Payload == nil {
+		return "", true
+	}
+	password, _, ok = stringFields(msg.Payload, "password", "")
+	return password, ok
+}
+
+// Returns the message types from a subscribe message sent by a client. An
+// empty list subscribes to all message types.
+func SubscribeMessageTypes(msg Message) (messageTypes []string, ok bool) {
+	if msg.Type != MessageTypeSubscribe {
+		return nil, false
+	}
+	return stringSlice(msg.Payload)
+}
+
+// Converts a payload of strings, which is decoded as []interface{}, to
+// []string. A nil payload is an empty list.
+func stringSlice(payload interface{}) (values []string, ok bool) {
+	switch payload := payload.(type) {
+	case nil:
+		return nil, true
+	case []string:
+		return payload, true
+	case []interface{}:
+		values = make([]string, 0, len(payload))
+		for _, value := range payload {
+			s, ok := value.(string)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, s)
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// Returns whether a client asked for the messages written to it to be
+// compressed in a compression message.
+func CompressionEnabled(msg Message) (enabled bool, ok bool) {
+	if msg.Type != MessageTypeCompression {
+		return false, false
+	}
+	switch payload := msg.Payload.(type) {
+	case map[string]interface{}:
+		enabled, ok = payload["enabled"].(bool)
+		return enabled, ok
+	case map[string]bool:
+		enabled, ok = payload["enabled"]
+		return enabled, ok
+	default:
+		return false, false
+	}
 }
 
 type ByteSerializer struct{}
@@ -53,5 +417,18 @@ func (s ByteSerializer) Serialize(m Message) ([]byte, error) {
 
 func (s ByteSerializer) Deserialize(data []byte) (msg Message, err error) {
 	err = json.Unmarshal(data, &msg)
+	if err == nil {
+		msg = migrate(msg)
+	}
 	return
 }
+
+// Upgrades messages from older versions to MessageVersionCurrent. Messages
+// from newer versions are returned unchanged.
+func migrate(msg Message) Message {
+	if msg.Version == MessageVersion0 {
+		// the envelope and payloads are unchanged in version 1
+		msg.Version = MessageVersion1
+	}
+	return msg
+}
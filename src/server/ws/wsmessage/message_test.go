@@ -2,6 +2,7 @@ package wsmessage_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/stretchr/testify/assert"
@@ -25,6 +26,53 @@ func TestMessageSerializeDeserialize(t *testing.T) {
 	assert.Equal(t, room, m2.Room)
 }
 
+func TestMessageSerializeDeserialize_roomLeave(t *testing.T) {
+	m1 := wsmessage.NewMessageRoomLeaveWithReason("test-room", "client1", wsmessage.LeaveReasonKicked)
+	var s wsmessage.ByteSerializer
+	serialized, err := s.Serialize(m1)
+	assert.Nil(t, err)
+	m2, err := s.Deserialize(serialized)
+	assert.Nil(t, err)
+	assert.Equal(t, wsmessage.MessageTypeRoomLeave, m2.Type)
+	assert.Equal(t, "test-room", m2.Room)
+	assert.Equal(t, map[string]interface{}{
+		"clientID": "client1",
+		"reason":   wsmessage.LeaveReasonKicked,
+	}, m2.Payload)
+}
+
+func TestNewMessage_version(t *testing.T) {
+	m1 := wsmessage.NewMessage("test-type", "test-room", nil)
+	assert.Equal(t, wsmessage.MessageVersionCurrent, m1.Version)
+}
+
+func TestMessageDeserialize_version(t *testing.T) {
+	var s wsmessage.ByteSerializer
+	for _, tc := range []struct {
+		data    string
+		version int
+	}{
+		{`{"type":"test-type","room":"test-room","payload":"test-payload"}`, wsmessage.MessageVersion1},
+		{`{"type":"test-type","room":"test-room","payload":"test-payload","version":0}`, wsmessage.MessageVersion1},
+		{`{"type":"test-type","room":"test-room","payload":"test-payload","version":1}`, wsmessage.MessageVersion1},
+		{`{"type":"test-type","room":"test-room","payload":"test-payload","version":2}`, 2},
+	} {
+		msg, err := s.Deserialize([]byte(tc.data))
+		assert.Nil(t, err)
+		assert.Equal(t, "test-type", msg.Type)
+		assert.Equal(t, "test-room", msg.Room)
+		assert.Equal(t, "test-payload", msg.Payload)
+		assert.Equal(t, tc.version, msg.Version, "data: %s", tc.data)
+	}
+}
+
+func TestMessageSerialize_version(t *testing.T) {
+	var s wsmessage.ByteSerializer
+	data, err := s.Serialize(wsmessage.NewMessage("test-type", "test-room", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type":"test-type","room":"test-room","payload":null,"version":1}`, string(data))
+}
+
 func TestNewMessageRoomJoin(t *testing.T) {
 	room := "test"
 	clientID := "client1"
@@ -44,5 +92,208 @@ func TestNewMessageRoomLeave(t *testing.T) {
 	m1 := wsmessage.NewMessageRoomLeave(room, clientID)
 	assert.Equal(t, wsmessage.MessageTypeRoomLeave, m1.Type)
 	assert.Equal(t, room, m1.Room)
-	assert.Equal(t, clientID, m1.Payload)
+	assert.Equal(t, map[string]string{
+		"clientID": clientID,
+		"reason":   wsmessage.LeaveReasonLeft,
+	}, m1.Payload)
+}
+
+func TestNewMessageRoomLeaveWithReason(t *testing.T) {
+	room := "test"
+	clientID := "client1"
+	for _, reason := range []string{
+		wsmessage.LeaveReasonLeft,
+		wsmessage.LeaveReasonDisconnected,
+		wsmessage.LeaveReasonKicked,
+		wsmessage.LeaveReasonTimeout,
+	} {
+		m1 := wsmessage.NewMessageRoomLeaveWithReason(room, clientID, reason)
+		assert.Equal(t, wsmessage.MessageTypeRoomLeave, m1.Type)
+		assert.Equal(t, room, m1.Room)
+		assert.Equal(t, map[string]string{
+			"clientID": clientID,
+			"reason":   reason,
+		}, m1.Payload)
+	}
+}
+
+func TestNewMessageClientID(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessageClientID(room, "client1")
+	assert.Equal(t, wsmessage.MessageTypeClientID, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, "client1", m1.Payload)
+}
+
+func TestNewMessageRoomState(t *testing.T) {
+	room := "test"
+	clients := map[string]string{"client1": "a", "client2": "b"}
+	m1 := wsmessage.NewMessageRoomState(room, clients, true, "topic")
+	assert.Equal(t, wsmessage.MessageTypeRoomState, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, map[string]interface{}{
+		"clients": clients,
+		"locked":  true,
+		"topic":   "topic",
+	}, m1.Payload)
+}
+
+func TestNewMessageNotice(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessageNotice(room, "welcome")
+	assert.Equal(t, wsmessage.MessageTypeNotice, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, "welcome", m1.Payload)
+}
+
+func TestNewMessageError(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessageError(room, wsmessage.ErrorCodeRoomFull, "Room is full")
+	assert.Equal(t, wsmessage.MessageTypeError, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, map[string]string{
+		"code":    "room_full",
+		"message": "Room is full",
+	}, m1.Payload)
+}
+
+func TestNewMessagePeerClose(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessagePeerClose(room, "ice_failed")
+	assert.Equal(t, wsmessage.MessageTypePeerClose, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, map[string]string{
+		"reason": "ice_failed",
+	}, m1.Payload)
+}
+
+func TestSubscribeMessageTypes(t *testing.T) {
+	for _, tc := range []struct {
+		payload interface{}
+		types   []string
+		ok      bool
+	}{
+		{nil, nil, true},
+		{[]string{"a"}, []string{"a"}, true},
+		{[]interface{}{"a", "b"}, []string{"a", "b"}, true},
+		{[]interface{}{"a", 1}, nil, false},
+		{"a", nil, false},
+	} {
+		msg := wsmessage.NewMessage(wsmessage.MessageTypeSubscribe, "test", tc.payload)
+		types, ok := wsmessage.SubscribeMessageTypes(msg)
+		assert.Equal(t, tc.ok, ok, "payload: %v", tc.payload)
+		assert.Equal(t, tc.types, types, "payload: %v", tc.payload)
+	}
+
+	_, ok := wsmessage.SubscribeMessageTypes(wsmessage.NewMessage("test", "test", nil))
+	assert.False(t, ok)
+}
+
+func TestVideoRequestClientIDs(t *testing.T) {
+	msg := wsmessage.NewMessage(wsmessage.MessageTypeVideoRequest, "test", []interface{}{"a", "b"})
+	clientIDs, ok := wsmessage.VideoRequestClientIDs(msg)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a", "b"}, clientIDs)
+
+	_, ok = wsmessage.VideoRequestClientIDs(wsmessage.NewMessage(wsmessage.MessageTypeVideoRequest, "test", "a"))
+	assert.False(t, ok)
+
+	_, ok = wsmessage.VideoRequestClientIDs(wsmessage.NewMessage(wsmessage.MessageTypeSubscribe, "test", nil))
+	assert.False(t, ok)
+}
+
+func TestRoomPassword(t *testing.T) {
+	for _, tc := range []struct {
+		typ      string
+		payload  interface{}
+		password string
+		ok       bool
+	}{
+		{wsmessage.MessageTypeJoin, map[string]interface{}{"password": "a"}, "a", true},
+		{wsmessage.MessageTypeJoin, map[string]string{"password": "b"}, "b", true},
+		{wsmessage.MessageTypeSetRoomPassword, nil, "", true},
+		{wsmessage.MessageTypeSetRoomPassword, map[string]interface{}{"password": 1}, "", false},
+		{wsmessage.MessageTypeSetRoomPassword, "a", "", false},
+		{wsmessage.MessageTypeChat, map[string]interface{}{"password": "a"}, "", false},
+	} {
+		password, ok := wsmessage.RoomPassword(wsmessage.NewMessage(tc.typ, "test", tc.payload))
+		assert.Equal(t, tc.ok, ok, "type: %s, payload: %v", tc.typ, tc.payload)
+		assert.Equal(t, tc.password, password, "type: %s, payload: %v", tc.typ, tc.payload)
+	}
+}
+
+func TestNewMessageChat(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessageChat(room, "client1", "hello")
+	assert.Equal(t, wsmessage.MessageTypeChat, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, map[string]interface{}{
+		"clientID": "client1",
+		"message":  "hello",
+	}, m1.Payload)
+
+	m2 := wsmessage.NewMessageChatHistory(room, []wsmessage.Message{m1})
+	assert.Equal(t, wsmessage.MessageTypeChatHistory, m2.Type)
+	assert.Equal(t, []wsmessage.Message{m1}, m2.Payload)
+}
+
+func TestNewMessageRaiseHand(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessageRaiseHand(room, "client1", "abc", true, 1000)
+	assert.Equal(t, wsmessage.MessageTypeRaiseHand, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, map[string]interface{}{
+		"clientID":  "client1",
+		"metadata":  "abc",
+		"raised":    true,
+		"timestamp": int64(1000),
+	}, m1.Payload)
+
+	clientID, raised, ok := wsmessage.RaiseHandState(m1)
+	assert.True(t, ok)
+	assert.Equal(t, "client1", clientID)
+	assert.True(t, raised)
+
+	m2 := wsmessage.NewMessageRaisedHands(room, []wsmessage.Message{m1})
+	assert.Equal(t, wsmessage.MessageTypeRaisedHands, m2.Type)
+	assert.Equal(t, []wsmessage.Message{m1}, m2.Payload)
+
+	_, _, ok = wsmessage.RaiseHandState(m2)
+	assert.False(t, ok)
+}
+
+func TestNewMessageReaction(t *testing.T) {
+	room := "test"
+	m1 := wsmessage.NewMessageReaction(room, "client1", "abc", "+1", 1000)
+	assert.Equal(t, wsmessage.MessageTypeReaction, m1.Type)
+	assert.Equal(t, room, m1.Room)
+	assert.Equal(t, map[string]interface{}{
+		"clientID":  "client1",
+		"metadata":  "abc",
+		"reaction":  "+1",
+		"timestamp": int64(1000),
+	}, m1.Payload)
+}
+
+func TestNewTimestamp(t *testing.T) {
+	assert.Equal(t, int64(1500), wsmessage.NewTimestamp(time.Unix(1, 500*int64(time.Millisecond))))
+}
+
+func TestCompressionEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		typ     string
+		payload interface{}
+		enabled bool
+		ok      bool
+	}{
+		{wsmessage.MessageTypeCompression, map[string]interface{}{"enabled": true}, true, true},
+		{wsmessage.MessageTypeCompression, map[string]bool{"enabled": false}, false, true},
+		{wsmessage.MessageTypeCompression, map[string]interface{}{"enabled": "yes"}, false, false},
+		{wsmessage.MessageTypeCompression, nil, false, false},
+		{"test", map[string]interface{}{"enabled": true}, false, false},
+	} {
+		enabled, ok := wsmessage.CompressionEnabled(wsmessage.NewMessage(tc.typ, "test", tc.payload))
+		assert.Equal(t, tc.ok, ok, "type: %s, payload: %v", tc.typ, tc.payload)
+		assert.Equal(t, tc.enabled, enabled, "type: %s, payload: %v", tc.typ, tc.payload)
+	}
 }
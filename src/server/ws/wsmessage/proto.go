@@ -0,0 +1,266 @@
+package wsmessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage/wspb"
+	"github.com/pion/webrtc/v2"
+)
+
+const messageTypeSignal = "signal"
+
+// ProtoSerializer encodes messages using protobuf. Signal payloads are
+// encoded as protobuf messages, while all other payloads are embedded as
+// JSON. Deserialized payloads have the same shape as payloads deserialized
+// by ByteSerializer.
+type ProtoSerializer struct{}
+
+func (s ProtoSerializer) Serialize(m Message) ([]byte, error) {
+	pbMessage := &wspb.Message{
+		Type:    m.Type,
+		Room:    m.Room,
+		Version: int32(m.Version),
+	}
+
+	if m.Type == messageTypeSignal {
+		if pbSignal, ok := newPBSignal(m.Payload); ok {
+			pbMessage.Payload = &wspb.Message_Signal{Signal: pbSignal}
+			return proto.Marshal(pbMessage)
+		}
+	}
+
+	if m.Payload != nil {
+		data, err := json.Marshal(m.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("ProtoSerializer.Serialize - error encoding payload: %w", err)
+		}
+		pbMessage.Payload = &wspb.Message_Json{Json: data}
+	}
+
+	return proto.Marshal(pbMessage)
+}
+
+func (s ProtoSerializer) Deserialize(data []byte) (msg Message, err error) {
+	var pbMessage wspb.Message
+	if err = proto.Unmarshal(data, &pbMessage); err != nil {
+		return msg, fmt.Errorf("ProtoSerializer.Deserialize - error decoding message: %w", err)
+	}
+
+	msg.Type = pbMessage.Type
+	msg.Room = pbMessage.Room
+	msg.Version = int(pbMessage.Version)
+
+	switch payload := pbMessage.Payload.(type) {
+	case *wspb.Message_Signal:
+		msg.Payload = newSignalPayload(payload.Signal)
+	case *wspb.Message_Json:
+		if err = json.Unmarshal(payload.Json, &msg.Payload); err != nil {
+			return msg, fmt.Errorf("ProtoSerializer.Deserialize - error decoding payload: %w", err)
+		}
+	}
+
+	return migrate(msg), nil
+}
+
+// Converts a signal payload to protobuf. Returns false when the payload is not
+// a known signal, or when it has fields or values that would be lost.
+func newPBSignal(payload interface{}) (*wspb.Signal, bool) {
+	switch p := payload.(type) {
+	case signals.Payload:
+		return newPBSignalFromPayload(p)
+	case *signals.Payload:
+		if p != nil {
+			return newPBSignalFromPayload(*p)
+		}
+	case map[string]interface{}:
+		return newPBSignalFromMap(p)
+	}
+	return nil, false
+}
+
+// Converts a signal payload created by the server.
+func newPBSignalFromPayload(payload signals.Payload) (*wspb.Signal, bool) {
+	pbSignal := &wspb.Signal{UserId: payload.UserID}
+
+	switch s := payload.Signal.(type) {
+	case webrtc.SessionDescription:
+		switch s.Type {
+		case webrtc.SDPTypeOffer, webrtc.SDPTypeAnswer, webrtc.SDPTypePranswer, webrtc.SDPTypeRollback:
+		default:
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_Sdp{Sdp: &wspb.SessionDescription{
+			Type: s.Type.String(),
+			Sdp:  s.SDP,
+		}}
+	case signals.Candidate:
+		// sdpMid and sdpMLineIndex are omitted from JSON when nil.
+		if s.Candidate.SDPMid == nil || s.Candidate.SDPMLineIndex == nil {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_Candidate{Candidate: &wspb.Candidate{
+			Candidate:        s.Candidate.Candidate,
+			SdpMid:           *s.Candidate.SDPMid,
+			SdpMLineIndex:    uint32(*s.Candidate.SDPMLineIndex),
+			UsernameFragment: s.Candidate.UsernameFragment,
+		}}
+	case signals.Renegotiate:
+		if !s.Renegotiate {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_Renegotiate{Renegotiate: &wspb.Renegotiate{}}
+	case signals.TransceiverRequestJSON:
+		// init is always present in JSON, but only decoded with a direction.
+		if s.TransceiverRequest.Init.Direction == "" {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_TransceiverRequest{TransceiverRequest: &wspb.TransceiverRequest{
+			Kind:      s.TransceiverRequest.Kind,
+			Direction: s.TransceiverRequest.Init.Direction,
+		}}
+	default:
+		return nil, false
+	}
+
+	return pbSignal, true
+}
+
+// Converts a JSON decoded signal payload, e.g. one relayed from a client.
+// Every field must be known and have the expected type.
+func newPBSignalFromMap(payload map[string]interface{}) (*wspb.Signal, bool) {
+	if len(payload) != 2 {
+		return nil, false
+	}
+	userID, ok := payload["userId"].(string)
+	if !ok {
+		return nil, false
+	}
+	signal, ok := payload["signal"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	pbSignal := &wspb.Signal{UserId: userID}
+
+	if candidate, ok := signal["candidate"].(map[string]interface{}); ok {
+		c, ok := newPBCandidate(candidate)
+		if !ok || len(signal) != 1 {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_Candidate{Candidate: c}
+	} else if renegotiate, ok := signal["renegotiate"]; ok {
+		if renegotiate != true || len(signal) != 1 {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_Renegotiate{Renegotiate: &wspb.Renegotiate{}}
+	} else if transceiverRequest, ok := signal["transceiverRequest"].(map[string]interface{}); ok {
+		t, ok := newPBTransceiverRequest(transceiverRequest)
+		if !ok || len(signal) != 1 {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_TransceiverRequest{TransceiverRequest: t}
+	} else if sdpType, ok := signal["type"].(string); ok {
+		sdp, ok := signal["sdp"].(string)
+		if !ok || len(signal) != 2 {
+			return nil, false
+		}
+		pbSignal.Signal = &wspb.Signal_Sdp{Sdp: &wspb.SessionDescription{Type: sdpType, Sdp: sdp}}
+	} else {
+		return nil, false
+	}
+
+	return pbSignal, true
+}
+
+func newPBCandidate(candidate map[string]interface{}) (*wspb.Candidate, bool) {
+	if len(candidate) != 4 {
+		return nil, false
+	}
+	c := &wspb.Candidate{}
+	var ok bool
+	if c.Candidate, ok = candidate["candidate"].(string); !ok {
+		return nil, false
+	}
+	if c.SdpMid, ok = candidate["sdpMid"].(string); !ok {
+		return nil, false
+	}
+	if c.UsernameFragment, ok = candidate["usernameFragment"].(string); !ok {
+		return nil, false
+	}
+	sdpMLineIndex, ok := candidate["sdpMLineIndex"].(float64)
+	if !ok || sdpMLineIndex < 0 || sdpMLineIndex > math.MaxUint32 || sdpMLineIndex != math.Trunc(sdpMLineIndex) {
+		return nil, false
+	}
+	c.SdpMLineIndex = uint32(sdpMLineIndex)
+	return c, true
+}
+
+func newPBTransceiverRequest(transceiverRequest map[string]interface{}) (*wspb.TransceiverRequest, bool) {
+	t := &wspb.TransceiverRequest{}
+	var ok bool
+	if t.Kind, ok = transceiverRequest["kind"].(string); !ok {
+		return nil, false
+	}
+	switch len(transceiverRequest) {
+	case 1:
+		return t, true
+	case 2:
+		init, ok := transceiverRequest["init"].(map[string]interface{})
+		if !ok || len(init) != 1 {
+			return nil, false
+		}
+		if t.Direction, ok = init["direction"].(string); !ok || t.Direction == "" {
+			return nil, false
+		}
+		return t, true
+	default:
+		return nil, false
+	}
+}
+
+// Converts a protobuf signal to the payload ByteSerializer would produce.
+func newSignalPayload(pbSignal *wspb.Signal) map[string]interface{} {
+	var signal map[string]interface{}
+
+	switch s := pbSignal.Signal.(type) {
+	case *wspb.Signal_Sdp:
+		signal = map[string]interface{}{
+			"type": s.Sdp.Type,
+			"sdp":  s.Sdp.Sdp,
+		}
+	case *wspb.Signal_Candidate:
+		signal = map[string]interface{}{
+			"candidate": map[string]interface{}{
+				"candidate":        s.Candidate.Candidate,
+				"sdpMid":           s.Candidate.SdpMid,
+				"sdpMLineIndex":    float64(s.Candidate.SdpMLineIndex),
+				"usernameFragment": s.Candidate.UsernameFragment,
+			},
+		}
+	case *wspb.Signal_Renegotiate:
+		signal = map[string]interface{}{
+			"renegotiate": true,
+		}
+	case *wspb.Signal_TransceiverRequest:
+		transceiverRequest := map[string]interface{}{
+			"kind": s.TransceiverRequest.Kind,
+		}
+		if s.TransceiverRequest.Direction != "" {
+			transceiverRequest["init"] = map[string]interface{}{
+				"direction": s.TransceiverRequest.Direction,
+			}
+		}
+		signal = map[string]interface{}{
+			"transceiverRequest": transceiverRequest,
+		}
+	}
+
+	return map[string]interface{}{
+		"userId": pbSignal.UserId,
+		"signal": signal,
+	}
+}
@@ -0,0 +1,195 @@
+package wsmessage_test
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jeremija/peer-calls/src/server/wrtc/signals"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage/wspb"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uint16Ptr(value uint16) *uint16 {
+	return &value
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
+
+// Serializes msg with ProtoSerializer and asserts that it deserializes to the
+// same message as with ByteSerializer. Returns the protobuf message.
+func assertProtoRoundTrip(t *testing.T, msg wsmessage.Message) *wspb.Message {
+	t.Helper()
+	var byteSerializer wsmessage.ByteSerializer
+	var protoSerializer wsmessage.ProtoSerializer
+
+	jsonData, err := byteSerializer.Serialize(msg)
+	require.Nil(t, err)
+	expected, err := byteSerializer.Deserialize(jsonData)
+	require.Nil(t, err)
+
+	data, err := protoSerializer.Serialize(msg)
+	require.Nil(t, err)
+	actual, err := protoSerializer.Deserialize(data)
+	require.Nil(t, err)
+	assert.Equal(t, expected, actual)
+
+	var pbMessage wspb.Message
+	require.Nil(t, proto.Unmarshal(data, &pbMessage))
+	return &pbMessage
+}
+
+func TestProtoSerializer_signal(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload interface{}
+	}{
+		{"sdp", signals.NewPayloadSDP("user1", webrtc.SessionDescription{
+			Type: webrtc.SDPTypeOffer,
+			SDP:  "v=0\r\n",
+		})},
+		{"sdp map", map[string]interface{}{
+			"userId": "user1",
+			"signal": map[string]interface{}{"type": "answer", "sdp": "v=0\r\n"},
+		}},
+		{"candidate", signals.Payload{
+			UserID: "user1",
+			Signal: signals.Candidate{
+				Candidate: webrtc.ICECandidateInit{
+					Candidate:        "candidate:1 1 udp 2130706431 127.0.0.1 5000 typ host",
+					SDPMid:           stringPtr("0"),
+					SDPMLineIndex:    uint16Ptr(1),
+					UsernameFragment: "abc",
+				},
+			},
+		}},
+		{"renegotiate", signals.NewPayloadRenegotiate("user1")},
+		{"transceiver request", signals.NewTransceiverRequest(
+			"user1",
+			webrtc.RTPCodecTypeVideo,
+			webrtc.RTPTransceiverDirectionRecvonly,
+		)},
+		{"transceiver request without init", map[string]interface{}{
+			"userId": "user1",
+			"signal": map[string]interface{}{
+				"transceiverRequest": map[string]interface{}{"kind": "audio"},
+			},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pbMessage := assertProtoRoundTrip(t, wsmessage.NewMessage("signal", "room1", tc.payload))
+			assert.NotNil(t, pbMessage.GetSignal(), "expected payload to be encoded as a signal")
+		})
+	}
+}
+
+func TestProtoSerializer_json(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  wsmessage.Message
+	}{
+		{"nil payload", wsmessage.NewMessage("ready", "room1", nil)},
+		{"room join", wsmessage.NewMessageRoomJoin("room1", "client1", "nick")},
+		{"room leave", wsmessage.NewMessageRoomLeave("room1", "client1")},
+		{"notice", wsmessage.NewMessageNotice("room1", "welcome")},
+		{"chat history", wsmessage.NewMessageChatHistory("room1", []wsmessage.Message{
+			wsmessage.NewMessageChat("room1", "client1", "hi"),
+		})},
+		{"signal with unknown fields", wsmessage.NewMessage("signal", "room1", map[string]interface{}{
+			"userId": "user1",
+			"signal": map[string]interface{}{"renegotiate": true, "extra": 1},
+		})},
+		{"signal without userId", wsmessage.NewMessage("signal", "room1", map[string]interface{}{
+			"signal": map[string]interface{}{"renegotiate": true},
+		})},
+		{"candidate without sdpMid", wsmessage.NewMessage("signal", "room1", signals.Payload{
+			UserID: "user1",
+			Signal: signals.Candidate{
+				Candidate: webrtc.ICECandidateInit{Candidate: "candidate:1", SDPMLineIndex: uint16Ptr(0)},
+			},
+		})},
+		{"candidate map with null usernameFragment", wsmessage.NewMessage("signal", "room1", map[string]interface{}{
+			"userId": "user1",
+			"signal": map[string]interface{}{"candidate": map[string]interface{}{
+				"candidate":        "candidate:1",
+				"sdpMid":           "0",
+				"sdpMLineIndex":    float64(0),
+				"usernameFragment": nil,
+			}},
+		})},
+		{"candidate map with fractional sdpMLineIndex", wsmessage.NewMessage("signal", "room1", map[string]interface{}{
+			"userId": "user1",
+			"signal": map[string]interface{}{"candidate": map[string]interface{}{
+				"candidate":        "candidate:1",
+				"sdpMid":           "0",
+				"sdpMLineIndex":    1.5,
+				"usernameFragment": "abc",
+			}},
+		})},
+		{"renegotiate false", wsmessage.NewMessage("signal", "room1", signals.Payload{
+			UserID: "user1",
+			Signal: signals.Renegotiate{},
+		})},
+		{"transceiver request without direction", wsmessage.NewMessage("signal", "room1", signals.Payload{
+			UserID: "user1",
+			Signal: signals.TransceiverRequestJSON{},
+		})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pbMessage := assertProtoRoundTrip(t, tc.msg)
+			assert.Nil(t, pbMessage.GetSignal())
+		})
+	}
+}
+
+func BenchmarkProtoSerializer_Serialize_signal(b *testing.B) {
+	var s wsmessage.ProtoSerializer
+	msg := wsmessage.NewMessage("signal", "room1", signals.Payload{
+		UserID: "user1",
+		Signal: signals.Candidate{
+			Candidate: webrtc.ICECandidateInit{
+				Candidate:        "candidate:1 1 udp 2130706431 127.0.0.1 5000 typ host",
+				SDPMid:           stringPtr("0"),
+				SDPMLineIndex:    uint16Ptr(1),
+				UsernameFragment: "abc",
+			},
+		},
+	})
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Serialize(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProtoSerializer_Serialize_signalMap(b *testing.B) {
+	var s wsmessage.ProtoSerializer
+	msg := wsmessage.NewMessage("signal", "room1", map[string]interface{}{
+		"userId": "user1",
+		"signal": map[string]interface{}{"type": "answer", "sdp": "v=0\r\n"},
+	})
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Serialize(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProtoSerializer_version(t *testing.T) {
+	var s wsmessage.ProtoSerializer
+	data, err := proto.Marshal(&wspb.Message{Type: "test", Room: "room1"})
+	require.Nil(t, err)
+	msg, err := s.Deserialize(data)
+	require.Nil(t, err)
+	assert.Equal(t, wsmessage.MessageVersionCurrent, msg.Version)
+}
+
+func TestProtoSerializer_invalid(t *testing.T) {
+	var s wsmessage.ProtoSerializer
+	_, err := s.Deserialize([]byte{0xff, 0xff})
+	assert.NotNil(t, err)
+}
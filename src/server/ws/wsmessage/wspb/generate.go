@@ -0,0 +1,4 @@
+// Package wspb contains protobuf types used by wsmessage.ProtoSerializer.
+package wspb
+
+//go:generate protoc --go_out=. message.proto
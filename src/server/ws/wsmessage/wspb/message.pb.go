@@ -0,0 +1,478 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: message.proto
+
+package wspb
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// Protobuf encoding of wsmessage.Message.
+type Message struct {
+	Type    string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Room    string `protobuf:"bytes,2,opt,name=room,proto3" json:"room,omitempty"`
+	Version int32  `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	// Unset when the payload is nil.
+	//
+	// Types that are valid to be assigned to Payload:
+	//	*Message_Signal
+	//	*Message_Json
+	Payload              isMessage_Payload `protobuf_oneof:"payload"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+func (*Message) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33c57e4bae7b9afd, []int{0}
+}
+
+func (m *Message) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Message.Unmarshal(m, b)
+}
+func (m *Message) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Message.Marshal(b, m, deterministic)
+}
+func (m *Message) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Message.Merge(m, src)
+}
+func (m *Message) XXX_Size() int {
+	return xxx_messageInfo_Message.Size(m)
+}
+func (m *Message) XXX_DiscardUnknown() {
+	xxx_messageInfo_Message.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Message proto.InternalMessageInfo
+
+func (m *Message) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Message) GetRoom() string {
+	if m != nil {
+		return m.Room
+	}
+	return ""
+}
+
+func (m *Message) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type isMessage_Payload interface {
+	isMessage_Payload()
+}
+
+type Message_Signal struct {
+	Signal *Signal `protobuf:"bytes,4,opt,name=signal,proto3,oneof"`
+}
+
+type Message_Json struct {
+	Json []byte `protobuf:"bytes,5,opt,name=json,proto3,oneof"`
+}
+
+func (*Message_Signal) isMessage_Payload() {}
+
+func (*Message_Json) isMessage_Payload() {}
+
+func (m *Message) GetPayload() isMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *Message) GetSignal() *Signal {
+	if x, ok := m.GetPayload().(*Message_Signal); ok {
+		return x.Signal
+	}
+	return nil
+}
+
+func (m *Message) GetJson() []byte {
+	if x, ok := m.GetPayload().(*Message_Json); ok {
+		return x.Json
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*Message) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*Message_Signal)(nil),
+		(*Message_Json)(nil),
+	}
+}
+
+type Signal struct {
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// Types that are valid to be assigned to Signal:
+	//	*Signal_Sdp
+	//	*Signal_Candidate
+	//	*Signal_Renegotiate
+	//	*Signal_TransceiverRequest
+	Signal               isSignal_Signal `protobuf_oneof:"signal"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *Signal) Reset()         { *m = Signal{} }
+func (m *Signal) String() string { return proto.CompactTextString(m) }
+func (*Signal) ProtoMessage()    {}
+func (*Signal) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33c57e4bae7b9afd, []int{1}
+}
+
+func (m *Signal) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Signal.Unmarshal(m, b)
+}
+func (m *Signal) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Signal.Marshal(b, m, deterministic)
+}
+func (m *Signal) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Signal.Merge(m, src)
+}
+func (m *Signal) XXX_Size() int {
+	return xxx_messageInfo_Signal.Size(m)
+}
+func (m *Signal) XXX_DiscardUnknown() {
+	xxx_messageInfo_Signal.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Signal proto.InternalMessageInfo
+
+func (m *Signal) GetUserId() string {
+	if m != nil {
+		return m.UserId
+	}
+	return ""
+}
+
+type isSignal_Signal interface {
+	isSignal_Signal()
+}
+
+type Signal_Sdp struct {
+	Sdp *SessionDescription `protobuf:"bytes,2,opt,name=sdp,proto3,oneof"`
+}
+
+type Signal_Candidate struct {
+	Candidate *Candidate `protobuf:"bytes,3,opt,name=candidate,proto3,oneof"`
+}
+
+type Signal_Renegotiate struct {
+	Renegotiate *Renegotiate `protobuf:"bytes,4,opt,name=renegotiate,proto3,oneof"`
+}
+
+type Signal_TransceiverRequest struct {
+	TransceiverRequest *TransceiverRequest `protobuf:"bytes,5,opt,name=transceiver_request,json=transceiverRequest,proto3,oneof"`
+}
+
+func (*Signal_Sdp) isSignal_Signal() {}
+
+func (*Signal_Candidate) isSignal_Signal() {}
+
+func (*Signal_Renegotiate) isSignal_Signal() {}
+
+func (*Signal_TransceiverRequest) isSignal_Signal() {}
+
+func (m *Signal) GetSignal() isSignal_Signal {
+	if m != nil {
+		return m.Signal
+	}
+	return nil
+}
+
+func (m *Signal) GetSdp() *SessionDescription {
+	if x, ok := m.GetSignal().(*Signal_Sdp); ok {
+		return x.Sdp
+	}
+	return nil
+}
+
+func (m *Signal) GetCandidate() *Candidate {
+	if x, ok := m.GetSignal().(*Signal_Candidate); ok {
+		return x.Candidate
+	}
+	return nil
+}
+
+func (m *Signal) GetRenegotiate() *Renegotiate {
+	if x, ok := m.GetSignal().(*Signal_Renegotiate); ok {
+		return x.Renegotiate
+	}
+	return nil
+}
+
+func (m *Signal) GetTransceiverRequest() *TransceiverRequest {
+	if x, ok := m.GetSignal().(*Signal_TransceiverRequest); ok {
+		return x.TransceiverRequest
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*Signal) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*Signal_Sdp)(nil),
+		(*Signal_Candidate)(nil),
+		(*Signal_Renegotiate)(nil),
+		(*Signal_TransceiverRequest)(nil),
+	}
+}
+
+type SessionDescription struct {
+	Type                 string   `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Sdp                  string   `protobuf:"bytes,2,opt,name=sdp,proto3" json:"sdp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SessionDescription) Reset()         { *m = SessionDescription{} }
+func (m *SessionDescription) String() string { return proto.CompactTextString(m) }
+func (*SessionDescription) ProtoMessage()    {}
+func (*SessionDescription) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33c57e4bae7b9afd, []int{2}
+}
+
+func (m *SessionDescription) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionDescription.Unmarshal(m, b)
+}
+func (m *SessionDescription) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionDescription.Marshal(b, m, deterministic)
+}
+func (m *SessionDescription) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionDescription.Merge(m, src)
+}
+func (m *SessionDescription) XXX_Size() int {
+	return xxx_messageInfo_SessionDescription.Size(m)
+}
+func (m *SessionDescription) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionDescription.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionDescription proto.InternalMessageInfo
+
+func (m *SessionDescription) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *SessionDescription) GetSdp() string {
+	if m != nil {
+		return m.Sdp
+	}
+	return ""
+}
+
+type Candidate struct {
+	Candidate            string   `protobuf:"bytes,1,opt,name=candidate,proto3" json:"candidate,omitempty"`
+	SdpMid               string   `protobuf:"bytes,2,opt,name=sdp_mid,json=sdpMid,proto3" json:"sdp_mid,omitempty"`
+	SdpMLineIndex        uint32   `protobuf:"varint,3,opt,name=sdp_m_line_index,json=sdpMLineIndex,proto3" json:"sdp_m_line_index,omitempty"`
+	UsernameFragment     string   `protobuf:"bytes,4,opt,name=username_fragment,json=usernameFragment,proto3" json:"username_fragment,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Candidate) Reset()         { *m = Candidate{} }
+func (m *Candidate) String() string { return proto.CompactTextString(m) }
+func (*Candidate) ProtoMessage()    {}
+func (*Candidate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33c57e4bae7b9afd, []int{3}
+}
+
+func (m *Candidate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Candidate.Unmarshal(m, b)
+}
+func (m *Candidate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Candidate.Marshal(b, m, deterministic)
+}
+func (m *Candidate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Candidate.Merge(m, src)
+}
+func (m *Candidate) XXX_Size() int {
+	return xxx_messageInfo_Candidate.Size(m)
+}
+func (m *Candidate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Candidate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Candidate proto.InternalMessageInfo
+
+func (m *Candidate) GetCandidate() string {
+	if m != nil {
+		return m.Candidate
+	}
+	return ""
+}
+
+func (m *Candidate) GetSdpMid() string {
+	if m != nil {
+		return m.SdpMid
+	}
+	return ""
+}
+
+func (m *Candidate) GetSdpMLineIndex() uint32 {
+	if m != nil {
+		return m.SdpMLineIndex
+	}
+	return 0
+}
+
+func (m *Candidate) GetUsernameFragment() string {
+	if m != nil {
+		return m.UsernameFragment
+	}
+	return ""
+}
+
+type Renegotiate struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Renegotiate) Reset()         { *m = Renegotiate{} }
+func (m *Renegotiate) String() string { return proto.CompactTextString(m) }
+func (*Renegotiate) ProtoMessage()    {}
+func (*Renegotiate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33c57e4bae7b9afd, []int{4}
+}
+
+func (m *Renegotiate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Renegotiate.Unmarshal(m, b)
+}
+func (m *Renegotiate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Renegotiate.Marshal(b, m, deterministic)
+}
+func (m *Renegotiate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Renegotiate.Merge(m, src)
+}
+func (m *Renegotiate) XXX_Size() int {
+	return xxx_messageInfo_Renegotiate.Size(m)
+}
+func (m *Renegotiate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Renegotiate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Renegotiate proto.InternalMessageInfo
+
+type TransceiverRequest struct {
+	Kind string `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	// Unset when the request has no init.
+	Direction            string   `protobuf:"bytes,2,opt,name=direction,proto3" json:"direction,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TransceiverRequest) Reset()         { *m = TransceiverRequest{} }
+func (m *TransceiverRequest) String() string { return proto.CompactTextString(m) }
+func (*TransceiverRequest) ProtoMessage()    {}
+func (*TransceiverRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33c57e4bae7b9afd, []int{5}
+}
+
+func (m *TransceiverRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TransceiverRequest.Unmarshal(m, b)
+}
+func (m *TransceiverRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TransceiverRequest.Marshal(b, m, deterministic)
+}
+func (m *TransceiverRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TransceiverRequest.Merge(m, src)
+}
+func (m *TransceiverRequest) XXX_Size() int {
+	return xxx_messageInfo_TransceiverRequest.Size(m)
+}
+func (m *TransceiverRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_TransceiverRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TransceiverRequest proto.InternalMessageInfo
+
+func (m *TransceiverRequest) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *TransceiverRequest) GetDirection() string {
+	if m != nil {
+		return m.Direction
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*Message)(nil), "peercalls.ws.Message")
+	proto.RegisterType((*Signal)(nil), "peercalls.ws.Signal")
+	proto.RegisterType((*SessionDescription)(nil), "peercalls.ws.SessionDescription")
+	proto.RegisterType((*Candidate)(nil), "peercalls.ws.Candidate")
+	proto.RegisterType((*Renegotiate)(nil), "peercalls.ws.Renegotiate")
+	proto.RegisterType((*TransceiverRequest)(nil), "peercalls.ws.TransceiverRequest")
+}
+
+func init() { proto.RegisterFile("message.proto", fileDescriptor_33c57e4bae7b9afd) }
+
+var fileDescriptor_33c57e4bae7b9afd = []byte{
+	// 445 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0xc1, 0x6e, 0xd3, 0x40,
+	0x10, 0xb5, 0xdb, 0xc4, 0xc1, 0xe3, 0x46, 0x0a, 0x4b, 0xa5, 0x1a, 0x89, 0x83, 0xe5, 0x0b, 0x91,
+	0x90, 0x7c, 0x08, 0x48, 0x48, 0x48, 0x5c, 0x02, 0xaa, 0x5c, 0x89, 0x5e, 0xb6, 0x9c, 0xb8, 0x58,
+	0x5b, 0xef, 0x10, 0x2d, 0xd8, 0xeb, 0x65, 0x77, 0xdb, 0xd2, 0x3f, 0xe1, 0xc0, 0x37, 0xf0, 0x8d,
+	0x68, 0xd7, 0x36, 0x4e, 0x49, 0x6f, 0x33, 0x6f, 0xde, 0x64, 0xdf, 0x7b, 0x13, 0xc3, 0xb2, 0x45,
+	0x63, 0xd8, 0x0e, 0x0b, 0xa5, 0x3b, 0xdb, 0x91, 0x13, 0x85, 0xa8, 0x6b, 0xd6, 0x34, 0xa6, 0xb8,
+	0x33, 0xf9, 0xef, 0x10, 0x16, 0x97, 0xfd, 0x9c, 0x10, 0x98, 0xd9, 0x7b, 0x85, 0x69, 0x98, 0x85,
+	0xeb, 0x98, 0xfa, 0xda, 0x61, 0xba, 0xeb, 0xda, 0xf4, 0xa8, 0xc7, 0x5c, 0x4d, 0x52, 0x58, 0xdc,
+	0xa2, 0x36, 0xa2, 0x93, 0xe9, 0x71, 0x16, 0xae, 0xe7, 0x74, 0x6c, 0x49, 0x01, 0x91, 0x11, 0x3b,
+	0xc9, 0x9a, 0x74, 0x96, 0x85, 0xeb, 0x64, 0x73, 0x5a, 0xec, 0x3f, 0x56, 0x5c, 0xf9, 0x59, 0x19,
+	0xd0, 0x81, 0x45, 0x4e, 0x61, 0xf6, 0xcd, 0x74, 0x32, 0x9d, 0x67, 0xe1, 0xfa, 0xa4, 0x0c, 0xa8,
+	0xef, 0xb6, 0x31, 0x2c, 0x14, 0xbb, 0x6f, 0x3a, 0xc6, 0xf3, 0x3f, 0x47, 0x10, 0xf5, 0x5b, 0xe4,
+	0x0c, 0x16, 0x37, 0x06, 0x75, 0x25, 0xf8, 0x20, 0x30, 0x72, 0xed, 0x05, 0x27, 0x6f, 0xe0, 0xd8,
+	0x70, 0xe5, 0x15, 0x26, 0x9b, 0xec, 0xbf, 0x17, 0xd1, 0x38, 0x61, 0x1f, 0xd1, 0xd4, 0x5a, 0x28,
+	0x2b, 0x3a, 0x59, 0x06, 0xd4, 0xd1, 0xc9, 0x5b, 0x88, 0x6b, 0x26, 0xb9, 0xe0, 0xcc, 0xa2, 0xb7,
+	0x91, 0x6c, 0xce, 0x1e, 0xee, 0x7e, 0x18, 0xc7, 0x65, 0x40, 0x27, 0x2e, 0x79, 0x0f, 0x89, 0x46,
+	0x89, 0xbb, 0xce, 0x0a, 0xb7, 0xda, 0x1b, 0x7d, 0xfe, 0x70, 0x95, 0x4e, 0x84, 0x32, 0xa0, 0xfb,
+	0x7c, 0x72, 0x05, 0xcf, 0xac, 0x66, 0xd2, 0xd4, 0x28, 0x6e, 0x51, 0x57, 0x1a, 0x7f, 0xdc, 0xa0,
+	0xb1, 0xe9, 0xfc, 0x31, 0xf5, 0x9f, 0x27, 0x22, 0xed, 0x79, 0x65, 0x40, 0x89, 0x3d, 0x40, 0xb7,
+	0x4f, 0xc6, 0xdc, 0xf3, 0x77, 0x40, 0x0e, 0x3d, 0x3f, 0x7a, 0xd9, 0xd5, 0x14, 0x5b, 0xec, 0x23,
+	0xc9, 0x7f, 0x85, 0x10, 0xff, 0x33, 0x4d, 0x5e, 0xec, 0x07, 0xd4, 0x2f, 0x4e, 0x80, 0xbb, 0x86,
+	0xe1, 0xaa, 0x6a, 0x05, 0x1f, 0x7e, 0x21, 0x32, 0x5c, 0x5d, 0x0a, 0x4e, 0x5e, 0xc2, 0xca, 0x0f,
+	0xaa, 0x46, 0x48, 0xac, 0x84, 0xe4, 0xf8, 0xd3, 0xc7, 0xbb, 0xa4, 0x4b, 0xc7, 0xf8, 0x24, 0x24,
+	0x5e, 0x38, 0x90, 0xbc, 0x82, 0xa7, 0xee, 0x80, 0x92, 0xb5, 0x58, 0x7d, 0xd5, 0x6c, 0xd7, 0xa2,
+	0xb4, 0x3e, 0xcd, 0x98, 0xae, 0xc6, 0xc1, 0xf9, 0x80, 0xe7, 0x4b, 0x48, 0xf6, 0x32, 0xcd, 0xcf,
+	0x81, 0x1c, 0x66, 0xe3, 0x5c, 0x7e, 0x17, 0x72, 0xfc, 0x7b, 0xf8, 0xda, 0xb9, 0xe0, 0x42, 0x63,
+	0xed, 0x62, 0x18, 0x94, 0x4e, 0xc0, 0x36, 0xfa, 0x32, 0xbb, 0x33, 0xea, 0xfa, 0x3a, 0xf2, 0x9f,
+	0xc6, 0xeb, 0xbf, 0x03, 0x00, 0xd8, 0x9f, 0x75, 0xea, 0x2b, 0x03, 0x00, 0x00,
+}
@@ -0,0 +1,54 @@
+package wsredis
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// RedisRoomDirectoryStore finds the listed rooms of all instances from the
+// set maintained by RedisRoomMetadataStore, and counts their clients from
+// the client lists stored by RedisAdapter.
+type RedisRoomDirectoryStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisRoomDirectoryStore(client *redis.Client, prefix string) *RedisRoomDirectoryStore {
+	return &RedisRoomDirectoryStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Returns the number of clients in each listed room that has clients. Rooms
+// that are not listed are omitted, so rooms are not scanned.
+func (s *RedisRoomDirectoryStore) ActiveRooms() (map[string]int, error) {
+	rooms, err := s.client.SMembers(getListedRoomsName(s.prefix)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("RedisRoomDirectoryStore.ActiveRooms - error retrieving listed rooms: %w", err)
+	}
+
+	participantsByRoom := map[string]int{}
+	if len(rooms) == 0 {
+		return participantsByRoom, nil
+	}
+
+	sizes := make([]*redis.IntCmd, len(rooms))
+	_, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, room := range rooms {
+			sizes[i] = pipe.HLen(getRoomClientsName(s.prefix, room))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("RedisRoomDirectoryStore.ActiveRooms - error retrieving clients of rooms: %w", err)
+	}
+
+	for i, room := range rooms {
+		if size := sizes[i].Val(); size > 0 {
+			participantsByRoom[room] = int(size)
+		}
+	}
+	return participantsByRoom, nil
+}
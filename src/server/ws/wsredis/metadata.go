@@ -0,0 +1,80 @@
+package wsredis
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+)
+
+// RedisRoomMetadataStore keeps room metadata in Redis so it is shared between
+// instances and survives restarts. Listed rooms are also added to a set used
+// by RedisRoomDirectoryStore.
+type RedisRoomMetadataStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisRoomMetadataStore(client *redis.Client, prefix string) *RedisRoomMetadataStore {
+	return &RedisRoomMetadataStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func getRoomMetadataName(prefix string, room string) string {
+	// TODO escape room name, what if it has ":" in the name?
+	return prefix + ":room:" + room + ":metadata"
+}
+
+func getListedRoomsName(prefix string) string {
+	return prefix + ":listed_rooms"
+}
+
+func (s *RedisRoomMetadataStore) RoomMetadata(room string) (metadata wsadapter.RoomMetadata, err error) {
+	value, err := s.client.Get(getRoomMetadataName(s.prefix, room)).Result()
+	if err == redis.Nil {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, fmt.Errorf("RedisRoomMetadataStore.RoomMetadata - error retrieving metadata: %w", err)
+	}
+	if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+		return metadata, fmt.Errorf("RedisRoomMetadataStore.RoomMetadata - error decoding metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+func (s *RedisRoomMetadataStore) SetRoomMetadata(room string, metadata wsadapter.RoomMetadata) error {
+	key := getRoomMetadataName(s.prefix, room)
+	listedKey := getListedRoomsName(s.prefix)
+	if metadata == (wsadapter.RoomMetadata{}) {
+		_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(key)
+			pipe.SRem(listedKey, room)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("RedisRoomMetadataStore.SetRoomMetadata - error deleting metadata: %w", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("RedisRoomMetadataStore.SetRoomMetadata - error encoding metadata: %w", err)
+	}
+	_, err = s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(key, string(data), 0)
+		if metadata.Listed {
+			pipe.SAdd(listedKey, room)
+		} else {
+			pipe.SRem(listedKey, room)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("RedisRoomMetadataStore.SetRoomMetadata - error storing metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package wsredis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// RedisRoomPasswordStore keeps room password hashes in Redis so they are
+// shared between instances. Keys expire after the TTL so that passwords of
+// rooms that are no longer used are removed.
+type RedisRoomPasswordStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// Creates a store whose passwords expire after ttl. Passwords never expire
+// when ttl is zero.
+func NewRedisRoomPasswordStore(client *redis.Client, prefix string, ttl time.Duration) *RedisRoomPasswordStore {
+	return &RedisRoomPasswordStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+func getRoomPasswordName(prefix string, room string) string {
+	// TODO escape room name, what if it has ":" in the name?
+	return prefix + ":room:" + room + ":password"
+}
+
+// Returns the password hash of room and resets its expiry.
+func (s *RedisRoomPasswordStore) RoomPassword(room string) (string, error) {
+	key := getRoomPasswordName(s.prefix, room)
+	hash, err := s.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("RedisRoomPasswordStore.RoomPassword - error retrieving password: %w", err)
+	}
+	if s.ttl > 0 {
+		if err := s.client.Expire(key, s.ttl).Err(); err != nil {
+			return "", fmt.Errorf("RedisRoomPasswordStore.RoomPassword - error resetting expiry: %w", err)
+		}
+	}
+	return hash, nil
+}
+
+func (s *RedisRoomPasswordStore) SetRoomPassword(room string, hash string) error {
+	key := getRoomPasswordName(s.prefix, room)
+	if hash == "" {
+		if err := s.client.Del(key).Err(); err != nil {
+			return fmt.Errorf("RedisRoomPasswordStore.SetRoomPassword - error removing password: %w", err)
+		}
+		return nil
+	}
+	if err := s.client.Set(key, hash, s.ttl).Err(); err != nil {
+		return fmt.Errorf("RedisRoomPasswordStore.SetRoomPassword - error storing password: %w", err)
+	}
+	return nil
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/jeremija/peer-calls/src/server/basen"
 	"github.com/jeremija/peer-calls/src/server/logger"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
@@ -22,9 +25,33 @@ type Doner interface {
 	Done()
 }
 
-var serializer wsmessage.ByteSerializer
 var log = logger.GetLogger("wsredis")
 
+var ErrPublishTimeout = errors.New("Publish timed out")
+
+var errPingFailed = errors.New("Subscription ping failed")
+
+// Delays between attempts to resubscribe after a failed ping. The delay
+// doubles with every consecutive failure up to the maximum, and is reset
+// once a subscription stays up for longer than the maximum.
+const (
+	resubscribeMinDelay = 100 * time.Millisecond
+	resubscribeMaxDelay = 10 * time.Second
+)
+
+// Published to the channel of a client when a client with the same ID is
+// added on another instance, so that the instance with the old client
+// replaces it. Never sent to clients.
+const messageTypeClientReplaced = "ws_redis_client_replaced"
+
+// Removes the room owner only when it is still the client being removed.
+var removeOwnerScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 type RedisAdapter struct {
 	clientsMu *sync.RWMutex
 	// contains local clients connected to current instance
@@ -35,11 +62,24 @@ type RedisAdapter struct {
 	pubRedis *redis.Client // TODO replace this with interface
 	subRedis *redis.Client
 	keys     struct {
-		roomChannel   string
-		roomClients   string
-		clientPattern string
+		roomChannel     string
+		roomClients     string
+		roomChatHistory string
+		roomHands       string
+		roomCreated     string
+		roomOwner       string
+		clientPattern   string
 	}
-	stop func() error
+	chatHistorySize   int
+	serializer        wsmessage.SerializerDeserializer
+	roomMetadataStore wsadapter.RoomMetadataStore
+	roomPasswordStore wsadapter.RoomPasswordStore
+	publishTimeout    time.Duration
+	pingInterval      time.Duration
+	stop              func() error
+	presence          *wsadapter.Presence
+	// identifies this adapter in messages published to other instances
+	instanceID string
 }
 
 func getRoomChannelName(prefix string, room string) string {
@@ -57,27 +97,86 @@ func getRoomClientsName(prefix string, room string) string {
 	return prefix + ":room:" + room + ":clients"
 }
 
+func getRoomChatHistoryName(prefix string, room string) string {
+	// TODO escape room name, what if it has ":" in the name?
+	return prefix + ":room:" + room + ":chat"
+}
+
+func getRoomHandsName(prefix string, room string) string {
+	// TODO escape room name, what if it has ":" in the name?
+	return prefix + ":room:" + room + ":hands"
+}
+
+func getRoomCreatedName(prefix string, room string) string {
+	// TODO escape room name, what if it has ":" in the name?
+	return prefix + ":room:" + room + ":created"
+}
+
+func getRoomOwnerName(prefix string, room string) string {
+	// TODO escape room name, what if it has ":" in the name?
+	return prefix + ":room:" + room + ":owner"
+}
+
 func NewRedisAdapter(
 	pubRedis *redis.Client,
 	subRedis *redis.Client,
 	prefix string,
 	room string,
+) *RedisAdapter {
+	return NewRedisAdapterWithParams(pubRedis, subRedis, prefix, room, wsadapter.Params{})
+}
+
+func NewRedisAdapterWithParams(
+	pubRedis *redis.Client,
+	subRedis *redis.Client,
+	prefix string,
+	room string,
+	params wsadapter.Params,
 ) *RedisAdapter {
 	var clientsMu sync.RWMutex
 
 	adapter := RedisAdapter{
-		clients:   map[string]wsadapter.Client{},
-		clientsMu: &clientsMu,
-		prefix:    prefix,
-		room:      room,
-		pubRedis:  pubRedis,
-		subRedis:  subRedis,
-		stop:      nil,
+		clients:           map[string]wsadapter.Client{},
+		clientsMu:         &clientsMu,
+		prefix:            prefix,
+		room:              room,
+		instanceID:        basen.NewUUIDBase62(),
+		pubRedis:          pubRedis,
+		subRedis:          subRedis,
+		chatHistorySize:   params.ChatHistorySize,
+		serializer:        params.Serializer,
+		roomMetadataStore: params.RoomMetadataStore,
+		roomPasswordStore: params.RoomPasswordStore,
+		publishTimeout:    params.PublishTimeout,
+		pingInterval:      params.PingInterval,
+		stop:              nil,
+	}
+
+	adapter.presence = wsadapter.NewPresence(room, params.PresenceDebounce, func(delta wsmessage.Message) {
+		adapter.clientsMu.RLock()
+		defer adapter.clientsMu.RUnlock()
+		if err := adapter.localBroadcast(delta); err != nil {
+			log.Printf("RedisAdapter error broadcasting presence delta in room: %s: %s", room, err)
+		}
+	})
+
+	if adapter.serializer == nil {
+		adapter.serializer = wsmessage.ByteSerializer{}
+	}
+	if adapter.roomMetadataStore == nil {
+		adapter.roomMetadataStore = wsadapter.NewMemoryRoomMetadataStore()
+	}
+	if adapter.roomPasswordStore == nil {
+		adapter.roomPasswordStore = wsadapter.NewMemoryRoomPasswordStore(0)
 	}
 
 	adapter.keys.roomChannel = getRoomChannelName(prefix, room)
 	adapter.keys.clientPattern = getClientChannelName(prefix, room, "*")
 	adapter.keys.roomClients = getRoomClientsName(prefix, room)
+	adapter.keys.roomChatHistory = getRoomChatHistoryName(prefix, room)
+	adapter.keys.roomHands = getRoomHandsName(prefix, room)
+	adapter.keys.roomCreated = getRoomCreatedName(prefix, room)
+	adapter.keys.roomOwner = getRoomOwnerName(prefix, room)
 
 	adapter.subscribeUntilReady()
 
@@ -88,19 +187,158 @@ func (a *RedisAdapter) Add(client wsadapter.Client) (err error) {
 	clientID := client.ID()
 	log.Printf("Add clientID: %s to room: %s", clientID, a.room)
 	a.clientsMu.Lock()
-	err = a.Broadcast(wsmessage.NewMessageRoomJoin(a.room, clientID, client.Metadata()))
+	old, replaced := a.clients[clientID]
+	replacedElsewhere := false
+	if !replaced {
+		// the client is connected to another instance
+		replacedElsewhere, err = a.pubRedis.HExists(a.keys.roomClients, clientID).Result()
+	}
+	switch {
+	case err != nil:
+	case replaced:
+		err = a.pubRedis.HSet(a.keys.roomClients, clientID, client.Metadata()).Err()
+		if err == nil {
+			log.Printf("Add clientID: %s to room: %s replaces existing client", clientID, a.room)
+			wsadapter.NotifyReplaced(old)
+		}
+	case replacedElsewhere:
+		err = a.pubRedis.HSet(a.keys.roomClients, clientID, client.Metadata()).Err()
+		if err == nil {
+			log.Printf("Add clientID: %s to room: %s replaces client of another instance", clientID, a.room)
+			err = a.publish(getClientChannelName(a.prefix, a.room, clientID), wsmessage.NewMessage(
+				messageTypeClientReplaced, a.room, map[string]string{"instanceID": a.instanceID}))
+		}
+	default:
+		err = a.setOwner(clientID)
+		if err == nil {
+			err = a.Broadcast(wsmessage.NewMessageRoomJoin(a.room, clientID, client.Metadata()))
+		}
+	}
+	replaced = replaced || replacedElsewhere
 	if err == nil {
 		a.clients[clientID] = client
 		log.Printf("Add clientID: %s to room: %s done", clientID, a.room)
+		if replaced {
+			err = a.emitRoomState(clientID)
+		}
 	}
 	a.clientsMu.Unlock()
 	return
 }
 
-func (a *RedisAdapter) Remove(clientID string) (err error) {
+// Makes the client the owner when the room has none, which is only the case
+// for the first client added to the empty room on any instance.
+func (a *RedisAdapter) setOwner(clientID string) error {
+	size, err := a.pubRedis.HLen(a.keys.roomClients).Result()
+	if err != nil || size > 0 {
+		return err
+	}
+	if err := a.pubRedis.SetNX(a.keys.roomOwner, clientID, 0).Err(); err != nil {
+		return fmt.Errorf("Error storing room owner: %w", err)
+	}
+	return nil
+}
+
+// Sends chat history and raised hands to a local client. New clients receive
+// them once their own join message comes back from the room channel, so that
+// they arrive after the join, like with the MemoryAdapter.
+func (a *RedisAdapter) emitRoomState(clientID string) error {
+	if err := a.emitChatHistory(clientID); err != nil {
+		return err
+	}
+	return a.emitRaisedHands(clientID)
+}
+
+func (a *RedisAdapter) emitChatHistory(clientID string) error {
+	if a.chatHistorySize <= 0 {
+		return nil
+	}
+	values, err := a.pubRedis.LRange(a.keys.roomChatHistory, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("RedisAdapter.emitChatHistory - error retrieving chat history: %w", err)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	history := make([]wsmessage.Message, 0, len(values))
+	for _, value := range values {
+		msg, err := a.serializer.Deserialize([]byte(value))
+		if err != nil {
+			return fmt.Errorf("RedisAdapter.emitChatHistory - error deserializing message: %w", err)
+		}
+		history = append(history, msg)
+	}
+	return a.localEmit(clientID, wsmessage.NewMessageChatHistory(a.room, history))
+}
+
+// Appends the message to a capped list so chat history is shared between
+// instances.
+func (a *RedisAdapter) addChatHistory(data []byte) error {
+	if a.chatHistorySize <= 0 {
+		return nil
+	}
+	key := a.keys.roomChatHistory
+	if err := a.pubRedis.RPush(key, string(data)).Err(); err != nil {
+		return fmt.Errorf("RedisAdapter.addChatHistory - error adding message: %w", err)
+	}
+	if err := a.pubRedis.LTrim(key, int64(-a.chatHistorySize), -1).Err(); err != nil {
+		return fmt.Errorf("RedisAdapter.addChatHistory - error trimming history: %w", err)
+	}
+	return nil
+}
+
+func (a *RedisAdapter) emitRaisedHands(clientID string) error {
+	values, err := a.pubRedis.HGetAll(a.keys.roomHands).Result()
+	if err != nil {
+		return fmt.Errorf("RedisAdapter.emitRaisedHands - error retrieving raised hands: %w", err)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	hands := make([]wsmessage.Message, 0, len(values))
+	for _, value := range values {
+		msg, err := a.serializer.Deserialize([]byte(value))
+		if err != nil {
+			return fmt.Errorf("RedisAdapter.emitRaisedHands - error deserializing message: %w", err)
+		}
+		hands = append(hands, msg)
+	}
+	sort.SliceStable(hands, func(i, j int) bool {
+		return raiseHandTimestamp(hands[i]) < raiseHandTimestamp(hands[j])
+	})
+	return a.localEmit(clientID, wsmessage.NewMessageRaisedHands(a.room, hands))
+}
+
+func raiseHandTimestamp(msg wsmessage.Message) float64 {
+	payload, _ := msg.Payload.(map[string]interface{})
+	timestamp, _ := payload["timestamp"].(float64)
+	return timestamp
+}
+
+// Stores raised hands in a hash so they are shared between instances.
+func (a *RedisAdapter) updateRaisedHands(msg wsmessage.Message, data []byte) error {
+	clientID, raised, ok := wsmessage.RaiseHandState(msg)
+	if !ok {
+		return nil
+	}
+	if !raised {
+		return a.pubRedis.HDel(a.keys.roomHands, clientID).Err()
+	}
+	return a.pubRedis.HSet(a.keys.roomHands, clientID, string(data)).Err()
+}
+
+func (a *RedisAdapter) Remove(clientID string) error {
+	return a.RemoveWithReason(clientID, wsmessage.LeaveReasonLeft)
+}
+
+func (a *RedisAdapter) RemoveWithReason(clientID string, reason string) (err error) {
+	return a.RemoveWithMessage(clientID, wsmessage.NewMessageRoomLeaveWithReason(a.room, clientID, reason))
+}
+
+func (a *RedisAdapter) RemoveWithMessage(clientID string, leave wsmessage.Message) (err error) {
 	a.clientsMu.Lock()
 	if _, ok := a.clients[clientID]; ok {
-		err = a.remove(clientID)
+		err = a.remove(clientID, leave)
 	}
 	a.clientsMu.Unlock()
 	return
@@ -108,21 +346,28 @@ func (a *RedisAdapter) Remove(clientID string) (err error) {
 
 func (a *RedisAdapter) removeAll() (err error) {
 	for clientID := range a.clients {
-		if removeErr := a.remove(clientID); removeErr != nil && err == nil {
+		leave := wsmessage.NewMessageRoomLeaveWithReason(a.room, clientID, wsmessage.LeaveReasonDisconnected)
+		if removeErr := a.remove(clientID, leave); removeErr != nil && err == nil {
 			err = removeErr
 		}
 	}
 	return
 }
 
-func (a *RedisAdapter) remove(clientID string) (err error) {
+func (a *RedisAdapter) remove(clientID string, leave wsmessage.Message) (err error) {
 	log.Printf("Remove clientID: %s from room: %s", clientID, a.room)
 	// can only remove clients connected to this adapter
 	if err = a.pubRedis.HDel(a.keys.roomClients, clientID).Err(); err != nil {
 		log.Printf("Error deleting clientID from all clients: %s", err)
 	}
+	if err = a.pubRedis.HDel(a.keys.roomHands, clientID).Err(); err != nil {
+		log.Printf("Error deleting clientID from raised hands: %s", err)
+	}
+	if err = removeOwnerScript.Run(a.pubRedis, []string{a.keys.roomOwner}, clientID).Err(); err != nil {
+		log.Printf("Error deleting clientID from room owner: %s", err)
+	}
 	delete(a.clients, clientID)
-	err = a.Broadcast(wsmessage.NewMessageRoomLeave(a.room, clientID))
+	err = a.Broadcast(leave)
 	log.Printf("Remove clientID: %s from room: %s done (err: %s)", clientID, a.room, err)
 	return
 }
@@ -155,10 +400,85 @@ func (a *RedisAdapter) Clients() (map[string]string, error) {
 	return allClients, nil
 }
 
-// Returns count of all known clients connected to this room
-func (a *RedisAdapter) Size() (size int, err error) {
-	c, err := a.Clients()
-	return len(c), err
+func (a *RedisAdapter) RoomMetadata() (wsadapter.RoomMetadata, error) {
+	return a.roomMetadataStore.RoomMetadata(a.room)
+}
+
+// Stores room metadata and sends it to all clients in the room, including
+// those connected to other instances
+func (a *RedisAdapter) SetRoomMetadata(metadata wsadapter.RoomMetadata) error {
+	if err := a.roomMetadataStore.SetRoomMetadata(a.room, metadata); err != nil {
+		return fmt.Errorf("RedisAdapter.SetRoomMetadata - error storing metadata: %w", err)
+	}
+	return a.Broadcast(wsmessage.NewMessageRoomMetadata(a.room, metadata))
+}
+
+// Returns the creation time stored in Redis, storing the current time when
+// this is the first instance to ask for it.
+func (a *RedisAdapter) CreatedAt() (time.Time, error) {
+	now := time.Now()
+	ok, err := a.pubRedis.SetNX(a.keys.roomCreated, now.UnixNano(), 0).Result()
+	if err != nil {
+		return now, fmt.Errorf("Error storing room creation time: %w", err)
+	}
+	if ok {
+		return now, nil
+	}
+
+	createdAt, err := a.pubRedis.Get(a.keys.roomCreated).Int64()
+	if err != nil {
+		return now, fmt.Errorf("Error retrieving room creation time: %w", err)
+	}
+	return time.Unix(0, createdAt), nil
+}
+
+// Removes the creation time, owner, chat history and raised hands once no
+// instance has clients in the room, so that the room is recreated when joined
+// again.
+func (a *RedisAdapter) removeCreatedAt() error {
+	size, err := a.pubRedis.HLen(a.keys.roomClients).Result()
+	if err != nil || size > 0 {
+		return err
+	}
+	return a.pubRedis.Del(
+		a.keys.roomCreated,
+		a.keys.roomOwner,
+		a.keys.roomChatHistory,
+		a.keys.roomHands,
+	).Err()
+}
+
+// Returns the owner stored in Redis, shared by all instances.
+func (a *RedisAdapter) Owner() (string, error) {
+	owner, err := a.pubRedis.Get(a.keys.roomOwner).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error retrieving room owner: %w", err)
+	}
+	return owner, nil
+}
+
+// Returns the hash of the room password from the RoomPasswordStore.
+func (a *RedisAdapter) RoomPassword() (string, error) {
+	return a.roomPasswordStore.RoomPassword(a.room)
+}
+
+// Stores the hash of the room password in the RoomPasswordStore, which is
+// shared by all instances when it is a RedisRoomPasswordStore.
+func (a *RedisAdapter) SetRoomPassword(hash string) error {
+	return a.roomPasswordStore.SetRoomPassword(a.room, hash)
+}
+
+// Returns count of all known clients connected to this room. Unlike
+// Clients, the client IDs are not retrieved.
+func (a *RedisAdapter) Size() (int, error) {
+	size, err := a.pubRedis.HLen(a.keys.roomClients).Result()
+	if err != nil {
+		return 0, fmt.Errorf("Error retrieving size of room: %s, reason: %w", a.room, err)
+	}
+	return int(size), nil
 }
 
 func (a *RedisAdapter) handleMessage(
@@ -166,7 +486,7 @@ func (a *RedisAdapter) handleMessage(
 	channel string,
 	message string,
 ) error {
-	msg, err := serializer.Deserialize([]byte(message))
+	msg, err := a.serializer.Deserialize([]byte(message))
 	if err != nil {
 		return fmt.Errorf("RedisAdapter.handleMessage error deserializing redis subscription: %w", err)
 	}
@@ -181,16 +501,18 @@ func (a *RedisAdapter) handleMessage(
 			if ok {
 				err = a.pubRedis.HSet(a.keys.roomClients, payload["clientID"], payload["metadata"]).Err()
 				if err == nil {
-					err = a.localBroadcast(msg)
+					err = a.localBroadcastPresence(msg)
+				}
+				if clientID, _ := payload["clientID"].(string); err == nil && a.clients[clientID] != nil {
+					err = a.emitRoomState(clientID)
 				}
 			}
 			a.clientsMu.Unlock()
 		case wsmessage.MessageTypeRoomLeave:
 			a.clientsMu.Lock()
-			err = a.localBroadcast(msg)
+			err = a.localBroadcastPresence(msg)
 			if err == nil {
-				clientID, ok := msg.Payload.(string)
-				if ok {
+				if clientID, ok := leaveClientID(msg.Payload); ok {
 					err = a.pubRedis.HDel(a.keys.roomClients, clientID).Err()
 				}
 			}
@@ -200,17 +522,56 @@ func (a *RedisAdapter) handleMessage(
 			err = a.localBroadcast(msg)
 			a.clientsMu.RUnlock()
 		}
+	case pattern == a.keys.clientPattern && msg.Type == messageTypeClientReplaced:
+		params := strings.Split(channel, ":")
+		a.replaceLocal(params[len(params)-1], msg)
 	case pattern == a.keys.clientPattern:
 		params := strings.Split(channel, ":")
 		clientID := params[len(params)-1]
 		a.clientsMu.RLock()
-		err = a.localEmit(clientID, msg)
+		// Messages to clients connected to other instances are received too,
+		// because all client channels of the room are subscribed to.
+		if _, ok := a.clients[clientID]; ok {
+			err = a.localEmit(clientID, msg)
+		}
 		a.clientsMu.RUnlock()
 	}
 	log.Printf("RedisAdapter.handleMessage done (err: %s)", err)
 	return err
 }
 
+// Removes a local client that was replaced by a client with the same ID on
+// another instance. The client ID stays in the room, so no leave message is
+// broadcast.
+func (a *RedisAdapter) replaceLocal(clientID string, msg wsmessage.Message) {
+	payload, _ := msg.Payload.(map[string]interface{})
+	if instanceID, _ := payload["instanceID"].(string); instanceID == a.instanceID {
+		return
+	}
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+	old, ok := a.clients[clientID]
+	if !ok {
+		return
+	}
+	log.Printf("Client clientID: %s in room: %s replaced by client of another instance", clientID, a.room)
+	delete(a.clients, clientID)
+	wsadapter.NotifyReplaced(old)
+}
+
+// Older servers publish the clientID as the whole leave message payload.
+func leaveClientID(payload interface{}) (string, bool) {
+	switch p := payload.(type) {
+	case string:
+		return p, true
+	case map[string]interface{}:
+		clientID, ok := p["clientID"].(string)
+		return clientID, ok
+	default:
+		return "", false
+	}
+}
+
 // Reads from subscribed keys and dispatches relevant messages to
 // client websockets. This method blocks until the context is closed.
 func (a *RedisAdapter) subscribe(ctx context.Context, ready func()) error {
@@ -220,6 +581,13 @@ func (a *RedisAdapter) subscribe(ctx context.Context, ready func()) error {
 
 	ch := pubsub.ChannelWithSubscriptions(100)
 
+	var ping <-chan time.Time
+	if a.pingInterval > 0 {
+		ticker := time.NewTicker(a.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
 	isReady := false
 
 	for {
@@ -237,6 +605,10 @@ func (a *RedisAdapter) subscribe(ctx context.Context, ready func()) error {
 					return fmt.Errorf("Error handling message: %w", err)
 				}
 			}
+		case <-ping:
+			if err := pubsub.Ping(); err != nil {
+				return fmt.Errorf("%w: %s", errPingFailed, err)
+			}
 		case <-ctx.Done():
 			err := ctx.Err()
 			log.Println("Subscribe done", err)
@@ -248,10 +620,34 @@ func (a *RedisAdapter) subscribe(ctx context.Context, ready func()) error {
 func (a *RedisAdapter) subscribeUntilReady() {
 	var wg sync.WaitGroup
 	wg.Add(1)
+	var readyOnce sync.Once
+	ready := func() {
+		readyOnce.Do(wg.Done)
+	}
 	errChan := make(chan error)
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		err := a.subscribe(ctx, wg.Done)
+		delay := resubscribeMinDelay
+		subscribedAt := time.Now()
+		err := a.subscribe(ctx, ready)
+		for errors.Is(err, errPingFailed) {
+			if time.Since(subscribedAt) > resubscribeMaxDelay {
+				delay = resubscribeMinDelay
+			}
+			log.Printf("Resubscribing to room: %s in %s: %s", a.room, delay, err)
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				continue
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > resubscribeMaxDelay {
+				delay = resubscribeMaxDelay
+			}
+			subscribedAt = time.Now()
+			err = a.subscribe(ctx, ready)
+		}
 		errChan <- err
 		close(errChan)
 	}()
@@ -269,6 +665,13 @@ func (a *RedisAdapter) Close() (err error) {
 	if removeErr := a.removeAll(); removeErr != nil && err == nil {
 		err = removeErr
 	}
+	if removeErr := a.removeCreatedAt(); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	// metadata stored in Redis is kept, in-process metadata is only needed
+	// while this instance has the room
+	wsadapter.RemoveRoomMetadata(a.roomMetadataStore, a.room)
+	a.presence.Close()
 	if a.stop != nil {
 		if stopErr := a.stop(); !errors.Is(stopErr, context.Canceled) && err == nil {
 			err = stopErr
@@ -280,11 +683,45 @@ func (a *RedisAdapter) Close() (err error) {
 }
 
 func (a *RedisAdapter) publish(channel string, msg wsmessage.Message) error {
-	data, err := serializer.Serialize(msg)
+	data, err := a.serializer.Serialize(msg)
 	if err != nil {
 		return fmt.Errorf("RedisAdapter.publish - error serializing message: %w", err)
 	}
-	return a.pubRedis.Publish(channel, string(data)).Err()
+	switch msg.Type {
+	case wsmessage.MessageTypeChat:
+		if err := a.addChatHistory(data); err != nil {
+			log.Printf("Error storing chat history in room: %s: %s", a.room, err)
+		}
+	case wsmessage.MessageTypeRaiseHand:
+		if err := a.updateRaisedHands(msg, data); err != nil {
+			log.Printf("Error storing raised hands in room: %s: %s", a.room, err)
+		}
+	}
+	return a.publishData(channel, data)
+}
+
+// Returns a client whose commands fail after the publish timeout, and the
+// context to check whether they did.
+func (a *RedisAdapter) publishClient() (*redis.Client, context.Context, context.CancelFunc) {
+	if a.publishTimeout <= 0 {
+		return a.pubRedis, context.Background(), func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.publishTimeout)
+	return a.pubRedis.WithContext(ctx), ctx, cancel
+}
+
+func (a *RedisAdapter) publishError(ctx context.Context, err error) error {
+	// the connection deadline can pass before the context is done
+	if deadline, ok := ctx.Deadline(); ok && err != nil && !time.Now().Before(deadline) {
+		return fmt.Errorf("%w after %s: %s", ErrPublishTimeout, a.publishTimeout, err)
+	}
+	return err
+}
+
+func (a *RedisAdapter) publishData(channel string, data []byte) error {
+	client, ctx, cancel := a.publishClient()
+	defer cancel()
+	return a.publishError(ctx, client.Publish(channel, string(data)).Err())
 }
 
 func (a *RedisAdapter) Broadcast(msg wsmessage.Message) error {
@@ -295,7 +732,10 @@ func (a *RedisAdapter) Broadcast(msg wsmessage.Message) error {
 
 func (a *RedisAdapter) localBroadcast(msg wsmessage.Message) (err error) {
 	log.Printf("RedisAdapter.localBroadcast in room %s of message type: %s", a.room, msg.Type)
-	for clientID := range a.clients {
+	for clientID, client := range a.clients {
+		if !a.presence.Subscribed(client, msg.Type) {
+			continue
+		}
 		if emitErr := a.localEmit(clientID, msg); emitErr != nil && err == nil {
 			err = emitErr
 		}
@@ -303,14 +743,46 @@ func (a *RedisAdapter) localBroadcast(msg wsmessage.Message) (err error) {
 	return
 }
 
+// Batches join and leave messages when presence debouncing is enabled.
+func (a *RedisAdapter) localBroadcastPresence(msg wsmessage.Message) error {
+	a.presence.Add(msg)
+	return a.localBroadcast(msg)
+}
+
 func (a *RedisAdapter) Emit(clientID string, msg wsmessage.Message) error {
 	channel := getClientChannelName(a.prefix, a.room, clientID)
 	log.Printf("Emit clientID: %s, type: %s, payload: %s to %s", clientID, msg.Type, msg, channel)
-	data, err := serializer.Serialize(msg)
+	data, err := a.serializer.Serialize(msg)
 	if err != nil {
 		return fmt.Errorf("RedisAdapter.Emit - error serializing message: %w", err)
 	}
-	return a.pubRedis.Publish(channel, string(data)).Err()
+	return a.publishData(channel, data)
+}
+
+// Publishes the message to the channel of each client so that clients
+// connected to other instances receive it too.
+func (a *RedisAdapter) BroadcastTo(clientIDs []string, msg wsmessage.Message) error {
+	if len(clientIDs) == 0 {
+		return nil
+	}
+
+	log.Printf("BroadcastTo clientIDs: %v, type: %s in room: %s", clientIDs, msg.Type, a.room)
+	data, err := a.serializer.Serialize(msg)
+	if err != nil {
+		return fmt.Errorf("RedisAdapter.BroadcastTo - error serializing message: %w", err)
+	}
+
+	client, ctx, cancel := a.publishClient()
+	defer cancel()
+
+	pipe := client.Pipeline()
+	for _, clientID := range clientIDs {
+		pipe.Publish(getClientChannelName(a.prefix, a.room, clientID), string(data))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("RedisAdapter.BroadcastTo - error publishing message: %w", a.publishError(ctx, err))
+	}
+	return nil
 }
 
 func (a *RedisAdapter) localEmit(clientID string, msg wsmessage.Message) error {
@@ -318,10 +790,8 @@ func (a *RedisAdapter) localEmit(clientID string, msg wsmessage.Message) error {
 	if !ok {
 		return fmt.Errorf("RedisAdapter.localEmit in room: %s - no local clientID: %s", a.room, clientID)
 	}
-	select {
-	case client.WriteChannel() <- msg:
-		return nil
-	default:
-		return fmt.Errorf("RedisAdapter.localEmit in room: %s - buffer full for clientID: %s", a.room, clientID)
+	if err := client.Send(msg); err != nil {
+		return fmt.Errorf("RedisAdapter.localEmit in room: %s - send failed for clientID: %s: %w", a.room, clientID, err)
 	}
+	return nil
 }
@@ -1,13 +1,20 @@
 package wsredis_test
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/jeremija/peer-calls/src/server/ws"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
 	"github.com/jeremija/peer-calls/src/server/ws/wsredis"
 	"github.com/stretchr/testify/assert"
@@ -103,16 +110,18 @@ func TestRedisAdapter_add_remove_client(t *testing.T) {
 	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(room, client2.ID(), "b")), <-mockWriter2.out)
 	assert.Equal(t, map[string]string{client1.ID(): "a", client2.ID(): "b"}, getClientIDs(t, adapter1))
 	assert.Equal(t, map[string]string{client1.ID(): "a", client2.ID(): "b"}, getClientIDs(t, adapter2))
+	assertSize(t, adapter1)
+	assertSize(t, adapter2)
 
 	assert.Nil(t, adapter1.Remove(client1.ID()))
 	t.Log("waiting for client id removal", client1.ID())
-	leaveMessage, err := serializer.Deserialize(<-mockWriter2.out)
-	assert.Nil(t, err)
-	assert.Equal(t, wsmessage.NewMessageRoomLeave(room, client1.ID()), leaveMessage)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomLeave(room, client1.ID())), <-mockWriter2.out)
 	assert.Equal(t, map[string]string{client2.ID(): "b"}, getClientIDs(t, adapter2))
+	assertSize(t, adapter1)
 
 	assert.Nil(t, adapter2.Remove(client2.ID()))
 	assert.Equal(t, map[string]string{}, getClientIDs(t, adapter2))
+	assertSize(t, adapter2)
 
 	t.Log("stopping...")
 	for _, stop := range []func() error{adapter1.Close, adapter2.Close} {
@@ -122,3 +131,713 @@ func TestRedisAdapter_add_remove_client(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+// Checks that the size of the room matches the number of clients.
+func assertSize(t *testing.T, adapter *wsredis.RedisAdapter) {
+	t.Helper()
+	size, err := adapter.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, len(getClientIDs(t, adapter)), size)
+}
+
+// Returns the next message that is not a room join or leave message.
+func readNext(t *testing.T, w *MockWSWriter) []byte {
+	for data := range w.out {
+		msg, err := serializer.Deserialize(data)
+		require.Nil(t, err)
+		switch msg.Type {
+		case wsmessage.MessageTypeRoomJoin, wsmessage.MessageTypeRoomLeave:
+		default:
+			return data
+		}
+	}
+	return nil
+}
+
+func TestRedisAdapter_BroadcastTo(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	adapter1 := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	adapter2 := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	var clients []*ws.Client
+	var writers []*MockWSWriter
+	for i := 0; i < 3; i++ {
+		// buffered so that leave messages do not block when adapters are closed
+		mockWriter := &MockWSWriter{out: make(chan []byte, 16)}
+		defer close(mockWriter.out)
+		client := ws.NewClient(mockWriter)
+		defer client.Close()
+		clients = append(clients, client)
+		writers = append(writers, mockWriter)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(len(clients))
+
+	for _, client := range clients {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	// client0 is connected to the first instance, the others to the second
+	assert.Nil(t, adapter1.Add(clients[0]))
+	assert.Nil(t, adapter2.Add(clients[1]))
+	assert.Nil(t, adapter2.Add(clients[2]))
+
+	msg := wsmessage.NewMessage("test-type", room, "test")
+	assert.Nil(t, adapter1.BroadcastTo([]string{clients[0].ID(), clients[1].ID()}, msg))
+	other := wsmessage.NewMessage("other-type", room, "test")
+	assert.Nil(t, adapter1.Broadcast(other))
+
+	assert.Equal(t, serialize(t, msg), readNext(t, writers[0]))
+	assert.Equal(t, serialize(t, other), readNext(t, writers[0]))
+	assert.Equal(t, serialize(t, msg), readNext(t, writers[1]))
+	assert.Equal(t, serialize(t, other), readNext(t, writers[1]))
+	assert.Equal(t, serialize(t, other), readNext(t, writers[2]), "client2 was not targeted")
+
+	for _, stop := range []func() error{adapter1.Close, adapter2.Close} {
+		err := stop()
+		assert.Equal(t, nil, err)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestRedisAdapter_Emit_otherInstance(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	adapter1 := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	adapter2 := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	var clients []*ws.Client
+	var writers []*MockWSWriter
+	for i := 0; i < 2; i++ {
+		// buffered so that leave messages do not block when adapters are closed
+		mockWriter := &MockWSWriter{out: make(chan []byte, 16)}
+		defer close(mockWriter.out)
+		client := ws.NewClient(mockWriter)
+		defer client.Close()
+		clients = append(clients, client)
+		writers = append(writers, mockWriter)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(len(clients))
+
+	for _, client := range clients {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	assert.Nil(t, adapter1.Add(clients[0]))
+	assert.Nil(t, adapter2.Add(clients[1]))
+
+	// adapter2 also receives the message for client0 of adapter1, which must
+	// not end its subscription.
+	msg := wsmessage.NewMessage("test-type", room, "test")
+	assert.Nil(t, adapter2.Emit(clients[0].ID(), msg))
+	assert.Equal(t, serialize(t, msg), readNext(t, writers[0]))
+
+	other := wsmessage.NewMessage("other-type", room, "test")
+	assert.Nil(t, adapter1.Broadcast(other))
+	assert.Equal(t, serialize(t, other), readNext(t, writers[0]))
+	assert.Equal(t, serialize(t, other), readNext(t, writers[1]))
+
+	for _, stop := range []func() error{adapter1.Close, adapter2.Close} {
+		err := stop()
+		assert.Equal(t, nil, err)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestRedisAdapter_chatHistory(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	chatRoom := "chatroom"
+	pub.Del("peercalls:room:" + chatRoom + ":chat")
+	params := wsadapter.Params{ChatHistorySize: 2}
+	adapter1 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", chatRoom, params)
+	adapter2 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", chatRoom, params)
+	mockWriter1 := NewMockWriter()
+	defer close(mockWriter1.out)
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	mockWriter2 := NewMockWriter()
+	defer close(mockWriter2.out)
+	client2 := ws.NewClient(mockWriter2)
+	defer client2.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	for _, client := range []*ws.Client{client1, client2} {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	assert.Nil(t, adapter1.Add(client1))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(chatRoom, client1.ID(), "")), <-mockWriter1.out)
+
+	chat := []wsmessage.Message{
+		wsmessage.NewMessageChat(chatRoom, client1.ID(), "a"),
+		wsmessage.NewMessageChat(chatRoom, client1.ID(), "b"),
+		wsmessage.NewMessageChat(chatRoom, client1.ID(), "c"),
+	}
+	for _, msg := range chat {
+		assert.Nil(t, adapter1.Broadcast(msg))
+		assert.Equal(t, serialize(t, msg), <-mockWriter1.out)
+	}
+
+	assert.Nil(t, adapter2.Add(client2))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(chatRoom, client2.ID(), "")), <-mockWriter2.out)
+	history, err := serializer.Deserialize(<-mockWriter2.out)
+	assert.Nil(t, err)
+	expectedHistory, err := serializer.Deserialize(serialize(t, wsmessage.NewMessageChatHistory(chatRoom, chat[1:])))
+	assert.Nil(t, err)
+	assert.Equal(t, expectedHistory, history)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(chatRoom, client2.ID(), "")), <-mockWriter1.out)
+
+	assert.Nil(t, adapter1.Remove(client1.ID()))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomLeave(chatRoom, client1.ID())), <-mockWriter2.out)
+	assert.Nil(t, adapter2.Remove(client2.ID()))
+
+	for _, stop := range []func() error{adapter1.Close, adapter2.Close} {
+		err := stop()
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, int64(0), pub.Exists("peercalls:room:"+chatRoom+":chat").Val(), "history should be removed with the room")
+	cancel()
+	wg.Wait()
+}
+
+func TestRedisAdapter_roomMetadata(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	metadataRoom := "metadataroom"
+	store := wsredis.NewRedisRoomMetadataStore(pub, "peercalls")
+	require.Nil(t, store.SetRoomMetadata(metadataRoom, wsadapter.RoomMetadata{}))
+	params := wsadapter.Params{RoomMetadataStore: store}
+
+	adapter1 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", metadataRoom, params)
+	mockWriter1 := NewMockWriter()
+	defer close(mockWriter1.out)
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := client1.Subscribe(ctx, func(msg wsmessage.Message) {})
+		assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+		wg.Done()
+	}()
+
+	assert.Nil(t, adapter1.Add(client1))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(metadataRoom, client1.ID(), "")), <-mockWriter1.out)
+
+	metadata := wsadapter.RoomMetadata{Locked: true, Topic: "topic", WelcomeMessage: "welcome"}
+	assert.Nil(t, adapter1.SetRoomMetadata(metadata))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomMetadata(metadataRoom, metadata)), <-mockWriter1.out)
+
+	assert.Nil(t, adapter1.Close())
+	cancel()
+	wg.Wait()
+
+	// simulate a restart with a new store and adapter against the same Redis
+	params.RoomMetadataStore = wsredis.NewRedisRoomMetadataStore(pub, "peercalls")
+	adapter2 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", metadataRoom, params)
+	defer adapter2.Close()
+	restored, err := adapter2.RoomMetadata()
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, restored)
+
+	assert.Nil(t, store.SetRoomMetadata(metadataRoom, wsadapter.RoomMetadata{}))
+}
+
+func TestRedisAdapter_createdAt(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	createdRoom := "createdroom"
+
+	adapter1 := wsredis.NewRedisAdapter(pub, sub, "peercalls", createdRoom)
+	adapter2 := wsredis.NewRedisAdapter(pub, sub, "peercalls", createdRoom)
+	mockWriter1 := NewMockWriter()
+	defer close(mockWriter1.out)
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := client1.Subscribe(ctx, func(msg wsmessage.Message) {})
+		assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+		wg.Done()
+	}()
+
+	assert.Nil(t, adapter1.Add(client1))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(createdRoom, client1.ID(), "")), <-mockWriter1.out)
+
+	createdAt1, err := adapter1.CreatedAt()
+	require.Nil(t, err)
+	createdAt2, err := adapter2.CreatedAt()
+	require.Nil(t, err)
+	assert.True(t, createdAt1.Equal(createdAt2), "expected the same creation time on all instances")
+
+	// the room still has clients connected to adapter1
+	assert.Nil(t, adapter2.Close())
+	createdAt2, err = adapter1.CreatedAt()
+	require.Nil(t, err)
+	assert.True(t, createdAt1.Equal(createdAt2), "expected the creation time to be kept")
+
+	assert.Nil(t, adapter1.Close())
+	cancel()
+	wg.Wait()
+
+	adapter3 := wsredis.NewRedisAdapter(pub, sub, "peercalls", createdRoom)
+	defer adapter3.Close()
+	createdAt3, err := adapter3.CreatedAt()
+	require.Nil(t, err)
+	assert.True(t, createdAt3.After(createdAt1), "expected a new creation time after the room became empty")
+}
+
+func TestRedisAdapter_roomPassword(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	passwordRoom := "passwordroom"
+	pub.Del("peercalls:room:" + passwordRoom + ":password")
+
+	params := wsadapter.Params{
+		RoomPasswordStore: wsredis.NewRedisRoomPasswordStore(pub, "peercalls", time.Hour),
+	}
+	adapter1 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", passwordRoom, params)
+	adapter2 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", passwordRoom, params)
+
+	hash, err := adapter2.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+
+	require.Nil(t, adapter1.SetRoomPassword("hash"))
+	hash, err = adapter2.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "hash", hash, "expected the password to be shared by all instances")
+
+	require.Nil(t, adapter2.SetRoomPassword(""))
+	hash, err = adapter1.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "", hash)
+
+	// the password is kept after the room becomes empty, until it expires
+	require.Nil(t, adapter1.SetRoomPassword("hash"))
+	assert.Nil(t, adapter1.Close())
+	assert.Nil(t, adapter2.Close())
+	ttl, err := pub.TTL("peercalls:room:" + passwordRoom + ":password").Result()
+	require.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour, "expected the password to expire, ttl: %s", ttl)
+
+	adapter3 := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", passwordRoom, params)
+	defer adapter3.Close()
+	hash, err = adapter3.RoomPassword()
+	require.Nil(t, err)
+	assert.Equal(t, "hash", hash)
+	require.Nil(t, adapter3.SetRoomPassword(""))
+}
+
+func TestRedisAdapter_owner(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	ownerRoom := "ownerroom"
+	pub.Del("peercalls:room:"+ownerRoom+":owner", "peercalls:room:"+ownerRoom+":clients")
+
+	adapter1 := wsredis.NewRedisAdapter(pub, sub, "peercalls", ownerRoom)
+	adapter2 := wsredis.NewRedisAdapter(pub, sub, "peercalls", ownerRoom)
+	var clients []*ws.Client
+	for i := 0; i < 2; i++ {
+		// buffered so that join and leave messages do not block
+		mockWriter := &MockWSWriter{out: make(chan []byte, 16)}
+		defer close(mockWriter.out)
+		client := ws.NewClient(mockWriter)
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(len(clients))
+	for _, client := range clients {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	assert.Nil(t, adapter1.Add(clients[0]))
+	assert.Nil(t, adapter2.Add(clients[1]))
+
+	for _, adapter := range []*wsredis.RedisAdapter{adapter1, adapter2} {
+		owner, err := adapter.Owner()
+		require.Nil(t, err)
+		assert.Equal(t, clients[0].ID(), owner, "expected the first client to be the owner on all instances")
+	}
+
+	assert.Nil(t, adapter1.Remove(clients[0].ID()))
+	owner, err := adapter2.Owner()
+	require.Nil(t, err)
+	assert.Equal(t, "", owner, "expected no owner after the owner left")
+
+	for _, stop := range []func() error{adapter1.Close, adapter2.Close} {
+		err := stop()
+		assert.Equal(t, nil, err)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestRedisAdapter_raisedHands(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	handsRoom := "handsroom"
+	pub.Del("peercalls:room:" + handsRoom + ":hands")
+	adapter1 := wsredis.NewRedisAdapter(pub, sub, "peercalls", handsRoom)
+	adapter2 := wsredis.NewRedisAdapter(pub, sub, "peercalls", handsRoom)
+	mockWriter1 := NewMockWriter()
+	defer close(mockWriter1.out)
+	client1 := ws.NewClient(mockWriter1)
+	defer client1.Close()
+	mockWriter2 := NewMockWriter()
+	defer close(mockWriter2.out)
+	client2 := ws.NewClient(mockWriter2)
+	defer client2.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	for _, client := range []*ws.Client{client1, client2} {
+		go func(client *ws.Client) {
+			err := client.Subscribe(ctx, func(msg wsmessage.Message) {})
+			assert.True(t, errors.Is(err, context.Canceled), "expected error to be context.Canceled, but was: %s", err)
+			wg.Done()
+		}(client)
+	}
+
+	assert.Nil(t, adapter1.Add(client1))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(handsRoom, client1.ID(), "")), <-mockWriter1.out)
+
+	raiseHand := wsmessage.NewMessageRaiseHand(handsRoom, client1.ID(), "", true, 1)
+	assert.Nil(t, adapter1.Broadcast(raiseHand))
+	assert.Equal(t, serialize(t, raiseHand), <-mockWriter1.out)
+
+	// client2 joins via another instance and receives the raised hands
+	assert.Nil(t, adapter2.Add(client2))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(handsRoom, client2.ID(), "")), <-mockWriter2.out)
+	raisedHands, err := serializer.Deserialize(<-mockWriter2.out)
+	assert.Nil(t, err)
+	expectedRaisedHands, err := serializer.Deserialize(serialize(t, wsmessage.NewMessageRaisedHands(handsRoom, []wsmessage.Message{raiseHand})))
+	assert.Nil(t, err)
+	assert.Equal(t, expectedRaisedHands, raisedHands)
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomJoin(handsRoom, client2.ID(), "")), <-mockWriter1.out)
+
+	lowerHand := wsmessage.NewMessageRaiseHand(handsRoom, client1.ID(), "", false, 2)
+	assert.Nil(t, adapter1.Broadcast(lowerHand))
+	assert.Equal(t, serialize(t, lowerHand), <-mockWriter1.out)
+	assert.Equal(t, serialize(t, lowerHand), <-mockWriter2.out)
+	assert.Equal(t, int64(0), pub.HLen("peercalls:room:"+handsRoom+":hands").Val())
+
+	assert.Nil(t, adapter1.Remove(client1.ID()))
+	assert.Equal(t, serialize(t, wsmessage.NewMessageRoomLeave(handsRoom, client1.ID())), <-mockWriter2.out)
+	assert.Nil(t, adapter2.Remove(client2.ID()))
+
+	// left behind by an instance that stopped without removing its clients
+	pub.HSet("peercalls:room:"+handsRoom+":hands", "stale", "{}")
+
+	for _, stop := range []func() error{adapter1.Close, adapter2.Close} {
+		err := stop()
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, int64(0), pub.Exists("peercalls:room:"+handsRoom+":hands").Val(), "raised hands should be removed with the room")
+	cancel()
+	wg.Wait()
+}
+
+// Starts a fake Redis server handling each connection with serve.
+func startFakeRedisServer(t *testing.T, serve func(conn net.Conn)) (addr string, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String(), func() {
+		l.Close()
+		wg.Wait()
+	}
+}
+
+func writePSubscribed(conn net.Conn, patterns []string) {
+	for i, pattern := range patterns {
+		fmt.Fprintf(conn, "*3\r\n$10\r\npsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(pattern), pattern, i+1)
+	}
+}
+
+// Never replies to PUBLISH commands.
+func serveSlowPublish(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPArray(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PSUBSCRIBE":
+			writePSubscribed(conn, args[1:])
+		case "PUBLISH":
+		default:
+			fmt.Fprint(conn, ":0\r\n")
+		}
+	}
+}
+
+func readRESPArray(r *bufio.Reader) (args []string, err error) {
+	var n int
+	if _, err = fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		var size int
+		if _, err = fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return
+		}
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return
+}
+
+func TestRedisAdapter_publishTimeout(t *testing.T) {
+	addr, stopServer := startFakeRedisServer(t, serveSlowPublish)
+	defer stopServer()
+	pub := redis.NewClient(&redis.Options{Addr: addr})
+	defer pub.Close()
+	sub := redis.NewClient(&redis.Options{Addr: addr})
+	defer sub.Close()
+
+	adapter := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", room, wsadapter.Params{
+		PublishTimeout: 50 * time.Millisecond,
+	})
+
+	msg := wsmessage.NewMessage("test-type", room, "test")
+	for name, publish := range map[string]func() error{
+		"Broadcast": func() error {
+			return adapter.Broadcast(msg)
+		},
+		"Emit": func() error {
+			return adapter.Emit("client1", msg)
+		},
+		"BroadcastTo": func() error {
+			return adapter.BroadcastTo([]string{"client1", "client2"}, msg)
+		},
+	} {
+		start := time.Now()
+		err := publish()
+		require.NotNil(t, err, "expected %s to fail", name)
+		assert.True(t, errors.Is(err, wsredis.ErrPublishTimeout), "%s: expected ErrPublishTimeout, but was: %s", name, err)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second), "%s took too long", name)
+	}
+
+	assert.Nil(t, adapter.Close())
+}
+
+func TestRedisAdapter_pingInterval(t *testing.T) {
+	psubscribed := make(chan struct{}, 10)
+	// drops the connection on PING, like a proxy that reaped it
+	addr, stopServer := startFakeRedisServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readRESPArray(r)
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "PSUBSCRIBE":
+				writePSubscribed(conn, args[1:])
+				psubscribed <- struct{}{}
+			case "PING":
+				return
+			default:
+				fmt.Fprint(conn, ":0\r\n")
+			}
+		}
+	})
+	defer stopServer()
+	pub := redis.NewClient(&redis.Options{Addr: addr})
+	defer pub.Close()
+	sub := redis.NewClient(&redis.Options{Addr: addr})
+	defer sub.Close()
+
+	adapter := wsredis.NewRedisAdapterWithParams(pub, sub, "peercalls", room, wsadapter.Params{
+		PingInterval: 50 * time.Millisecond,
+	})
+	defer adapter.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-psubscribed:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for subscription", "subscription: %d", i+1)
+		}
+	}
+}
+
+func TestRedisRoomDirectoryStore_ActiveRooms(t *testing.T) {
+	pub, _, stop := configureRedis(t)
+	defer stop()
+	prefix := "peercalls_directory"
+	store := wsredis.NewRedisRoomDirectoryStore(pub, prefix)
+	metadata := wsredis.NewRedisRoomMetadataStore(pub, prefix)
+
+	require.Nil(t, pub.HSet(prefix+":room:room1:clients", "a", "", "b", "").Err())
+	require.Nil(t, pub.HSet(prefix+":room:room2:clients", "c", "").Err())
+	require.Nil(t, pub.HSet(prefix+":room:room3:clients", "d", "").Err())
+	defer pub.Del(prefix+":room:room1:clients", prefix+":room:room2:clients", prefix+":room:room3:clients")
+	for _, room := range []string{"room1", "room2", "room4"} {
+		require.Nil(t, metadata.SetRoomMetadata(room, wsadapter.RoomMetadata{Listed: true}))
+		defer metadata.SetRoomMetadata(room, wsadapter.RoomMetadata{})
+	}
+
+	rooms, err := store.ActiveRooms()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"room1": 2, "room2": 1}, rooms, "should only list rooms with clients")
+
+	require.Nil(t, metadata.SetRoomMetadata("room2", wsadapter.RoomMetadata{Topic: "unlisted"}))
+	rooms, err = store.ActiveRooms()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"room1": 2}, rooms)
+}
+
+type replaceableClient struct {
+	id       string
+	out      chan string
+	replaced chan struct{}
+}
+
+func newReplaceableClient(id string) *replaceableClient {
+	return &replaceableClient{
+		id:       id,
+		out:      make(chan string, 16),
+		replaced: make(chan struct{}),
+	}
+}
+
+func (c *replaceableClient) ID() string                 { return c.id }
+func (c *replaceableClient) Subscribed(typ string) bool { return true }
+func (c *replaceableClient) Metadata() string           { return "" }
+func (c *replaceableClient) SetMetadata(string)         {}
+func (c *replaceableClient) Replaced()                  { close(c.replaced) }
+
+func (c *replaceableClient) Send(msg wsmessage.Message) error {
+	c.out <- msg.Type
+	return nil
+}
+
+func TestRedisAdapter_Add_duplicate(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	adapter := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	defer adapter.Close()
+	other := newReplaceableClient("b")
+	client1 := newReplaceableClient("a")
+	client2 := newReplaceableClient("a")
+	join := wsmessage.MessageTypeRoomJoin
+
+	require.Nil(t, adapter.Add(other))
+	assert.Equal(t, join, <-other.out)
+	require.Nil(t, adapter.Add(client1))
+	assert.Equal(t, join, <-other.out)
+	assert.Equal(t, join, <-client1.out)
+
+	require.Nil(t, adapter.Add(client2))
+	<-client1.replaced
+	assert.Equal(t, map[string]string{"a": "", "b": ""}, getClientIDs(t, adapter))
+
+	require.Nil(t, adapter.Broadcast(wsmessage.NewMessage("test-type", room, nil)))
+	assert.Equal(t, "test-type", <-other.out)
+	assert.Equal(t, "test-type", <-client2.out)
+	assert.Empty(t, client1.out)
+
+	require.Nil(t, adapter.Remove("a"))
+	require.Nil(t, adapter.Remove("b"))
+}
+
+func TestRedisAdapter_Add_duplicateOtherInstance(t *testing.T) {
+	pub, sub, stop := configureRedis(t)
+	defer stop()
+	adapter1 := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	defer adapter1.Close()
+	adapter2 := wsredis.NewRedisAdapter(pub, sub, "peercalls", room)
+	defer adapter2.Close()
+	other := newReplaceableClient("b")
+	client1 := newReplaceableClient("a")
+	client2 := newReplaceableClient("a")
+	join := wsmessage.MessageTypeRoomJoin
+
+	require.Nil(t, adapter1.Add(other))
+	assert.Equal(t, join, <-other.out)
+	require.Nil(t, adapter1.Add(client1))
+	assert.Equal(t, join, <-other.out)
+	assert.Equal(t, join, <-client1.out)
+
+	// the client reconnects to another instance
+	require.Nil(t, adapter2.Add(client2))
+	<-client1.replaced
+	assert.Equal(t, map[string]string{"a": "", "b": ""}, getClientIDs(t, adapter1))
+
+	require.Nil(t, adapter1.Broadcast(wsmessage.NewMessage("test-type", room, nil)))
+	assert.Equal(t, "test-type", <-other.out)
+	assert.Equal(t, "test-type", <-client2.out)
+	assert.Empty(t, client1.out, "the replaced client should not receive messages")
+	assert.Empty(t, other.out, "the join message should not be broadcast again")
+
+	require.Nil(t, adapter2.Emit("a", wsmessage.NewMessage("emit-type", room, nil)))
+	assert.Equal(t, "emit-type", <-client2.out)
+
+	// removing the replaced client from its old instance does not remove the
+	// client from the room
+	require.Nil(t, adapter1.Remove("a"))
+	assert.Equal(t, map[string]string{"a": "", "b": ""}, getClientIDs(t, adapter2))
+
+	require.Nil(t, adapter2.Remove("a"))
+	require.Nil(t, adapter1.Remove("b"))
+}
@@ -0,0 +1,25 @@
+package wshandler
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrUnauthorized = errors.New("Unauthorized")
+
+// Authenticator authenticates websocket connection requests before they are
+// upgraded. A non-empty clientID overrides the client ID from the URL and the
+// metadata is announced to the other clients in the room. Connections are
+// rejected when an error is returned.
+type Authenticator interface {
+	Authenticate(r *http.Request) (clientID string, metadata string, err error)
+}
+
+// NoopAuthenticator accepts all requests without changing the client ID.
+type NoopAuthenticator struct{}
+
+var _ Authenticator = NoopAuthenticator{}
+
+func (NoopAuthenticator) Authenticate(r *http.Request) (string, string, error) {
+	return "", "", nil
+}
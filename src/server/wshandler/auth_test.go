@@ -0,0 +1,89 @@
+package wshandler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/wshandler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type fakeAuthenticator struct {
+	clientID string
+	metadata string
+	err      error
+}
+
+func (a fakeAuthenticator) Authenticate(r *http.Request) (string, string, error) {
+	return a.clientID, a.metadata, a.err
+}
+
+func setupServerWithAuthenticator(t *testing.T, authenticator wshandler.Authenticator) (server *httptest.Server, url string) {
+	wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+		Rooms:         room.NewRoomManager(newAdapter),
+		Config:        config.WSConfig{},
+		Authenticator: authenticator,
+	})
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	return
+}
+
+func TestWSS_authenticator_accept(t *testing.T) {
+	server, url := setupServerWithAuthenticator(t, fakeAuthenticator{
+		metadata: "alice",
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	msg := mustReadWS(t, ctx, ws1)
+	assert.Equal(t, map[string]interface{}{
+		"clientID": "client1",
+		"metadata": "alice",
+	}, msg.Payload)
+}
+
+func TestWSS_authenticator_reject(t *testing.T) {
+	server, url := setupServerWithAuthenticator(t, fakeAuthenticator{
+		err: errors.New("invalid session"),
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, res, err := websocket.Dial(ctx, url+"client1", nil)
+	require.NotNil(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestWSS_authenticator_overrideClientID(t *testing.T) {
+	server, url := setupServerWithAuthenticator(t, fakeAuthenticator{
+		clientID: "user-123",
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	msg := mustReadWS(t, ctx, ws1)
+	assert.Equal(t, map[string]interface{}{
+		"clientID": "user-123",
+		"metadata": "",
+	}, msg.Payload)
+}
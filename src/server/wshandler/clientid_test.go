@@ -0,0 +1,48 @@
+package wshandler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestWSS_assignClientID_collision(t *testing.T) {
+	rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+		return wsmemory.NewMemoryAdapter(room)
+	})
+	wss := NewWSS(rooms, config.WSConfig{
+		ClientIDMode: config.ClientIDModeServer,
+	})
+	wss.newClientID = func() string {
+		return "same-id"
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/test-room/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url+"client1", nil)
+	require.Nil(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	_, _, err = conn.Read(ctx)
+	require.Nil(t, err)
+
+	_, res, err := websocket.Dial(ctx, url+"client2", nil)
+	require.NotNil(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+}
@@ -0,0 +1,84 @@
+package wshandler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWSS_correlationID(t *testing.T) {
+	var out syncBuffer
+	defer func(l *logger.Logger) { log = l }(log)
+	log = logger.NewLogger("wshandler", &out, true)
+
+	rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+		return wsmemory.NewMemoryAdapter(room)
+	})
+	wss := NewWSS(rooms, config.WSConfig{
+		CorrelationIDs: true,
+	})
+	events := make(chan RoomEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event RoomEvent) {
+			events <- event
+		})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/test-room/client1"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	require.Nil(t, err)
+	data, err := wsmessage.ByteSerializer{}.Serialize(wsmessage.NewMessage("test", "test-room", nil))
+	require.Nil(t, err)
+	require.Nil(t, conn.Write(ctx, websocket.MessageText, data))
+
+	event := <-events
+	correlationID := logger.CorrelationID(event.Context)
+	assert.Regexp(t, "^[0-9a-f]{8}$", correlationID)
+	require.NotEqual(t, "", correlationID)
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "wss.rooms.Exit")
+	}, time.Second, 10*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Greater(t, len(lines), 2)
+	for _, line := range lines {
+		assert.Contains(t, line, "["+correlationID+"] ")
+	}
+}
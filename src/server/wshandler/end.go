@@ -0,0 +1,88 @@
+package wshandler
+
+import (
+	"sync"
+
+	"github.com/jeremija/peer-calls/src/server/ws"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+// roomEnding records the first message that ends the room for a client.
+type roomEnding struct {
+	once sync.Once
+	done chan struct{}
+	// Set before done is closed.
+	message wsmessage.Message
+	// Set before done is closed when another client with the same ID was
+	// added to the room.
+	replaced bool
+}
+
+func newRoomEnding() *roomEnding {
+	return &roomEnding{
+		done: make(chan struct{}),
+	}
+}
+
+func (e *roomEnding) end(msg wsmessage.Message) {
+	e.once.Do(func() {
+		e.message = msg
+		close(e.done)
+	})
+}
+
+func (e *roomEnding) replace() {
+	e.once.Do(func() {
+		e.replaced = true
+		close(e.done)
+	})
+}
+
+// Returns true when the client was replaced by another client with the same
+// ID.
+func (e *roomEnding) isReplaced() bool {
+	select {
+	case <-e.done:
+		return e.replaced
+	default:
+		return false
+	}
+}
+
+func isRoomEndMessage(typ string) bool {
+	return typ == wsmessage.MessageTypeRoomEnd || typ == wsmessage.MessageTypeRoomRedirect
+}
+
+// endingClient ends the room instead of sending room end and redirect
+// messages, so that the connection can be closed after the message has been
+// written. These messages are broadcast through the adapter, which delivers
+// them to clients connected to all instances when using Redis.
+type endingClient struct {
+	*ws.Client
+	ending *roomEnding
+}
+
+func newEndingClient(client *ws.Client, ending *roomEnding) endingClient {
+	return endingClient{
+		Client: client,
+		ending: ending,
+	}
+}
+
+func (c endingClient) Send(msg wsmessage.Message) error {
+	if !isRoomEndMessage(msg.Type) {
+		return c.Client.Send(msg)
+	}
+	c.ending.end(msg)
+	return nil
+}
+
+// Ends the room for the client so the connection is closed.
+func (c endingClient) Replaced() {
+	c.ending.replace()
+}
+
+// Room end messages are delivered regardless of subscribed message types.
+func (c endingClient) Subscribed(typ string) bool {
+	return isRoomEndMessage(typ) || c.Client.Subscribed(typ)
+}
@@ -0,0 +1,31 @@
+package wshandler
+
+import (
+	"context"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+)
+
+type enteredRoomKey struct{}
+
+type enteredRoom struct {
+	room    string
+	adapter wsadapter.Adapter
+}
+
+// Returns a context carrying the adapter of a room the caller has already
+// entered, e.g. to decide which handler serves the connection. HandleRoom
+// uses the adapter instead of entering the room again, and leaves exiting the
+// room to the caller.
+func WithEnteredRoom(ctx context.Context, room string, adapter wsadapter.Adapter) context.Context {
+	return context.WithValue(ctx, enteredRoomKey{}, enteredRoom{room, adapter})
+}
+
+// Returns the adapter set with WithEnteredRoom when it belongs to room.
+func enteredRoomAdapter(ctx context.Context, room string) (wsadapter.Adapter, bool) {
+	entered, ok := ctx.Value(enteredRoomKey{}).(enteredRoom)
+	if !ok || entered.room != room {
+		return nil, false
+	}
+	return entered.adapter, true
+}
@@ -0,0 +1,31 @@
+package wshandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestGetLeaveReason(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		kicked bool
+		reason string
+	}{
+		{nil, false, wsmessage.LeaveReasonLeft},
+		{websocket.CloseError{Code: websocket.StatusNormalClosure}, false, wsmessage.LeaveReasonLeft},
+		{websocket.CloseError{Code: websocket.StatusGoingAway}, false, wsmessage.LeaveReasonLeft},
+		{websocket.CloseError{Code: websocket.StatusPolicyViolation}, true, wsmessage.LeaveReasonKicked},
+		{fmt.Errorf("write: %w", context.DeadlineExceeded), false, wsmessage.LeaveReasonTimeout},
+		{io.EOF, false, wsmessage.LeaveReasonDisconnected},
+		{errors.New("read error"), false, wsmessage.LeaveReasonDisconnected},
+	} {
+		assert.Equal(t, tc.reason, getLeaveReason(tc.err, tc.kicked), "error: %v", tc.err)
+	}
+}
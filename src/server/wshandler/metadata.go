@@ -0,0 +1,79 @@
+package wshandler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+var ErrInvalidMetadata = errors.New("Invalid metadata")
+
+var ErrMetadataRequired = errors.New("Metadata required")
+
+// Returns the wsmessage error code sent to clients whose metadata was
+// rejected with err.
+func MetadataErrorCode(err error) string {
+	if errors.Is(err, ErrMetadataRequired) {
+		return wsmessage.ErrorCodeMetadataRequired
+	}
+	return wsmessage.ErrorCodeInvalidMetadata
+}
+
+// MetadataValidator checks metadata sent by clients, such as display names,
+// before it is stored and announced to the other clients in the room.
+type MetadataValidator struct {
+	maxLength int
+	pattern   *regexp.Regexp
+	check     func(metadata string) error
+}
+
+type MetadataValidatorParams struct {
+	// Maximum number of characters. Unlimited when zero.
+	MaxLength int
+	// Regular expression that the whole metadata must match. Must have been
+	// validated. All metadata is allowed when empty.
+	Pattern string
+	// Called after the other checks pass, e.g. to filter profanity. The
+	// metadata is rejected when an error is returned. Optional.
+	Check func(metadata string) error
+}
+
+func NewMetadataValidator(params MetadataValidatorParams) *MetadataValidator {
+	v := &MetadataValidator{
+		maxLength: params.MaxLength,
+		check:     params.Check,
+	}
+	if params.Pattern != "" {
+		v.pattern = regexp.MustCompile("^(?:" + params.Pattern + ")$")
+	}
+	return v
+}
+
+// Returns an error wrapping ErrInvalidMetadata when metadata is rejected.
+// Metadata with control characters or invalid UTF-8 is always rejected.
+func (v *MetadataValidator) Validate(metadata string) error {
+	if !utf8.ValidString(metadata) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidMetadata)
+	}
+	for _, r := range metadata {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: contains control characters", ErrInvalidMetadata)
+		}
+	}
+	if length := utf8.RuneCountInString(metadata); v.maxLength > 0 && length > v.maxLength {
+		return fmt.Errorf("%w: %d characters, limit is %d", ErrInvalidMetadata, length, v.maxLength)
+	}
+	if v.pattern != nil && !v.pattern.MatchString(metadata) {
+		return fmt.Errorf("%w: contains characters that are not allowed", ErrInvalidMetadata)
+	}
+	if v.check != nil {
+		if err := v.check(metadata); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMetadata, err)
+		}
+	}
+	return nil
+}
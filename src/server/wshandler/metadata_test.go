@@ -0,0 +1,66 @@
+package wshandler_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/jeremija/peer-calls/src/server/wshandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataValidator(t *testing.T) {
+	v := wshandler.NewMetadataValidator(wshandler.MetadataValidatorParams{
+		MaxLength: 5,
+		Pattern:   `[\pL ]*`,
+		Check: func(metadata string) error {
+			if strings.Contains(metadata, "bad") {
+				return errors.New("profanity")
+			}
+			return nil
+		},
+	})
+
+	assert.NoError(t, v.Validate(""))
+	assert.NoError(t, v.Validate("abc"))
+	assert.NoError(t, v.Validate("ćčžšđ"), "length is counted in characters")
+
+	for _, metadata := range []string{
+		"abcdef",
+		"a1",
+		"a\nb",
+		"\xff",
+		"bad",
+	} {
+		assert.True(t, errors.Is(v.Validate(metadata), wshandler.ErrInvalidMetadata), "expected %q to be rejected", metadata)
+	}
+}
+
+func TestMetadataValidator_defaults(t *testing.T) {
+	v := wshandler.NewMetadataValidator(wshandler.MetadataValidatorParams{})
+
+	assert.NoError(t, v.Validate(strings.Repeat("a1 -_", 100)))
+	assert.True(t, errors.Is(v.Validate("a\x00"), wshandler.ErrInvalidMetadata))
+}
+
+func TestWSS_CheckMetadata(t *testing.T) {
+	wss := wshandler.NewWSS(nil, config.WSConfig{
+		MetadataMaxLength: 3,
+		RequireMetadata:   true,
+	})
+
+	assert.NoError(t, wss.CheckMetadata("abc"))
+
+	err := wss.CheckMetadata("")
+	assert.Equal(t, wshandler.ErrMetadataRequired, err)
+	assert.Equal(t, wsmessage.ErrorCodeMetadataRequired, wshandler.MetadataErrorCode(err))
+
+	err = wss.CheckMetadata("abcd")
+	assert.True(t, errors.Is(err, wshandler.ErrInvalidMetadata))
+	assert.Equal(t, wsmessage.ErrorCodeInvalidMetadata, wshandler.MetadataErrorCode(err))
+
+	wss = wshandler.NewWSS(nil, config.WSConfig{})
+	assert.NoError(t, wss.CheckMetadata(""))
+}
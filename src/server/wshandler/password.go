@@ -0,0 +1,77 @@
+package wshandler
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrTooManyPasswordAttempts = errors.New("Too many invalid room passwords")
+
+// Number of invalid room passwords accepted from an IP address within
+// passwordAttemptsWindow before its attempts to join the room are rejected.
+const maxPasswordAttempts = 5
+
+const passwordAttemptsWindow = time.Minute
+
+type passwordAttemptsKey struct {
+	ip   string
+	room string
+}
+
+type passwordAttempt struct {
+	count     int
+	expiresAt time.Time
+}
+
+// passwordAttempts limits the number of invalid room passwords per IP address
+// and room. Failures are counted in fixed windows that start with the first
+// failure.
+type passwordAttempts struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	attempts map[passwordAttemptsKey]passwordAttempt
+}
+
+func newPasswordAttempts() *passwordAttempts {
+	return &passwordAttempts{
+		now:      time.Now,
+		attempts: map[passwordAttemptsKey]passwordAttempt{},
+	}
+}
+
+// Returns true when the password of room should not be checked for ip.
+func (p *passwordAttempts) Blocked(ip string, room string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeExpired()
+	return p.attempts[passwordAttemptsKey{ip, room}].count >= maxPasswordAttempts
+}
+
+func (p *passwordAttempts) Failed(ip string, room string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeExpired()
+	key := passwordAttemptsKey{ip, room}
+	attempt, ok := p.attempts[key]
+	if !ok {
+		attempt.expiresAt = p.now().Add(passwordAttemptsWindow)
+	}
+	attempt.count++
+	p.attempts[key] = attempt
+}
+
+func (p *passwordAttempts) Succeeded(ip string, room string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.attempts, passwordAttemptsKey{ip, room})
+}
+
+func (p *passwordAttempts) removeExpired() {
+	now := p.now()
+	for key, attempt := range p.attempts {
+		if !now.Before(attempt.expiresAt) {
+			delete(p.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package wshandler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func mustSetRoomPassword(t *testing.T, ctx context.Context, conn *websocket.Conn, payload interface{}) string {
+	t.Helper()
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeSetRoomPassword, roomName, payload))
+	msg := mustReadWS(t, ctx, conn)
+	require.Equal(t, wsmessage.MessageTypeRoomPassword, msg.Type)
+	password, ok := msg.Payload.(map[string]interface{})["password"].(string)
+	require.True(t, ok, "expected a password in: %v", msg.Payload)
+	return password
+}
+
+func assertInvalidPassword(t *testing.T, ctx context.Context, conn *websocket.Conn) {
+	t.Helper()
+	msg := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, wsmessage.ErrorCodeInvalidPassword, msg.Payload.(map[string]interface{})["code"])
+	_, _, err := conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+}
+
+func TestWSS_roomPassword(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RoomPasswords: true,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+	assert.Equal(t, "secret", mustSetRoomPassword(t, ctx, conn1, map[string]string{"password": "secret"}))
+
+	t.Run("incorrect query param", func(t *testing.T) {
+		_, res, err := websocket.Dial(ctx, url+"client2?password=invalid", nil)
+		require.NotNil(t, err)
+		require.NotNil(t, res)
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("incorrect join message", func(t *testing.T) {
+		conn := mustDialWS(t, ctx, url+"client2")
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeJoin, roomName, map[string]string{"password": "invalid"}))
+		assertInvalidPassword(t, ctx, conn)
+	})
+
+	t.Run("other message instead of join message", func(t *testing.T) {
+		conn := mustDialWS(t, ctx, url+"client2")
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeChat, roomName, "hello"))
+		assertInvalidPassword(t, ctx, conn)
+	})
+
+	t.Run("correct query param", func(t *testing.T) {
+		conn := mustDialWS(t, ctx, url+"client3?password=secret")
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		mustReadJoin(t, ctx, conn)
+		assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+
+		// only the first participant can change the password
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeSetRoomPassword, roomName, nil))
+		msg := mustReadWS(t, ctx, conn)
+		assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+		assert.Equal(t, wsmessage.ErrorCodeForbidden, msg.Payload.(map[string]interface{})["code"])
+
+		conn.Close(websocket.StatusNormalClosure, "")
+		clientID, _, _ := wsmessage.RoomLeaveState(mustReadWS(t, ctx, conn1))
+		assert.Equal(t, "client3", clientID)
+	})
+
+	t.Run("correct join message", func(t *testing.T) {
+		conn := mustDialWS(t, ctx, url+"client4")
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeJoin, roomName, map[string]string{"password": "secret"}))
+		mustReadJoin(t, ctx, conn)
+		assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+	})
+}
+
+func TestWSS_roomPassword_generated(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RoomPasswords: true,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+	password := mustSetRoomPassword(t, ctx, conn1, nil)
+	assert.NotEqual(t, "", password)
+
+	_, res, err := websocket.Dial(ctx, url+"client2?password=invalid", nil)
+	require.NotNil(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	conn2 := mustDialWS(t, ctx, url+"client2?password="+password)
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn2)
+}
+
+func TestWSS_roomPassword_disabled(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+
+	mustWriteWS(t, ctx, conn1, wsmessage.NewMessage(wsmessage.MessageTypeSetRoomPassword, roomName, map[string]string{"password": "secret"}))
+	msg := mustReadWS(t, ctx, conn1)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, wsmessage.ErrorCodeForbidden, msg.Payload.(map[string]interface{})["code"])
+
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn2)
+}
+
+func TestWSS_roomPassword_attempts(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RoomPasswords: true,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+	mustSetRoomPassword(t, ctx, conn1, map[string]string{"password": "secret"})
+
+	dial := func(query string) int {
+		_, res, err := websocket.Dial(ctx, url+"client2"+query, nil)
+		require.NotNil(t, err)
+		require.NotNil(t, res)
+		return res.StatusCode
+	}
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusForbidden, dial("?password=invalid"))
+	}
+
+	// invalid passwords in join messages count too
+	conn := mustDialWS(t, ctx, url+"client2")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeJoin, roomName, map[string]string{"password": "invalid"}))
+	assertInvalidPassword(t, ctx, conn)
+
+	assert.Equal(t, http.StatusTooManyRequests, dial("?password=invalid"))
+	assert.Equal(t, http.StatusTooManyRequests, dial("?password=secret"))
+	assert.Equal(t, http.StatusTooManyRequests, dial(""))
+}
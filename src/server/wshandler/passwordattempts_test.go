@@ -0,0 +1,37 @@
+package wshandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordAttempts(t *testing.T) {
+	p := newPasswordAttempts()
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	for i := 0; i < maxPasswordAttempts; i++ {
+		assert.False(t, p.Blocked("10.0.0.1", "room1"))
+		p.Failed("10.0.0.1", "room1")
+	}
+	assert.True(t, p.Blocked("10.0.0.1", "room1"))
+	assert.False(t, p.Blocked("10.0.0.1", "room2"), "should be limited per room")
+	assert.False(t, p.Blocked("10.0.0.2", "room1"), "should be limited per IP address")
+
+	now = now.Add(passwordAttemptsWindow)
+	assert.False(t, p.Blocked("10.0.0.1", "room1"), "should be reset after the window")
+	assert.Empty(t, p.attempts, "expired attempts should be removed")
+}
+
+func TestPasswordAttempts_succeeded(t *testing.T) {
+	p := newPasswordAttempts()
+
+	for i := 0; i < maxPasswordAttempts-1; i++ {
+		p.Failed("10.0.0.1", "room1")
+	}
+	p.Succeeded("10.0.0.1", "room1")
+	p.Failed("10.0.0.1", "room1")
+	assert.False(t, p.Blocked("10.0.0.1", "room1"))
+}
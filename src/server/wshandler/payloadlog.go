@@ -0,0 +1,74 @@
+package wshandler
+
+import (
+	"encoding/json"
+
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+)
+
+const redacted = "[REDACTED]"
+
+// Logs messages received from clients with the values of sensitive fields
+// redacted.
+type payloadLogger struct {
+	log    *logger.Logger
+	redact map[string]struct{}
+}
+
+func newPayloadLogger(log *logger.Logger, redact []string) *payloadLogger {
+	fields := make(map[string]struct{}, len(redact)+1)
+	// room passwords are never logged
+	fields["password"] = struct{}{}
+	for _, field := range redact {
+		fields[field] = struct{}{}
+	}
+	return &payloadLogger{
+		log:    log,
+		redact: fields,
+	}
+}
+
+func (p *payloadLogger) Log(room string, clientID string, message wsmessage.Message) {
+	p.log.Printf("Received message type: %s, room: %s, clientID: %s, payload: %s",
+		message.Type, room, clientID, p.format(message.Payload))
+}
+
+// Returns the payload encoded as JSON with redacted fields. The payload is
+// decoded first so that payloads of any type are redacted the same way.
+func (p *payloadLogger) format(payload interface{}) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "<error encoding payload: " + err.Error() + ">"
+	}
+
+	if len(p.redact) == 0 {
+		return string(data)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "<error decoding payload: " + err.Error() + ">"
+	}
+
+	data, _ = json.Marshal(p.redactValue(value))
+	return string(data)
+}
+
+func (p *payloadLogger) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if _, ok := p.redact[key]; ok {
+				v[key] = redacted
+				continue
+			}
+			v[key] = p.redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = p.redactValue(item)
+		}
+	}
+	return value
+}
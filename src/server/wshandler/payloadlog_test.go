@@ -0,0 +1,105 @@
+package wshandler_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/jeremija/peer-calls/src/server/wshandler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// Sends a message to a server logging payloads to a buffer and returns the
+// buffer after the message has been handled.
+func sendLoggedMessage(t *testing.T, c config.WSConfig, msg wsmessage.Message) string {
+	t.Helper()
+	var buf bytes.Buffer
+	handled := make(chan wshandler.RoomEvent, 1)
+	wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+		Rooms:         room.NewRoomManager(newAdapter),
+		Config:        c,
+		PayloadLogger: logger.NewLogger("payload", &buf, true),
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {
+			handled <- event
+		})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/client1"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, url)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, msg)
+
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		require.Fail(t, "timed out waiting for message to be handled")
+	}
+	return buf.String()
+}
+
+func TestWSS_logPayloads(t *testing.T) {
+	output := sendLoggedMessage(t, config.WSConfig{
+		LogPayloads:       true,
+		LogPayloadsRedact: []string{"sdp", "nickname"},
+	}, wsmessage.NewMessage("signal", roomName, map[string]interface{}{
+		"userId":   "client1",
+		"nickname": "alice",
+		"signal": map[string]interface{}{
+			"type": "offer",
+			"sdp":  "v=0",
+		},
+		"signals": []interface{}{
+			map[string]interface{}{"sdp": "v=1"},
+		},
+	}))
+
+	assert.Contains(t, output, "type: signal, room: "+roomName+", clientID: client1")
+	assert.Contains(t, output, `"nickname":"[REDACTED]"`)
+	assert.Contains(t, output, `"signal":{"sdp":"[REDACTED]","type":"offer"}`)
+	assert.Contains(t, output, `"signals":[{"sdp":"[REDACTED]"}]`)
+	assert.Contains(t, output, `"userId":"client1"`)
+	assert.NotContains(t, output, "alice")
+	assert.NotContains(t, output, "v=0")
+	assert.NotContains(t, output, "v=1")
+}
+
+func TestWSS_logPayloads_noRedaction(t *testing.T) {
+	output := sendLoggedMessage(t, config.WSConfig{
+		LogPayloads: true,
+	}, wsmessage.NewMessage("chat", roomName, "hello"))
+
+	assert.Contains(t, output, `type: chat, room: `+roomName+`, clientID: client1, payload: "hello"`)
+}
+
+func TestWSS_logPayloads_disabledByDefault(t *testing.T) {
+	output := sendLoggedMessage(t, config.WSConfig{},
+		wsmessage.NewMessage("chat", roomName, "hello"))
+
+	assert.Equal(t, "", output)
+}
+
+func TestWSS_logPayloads_passwordRedacted(t *testing.T) {
+	output := sendLoggedMessage(t, config.WSConfig{
+		LogPayloads: true,
+	}, wsmessage.NewMessage("test", roomName, map[string]interface{}{
+		"password": "secret",
+	}))
+
+	assert.Contains(t, output, `"password":"[REDACTED]"`)
+	assert.NotContains(t, output, "secret")
+}
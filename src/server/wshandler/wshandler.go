@@ -3,10 +3,21 @@ package wshandler
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"path"
+	"regexp"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/jeremija/peer-calls/src/server/basen"
+	"github.com/jeremija/peer-calls/src/server/clientip"
+	"github.com/jeremija/peer-calls/src/server/config"
 	"github.com/jeremija/peer-calls/src/server/logger"
+	"github.com/jeremija/peer-calls/src/server/quota"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/webhook"
 	"github.com/jeremija/peer-calls/src/server/ws"
 	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
 	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
@@ -16,31 +27,284 @@ import (
 var log = logger.GetLogger("wshandler")
 
 type RoomManager interface {
-	Enter(room string) wsadapter.Adapter
+	Enter(room string) (wsadapter.Adapter, error)
 	Exit(room string)
+	// Returns a channel that is closed once the room has been closed and
+	// its clients should be disconnected. A nil channel is returned for
+	// rooms that are never closed.
+	Closed(room string) <-chan struct{}
 }
 
+var ErrClientIDCollision = errors.New("Client ID collision")
+
+var ErrRoomLocked = errors.New("Room is locked")
+
+var ErrInvalidRoomPassword = errors.New("Invalid room password")
+
+var ErrInvalidRoomName = errors.New("Invalid room name")
+
+var ErrShuttingDown = errors.New("Server is shutting down")
+
+// Number of times a server-assigned client ID is regenerated when it is
+// already in use.
+const maxClientIDAttempts = 3
+
+// Time a client connecting to a room with a password has to send the join
+// message with the password, when it was not supplied in the URL.
+const joinTimeout = 10 * time.Second
+
 type WSS struct {
-	rooms RoomManager
+	rooms         RoomManager
+	config        config.WSConfig
+	authenticator Authenticator
+	clientIP      *clientip.Resolver
+	payloadLog    *payloadLogger
+	quota         *quota.Tracker
+	webhooks      *webhook.Dispatcher
+	serializer    config.SerializerType
+	messageTypes  map[string]struct{}
+	newClientID   func() string
+	roomName      *regexp.Regexp
+	metadata      *MetadataValidator
+	passwords     *passwordAttempts
+
+	shutdownMu  sync.Mutex
+	shutdown    chan struct{}
+	connections sync.WaitGroup
+}
+
+func compressionMode(compression config.Compression) websocket.CompressionMode {
+	switch compression {
+	case config.CompressionContextTakeover:
+		return websocket.CompressionContextTakeover
+	case config.CompressionNoContextTakeover:
+		return websocket.CompressionNoContextTakeover
+	default:
+		return websocket.CompressionDisabled
+	}
+}
+
+func overflowPolicy(policy config.OverflowPolicy) ws.OverflowPolicy {
+	switch policy {
+	case config.OverflowPolicyDropOldest:
+		return ws.OverflowPolicyDropOldest
+	case config.OverflowPolicyCloseConnection:
+		return ws.OverflowPolicyCloseConnection
+	default:
+		return ws.OverflowPolicyDropNewest
+	}
+}
+
+// Returns the subprotocols accepted from clients and the client params
+// matching the serializer type.
+func serializerParams(serializerType config.SerializerType) ([]string, ws.ClientParams) {
+	switch serializerType {
+	case config.SerializerTypeProtobuf:
+		return ws.ProtobufSubprotocols, ws.ClientParams{
+			Serializer:  wsmessage.ProtoSerializer{},
+			MessageType: websocket.MessageBinary,
+		}
+	case config.SerializerTypeBinary:
+		return ws.BinarySubprotocols, ws.ClientParams{
+			Serializer: wsmessage.CompressingSerializer{
+				Serializer: wsmessage.ByteSerializer{},
+			},
+			MessageType: websocket.MessageBinary,
+		}
+	default:
+		return ws.Subprotocols, ws.ClientParams{
+			Serializer:  wsmessage.ByteSerializer{},
+			MessageType: websocket.MessageText,
+		}
+	}
 }
 
-func NewWSS(rooms RoomManager) *WSS {
-	return &WSS{
-		rooms: rooms,
+// Responds to a connection that could not enter room because of err. Clients
+// rejected because the server or room is at capacity receive a room_full
+// error message before the websocket is closed, others a 503 response.
+func (wss *WSS) RejectEnter(w http.ResponseWriter, r *http.Request, room string, err error) {
+	if !isRoomFull(err) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	subprotocols, clientParams := serializerParams(wss.serializer)
+	c, acceptErr := websocket.Accept(w, r, &websocket.AcceptOptions{
+		Subprotocols: subprotocols,
+	})
+	if acceptErr != nil {
+		log.Printf("Error accepting websocket connection: %s", acceptErr)
+		return
+	}
+	defer c.Close(websocket.StatusTryAgainLater, err.Error())
+
+	client := ws.NewClientWithParams(c, clientParams)
+	defer client.Close()
+	if protocol := c.Subprotocol(); protocol != "" {
+		client.SetProtocol(protocol)
+	} else {
+		client.SetProtocol(ws.ProtocolVersion1)
+	}
+
+	msg := wsmessage.NewMessageError(room, wsmessage.ErrorCodeRoomFull, err.Error())
+	if writeErr := client.WriteTimeout(r.Context(), time.Second, msg); writeErr != nil {
+		log.Printf("Error sending room full error in room: %s: %s", room, writeErr)
+	}
+}
+
+func isRoomFull(err error) bool {
+	return errors.Is(err, room.ErrTooManyRooms)
+}
+
+// The room name pattern, metadata pattern and trusted proxies in c must have
+// been validated.
+func NewWSS(rooms RoomManager, c config.WSConfig) *WSS {
+	return NewWSSWithParams(WSSParams{
+		Rooms:  rooms,
+		Config: c,
+	})
+}
+
+type WSSParams struct {
+	Rooms  RoomManager
+	Config config.WSConfig
+	// Defaults to NoopAuthenticator.
+	Authenticator Authenticator
+	// Logs message payloads when Config.LogPayloads is set. Defaults to the
+	// wshandler logger.
+	PayloadLogger *logger.Logger
+	// Accounts the bytes sent to and received from clients and closes the
+	// connections of clients over the quota. Optional.
+	Quota *quota.Tracker
+	// Notified when rooms are created or destroyed and when clients join or
+	// leave. Optional.
+	Webhooks *webhook.Dispatcher
+	// Encoding of messages exchanged with clients. Defaults to JSON.
+	Serializer config.SerializerType
+	// Types of messages handled by the room handler. Other types are handled
+	// according to Config.UnknownMessagePolicy. All types are passed to the
+	// room handler when empty.
+	MessageTypes []string
+	// Additional check of metadata sent by clients, e.g. a profanity filter.
+	// Metadata is rejected when an error is returned. Optional.
+	MetadataCheck func(metadata string) error
+}
+
+func NewWSSWithParams(params WSSParams) *WSS {
+	authenticator := params.Authenticator
+	if authenticator == nil {
+		authenticator = NoopAuthenticator{}
+	}
+	wss := &WSS{
+		rooms:         params.Rooms,
+		config:        params.Config,
+		authenticator: authenticator,
+		clientIP:      clientip.NewResolver(params.Config.TrustedProxies),
+		quota:         params.Quota,
+		webhooks:      params.Webhooks,
+		serializer:    params.Serializer,
+		newClientID:   basen.NewUUIDBase62,
+		shutdown:      make(chan struct{}),
+		passwords:     newPasswordAttempts(),
+		metadata: NewMetadataValidator(MetadataValidatorParams{
+			MaxLength: params.Config.MetadataMaxLength,
+			Pattern:   params.Config.MetadataPattern,
+			Check:     params.MetadataCheck,
+		}),
+	}
+	if params.Config.LogPayloads {
+		payloadLog := params.PayloadLogger
+		if payloadLog == nil {
+			payloadLog = log
+		}
+		wss.payloadLog = newPayloadLogger(payloadLog, params.Config.LogPayloadsRedact)
+	}
+	if len(params.MessageTypes) > 0 {
+		wss.messageTypes = map[string]struct{}{
+			wsmessage.MessageTypeSubscribe:       {},
+			wsmessage.MessageTypeJoin:            {},
+			wsmessage.MessageTypeSetRoomPassword: {},
+			wsmessage.MessageTypeCompression:     {},
+		}
+		for _, typ := range params.MessageTypes {
+			wss.messageTypes[typ] = struct{}{}
+		}
+	}
+	if pattern := params.Config.RoomNamePattern; pattern != "" {
+		wss.roomName = regexp.MustCompile("^(?:" + pattern + ")$")
+	}
+	return wss
+}
+
+// Room names that are empty, path elements like "..", or contain control
+// characters are never allowed.
+func (wss *WSS) isValidRoomName(room string) bool {
+	switch room {
+	case "", ".", "..", "/":
+		return false
+	}
+	for _, r := range room {
+		if r == unicode.ReplacementChar || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return wss.roomName == nil || wss.roomName.MatchString(room)
+}
+
+// Checks the metadata of a client joining a room. Metadata supplied in the
+// URL is validated, while metadata from the authenticator is trusted.
+func (wss *WSS) checkJoinMetadata(metadata string, fromURL bool) error {
+	if metadata != "" && !fromURL {
+		return nil
+	}
+	return wss.CheckMetadata(metadata)
+}
+
+// Checks metadata sent by a client, such as the nickname of the ready
+// message, before it replaces the metadata of the client with
+// Adapter.SetMetadata. Returns ErrMetadataRequired when metadata is empty
+// and required, and an error wrapping ErrInvalidMetadata when it is rejected
+// by ValidateMetadata.
+func (wss *WSS) CheckMetadata(metadata string) error {
+	if metadata == "" {
+		if wss.config.RequireMetadata {
+			return ErrMetadataRequired
+		}
+		return nil
 	}
+	return wss.ValidateMetadata(metadata)
+}
+
+// Checks metadata sent by a client before it is stored with
+// Adapter.SetMetadata. Returns an error wrapping ErrInvalidMetadata when the
+// metadata is rejected.
+func (wss *WSS) ValidateMetadata(metadata string) error {
+	return wss.metadata.Validate(metadata)
 }
 
 type RoomEvent struct {
 	ClientID string
+	// Address of the client, taken from headers set by trusted proxies.
+	ClientIP string
 	Room     string
+	Protocol string
 	Adapter  wsadapter.Adapter
 	Message  wsmessage.Message
+	// Context of the connection, carrying its correlation ID when
+	// config.WSConfig.CorrelationIDs is set.
+	Context context.Context
 }
 
 type CleanupEvent struct {
 	ClientID string
 	Room     string
 	Adapter  wsadapter.Adapter
+	// One of the wsmessage.LeaveReason constants.
+	Reason string
+	// Connection of the client. When the server closes the connection the
+	// cleanup runs before it is closed, so messages written directly to the
+	// client are still delivered.
+	Client *ws.Client
 }
 
 func (wss *WSS) HandleRoom(w http.ResponseWriter, r *http.Request, handleMessage func(RoomEvent)) {
@@ -48,63 +312,436 @@ func (wss *WSS) HandleRoom(w http.ResponseWriter, r *http.Request, handleMessage
 }
 
 func (wss *WSS) HandleRoomWithCleanup(w http.ResponseWriter, r *http.Request, handleMessage func(RoomEvent), cleanup func(CleanupEvent)) {
+	clientID := path.Base(r.URL.Path)
+	room := path.Base(path.Dir(r.URL.Path))
+	clientIP := wss.clientIP.ClientIP(r)
+
+	// all log lines of this connection are tagged with the same ID
+	var correlationID, logPrefix string
+	if wss.config.CorrelationIDs {
+		correlationID = logger.NewCorrelationID()
+		logPrefix = "[" + correlationID + "] "
+	}
+	log := logger.NewPrefixLogger(log, logPrefix)
+
+	if !wss.isValidRoomName(room) {
+		log.Printf("Rejecting clientID: %s, ip: %s from invalid room: %q", clientID, clientIP, room)
+		http.Error(w, ErrInvalidRoomName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !wss.addConnection() {
+		log.Printf("Rejecting clientID: %s, ip: %s from room: %s: %s", clientID, clientIP, room, ErrShuttingDown)
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer wss.connections.Done()
+
+	authClientID, metadata, err := wss.authenticator.Authenticate(r)
+	if err != nil {
+		log.Printf("Rejecting unauthenticated clientID: %s, ip: %s from room: %s: %s", clientID, clientIP, room, err)
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	if authClientID != "" {
+		clientID = authClientID
+	}
+	// clients that are not given metadata by the authenticator can supply
+	// it in the URL, e.g. a display name required to join
+	metadataFromURL := metadata == ""
+	if metadataFromURL {
+		metadata = r.URL.Query().Get("metadata")
+	}
+
+	adapter, entered := enteredRoomAdapter(r.Context(), room)
+	if !entered {
+		adapter, err = wss.rooms.Enter(room)
+		if err != nil {
+			log.Printf("Error entering room: %s, clientID: %s: %s", room, clientID, err)
+			wss.RejectEnter(w, r, room, err)
+			return
+		}
+		defer func() {
+			log.Printf("wss.rooms.Exit room: %s, clientID: %s", room, clientID)
+			wss.rooms.Exit(room)
+		}()
+	}
+
+	roomMetadata, err := adapter.RoomMetadata()
+	if err != nil {
+		log.Printf("Error retrieving metadata of room: %s: %s", room, err)
+	}
+	if roomMetadata.Locked {
+		log.Printf("Rejecting clientID: %s, ip: %s from locked room: %s", clientID, clientIP, room)
+		http.Error(w, ErrRoomLocked.Error(), http.StatusForbidden)
+		return
+	}
+
+	passwordHash, err := adapter.RoomPassword()
+	if err != nil {
+		log.Printf("Error retrieving password of room: %s: %s", room, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	// clients that do not supply the password in the URL send it in a join
+	// message after connecting
+	password := r.URL.Query().Get("password")
+	readJoin := passwordHash != "" && password == ""
+	if passwordHash != "" && wss.passwords.Blocked(clientIP, room) {
+		log.Printf("Rejecting clientID: %s, ip: %s from room: %s: %s", clientID, clientIP, room, ErrTooManyPasswordAttempts)
+		http.Error(w, ErrTooManyPasswordAttempts.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if passwordHash != "" && !readJoin {
+		if !wsadapter.CheckRoomPassword(passwordHash, password) {
+			log.Printf("Rejecting clientID: %s, ip: %s with invalid password from room: %s", clientID, clientIP, room)
+			wss.passwords.Failed(clientIP, room)
+			http.Error(w, ErrInvalidRoomPassword.Error(), http.StatusForbidden)
+			return
+		}
+		wss.passwords.Succeeded(clientIP, room)
+	}
+
+	assignClientID := wss.config.ClientIDMode == config.ClientIDModeServer && authClientID == ""
+	if assignClientID {
+		clientID, err = wss.assignClientID(adapter)
+		if err != nil {
+			log.Printf("Error assigning client ID in room: %s: %s", room, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	subprotocols, clientParams := serializerParams(wss.serializer)
+
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		CompressionMode: websocket.CompressionDisabled,
+		CompressionMode:      compressionMode(wss.config.Compression),
+		CompressionThreshold: wss.config.CompressionThreshold,
+		Subprotocols:         subprotocols,
 	})
 	if err != nil {
 		log.Printf("Error accepting websocket connection: %s", err)
 		return
 	}
 
-	clientID := path.Base(r.URL.Path)
-	room := path.Base(path.Dir(r.URL.Path))
+	protocol := c.Subprotocol()
+	if protocol == "" {
+		// clients that do not advertise any subprotocols only support JSON
+		if r.Header.Get("Sec-WebSocket-Protocol") != "" || clientParams.MessageType != websocket.MessageText {
+			log.Printf("No compatible protocol version for room: %s, clientID: %s", room, clientID)
+			c.Close(websocket.StatusPolicyViolation, "No compatible protocol version")
+			return
+		}
+		// clients that do not advertise any subprotocols predate versioning
+		protocol = ws.ProtocolVersion1
+	}
+
+	if readLimit := wss.config.ReadLimit; readLimit > 0 {
+		c.SetReadLimit(int64(readLimit))
+	}
 
 	defer func() {
 		log.Printf("Closing websocket connection room: %s, clientID: %s", room, clientID)
 		c.Close(websocket.StatusInternalError, "")
 	}()
 	ctx := r.Context()
+	if correlationID != "" {
+		ctx = logger.WithCorrelationID(ctx, correlationID)
+	}
 
-	client := ws.NewClientWithID(c, clientID)
+	usage := wss.quota.Open(room, clientID)
+	defer usage.Close()
+
+	clientParams.ID = clientID
+	clientParams.SendQueueSize = wss.config.SendQueueSize
+	clientParams.OverflowPolicy = overflowPolicy(wss.config.SendQueueOverflowPolicy)
+	clientParams.ByteCounter = usage
+	clientParams.MaxFrameRate = wss.config.MaxFrameRate
+	client := ws.NewClientWithParams(c, clientParams)
+	client.SetProtocol(protocol)
+	client.SetMetadata(metadata)
 	defer client.Close()
-	log.Printf("New websocket connection - room: %s, clientID: %s", room, clientID)
+	log.Printf("New websocket connection - room: %s, clientID: %s, ip: %s, protocol: %s", room, clientID, clientIP, protocol)
 
-	adapter := wss.rooms.Enter(room)
-	defer func() {
-		log.Printf("wss.rooms.Exit room: %s, clientID: %s", room, clientID)
-		wss.rooms.Exit(room)
-	}()
-	err = adapter.Add(client)
+	if metadataErr := wss.checkJoinMetadata(metadata, metadataFromURL); metadataErr != nil {
+		log.Printf("Rejecting clientID: %s, ip: %s from room: %s: %s", clientID, clientIP, room, metadataErr)
+		err := client.WriteTimeout(ctx, time.Second, wsmessage.NewMessageError(room, MetadataErrorCode(metadataErr), metadataErr.Error()))
+		if err != nil {
+			log.Printf("Error sending metadata error to clientID: %s: %s", clientID, err)
+		}
+		c.Close(websocket.StatusPolicyViolation, metadataErr.Error())
+		return
+	}
+
+	if readJoin {
+		if err := readJoinPassword(ctx, client, passwordHash); err != nil {
+			log.Printf("Rejecting clientID: %s, ip: %s from room: %s: %s", clientID, clientIP, room, err)
+			wss.passwords.Failed(clientIP, room)
+			err := client.WriteTimeout(ctx, time.Second, wsmessage.NewMessageError(room, wsmessage.ErrorCodeInvalidPassword, ErrInvalidRoomPassword.Error()))
+			if err != nil {
+				log.Printf("Error sending invalid password error to clientID: %s: %s", clientID, err)
+			}
+			c.Close(websocket.StatusPolicyViolation, ErrInvalidRoomPassword.Error())
+			return
+		}
+		wss.passwords.Succeeded(clientIP, room)
+	}
+
+	if assignClientID {
+		err = client.WriteTimeout(ctx, 5*time.Second, wsmessage.NewMessageClientID(room, clientID))
+		if err != nil {
+			log.Printf("Error sending assigned client ID: %s", err)
+			return
+		}
+	}
+
+	roomEnding := newRoomEnding()
+	err = adapter.Add(newEndingClient(client, roomEnding))
 	if err != nil {
 		log.Printf("Error adding client to room: %s", err)
 		return
 	}
 
-	if cleanup != nil {
-		defer cleanup(CleanupEvent{
-			ClientID: clientID,
-			Room:     room,
-			Adapter:  adapter,
+	// the first client to join can set the room password
+	ownerID, err := adapter.Owner()
+	if err != nil {
+		log.Printf("Error retrieving owner of room: %s: %s", room, err)
+	}
+	owner := ownerID == clientID
+
+	// the snapshot is taken after adding the client so that clients joining
+	// later are announced by their join messages
+	clients, err := adapter.Clients()
+	if err != nil {
+		log.Printf("Error retrieving clients of room: %s: %s", room, err)
+	} else {
+		err = adapter.Emit(clientID, wsmessage.NewMessageRoomState(room, clients, roomMetadata.Locked, roomMetadata.Topic))
+		if err != nil {
+			log.Printf("Error sending room state to clientID: %s: %s", clientID, err)
+		}
+	}
+	wss.webhooks.Dispatch(webhook.Event{
+		Type:     webhook.EventTypeRoomJoin,
+		Room:     room,
+		ClientID: clientID,
+		Metadata: metadata,
+	})
+
+	if roomMetadata != (wsadapter.RoomMetadata{}) {
+		err = adapter.Emit(clientID, wsmessage.NewMessageRoomMetadata(room, roomMetadata))
+		if err != nil {
+			log.Printf("Error sending room metadata to clientID: %s: %s", clientID, err)
+		}
+	}
+
+	welcomeMessage := wss.config.WelcomeMessage
+	if roomMetadata.WelcomeMessage != "" {
+		welcomeMessage = roomMetadata.WelcomeMessage
+	}
+	if welcomeMessage != "" {
+		err = adapter.Emit(clientID, wsmessage.NewMessageNotice(room, welcomeMessage))
+		if err != nil {
+			log.Printf("Error sending welcome message to clientID: %s: %s", clientID, err)
+		}
+	}
+
+	leaveReason := wsmessage.LeaveReasonLeft
+	// runs before connections are closed by the server, or after the client
+	// has left and has been removed from the room
+	var cleanupOnce sync.Once
+	runCleanup := func(reason string) {
+		if cleanup == nil {
+			return
+		}
+		cleanupOnce.Do(func() {
+			cleanup(CleanupEvent{
+				ClientID: clientID,
+				Room:     room,
+				Adapter:  adapter,
+				Reason:   reason,
+				Client:   client,
+			})
 		})
 	}
+	defer func() {
+		runCleanup(leaveReason)
+	}()
 
 	defer func() {
-		log.Printf("adapter.Remove room: %s, clientID: %s", room, clientID)
-		err := adapter.Remove(clientID)
+		if roomEnding.isReplaced() {
+			// the client ID now belongs to the connection that replaced this one
+			log.Printf("Not removing replaced clientID: %s from room: %s", clientID, room)
+			return
+		}
+		log.Printf("adapter.Remove room: %s, clientID: %s, reason: %s", room, clientID, leaveReason)
+		err := adapter.RemoveWithMessage(clientID, wsmessage.NewMessageRoomLeaveWithReason(room, clientID, leaveReason))
 		if err != nil {
 			log.Printf("Error removing client from adapter: %s", err)
 		}
+		wss.dispatchLeave(room, clientID, metadata, leaveReason)
 	}()
 
+	roomClosed := wss.rooms.Closed(room)
+	subscribeDone := make(chan struct{})
+	go func() {
+		var msg wsmessage.Message
+		status, reason := websocket.StatusNormalClosure, "Room closed"
+		closeLeaveReason := wsmessage.LeaveReasonRoomClosed
+		select {
+		case <-roomClosed:
+			msg = wsmessage.NewMessageNotice(room, "The room has reached its maximum duration and is closing")
+		case <-roomEnding.done:
+			msg = roomEnding.message
+			if roomEnding.replaced {
+				msg = wsmessage.NewMessageError(room, wsmessage.ErrorCodeReplaced, "Replaced by another connection")
+				reason = "Replaced by another connection"
+				closeLeaveReason = wsmessage.LeaveReasonReplaced
+			} else if msg.Type == wsmessage.MessageTypeRoomRedirect {
+				closeLeaveReason = wsmessage.LeaveReasonRedirected
+			}
+		case <-usage.Exceeded():
+			msg = wsmessage.NewMessageError(room, wsmessage.ErrorCodeQuotaExceeded, "Quota exceeded")
+			status, reason = websocket.StatusPolicyViolation, "Quota exceeded"
+			closeLeaveReason = wsmessage.LeaveReasonKicked
+		case <-wss.shutdown:
+			msg = wsmessage.NewMessageNotice(room, "The server is shutting down")
+			status, reason = websocket.StatusGoingAway, "Server shutting down"
+			closeLeaveReason = wsmessage.LeaveReasonShutdown
+		case <-subscribeDone:
+			return
+		}
+		log.Printf("Closing connection room: %s, clientID: %s, reason: %s", room, clientID, reason)
+		runCleanup(closeLeaveReason)
+		if err := client.WriteTimeout(ctx, time.Second, msg); err != nil {
+			log.Printf("Error sending closing message to clientID: %s: %s", clientID, err)
+		}
+		c.Close(status, reason)
+	}()
+
+	rateLimiter, dropLimiter := wss.newRateLimiters()
+	rateLimited := false
+	rejected := false
+
 	err = client.Subscribe(ctx, func(message wsmessage.Message) {
+		if rateLimiter != nil && !rateLimiter.Allow(wss.rateLimitKey(message.Type)) {
+			log.Printf("Rate limit exceeded, dropping message type: %s, room: %s, clientID: %s", message.Type, room, clientID)
+			if dropLimiter != nil && !dropLimiter.Allow("dropped") && !rateLimited {
+				log.Printf("Closing rate limited connection room: %s, clientID: %s, ip: %s", room, clientID, clientIP)
+				rateLimited = true
+				runCleanup(wsmessage.LeaveReasonKicked)
+				err := client.WriteTimeout(ctx, time.Second, wsmessage.NewMessageError(room, wsmessage.ErrorCodeRateLimited, "Rate limit exceeded"))
+				if err != nil {
+					log.Printf("Error sending rate limit error to clientID: %s: %s", clientID, err)
+				}
+				// closing waits for the close frame from the client, which is read
+				// by the subscription, so it cannot block here
+				go c.Close(websocket.StatusPolicyViolation, "Rate limit exceeded")
+			}
+			return
+		}
+		if wss.payloadLog != nil {
+			wss.payloadLog.Log(room, clientID, message)
+		}
+		if !wss.isKnownMessageType(message.Type) {
+			switch wss.config.UnknownMessagePolicy {
+			case config.UnknownMessagePolicyLog:
+				log.Printf("Ignoring unknown message type: %s, room: %s, clientID: %s", message.Type, room, clientID)
+			case config.UnknownMessagePolicyReject:
+				if rejected {
+					return
+				}
+				log.Printf("Closing connection after unknown message type: %s, room: %s, clientID: %s, ip: %s", message.Type, room, clientID, clientIP)
+				rejected = true
+				runCleanup(wsmessage.LeaveReasonKicked)
+				err := client.WriteTimeout(ctx, time.Second, wsmessage.NewMessageError(room, wsmessage.ErrorCodeUnknownMessageType, "Unknown message type: "+message.Type))
+				if err != nil {
+					log.Printf("Error sending unknown message type error to clientID: %s: %s", clientID, err)
+				}
+				go c.Close(websocket.StatusPolicyViolation, "Unknown message type")
+			}
+			return
+		}
+		if message.Type == wsmessage.MessageTypeSubscribe {
+			messageTypes, ok := wsmessage.SubscribeMessageTypes(message)
+			if !ok {
+				log.Printf("Invalid subscribe message, room: %s, clientID: %s", room, clientID)
+				return
+			}
+			log.Printf("Subscribing to message types: %v, room: %s, clientID: %s", messageTypes, room, clientID)
+			client.SetMessageTypes(messageTypes)
+			return
+		}
+		if message.Type == wsmessage.MessageTypeCompression {
+			enabled, ok := wsmessage.CompressionEnabled(message)
+			if !ok {
+				log.Printf("Invalid compression message, room: %s, clientID: %s", room, clientID)
+				return
+			}
+			log.Printf("Setting compression: %t, room: %s, clientID: %s", enabled, room, clientID)
+			client.SetCompression(enabled)
+			return
+		}
+		switch message.Type {
+		case wsmessage.MessageTypeJoin:
+			// the password was already checked
+			return
+		case wsmessage.MessageTypeSetRoomPassword:
+			wss.setRoomPassword(adapter, room, clientID, owner, message)
+			return
+		}
 		handleMessage(RoomEvent{
 			ClientID: clientID,
+			ClientIP: clientIP,
 			Room:     room,
+			Protocol: protocol,
 			Adapter:  adapter,
 			Message:  message,
+			Context:  ctx,
 		})
 	})
 
+	close(subscribeDone)
+	flooding := errors.Is(err, ws.ErrFrameRateExceeded)
+	leaveReason = getLeaveReason(err, rateLimited || rejected || flooding)
+
+	select {
+	case <-roomClosed:
+		leaveReason = wsmessage.LeaveReasonRoomClosed
+		return
+	case <-roomEnding.done:
+		if roomEnding.replaced {
+			leaveReason = wsmessage.LeaveReasonReplaced
+			return
+		}
+		leaveReason = wsmessage.LeaveReasonRoomClosed
+		if roomEnding.message.Type == wsmessage.MessageTypeRoomRedirect {
+			leaveReason = wsmessage.LeaveReasonRedirected
+		}
+		return
+	case <-usage.Exceeded():
+		leaveReason = wsmessage.LeaveReasonKicked
+		return
+	case <-wss.shutdown:
+		leaveReason = wsmessage.LeaveReasonShutdown
+		return
+	default:
+	}
+
+	if rateLimited || rejected {
+		return
+	}
+	if flooding {
+		log.Printf("Closing flooding connection room: %s, clientID: %s, ip: %s", room, clientID, clientIP)
+		runCleanup(leaveReason)
+		c.Close(websocket.StatusPolicyViolation, "Frame rate exceeded")
+		return
+	}
+	if errors.Is(err, ws.ErrSendQueueFull) {
+		log.Printf("Closing slow connection room: %s, clientID: %s", room, clientID)
+		runCleanup(leaveReason)
+		c.Close(websocket.StatusTryAgainLater, "Send queue full")
+		return
+	}
 	if errors.Is(err, context.Canceled) {
 		return
 	}
@@ -116,3 +753,189 @@ func (wss *WSS) HandleRoomWithCleanup(w http.ResponseWriter, r *http.Request, ha
 		log.Printf("Subscription error: %s", err)
 	}
 }
+
+// Closes the connections of all clients with a notice and waits until the
+// clients have left or ctx is done. New connections are rejected.
+func (wss *WSS) Shutdown(ctx context.Context) error {
+	wss.shutdownMu.Lock()
+	select {
+	case <-wss.shutdown:
+	default:
+		close(wss.shutdown)
+	}
+	wss.shutdownMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wss.connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns false when the server is shutting down. Otherwise the connection
+// is tracked until connections.Done is called.
+func (wss *WSS) addConnection() bool {
+	wss.shutdownMu.Lock()
+	defer wss.shutdownMu.Unlock()
+
+	select {
+	case <-wss.shutdown:
+		return false
+	default:
+	}
+	wss.connections.Add(1)
+	return true
+}
+
+// Notifies webhooks that the client left. The room_destroyed event is
+// dispatched by the room manager once the room is closed.
+func (wss *WSS) dispatchLeave(room string, clientID string, metadata string, reason string) {
+	wss.webhooks.Dispatch(webhook.Event{
+		Type:     webhook.EventTypeRoomLeave,
+		Room:     room,
+		ClientID: clientID,
+		Metadata: metadata,
+		Reason:   reason,
+	})
+}
+
+// Waits for the join message of a client connecting to a room with a
+// password and checks the password in it.
+func readJoinPassword(ctx context.Context, client *ws.Client, passwordHash string) error {
+	message, err := client.ReadTimeout(ctx, joinTimeout)
+	if err != nil {
+		return fmt.Errorf("Error reading join message: %w", err)
+	}
+	password, ok := wsmessage.RoomPassword(message)
+	if !ok || message.Type != wsmessage.MessageTypeJoin || !wsadapter.CheckRoomPassword(passwordHash, password) {
+		return ErrInvalidRoomPassword
+	}
+	return nil
+}
+
+// Sets the room password when room passwords are enabled and the client was
+// the first to join the room. The server generates a password when the
+// message has none. The password is sent back to the client.
+func (wss *WSS) setRoomPassword(adapter wsadapter.Adapter, room string, clientID string, owner bool, message wsmessage.Message) {
+	if !wss.config.RoomPasswords || !owner {
+		log.Printf("Rejecting room password from clientID: %s, room: %s", clientID, room)
+		err := adapter.Emit(clientID, wsmessage.NewMessageError(room, wsmessage.ErrorCodeForbidden, "Only the first participant can set the room password"))
+		if err != nil {
+			log.Printf("Error sending forbidden error to clientID: %s: %s", clientID, err)
+		}
+		return
+	}
+
+	password, ok := wsmessage.RoomPassword(message)
+	if !ok {
+		log.Printf("Invalid set room password message, room: %s, clientID: %s", room, clientID)
+		return
+	}
+	if password == "" {
+		password = wsadapter.NewRoomPassword()
+	}
+
+	hash, err := wsadapter.HashRoomPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password of room: %s: %s", room, err)
+		return
+	}
+	if err := adapter.SetRoomPassword(hash); err != nil {
+		log.Printf("Error setting password of room: %s: %s", room, err)
+		return
+	}
+	log.Printf("Room password set by clientID: %s, room: %s", clientID, room)
+
+	if err := adapter.Emit(clientID, wsmessage.NewMessageRoomPassword(room, password)); err != nil {
+		log.Printf("Error sending room password to clientID: %s: %s", clientID, err)
+	}
+}
+
+// Generates a random client ID that is not used by any other client in the
+// room.
+func (wss *WSS) assignClientID(adapter wsadapter.Adapter) (string, error) {
+	clients, err := adapter.Clients()
+	if err != nil {
+		return "", fmt.Errorf("Error retrieving clients: %w", err)
+	}
+	for i := 0; i < maxClientIDAttempts; i++ {
+		clientID := wss.newClientID()
+		if _, ok := clients[clientID]; !ok {
+			return clientID, nil
+		}
+	}
+	return "", ErrClientIDCollision
+}
+
+// Returns the time other clients should wait for a client whose peer
+// connection failed to reconnect, or zero when no hint should be sent.
+func (wss *WSS) ReconnectWindow() time.Duration {
+	return wss.config.ReconnectWindow
+}
+
+// Returns true when the room handler handles messages of type typ.
+func (wss *WSS) isKnownMessageType(typ string) bool {
+	if wss.messageTypes == nil {
+		return true
+	}
+	_, ok := wss.messageTypes[typ]
+	return ok
+}
+
+// Returns the rate limiter bucket of messages of type typ. Types are chosen
+// by clients, so only the types handled by the room handler get a bucket of
+// their own and all other types share one. Otherwise, a client could bypass
+// the limit and grow the buckets without bound by sending a new type with
+// every message.
+func (wss *WSS) rateLimitKey(typ string) string {
+	if wss.messageTypes == nil {
+		return ""
+	}
+	if _, ok := wss.messageTypes[typ]; !ok {
+		return ""
+	}
+	return typ
+}
+
+// Determines why a client left from the error its subscription ended with.
+func getLeaveReason(err error, kicked bool) string {
+	switch {
+	case kicked:
+		return wsmessage.LeaveReasonKicked
+	case err == nil:
+		return wsmessage.LeaveReasonLeft
+	case errors.Is(err, context.DeadlineExceeded):
+		return wsmessage.LeaveReasonTimeout
+	case websocket.CloseStatus(err) == websocket.StatusNormalClosure,
+		websocket.CloseStatus(err) == websocket.StatusGoingAway:
+		return wsmessage.LeaveReasonLeft
+	default:
+		return wsmessage.LeaveReasonDisconnected
+	}
+}
+
+// Returns nil limiters when rate limiting is disabled. The second limiter
+// tracks dropped messages and is nil when clients should never be
+// disconnected.
+func (wss *WSS) newRateLimiters() (rateLimiter *ws.RateLimiter, dropLimiter *ws.RateLimiter) {
+	rate := wss.config.RateLimit
+	if rate <= 0 {
+		return
+	}
+	burst := wss.config.RateLimitBurst
+	if burst <= 0 {
+		burst = rate
+	}
+	rateLimiter = ws.NewRateLimiter(float64(rate), burst)
+	if maxDropped := wss.config.RateLimitMaxDropped; maxDropped > 0 {
+		dropLimiter = ws.NewRateLimiter(float64(rate), maxDropped)
+	}
+	return
+}
@@ -0,0 +1,1346 @@
+package wshandler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeremija/peer-calls/src/server/basen"
+	"github.com/jeremija/peer-calls/src/server/config"
+	"github.com/jeremija/peer-calls/src/server/quota"
+	"github.com/jeremija/peer-calls/src/server/room"
+	"github.com/jeremija/peer-calls/src/server/webhook"
+	"github.com/jeremija/peer-calls/src/server/ws"
+	"github.com/jeremija/peer-calls/src/server/ws/wsadapter"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmemory"
+	"github.com/jeremija/peer-calls/src/server/ws/wsmessage"
+	"github.com/jeremija/peer-calls/src/server/wshandler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+const roomName = "test-room"
+
+var serializer wsmessage.ByteSerializer
+
+func newAdapter(room string) wsadapter.Adapter {
+	return wsmemory.NewMemoryAdapter(room)
+}
+
+func setupServer(t *testing.T, c config.WSConfig) (server *httptest.Server, url string) {
+	return setupServerWithHandler(t, c, func(event wshandler.RoomEvent) {})
+}
+
+func setupServerWithHandler(t *testing.T, c config.WSConfig, handleMessage func(wshandler.RoomEvent)) (server *httptest.Server, url string) {
+	rooms := room.NewRoomManager(newAdapter)
+	wss := wshandler.NewWSS(rooms, c)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, handleMessage)
+	}))
+	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	return
+}
+
+func mustDialWS(t *testing.T, ctx context.Context, url string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.Dial(ctx, url, nil)
+	require.Nil(t, err)
+	return ws
+}
+
+func mustWriteWS(t *testing.T, ctx context.Context, ws *websocket.Conn, msg wsmessage.Message) {
+	t.Helper()
+	data, err := serializer.Serialize(msg)
+	require.Nil(t, err, "Error serializing message")
+	err = ws.Write(ctx, websocket.MessageText, data)
+	require.Nil(t, err, "Error writing message")
+}
+
+func mustReadWS(t *testing.T, ctx context.Context, ws *websocket.Conn) wsmessage.Message {
+	t.Helper()
+	messageType, data, err := ws.Read(ctx)
+	require.Nil(t, err, "Error reading message")
+	require.Equal(t, websocket.MessageText, messageType, "Expected to read text message")
+	msg, err := serializer.Deserialize(data)
+	require.Nil(t, err, "Error deserializing message")
+	return msg
+}
+
+// Reads the join message of the client and the room state sent to it.
+func mustReadJoin(t *testing.T, ctx context.Context, ws *websocket.Conn) wsmessage.Message {
+	t.Helper()
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, ws).Type)
+	msg := mustReadWS(t, ctx, ws)
+	assert.Equal(t, wsmessage.MessageTypeRoomState, msg.Type)
+	return msg
+}
+
+func assertNoMessage(t *testing.T, ws *websocket.Conn) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, data, err := ws.Read(ctx)
+	assert.NotNil(t, err, "Expected no message, but got: %s", data)
+}
+
+func TestWSS_welcomeMessage(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		WelcomeMessage: "welcome",
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+	assert.Equal(t, wsmessage.NewMessageNotice(roomName, "welcome"), mustReadWS(t, ctx, ws1))
+
+	ws2 := mustDialWS(t, ctx, url+"client2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws2)
+	assert.Equal(t, wsmessage.NewMessageNotice(roomName, "welcome"), mustReadWS(t, ctx, ws2))
+
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, ws1).Type)
+	assertNoMessage(t, ws1)
+}
+
+func TestWSS_welcomeMessage_empty(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+	assertNoMessage(t, ws1)
+}
+
+func TestWSS_protocolVersion(t *testing.T) {
+	for _, tc := range []struct {
+		subprotocols []string
+		selected     string
+		protocol     string
+	}{
+		{nil, "", ws.ProtocolVersion1},
+		{[]string{ws.ProtocolVersion1}, ws.ProtocolVersion1, ws.ProtocolVersion1},
+		{[]string{ws.ProtocolVersion2}, ws.ProtocolVersion2, ws.ProtocolVersion2},
+		{[]string{ws.ProtocolVersion1, ws.ProtocolVersion2}, ws.ProtocolVersion2, ws.ProtocolVersion2},
+		{[]string{"peercalls.v3", ws.ProtocolVersion1}, ws.ProtocolVersion1, ws.ProtocolVersion1},
+	} {
+		t.Run(strings.Join(tc.subprotocols, ","), func(t *testing.T) {
+			events := make(chan wshandler.RoomEvent, 1)
+			server, url := setupServerWithHandler(t, config.WSConfig{}, func(event wshandler.RoomEvent) {
+				events <- event
+			})
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			conn, _, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+				Subprotocols: tc.subprotocols,
+			})
+			require.Nil(t, err)
+			defer conn.Close(websocket.StatusNormalClosure, "")
+			assert.Equal(t, tc.selected, conn.Subprotocol())
+
+			mustWriteWS(t, ctx, conn, wsmessage.NewMessage("test", roomName, nil))
+			event := <-events
+			assert.Equal(t, tc.protocol, event.Protocol)
+		})
+	}
+}
+
+func TestWSS_protocolVersion_noMatch(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+		Subprotocols: []string{"peercalls.v3"},
+	})
+	require.Nil(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, "", conn.Subprotocol())
+	_, _, err = conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+}
+
+func setupSerializerServer(t *testing.T, serializer config.SerializerType) (*httptest.Server, string) {
+	t.Helper()
+	wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+		Rooms:      room.NewRoomManager(newAdapter),
+		Serializer: serializer,
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(wshandler.RoomEvent) {})
+	}))
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	return server, url
+}
+
+func TestWSS_serializer_protobuf(t *testing.T) {
+	server, url := setupSerializerServer(t, config.SerializerTypeProtobuf)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+		Subprotocols: []string{ws.ProtocolVersion2Protobuf},
+	})
+	require.Nil(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, ws.ProtocolVersion2Protobuf, conn.Subprotocol())
+
+	var protoSerializer wsmessage.ProtoSerializer
+	typ, data, err := conn.Read(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, websocket.MessageBinary, typ)
+	msg, err := protoSerializer.Deserialize(data)
+	require.Nil(t, err)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, msg.Type)
+	assert.Equal(t, roomName, msg.Room)
+}
+
+func TestWSS_serializer_binary(t *testing.T) {
+	server, url := setupSerializerServer(t, config.SerializerTypeBinary)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+		Subprotocols: []string{ws.ProtocolVersion2Binary},
+	})
+	require.Nil(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, ws.ProtocolVersion2Binary, conn.Subprotocol())
+
+	serializer := wsmessage.CompressingSerializer{Serializer: wsmessage.ByteSerializer{}}
+	typ, data, err := conn.Read(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, websocket.MessageBinary, typ)
+	msg, err := serializer.Deserialize(data)
+	require.Nil(t, err)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, msg.Type)
+	assert.Equal(t, roomName, msg.Room)
+}
+
+func TestWSS_serializer_protobuf_noMatch(t *testing.T) {
+	server, url := setupSerializerServer(t, config.SerializerTypeProtobuf)
+	defer server.Close()
+
+	for _, subprotocols := range [][]string{
+		nil,
+		ws.Subprotocols,
+	} {
+		t.Run(strings.Join(subprotocols, ","), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			conn, _, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+				Subprotocols: subprotocols,
+			})
+			require.Nil(t, err)
+			defer conn.Close(websocket.StatusNormalClosure, "")
+			assert.Equal(t, "", conn.Subprotocol())
+			_, _, err = conn.Read(ctx)
+			assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+		})
+	}
+}
+
+type fullRoomManager struct{}
+
+func (fullRoomManager) Enter(room string) (wsadapter.Adapter, error) {
+	return nil, errors.New("full")
+}
+
+func (fullRoomManager) Exit(room string) {}
+
+func (fullRoomManager) Closed(room string) <-chan struct{} {
+	return nil
+}
+
+func TestWSS_enterError(t *testing.T) {
+	wss := wshandler.NewWSS(fullRoomManager{}, config.WSConfig{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/client1"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, res, err := websocket.Dial(ctx, url, nil)
+	require.NotNil(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
+func TestWSS_rateLimit(t *testing.T) {
+	events := make(chan wshandler.RoomEvent, 10)
+	server, url := setupServerWithMessageTypes(t, config.WSConfig{
+		RateLimit:      1,
+		RateLimitBurst: 2,
+	}, []string{"test", "other"}, func(event wshandler.RoomEvent) {
+		events <- event
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	for i := 0; i < 5; i++ {
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage("test", roomName, i))
+	}
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage("other", roomName, nil))
+
+	assert.Equal(t, float64(0), (<-events).Message.Payload)
+	assert.Equal(t, float64(1), (<-events).Message.Payload)
+	assert.Equal(t, "other", (<-events).Message.Type)
+	select {
+	case event := <-events:
+		assert.Fail(t, "unexpected event", "%v", event.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWSS_rateLimit_unknownTypes(t *testing.T) {
+	events := make(chan wshandler.RoomEvent, 10)
+	server, url := setupServerWithHandler(t, config.WSConfig{
+		RateLimit:      1,
+		RateLimitBurst: 2,
+	}, func(event wshandler.RoomEvent) {
+		events <- event
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	for i := 0; i < 5; i++ {
+		typ := "random-" + basen.NewUUIDBase62()
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage(typ, roomName, i))
+	}
+
+	assert.Equal(t, float64(0), (<-events).Message.Payload)
+	assert.Equal(t, float64(1), (<-events).Message.Payload)
+	select {
+	case event := <-events:
+		assert.Fail(t, "unexpected event", "%v", event.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWSS_rateLimit_close(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RateLimit:           1,
+		RateLimitBurst:      1,
+		RateLimitMaxDropped: 2,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn)
+	for i := 0; i < 4; i++ {
+		mustWriteWS(t, ctx, conn, wsmessage.NewMessage("test", roomName, i))
+	}
+	msg := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, wsmessage.ErrorCodeRateLimited, msg.Payload.(map[string]interface{})["code"])
+	_, _, err := conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+}
+
+func TestWSS_maxFrameRate(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		MaxFrameRate: 5,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn)
+
+	data, err := serializer.Serialize(wsmessage.NewMessage("test", roomName, nil))
+	require.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		// frames of ignored types count towards the limit too
+		typ := websocket.MessageText
+		if i%2 == 1 {
+			typ = websocket.MessageBinary
+		}
+		if err := conn.Write(ctx, typ, data); err != nil {
+			break
+		}
+	}
+
+	for {
+		_, _, err := conn.Read(ctx)
+		if err != nil {
+			assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+			break
+		}
+	}
+}
+
+func setupServerWithMessageTypes(t *testing.T, c config.WSConfig, messageTypes []string, handleMessage func(wshandler.RoomEvent)) (server *httptest.Server, url string) {
+	rooms := room.NewRoomManager(newAdapter)
+	wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+		Rooms:        rooms,
+		Config:       c,
+		MessageTypes: messageTypes,
+	})
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, handleMessage)
+	}))
+	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	return
+}
+
+func TestWSS_unknownMessagePolicy_ignore(t *testing.T) {
+	for _, policy := range []config.UnknownMessagePolicy{
+		"",
+		config.UnknownMessagePolicyIgnore,
+		config.UnknownMessagePolicyLog,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			events := make(chan wshandler.RoomEvent, 2)
+			server, url := setupServerWithMessageTypes(t, config.WSConfig{
+				UnknownMessagePolicy: policy,
+			}, []string{"known"}, func(event wshandler.RoomEvent) {
+				events <- event
+			})
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			conn := mustDialWS(t, ctx, url+"client1")
+			defer conn.Close(websocket.StatusNormalClosure, "")
+			mustReadJoin(t, ctx, conn)
+			mustWriteWS(t, ctx, conn, wsmessage.NewMessage("unknown", roomName, nil))
+			mustWriteWS(t, ctx, conn, wsmessage.NewMessage("known", roomName, nil))
+
+			event := <-events
+			assert.Equal(t, "known", event.Message.Type, "unknown messages should not be handled")
+
+			mustWriteWS(t, ctx, conn, wsmessage.NewMessage("known", roomName, nil))
+			event = <-events
+			assert.Equal(t, "known", event.Message.Type, "connection should stay open")
+		})
+	}
+}
+
+func TestWSS_unknownMessagePolicy_reject(t *testing.T) {
+	events := make(chan wshandler.RoomEvent, 1)
+	server, url := setupServerWithMessageTypes(t, config.WSConfig{
+		UnknownMessagePolicy: config.UnknownMessagePolicyReject,
+	}, []string{"known"}, func(event wshandler.RoomEvent) {
+		events <- event
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn)
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage("known", roomName, nil))
+	assert.Equal(t, "known", (<-events).Message.Type)
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage(wsmessage.MessageTypeSubscribe, roomName, nil))
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage("unknown", roomName, nil))
+
+	msg := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"code":    wsmessage.ErrorCodeUnknownMessageType,
+		"message": "Unknown message type: unknown",
+	}, msg.Payload)
+	_, _, err := conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+	assert.Equal(t, 0, len(events))
+}
+
+func TestWSS_unknownMessagePolicy_allTypesKnown(t *testing.T) {
+	events := make(chan wshandler.RoomEvent, 1)
+	server, url := setupServerWithMessageTypes(t, config.WSConfig{
+		UnknownMessagePolicy: config.UnknownMessagePolicyReject,
+	}, nil, func(event wshandler.RoomEvent) {
+		events <- event
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn)
+	mustWriteWS(t, ctx, conn, wsmessage.NewMessage("anything", roomName, nil))
+
+	assert.Equal(t, "anything", (<-events).Message.Type)
+}
+
+func TestWSS_readLimit(t *testing.T) {
+	for _, tc := range []struct {
+		readLimit int
+		size      int
+		closed    bool
+	}{
+		{0, 30000, false},
+		{0, 40000, true},
+		{65536, 40000, false},
+		{2048, 4096, true},
+	} {
+		t.Run(fmt.Sprintf("%d_%d", tc.readLimit, tc.size), func(t *testing.T) {
+			events := make(chan wshandler.RoomEvent, 1)
+			server, url := setupServerWithHandler(t, config.WSConfig{
+				ReadLimit: tc.readLimit,
+			}, func(event wshandler.RoomEvent) {
+				events <- event
+			})
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			conn := mustDialWS(t, ctx, url+"client1")
+			defer conn.Close(websocket.StatusNormalClosure, "")
+			mustReadJoin(t, ctx, conn)
+			mustWriteWS(t, ctx, conn, wsmessage.NewMessage("test", roomName, strings.Repeat("a", tc.size)))
+
+			if !tc.closed {
+				assert.Equal(t, "test", (<-events).Message.Type)
+				return
+			}
+			_, _, err := conn.Read(ctx)
+			assert.Equal(t, websocket.StatusMessageTooBig, websocket.CloseStatus(err))
+		})
+	}
+}
+
+func TestWSS_quota(t *testing.T) {
+	usage := quota.NewTracker(quota.Params{Bytes: 1000})
+	rooms := room.NewRoomManager(newAdapter)
+	wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+		Rooms: rooms,
+		Quota: usage,
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+	mustReadJoin(t, ctx, conn2)
+
+	mustWriteWS(t, ctx, conn2, wsmessage.NewMessage("test", roomName, "small"))
+	assert.False(t, usage.Usage(roomName)["client2"].Exceeded)
+
+	mustWriteWS(t, ctx, conn2, wsmessage.NewMessage("test", roomName, strings.Repeat("a", 1000)))
+	msg := mustReadWS(t, ctx, conn2)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, wsmessage.ErrorCodeQuotaExceeded, msg.Payload.(map[string]interface{})["code"])
+	_, _, err := conn2.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+
+	assertLeaveReason(t, ctx, conn1, wsmessage.LeaveReasonKicked)
+	assert.False(t, usage.Usage(roomName)["client1"].Exceeded)
+}
+
+func TestWSS_compression(t *testing.T) {
+	for _, tc := range []struct {
+		compression config.Compression
+		extensions  string
+	}{
+		{"", ""},
+		{config.CompressionDisabled, ""},
+		{config.CompressionContextTakeover, "permessage-deflate"},
+		{config.CompressionNoContextTakeover, "permessage-deflate; client_no_context_takeover; server_no_context_takeover"},
+	} {
+		t.Run(string(tc.compression), func(t *testing.T) {
+			server, url := setupServer(t, config.WSConfig{
+				Compression: tc.compression,
+			})
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			conn, res, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+				CompressionMode: websocket.CompressionContextTakeover,
+			})
+			require.Nil(t, err)
+			defer conn.Close(websocket.StatusNormalClosure, "")
+			assert.Equal(t, tc.extensions, res.Header.Get("Sec-WebSocket-Extensions"))
+			mustReadJoin(t, ctx, conn)
+		})
+	}
+}
+
+func assertLeaveReason(t *testing.T, ctx context.Context, conn *websocket.Conn, reason string) {
+	t.Helper()
+	msg := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeRoomLeave, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"clientID": "client2",
+		"reason":   reason,
+	}, msg.Payload)
+}
+
+func TestWSS_leaveReason(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RateLimit:           1,
+		RateLimitBurst:      1,
+		RateLimitMaxDropped: 1,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+
+	t.Run("left", func(t *testing.T) {
+		conn2 := mustDialWS(t, ctx, url+"client2")
+		assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+		conn2.Close(websocket.StatusNormalClosure, "")
+		assertLeaveReason(t, ctx, conn1, wsmessage.LeaveReasonLeft)
+	})
+
+	t.Run("disconnected", func(t *testing.T) {
+		conn2 := mustDialWS(t, ctx, url+"client2")
+		assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+		// cancelling a read drops the connection without a close frame
+		readCtx, readCancel := context.WithCancel(ctx)
+		readCancel()
+		_, _, err := conn2.Read(readCtx)
+		require.NotNil(t, err)
+		assertLeaveReason(t, ctx, conn1, wsmessage.LeaveReasonDisconnected)
+	})
+
+	t.Run("kicked", func(t *testing.T) {
+		conn2 := mustDialWS(t, ctx, url+"client2")
+		defer conn2.Close(websocket.StatusNormalClosure, "")
+		assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+		for i := 0; i < 3; i++ {
+			mustWriteWS(t, ctx, conn2, wsmessage.NewMessage("test", roomName, i))
+		}
+		go func() {
+			// reading responds to the close frame sent by the server
+			for {
+				if _, _, err := conn2.Read(ctx); err != nil {
+					return
+				}
+			}
+		}()
+		assertLeaveReason(t, ctx, conn1, wsmessage.LeaveReasonKicked)
+	})
+}
+
+func TestWSS_clientIDModeServer(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		ClientIDMode: config.ClientIDModeServer,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	msg := mustReadWS(t, ctx, conn1)
+	assert.Equal(t, wsmessage.MessageTypeClientID, msg.Type)
+	clientID1, ok := msg.Payload.(string)
+	require.True(t, ok)
+	assert.NotEqual(t, "client1", clientID1)
+	assert.NotEqual(t, "", clientID1)
+	join := mustReadWS(t, ctx, conn1)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, join.Type)
+	assert.Equal(t, clientID1, join.Payload.(map[string]interface{})["clientID"])
+
+	// the same client-supplied ID is ignored and cannot collide
+	conn2 := mustDialWS(t, ctx, url+"client1")
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	msg = mustReadWS(t, ctx, conn2)
+	assert.Equal(t, wsmessage.MessageTypeClientID, msg.Type)
+	assert.NotEqual(t, clientID1, msg.Payload)
+	assert.NotEqual(t, "client1", msg.Payload)
+}
+
+func TestWSS_clientIDModeClient(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		ClientIDMode: config.ClientIDModeClient,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	join := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, join.Type)
+	assert.Equal(t, "client1", join.Payload.(map[string]interface{})["clientID"])
+}
+
+func assertRejectedMetadata(t *testing.T, ctx context.Context, conn *websocket.Conn, code string) {
+	t.Helper()
+	msg := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, code, msg.Payload.(map[string]interface{})["code"])
+	_, _, err := conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+}
+
+func TestWSS_requireMetadata(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RequireMetadata:   true,
+		MetadataMaxLength: 8,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assertRejectedMetadata(t, ctx, conn, wsmessage.ErrorCodeMetadataRequired)
+
+	conn = mustDialWS(t, ctx, url+"client1?metadata=")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assertRejectedMetadata(t, ctx, conn, wsmessage.ErrorCodeMetadataRequired)
+
+	conn = mustDialWS(t, ctx, url+"client1?metadata=too+long+name")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assertRejectedMetadata(t, ctx, conn, wsmessage.ErrorCodeInvalidMetadata)
+
+	conn = mustDialWS(t, ctx, url+"client1?metadata=Alice")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	join := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, join.Type)
+	assert.Equal(t, "Alice", join.Payload.(map[string]interface{})["metadata"])
+}
+
+func TestWSS_maxRooms(t *testing.T) {
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{MaxRooms: 1})
+	_, err := rooms.Enter("other")
+	require.Nil(t, err)
+	defer rooms.Exit("other")
+
+	wss := wshandler.NewWSS(rooms, config.WSConfig{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/client1"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	msg := mustReadWS(t, ctx, conn)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, wsmessage.ErrorCodeRoomFull, msg.Payload.(map[string]interface{})["code"])
+	_, _, err = conn.Read(ctx)
+	assert.Equal(t, websocket.StatusTryAgainLater, websocket.CloseStatus(err))
+}
+
+func TestWSS_metadataOptional(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn := mustDialWS(t, ctx, url+"client1")
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{
+		"clients": map[string]interface{}{"client1": ""},
+		"locked":  false,
+		"topic":   "",
+	}, mustReadJoin(t, ctx, conn).Payload)
+}
+
+func setupServerWithRoomMetadata(t *testing.T, c config.WSConfig, metadata wsadapter.RoomMetadata) (server *httptest.Server, url string) {
+	store := wsadapter.NewMemoryRoomMetadataStore()
+	require.Nil(t, store.SetRoomMetadata(roomName, metadata))
+	rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+		return wsmemory.NewMemoryAdapterWithParams(room, wsadapter.Params{
+			RoomMetadataStore: store,
+		})
+	})
+	wss := wshandler.NewWSS(rooms, c)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	return
+}
+
+func TestWSS_roomMetadata(t *testing.T) {
+	metadata := wsadapter.RoomMetadata{
+		Topic:          "topic",
+		WelcomeMessage: "room welcome",
+	}
+	server, url := setupServerWithRoomMetadata(t, config.WSConfig{
+		WelcomeMessage: "welcome",
+	}, metadata)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+	msg := mustReadWS(t, ctx, ws1)
+	assert.Equal(t, wsmessage.MessageTypeRoomMetadata, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"locked":         false,
+		"topic":          "topic",
+		"welcomeMessage": "room welcome",
+		"listed":         false,
+	}, msg.Payload)
+	assert.Equal(t, wsmessage.NewMessageNotice(roomName, "room welcome"), mustReadWS(t, ctx, ws1))
+}
+
+func TestWSS_roomMetadata_locked(t *testing.T) {
+	server, url := setupServerWithRoomMetadata(t, config.WSConfig{}, wsadapter.RoomMetadata{
+		Locked: true,
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, res, err := websocket.Dial(ctx, url+"client1", nil)
+	require.NotNil(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestWSS_subscribeMessageTypes(t *testing.T) {
+	server, url := setupServerWithHandler(t, config.WSConfig{}, func(event wshandler.RoomEvent) {
+		assert.Nil(t, event.Adapter.Broadcast(event.Message))
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+
+	mustWriteWS(t, ctx, conn1, wsmessage.NewMessage(wsmessage.MessageTypeSubscribe, roomName, []string{
+		"test", wsmessage.MessageTypeRoomLeave,
+	}))
+	// messages are handled in order, so the subscription is active once this
+	// message is received
+	mustWriteWS(t, ctx, conn1, wsmessage.NewMessage("test", roomName, nil))
+	assert.Equal(t, "test", mustReadWS(t, ctx, conn1).Type)
+
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	mustReadJoin(t, ctx, conn2)
+	conn2.Close(websocket.StatusNormalClosure, "")
+	assertLeaveReason(t, ctx, conn1, wsmessage.LeaveReasonLeft)
+}
+
+func TestWSS_compressionNegotiation(t *testing.T) {
+	server, url := setupServerWithHandler(t, config.WSConfig{}, func(event wshandler.RoomEvent) {
+		assert.Nil(t, event.Adapter.Broadcast(event.Message))
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn2)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+
+	mustWriteWS(t, ctx, conn1, wsmessage.NewMessage(wsmessage.MessageTypeCompression, roomName, map[string]interface{}{
+		"enabled": true,
+	}))
+	mustWriteWS(t, ctx, conn1, wsmessage.NewMessage("test", roomName, "hello"))
+
+	typ, data, err := conn1.Read(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, websocket.MessageBinary, typ)
+	msg, err := wsmessage.CompressingSerializer{Serializer: serializer}.Deserialize(data)
+	require.Nil(t, err)
+	assert.Equal(t, wsmessage.NewMessage("test", roomName, "hello"), msg)
+
+	assert.Equal(t, wsmessage.NewMessage("test", roomName, "hello"), mustReadWS(t, ctx, conn2))
+}
+
+func TestWSS_roomNamePattern(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{
+		RoomNamePattern: "[a-z0-9-]{4,32}",
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	baseURL := strings.TrimSuffix(url, roomName+"/")
+
+	for _, room := range []string{"room", "room-1234", strings.Repeat("a", 32)} {
+		conn, _, err := websocket.Dial(ctx, baseURL+room+"/client1", nil)
+		require.Nil(t, err, "expected room %q to be accepted", room)
+		mustReadJoin(t, ctx, conn)
+		conn.Close(websocket.StatusNormalClosure, "")
+	}
+
+	for _, room := range []string{"abc", "Room", "room_1", strings.Repeat("a", 33), "room%00", "room%2F..", ".."} {
+		_, res, err := websocket.Dial(ctx, baseURL+room+"/client1", nil)
+		require.NotNil(t, err, "expected room %q to be rejected", room)
+		require.NotNil(t, res)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, "room: %q", room)
+	}
+}
+
+func TestWSS_roomName_sanitized(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	baseURL := strings.TrimSuffix(url, roomName+"/")
+
+	for _, room := range []string{"room%00", "room%0A", "room%FF"} {
+		_, res, err := websocket.Dial(ctx, baseURL+room+"/client1", nil)
+		require.NotNil(t, err, "expected room %q to be rejected", room)
+		require.NotNil(t, res)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, "room: %q", room)
+	}
+}
+
+func TestWSS_roomState(t *testing.T) {
+	server, url := setupServerWithRoomMetadata(t, config.WSConfig{}, wsadapter.RoomMetadata{
+		Topic: "topic",
+	})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{
+		"clients": map[string]interface{}{"client1": ""},
+		"locked":  false,
+		"topic":   "topic",
+	}, mustReadJoin(t, ctx, conn1).Payload)
+	assert.Equal(t, wsmessage.MessageTypeRoomMetadata, mustReadWS(t, ctx, conn1).Type)
+
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{
+		"clients": map[string]interface{}{"client1": "", "client2": ""},
+		"locked":  false,
+		"topic":   "topic",
+	}, mustReadJoin(t, ctx, conn2).Payload)
+
+	// existing clients only receive the join message
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+}
+
+func TestWSS_duplicateClientID(t *testing.T) {
+	server, url := setupServer(t, config.WSConfig{})
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	defer conn1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn1)
+
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	defer conn2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, conn2)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, conn1).Type)
+
+	conn3 := mustDialWS(t, ctx, url+"client1")
+	defer conn3.Close(websocket.StatusNormalClosure, "")
+	// the client is already in the room so the join is not broadcast again
+	msg := mustReadWS(t, ctx, conn3)
+	assert.Equal(t, wsmessage.MessageTypeRoomState, msg.Type)
+	assert.Equal(t, map[string]interface{}{"client1": "", "client2": ""}, msg.Payload.(map[string]interface{})["clients"])
+
+	msg = mustReadWS(t, ctx, conn1)
+	assert.Equal(t, wsmessage.MessageTypeError, msg.Type)
+	assert.Equal(t, wsmessage.ErrorCodeReplaced, msg.Payload.(map[string]interface{})["code"])
+	_, _, err := conn1.Read(ctx)
+	assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+
+	// the replaced connection does not leave the room, so the next message
+	// is sent after the connection that replaced it closes
+	conn3.Close(websocket.StatusNormalClosure, "")
+	msg = mustReadWS(t, ctx, conn2)
+	assert.Equal(t, wsmessage.MessageTypeRoomLeave, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"clientID": "client1",
+		"reason":   wsmessage.LeaveReasonLeft,
+	}, msg.Payload)
+}
+
+func TestWSS_roomClosed(t *testing.T) {
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		MaxRoomDuration: 200 * time.Millisecond,
+	})
+	wss := wshandler.NewWSS(rooms, config.WSConfig{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+
+	msg := mustReadWS(t, ctx, ws1)
+	assert.Equal(t, wsmessage.MessageTypeNotice, msg.Type)
+	_, _, err := ws1.Read(ctx)
+	assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+}
+
+func TestWSS_shutdown(t *testing.T) {
+	rooms := room.NewRoomManager(newAdapter)
+	wss := wshandler.NewWSS(rooms, config.WSConfig{})
+	cleanups := make(chan wshandler.CleanupEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoomWithCleanup(w, r, func(event wshandler.RoomEvent) {}, func(event wshandler.CleanupEvent) {
+			// the connection is not closed before the cleanup
+			err := event.Client.WriteTimeout(context.Background(), time.Second, wsmessage.NewMessage("cleanup", event.Room, event.Reason))
+			assert.Nil(t, err)
+			cleanups <- event
+		})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- wss.Shutdown(ctx)
+	}()
+
+	assert.Equal(t, wsmessage.NewMessage("cleanup", roomName, wsmessage.LeaveReasonShutdown), mustReadWS(t, ctx, ws1))
+	assert.Equal(t, wsmessage.NewMessageNotice(roomName, "The server is shutting down"), mustReadWS(t, ctx, ws1))
+	_, _, err := ws1.Read(ctx)
+	assert.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(err))
+	assert.Nil(t, <-shutdown)
+	assert.Equal(t, wsmessage.LeaveReasonShutdown, (<-cleanups).Reason)
+
+	_, res, err := websocket.Dial(ctx, url+"client2", nil)
+	require.NotNil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
+func assertRoomEnded(t *testing.T, ctx context.Context, ws *websocket.Conn) {
+	t.Helper()
+	assert.Equal(t, wsmessage.NewMessageRoomEnd(roomName), mustReadWS(t, ctx, ws))
+	_, _, err := ws.Read(ctx)
+	assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+}
+
+func TestWSS_roomEnd(t *testing.T) {
+	rooms := room.NewRoomManager(newAdapter)
+	wss := wshandler.NewWSS(rooms, config.WSConfig{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+	ws2 := mustDialWS(t, ctx, url+"client2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws2)
+	assert.Equal(t, wsmessage.MessageTypeRoomJoin, mustReadWS(t, ctx, ws1).Type)
+
+	// clients that subscribed to other message types are disconnected too
+	mustWriteWS(t, ctx, ws2, wsmessage.NewMessage(wsmessage.MessageTypeSubscribe, roomName, []string{wsmessage.MessageTypeChat}))
+
+	adapter, err := rooms.Enter(roomName)
+	require.Nil(t, err)
+	defer rooms.Exit(roomName)
+	require.Nil(t, adapter.Broadcast(wsmessage.NewMessageRoomEnd(roomName)))
+	// ending the room again does not affect the clients being disconnected
+	require.Nil(t, adapter.Broadcast(wsmessage.NewMessageRoomEnd(roomName)))
+
+	assertRoomEnded(t, ctx, ws1)
+	assertRoomEnded(t, ctx, ws2)
+}
+
+// Closes closed when the room manager closes the adapter.
+type closeRecordingAdapter struct {
+	*wsmemory.MemoryAdapter
+	closed chan struct{}
+}
+
+func (a closeRecordingAdapter) Close() error {
+	close(a.closed)
+	return a.MemoryAdapter.Close()
+}
+
+// Skips messages until a redirect message is read and asserts that the
+// connection is closed afterwards.
+func assertRoomRedirected(t *testing.T, ctx context.Context, ws *websocket.Conn) {
+	t.Helper()
+	for {
+		msg := mustReadWS(t, ctx, ws)
+		if msg.Type == wsmessage.MessageTypeRoomRedirect {
+			assert.Equal(t, map[string]interface{}{
+				"url":   "https://sfu2.example.com/call/" + roomName,
+				"token": "abc",
+			}, msg.Payload)
+			break
+		}
+	}
+	_, _, err := ws.Read(ctx)
+	assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+}
+
+func TestWSS_roomRedirect(t *testing.T) {
+	adapterClosed := make(chan struct{})
+	rooms := room.NewRoomManager(func(room string) wsadapter.Adapter {
+		return closeRecordingAdapter{wsmemory.NewMemoryAdapter(room), adapterClosed}
+	})
+	wss := wshandler.NewWSS(rooms, config.WSConfig{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, url+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+	ws2 := mustDialWS(t, ctx, url+"client2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws2)
+
+	adapter, err := rooms.Enter(roomName)
+	require.Nil(t, err)
+	redirect := wsmessage.NewMessageRoomRedirect(roomName, "https://sfu2.example.com/call/"+roomName, "abc")
+	require.Nil(t, adapter.Broadcast(redirect))
+	rooms.Exit(roomName)
+
+	assertRoomRedirected(t, ctx, ws1)
+	assertRoomRedirected(t, ctx, ws2)
+
+	select {
+	case <-adapterClosed:
+	case <-ctx.Done():
+		require.Fail(t, "timed out waiting for the adapter of the drained room to be closed")
+	}
+}
+
+// Delivers broadcasts to the adapters of all servers sharing the bus, like
+// Redis does.
+type busAdapter struct {
+	*wsmemory.MemoryAdapter
+	bus *[]wsadapter.Adapter
+}
+
+func (a busAdapter) Broadcast(msg wsmessage.Message) error {
+	for _, adapter := range *a.bus {
+		if err := adapter.(busAdapter).MemoryAdapter.Broadcast(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWSS_roomEnd_crossServer(t *testing.T) {
+	var bus []wsadapter.Adapter
+	var busMu sync.Mutex
+	newBusAdapter := func(room string) wsadapter.Adapter {
+		busMu.Lock()
+		defer busMu.Unlock()
+		adapter := busAdapter{wsmemory.NewMemoryAdapter(room), &bus}
+		bus = append(bus, adapter)
+		return adapter
+	}
+
+	var urls []string
+	var roomManagers []*room.RoomManager
+	for i := 0; i < 2; i++ {
+		rooms := room.NewRoomManager(newBusAdapter)
+		roomManagers = append(roomManagers, rooms)
+		wss := wshandler.NewWSS(rooms, config.WSConfig{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wss.HandleRoom(w, r, func(event wshandler.RoomEvent) {})
+		}))
+		defer server.Close()
+		urls = append(urls, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws/"+roomName+"/")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ws1 := mustDialWS(t, ctx, urls[0]+"client1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws1)
+	ws2 := mustDialWS(t, ctx, urls[1]+"client2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	mustReadJoin(t, ctx, ws2)
+
+	// the room is ended through the first server only
+	adapter, err := roomManagers[0].Enter(roomName)
+	require.Nil(t, err)
+	defer roomManagers[0].Exit(roomName)
+	busMu.Lock()
+	err = adapter.Broadcast(wsmessage.NewMessageRoomEnd(roomName))
+	busMu.Unlock()
+	require.Nil(t, err)
+
+	assertRoomEnded(t, ctx, ws1)
+	assertRoomEnded(t, ctx, ws2)
+}
+
+func TestWSS_clientIP(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		trustedProxies []string
+		clientIP       string
+	}{
+		{"trusted", []string{"127.0.0.0/8", "::1/128"}, "1.2.3.4"},
+		{"untrusted", nil, "127.0.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events := make(chan wshandler.RoomEvent, 1)
+			server, url := setupServerWithHandler(t, config.WSConfig{
+				TrustedProxies: tc.trustedProxies,
+			}, func(event wshandler.RoomEvent) {
+				events <- event
+			})
+			defer server.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			conn, _, err := websocket.Dial(ctx, url+"client1", &websocket.DialOptions{
+				HTTPHeader: http.Header{
+					"X-Forwarded-For": []string{"1.2.3.4"},
+				},
+			})
+			require.Nil(t, err)
+			defer conn.Close(websocket.StatusNormalClosure, "")
+
+			mustWriteWS(t, ctx, conn, wsmessage.NewMessage("test", roomName, nil))
+			event := <-events
+			assert.Equal(t, tc.clientIP, event.ClientIP)
+		})
+	}
+}
+
+func TestWSS_webhooks(t *testing.T) {
+	events := make(chan webhook.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- event
+	}))
+	defer receiver.Close()
+
+	webhooks := webhook.NewDispatcher(webhook.Params{
+		URLs: []string{receiver.URL},
+	})
+	rooms := room.NewRoomManagerWithParams(newAdapter, room.Params{
+		Webhooks: webhooks,
+	})
+	wss := wshandler.NewWSSWithParams(wshandler.WSSParams{
+		Rooms:    rooms,
+		Webhooks: webhooks,
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wss.HandleRoom(w, r, func(wshandler.RoomEvent) {})
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + roomName + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	readEvents := func(n int) []webhook.Event {
+		t.Helper()
+		var received []webhook.Event
+		for i := 0; i < n; i++ {
+			select {
+			case event := <-events:
+				assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+				event.Timestamp = time.Time{}
+				received = append(received, event)
+			case <-ctx.Done():
+				require.Fail(t, "timed out waiting for webhook")
+			}
+		}
+		// events are delivered concurrently
+		sort.Slice(received, func(i, j int) bool {
+			return received[i].Type+webhook.EventType(received[i].ClientID) <
+				received[j].Type+webhook.EventType(received[j].ClientID)
+		})
+		return received
+	}
+
+	conn1 := mustDialWS(t, ctx, url+"client1")
+	mustReadJoin(t, ctx, conn1)
+	assert.Equal(t, []webhook.Event{
+		{Type: webhook.EventTypeRoomCreated, Room: roomName},
+		{Type: webhook.EventTypeRoomJoin, Room: roomName, ClientID: "client1"},
+	}, readEvents(2))
+
+	conn2 := mustDialWS(t, ctx, url+"client2")
+	mustReadJoin(t, ctx, conn2)
+	assert.Equal(t, []webhook.Event{
+		{Type: webhook.EventTypeRoomJoin, Room: roomName, ClientID: "client2"},
+	}, readEvents(1))
+
+	conn2.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, []webhook.Event{
+		{Type: webhook.EventTypeRoomLeave, Room: roomName, ClientID: "client2", Reason: wsmessage.LeaveReasonLeft},
+	}, readEvents(1))
+
+	conn1.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, []webhook.Event{
+		{Type: webhook.EventTypeRoomDestroyed, Room: roomName},
+		{Type: webhook.EventTypeRoomLeave, Room: roomName, ClientID: "client1", Reason: wsmessage.LeaveReasonLeft},
+	}, readEvents(2))
+}
@@ -16,6 +16,10 @@ export interface SocketEvent {
   }
   hangUp: {
     userId: string
+    // set when the peer connection failed and the user is expected to
+    // reconnect within reconnectWindowMs
+    reconnecting?: boolean
+    reconnectWindowMs?: number
   }
   signal: {
     userId: string